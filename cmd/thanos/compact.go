@@ -21,12 +21,14 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/indexheader"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/compact"
+	v1 "github.com/thanos-io/thanos/pkg/compact/api"
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/component"
 	"github.com/thanos-io/thanos/pkg/extflag"
@@ -106,6 +108,14 @@ func registerCompact(m map[string]setupFunc, app *kingpin.Application) {
 	retentionRaw := modelDuration(cmd.Flag("retention.resolution-raw", "How long to retain raw samples in bucket. Setting this to 0d will retain samples of this resolution forever").Default("0d"))
 	retention5m := modelDuration(cmd.Flag("retention.resolution-5m", "How long to retain samples of resolution 1 (5 minutes) in bucket. Setting this to 0d will retain samples of this resolution forever").Default("0d"))
 	retention1h := modelDuration(cmd.Flag("retention.resolution-1h", "How long to retain samples of resolution 2 (1 hour) in bucket. Setting this to 0d will retain samples of this resolution forever").Default("0d"))
+	retentionResolutions := cmd.Flag("retention.resolution", "How long to retain samples of a downsampling level in the <resolution>:<retention> format, e.g. 1m:90d. The resolution must be raw (0s) or one of the configured downsampling levels. Repeat the flag to set the retention of multiple levels. Setting the retention to 0d will retain samples of the resolution forever.").
+		PlaceHolder("<resolution>:<retention>").Strings()
+	retentionConf := extflag.RegisterPathOrContent(cmd, "retention.config",
+		"YAML file that contains retention rules. Rules match external labels of blocks, the first matching rule sets the retention per resolution. "+
+			"Blocks not matching any rule are retained according to --retention.resolution-* flags. See format details: https://thanos.io/components/compact.md/#retention",
+		false)
+	retentionDryRun := cmd.Flag("retention.dry-run", "If true, blocks which would be marked for deletion by retention are only logged.").
+		Default("false").Bool()
 
 	// TODO(kakkoyun): https://github.com/thanos-io/thanos/issues/2266.
 	wait := cmd.Flag("wait", "Do not exit after all compactions have been processed and wait for new work.").
@@ -121,15 +131,39 @@ func registerCompact(m map[string]setupFunc, app *kingpin.Application) {
 		"as querying long time ranges without non-downsampled data is not efficient and useful e.g it is not possible to render all samples for a human eye anyway").
 		Default("false").Bool()
 
+	downsamplingLevels := regDownsamplingLevelsFlag(cmd)
+
 	maxCompactionLevel := cmd.Flag("debug.max-compaction-level", fmt.Sprintf("Maximum compaction level, default is %d: %s", compactions.maxLevel(), compactions.String())).
 		Hidden().Default(strconv.Itoa(compactions.maxLevel())).Int()
 
 	blockSyncConcurrency := cmd.Flag("block-sync-concurrency", "Number of goroutines to use when syncing block metadata from object storage.").
 		Default("20").Int()
 
-	compactionConcurrency := cmd.Flag("compact.concurrency", "Number of goroutines to use when compacting groups.").
+	compactionConcurrency := cmd.Flag("compact.concurrency", "Number of goroutines to use when compacting groups. "+
+		"Downloads, compactions and uploads are run by separate goroutines, so they overlap across groups.").
 		Default("1").Int()
 
+	compactionDiskBudget := cmd.Flag("compact.disk-budget", "Maximum local disk space used by concurrent compactions. "+
+		"Each compaction reserves twice the size of its input blocks before downloading them. A compaction exceeding "+
+		"the whole budget is run alone. 0 means no limit.").
+		Default("0B").Bytes()
+
+	splitMaxSeries := cmd.Flag("compact.split-max-series", "Maximum number of series of a compacted block, estimated as the highest number of series of its input blocks. "+
+		"A compaction exceeding it is split into blocks of the same time range holding shards of the series. 0 means no limit.").
+		Default("0").Uint64()
+
+	splitMaxSize := cmd.Flag("compact.split-max-size", "Maximum size of a compacted block, estimated as the total size of its input blocks. "+
+		"A compaction exceeding it is split into blocks of the same time range holding shards of the series. 0 means no limit.").
+		Default("0B").Bytes()
+
+	repairChunks := cmd.Flag("compact.repair-chunks", "If true, input blocks with out of order or duplicated chunks, which halt the compaction otherwise, are repaired before compaction. "+
+		"Chunks of each series are sorted and overlapping chunks are merged, keeping one sample per timestamp. "+
+		"Original blocks are backed up to the backup bucket configured by --objstore-backup.config or --objstore-backup.config-file. "+
+		"Applies only to raw blocks.").
+		Default("false").Bool()
+
+	objStoreBackupConfig := regCommonObjStoreFlags(cmd, "-backup", false, "Used for backups of blocks repaired by the compactor, see --compact.repair-chunks.")
+
 	deleteDelay := modelDuration(cmd.Flag("delete-delay", "Time before a block marked for deletion is deleted from bucket. "+
 		"If delete-delay is non zero, blocks will be marked for deletion and compactor component will delete blocks marked for deletion from the bucket. "+
 		"If delete-delay is 0, blocks will be deleted straight away. "+
@@ -143,14 +177,41 @@ func registerCompact(m map[string]setupFunc, app *kingpin.Application) {
 		"This works well for deduplication of blocks with **precisely the same samples** like produced by Receiver replication.").
 		Hidden().Strings()
 
+	dedupFunc := cmd.Flag("deduplication.func", "Experimental. Deduplication algorithm for merging overlapping blocks of replicas. "+
+		"Possible values are: \"\", \"penalty\". If no value is specified, the default compaction merge is used, chaining samples together. "+
+		"The \"penalty\" algorithm deduplicates samples the same way the Querier does with its replica labels, which suits data of HA Prometheus pairs. "+
+		"Applies only to raw blocks, downsampled blocks are merged with the default compaction merge.").
+		Default("").Hidden().Enum("", compact.DedupFuncPenalty)
+
 	selectorRelabelConf := regSelectorRelabelFlags(cmd)
 
+	updateBucketIndex := cmd.Flag("bucket-index.update", "If true, compactor writes the bucket index after each compaction run. The bucket index holds the metadata and deletion marks of all the blocks in the bucket, "+
+		"so that other components can sync blocks by reading a single object instead of listing the bucket (see --bucket-index.max-staleness). Only one compactor per bucket should have this enabled.").
+		Default("false").Bool()
+
 	webExternalPrefix := cmd.Flag("web.external-prefix", "Static prefix for all HTML links and redirect URLs in the bucket web UI interface. Actual endpoints are still served on / or the web.route-prefix. This allows thanos bucket web UI to be served behind a reverse proxy that strips a URL sub-path.").Default("").String()
 	webPrefixHeaderName := cmd.Flag("web.prefix-header", "Name of HTTP request header used for dynamic prefixing of UI links and redirects. This option is ignored if web.external-prefix argument is set. Security risk: enable this option only if a reverse proxy in front of thanos is resetting the header. The --web.prefix-header=X-Forwarded-Prefix option can be useful, for example, if Thanos UI is served via Traefik reverse proxy with PathPrefixStrip option enabled, which sends the stripped prefix value in X-Forwarded-Prefix header. This allows thanos UI to be served on a sub-path.").Default("").String()
 	label := cmd.Flag("bucket-web-label", "Prometheus label to use as timeline title in the bucket web UI").String()
 
 	m[component.Compact.String()] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ <-chan struct{}, _ bool) error {
-		return runCompact(g, logger, reg,
+		retentionByResolution, err := compact.ParseRetentionByResolution(*retentionResolutions)
+		if err != nil {
+			return errors.Wrap(err, "parse retention flags")
+		}
+		for res, retention := range map[compact.ResolutionLevel]time.Duration{
+			compact.ResolutionLevelRaw: time.Duration(*retentionRaw),
+			compact.ResolutionLevel5m:  time.Duration(*retention5m),
+			compact.ResolutionLevel1h:  time.Duration(*retention1h),
+		} {
+			if _, ok := retentionByResolution[res]; ok && retention > 0 {
+				return errors.Errorf("retention of resolution %s is set by both --retention.resolution and the flag of the resolution", model.Duration(time.Duration(res)*time.Millisecond))
+			}
+			if _, ok := retentionByResolution[res]; !ok {
+				retentionByResolution[res] = retention
+			}
+		}
+
+		return runCompact(g, logger, reg, tracer,
 			*httpAddr,
 			time.Duration(*httpGracePeriod),
 			*dataDir,
@@ -161,18 +222,23 @@ func registerCompact(m map[string]setupFunc, app *kingpin.Application) {
 			*acceptMalformedIndex,
 			*wait,
 			*generateMissingIndexCacheFiles,
-			map[compact.ResolutionLevel]time.Duration{
-				compact.ResolutionLevelRaw: time.Duration(*retentionRaw),
-				compact.ResolutionLevel5m:  time.Duration(*retention5m),
-				compact.ResolutionLevel1h:  time.Duration(*retention1h),
-			},
+			retentionByResolution,
+			retentionConf,
+			*retentionDryRun,
 			component.Compact,
 			*disableDownsampling,
+			*downsamplingLevels,
 			*maxCompactionLevel,
 			*blockSyncConcurrency,
 			*compactionConcurrency,
+			uint64(*compactionDiskBudget),
+			compact.SplitConfig{MaxSeries: *splitMaxSeries, MaxBytes: uint64(*splitMaxSize)},
+			*repairChunks,
+			objStoreBackupConfig,
 			*dedupReplicaLabels,
+			*dedupFunc,
 			selectorRelabelConf,
+			*updateBucketIndex,
 			*waitInterval,
 			*label,
 			*webExternalPrefix,
@@ -185,6 +251,7 @@ func runCompact(
 	g *run.Group,
 	logger log.Logger,
 	reg *prometheus.Registry,
+	tracer opentracing.Tracer,
 	httpBindAddr string,
 	httpGracePeriod time.Duration,
 	dataDir string,
@@ -193,12 +260,21 @@ func runCompact(
 	deleteDelay time.Duration,
 	haltOnError, acceptMalformedIndex, wait, generateMissingIndexCacheFiles bool,
 	retentionByResolution map[compact.ResolutionLevel]time.Duration,
+	retentionConf *extflag.PathOrContent,
+	retentionDryRun bool,
 	component component.Component,
 	disableDownsampling bool,
+	downsamplingLevelSpecs []string,
 	maxCompactionLevel, blockSyncConcurrency int,
 	concurrency int,
+	diskBudget uint64,
+	splitConf compact.SplitConfig,
+	repairChunks bool,
+	objStoreBackupConfig *extflag.PathOrContent,
 	dedupReplicaLabels []string,
+	dedupFunc string,
 	selectorRelabelConf *extflag.PathOrContent,
+	updateBucketIndex bool,
 	waitInterval time.Duration,
 	label string,
 	externalPrefix, prefixHeader string,
@@ -240,6 +316,10 @@ func runCompact(
 	})
 
 	downsampleMetrics := newDownsampleMetrics(reg)
+	downsamplingLevels, err := downsample.ParseLevels(downsamplingLevelSpecs)
+	if err != nil {
+		return errors.Wrap(err, "parse downsampling levels")
+	}
 
 	httpProbe := prober.NewHTTP()
 	statusProber := prober.Combine(
@@ -273,6 +353,21 @@ func runCompact(
 		return err
 	}
 
+	var backupBkt objstore.Bucket
+	if repairChunks {
+		backupConfContentYaml, err := objStoreBackupConfig.Content()
+		if err != nil {
+			return err
+		}
+		if len(backupConfContentYaml) == 0 {
+			return errors.New("chunks repair is enabled, so backup bucket client is required")
+		}
+		backupBkt, err = client.NewBucket(logger, backupConfContentYaml, nil, component.String())
+		if err != nil {
+			return err
+		}
+	}
+
 	relabelContentYaml, err := selectorRelabelConf.Content()
 	if err != nil {
 		return errors.Wrap(err, "get content of relabel configuration")
@@ -287,6 +382,9 @@ func runCompact(
 	defer func() {
 		if err != nil {
 			runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+			if backupBkt != nil {
+				runutil.CloseWithLogOnErr(logger, backupBkt, "backup bucket client")
+			}
 		}
 	}()
 
@@ -295,6 +393,8 @@ func runCompact(
 	// This is to make sure compactor will not accidentally perform compactions with gap instead.
 	ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(logger, bkt, deleteDelay/2)
 	duplicateBlocksFilter := block.NewDeduplicateFilter()
+	// Blocks marked for no compaction are still fetched, so they are downsampled and retention is applied to them.
+	noCompactMarkFilter := block.NewNoCompactMarkFilter(logger, bkt)
 
 	baseMetaFetcher, err := block.NewBaseFetcher(logger, 32, bkt, "", extprom.WrapRegistererWithPrefix("thanos_", reg))
 	if err != nil {
@@ -317,6 +417,7 @@ func runCompact(
 				block.NewConsistencyDelayMetaFilter(logger, consistencyDelay, extprom.WrapRegistererWithPrefix("thanos_", reg)),
 				ignoreDeletionMarkFilter,
 				duplicateBlocksFilter,
+				noCompactMarkFilter,
 			}, []block.MetadataModifier{block.NewReplicaLabelRemover(logger, dedupReplicaLabels)},
 		)
 		cf.UpdateOnChange(compactorView.Set)
@@ -327,12 +428,16 @@ func runCompact(
 			cf,
 			duplicateBlocksFilter,
 			ignoreDeletionMarkFilter,
+			noCompactMarkFilter,
 			blocksMarkedForDeletion,
 			blockSyncConcurrency,
 			acceptMalformedIndex, enableVerticalCompaction)
 		if err != nil {
 			return errors.Wrap(err, "create syncer")
 		}
+		if backupBkt != nil {
+			sy.EnableChunksRepair(backupBkt)
+		}
 	}
 
 	levels, err := compactions.levels(maxCompactionLevel)
@@ -347,15 +452,21 @@ func runCompact(
 	ctx, cancel := context.WithCancel(context.Background())
 	// Instantiate the compactor with different time slices. Timestamps in TSDB
 	// are in milliseconds.
-	comp, err := tsdb.NewLeveledCompactor(ctx, reg, logger, levels, downsample.NewPool())
+	var comp tsdb.Compactor
+	comp, err = tsdb.NewLeveledCompactor(ctx, reg, logger, levels, downsample.NewPool())
 	if err != nil {
 		cancel()
 		return errors.Wrap(err, "create compactor")
 	}
+	if dedupFunc == compact.DedupFuncPenalty {
+		level.Info(logger).Log("msg", "overlapping raw blocks are merged with penalty based deduplication")
+		comp = compact.NewDedupCompactor(logger, comp, downsample.NewPool())
+	}
 
 	var (
 		compactDir      = path.Join(dataDir, "compact")
 		downsamplingDir = path.Join(dataDir, "downsample")
+		deletionsDir    = path.Join(dataDir, "deletions")
 		indexCacheDir   = path.Join(dataDir, "index_cache")
 	)
 
@@ -364,21 +475,57 @@ func runCompact(
 		return errors.Wrap(err, "clean working downsample directory")
 	}
 
+	// Blocks of the highest compaction level are not compacted anymore, so deletion requests are applied by rewriting them.
+	deletionRewriter := compact.NewDeletionRewriter(logger, reg, bkt, deletionsDir, time.Duration(levels[len(levels)-1])*time.Millisecond, blocksMarkedForDeletion)
+
 	blocksCleaner := compact.NewBlocksCleaner(logger, bkt, ignoreDeletionMarkFilter, deleteDelay, blocksCleaned, blockCleanupFailures)
-	compactor, err := compact.NewBucketCompactor(logger, sy, comp, compactDir, bkt, concurrency)
+
+	var bucketIndexUpdater *block.BucketIndexUpdater
+	if updateBucketIndex {
+		bucketIndexUpdater = block.NewBucketIndexUpdater(logger, bkt)
+	}
+	compactor, err := compact.NewBucketCompactor(logger, sy, comp, compactDir, bkt, concurrency, diskBudget, splitConf)
 	if err != nil {
 		cancel()
 		return errors.Wrap(err, "create bucket compactor")
 	}
 
-	if retentionByResolution[compact.ResolutionLevelRaw].Seconds() != 0 {
-		level.Info(logger).Log("msg", "retention policy of raw samples is enabled", "duration", retentionByResolution[compact.ResolutionLevelRaw])
+	retentionContentYaml, err := retentionConf.Content()
+	if err != nil {
+		cancel()
+		return errors.Wrap(err, "get content of retention configuration")
+	}
+	var retentionConfig *compact.RetentionConfig
+	if len(retentionContentYaml) > 0 {
+		retentionConfig, err = compact.ParseRetentionConfig(retentionContentYaml)
+		if err != nil {
+			cancel()
+			return err
+		}
 	}
-	if retentionByResolution[compact.ResolutionLevel5m].Seconds() != 0 {
-		level.Info(logger).Log("msg", "retention policy of 5 min aggregated samples is enabled", "duration", retentionByResolution[compact.ResolutionLevel5m])
+	retentionPolicy := compact.NewRetentionPolicy(logger, reg, bkt, retentionConfig, retentionByResolution, retentionDryRun, blocksMarkedForDeletion)
+	if err := retentionPolicy.Validate(downsamplingLevels.Resolutions()); err != nil {
+		cancel()
+		return errors.Wrap(err, "validate retention")
 	}
-	if retentionByResolution[compact.ResolutionLevel1h].Seconds() != 0 {
-		level.Info(logger).Log("msg", "retention policy of 1 hour aggregated samples is enabled", "duration", retentionByResolution[compact.ResolutionLevel1h])
+	for _, r := range retentionPolicy.Rules() {
+		var retentions []interface{}
+		for _, res := range downsamplingLevels.Resolutions() {
+			retention := r.RetentionByResolution()[compact.ResolutionLevel(res)]
+			if retention == 0 {
+				continue
+			}
+			name := "raw"
+			if res != downsample.ResLevel0 {
+				name = model.Duration(time.Duration(res) * time.Millisecond).String()
+			}
+			retentions = append(retentions, name, model.Duration(retention))
+		}
+		if len(retentions) == 0 {
+			continue
+		}
+		kv := append([]interface{}{"msg", "retention policy is enabled", "rule", r.Name, "matchers", r.Matchers}, retentions...)
+		level.Info(logger).Log(append(kv, "dryRun", retentionDryRun)...)
 	}
 
 	compactMainFn := func() error {
@@ -388,22 +535,16 @@ func runCompact(
 
 		if !disableDownsampling {
 			// After all compactions are done, work down the downsampling backlog.
-			// We run two passes of this to ensure that the 1h downsampling is generated
-			// for 5m downsamplings created in the first run.
-			level.Info(logger).Log("msg", "start first pass of downsampling")
-			if err := sy.SyncMetas(ctx); err != nil {
-				return errors.Wrap(err, "sync before first pass of downsampling")
-			}
-			if err := downsampleBucket(ctx, logger, downsampleMetrics, bkt, sy.Metas(), downsamplingDir); err != nil {
-				return errors.Wrap(err, "first pass of downsampling failed")
-			}
-
-			level.Info(logger).Log("msg", "start second pass of downsampling")
-			if err := sy.SyncMetas(ctx); err != nil {
-				return errors.Wrap(err, "sync before second pass of downsampling")
-			}
-			if err := downsampleBucket(ctx, logger, downsampleMetrics, bkt, sy.Metas(), downsamplingDir); err != nil {
-				return errors.Wrap(err, "second pass of downsampling failed")
+			// We run a pass per downsampling level to ensure that e.g. the 1h downsampling is generated
+			// for 5m downsamplings created in the previous pass.
+			for i := range downsamplingLevels {
+				level.Info(logger).Log("msg", "start pass of downsampling", "pass", i+1)
+				if err := sy.SyncMetas(ctx); err != nil {
+					return errors.Wrapf(err, "sync before pass %d of downsampling", i+1)
+				}
+				if err := downsampleBucket(ctx, logger, downsampleMetrics, bkt, sy.Metas(), sy.DeletionRequests(), downsamplingLevels, downsamplingDir); err != nil {
+					return errors.Wrapf(err, "pass %d of downsampling failed", i+1)
+				}
 			}
 			level.Info(logger).Log("msg", "downsampling iterations done")
 		} else {
@@ -415,20 +556,34 @@ func runCompact(
 			return errors.Wrap(err, "sync before first pass of downsampling")
 		}
 
-		if err := compact.ApplyRetentionPolicyByResolution(ctx, logger, bkt, sy.Metas(), retentionByResolution, blocksMarkedForDeletion); err != nil {
+		if err := retentionPolicy.Apply(ctx, sy.Metas()); err != nil {
 			return errors.Wrap(err, "retention failed")
 		}
 
+		if err := deletionRewriter.RewriteBlocks(ctx, sy.Metas(), sy.DeletionRequests()); err != nil {
+			return errors.Wrap(err, "rewrite blocks with deletion requests")
+		}
+
 		// No need to resync before partial uploads and delete marked blocks. Last sync should be valid.
 		compact.BestEffortCleanAbortedPartialUploads(ctx, logger, sy.Partial(), bkt, partialUploadDeleteAttempts, blocksCleaned, blockCleanupFailures)
 		if err := blocksCleaner.DeleteMarkedBlocks(ctx); err != nil {
 			return errors.Wrap(err, "error cleaning blocks")
 		}
+
+		if bucketIndexUpdater != nil {
+			// Readers fall back to listing the bucket once the bucket index is stale, so failing to update it is not critical.
+			if err := bucketIndexUpdater.UpdateIndex(ctx); err != nil {
+				level.Warn(logger).Log("msg", "failed to update bucket index", "err", err)
+			}
+		}
 		return nil
 	}
 
 	g.Add(func() error {
 		defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+		if backupBkt != nil {
+			defer runutil.CloseWithLogOnErr(logger, backupBkt, "backup bucket client")
+		}
 
 		// Generate index files.
 		// TODO(bwplotka): Remove this in next release.
@@ -489,10 +644,17 @@ func runCompact(
 		global := ui.NewBucketUI(logger, label, path.Join(externalPrefix, "/global"), prefixHeader)
 		global.Register(r, ins)
 
+		api := v1.NewAPI(logger, bkt, sy.PendingDeletionBlocks)
+		api.Register(r.WithPrefix("/api/v1"), tracer, logger, ins)
+
 		// Separate fetcher for global view.
 		// TODO(bwplotka): Allow Bucket UI to visualize the state of the block as well.
-		f := baseMetaFetcher.NewMetaFetcher(extprom.WrapRegistererWithPrefix("thanos_bucket_ui", reg), nil, nil, "component", "globalBucketUI")
-		f.UpdateOnChange(global.Set)
+		noServeMarkFilter := block.NewNoServeMarkFilter(logger, bkt, false)
+		f := baseMetaFetcher.NewMetaFetcher(extprom.WrapRegistererWithPrefix("thanos_bucket_ui", reg), []block.MetadataFilter{noServeMarkFilter}, nil, "component", "globalBucketUI")
+		f.UpdateOnChange(func(blocks []metadata.Meta, err error) {
+			global.SetNoServeMarks(noServeMarkFilter.NoServeMarkBlocks())
+			global.Set(blocks, err)
+		})
 
 		srv.Handle("/", r)
 
@@ -16,6 +16,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/thanos-io/thanos/pkg/block"
@@ -60,6 +61,7 @@ func RunDownsample(
 	httpGracePeriod time.Duration,
 	dataDir string,
 	objStoreConfig *extflag.PathOrContent,
+	levels downsample.Levels,
 	comp component.Component,
 ) error {
 	confContentYaml, err := objStoreConfig.Content()
@@ -101,22 +103,21 @@ func RunDownsample(
 			defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
 			statusProber.Ready()
 
-			level.Info(logger).Log("msg", "start first pass of downsampling")
-			metas, _, err := metaFetcher.Fetch(ctx)
+			deletionRequests, err := compact.ReadDeletionRequests(ctx, logger, bkt)
 			if err != nil {
-				return errors.Wrap(err, "sync before first pass of downsampling")
+				return errors.Wrap(err, "read deletion requests")
 			}
-			if err := downsampleBucket(ctx, logger, metrics, bkt, metas, dataDir); err != nil {
-				return errors.Wrap(err, "downsampling failed")
-			}
-
-			level.Info(logger).Log("msg", "start second pass of downsampling")
-			metas, _, err = metaFetcher.Fetch(ctx)
-			if err != nil {
-				return errors.Wrap(err, "sync before second pass of downsampling")
-			}
-			if err := downsampleBucket(ctx, logger, metrics, bkt, metas, dataDir); err != nil {
-				return errors.Wrap(err, "downsampling failed")
+			// Run a pass per downsampling level to ensure that each level is generated for blocks of the
+			// previous level created in the previous pass.
+			for i := range levels {
+				level.Info(logger).Log("msg", "start pass of downsampling", "pass", i+1)
+				metas, _, err := metaFetcher.Fetch(ctx)
+				if err != nil {
+					return errors.Wrapf(err, "sync before pass %d of downsampling", i+1)
+				}
+				if err := downsampleBucket(ctx, logger, metrics, bkt, metas, deletionRequests, levels, dataDir); err != nil {
+					return errors.Wrap(err, "downsampling failed")
+				}
 			}
 
 			return nil
@@ -151,6 +152,8 @@ func downsampleBucket(
 	metrics *DownsampleMetrics,
 	bkt objstore.Bucket,
 	metas map[ulid.ULID]*metadata.Meta,
+	deletionRequests []*compact.DeletionRequest,
+	levels downsample.Levels,
 	dir string,
 ) error {
 	if err := os.RemoveAll(dir); err != nil {
@@ -166,81 +169,70 @@ func downsampleBucket(
 		}
 	}()
 
-	// mapping from a hash over all source IDs to blocks. We don't need to downsample a block
-	// if a downsampled version with the same hash already exists.
-	sources5m := map[ulid.ULID]struct{}{}
-	sources1h := map[ulid.ULID]struct{}{}
+	// mapping from source IDs to shards of downsampled blocks holding their series, per resolution. We don't need
+	// to downsample a block if downsampled blocks of all its sources already exist. Shards of a split block share
+	// their sources, so a downsampled block only covers the shards of series it holds.
+	sources := map[int64]map[ulid.ULID][]*metadata.ThanosShard{}
+	for _, res := range levels.Resolutions()[1:] {
+		sources[res] = map[ulid.ULID][]*metadata.ThanosShard{}
+	}
 
 	for _, m := range metas {
-		switch m.Thanos.Downsample.Resolution {
-		case downsample.ResLevel0:
+		if m.Thanos.Downsample.Resolution == downsample.ResLevel0 {
 			continue
-		case downsample.ResLevel1:
-			for _, id := range m.Compaction.Sources {
-				sources5m[id] = struct{}{}
-			}
-		case downsample.ResLevel2:
-			for _, id := range m.Compaction.Sources {
-				sources1h[id] = struct{}{}
-			}
-		default:
-			return errors.Errorf("unexpected downsampling resolution %d", m.Thanos.Downsample.Resolution)
+		}
+		s, ok := sources[m.Thanos.Downsample.Resolution]
+		if !ok {
+			// Blocks downsampled with previously configured levels are kept, but not downsampled further.
+			level.Debug(logger).Log("msg", "block resolution is not a configured downsampling level", "id", m.ULID, "resolution", m.Thanos.Downsample.Resolution)
+			continue
+		}
+		for _, id := range m.Compaction.Sources {
+			s[id] = append(s[id], m.Thanos.Shard)
 		}
 	}
 
 	for _, m := range metas {
-		switch m.Thanos.Downsample.Resolution {
-		case downsample.ResLevel0:
-			missing := false
-			for _, id := range m.Compaction.Sources {
-				if _, ok := sources5m[id]; !ok {
-					missing = true
-					break
-				}
-			}
-			if !missing {
-				continue
-			}
-			// Only downsample blocks once we are sure to get roughly 2 chunks out of it.
-			// NOTE(fabxc): this must match with at which block size the compactor creates downsampled
-			// blocks. Otherwise we may never downsample some data.
-			if m.MaxTime-m.MinTime < downsample.DownsampleRange0 {
-				continue
-			}
-			if err := processDownsampling(ctx, logger, bkt, m, dir, downsample.ResLevel1); err != nil {
-				metrics.downsampleFailures.WithLabelValues(compact.GroupKey(m.Thanos)).Inc()
-				return errors.Wrap(err, "downsampling to 5 min")
-			}
-			metrics.downsamples.WithLabelValues(compact.GroupKey(m.Thanos)).Inc()
-
-		case downsample.ResLevel1:
-			missing := false
-			for _, id := range m.Compaction.Sources {
-				if _, ok := sources1h[id]; !ok {
-					missing = true
-					break
-				}
-			}
-			if !missing {
-				continue
-			}
-			// Only downsample blocks once we are sure to get roughly 2 chunks out of it.
-			// NOTE(fabxc): this must match with at which block size the compactor creates downsampled
-			// blocks. Otherwise we may never downsample some data.
-			if m.MaxTime-m.MinTime < downsample.DownsampleRange1 {
-				continue
-			}
-			if err := processDownsampling(ctx, logger, bkt, m, dir, downsample.ResLevel2); err != nil {
-				metrics.downsampleFailures.WithLabelValues(compact.GroupKey(m.Thanos))
-				return errors.Wrap(err, "downsampling to 60 min")
+		next, ok := levels.Next(m.Thanos.Downsample.Resolution)
+		if !ok {
+			continue
+		}
+		missing := false
+		for _, id := range m.Compaction.Sources {
+			if !shardsCover(sources[next.Resolution][id], m.Thanos.Shard) {
+				missing = true
+				break
 			}
-			metrics.downsamples.WithLabelValues(compact.GroupKey(m.Thanos))
 		}
+		if !missing {
+			continue
+		}
+		// Only downsample blocks once we are sure to get roughly 2 chunks out of it.
+		// NOTE(fabxc): this must match with at which block size the compactor creates downsampled
+		// blocks. Otherwise we may never downsample some data.
+		if m.MaxTime-m.MinTime < next.MinBlockRange {
+			continue
+		}
+		if err := processDownsampling(ctx, logger, bkt, m, deletionRequests, dir, next.Resolution); err != nil {
+			metrics.downsampleFailures.WithLabelValues(compact.GroupKey(m.Thanos)).Inc()
+			return errors.Wrapf(err, "downsampling to %s", model.Duration(time.Duration(next.Resolution)*time.Millisecond))
+		}
+		metrics.downsamples.WithLabelValues(compact.GroupKey(m.Thanos)).Inc()
 	}
 	return nil
 }
 
-func processDownsampling(ctx context.Context, logger log.Logger, bkt objstore.Bucket, m *metadata.Meta, dir string, resolution int64) error {
+// shardsCover returns true if any of the shards covers all series of the given shard.
+func shardsCover(shards []*metadata.ThanosShard, shard *metadata.ThanosShard) bool {
+	for _, s := range shards {
+		if s.Covers(shard) {
+			return true
+		}
+	}
+	return false
+}
+
+func processDownsampling(ctx context.Context, logger log.Logger, bkt objstore.Bucket, m *metadata.Meta, deletionRequests []*compact.DeletionRequest, dir string, resolution int64) error {
 	begin := time.Now()
 	bdir := filepath.Join(dir, m.ULID.String())
 
@@ -254,6 +246,19 @@ func processDownsampling(ctx context.Context, logger log.Logger, bkt objstore.Bu
 		return errors.Wrap(err, "input block index not valid")
 	}
 
+	// Downsampled block must not contain series deleted by deletion requests not yet applied to the input block.
+	if pending := compact.PendingDeletionRequests(m, deletionRequests, time.Now()); len(pending) > 0 {
+		res, err := compact.ApplyDeletionRequests(logger, bdir, *m, pending, time.Now())
+		if err != nil {
+			return errors.Wrapf(err, "apply deletion requests to block %s", m.ULID)
+		}
+		m = res
+		if m.Stats.NumSeries == 0 {
+			level.Info(logger).Log("msg", "all series of block deleted by deletion requests, skipping downsampling", "id", m.ULID)
+			return nil
+		}
+	}
+
 	begin = time.Now()
 
 	var pool chunkenc.Pool
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"strings"
 
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
 	"github.com/thanos-io/thanos/pkg/extflag"
 
 	"github.com/prometheus/common/model"
@@ -73,3 +74,8 @@ func regSelectorRelabelFlags(cmd *kingpin.CmdClause) *extflag.PathOrContent {
 		false,
 	)
 }
+
+func regDownsamplingLevelsFlag(cmd *kingpin.CmdClause) *[]string {
+	return cmd.Flag("downsampling.level", "Downsampling level in the <resolution>:<min-block-range> format, e.g. 5m:40h. Blocks of the previous resolution (starting with raw data) spanning at least the minimum block range are downsampled to the resolution. Each resolution must be a multiple of the previous one. Repeat the flag to configure the downsampling levels from the lowest to the highest resolution.").
+		PlaceHolder("<resolution>:<min-block-range>").Default(downsample.DefaultLevelsFlagValues()...).Strings()
+}
@@ -9,6 +9,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"testing"
 	"time"
 
@@ -123,9 +124,110 @@ func TestCleanupDownsampleCacheFolder(t *testing.T) {
 
 	metas, _, err := metaFetcher.Fetch(ctx)
 	testutil.Ok(t, err)
-	testutil.Ok(t, downsampleBucket(ctx, logger, metrics, bkt, metas, dir))
+	testutil.Ok(t, downsampleBucket(ctx, logger, metrics, bkt, metas, nil, downsample.DefaultLevels, dir))
 	testutil.Equals(t, 1.0, promtest.ToFloat64(metrics.downsamples.WithLabelValues(compact.GroupKey(meta.Thanos))))
 
 	_, err = os.Stat(dir)
 	testutil.Assert(t, os.IsNotExist(err), "index cache dir should not exist at the end of execution")
 }
+
+func TestDownsampleBucket_CustomLevels(t *testing.T) {
+	logger := log.NewNopLogger()
+	dir, err := ioutil.TempDir("", "test-downsample-levels")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+	id, err := e2eutil.CreateBlock(
+		ctx,
+		dir,
+		[]labels.Labels{{{Name: "a", Value: "1"}}},
+		100, 0, 3*time.Hour.Milliseconds(),
+		labels.Labels{{Name: "e1", Value: "1"}},
+		downsample.ResLevel0)
+	testutil.Ok(t, err)
+	testutil.Ok(t, block.Upload(ctx, logger, bkt, path.Join(dir, id.String())))
+
+	levels, err := downsample.ParseLevels([]string{"1m:2h", "10m:1d"})
+	testutil.Ok(t, err)
+
+	metaFetcher, err := block.NewMetaFetcher(nil, 32, bkt, "", nil, nil, nil)
+	testutil.Ok(t, err)
+
+	// The block is downsampled to the first level only, as the downsampled block is too short for the second one.
+	for range levels {
+		metas, _, err := metaFetcher.Fetch(ctx)
+		testutil.Ok(t, err)
+		testutil.Ok(t, downsampleBucket(ctx, logger, newDownsampleMetrics(prometheus.NewRegistry()), bkt, metas, nil, levels, path.Join(dir, "downsample")))
+	}
+
+	metas, _, err := metaFetcher.Fetch(ctx)
+	testutil.Ok(t, err)
+	var resolutions []int64
+	for _, m := range metas {
+		resolutions = append(resolutions, m.Thanos.Downsample.Resolution)
+	}
+	sort.Slice(resolutions, func(i, j int) bool { return resolutions[i] < resolutions[j] })
+	testutil.Equals(t, []int64{downsample.ResLevel0, time.Minute.Milliseconds()}, resolutions)
+}
+
+func TestDownsampleBucket_Shards(t *testing.T) {
+	logger := log.NewNopLogger()
+	dir, err := ioutil.TempDir("", "test-downsample-shards")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Two shards of a split block, sharing their sources.
+	bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+	var (
+		ids     []ulid.ULID
+		sources []ulid.ULID
+	)
+	for i, series := range [][]labels.Labels{{{{Name: "a", Value: "1"}}}, {{{Name: "a", Value: "2"}}}} {
+		id, err := e2eutil.CreateBlock(ctx, dir, series, 100, 0, 3*time.Hour.Milliseconds(), labels.Labels{{Name: "e1", Value: "1"}}, downsample.ResLevel0)
+		testutil.Ok(t, err)
+		meta, err := metadata.Read(path.Join(dir, id.String()))
+		testutil.Ok(t, err)
+		if sources == nil {
+			sources = meta.Compaction.Sources
+		}
+		meta.Compaction.Sources = sources
+		meta.Thanos.Shard = &metadata.ThanosShard{Index: uint64(i), Count: 2}
+		testutil.Ok(t, metadata.Write(logger, path.Join(dir, id.String()), meta))
+		testutil.Ok(t, block.Upload(ctx, logger, bkt, path.Join(dir, id.String())))
+		ids = append(ids, id)
+	}
+
+	levels, err := downsample.ParseLevels([]string{"1m:2h"})
+	testutil.Ok(t, err)
+	metaFetcher, err := block.NewMetaFetcher(nil, 32, bkt, "", nil, nil, nil)
+	testutil.Ok(t, err)
+
+	// A run interrupted after downsampling the first shard.
+	metas, _, err := metaFetcher.Fetch(ctx)
+	testutil.Ok(t, err)
+	delete(metas, ids[1])
+	testutil.Ok(t, downsampleBucket(ctx, logger, newDownsampleMetrics(prometheus.NewRegistry()), bkt, metas, nil, levels, path.Join(dir, "downsample")))
+
+	// The second shard is still downsampled by the next run, while the first one is not downsampled again.
+	metas, _, err = metaFetcher.Fetch(ctx)
+	testutil.Ok(t, err)
+	testutil.Ok(t, downsampleBucket(ctx, logger, newDownsampleMetrics(prometheus.NewRegistry()), bkt, metas, nil, levels, path.Join(dir, "downsample")))
+
+	metas, _, err = metaFetcher.Fetch(ctx)
+	testutil.Ok(t, err)
+	var shards []uint64
+	for _, m := range metas {
+		if m.Thanos.Downsample.Resolution == time.Minute.Milliseconds() {
+			shards = append(shards, m.Thanos.Shard.Index)
+		}
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i] < shards[j] })
+	testutil.Equals(t, []uint64{0, 1}, shards)
+}
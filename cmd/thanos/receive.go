@@ -23,6 +23,7 @@ import (
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/discovery/dns"
 	"github.com/thanos-io/thanos/pkg/extflag"
 	"github.com/thanos-io/thanos/pkg/extgrpc"
 	"github.com/thanos-io/thanos/pkg/extprom"
@@ -34,6 +35,7 @@ import (
 	grpcserver "github.com/thanos-io/thanos/pkg/server/grpc"
 	httpserver "github.com/thanos-io/thanos/pkg/server/http"
 	"github.com/thanos-io/thanos/pkg/store"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/thanos-io/thanos/pkg/tls"
 )
 
@@ -69,6 +71,24 @@ func registerReceive(m map[string]setupFunc, app *kingpin.Application) {
 	refreshInterval := modelDuration(cmd.Flag("receive.hashrings-file-refresh-interval", "Refresh interval to re-read the hashring configuration file. (used as a fallback)").
 		Default("5m"))
 
+	hashringsSDInterval := modelDuration(cmd.Flag("receive.hashrings-sd-interval", "Refresh interval to re-read file SD files of hashrings. It is used as a resync fallback.").
+		Default("5m"))
+
+	hashringsDNSInterval := modelDuration(cmd.Flag("receive.hashrings-dns-interval", "Interval between DNS resolutions of hashring endpoints prefixed with dns+ or dnssrv+.").
+		Default("30s"))
+
+	hashringsDNSResolver := cmd.Flag("receive.hashrings-dns-resolver", fmt.Sprintf("Resolver to use. Possible options: [%s, %s]", dns.GolangResolverType, dns.MiekgdnsResolverType)).
+		Default(string(dns.GolangResolverType)).Hidden().String()
+
+	hashringsDebounce := modelDuration(cmd.Flag("receive.hashrings-debounce", "Minimum time between rebuilds of hashrings whose discovered endpoints changed.").
+		Default("5s"))
+
+	hashringsTransition := modelDuration(cmd.Flag("receive.hashrings-transition-period", "Period after a hashring change during which time series moved to other endpoints are written to their previous endpoints as well, so these keep complete series while the new endpoints fill up. 0 disables dual-writing.").
+		Default("0s"))
+
+	forwardTimeout := modelDuration(cmd.Flag("receive.forward-timeout", "Timeout of write requests forwarded to other receivers, including dual-writes during hashring transitions. 0 disables the timeout.").
+		Default("5s"))
+
 	local := cmd.Flag("receive.local-endpoint", "Endpoint of local receive node. Used to identify the local node in the hashring configuration.").String()
 
 	tenantHeader := cmd.Flag("receive.tenant-header", "HTTP header to determine tenant for write requests.").Default(receive.DefaultTenantHeader).String()
@@ -81,26 +101,120 @@ func registerReceive(m map[string]setupFunc, app *kingpin.Application) {
 
 	replicationFactor := cmd.Flag("receive.replication-factor", "How many times to replicate incoming write requests.").Default("1").Uint64()
 
+	handoffDir := cmd.Flag("receive.hinted-handoff-dir", "Directory of the hinted handoff queue. If set, replicated write requests missed by unreachable replicas, while the replication quorum was met, are queued there and replayed to the replicas once they are back. Empty disables hinted handoff.").
+		PlaceHolder("<path>").String()
+
+	handoffMaxSize := cmd.Flag("receive.hinted-handoff-max-size", "Maximum size of the hinted handoff queue. Write requests beyond it are not queued.").
+		Default("1GB").Bytes()
+
+	handoffMaxAge := modelDuration(cmd.Flag("receive.hinted-handoff-max-age", "Maximum age of queued write requests. Older write requests are dropped instead of being replayed.").
+		Default("3h"))
+
+	handoffRetryInterval := modelDuration(cmd.Flag("receive.hinted-handoff-retry-interval", "Interval between attempts to replay queued write requests to their replicas.").
+		Default("10s"))
+
+	forwardCompression := cmd.Flag("receive.forward.compression", "Compression of write requests forwarded to other receivers over gRPC write streams.").
+		Default("snappy").Enum("none", "snappy", "zstd")
+
+	maxConcurrentWrites := cmd.Flag("receive.max-concurrent-writes", "Maximum number of write requests of clients handled concurrently, over HTTP and gRPC. Further requests wait for their turn. Write requests forwarded between receivers are not limited, as they hold a turn on the forwarding receiver. gRPC write streams are not read from while 64 of their requests are being written, regardless of the limit. 0 disables the limit.").
+		Default("0").Int()
+
+	tenantIdleTimeout := modelDuration(cmd.Flag("receive.tenant-idle-timeout", "Duration without writes after which a tenant is evicted: its head is flushed to a block, its blocks are shipped and its TSDB is closed. Local data of evicted tenants is removed once all their blocks are shipped. 0 disables eviction.").
+		Default("0s"))
+
+	walOnly := cmd.Flag("receive.wal-only", "Keep only the WAL and head of TSDBs. Blocks are cut from the head as soon as their range of tsdb.min-block-duration is complete, shipped and removed locally once uploaded; the head keeps serving the most recent samples via the StoreAPI. Requires a bucket configuration.").
+		Default("false").Bool()
+
+	tenantsConf := extflag.RegisterPathOrContent(cmd, "receive.tenants.config",
+		"YAML file that contains per-tenant overrides of the TSDB retention, min and max block durations and of the idle timeout, applied when TSDBs of tenants are opened.",
+		false)
+
 	tsdbMinBlockDuration := modelDuration(cmd.Flag("tsdb.min-block-duration", "Min duration for local TSDB blocks").Default("2h").Hidden())
 	tsdbMaxBlockDuration := modelDuration(cmd.Flag("tsdb.max-block-duration", "Max duration for local TSDB blocks").Default("2h").Hidden())
 	ignoreBlockSize := cmd.Flag("shipper.ignore-unequal-block-size", "If true receive will not require min and max block size flags to be set to the same value. Only use this if you want to keep long retention and compaction enabled, as in the worst case it can result in ~2h data loss for your Thanos bucket storage.").Default("false").Hidden().Bool()
 
 	walCompression := cmd.Flag("tsdb.wal-compression", "Compress the tsdb WAL.").Default("true").Bool()
 
+	receiveMode := cmd.Flag("receive.mode", "Mode of the receiver. \"router-ingestor\" routes remote write requests over the hashring and ingests series belonging to its local endpoint. "+
+		"\"router\" only routes remote write requests over the hashring and holds no TSDB, so it requires --receive.hashrings-file and ignores the TSDB, shipper and StoreAPI flags. "+
+		"\"ingestor\" only ingests write requests already routed by routers, accepted on the gRPC WriteableStore API, and needs no hashring configuration.").
+		Default(string(receive.RouterIngestor)).Enum(string(receive.RouterIngestor), string(receive.RouterOnly), string(receive.IngestorOnly))
+
 	m[comp.String()] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ <-chan struct{}, _ bool) error {
 		lset, err := parseFlagLabels(*labelStrs)
 		if err != nil {
 			return errors.Wrap(err, "parse labels")
 		}
 
-		var cw *receive.ConfigWatcher
+		mode := receive.ReceiverMode(*receiveMode)
+		if mode == receive.RouterOnly && *hashringsFile == "" {
+			return errors.New("--receive.hashrings-file is required in router mode")
+		}
+		if mode == receive.IngestorOnly && *hashringsFile != "" {
+			level.Warn(logger).Log("msg", "ingestor does not route write requests; ignoring --receive.hashrings-file")
+			*hashringsFile = ""
+		}
+
+		var (
+			cw *receive.ConfigWatcher
+			hd *receive.HashringDiscovery
+		)
 		if *hashringsFile != "" {
-			cw, err = receive.NewConfigWatcher(log.With(logger, "component", "config-watcher"), reg, *hashringsFile, *refreshInterval)
+			// Routers have no gRPC server, so they must not be members of a hashring.
+			var routerEndpoint string
+			if mode == receive.RouterOnly {
+				routerEndpoint = *local
+			}
+			cw, err = receive.NewConfigWatcher(log.With(logger, "component", "config-watcher"), reg, *hashringsFile, *refreshInterval, routerEndpoint)
+			if err != nil {
+				return err
+			}
+			hd = receive.NewHashringDiscovery(
+				log.With(logger, "component", "hashring-discovery"),
+				reg,
+				dns.ResolverType(*hashringsDNSResolver),
+				time.Duration(*hashringsDNSInterval),
+				*hashringsSDInterval,
+				time.Duration(*hashringsDebounce),
+			)
+		}
+
+		tenantsContentYaml, err := tenantsConf.Content()
+		if err != nil {
+			return errors.Wrap(err, "get content of tenants configuration")
+		}
+		var tenantsCfg *receive.TenantsConfig
+		if len(tenantsContentYaml) > 0 {
+			tenantsCfg, err = receive.ParseTenantsConfig(tenantsContentYaml)
 			if err != nil {
 				return err
 			}
 		}
 
+		var hq *receive.HandoffQueue
+		if *handoffDir != "" {
+			if !mode.Routes() || *replicationFactor < 2 {
+				level.Warn(logger).Log("msg", "hinted handoff requires routing with replication; ignoring --receive.hinted-handoff-dir")
+			} else {
+				hq, err = receive.NewHandoffQueue(
+					log.With(logger, "component", "hinted-handoff"),
+					reg,
+					*handoffDir,
+					int64(*handoffMaxSize),
+					time.Duration(*handoffMaxAge),
+					time.Duration(*handoffRetryInterval),
+				)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		compression, err := receive.ParseCompression(*forwardCompression)
+		if err != nil {
+			return err
+		}
+
 		tsdbOpts := &tsdb.Options{
 			MinBlockDuration:  *tsdbMinBlockDuration,
 			MaxBlockDuration:  *tsdbMaxBlockDuration,
@@ -144,15 +258,25 @@ func registerReceive(m map[string]setupFunc, app *kingpin.Application) {
 			*dataDir,
 			objStoreConfig,
 			tsdbOpts,
+			tenantsCfg,
+			time.Duration(*tenantIdleTimeout),
+			*walOnly,
 			*ignoreBlockSize,
 			lset,
 			cw,
+			hd,
 			*local,
 			*tenantHeader,
 			*defaultTenantID,
 			*tenantLabelName,
 			*replicaHeader,
 			*replicationFactor,
+			hq,
+			time.Duration(*hashringsTransition),
+			time.Duration(*forwardTimeout),
+			compression,
+			*maxConcurrentWrites,
+			mode,
 			comp,
 		)
 	}
@@ -181,19 +305,30 @@ func runReceive(
 	dataDir string,
 	objStoreConfig *extflag.PathOrContent,
 	tsdbOpts *tsdb.Options,
+	tenantsCfg *receive.TenantsConfig,
+	tenantIdleTimeout time.Duration,
+	walOnly bool,
 	ignoreBlockSize bool,
 	lset labels.Labels,
 	cw *receive.ConfigWatcher,
+	hd *receive.HashringDiscovery,
 	endpoint string,
 	tenantHeader string,
 	defaultTenantID string,
 	tenantLabelName string,
 	replicaHeader string,
 	replicationFactor uint64,
+	hq *receive.HandoffQueue,
+	hashringsTransition time.Duration,
+	forwardTimeout time.Duration,
+	forwardCompression storepb.Compression,
+	maxConcurrentWrites int,
+	mode receive.ReceiverMode,
 	comp component.SourceStoreAPI,
 ) error {
 	logger = log.With(logger, "component", "receive")
 	level.Warn(logger).Log("msg", "setting up receive; the Thanos receive component is EXPERIMENTAL, it may break significantly without notice")
+	level.Info(logger).Log("msg", "running receive", "mode", mode)
 
 	rwTLSConfig, err := tls.NewServerConfig(log.With(logger, "protocol", "HTTP"), rwServerCert, rwServerKey, rwServerClientCA)
 	if err != nil {
@@ -210,7 +345,15 @@ func runReceive(
 		return err
 	}
 	upload := len(confContentYaml) > 0
-	if upload {
+	if upload && !mode.Ingests() {
+		level.Warn(logger).Log("msg", "router holds no TSDB; ignoring the bucket configuration")
+		upload = false
+	}
+	if walOnly && mode.Ingests() && !upload {
+		return errors.New("WAL-only mode requires a bucket configuration to ship blocks to")
+	}
+	// Blocks of WAL-only TSDBs are never compacted.
+	if upload && !walOnly {
 		if tsdbOpts.MinBlockDuration != tsdbOpts.MaxBlockDuration {
 			if !ignoreBlockSize {
 				return errors.Errorf("found that TSDB Max time is %s and Min time is %s. "+
@@ -218,6 +361,15 @@ func runReceive(
 			}
 			level.Warn(logger).Log("msg", "flag to ignore min/max block duration flags differing is being used. If the upload of a 2h block fails and a tsdb compaction happens that block may be missing from your Thanos bucket storage.")
 		}
+		if tenantsCfg != nil {
+			for _, tc := range tenantsCfg.Tenants {
+				opts := tenantsCfg.TSDBOptions(tc.Tenant, tsdbOpts)
+				if opts.MinBlockDuration != opts.MaxBlockDuration && !ignoreBlockSize {
+					return errors.Errorf("found that TSDB Max time is %s and Min time is %s for tenant %s. "+
+						"Compaction needs to be disabled (min_block_duration = max_block_duration)", opts.MaxBlockDuration, opts.MinBlockDuration, tc.Tenant)
+				}
+			}
+		}
 		// The background shipper continuously scans the data directory and uploads
 		// new blocks to object storage service.
 		bkt, err = client.NewBucket(logger, confContentYaml, reg, comp.String())
@@ -228,34 +380,49 @@ func runReceive(
 		level.Info(logger).Log("msg", "no supported bucket was configured, uploads will be disabled")
 	}
 
-	// TODO(brancz): remove after a couple of versions
-	// Migrate non-multi-tsdb capable storage to multi-tsdb disk layout.
-	if err := migrateLegacyStorage(logger, dataDir, defaultTenantID); err != nil {
-		return errors.Wrapf(err, "migrate legacy storage in %v to default tenant %v", dataDir, defaultTenantID)
-	}
-
-	dbs := receive.NewMultiTSDB(
-		dataDir,
-		logger,
-		reg,
-		tsdbOpts,
-		lset,
-		tenantLabelName,
-		bkt,
+	var (
+		dbs    *receive.MultiTSDB
+		writer *receive.Writer
 	)
-	writer := receive.NewWriter(log.With(logger, "component", "receive-writer"), dbs)
+	if mode.Ingests() {
+		// TODO(brancz): remove after a couple of versions
+		// Migrate non-multi-tsdb capable storage to multi-tsdb disk layout.
+		if err := migrateLegacyStorage(logger, dataDir, defaultTenantID); err != nil {
+			return errors.Wrapf(err, "migrate legacy storage in %v to default tenant %v", dataDir, defaultTenantID)
+		}
+
+		dbs = receive.NewMultiTSDB(
+			dataDir,
+			logger,
+			reg,
+			tsdbOpts,
+			tenantsCfg,
+			tenantIdleTimeout,
+			walOnly,
+			lset,
+			tenantLabelName,
+			bkt,
+		)
+		writer = receive.NewWriter(log.With(logger, "component", "receive-writer"), dbs)
+	}
 	webHandler := receive.NewHandler(log.With(logger, "component", "receive-handler"), &receive.Options{
-		Writer:            writer,
-		ListenAddress:     rwAddress,
-		Registry:          reg,
-		Endpoint:          endpoint,
-		TenantHeader:      tenantHeader,
-		DefaultTenantID:   defaultTenantID,
-		ReplicaHeader:     replicaHeader,
-		ReplicationFactor: replicationFactor,
-		Tracer:            tracer,
-		TLSConfig:         rwTLSConfig,
-		DialOpts:          dialOpts,
+		Writer:              writer,
+		ListenAddress:       rwAddress,
+		Registry:            reg,
+		Endpoint:            endpoint,
+		TenantHeader:        tenantHeader,
+		DefaultTenantID:     defaultTenantID,
+		ReplicaHeader:       replicaHeader,
+		ReplicationFactor:   replicationFactor,
+		Tracer:              tracer,
+		TLSConfig:           rwTLSConfig,
+		DialOpts:            dialOpts,
+		ReceiverMode:        mode,
+		Handoff:             hq,
+		HashringTransition:  hashringsTransition,
+		ForwardTimeout:      forwardTimeout,
+		ForwardCompression:  forwardCompression,
+		MaxConcurrentWrites: maxConcurrentWrites,
 	})
 
 	grpcProbe := prober.NewGRPC()
@@ -278,8 +445,8 @@ func runReceive(
 	// uploadDone signals when uploading has finished.
 	uploadDone := make(chan struct{}, 1)
 
-	level.Debug(logger).Log("msg", "setting up tsdb")
-	{
+	if mode.Ingests() {
+		level.Debug(logger).Log("msg", "setting up tsdb")
 		// TSDB.
 		cancel := make(chan struct{})
 		g.Add(func() error {
@@ -324,8 +491,8 @@ func runReceive(
 		})
 	}
 
-	level.Debug(logger).Log("msg", "setting up hashring")
-	{
+	if mode.Routes() {
+		level.Debug(logger).Log("msg", "setting up hashring")
 		// Note: the hashring configuration watcher
 		// is the sender and thus closes the chan.
 		// In the single-node case, which has no configuration
@@ -341,7 +508,7 @@ func runReceive(
 
 			ctx, cancel := context.WithCancel(context.Background())
 			g.Add(func() error {
-				return receive.HashringFromConfig(ctx, updates, cw)
+				return receive.HashringFromConfig(ctx, updates, cw, hd)
 			}, func(error) {
 				cancel()
 			})
@@ -367,6 +534,12 @@ func runReceive(
 						return nil
 					}
 					webHandler.Hashring(h)
+					if !mode.Ingests() {
+						// Routers hold no TSDB, so they are ready as soon as they have a hashring.
+						statusProber.Ready()
+						level.Info(logger).Log("msg", "hashring has changed; router is ready to receive web requests")
+						continue
+					}
 					msg := "hashring has changed; server is not ready to receive web requests."
 					statusProber.NotReady(errors.New(msg))
 					level.Info(logger).Log("msg", msg)
@@ -379,6 +552,17 @@ func runReceive(
 			close(cancel)
 		},
 		)
+	} else {
+		// Ingestors have no hashring, so the TSDB is opened once.
+		cancel := make(chan struct{})
+		g.Add(func() error {
+			defer close(updateDB)
+			updateDB <- struct{}{}
+			<-cancel
+			return nil
+		}, func(error) {
+			close(cancel)
+		})
 	}
 
 	level.Debug(logger).Log("msg", "setting up http server")
@@ -397,8 +581,8 @@ func runReceive(
 		srv.Shutdown(err)
 	})
 
-	level.Debug(logger).Log("msg", "setting up grpc server")
-	{
+	if mode.Ingests() {
+		level.Debug(logger).Log("msg", "setting up grpc server")
 		var s *grpcserver.Server
 		startGRPC := make(chan struct{})
 		g.Add(func() error {
@@ -449,8 +633,9 @@ func runReceive(
 		}, func(error) {})
 	}
 
-	level.Debug(logger).Log("msg", "setting up receive http handler")
-	{
+	// Ingestors accept only write requests routed over gRPC.
+	if mode.Routes() {
+		level.Debug(logger).Log("msg", "setting up receive http handler")
 		g.Add(
 			func() error {
 				return errors.Wrap(webHandler.Run(), "error starting web server")
@@ -461,6 +646,31 @@ func runReceive(
 		)
 	}
 
+	if mode.Ingests() && (tenantIdleTimeout > 0 || tenantsCfg != nil) {
+		level.Debug(logger).Log("msg", "setting up eviction of idle tenants")
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return runutil.Repeat(time.Minute, ctx.Done(), func() error {
+				if err := dbs.EvictIdle(ctx); err != nil {
+					level.Warn(logger).Log("msg", "failed to evict idle tenants", "err", err)
+				}
+				return nil
+			})
+		}, func(error) {
+			cancel()
+		})
+	}
+
+	if hq != nil {
+		level.Debug(logger).Log("msg", "setting up hinted handoff")
+		ctx, cancel := context.WithCancel(context.Background())
+		g.Add(func() error {
+			return hq.Run(ctx, webHandler.Replay)
+		}, func(error) {
+			cancel()
+		})
+	}
+
 	if upload {
 		level.Debug(logger).Log("msg", "upload enabled")
 		if err := dbs.Sync(context.Background()); err != nil {
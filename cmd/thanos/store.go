@@ -64,6 +64,10 @@ func registerStore(m map[string]setupFunc, app *kingpin.Application) {
 
 	maxConcurrent := cmd.Flag("store.grpc.series-max-concurrency", "Maximum number of concurrent Series calls.").Default("20").Int()
 
+	quarantineThreshold := cmd.Flag("store.quarantine.corruption-threshold", "Number of requests failing because of corrupted chunks or index entries of a block (checksum mismatch, truncated data) after which the block is no longer served "+
+		"and a no-serve-mark.json is uploaded to the block directory, so that no Store Gateway loads it anymore until the mark is removed. Requires write access to the bucket. 0 disables quarantine.").
+		Default("0").Int64()
+
 	objStoreConfig := regCommonObjStoreFlags(cmd, "", true)
 
 	syncInterval := cmd.Flag("sync-block-duration", "Repeat interval for syncing the blocks between local and remote view.").
@@ -72,6 +76,10 @@ func registerStore(m map[string]setupFunc, app *kingpin.Application) {
 	blockSyncConcurrency := cmd.Flag("block-sync-concurrency", "Number of goroutines to use when constructing index-cache.json blocks from object storage.").
 		Default("20").Int()
 
+	bucketIndexMaxStaleness := modelDuration(cmd.Flag("bucket-index.max-staleness", "If non-zero, blocks are synced by reading the bucket index, written by the compactor with --bucket-index.update, instead of listing the bucket and reading every meta.json. "+
+		"The bucket index is used only if it was updated within this duration, otherwise the bucket is listed. 0 disables the bucket index.").
+		Default("0s"))
+
 	minTime := model.TimeOrDuration(cmd.Flag("min-time", "Start of time range limit to serve. Thanos Store will serve only metrics, which happened later than this value. Option can be a constant time in RFC3339 format or time duration relative to current time, such as -1d or 2h45m. Valid duration units are ms, s, m, h, d, w, y.").
 		Default("0000-01-01T00:00:00Z"))
 
@@ -136,6 +144,7 @@ func registerStore(m map[string]setupFunc, app *kingpin.Application) {
 			debugLogging,
 			*syncInterval,
 			*blockSyncConcurrency,
+			time.Duration(*bucketIndexMaxStaleness),
 			&store.FilterConfig{
 				MinTime: *minTime,
 				MaxTime: *maxTime,
@@ -149,6 +158,7 @@ func registerStore(m map[string]setupFunc, app *kingpin.Application) {
 			*webExternalPrefix,
 			*webPrefixHeaderName,
 			*postingOffsetsInMemSampling,
+			*quarantineThreshold,
 		)
 	}
 }
@@ -172,6 +182,7 @@ func runStore(
 	verbose bool,
 	syncInterval time.Duration,
 	blockSyncConcurrency int,
+	bucketIndexMaxStaleness time.Duration,
 	filterConf *store.FilterConfig,
 	selectorRelabelConf *extflag.PathOrContent,
 	advertiseCompatibilityLabel, disableIndexHeader, enablePostingsCompression bool,
@@ -179,6 +190,7 @@ func runStore(
 	ignoreDeletionMarksDelay time.Duration,
 	externalPrefix, prefixHeader string,
 	postingOffsetsInMemSampling int,
+	quarantineThreshold int64,
 ) error {
 	grpcProbe := prober.NewGRPC()
 	httpProbe := prober.NewHTTP()
@@ -252,17 +264,19 @@ func runStore(
 	}
 
 	ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(logger, bkt, ignoreDeletionMarksDelay)
-	metaFetcher, err := block.NewMetaFetcher(logger, fetcherConcurrency, bkt, dataDir, extprom.WrapRegistererWithPrefix("thanos_", reg),
+	baseMetaFetcher, err := block.NewBaseFetcherWithBucketIndex(logger, fetcherConcurrency, bkt, dataDir, bucketIndexMaxStaleness, extprom.WrapRegistererWithPrefix("thanos_", reg))
+	if err != nil {
+		return errors.Wrap(err, "meta fetcher")
+	}
+	metaFetcher := baseMetaFetcher.NewMetaFetcher(extprom.WrapRegistererWithPrefix("thanos_", reg),
 		[]block.MetadataFilter{
 			block.NewTimePartitionMetaFilter(filterConf.MinTime, filterConf.MaxTime),
 			block.NewLabelShardedMetaFilter(relabelConfig),
 			block.NewConsistencyDelayMetaFilter(logger, consistencyDelay, extprom.WrapRegistererWithPrefix("thanos_", reg)),
 			ignoreDeletionMarkFilter,
+			block.NewNoServeMarkFilter(logger, bkt, true),
 			block.NewDeduplicateFilter(),
 		}, nil)
-	if err != nil {
-		return errors.Wrap(err, "meta fetcher")
-	}
 
 	if !disableIndexHeader {
 		level.Info(logger).Log("msg", "index-header instead of index-cache.json enabled")
@@ -285,6 +299,10 @@ func runStore(
 		enablePostingsCompression,
 		postingOffsetsInMemSampling,
 		false,
+		&store.QuarantineConfig{
+			CorruptionThreshold: quarantineThreshold,
+			Bucket:              bkt,
+		},
 	)
 	if err != nil {
 		return errors.Wrap(err, "create object storage store")
@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
 	"sort"
 	"strconv"
 	"strings"
@@ -22,6 +23,7 @@ import (
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/route"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/thanos-io/thanos/pkg/block"
@@ -75,6 +77,7 @@ func registerBucket(m map[string]setupFunc, app *kingpin.CmdClause, pre string)
 	registerBucketWeb(m, cmd, pre, objStoreConfig)
 	registerBucketReplicate(m, cmd, pre, objStoreConfig)
 	registerBucketDownsample(m, cmd, pre, objStoreConfig)
+	registerBucketMark(m, cmd, pre, objStoreConfig)
 }
 
 func registerBucketVerify(m map[string]setupFunc, root *kingpin.CmdClause, name string, objStoreConfig *extflag.PathOrContent) {
@@ -271,8 +274,13 @@ func registerBucketInspect(m map[string]setupFunc, root *kingpin.CmdClause, name
 	sortBy := cmd.Flag("sort-by", "Sort by columns. It's also possible to sort by multiple columns, e.g. '--sort-by FROM --sort-by UNTIL'. I.e., if the 'FROM' value is equal the rows are then further sorted by the 'UNTIL' value.").
 		Default("FROM", "UNTIL").Enums(inspectColumns...)
 	timeout := cmd.Flag("timeout", "Timeout to download metadata from remote storage").Default("5m").Duration()
+	downsamplingLevels := regDownsamplingLevelsFlag(cmd)
 
 	m[name+" inspect"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		levels, err := downsample.ParseLevels(*downsamplingLevels)
+		if err != nil {
+			return errors.Wrap(err, "parse downsampling levels")
+		}
 
 		// Parse selector.
 		selectorLabels, err := parseFlagLabels(*selector)
@@ -314,7 +322,7 @@ func registerBucketInspect(m map[string]setupFunc, root *kingpin.CmdClause, name
 			blockMetas = append(blockMetas, meta)
 		}
 
-		return printTable(blockMetas, selectorLabels, *sortBy)
+		return printTable(blockMetas, selectorLabels, *sortBy, levels)
 	}
 }
 
@@ -370,11 +378,15 @@ func registerBucketWeb(m map[string]setupFunc, root *kingpin.CmdClause, name str
 		}
 
 		// TODO(bwplotka): Allow Bucket UI to visualize the state of block as well.
-		fetcher, err := block.NewMetaFetcher(logger, fetcherConcurrency, bkt, "", extprom.WrapRegistererWithPrefix(extpromPrefix, reg), nil, nil)
+		noServeMarkFilter := block.NewNoServeMarkFilter(logger, bkt, false)
+		fetcher, err := block.NewMetaFetcher(logger, fetcherConcurrency, bkt, "", extprom.WrapRegistererWithPrefix(extpromPrefix, reg), []block.MetadataFilter{noServeMarkFilter}, nil)
 		if err != nil {
 			return err
 		}
-		fetcher.UpdateOnChange(bucketUI.Set)
+		fetcher.UpdateOnChange(func(blocks []metadata.Meta, err error) {
+			bucketUI.SetNoServeMarks(noServeMarkFilter.NoServeMarkBlocks())
+			bucketUI.Set(blocks, err)
+		})
 
 		ctx, cancel := context.WithCancel(context.Background())
 		g.Add(func() error {
@@ -411,12 +423,18 @@ func registerBucketWeb(m map[string]setupFunc, root *kingpin.CmdClause, name str
 	}
 }
 
-// Provide a list of resolution, can not use Enum directly, since string does not implement int64 function.
-func listResLevel() []string {
-	return []string{
-		strconv.FormatInt(downsample.ResLevel0, 10),
-		strconv.FormatInt(downsample.ResLevel1, 10),
-		strconv.FormatInt(downsample.ResLevel2, 10)}
+// Provide a list of resolutions of the given downsampling levels, can not use Enum directly, since string does not
+// implement int64 function. The default levels are listed if the levels are invalid.
+func listResLevel(levelSpecs []string) []string {
+	levels, err := downsample.ParseLevels(levelSpecs)
+	if err != nil {
+		levels = downsample.DefaultLevels
+	}
+	var res []string
+	for _, r := range levels.Resolutions() {
+		res = append(res, strconv.FormatInt(r, 10))
+	}
+	return res
 }
 
 func registerBucketReplicate(m map[string]setupFunc, root *kingpin.CmdClause, name string, objStoreConfig *extflag.PathOrContent) {
@@ -424,13 +442,27 @@ func registerBucketReplicate(m map[string]setupFunc, root *kingpin.CmdClause, na
 	httpBindAddr, httpGracePeriod := regHTTPFlags(cmd)
 	toObjStoreConfig := regCommonObjStoreFlags(cmd, "-to", false, "The object storage which replicate data to.")
 	// TODO(bwplotka): Allow to replicate many resolution levels.
-	resolution := cmd.Flag("resolution", "Only blocks with this resolution will be replicated.").Default(strconv.FormatInt(downsample.ResLevel0, 10)).HintAction(listResLevel).Int64()
+	downsamplingLevels := regDownsamplingLevelsFlag(cmd)
+	resolution := cmd.Flag("resolution", "Only blocks with this resolution (in milliseconds) will be replicated. Must be raw (0) or the resolution of one of the downsampling levels.").
+		Default(strconv.FormatInt(downsample.ResLevel0, 10)).HintAction(func() []string { return listResLevel(*downsamplingLevels) }).Int64()
 	// TODO(bwplotka): Allow to replicate many compaction levels.
 	compaction := cmd.Flag("compaction", "Only blocks with this compaction level will be replicated.").Default("1").Int()
 	matcherStrs := cmd.Flag("matcher", "Only blocks whose external labels exactly match this matcher will be replicated.").PlaceHolder("key=\"value\"").Strings()
 	singleRun := cmd.Flag("single-run", "Run replication only one time, then exit.").Default("false").Bool()
 
 	m[name+" replicate"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		levels, err := downsample.ParseLevels(*downsamplingLevels)
+		if err != nil {
+			return errors.Wrap(err, "parse downsampling levels")
+		}
+		known := false
+		for _, r := range levels.Resolutions() {
+			known = known || r == *resolution
+		}
+		if !known {
+			return errors.Errorf("resolution %d is not raw or the resolution of a downsampling level", *resolution)
+		}
+
 		matchers, err := replicate.ParseFlagMatchers(*matcherStrs)
 		if err != nil {
 			return errors.Wrap(err, "parse block label matchers")
@@ -463,12 +495,98 @@ func registerBucketDownsample(m map[string]setupFunc, root *kingpin.CmdClause, n
 	dataDir := cmd.Flag("data-dir", "Data directory in which to cache blocks and process downsamplings.").
 		Default("./data").String()
 
+	downsamplingLevels := regDownsamplingLevelsFlag(cmd)
+
 	m[name+" "+comp.String()] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, tracer opentracing.Tracer, _ <-chan struct{}, _ bool) error {
-		return RunDownsample(g, logger, reg, *httpAddr, time.Duration(*httpGracePeriod), *dataDir, objStoreConfig, comp)
+		levels, err := downsample.ParseLevels(*downsamplingLevels)
+		if err != nil {
+			return errors.Wrap(err, "parse downsampling levels")
+		}
+		return RunDownsample(g, logger, reg, *httpAddr, time.Duration(*httpGracePeriod), *dataDir, objStoreConfig, levels, comp)
+	}
+}
+
+func registerBucketMark(m map[string]setupFunc, root *kingpin.CmdClause, name string, objStoreConfig *extflag.PathOrContent) {
+	cmd := root.Command("mark", "Mark blocks for deletion or no compaction, or remove such markers")
+	blockIDs := cmd.Flag("id", "ID (ULID) of the blocks to be marked (repeated flag).").Required().Strings()
+	marker := cmd.Flag("marker", "Marker to be put or removed.").Required().Enum(metadata.NoCompactMarkFilename, metadata.DeletionMarkFilename)
+	details := cmd.Flag("details", "Human readable details of the reason for marking the blocks, stored in the marker. Required unless the marker is removed.").String()
+	remove := cmd.Flag("remove", "Remove the marker from the blocks instead of putting it.").Default("false").Bool()
+
+	m[name+" mark"] = func(g *run.Group, logger log.Logger, reg *prometheus.Registry, _ opentracing.Tracer, _ <-chan struct{}, _ bool) error {
+		var ids []ulid.ULID
+		for _, id := range *blockIDs {
+			u, err := ulid.Parse(id)
+			if err != nil {
+				return errors.Errorf("block.id is not a valid ULID, got: %v", id)
+			}
+			ids = append(ids, u)
+		}
+		if !*remove && *details == "" {
+			return errors.New("details are required when marking blocks")
+		}
+
+		confContentYaml, err := objStoreConfig.Content()
+		if err != nil {
+			return err
+		}
+
+		bkt, err := client.NewBucket(logger, confContentYaml, reg, name)
+		if err != nil {
+			return err
+		}
+
+		// Dummy actor to immediately kill the group after the run function returns.
+		g.Add(func() error { return nil }, func(error) {})
+
+		defer runutil.CloseWithLogOnErr(logger, bkt, "bucket client")
+
+		markedForNoCompact := promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_bucket_tool_blocks_marked_for_no_compact_total",
+			Help: "Total number of blocks marked for no compaction by the bucket mark tool.",
+		})
+		markedForDeletion := promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_bucket_tool_blocks_marked_for_deletion_total",
+			Help: "Total number of blocks marked for deletion by the bucket mark tool.",
+		})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+		defer cancel()
+
+		for _, id := range ids {
+			if *remove {
+				if err := block.RemoveMark(ctx, logger, bkt, id, *marker); err != nil {
+					return errors.Wrapf(err, "remove %s from block %s", *marker, id)
+				}
+				continue
+			}
+
+			// Make sure the block exists, so no marker is put into an empty directory by a mistyped ID.
+			ok, err := bkt.Exists(ctx, path.Join(id.String(), block.MetaFilename))
+			if err != nil {
+				return errors.Wrapf(err, "check meta of block %s", id)
+			}
+			if !ok {
+				return errors.Errorf("block %s not found", id)
+			}
+
+			switch *marker {
+			case metadata.NoCompactMarkFilename:
+				err = block.MarkForNoCompact(ctx, logger, bkt, id, metadata.ManualNoCompactReason, *details, markedForNoCompact)
+			case metadata.DeletionMarkFilename:
+				level.Info(logger).Log("msg", "marking block for deletion", "block", id, "details", *details)
+				err = block.MarkForDeletion(ctx, logger, bkt, id, markedForDeletion)
+			}
+			if err != nil {
+				return errors.Wrapf(err, "mark block %s", id)
+			}
+		}
+		level.Info(logger).Log("msg", "marking done", "marker", *marker, "remove", *remove, "blocks", len(ids))
+		return nil
 	}
 }
 
-func printTable(blockMetas []*metadata.Meta, selectorLabels labels.Labels, sortBy []string) error {
+func printTable(blockMetas []*metadata.Meta, selectorLabels labels.Labels, sortBy []string, levels downsample.Levels) error {
 	header := inspectColumns
 
 	var lines [][]string
@@ -482,7 +600,7 @@ func printTable(blockMetas []*metadata.Meta, selectorLabels labels.Labels, sortB
 		timeRange := time.Duration((blockMeta.MaxTime - blockMeta.MinTime) * int64(time.Millisecond))
 
 		untilDown := "-"
-		if until, err := compact.UntilNextDownsampling(blockMeta); err == nil {
+		if until, err := compact.UntilNextDownsampling(blockMeta, levels); err == nil {
 			untilDown = until.String()
 		}
 		var labels []string
@@ -42,8 +42,55 @@ const (
 
 	// DebugMetas is a directory for debug meta files that happen in the past. Useful for debugging.
 	DebugMetas = "debug/metas"
+
+	// MarkersDir is a directory at the root of the bucket holding a copy of the marks of all the blocks, so that
+	// new marks can be found by listing a single directory instead of reading the marks of every block.
+	MarkersDir = "markers"
 )
 
+// DeletionMarkGlobalPath returns the path of the copy of the deletion mark of the block in MarkersDir.
+func DeletionMarkGlobalPath(id ulid.ULID) string {
+	return globalMarkPath(id, metadata.DeletionMarkFilename)
+}
+
+// NoServeMarkGlobalPath returns the path of the copy of the no-serve mark of the block in MarkersDir.
+func NoServeMarkGlobalPath(id ulid.ULID) string {
+	return globalMarkPath(id, metadata.NoServeMarkFilename)
+}
+
+// NoCompactMarkGlobalPath returns the path of the copy of the no-compact mark of the block in MarkersDir.
+func NoCompactMarkGlobalPath(id ulid.ULID) string {
+	return globalMarkPath(id, metadata.NoCompactMarkFilename)
+}
+
+func globalMarkPath(id ulid.ULID, markFilename string) string {
+	return path.Join(MarkersDir, id.String()+"-"+markFilename)
+}
+
+// parseGlobalMarkPath returns the ULID of the block of a mark in MarkersDir, named <ULID>-<mark filename>.
+func parseGlobalMarkPath(name string) (ulid.ULID, bool) {
+	base := path.Base(name)
+	if len(base) < ulid.EncodedSize {
+		return ulid.ULID{}, false
+	}
+	id, err := ulid.Parse(base[:ulid.EncodedSize])
+	return id, err == nil
+}
+
+// listGlobalMarks returns the IDs of the blocks having a copy of a mark in MarkersDir, as named by globalPath.
+func listGlobalMarks(ctx context.Context, bkt objstore.BucketReader, globalPath func(ulid.ULID) string) (map[ulid.ULID]struct{}, error) {
+	ids := map[ulid.ULID]struct{}{}
+	if err := bkt.Iter(ctx, MarkersDir, func(name string) error {
+		if id, ok := parseGlobalMarkPath(name); ok && name == globalPath(id) {
+			ids[id] = struct{}{}
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "iter markers")
+	}
+	return ids, nil
+}
+
 // Download downloads directory that is mean to be block directory.
 func Download(ctx context.Context, logger log.Logger, bucket objstore.Bucket, id ulid.ULID, dst string) error {
 	if err := objstore.DownloadDir(ctx, logger, bucket, id.String(), dst); err != nil {
@@ -153,11 +200,107 @@ func MarkForDeletion(ctx context.Context, logger log.Logger, bkt objstore.Bucket
 	if err := bkt.Upload(ctx, deletionMarkFile, bytes.NewBuffer(deletionMark)); err != nil {
 		return errors.Wrapf(err, "upload file %s to bucket", deletionMarkFile)
 	}
+	if err := bkt.Upload(ctx, DeletionMarkGlobalPath(id), bytes.NewBuffer(deletionMark)); err != nil {
+		return errors.Wrapf(err, "upload file %s to bucket", DeletionMarkGlobalPath(id))
+	}
 	markedForDeletion.Inc()
 	level.Info(logger).Log("msg", "block has been marked for deletion", "block", id)
 	return nil
 }
 
+// MarkForNoServe creates a file which stores information about why the block must not be served anymore.
+func MarkForNoServe(ctx context.Context, logger log.Logger, bkt objstore.Bucket, id ulid.ULID, reason metadata.NoServeReason, details string, markedForNoServe prometheus.Counter) error {
+	noServeMarkFile := path.Join(id.String(), metadata.NoServeMarkFilename)
+	noServeMarkExists, err := bkt.Exists(ctx, noServeMarkFile)
+	if err != nil {
+		return errors.Wrapf(err, "check exists %s in bucket", noServeMarkFile)
+	}
+	if noServeMarkExists {
+		level.Warn(logger).Log("msg", "requested to mark for no serve, but file already exists", "block", id)
+		return nil
+	}
+
+	noServeMark, err := json.Marshal(metadata.NoServeMark{
+		ID:          id,
+		NoServeTime: time.Now().Unix(),
+		Reason:      reason,
+		Details:     details,
+		Version:     metadata.NoServeMarkVersion1,
+	})
+	if err != nil {
+		return errors.Wrap(err, "json encode no-serve mark")
+	}
+
+	if err := bkt.Upload(ctx, noServeMarkFile, bytes.NewBuffer(noServeMark)); err != nil {
+		return errors.Wrapf(err, "upload file %s to bucket", noServeMarkFile)
+	}
+	if err := bkt.Upload(ctx, NoServeMarkGlobalPath(id), bytes.NewBuffer(noServeMark)); err != nil {
+		return errors.Wrapf(err, "upload file %s to bucket", NoServeMarkGlobalPath(id))
+	}
+	markedForNoServe.Inc()
+	level.Info(logger).Log("msg", "block has been marked for no serve", "block", id, "reason", reason)
+	return nil
+}
+
+// MarkForNoCompact creates a file which stores information about why the block must not be compacted.
+func MarkForNoCompact(ctx context.Context, logger log.Logger, bkt objstore.Bucket, id ulid.ULID, reason metadata.NoCompactReason, details string, markedForNoCompact prometheus.Counter) error {
+	noCompactMarkFile := path.Join(id.String(), metadata.NoCompactMarkFilename)
+	noCompactMarkExists, err := bkt.Exists(ctx, noCompactMarkFile)
+	if err != nil {
+		return errors.Wrapf(err, "check exists %s in bucket", noCompactMarkFile)
+	}
+	if noCompactMarkExists {
+		level.Warn(logger).Log("msg", "requested to mark for no compaction, but file already exists", "block", id)
+		return nil
+	}
+
+	noCompactMark, err := json.Marshal(metadata.NoCompactMark{
+		ID:            id,
+		NoCompactTime: time.Now().Unix(),
+		Reason:        reason,
+		Details:       details,
+		Version:       metadata.NoCompactMarkVersion1,
+	})
+	if err != nil {
+		return errors.Wrap(err, "json encode no-compact mark")
+	}
+
+	if err := bkt.Upload(ctx, noCompactMarkFile, bytes.NewBuffer(noCompactMark)); err != nil {
+		return errors.Wrapf(err, "upload file %s to bucket", noCompactMarkFile)
+	}
+	if err := bkt.Upload(ctx, NoCompactMarkGlobalPath(id), bytes.NewBuffer(noCompactMark)); err != nil {
+		return errors.Wrapf(err, "upload file %s to bucket", NoCompactMarkGlobalPath(id))
+	}
+	markedForNoCompact.Inc()
+	level.Info(logger).Log("msg", "block has been marked for no compaction", "block", id, "reason", reason)
+	return nil
+}
+
+// RemoveMark removes the marker file with the given name, e.g. metadata.NoCompactMarkFilename, from the block,
+// together with its copy in MarkersDir. Removing a marker which does not exist is a noop.
+func RemoveMark(ctx context.Context, logger log.Logger, bkt objstore.Bucket, id ulid.ULID, markFilename string) error {
+	markFile := path.Join(id.String(), markFilename)
+	exists, err := bkt.Exists(ctx, markFile)
+	if err != nil {
+		return errors.Wrapf(err, "check exists %s in bucket", markFile)
+	}
+	if exists {
+		if err := bkt.Delete(ctx, markFile); err != nil {
+			return errors.Wrapf(err, "delete file %s from bucket", markFile)
+		}
+	}
+	// The copy is removed even if the marker is gone, in case removing it was interrupted before.
+	if err := deleteGlobalMark(ctx, logger, bkt, globalMarkPath(id, markFilename)); err != nil {
+		return err
+	}
+	if !exists {
+		level.Warn(logger).Log("msg", "requested to remove marker, but file does not exist", "block", id, "marker", markFilename)
+		return nil
+	}
+	level.Info(logger).Log("msg", "marker has been removed from block", "block", id, "marker", markFilename)
+	return nil
+}
+
 // Delete removes directory that is meant to be block directory.
 // NOTE: Always prefer this method for deleting blocks.
 //  * We have to delete block's files in the certain order (meta.json first)
@@ -179,9 +322,35 @@ func Delete(ctx context.Context, logger log.Logger, bkt objstore.Bucket, id ulid
 	}
 
 	// Delete the bucket, but skip the metaFile as we just deleted that. This is required for eventual object storages (list after write).
-	return deleteDirRec(ctx, logger, bkt, id.String(), func(name string) bool {
+	if err := deleteDirRec(ctx, logger, bkt, id.String(), func(name string) bool {
 		return name == metaFile
-	})
+	}); err != nil {
+		return err
+	}
+
+	// The copies of the marks are deleted last, so they are not lost if deleting the block is interrupted.
+	for _, markFile := range []string{DeletionMarkGlobalPath(id), NoServeMarkGlobalPath(id), NoCompactMarkGlobalPath(id)} {
+		if err := deleteGlobalMark(ctx, logger, bkt, markFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteGlobalMark removes the copy of a mark from MarkersDir, if it exists.
+func deleteGlobalMark(ctx context.Context, logger log.Logger, bkt objstore.Bucket, name string) error {
+	ok, err := bkt.Exists(ctx, name)
+	if err != nil {
+		return errors.Wrapf(err, "stat %s", name)
+	}
+	if !ok {
+		return nil
+	}
+	if err := bkt.Delete(ctx, name); err != nil {
+		return errors.Wrapf(err, "delete %s", name)
+	}
+	level.Debug(logger).Log("msg", "deleted file", "file", name, "bucket", bkt.Name())
+	return nil
 }
 
 // deleteDirRec removes all objects prefixed with dir from the bucket. It skips objects that return true for the passed keep function.
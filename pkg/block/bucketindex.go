@@ -0,0 +1,388 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+const (
+	// BucketIndexFilename is the known filename of the bucket index, stored at the root of the bucket.
+	BucketIndexFilename = "bucket-index.json.gz"
+
+	// BucketIndexVersion1 is the version of the bucket index supported by Thanos.
+	BucketIndexVersion1 = 1
+)
+
+var (
+	// ErrorBucketIndexNotFound is the error when the bucket index is not found.
+	ErrorBucketIndexNotFound = errors.New("bucket index not found")
+	// ErrorBucketIndexCorrupted is the error when the bucket index cannot be decompressed or unmarshalled.
+	ErrorBucketIndexCorrupted = errors.New("bucket index corrupted")
+)
+
+// BucketIndex is a single object holding the metadata of all the blocks in the bucket along with their
+// deletion and no-serve marks. It is written by the compactor, so that other components can sync blocks by reading a
+// single object instead of listing the whole bucket and reading every meta.json.
+type BucketIndex struct {
+	// Version of the file.
+	Version int `json:"version"`
+
+	// UpdatedAt is a unix timestamp of when the index was built.
+	UpdatedAt int64 `json:"updated_at"`
+
+	// Blocks holds the metadata of all the blocks in the bucket, sorted by ULID.
+	Blocks []*metadata.Meta `json:"blocks"`
+
+	// DeletionMarks holds the deletion marks of the blocks in the bucket, sorted by ULID.
+	DeletionMarks []*metadata.DeletionMark `json:"deletion_marks"`
+
+	// NoServeMarks holds the no-serve marks of the quarantined blocks in the bucket, sorted by ULID.
+	NoServeMarks []*metadata.NoServeMark `json:"no_serve_marks,omitempty"`
+}
+
+// NewBucketIndex builds a bucket index updated at the given time.
+func NewBucketIndex(metas map[ulid.ULID]*metadata.Meta, marks map[ulid.ULID]*metadata.DeletionMark, noServeMarks map[ulid.ULID]*metadata.NoServeMark, updatedAt time.Time) *BucketIndex {
+	idx := &BucketIndex{
+		Version:       BucketIndexVersion1,
+		UpdatedAt:     updatedAt.Unix(),
+		Blocks:        make([]*metadata.Meta, 0, len(metas)),
+		DeletionMarks: make([]*metadata.DeletionMark, 0, len(marks)),
+	}
+	for _, m := range metas {
+		idx.Blocks = append(idx.Blocks, m)
+	}
+	for _, m := range marks {
+		idx.DeletionMarks = append(idx.DeletionMarks, m)
+	}
+	for _, m := range noServeMarks {
+		idx.NoServeMarks = append(idx.NoServeMarks, m)
+	}
+	sort.Slice(idx.Blocks, func(i, j int) bool { return idx.Blocks[i].ULID.Compare(idx.Blocks[j].ULID) < 0 })
+	sort.Slice(idx.DeletionMarks, func(i, j int) bool { return idx.DeletionMarks[i].ID.Compare(idx.DeletionMarks[j].ID) < 0 })
+	sort.Slice(idx.NoServeMarks, func(i, j int) bool { return idx.NoServeMarks[i].ID.Compare(idx.NoServeMarks[j].ID) < 0 })
+	return idx
+}
+
+// UpdatedAtTime returns the time the index was built.
+func (idx *BucketIndex) UpdatedAtTime() time.Time {
+	return time.Unix(idx.UpdatedAt, 0)
+}
+
+// Metas returns the metadata of the blocks in the index, by ULID.
+func (idx *BucketIndex) Metas() map[ulid.ULID]*metadata.Meta {
+	metas := make(map[ulid.ULID]*metadata.Meta, len(idx.Blocks))
+	for _, m := range idx.Blocks {
+		metas[m.ULID] = m
+	}
+	return metas
+}
+
+// DeletionMarksByID returns the deletion marks in the index, by block ULID.
+func (idx *BucketIndex) DeletionMarksByID() map[ulid.ULID]*metadata.DeletionMark {
+	marks := make(map[ulid.ULID]*metadata.DeletionMark, len(idx.DeletionMarks))
+	for _, m := range idx.DeletionMarks {
+		marks[m.ID] = m
+	}
+	return marks
+}
+
+// NoServeMarksByID returns the no-serve marks in the index, by block ULID.
+func (idx *BucketIndex) NoServeMarksByID() map[ulid.ULID]*metadata.NoServeMark {
+	marks := make(map[ulid.ULID]*metadata.NoServeMark, len(idx.NoServeMarks))
+	for _, m := range idx.NoServeMarks {
+		marks[m.ID] = m
+	}
+	return marks
+}
+
+// ReadBucketIndex reads the bucket index from the root of the bucket.
+// It returns `ErrorBucketIndexNotFound` and `ErrorBucketIndexCorrupted` sentinel errors in those cases.
+func ReadBucketIndex(ctx context.Context, bkt objstore.InstrumentedBucketReader, logger log.Logger) (*BucketIndex, error) {
+	r, err := bkt.ReaderWithExpectedErrs(bkt.IsObjNotFoundErr).Get(ctx, BucketIndexFilename)
+	if err != nil {
+		if bkt.IsObjNotFoundErr(err) {
+			return nil, ErrorBucketIndexNotFound
+		}
+		return nil, errors.Wrapf(err, "get file: %s", BucketIndexFilename)
+	}
+	defer runutil.CloseWithLogOnErr(logger, r, "close bkt bucket index reader")
+
+	gzr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, errors.Wrapf(ErrorBucketIndexCorrupted, "file: %s; err: %v", BucketIndexFilename, err)
+	}
+	defer runutil.CloseWithLogOnErr(logger, gzr, "close bucket index gzip reader")
+
+	content, err := ioutil.ReadAll(gzr)
+	if err != nil {
+		return nil, errors.Wrapf(ErrorBucketIndexCorrupted, "file: %s; err: %v", BucketIndexFilename, err)
+	}
+
+	idx := &BucketIndex{}
+	if err := json.Unmarshal(content, idx); err != nil {
+		return nil, errors.Wrapf(ErrorBucketIndexCorrupted, "file: %s; err: %v", BucketIndexFilename, err)
+	}
+
+	if idx.Version != BucketIndexVersion1 {
+		return nil, errors.Errorf("unexpected bucket index file version %d", idx.Version)
+	}
+	return idx, nil
+}
+
+// WriteBucketIndex compresses and uploads the bucket index to the root of the bucket.
+func WriteBucketIndex(ctx context.Context, bkt objstore.Bucket, idx *BucketIndex) error {
+	content, err := json.Marshal(idx)
+	if err != nil {
+		return errors.Wrap(err, "json encode bucket index")
+	}
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	if _, err := gzw.Write(content); err != nil {
+		return errors.Wrap(err, "compress bucket index")
+	}
+	if err := gzw.Close(); err != nil {
+		return errors.Wrap(err, "compress bucket index")
+	}
+
+	if err := bkt.Upload(ctx, BucketIndexFilename, &buf); err != nil {
+		return errors.Wrapf(err, "upload file %s to bucket", BucketIndexFilename)
+	}
+	return nil
+}
+
+// BucketIndexUpdater builds the bucket index out of a complete view of the bucket and uploads it.
+// Not go-routine safe.
+type BucketIndexUpdater struct {
+	logger log.Logger
+	bkt    objstore.InstrumentedBucket
+
+	// prev is the last bucket index, whose block metas and marks are reused instead of being read again.
+	prev *BucketIndex
+	// marksSynced is true once the marks of all the blocks were read from their directories and copied to MarkersDir.
+	marksSynced bool
+}
+
+// NewBucketIndexUpdater creates a BucketIndexUpdater.
+func NewBucketIndexUpdater(logger log.Logger, bkt objstore.InstrumentedBucket) *BucketIndexUpdater {
+	return &BucketIndexUpdater{logger: logger, bkt: bkt}
+}
+
+// UpdateIndex builds the bucket index and uploads it. The metas of the blocks, which are immutable, are reused from
+// the previous bucket index, so only the metas of new blocks are read. On the first update, the marks of all the blocks
+// are read from their directories and the ones missing from MarkersDir are copied there. Afterwards MarkersDir is the
+// source of truth: the marks listed there are reused from the previous bucket index and only new marks are read.
+// The index is not updated if the view of the bucket is incomplete.
+func (u *BucketIndexUpdater) UpdateIndex(ctx context.Context) error {
+	start := time.Now()
+
+	if u.prev == nil {
+		prev, err := ReadBucketIndex(ctx, u.bkt, u.logger)
+		if err != nil && err != ErrorBucketIndexNotFound {
+			level.Warn(u.logger).Log("msg", "failed to read previous bucket index; rebuilding it", "err", err)
+		}
+		u.prev = prev
+	}
+
+	metas, err := u.updateMetas(ctx)
+	if err != nil {
+		return err
+	}
+
+	globalMarks, globalNoServeMarks, err := u.listMarkers(ctx)
+	if err != nil {
+		return err
+	}
+
+	var (
+		prevMarks        map[ulid.ULID]*metadata.DeletionMark
+		prevNoServeMarks map[ulid.ULID]*metadata.NoServeMark
+	)
+	if u.prev != nil && u.marksSynced {
+		prevMarks = u.prev.DeletionMarksByID()
+		prevNoServeMarks = u.prev.NoServeMarksByID()
+	}
+
+	marks := make(map[ulid.ULID]*metadata.DeletionMark)
+	for id := range metas {
+		_, global := globalMarks[id]
+		if m, ok := prevMarks[id]; ok && global {
+			marks[id] = m
+			continue
+		}
+		if !global && u.marksSynced {
+			continue
+		}
+		m, err := u.readDeletionMark(ctx, id)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			continue
+		}
+		marks[id] = m
+		if !global {
+			if err := u.uploadGlobalMark(ctx, DeletionMarkGlobalPath(id), m); err != nil {
+				return err
+			}
+		}
+	}
+
+	noServeMarks := make(map[ulid.ULID]*metadata.NoServeMark)
+	for id := range metas {
+		_, global := globalNoServeMarks[id]
+		if m, ok := prevNoServeMarks[id]; ok && global {
+			noServeMarks[id] = m
+			continue
+		}
+		if !global && u.marksSynced {
+			continue
+		}
+		m, err := u.readNoServeMark(ctx, id)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			continue
+		}
+		noServeMarks[id] = m
+		if !global {
+			if err := u.uploadGlobalMark(ctx, NoServeMarkGlobalPath(id), m); err != nil {
+				return err
+			}
+		}
+	}
+	u.marksSynced = true
+
+	idx := NewBucketIndex(metas, marks, noServeMarks, start)
+	if err := WriteBucketIndex(ctx, u.bkt, idx); err != nil {
+		return err
+	}
+	u.prev = idx
+
+	level.Info(u.logger).Log("msg", "updated bucket index", "blocks", len(metas), "deletionMarks", len(marks), "noServeMarks", len(noServeMarks), "duration", time.Since(start).String())
+	return nil
+}
+
+// updateMetas lists the blocks in the bucket and returns their metas, reading only the ones of the blocks not in the
+// previous bucket index. Blocks without meta.json, being uploaded or partially deleted, are skipped.
+func (u *BucketIndexUpdater) updateMetas(ctx context.Context) (map[ulid.ULID]*metadata.Meta, error) {
+	var prevMetas map[ulid.ULID]*metadata.Meta
+	if u.prev != nil {
+		prevMetas = u.prev.Metas()
+	}
+
+	var ids []ulid.ULID
+	if err := u.bkt.Iter(ctx, "", func(name string) error {
+		if id, ok := IsBlockDir(name); ok {
+			ids = append(ids, id)
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "iter bucket")
+	}
+
+	metas := make(map[ulid.ULID]*metadata.Meta, len(ids))
+	for _, id := range ids {
+		if m, ok := prevMetas[id]; ok {
+			metas[id] = m
+			continue
+		}
+
+		m, err := readMeta(ctx, u.bkt, u.logger, id)
+		if errors.Cause(err) == ErrorSyncMetaNotFound {
+			continue
+		}
+		if errors.Cause(err) == ErrorSyncMetaCorrupted {
+			level.Warn(u.logger).Log("msg", "found corrupted meta.json; not adding the block to the bucket index", "block", id, "err", err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		metas[id] = m
+	}
+	return metas, nil
+}
+
+// listMarkers returns the IDs of the blocks with a deletion mark and with a no-serve mark in MarkersDir.
+func (u *BucketIndexUpdater) listMarkers(ctx context.Context) (map[ulid.ULID]struct{}, map[ulid.ULID]struct{}, error) {
+	marks := map[ulid.ULID]struct{}{}
+	noServeMarks := map[ulid.ULID]struct{}{}
+	if err := u.bkt.Iter(ctx, MarkersDir, func(name string) error {
+		id, ok := parseGlobalMarkPath(name)
+		if !ok {
+			return nil
+		}
+		switch name {
+		case DeletionMarkGlobalPath(id):
+			marks[id] = struct{}{}
+		case NoServeMarkGlobalPath(id):
+			noServeMarks[id] = struct{}{}
+		}
+		return nil
+	}); err != nil {
+		return nil, nil, errors.Wrap(err, "iter markers")
+	}
+	return marks, noServeMarks, nil
+}
+
+// readDeletionMark reads the deletion mark of the block from its directory. It returns nil if the block is not marked.
+func (u *BucketIndexUpdater) readDeletionMark(ctx context.Context, id ulid.ULID) (*metadata.DeletionMark, error) {
+	m, err := metadata.ReadDeletionMark(ctx, u.bkt, u.logger, id.String())
+	if err == metadata.ErrorDeletionMarkNotFound {
+		return nil, nil
+	}
+	if errors.Cause(err) == metadata.ErrorUnmarshalDeletionMark {
+		level.Warn(u.logger).Log("msg", "found partial deletion-mark.json; not adding it to the bucket index", "block", id, "err", err)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read deletion mark of block %s", id)
+	}
+	return m, nil
+}
+
+// readNoServeMark reads the no-serve mark of the block from its directory. It returns nil if the block is not marked.
+func (u *BucketIndexUpdater) readNoServeMark(ctx context.Context, id ulid.ULID) (*metadata.NoServeMark, error) {
+	m, err := metadata.ReadNoServeMark(ctx, u.bkt, u.logger, id.String())
+	if err == metadata.ErrorNoServeMarkNotFound {
+		return nil, nil
+	}
+	if errors.Cause(err) == metadata.ErrorUnmarshalNoServeMark {
+		level.Warn(u.logger).Log("msg", "found partial no-serve-mark.json; not adding it to the bucket index", "block", id, "err", err)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "read no-serve mark of block %s", id)
+	}
+	return m, nil
+}
+
+// uploadGlobalMark copies a mark written before MarkersDir was introduced to MarkersDir.
+func (u *BucketIndexUpdater) uploadGlobalMark(ctx context.Context, name string, mark interface{}) error {
+	b, err := json.Marshal(mark)
+	if err != nil {
+		return errors.Wrap(err, "json encode mark")
+	}
+	if err := u.bkt.Upload(ctx, name, bytes.NewBuffer(b)); err != nil {
+		return errors.Wrapf(err, "upload file %s to bucket", name)
+	}
+	return nil
+}
@@ -0,0 +1,180 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package block
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func uploadTestMeta(ctx context.Context, t *testing.T, bkt objstore.Bucket, id ulid.ULID) {
+	var meta metadata.Meta
+	meta.Version = 1
+	meta.ULID = id
+
+	var buf bytes.Buffer
+	testutil.Ok(t, json.NewEncoder(&buf).Encode(&meta))
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(id.String(), metadata.MetaFilename), &buf))
+}
+
+func tsdbBlockMeta(id ulid.ULID) tsdb.BlockMeta {
+	return tsdb.BlockMeta{ULID: id, Version: 1}
+}
+
+func TestBucketIndex_ReadWrite(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+
+	_, err := ReadBucketIndex(ctx, bkt, log.NewNopLogger())
+	testutil.Equals(t, ErrorBucketIndexNotFound, err)
+
+	testutil.Ok(t, bkt.Upload(ctx, BucketIndexFilename, bytes.NewBufferString("not gzipped")))
+	_, err = ReadBucketIndex(ctx, bkt, log.NewNopLogger())
+	testutil.Equals(t, ErrorBucketIndexCorrupted, errors.Cause(err))
+
+	now := time.Unix(1600000000, 0)
+	idx := NewBucketIndex(
+		map[ulid.ULID]*metadata.Meta{
+			ULID(2): {BlockMeta: tsdbBlockMeta(ULID(2))},
+			ULID(1): {BlockMeta: tsdbBlockMeta(ULID(1))},
+		},
+		map[ulid.ULID]*metadata.DeletionMark{
+			ULID(2): {ID: ULID(2), DeletionTime: now.Unix(), Version: metadata.DeletionMarkVersion1},
+		},
+		map[ulid.ULID]*metadata.NoServeMark{
+			ULID(1): {ID: ULID(1), NoServeTime: now.Unix(), Reason: metadata.CorruptedChunksNoServeReason, Version: metadata.NoServeMarkVersion1},
+		},
+		now,
+	)
+	testutil.Equals(t, ULID(1), idx.Blocks[0].ULID)
+	testutil.Equals(t, ULID(2), idx.Blocks[1].ULID)
+	testutil.Ok(t, WriteBucketIndex(ctx, bkt, idx))
+
+	read, err := ReadBucketIndex(ctx, bkt, log.NewNopLogger())
+	testutil.Ok(t, err)
+	testutil.Equals(t, idx, read)
+	testutil.Equals(t, now, read.UpdatedAtTime())
+	testutil.Equals(t, 2, len(read.Metas()))
+	testutil.Equals(t, 1, len(read.DeletionMarksByID()))
+	testutil.Equals(t, 1, len(read.NoServeMarksByID()))
+}
+
+func TestBucketIndexUpdater_UpdateIndex(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+
+	uploadTestMeta(ctx, t, bkt, ULID(1))
+	uploadTestMeta(ctx, t, bkt, ULID(2))
+	testutil.Ok(t, MarkForDeletion(ctx, log.NewNopLogger(), bkt, ULID(2), prometheus.NewCounter(prometheus.CounterOpts{})))
+	testutil.Ok(t, MarkForNoServe(ctx, log.NewNopLogger(), bkt, ULID(1), metadata.CorruptedChunksNoServeReason, "", prometheus.NewCounter(prometheus.CounterOpts{})))
+	// Marks written before MarkersDir was introduced are only in the block directories.
+	testutil.Ok(t, bkt.Delete(ctx, DeletionMarkGlobalPath(ULID(2))))
+
+	u := NewBucketIndexUpdater(log.NewNopLogger(), bkt)
+	testutil.Ok(t, u.UpdateIndex(ctx))
+
+	idx, err := ReadBucketIndex(ctx, bkt, log.NewNopLogger())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(idx.Blocks))
+	testutil.Equals(t, 1, len(idx.DeletionMarks))
+	testutil.Equals(t, ULID(2), idx.DeletionMarks[0].ID)
+	testutil.Equals(t, 1, len(idx.NoServeMarks))
+	testutil.Equals(t, ULID(1), idx.NoServeMarks[0].ID)
+
+	// The metas of known blocks are not read again, only the ones of new blocks and the new marks.
+	testutil.Ok(t, bkt.Upload(ctx, path.Join(ULID(1).String(), MetaFilename), bytes.NewBufferString("not read")))
+	uploadTestMeta(ctx, t, bkt, ULID(3))
+	testutil.Ok(t, MarkForDeletion(ctx, log.NewNopLogger(), bkt, ULID(1), prometheus.NewCounter(prometheus.CounterOpts{})))
+	testutil.Ok(t, Delete(ctx, log.NewNopLogger(), bkt, ULID(2)))
+	testutil.Ok(t, u.UpdateIndex(ctx))
+
+	idx, err = ReadBucketIndex(ctx, bkt, log.NewNopLogger())
+	testutil.Ok(t, err)
+	testutil.Equals(t, []ulid.ULID{ULID(1), ULID(3)}, []ulid.ULID{idx.Blocks[0].ULID, idx.Blocks[1].ULID})
+	testutil.Equals(t, 1, len(idx.DeletionMarks))
+	testutil.Equals(t, ULID(1), idx.DeletionMarks[0].ID)
+	testutil.Equals(t, 1, len(idx.NoServeMarks))
+
+	// A new updater reuses the bucket index in the bucket.
+	testutil.Ok(t, NewBucketIndexUpdater(log.NewNopLogger(), bkt).UpdateIndex(ctx))
+	read, err := ReadBucketIndex(ctx, bkt, log.NewNopLogger())
+	testutil.Ok(t, err)
+	testutil.Equals(t, idx.Blocks, read.Blocks)
+	testutil.Equals(t, idx.DeletionMarks, read.DeletionMarks)
+	testutil.Equals(t, idx.NoServeMarks, read.NoServeMarks)
+
+	// Removed marks are dropped from the index.
+	testutil.Ok(t, RemoveMark(ctx, log.NewNopLogger(), bkt, ULID(1), metadata.NoServeMarkFilename))
+	testutil.Ok(t, u.UpdateIndex(ctx))
+	idx, err = ReadBucketIndex(ctx, bkt, log.NewNopLogger())
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(idx.NoServeMarks))
+	testutil.Equals(t, 1, len(idx.DeletionMarks))
+}
+
+func TestBaseFetcher_FetchFromBucketIndex(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+
+	dir, err := ioutil.TempDir("", "test-bucket-index-fetcher")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	uploadTestMeta(ctx, t, bkt, ULID(1))
+	uploadTestMeta(ctx, t, bkt, ULID(2))
+
+	r := prometheus.NewRegistry()
+	baseFetcher, err := NewBaseFetcherWithBucketIndex(log.NewNopLogger(), 1, bkt, dir, time.Hour, r)
+	testutil.Ok(t, err)
+	ignoreDeletionMarkFilter := NewIgnoreDeletionMarkFilter(log.NewNopLogger(), bkt, 0)
+	fetcher := baseFetcher.NewMetaFetcher(r, []MetadataFilter{ignoreDeletionMarkFilter}, nil)
+
+	// No index yet: the bucket is listed.
+	metas, _, err := fetcher.Fetch(ctx)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(metas))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(baseFetcher.bucketIndexLoads.WithLabelValues(bucketIndexMissing)))
+
+	// The index is the source of truth once written, even if it lags behind the bucket.
+	// Filters read the block marks from the index too.
+	testutil.Ok(t, WriteBucketIndex(ctx, bkt, NewBucketIndex(
+		map[ulid.ULID]*metadata.Meta{
+			ULID(1): {BlockMeta: tsdbBlockMeta(ULID(1))},
+			ULID(3): {BlockMeta: tsdbBlockMeta(ULID(3))},
+		},
+		map[ulid.ULID]*metadata.DeletionMark{
+			ULID(3): {ID: ULID(3), DeletionTime: time.Now().Add(-time.Minute).Unix(), Version: metadata.DeletionMarkVersion1},
+		},
+		nil,
+		time.Now(),
+	)))
+	metas, _, err = fetcher.Fetch(ctx)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(metas))
+	testutil.Equals(t, 1, len(ignoreDeletionMarkFilter.DeletionMarkBlocks()))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(baseFetcher.bucketIndexLoads.WithLabelValues(bucketIndexLoaded)))
+
+	// A stale index is ignored and the bucket is listed again.
+	testutil.Ok(t, WriteBucketIndex(ctx, bkt, NewBucketIndex(nil, nil, nil, time.Now().Add(-2*time.Hour))))
+	metas, _, err = fetcher.Fetch(ctx)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(metas))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(baseFetcher.bucketIndexLoads.WithLabelValues(bucketIndexStale)))
+}
@@ -69,9 +69,17 @@ const (
 	// Blocks that are marked for deletion can be loaded as well. This is done to make sure that we load blocks that are meant to be deleted,
 	// but don't have a replacement block yet.
 	markedForDeletionMeta = "marked-for-deletion"
+	// Blocks that are quarantined because of corrupted data.
+	markedForNoServeMeta = "marked-for-no-serve"
 
 	// Modified label values.
 	replicaRemovedMeta = "replica-label-removed"
+
+	// Bucket index load result label values.
+	bucketIndexLoaded  = "loaded"
+	bucketIndexStale   = "stale"
+	bucketIndexMissing = "missing"
+	bucketIndexFailed  = "failed"
 )
 
 func newFetcherMetrics(reg prometheus.Registerer) *fetcherMetrics {
@@ -110,6 +118,7 @@ func newFetcherMetrics(reg prometheus.Registerer) *fetcherMetrics {
 		[]string{timeExcludedMeta},
 		[]string{duplicateMeta},
 		[]string{markedForDeletionMeta},
+		[]string{markedForNoServeMeta},
 	)
 	m.modified = extprom.NewTxGaugeVec(
 		reg,
@@ -133,6 +142,13 @@ type MetadataFilter interface {
 	Filter(ctx context.Context, metas map[ulid.ULID]*metadata.Meta, synced *extprom.TxGaugeVec) error
 }
 
+// BucketIndexFilter is a MetadataFilter which can read the block marks from the bucket index instead of the bucket.
+// FilterWithBucketIndex is called instead of Filter when the metas were read from the bucket index.
+type BucketIndexFilter interface {
+	MetadataFilter
+	FilterWithBucketIndex(ctx context.Context, metas map[ulid.ULID]*metadata.Meta, idx *BucketIndex, synced *extprom.TxGaugeVec) error
+}
+
 type MetadataModifier interface {
 	Modify(ctx context.Context, metas map[ulid.ULID]*metadata.Meta, modified *extprom.TxGaugeVec) error
 }
@@ -149,10 +165,21 @@ type BaseFetcher struct {
 	cached   map[ulid.ULID]*metadata.Meta
 	syncs    prometheus.Counter
 	g        singleflight.Group
+
+	// If greater than zero, metadata are read from the bucket index as long as it is not older than this.
+	bucketIndexMaxStaleness time.Duration
+	bucketIndexLoads        *prometheus.CounterVec
 }
 
 // NewBaseFetcher constructs BaseFetcher.
 func NewBaseFetcher(logger log.Logger, concurrency int, bkt objstore.InstrumentedBucketReader, dir string, reg prometheus.Registerer) (*BaseFetcher, error) {
+	return NewBaseFetcherWithBucketIndex(logger, concurrency, bkt, dir, 0, reg)
+}
+
+// NewBaseFetcherWithBucketIndex constructs BaseFetcher which reads block metadata from the bucket index written by the compactor,
+// instead of listing the bucket. It falls back to listing the bucket if the bucket index is missing, cannot be read or
+// was updated longer than bucketIndexMaxStaleness ago. Zero bucketIndexMaxStaleness disables the bucket index.
+func NewBaseFetcherWithBucketIndex(logger log.Logger, concurrency int, bkt objstore.InstrumentedBucketReader, dir string, bucketIndexMaxStaleness time.Duration, reg prometheus.Registerer) (*BaseFetcher, error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
@@ -176,6 +203,12 @@ func NewBaseFetcher(logger log.Logger, concurrency int, bkt objstore.Instrumente
 			Name:      "base_syncs_total",
 			Help:      "Total blocks metadata synchronization attempts by base Fetcher",
 		}),
+		bucketIndexMaxStaleness: bucketIndexMaxStaleness,
+		bucketIndexLoads: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Subsystem: fetcherSubSys,
+			Name:      "bucket_index_loads_total",
+			Help:      "Total attempts to load blocks metadata from the bucket index by base Fetcher, by result",
+		}, []string{"result"}),
 	}, nil
 }
 
@@ -236,16 +269,38 @@ func (f *BaseFetcher) loadMeta(ctx context.Context, id ulid.ULID) (*metadata.Met
 		}
 	}
 
-	r, err := f.bkt.ReaderWithExpectedErrs(f.bkt.IsObjNotFoundErr).Get(ctx, metaFile)
-	if f.bkt.IsObjNotFoundErr(err) {
-		// Meta.json was deleted between bkt.Exists and here.
+	// Meta.json might be deleted between bkt.Exists and here.
+	m, err := readMeta(ctx, f.bkt, f.logger, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// Best effort cache in local dir.
+	if f.cacheDir != "" {
+		if err := os.MkdirAll(cachedBlockDir, os.ModePerm); err != nil {
+			level.Warn(f.logger).Log("msg", "best effort mkdir of the meta.json block dir failed; ignoring", "dir", cachedBlockDir, "err", err)
+		}
+
+		if err := metadata.Write(f.logger, cachedBlockDir, m); err != nil {
+			level.Warn(f.logger).Log("msg", "best effort save of the meta.json to local dir failed; ignoring", "dir", cachedBlockDir, "err", err)
+		}
+	}
+	return m, nil
+}
+
+// readMeta reads the meta.json of the block from the bucket.
+// It returns `ErrorSyncMetaNotFound` and `ErrorSyncMetaCorrupted` sentinel errors in those cases.
+func readMeta(ctx context.Context, bkt objstore.InstrumentedBucketReader, logger log.Logger, id ulid.ULID) (*metadata.Meta, error) {
+	metaFile := path.Join(id.String(), MetaFilename)
+	r, err := bkt.ReaderWithExpectedErrs(bkt.IsObjNotFoundErr).Get(ctx, metaFile)
+	if bkt.IsObjNotFoundErr(err) {
 		return nil, errors.Wrapf(ErrorSyncMetaNotFound, "%v", err)
 	}
 	if err != nil {
 		return nil, errors.Wrapf(err, "get meta file: %v", metaFile)
 	}
 
-	defer runutil.CloseWithLogOnErr(f.logger, r, "close bkt meta get")
+	defer runutil.CloseWithLogOnErr(logger, r, "close bkt meta get")
 
 	metaContent, err := ioutil.ReadAll(r)
 	if err != nil {
@@ -260,17 +315,6 @@ func (f *BaseFetcher) loadMeta(ctx context.Context, id ulid.ULID) (*metadata.Met
 	if m.Version != metadata.MetaVersion1 {
 		return nil, errors.Errorf("unexpected meta file: %s version: %d", metaFile, m.Version)
 	}
-
-	// Best effort cache in local dir.
-	if f.cacheDir != "" {
-		if err := os.MkdirAll(cachedBlockDir, os.ModePerm); err != nil {
-			level.Warn(f.logger).Log("msg", "best effort mkdir of the meta.json block dir failed; ignoring", "dir", cachedBlockDir, "err", err)
-		}
-
-		if err := metadata.Write(f.logger, cachedBlockDir, m); err != nil {
-			level.Warn(f.logger).Log("msg", "best effort save of the meta.json to local dir failed; ignoring", "dir", cachedBlockDir, "err", err)
-		}
-	}
 	return m, nil
 }
 
@@ -282,11 +326,58 @@ type response struct {
 
 	noMetas        float64
 	corruptedMetas float64
+
+	// bucketIndex is set only if metas were read from the bucket index.
+	bucketIndex *BucketIndex
+}
+
+// fetchMetadataFromBucketIndex returns the metadata from the bucket index. It returns false
+// if the bucket index cannot be used, in which case the bucket has to be listed.
+func (f *BaseFetcher) fetchMetadataFromBucketIndex(ctx context.Context) (response, bool) {
+	idx, err := ReadBucketIndex(ctx, f.bkt, f.logger)
+	if err != nil {
+		if err == ErrorBucketIndexNotFound {
+			f.bucketIndexLoads.WithLabelValues(bucketIndexMissing).Inc()
+			level.Warn(f.logger).Log("msg", "bucket index not found; falling back to listing the bucket")
+			return response{}, false
+		}
+		f.bucketIndexLoads.WithLabelValues(bucketIndexFailed).Inc()
+		level.Warn(f.logger).Log("msg", "failed to read bucket index; falling back to listing the bucket", "err", err)
+		return response{}, false
+	}
+
+	if age := time.Since(idx.UpdatedAtTime()); age > f.bucketIndexMaxStaleness {
+		f.bucketIndexLoads.WithLabelValues(bucketIndexStale).Inc()
+		level.Warn(f.logger).Log("msg", "bucket index is stale; falling back to listing the bucket", "age", age, "maxStaleness", f.bucketIndexMaxStaleness)
+		return response{}, false
+	}
+	f.bucketIndexLoads.WithLabelValues(bucketIndexLoaded).Inc()
+
+	resp := response{
+		metas:       idx.Metas(),
+		partial:     make(map[ulid.ULID]error),
+		bucketIndex: idx,
+	}
+
+	// Keep the cache up to date, in case the next sync falls back to listing the bucket.
+	cached := make(map[ulid.ULID]*metadata.Meta, len(resp.metas))
+	for id, m := range resp.metas {
+		cached[id] = m
+	}
+	f.cached = cached
+
+	return resp, true
 }
 
 func (f *BaseFetcher) fetchMetadata(ctx context.Context) (interface{}, error) {
 	f.syncs.Inc()
 
+	if f.bucketIndexMaxStaleness > 0 {
+		if resp, ok := f.fetchMetadataFromBucketIndex(ctx); ok {
+			return resp, nil
+		}
+	}
+
 	var (
 		resp = response{
 			metas:   make(map[ulid.ULID]*metadata.Meta),
@@ -427,6 +518,12 @@ func (f *BaseFetcher) fetch(ctx context.Context, metrics *fetcherMetrics, filter
 
 	for _, filter := range filters {
 		// NOTE: filter can update synced metric accordingly to the reason of the exclude.
+		if f, ok := filter.(BucketIndexFilter); ok && resp.bucketIndex != nil {
+			if err := f.FilterWithBucketIndex(ctx, metas, resp.bucketIndex, metrics.synced); err != nil {
+				return nil, nil, errors.Wrap(err, "filter metas")
+			}
+			continue
+		}
 		if err := filter.Filter(ctx, metas, metrics.synced); err != nil {
 			return nil, nil, errors.Wrap(err, "filter metas")
 		}
@@ -558,6 +655,8 @@ func NewDeduplicateFilter() *DeduplicateFilter {
 
 // Filter filters out duplicate blocks that can be formed
 // from two or more overlapping blocks that fully submatches the source blocks of the older blocks.
+// Blocks holding different shards of series of the same sources complement each other, so a block is a duplicate
+// of sharded blocks only if all the shards of its series are present.
 func (f *DeduplicateFilter) Filter(_ context.Context, metas map[ulid.ULID]*metadata.Meta, synced *extprom.TxGaugeVec) error {
 	f.duplicateIDs = f.duplicateIDs[:0]
 
@@ -573,11 +672,29 @@ func (f *DeduplicateFilter) Filter(_ context.Context, metas map[ulid.ULID]*metad
 		wg.Add(1)
 		go func(res int64) {
 			defer wg.Done()
-			f.filterForResolution(NewNode(&metadata.Meta{
-				BlockMeta: tsdb.BlockMeta{
-					ULID: ulid.MustNew(uint64(0), nil),
-				},
-			}), metasByResolution[res], metas, synced)
+
+			metasByShard := make(map[string][]*metadata.Meta)
+			for _, meta := range metasByResolution[res] {
+				shard := meta.Thanos.Shard.String()
+				metasByShard[shard] = append(metasByShard[shard], meta)
+			}
+			for _, shardMetas := range metasByShard {
+				f.filterForResolution(NewNode(&metadata.Meta{
+					BlockMeta: tsdb.BlockMeta{
+						ULID: ulid.MustNew(uint64(0), nil),
+					},
+				}), shardMetas, metas, synced)
+			}
+
+			for _, id := range coveredByShards(metasByResolution[res]) {
+				f.mu.Lock()
+				if metas[id] != nil {
+					f.duplicateIDs = append(f.duplicateIDs, id)
+					synced.WithLabelValues(duplicateMeta).Inc()
+					delete(metas, id)
+				}
+				f.mu.Unlock()
+			}
 		}(res)
 	}
 
@@ -619,14 +736,63 @@ func (f *DeduplicateFilter) DuplicateIDs() []ulid.ULID {
 	return f.duplicateIDs
 }
 
+// coveredByShards returns IDs of blocks whose series are all held by blocks of finer shards, which were
+// compacted from a superset of their sources.
+func coveredByShards(metas []*metadata.Meta) []ulid.ULID {
+	var sharded []*metadata.Meta
+	for _, m := range metas {
+		if m.Thanos.Shard != nil {
+			sharded = append(sharded, m)
+		}
+	}
+	if len(sharded) == 0 {
+		return nil
+	}
+
+	var res []ulid.ULID
+	for _, m := range metas {
+		count := uint64(1)
+		if m.Thanos.Shard != nil {
+			count = m.Thanos.Shard.Count
+		}
+		// Indexes of covering shards by their count.
+		covering := map[uint64]map[uint64]struct{}{}
+		for _, o := range sharded {
+			if o.Thanos.Shard.Count == count || !m.Thanos.Shard.Covers(o.Thanos.Shard) {
+				continue
+			}
+			if !contains(o.Compaction.Sources, m.Compaction.Sources) {
+				continue
+			}
+			if covering[o.Thanos.Shard.Count] == nil {
+				covering[o.Thanos.Shard.Count] = map[uint64]struct{}{}
+			}
+			covering[o.Thanos.Shard.Count][o.Thanos.Shard.Index] = struct{}{}
+		}
+		for c, indexes := range covering {
+			if uint64(len(indexes)) == c/count {
+				res = append(res, m.ULID)
+				break
+			}
+		}
+	}
+	return res
+}
+
 func addNodeBySources(root *Node, add *Node) bool {
 	var rootNode *Node
-	for _, node := range root.Children {
+	for i, node := range root.Children {
 		parentSources := node.Compaction.Sources
 		childSources := add.Compaction.Sources
 
 		// Block exists with same sources, add as child.
 		if contains(parentSources, childSources) && contains(childSources, parentSources) {
+			// Block rewritten from the existing one (e.g. to apply deletion requests) replaces it.
+			if isRewriteOf(add, node) {
+				add.Children = append(add.Children, node)
+				root.Children[i] = add
+				return true
+			}
 			node.Children = append(node.Children, add)
 			return true
 		}
@@ -647,6 +813,15 @@ func addNodeBySources(root *Node, add *Node) bool {
 	return addNodeBySources(rootNode, add)
 }
 
+func isRewriteOf(n, orig *Node) bool {
+	for _, p := range n.Compaction.Parents {
+		if p.ULID == orig.ULID {
+			return true
+		}
+	}
+	return false
+}
+
 func contains(s1 []ulid.ULID, s2 []ulid.ULID) bool {
 	for _, a := range s2 {
 		found := false
@@ -780,11 +955,153 @@ func (f *IgnoreDeletionMarkFilter) Filter(ctx context.Context, metas map[ulid.UL
 		if err != nil {
 			return err
 		}
-		f.deletionMarkMap[id] = deletionMark
-		if time.Since(time.Unix(deletionMark.DeletionTime, 0)).Seconds() > f.delay.Seconds() {
-			synced.WithLabelValues(markedForDeletionMeta).Inc()
-			delete(metas, id)
+		f.filterMarked(id, deletionMark, metas, synced)
+	}
+	return nil
+}
+
+// FilterWithBucketIndex works like Filter, but reads the deletion marks from the bucket index.
+func (f *IgnoreDeletionMarkFilter) FilterWithBucketIndex(_ context.Context, metas map[ulid.ULID]*metadata.Meta, idx *BucketIndex, synced *extprom.TxGaugeVec) error {
+	f.deletionMarkMap = make(map[ulid.ULID]*metadata.DeletionMark)
+
+	marks := idx.DeletionMarksByID()
+	for id := range metas {
+		if deletionMark, ok := marks[id]; ok {
+			f.filterMarked(id, deletionMark, metas, synced)
+		}
+	}
+	return nil
+}
+
+func (f *IgnoreDeletionMarkFilter) filterMarked(id ulid.ULID, deletionMark *metadata.DeletionMark, metas map[ulid.ULID]*metadata.Meta, synced *extprom.TxGaugeVec) {
+	f.deletionMarkMap[id] = deletionMark
+	if time.Since(time.Unix(deletionMark.DeletionTime, 0)).Seconds() > f.delay.Seconds() {
+		synced.WithLabelValues(markedForDeletionMeta).Inc()
+		delete(metas, id)
+	}
+}
+
+// NoServeMarkFilter is a filter that reads the no-serve marks of the blocks and optionally filters out the marked ones.
+// Not go-routine safe.
+type NoServeMarkFilter struct {
+	logger         log.Logger
+	bkt            objstore.InstrumentedBucketReader
+	filterMarked   bool
+	noServeMarkMap map[ulid.ULID]*metadata.NoServeMark
+}
+
+// NewNoServeMarkFilter creates NoServeMarkFilter. If filterMarked is false, marked blocks are only
+// tracked, e.g. to be surfaced in the bucket UI.
+func NewNoServeMarkFilter(logger log.Logger, bkt objstore.InstrumentedBucketReader, filterMarked bool) *NoServeMarkFilter {
+	return &NoServeMarkFilter{
+		logger:       logger,
+		bkt:          bkt,
+		filterMarked: filterMarked,
+	}
+}
+
+// NoServeMarkBlocks returns block ids that were marked for no serve.
+func (f *NoServeMarkFilter) NoServeMarkBlocks() map[ulid.ULID]*metadata.NoServeMark {
+	return f.noServeMarkMap
+}
+
+// Filter filters out blocks that are marked for no serve, if configured to. MarkersDir is listed once, and only
+// the marks of the blocks found there are read.
+func (f *NoServeMarkFilter) Filter(ctx context.Context, metas map[ulid.ULID]*metadata.Meta, synced *extprom.TxGaugeVec) error {
+	f.noServeMarkMap = make(map[ulid.ULID]*metadata.NoServeMark)
+
+	marked, err := listGlobalMarks(ctx, f.bkt, NoServeMarkGlobalPath)
+	if err != nil {
+		return err
+	}
+	for id := range marked {
+		if _, ok := metas[id]; !ok {
+			continue
+		}
+		noServeMark, err := metadata.ReadNoServeMark(ctx, f.bkt, f.logger, id.String())
+		if err == metadata.ErrorNoServeMarkNotFound {
+			continue
+		}
+		if errors.Cause(err) == metadata.ErrorUnmarshalNoServeMark {
+			level.Warn(f.logger).Log("msg", "found partial no-serve-mark.json; if we will see it happening often for the same block, consider manually deleting no-serve-mark.json from the object storage", "block", id, "err", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		f.addMarked(id, noServeMark, metas, synced)
+	}
+	return nil
+}
+
+// FilterWithBucketIndex works like Filter, but reads the no-serve marks from the bucket index.
+func (f *NoServeMarkFilter) FilterWithBucketIndex(_ context.Context, metas map[ulid.ULID]*metadata.Meta, idx *BucketIndex, synced *extprom.TxGaugeVec) error {
+	f.noServeMarkMap = make(map[ulid.ULID]*metadata.NoServeMark)
+
+	marks := idx.NoServeMarksByID()
+	for id := range metas {
+		if noServeMark, ok := marks[id]; ok {
+			f.addMarked(id, noServeMark, metas, synced)
+		}
+	}
+	return nil
+}
+
+func (f *NoServeMarkFilter) addMarked(id ulid.ULID, noServeMark *metadata.NoServeMark, metas map[ulid.ULID]*metadata.Meta, synced *extprom.TxGaugeVec) {
+	f.noServeMarkMap[id] = noServeMark
+	if f.filterMarked {
+		synced.WithLabelValues(markedForNoServeMeta).Inc()
+		delete(metas, id)
+	}
+}
+
+// NoCompactMarkFilter is a filter that reads the no-compact marks of the blocks. Marked blocks are not filtered out,
+// as they are still queried and downsampled, but they are excluded from compaction.
+// Not go-routine safe.
+type NoCompactMarkFilter struct {
+	logger           log.Logger
+	bkt              objstore.InstrumentedBucketReader
+	noCompactMarkMap map[ulid.ULID]*metadata.NoCompactMark
+}
+
+// NewNoCompactMarkFilter creates NoCompactMarkFilter.
+func NewNoCompactMarkFilter(logger log.Logger, bkt objstore.InstrumentedBucketReader) *NoCompactMarkFilter {
+	return &NoCompactMarkFilter{
+		logger: logger,
+		bkt:    bkt,
+	}
+}
+
+// NoCompactMarkedBlocks returns block ids that were marked for no compaction.
+func (f *NoCompactMarkFilter) NoCompactMarkedBlocks() map[ulid.ULID]*metadata.NoCompactMark {
+	return f.noCompactMarkMap
+}
+
+// Filter reads the no-compact marks of the blocks. MarkersDir is listed once, and only the marks of the blocks found
+// there are read.
+func (f *NoCompactMarkFilter) Filter(ctx context.Context, metas map[ulid.ULID]*metadata.Meta, _ *extprom.TxGaugeVec) error {
+	f.noCompactMarkMap = make(map[ulid.ULID]*metadata.NoCompactMark)
+
+	marked, err := listGlobalMarks(ctx, f.bkt, NoCompactMarkGlobalPath)
+	if err != nil {
+		return err
+	}
+	for id := range marked {
+		if _, ok := metas[id]; !ok {
+			continue
+		}
+		noCompactMark, err := metadata.ReadNoCompactMark(ctx, f.bkt, f.logger, id.String())
+		if err == metadata.ErrorNoCompactMarkNotFound {
+			continue
+		}
+		if errors.Cause(err) == metadata.ErrorUnmarshalNoCompactMark {
+			level.Warn(f.logger).Log("msg", "found partial no-compact-mark.json; if we will see it happening often for the same block, consider manually deleting no-compact-mark.json from the object storage", "block", id, "err", err)
+			continue
+		}
+		if err != nil {
+			return err
 		}
+		f.noCompactMarkMap[id] = noCompactMark
 	}
 	return nil
 }
@@ -527,6 +527,8 @@ func TestTimePartitionMetaFilter_Filter(t *testing.T) {
 type sourcesAndResolution struct {
 	sources    []ulid.ULID
 	resolution int64
+	parents    []ulid.ULID
+	shard      *metadata.ThanosShard
 }
 
 func TestDeduplicateFilter_Filter(t *testing.T) {
@@ -608,6 +610,28 @@ func TestDeduplicateFilter_Filter(t *testing.T) {
 				ULID(6),
 			},
 		},
+		{
+			name: "rewritten block replaces block with same sources",
+			input: map[ulid.ULID]*sourcesAndResolution{
+				ULID(3): {
+					sources:    []ulid.ULID{ULID(1), ULID(2)},
+					resolution: 0,
+				},
+				ULID(4): {
+					sources:    []ulid.ULID{ULID(1), ULID(2)},
+					resolution: 0,
+					parents:    []ulid.ULID{ULID(3)},
+				},
+				ULID(5): {
+					sources:    []ulid.ULID{ULID(1), ULID(2)},
+					resolution: 0,
+					parents:    []ulid.ULID{ULID(4)},
+				},
+			},
+			expected: []ulid.ULID{
+				ULID(5),
+			},
+		},
 		{
 			name: "two compacted blocks with overlapping sources",
 			input: map[ulid.ULID]*sourcesAndResolution{
@@ -847,6 +871,79 @@ func TestDeduplicateFilter_Filter(t *testing.T) {
 				ULID(12),
 			},
 		},
+		{
+			name: "all shards of compacted blocks replace their sources",
+			input: map[ulid.ULID]*sourcesAndResolution{
+				ULID(1): {
+					sources: []ulid.ULID{ULID(1)},
+				},
+				ULID(2): {
+					sources: []ulid.ULID{ULID(2)},
+				},
+				ULID(3): {
+					sources: []ulid.ULID{ULID(1), ULID(2)},
+					shard:   &metadata.ThanosShard{Index: 0, Count: 2},
+				},
+				ULID(4): {
+					sources: []ulid.ULID{ULID(1), ULID(2)},
+					shard:   &metadata.ThanosShard{Index: 1, Count: 2},
+				},
+			},
+			expected: []ulid.ULID{
+				ULID(3),
+				ULID(4),
+			},
+		},
+		{
+			name: "incomplete shards of compacted blocks do not replace their sources",
+			input: map[ulid.ULID]*sourcesAndResolution{
+				ULID(1): {
+					sources: []ulid.ULID{ULID(1)},
+				},
+				ULID(2): {
+					sources: []ulid.ULID{ULID(2)},
+				},
+				ULID(3): {
+					sources: []ulid.ULID{ULID(1), ULID(2)},
+					shard:   &metadata.ThanosShard{Index: 0, Count: 2},
+				},
+			},
+			expected: []ulid.ULID{
+				ULID(1),
+				ULID(2),
+				ULID(3),
+			},
+		},
+		{
+			name: "split shard is replaced by its shards",
+			input: map[ulid.ULID]*sourcesAndResolution{
+				ULID(3): {
+					sources: []ulid.ULID{ULID(3)},
+				},
+				ULID(5): {
+					sources: []ulid.ULID{ULID(1), ULID(2)},
+					shard:   &metadata.ThanosShard{Index: 1, Count: 2},
+				},
+				ULID(6): {
+					sources: []ulid.ULID{ULID(1), ULID(2), ULID(3)},
+					shard:   &metadata.ThanosShard{Index: 1, Count: 4},
+				},
+				ULID(7): {
+					sources: []ulid.ULID{ULID(1), ULID(2), ULID(3)},
+					shard:   &metadata.ThanosShard{Index: 3, Count: 4},
+				},
+				ULID(8): {
+					sources: []ulid.ULID{ULID(1), ULID(2)},
+					shard:   &metadata.ThanosShard{Index: 0, Count: 2},
+				},
+			},
+			expected: []ulid.ULID{
+				ULID(3),
+				ULID(6),
+				ULID(7),
+				ULID(8),
+			},
+		},
 	} {
 		f := NewDeduplicateFilter()
 		if ok := t.Run(tcase.name, func(t *testing.T) {
@@ -854,17 +951,23 @@ func TestDeduplicateFilter_Filter(t *testing.T) {
 			metas := make(map[ulid.ULID]*metadata.Meta)
 			inputLen := len(tcase.input)
 			for id, metaInfo := range tcase.input {
+				var parents []tsdb.BlockDesc
+				for _, p := range metaInfo.parents {
+					parents = append(parents, tsdb.BlockDesc{ULID: p})
+				}
 				metas[id] = &metadata.Meta{
 					BlockMeta: tsdb.BlockMeta{
 						ULID: id,
 						Compaction: tsdb.BlockMetaCompaction{
 							Sources: metaInfo.sources,
+							Parents: parents,
 						},
 					},
 					Thanos: metadata.Thanos{
 						Downsample: metadata.ThanosDownsample{
 							Resolution: metaInfo.resolution,
 						},
+						Shard: metaInfo.shard,
 					},
 				}
 			}
@@ -1109,3 +1212,77 @@ func TestIgnoreDeletionMarkFilter_Filter(t *testing.T) {
 		testutil.Equals(t, expected, input)
 	})
 }
+
+func TestNoServeMarkFilter_Filter(t *testing.T) {
+	objtesting.ForeachStore(t, func(t *testing.T, bkt objstore.Bucket) {
+		ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+		defer cancel()
+
+		testutil.Ok(t, MarkForNoServe(ctx, log.NewNopLogger(), bkt, ULID(1), metadata.CorruptedChunksNoServeReason, "checksum mismatch", prometheus.NewCounter(prometheus.CounterOpts{})))
+		testutil.Ok(t, bkt.Upload(ctx, path.Join(ULID(2).String(), metadata.NoServeMarkFilename), bytes.NewBufferString("not a valid no-serve-mark.json")))
+		testutil.Ok(t, bkt.Upload(ctx, NoServeMarkGlobalPath(ULID(2)), bytes.NewBufferString("not a valid no-serve-mark.json")))
+
+		for _, filterMarked := range []bool{false, true} {
+			input := map[ulid.ULID]*metadata.Meta{
+				ULID(1): {},
+				ULID(2): {},
+				ULID(3): {},
+			}
+			expected := map[ulid.ULID]*metadata.Meta{
+				ULID(2): {},
+				ULID(3): {},
+			}
+			expectedFiltered := 1.0
+			if !filterMarked {
+				expected[ULID(1)] = &metadata.Meta{}
+				expectedFiltered = 0
+			}
+
+			f := NewNoServeMarkFilter(log.NewNopLogger(), objstore.WithNoopInstr(bkt), filterMarked)
+			m := newTestFetcherMetrics()
+			testutil.Ok(t, f.Filter(ctx, input, m.synced))
+			testutil.Equals(t, expectedFiltered, promtest.ToFloat64(m.synced.WithLabelValues(markedForNoServeMeta)))
+			testutil.Equals(t, expected, input)
+			testutil.Equals(t, 1, len(f.NoServeMarkBlocks()))
+			testutil.Equals(t, metadata.CorruptedChunksNoServeReason, f.NoServeMarkBlocks()[ULID(1)].Reason)
+		}
+	})
+}
+
+func TestNoCompactMarkFilter_Filter(t *testing.T) {
+	objtesting.ForeachStore(t, func(t *testing.T, bkt objstore.Bucket) {
+		ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+		defer cancel()
+
+		testutil.Ok(t, MarkForNoCompact(ctx, log.NewNopLogger(), bkt, ULID(1), metadata.ManualNoCompactReason, "out of order chunks", prometheus.NewCounter(prometheus.CounterOpts{})))
+		testutil.Ok(t, bkt.Upload(ctx, path.Join(ULID(2).String(), metadata.NoCompactMarkFilename), bytes.NewBufferString("not a valid no-compact-mark.json")))
+		testutil.Ok(t, bkt.Upload(ctx, NoCompactMarkGlobalPath(ULID(2)), bytes.NewBufferString("not a valid no-compact-mark.json")))
+
+		input := map[ulid.ULID]*metadata.Meta{
+			ULID(1): {},
+			ULID(2): {},
+			ULID(3): {},
+		}
+		expected := map[ulid.ULID]*metadata.Meta{
+			ULID(1): {},
+			ULID(2): {},
+			ULID(3): {},
+		}
+
+		f := NewNoCompactMarkFilter(log.NewNopLogger(), objstore.WithNoopInstr(bkt))
+		m := newTestFetcherMetrics()
+		testutil.Ok(t, f.Filter(ctx, input, m.synced))
+		// Marked blocks are kept, as they are still queried and downsampled.
+		testutil.Equals(t, expected, input)
+		testutil.Equals(t, 1, len(f.NoCompactMarkedBlocks()))
+		testutil.Equals(t, "out of order chunks", f.NoCompactMarkedBlocks()[ULID(1)].Details)
+
+		testutil.Ok(t, RemoveMark(ctx, log.NewNopLogger(), bkt, ULID(1), metadata.NoCompactMarkFilename))
+		ok, err := bkt.Exists(ctx, NoCompactMarkGlobalPath(ULID(1)))
+		testutil.Ok(t, err)
+		testutil.Assert(t, !ok, "global copy of the removed mark still exists")
+
+		testutil.Ok(t, f.Filter(ctx, input, m.synced))
+		testutil.Equals(t, 0, len(f.NoCompactMarkedBlocks()))
+	})
+}
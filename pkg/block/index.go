@@ -22,6 +22,7 @@ import (
 
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/prometheus/prometheus/tsdb/chunks"
 	"github.com/prometheus/prometheus/tsdb/index"
 	"github.com/thanos-io/thanos/pkg/runutil"
@@ -247,7 +248,22 @@ func Repair(logger log.Logger, dir string, id ulid.ULID, source metadata.SourceT
 	if len(ignoreChkFns) == 0 {
 		return resid, errors.New("no ignore chunk function specified")
 	}
+	return repair(logger, dir, id, source, func(chks []chunks.Meta, mint int64, maxt int64) ([]chunks.Meta, error) {
+		return sanitizeChunkSequence(chks, mint, maxt, ignoreChkFns)
+	})
+}
+
+// RepairOverlappingChunks opens the block with given id in dir and creates a new one, in which chunks of each series
+// are sorted by time and chunks overlapping in time are merged. Samples with duplicated timestamps are dropped,
+// keeping the sample of the chunk which starts earlier.
+// It resolves out of order and duplicated chunks reported by GatherIndexIssueStats.
+func RepairOverlappingChunks(logger log.Logger, dir string, id ulid.ULID, source metadata.SourceType) (resid ulid.ULID, err error) {
+	return repair(logger, dir, id, source, mergeOverlappingChunks)
+}
+
+type sanitizeFnType func(chks []chunks.Meta, mint int64, maxt int64) ([]chunks.Meta, error)
 
+func repair(logger log.Logger, dir string, id ulid.ULID, source metadata.SourceType, sanitizeFn sanitizeFnType) (resid ulid.ULID, err error) {
 	bdir := filepath.Join(dir, id.String())
 	entropy := rand.New(rand.NewSource(time.Now().UnixNano()))
 	resid = ulid.MustNew(ulid.Now(), entropy)
@@ -299,7 +315,7 @@ func Repair(logger log.Logger, dir string, id ulid.ULID, source metadata.SourceT
 	resmeta.Stats = tsdb.BlockStats{} // Reset stats.
 	resmeta.Thanos.Source = source    // Update source.
 
-	if err := rewrite(logger, indexr, chunkr, indexw, chunkw, &resmeta, ignoreChkFns); err != nil {
+	if err := rewrite(logger, indexr, chunkr, indexw, chunkw, &resmeta, sanitizeFn); err != nil {
 		return resid, errors.Wrap(err, "rewrite block")
 	}
 	if err := metadata.Write(logger, resdir, &resmeta); err != nil {
@@ -396,6 +412,90 @@ OUTER:
 	return repl, nil
 }
 
+// maxSamplesPerChunk is the number of samples after which merged chunks are cut, the same as TSDB head does.
+const maxSamplesPerChunk = 120
+
+// mergeOverlappingChunks ensures order of the input chunks and merges chunks overlapping in time.
+// Samples with duplicated timestamps are dropped, keeping the sample of the chunk which starts earlier.
+func mergeOverlappingChunks(chks []chunks.Meta, _ int64, _ int64) ([]chunks.Meta, error) {
+	if len(chks) == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(chks, func(i, j int) bool {
+		return chks[i].MinTime < chks[j].MinTime
+	})
+
+	repl := make([]chunks.Meta, 0, len(chks))
+	for i := 0; i < len(chks); {
+		j, maxt := i+1, chks[i].MaxTime
+		for ; j < len(chks) && chks[j].MinTime <= maxt; j++ {
+			if chks[j].MaxTime > maxt {
+				maxt = chks[j].MaxTime
+			}
+		}
+		if j == i+1 {
+			repl = append(repl, chks[i])
+			i = j
+			continue
+		}
+		merged, err := mergeChunks(chks[i:j])
+		if err != nil {
+			return nil, err
+		}
+		repl = append(repl, merged...)
+		i = j
+	}
+	return repl, nil
+}
+
+type sample struct {
+	t int64
+	v float64
+}
+
+// mergeChunks merges samples of the given XOR chunks into new chunks, ordered by time and without duplicated timestamps.
+func mergeChunks(chks []chunks.Meta) ([]chunks.Meta, error) {
+	var samples []sample
+	for _, c := range chks {
+		if c.Chunk.Encoding() != chunkenc.EncXOR {
+			return nil, errors.Errorf("cannot merge chunk with encoding %s", c.Chunk.Encoding())
+		}
+		it := c.Chunk.Iterator(nil)
+		for it.Next() {
+			t, v := it.At()
+			samples = append(samples, sample{t: t, v: v})
+		}
+		if it.Err() != nil {
+			return nil, errors.Wrap(it.Err(), "iterate chunk")
+		}
+	}
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].t < samples[j].t
+	})
+
+	var (
+		res []chunks.Meta
+		app chunkenc.Appender
+	)
+	for i, s := range samples {
+		if i > 0 && s.t == samples[i-1].t {
+			continue
+		}
+		if len(res) == 0 || res[len(res)-1].Chunk.NumSamples() >= maxSamplesPerChunk {
+			c := chunkenc.NewXORChunk()
+			a, err := c.Appender()
+			if err != nil {
+				return nil, errors.Wrap(err, "create appender")
+			}
+			app = a
+			res = append(res, chunks.Meta{MinTime: s.t, Chunk: c})
+		}
+		app.Append(s.t, s.v)
+		res[len(res)-1].MaxTime = s.t
+	}
+	return res, nil
+}
+
 type seriesRepair struct {
 	lset labels.Labels
 	chks []chunks.Meta
@@ -408,7 +508,7 @@ func rewrite(
 	indexr tsdb.IndexReader, chunkr tsdb.ChunkReader,
 	indexw tsdb.IndexWriter, chunkw tsdb.ChunkWriter,
 	meta *metadata.Meta,
-	ignoreChkFns []ignoreFnType,
+	sanitizeFn sanitizeFnType,
 ) error {
 	symbols := indexr.Symbols()
 	for symbols.Next() {
@@ -452,7 +552,7 @@ func rewrite(
 			}
 		}
 
-		chks, err := sanitizeChunkSequence(chks, meta.MinTime, meta.MaxTime, ignoreChkFns)
+		chks, err := sanitizeFn(chks, meta.MinTime, meta.MaxTime)
 		if err != nil {
 			return err
 		}
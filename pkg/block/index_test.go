@@ -13,6 +13,7 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/prometheus/prometheus/tsdb/chunks"
 	"github.com/prometheus/prometheus/tsdb/index"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
@@ -61,9 +62,11 @@ func TestRewrite(t *testing.T) {
 
 	defer cw.Close()
 
-	testutil.Ok(t, rewrite(log.NewNopLogger(), ir, cr, iw, cw, m, []ignoreFnType{func(mint, maxt int64, prev *chunks.Meta, curr *chunks.Meta) (bool, error) {
-		return curr.MaxTime == 696, nil
-	}}))
+	testutil.Ok(t, rewrite(log.NewNopLogger(), ir, cr, iw, cw, m, func(chks []chunks.Meta, mint int64, maxt int64) ([]chunks.Meta, error) {
+		return sanitizeChunkSequence(chks, mint, maxt, []ignoreFnType{func(mint, maxt int64, prev *chunks.Meta, curr *chunks.Meta) (bool, error) {
+			return curr.MaxTime == 696, nil
+		}})
+	}))
 
 	testutil.Ok(t, iw.Close())
 	testutil.Ok(t, cw.Close())
@@ -85,3 +88,97 @@ func TestRewrite(t *testing.T) {
 	}
 
 }
+
+func xorChunk(t *testing.T, ts ...int64) chunks.Meta {
+	c := chunkenc.NewXORChunk()
+	app, err := c.Appender()
+	testutil.Ok(t, err)
+	for _, s := range ts {
+		app.Append(s, float64(s))
+	}
+	return chunks.Meta{MinTime: ts[0], MaxTime: ts[len(ts)-1], Chunk: c}
+}
+
+func chunkSamples(t *testing.T, chks []chunks.Meta) (res [][]int64) {
+	for _, c := range chks {
+		var ts []int64
+		it := c.Chunk.Iterator(nil)
+		for it.Next() {
+			s, _ := it.At()
+			ts = append(ts, s)
+		}
+		testutil.Ok(t, it.Err())
+		testutil.Equals(t, c.MinTime, ts[0])
+		testutil.Equals(t, c.MaxTime, ts[len(ts)-1])
+		res = append(res, ts)
+	}
+	return res
+}
+
+func TestMergeOverlappingChunks(t *testing.T) {
+	for _, tcase := range []struct {
+		name     string
+		input    []chunks.Meta
+		expected [][]int64
+	}{
+		{
+			name:     "ordered chunks are kept",
+			input:    []chunks.Meta{xorChunk(t, 1, 2, 3), xorChunk(t, 4, 5)},
+			expected: [][]int64{{1, 2, 3}, {4, 5}},
+		},
+		{
+			name:     "out of order chunks are sorted",
+			input:    []chunks.Meta{xorChunk(t, 4, 5), xorChunk(t, 1, 2, 3)},
+			expected: [][]int64{{1, 2, 3}, {4, 5}},
+		},
+		{
+			name:     "duplicated chunks are deduplicated",
+			input:    []chunks.Meta{xorChunk(t, 1, 2, 3), xorChunk(t, 1, 2, 3), xorChunk(t, 4, 5)},
+			expected: [][]int64{{1, 2, 3}, {4, 5}},
+		},
+		{
+			name:     "overlapping chunks are merged",
+			input:    []chunks.Meta{xorChunk(t, 6, 8), xorChunk(t, 1, 3, 5), xorChunk(t, 2, 3, 4, 7), xorChunk(t, 10)},
+			expected: [][]int64{{1, 2, 3, 4, 5, 6, 7, 8}, {10}},
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			res, err := mergeOverlappingChunks(tcase.input, 0, 10)
+			testutil.Ok(t, err)
+			testutil.Equals(t, tcase.expected, chunkSamples(t, res))
+		})
+	}
+
+	t.Run("merged chunks are cut", func(t *testing.T) {
+		var a, b []int64
+		for i := int64(0); i < 200; i++ {
+			a = append(a, 2*i)
+			b = append(b, 2*i+1)
+		}
+		res, err := mergeOverlappingChunks([]chunks.Meta{xorChunk(t, a...), xorChunk(t, b...)}, 0, 400)
+		testutil.Ok(t, err)
+		samples := chunkSamples(t, res)
+		testutil.Equals(t, 4, len(samples))
+		testutil.Equals(t, maxSamplesPerChunk, len(samples[0]))
+		testutil.Equals(t, 400-3*maxSamplesPerChunk, len(samples[3]))
+	})
+
+	t.Run("samples with duplicated timestamps keep the earlier chunk", func(t *testing.T) {
+		c := chunkenc.NewXORChunk()
+		app, err := c.Appender()
+		testutil.Ok(t, err)
+		app.Append(2, 100)
+		app.Append(3, 100)
+
+		res, err := mergeOverlappingChunks([]chunks.Meta{{MinTime: 2, MaxTime: 3, Chunk: c}, xorChunk(t, 1, 2)}, 0, 10)
+		testutil.Ok(t, err)
+		testutil.Equals(t, 1, len(res))
+		var values []float64
+		it := res[0].Chunk.Iterator(nil)
+		for it.Next() {
+			_, v := it.At()
+			values = append(values, v)
+		}
+		testutil.Equals(t, []float64{1, 2, 100}, values)
+	})
+}
@@ -10,12 +10,15 @@ package metadata
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 
 	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/prometheus/prometheus/tsdb/fileutil"
 	"github.com/thanos-io/thanos/pkg/runutil"
@@ -59,12 +62,79 @@ type Thanos struct {
 
 	// Source is a real upload source of the block.
 	Source SourceType `json:"source"`
+
+	// DeletionRequests holds IDs of deletion requests applied to the block.
+	DeletionRequests []string `json:"deletion_requests,omitempty"`
+
+	// Shard is set if the block holds only a shard of series of its time range, as the compactor split the range
+	// into multiple blocks. Blocks of all shards of a range complement each other.
+	Shard *ThanosShard `json:"shard,omitempty"`
+
+	// Repairs holds repairs of the block data done by the compactor, including repairs of blocks it was compacted from.
+	Repairs []ThanosRepair `json:"repairs,omitempty"`
 }
 
 type ThanosDownsample struct {
 	Resolution int64 `json:"resolution"`
 }
 
+// ThanosRepair describes a repair of out of order and duplicated chunks of a block. The original block is kept
+// in the backup bucket of the compactor.
+type ThanosRepair struct {
+	// Block is the ID of the repaired block.
+	Block ulid.ULID `json:"block"`
+	// Time is a unix timestamp of the repair.
+	Time int64 `json:"time"`
+	// OutOfOrderChunks is the number of out of order chunks found in the block.
+	OutOfOrderChunks int `json:"out_of_order_chunks"`
+	// DuplicatedChunks is the number of duplicated chunks found in the block.
+	DuplicatedChunks int `json:"duplicated_chunks"`
+}
+
+// ThanosShard identifies a shard of series. Series belong to the shard with the index equal to the hash of their
+// labels modulo the number of shards. Nil shard holds all series.
+type ThanosShard struct {
+	Index uint64 `json:"index"`
+	Count uint64 `json:"count"`
+}
+
+// Contains returns true if series with the given labels belong to the shard.
+func (s *ThanosShard) Contains(lset labels.Labels) bool {
+	return s == nil || lset.Hash()%s.Count == s.Index
+}
+
+// Covers returns true if all series of the other shard belong to the shard.
+func (s *ThanosShard) Covers(o *ThanosShard) bool {
+	if s == nil {
+		return true
+	}
+	if o == nil {
+		return false
+	}
+	return o.Count%s.Count == 0 && o.Index%s.Count == s.Index
+}
+
+// Split returns n shards the series of the shard are split into. Shards of a split shard are shards of
+// all series, so series of a range split repeatedly are assigned consistently.
+func (s *ThanosShard) Split(n uint64) []*ThanosShard {
+	index, count := uint64(0), uint64(1)
+	if s != nil {
+		index, count = s.Index, s.Count
+	}
+	res := make([]*ThanosShard, 0, n)
+	for i := uint64(0); i < n; i++ {
+		res = append(res, &ThanosShard{Index: index + i*count, Count: n * count})
+	}
+	return res
+}
+
+func (s *ThanosShard) String() string {
+	if s == nil {
+		return "all"
+	}
+	return fmt.Sprintf("%d_of_%d", s.Index, s.Count)
+}
+
 // InjectThanos sets Thanos meta to the block meta JSON and saves it to the disk.
 // NOTE: It should be used after writing any block by any Thanos component, otherwise we will miss crucial metadata.
 func InjectThanos(logger log.Logger, bdir string, meta Thanos, downsampledMeta *tsdb.BlockMeta) (*Meta, error) {
@@ -145,5 +215,8 @@ func Read(dir string) (*Meta, error) {
 	if m.Version != MetaVersion1 {
 		return nil, errors.Errorf("unexpected meta file version %d", m.Version)
 	}
+	if s := m.Thanos.Shard; s != nil && (s.Count < 2 || s.Index >= s.Count) {
+		return nil, errors.Errorf("invalid shard %d of %d", s.Index, s.Count)
+	}
 	return &m, nil
 }
@@ -0,0 +1,49 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package metadata
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestThanosShard(t *testing.T) {
+	var all *ThanosShard
+	halves := all.Split(2)
+	testutil.Equals(t, []*ThanosShard{{Index: 0, Count: 2}, {Index: 1, Count: 2}}, halves)
+	testutil.Equals(t, []*ThanosShard{{Index: 1, Count: 6}, {Index: 3, Count: 6}, {Index: 5, Count: 6}}, halves[1].Split(3))
+	testutil.Equals(t, "all", all.String())
+	testutil.Equals(t, "1_of_2", halves[1].String())
+
+	testutil.Assert(t, all.Covers(halves[0]), "expected all series to cover shard")
+	testutil.Assert(t, !halves[0].Covers(all), "expected shard not to cover all series")
+	testutil.Assert(t, halves[1].Covers(&ThanosShard{Index: 3, Count: 6}), "expected shard to cover its split")
+	testutil.Assert(t, !halves[0].Covers(&ThanosShard{Index: 3, Count: 6}), "expected shard not to cover split of other shard")
+	testutil.Assert(t, !halves[0].Covers(&ThanosShard{Index: 0, Count: 3}), "expected shard not to cover shard of other count")
+
+	// Each series belongs to exactly one shard of a split, and the split of its shard.
+	for i := 0; i < 100; i++ {
+		lset := labels.FromStrings("a", fmt.Sprintf("%d", i))
+		testutil.Assert(t, all.Contains(lset), "expected all series to contain series")
+
+		var n int
+		for _, s := range halves {
+			if !s.Contains(lset) {
+				continue
+			}
+			n++
+			var m int
+			for _, ss := range s.Split(3) {
+				if ss.Contains(lset) {
+					m++
+				}
+			}
+			testutil.Equals(t, 1, m)
+		}
+		testutil.Equals(t, 1, n)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package metadata
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+const (
+	// NoServeMarkFilename is the known json filename to store details about why block is quarantined and must not be served.
+	NoServeMarkFilename = "no-serve-mark.json"
+
+	// NoServeMarkVersion1 is the version of no-serve-mark file supported by Thanos.
+	NoServeMarkVersion1 = 1
+)
+
+// ErrorNoServeMarkNotFound is the error when no-serve-mark.json file is not found.
+var ErrorNoServeMarkNotFound = errors.New("no-serve-mark.json not found")
+
+// ErrorUnmarshalNoServeMark is the error when unmarshalling no-serve-mark.json file.
+// This error can occur because no-serve-mark.json has been partially uploaded to block storage
+// or the no-serve-mark.json file is not a valid json file.
+var ErrorUnmarshalNoServeMark = errors.New("unmarshal no-serve-mark.json")
+
+// NoServeReason is a reason for a block to be quarantined.
+type NoServeReason string
+
+const (
+	// CorruptedChunksNoServeReason is a reason for blocks with chunks failing integrity checks.
+	CorruptedChunksNoServeReason NoServeReason = "corrupted-chunks"
+	// CorruptedIndexNoServeReason is a reason for blocks with index entries failing integrity checks.
+	CorruptedIndexNoServeReason NoServeReason = "corrupted-index"
+)
+
+// NoServeMark stores block id and why the block was quarantined.
+type NoServeMark struct {
+	// ID of the tsdb block.
+	ID ulid.ULID `json:"id"`
+
+	// NoServeTime is a unix timestamp of when the block was quarantined.
+	NoServeTime int64 `json:"no_serve_time"`
+
+	// Reason is the reason of the quarantine.
+	Reason NoServeReason `json:"reason"`
+
+	// Details is a human readable description of the problem, e.g. the last integrity check error.
+	Details string `json:"details,omitempty"`
+
+	// Version of the file.
+	Version int `json:"version"`
+}
+
+// ReadNoServeMark reads the given no-serve mark file from <dir>/no-serve-mark.json in bucket.
+func ReadNoServeMark(ctx context.Context, bkt objstore.InstrumentedBucketReader, logger log.Logger, dir string) (*NoServeMark, error) {
+	noServeMarkFile := path.Join(dir, NoServeMarkFilename)
+
+	r, err := bkt.ReaderWithExpectedErrs(bkt.IsObjNotFoundErr).Get(ctx, noServeMarkFile)
+	if err != nil {
+		if bkt.IsObjNotFoundErr(err) {
+			return nil, ErrorNoServeMarkNotFound
+		}
+		return nil, errors.Wrapf(err, "get file: %s", noServeMarkFile)
+	}
+
+	defer runutil.CloseWithLogOnErr(logger, r, "close bkt no-serve-mark reader")
+
+	content, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read file: %s", noServeMarkFile)
+	}
+
+	noServeMark := NoServeMark{}
+	if err := json.Unmarshal(content, &noServeMark); err != nil {
+		return nil, errors.Wrapf(ErrorUnmarshalNoServeMark, "file: %s; err: %v", noServeMarkFile, err.Error())
+	}
+
+	if noServeMark.Version != NoServeMarkVersion1 {
+		return nil, errors.Errorf("unexpected no-serve-mark file version %d", noServeMark.Version)
+	}
+
+	return &noServeMark, nil
+}
@@ -0,0 +1,629 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package cacheutil
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/go-redis/redis"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/thanos/pkg/extprom"
+	"github.com/thanos-io/thanos/pkg/gate"
+	"github.com/thanos-io/thanos/pkg/model"
+	thanostls "github.com/thanos-io/thanos/pkg/tls"
+	"github.com/thanos-io/thanos/pkg/tracing"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// RedisClientMode is the mode used to connect to redis.
+type RedisClientMode string
+
+const (
+	// RedisSingleMode connects to a single redis node.
+	RedisSingleMode RedisClientMode = "single"
+	// RedisSentinelMode discovers the redis master through redis sentinels.
+	RedisSentinelMode RedisClientMode = "sentinel"
+	// RedisClusterMode connects to a redis cluster.
+	RedisClusterMode RedisClientMode = "cluster"
+)
+
+var (
+	errRedisAsyncBufferFull       = errors.New("the async buffer is full")
+	errRedisClientStopped         = errors.New("the redis client is stopped")
+	errRedisConfigNoAddrs         = errors.New("no redis addresses provided")
+	errRedisConfigNoMasterName    = errors.New("no redis master name provided, required in sentinel mode")
+	errRedisConfigTooManyAddrs    = errors.New("only one redis address can be provided in single mode")
+	errRedisConfigDBInClusterMode = errors.New("redis database selection is not supported in cluster mode")
+
+	// redisDisableIdleCheck disables the background reaper of idle connections, which outlives the client on Stop().
+	// Idle connections are still closed after IdleTimeout, when they are picked from the pool.
+	redisDisableIdleCheck = time.Duration(-1)
+
+	defaultRedisClientConfig = RedisClientConfig{
+		Mode:                   RedisSingleMode,
+		DialTimeout:            5 * time.Second,
+		ReadTimeout:            3 * time.Second,
+		WriteTimeout:           3 * time.Second,
+		GetMultiTimeout:        500 * time.Millisecond,
+		PoolSize:               100,
+		MinIdleConnections:     10,
+		IdleTimeout:            5 * time.Minute,
+		MaxAsyncConcurrency:    20,
+		MaxAsyncBufferSize:     10000,
+		MaxItemSize:            model.Bytes(16 * 1024 * 1024),
+		MaxGetMultiConcurrency: 100,
+		MaxGetMultiBatchSize:   100,
+	}
+)
+
+// RedisClient is a high level client to interact with redis.
+type RedisClient interface {
+	// GetMulti fetches multiple keys at once from redis. In case of error,
+	// an empty map is returned and the error tracked/logged.
+	GetMulti(ctx context.Context, keys []string) map[string][]byte
+
+	// SetAsync enqueues an asynchronous operation to store a key into redis.
+	// Returns an error in case it fails to enqueue the operation. In case the
+	// underlying async operation will fail, the error will be tracked/logged.
+	SetAsync(ctx context.Context, key string, value []byte, ttl time.Duration) error
+
+	// Stop client and release underlying resources.
+	Stop()
+}
+
+// RedisTLSConfig configures the TLS connection to redis.
+type RedisTLSConfig struct {
+	// CAFile is the CA certificate used to verify the redis servers.
+	CAFile string `yaml:"ca_file"`
+	// CertFile is the client certificate used to authenticate to redis.
+	CertFile string `yaml:"cert_file"`
+	// KeyFile is the key of the client certificate.
+	KeyFile string `yaml:"key_file"`
+	// ServerName is used to verify the hostname of the redis servers.
+	ServerName string `yaml:"server_name"`
+	// InsecureSkipVerify disables the verification of the redis servers certificate.
+	InsecureSkipVerify bool `yaml:"insecure_skip_verify"`
+}
+
+// RedisClientConfig is the config accepted by RedisClient.
+type RedisClientConfig struct {
+	// Mode is the mode used to connect to redis: single, sentinel or cluster.
+	Mode RedisClientMode `yaml:"mode"`
+
+	// Addresses specifies the list of redis addresses. In single mode it must be the
+	// address of the redis node, in sentinel mode the addresses of the sentinels and
+	// in cluster mode a seed list of cluster nodes.
+	Addresses []string `yaml:"addresses"`
+
+	// MasterName is the name of the master monitored by the sentinels. Required
+	// in sentinel mode.
+	MasterName string `yaml:"master_name"`
+
+	// Password is used to authenticate to redis.
+	Password string `yaml:"password"`
+
+	// DB is the database to select after connecting. Not supported in cluster mode.
+	DB int `yaml:"db"`
+
+	// DialTimeout specifies the timeout to establish a new connection.
+	DialTimeout time.Duration `yaml:"dial_timeout"`
+
+	// ReadTimeout specifies the socket read timeout.
+	ReadTimeout time.Duration `yaml:"read_timeout"`
+
+	// WriteTimeout specifies the socket write timeout.
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+
+	// GetMultiTimeout specifies the maximum time a GetMulti() operation can take, including
+	// the wait for a free slot when MaxGetMultiConcurrency is enforced. If set to 0, only
+	// the socket timeouts apply.
+	GetMultiTimeout time.Duration `yaml:"get_multi_timeout"`
+
+	// PoolSize specifies the maximum number of connections per redis node.
+	PoolSize int `yaml:"pool_size"`
+
+	// MinIdleConnections specifies the minimum number of idle connections
+	// maintained per redis node.
+	MinIdleConnections int `yaml:"min_idle_connections"`
+
+	// IdleTimeout specifies the amount of time after which idle connections are closed.
+	IdleTimeout time.Duration `yaml:"idle_timeout"`
+
+	// MaxAsyncConcurrency specifies the maximum number of concurrent asynchronous
+	// operations can occur.
+	MaxAsyncConcurrency int `yaml:"max_async_concurrency"`
+
+	// MaxAsyncBufferSize specifies the maximum number of enqueued asynchronous
+	// operations allowed.
+	MaxAsyncBufferSize int `yaml:"max_async_buffer_size"`
+
+	// MaxGetMultiConcurrency specifies the maximum number of concurrent pipelines
+	// running GetMulti() operations. If set to 0, concurrency is unlimited.
+	MaxGetMultiConcurrency int `yaml:"max_get_multi_concurrency"`
+
+	// MaxItemSize specifies the maximum size of an item stored in redis. Bigger
+	// items are skipped to be stored by the client. If set to 0, no maximum size is
+	// enforced.
+	MaxItemSize model.Bytes `yaml:"max_item_size"`
+
+	// MaxGetMultiBatchSize specifies the maximum number of keys fetched by a single
+	// pipeline. If more keys are specified, internally keys are splitted into multiple
+	// batches and fetched concurrently, honoring MaxGetMultiConcurrency parallelism.
+	// If set to 0, the max batch size is unlimited.
+	MaxGetMultiBatchSize int `yaml:"max_get_multi_batch_size"`
+
+	// TLSEnabled enables TLS when connecting to redis.
+	TLSEnabled bool `yaml:"tls_enabled"`
+
+	// TLSConfig configures the TLS connection, if enabled.
+	TLSConfig RedisTLSConfig `yaml:"tls_config"`
+}
+
+func (c *RedisClientConfig) validate() error {
+	if len(c.Addresses) == 0 {
+		return errRedisConfigNoAddrs
+	}
+
+	switch c.Mode {
+	case RedisSingleMode:
+		if len(c.Addresses) > 1 {
+			return errRedisConfigTooManyAddrs
+		}
+	case RedisSentinelMode:
+		if c.MasterName == "" {
+			return errRedisConfigNoMasterName
+		}
+	case RedisClusterMode:
+		if c.DB != 0 {
+			return errRedisConfigDBInClusterMode
+		}
+	default:
+		return errors.Errorf("unsupported redis mode %q", c.Mode)
+	}
+
+	return nil
+}
+
+// parseRedisClientConfig unmarshals a buffer into a RedisClientConfig with default values.
+func parseRedisClientConfig(conf []byte) (RedisClientConfig, error) {
+	config := defaultRedisClientConfig
+	if err := yaml.Unmarshal(conf, &config); err != nil {
+		return RedisClientConfig{}, err
+	}
+
+	return config, nil
+}
+
+type redisClient struct {
+	logger log.Logger
+	config RedisClientConfig
+	client redis.UniversalClient
+
+	// Channel used to notify internal goroutines when they should quit.
+	stop chan struct{}
+
+	// Channel used to enqueue async operations.
+	asyncQueue chan func()
+
+	// Gate used to enforce the max number of concurrent GetMulti() operations.
+	getMultiGate *gate.Gate
+
+	// Wait group used to wait all workers on stopping. Workers are only added while the client is not stopped,
+	// which is guarded by stopMtx.
+	workers sync.WaitGroup
+	stopMtx sync.Mutex
+	stopped bool
+
+	// Tracked metrics.
+	operations *prometheus.CounterVec
+	failures   *prometheus.CounterVec
+	skipped    *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+}
+
+type redisGetMultiResult struct {
+	items map[string][]byte
+	err   error
+}
+
+// NewRedisClient makes a new RedisClient.
+func NewRedisClient(logger log.Logger, name string, conf []byte, reg prometheus.Registerer) (*redisClient, error) {
+	config, err := parseRedisClientConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRedisClientWithConfig(logger, name, config, reg)
+}
+
+// NewRedisClientWithConfig makes a new RedisClient.
+func NewRedisClientWithConfig(logger log.Logger, name string, config RedisClientConfig, reg prometheus.Registerer) (*redisClient, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	var tlsConfig *tls.Config
+	if config.TLSEnabled {
+		var err error
+		tlsConfig, err = newRedisTLSConfig(logger, config.TLSConfig)
+		if err != nil {
+			return nil, errors.Wrap(err, "create redis TLS config")
+		}
+	}
+
+	var client redis.UniversalClient
+	switch config.Mode {
+	case RedisSentinelMode:
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:         config.MasterName,
+			SentinelAddrs:      config.Addresses,
+			DB:                 config.DB,
+			Password:           config.Password,
+			DialTimeout:        config.DialTimeout,
+			ReadTimeout:        config.ReadTimeout,
+			WriteTimeout:       config.WriteTimeout,
+			PoolSize:           config.PoolSize,
+			MinIdleConns:       config.MinIdleConnections,
+			IdleTimeout:        config.IdleTimeout,
+			IdleCheckFrequency: redisDisableIdleCheck,
+			TLSConfig:          tlsConfig,
+		})
+	case RedisClusterMode:
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:        config.Addresses,
+			Password:     config.Password,
+			DialTimeout:  config.DialTimeout,
+			ReadTimeout:  config.ReadTimeout,
+			WriteTimeout: config.WriteTimeout,
+			PoolSize:     config.PoolSize,
+			MinIdleConns: config.MinIdleConnections,
+			IdleTimeout:  config.IdleTimeout,
+			TLSConfig:    tlsConfig,
+		})
+	default:
+		client = redis.NewClient(&redis.Options{
+			Addr:               config.Addresses[0],
+			DB:                 config.DB,
+			Password:           config.Password,
+			DialTimeout:        config.DialTimeout,
+			ReadTimeout:        config.ReadTimeout,
+			WriteTimeout:       config.WriteTimeout,
+			PoolSize:           config.PoolSize,
+			MinIdleConns:       config.MinIdleConnections,
+			IdleTimeout:        config.IdleTimeout,
+			IdleCheckFrequency: redisDisableIdleCheck,
+			TLSConfig:          tlsConfig,
+		})
+	}
+
+	return newRedisClient(logger, name, client, config, reg), nil
+}
+
+func newRedisTLSConfig(logger log.Logger, config RedisTLSConfig) (*tls.Config, error) {
+	tlsConfig, err := thanostls.NewClientConfig(logger, config.CertFile, config.KeyFile, config.CAFile, config.ServerName)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.InsecureSkipVerify = config.InsecureSkipVerify
+	return tlsConfig, nil
+}
+
+func newRedisClient(
+	logger log.Logger,
+	name string,
+	client redis.UniversalClient,
+	config RedisClientConfig,
+	reg prometheus.Registerer,
+) *redisClient {
+	c := &redisClient{
+		logger:     logger,
+		config:     config,
+		client:     client,
+		asyncQueue: make(chan func(), config.MaxAsyncBufferSize),
+		stop:       make(chan struct{}, 1),
+		getMultiGate: gate.NewGate(
+			config.MaxGetMultiConcurrency,
+			extprom.WrapRegistererWithPrefix("thanos_redis_getmulti_", reg),
+		),
+	}
+
+	c.operations = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name:        "thanos_redis_operations_total",
+		Help:        "Total number of operations against redis.",
+		ConstLabels: prometheus.Labels{"name": name},
+	}, []string{"operation"})
+	c.operations.WithLabelValues(opGetMulti)
+	c.operations.WithLabelValues(opSet)
+
+	c.failures = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name:        "thanos_redis_operation_failures_total",
+		Help:        "Total number of operations against redis that failed.",
+		ConstLabels: prometheus.Labels{"name": name},
+	}, []string{"operation"})
+	c.failures.WithLabelValues(opGetMulti)
+	c.failures.WithLabelValues(opSet)
+
+	c.skipped = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name:        "thanos_redis_operation_skipped_total",
+		Help:        "Total number of operations against redis that have been skipped.",
+		ConstLabels: prometheus.Labels{"name": name},
+	}, []string{"operation", "reason"})
+	c.skipped.WithLabelValues(opSet, reasonMaxItemSize)
+
+	c.duration = promauto.With(reg).NewHistogramVec(prometheus.HistogramOpts{
+		Name:        "thanos_redis_operation_duration_seconds",
+		Help:        "Duration of operations against redis.",
+		ConstLabels: prometheus.Labels{"name": name},
+		Buckets:     []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.2, 0.5, 1, 3, 6, 10},
+	}, []string{"operation"})
+	c.duration.WithLabelValues(opGetMulti)
+	c.duration.WithLabelValues(opSet)
+
+	// Start a number of goroutines - processing async operations - equal
+	// to the max concurrency we have.
+	c.workers.Add(c.config.MaxAsyncConcurrency)
+	for i := 0; i < c.config.MaxAsyncConcurrency; i++ {
+		go c.asyncQueueProcessLoop()
+	}
+
+	return c
+}
+
+func (c *redisClient) Stop() {
+	c.stopMtx.Lock()
+	c.stopped = true
+	c.stopMtx.Unlock()
+	close(c.stop)
+
+	// Wait until all workers have terminated.
+	c.workers.Wait()
+
+	if err := c.client.Close(); err != nil {
+		level.Warn(c.logger).Log("msg", "failed to close redis client", "err", err)
+	}
+}
+
+func (c *redisClient) SetAsync(ctx context.Context, key string, value []byte, ttl time.Duration) (err error) {
+	// Skip hitting redis at all if the item is bigger than the max allowed size.
+	if c.config.MaxItemSize > 0 && uint64(len(value)) > uint64(c.config.MaxItemSize) {
+		c.skipped.WithLabelValues(opSet, reasonMaxItemSize).Inc()
+		return nil
+	}
+
+	return c.enqueueAsync(func() {
+		start := time.Now()
+		c.operations.WithLabelValues(opSet).Inc()
+
+		tracing.DoInSpan(ctx, "redis_set", func(ctx context.Context) {
+			// Timeouts are enforced by the underlying client on the socket.
+			err = c.client.Set(key, value, ttl).Err()
+		})
+		if err != nil {
+			c.failures.WithLabelValues(opSet).Inc()
+			level.Warn(c.logger).Log("msg", "failed to store item to redis", "key", key, "sizeBytes", len(value), "err", err)
+			return
+		}
+
+		c.duration.WithLabelValues(opSet).Observe(time.Since(start).Seconds())
+	})
+}
+
+func (c *redisClient) GetMulti(ctx context.Context, keys []string) map[string][]byte {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if c.config.GetMultiTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.config.GetMultiTimeout)
+		defer cancel()
+	}
+
+	batches, err := c.getMultiBatched(ctx, keys)
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "failed to fetch items from redis", "numKeys", len(keys), "firstKey", keys[0], "err", err)
+
+		// In case we have both results and an error, it means some batch requests
+		// failed and other succeeded. In this case we prefer to log it and move on,
+		// given returning some results from the cache is better than returning
+		// nothing.
+		if len(batches) == 0 {
+			return nil
+		}
+	}
+
+	hits := map[string][]byte{}
+	for _, items := range batches {
+		for key, value := range items {
+			hits[key] = value
+		}
+	}
+
+	return hits
+}
+
+func (c *redisClient) getMultiBatched(ctx context.Context, keys []string) ([]map[string][]byte, error) {
+	// Do not batch if the input keys are less than the max batch size.
+	if (c.config.MaxGetMultiBatchSize <= 0) || (len(keys) <= c.config.MaxGetMultiBatchSize) {
+		items, err := c.getMultiSingle(ctx, keys)
+		if err != nil {
+			return nil, err
+		}
+
+		return []map[string][]byte{items}, nil
+	}
+
+	// Calculate the number of expected results.
+	batchSize := c.config.MaxGetMultiBatchSize
+	numResults := len(keys) / batchSize
+	if len(keys)%batchSize != 0 {
+		numResults++
+	}
+
+	// Spawn a goroutine for each batch request. The max concurrency will be
+	// enforced by getMultiSingle().
+	results := make(chan *redisGetMultiResult, numResults)
+	defer close(results)
+
+	for batchStart := 0; batchStart < len(keys); batchStart += batchSize {
+		batchEnd := batchStart + batchSize
+		if batchEnd > len(keys) {
+			batchEnd = len(keys)
+		}
+
+		batchKeys := keys[batchStart:batchEnd]
+
+		if !c.addWorker() {
+			results <- &redisGetMultiResult{err: errRedisClientStopped}
+			continue
+		}
+		go func() {
+			defer c.workers.Done()
+
+			res := &redisGetMultiResult{}
+			res.items, res.err = c.getMultiSingle(ctx, batchKeys)
+
+			results <- res
+		}()
+	}
+
+	// Wait for all batch results. In case of error, we keep
+	// track of the last error occurred.
+	items := make([]map[string][]byte, 0, numResults)
+	var lastErr error
+
+	for i := 0; i < numResults; i++ {
+		result := <-results
+		if result.err != nil {
+			lastErr = result.err
+			continue
+		}
+
+		items = append(items, result.items)
+	}
+
+	return items, lastErr
+}
+
+// getMultiSingle fetches the keys with a single pipeline of GET commands. A pipeline is used
+// instead of MGET because, in cluster mode, keys may belong to different hash slots.
+func (c *redisClient) getMultiSingle(ctx context.Context, keys []string) (items map[string][]byte, err error) {
+	// Wait until we get a free slot from the gate, if the max
+	// concurrency should be enforced.
+	if c.config.MaxGetMultiConcurrency > 0 {
+		tracing.DoInSpan(ctx, "redis_getmulti_gate_ismyturn", func(ctx context.Context) {
+			err = c.getMultiGate.IsMyTurn(ctx)
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to wait for turn")
+		}
+		defer c.getMultiGate.Done()
+	}
+
+	start := time.Now()
+	c.operations.WithLabelValues(opGetMulti).Inc()
+	tracing.DoInSpan(ctx, "redis_getmulti", func(ctx context.Context) {
+		items, err = c.pipelinedGet(ctx, keys)
+	})
+	if err != nil {
+		c.failures.WithLabelValues(opGetMulti).Inc()
+	} else {
+		c.duration.WithLabelValues(opGetMulti).Observe(time.Since(start).Seconds())
+	}
+
+	return items, err
+}
+
+// addWorker adds a worker to the wait group, unless the client is stopped. Stop waits for all added workers.
+func (c *redisClient) addWorker() bool {
+	c.stopMtx.Lock()
+	defer c.stopMtx.Unlock()
+	if c.stopped {
+		return false
+	}
+	c.workers.Add(1)
+	return true
+}
+
+// pipelinedGet runs the pipeline of GET commands, returning early once the context is done. The redis
+// client does not honor contexts, so a pipeline already sent keeps running in the background until it
+// completes or the socket timeouts expire.
+func (c *redisClient) pipelinedGet(ctx context.Context, keys []string) (map[string][]byte, error) {
+	res := make(chan *redisGetMultiResult, 1)
+
+	if !c.addWorker() {
+		return nil, errRedisClientStopped
+	}
+	go func() {
+		defer c.workers.Done()
+
+		items, err := c.pipelinedGetSync(ctx, keys)
+		res <- &redisGetMultiResult{items: items, err: err}
+	}()
+
+	select {
+	case r := <-res:
+		return r.items, r.err
+	case <-ctx.Done():
+		return nil, errors.Wrap(ctx.Err(), "wait for redis pipeline")
+	}
+}
+
+func (c *redisClient) pipelinedGetSync(ctx context.Context, keys []string) (map[string][]byte, error) {
+	cmds := make([]*redis.StringCmd, 0, len(keys))
+	// Errors are checked per command below, given missing keys are reported as errors too. The pipeline
+	// is not sent if the request was cancelled before.
+	_, _ = c.client.Pipelined(func(p redis.Pipeliner) error {
+		for _, key := range keys {
+			cmds = append(cmds, p.Get(key))
+		}
+		return ctx.Err()
+	})
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	items := make(map[string][]byte, len(keys))
+	for i, cmd := range cmds {
+		value, err := cmd.Bytes()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		items[keys[i]] = value
+	}
+
+	return items, nil
+}
+
+func (c *redisClient) enqueueAsync(op func()) error {
+	select {
+	case c.asyncQueue <- op:
+		return nil
+	default:
+		return errRedisAsyncBufferFull
+	}
+}
+
+func (c *redisClient) asyncQueueProcessLoop() {
+	defer c.workers.Done()
+
+	for {
+		select {
+		case op := <-c.asyncQueue:
+			op()
+		case <-c.stop:
+			return
+		}
+	}
+}
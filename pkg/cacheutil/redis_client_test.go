@@ -0,0 +1,318 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package cacheutil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/fortytw2/leaktest"
+	"github.com/go-kit/kit/log"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestRedisClientConfig_validate(t *testing.T) {
+	tests := map[string]struct {
+		config      RedisClientConfig
+		expectedErr bool
+	}{
+		"should pass on valid single mode config": {
+			config: RedisClientConfig{Mode: RedisSingleMode, Addresses: []string{"127.0.0.1:6379"}},
+		},
+		"should fail on no addresses": {
+			config:      RedisClientConfig{Mode: RedisSingleMode},
+			expectedErr: true,
+		},
+		"should fail on multiple addresses in single mode": {
+			config:      RedisClientConfig{Mode: RedisSingleMode, Addresses: []string{"127.0.0.1:6379", "127.0.0.2:6379"}},
+			expectedErr: true,
+		},
+		"should pass on valid sentinel mode config": {
+			config: RedisClientConfig{Mode: RedisSentinelMode, Addresses: []string{"127.0.0.1:26379", "127.0.0.2:26379"}, MasterName: "master"},
+		},
+		"should fail on no master name in sentinel mode": {
+			config:      RedisClientConfig{Mode: RedisSentinelMode, Addresses: []string{"127.0.0.1:26379"}},
+			expectedErr: true,
+		},
+		"should pass on valid cluster mode config": {
+			config: RedisClientConfig{Mode: RedisClusterMode, Addresses: []string{"127.0.0.1:6379", "127.0.0.2:6379"}},
+		},
+		"should fail on database selection in cluster mode": {
+			config:      RedisClientConfig{Mode: RedisClusterMode, Addresses: []string{"127.0.0.1:6379"}, DB: 1},
+			expectedErr: true,
+		},
+		"should fail on unknown mode": {
+			config:      RedisClientConfig{Mode: "unknown", Addresses: []string{"127.0.0.1:6379"}},
+			expectedErr: true,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			err := testData.config.validate()
+			testutil.Equals(t, testData.expectedErr, err != nil)
+		})
+	}
+}
+
+func TestNewRedisClient(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Should return error on empty YAML config.
+	cache, err := NewRedisClient(log.NewNopLogger(), "test", []byte{}, nil)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, (*redisClient)(nil), cache)
+
+	// Should return error on invalid YAML config.
+	cache, err = NewRedisClient(log.NewNopLogger(), "test", []byte("invalid"), nil)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, (*redisClient)(nil), cache)
+
+	// Should instance a redis client with minimum YAML config.
+	cache, err = NewRedisClient(log.NewNopLogger(), "test", []byte(`
+addresses:
+  - 127.0.0.1:6379
+`), nil)
+	testutil.Ok(t, err)
+	defer cache.Stop()
+
+	testutil.Equals(t, RedisSingleMode, cache.config.Mode)
+	testutil.Equals(t, []string{"127.0.0.1:6379"}, cache.config.Addresses)
+	testutil.Equals(t, defaultRedisClientConfig.DialTimeout, cache.config.DialTimeout)
+	testutil.Equals(t, defaultRedisClientConfig.ReadTimeout, cache.config.ReadTimeout)
+	testutil.Equals(t, defaultRedisClientConfig.WriteTimeout, cache.config.WriteTimeout)
+	testutil.Equals(t, defaultRedisClientConfig.GetMultiTimeout, cache.config.GetMultiTimeout)
+	testutil.Equals(t, defaultRedisClientConfig.PoolSize, cache.config.PoolSize)
+	testutil.Equals(t, defaultRedisClientConfig.MaxAsyncConcurrency, cache.config.MaxAsyncConcurrency)
+	testutil.Equals(t, defaultRedisClientConfig.MaxAsyncBufferSize, cache.config.MaxAsyncBufferSize)
+	testutil.Equals(t, defaultRedisClientConfig.MaxGetMultiConcurrency, cache.config.MaxGetMultiConcurrency)
+	testutil.Equals(t, defaultRedisClientConfig.MaxGetMultiBatchSize, cache.config.MaxGetMultiBatchSize)
+	testutil.Equals(t, defaultRedisClientConfig.MaxItemSize, cache.config.MaxItemSize)
+
+	// Should instance a redis client with configured YAML config.
+	cache, err = NewRedisClient(log.NewNopLogger(), "test", []byte(`
+mode: sentinel
+addresses:
+  - 127.0.0.1:26379
+  - 127.0.0.2:26379
+master_name: master
+dial_timeout: 1s
+read_timeout: 2s
+write_timeout: 3s
+get_multi_timeout: 4s
+max_async_concurrency: 1
+max_async_buffer_size: 1
+max_get_multi_concurrency: 1
+max_get_multi_batch_size: 1
+max_item_size: 1MiB
+`), nil)
+	testutil.Ok(t, err)
+	defer cache.Stop()
+
+	testutil.Equals(t, RedisSentinelMode, cache.config.Mode)
+	testutil.Equals(t, "master", cache.config.MasterName)
+	testutil.Equals(t, 1*time.Second, cache.config.DialTimeout)
+	testutil.Equals(t, 2*time.Second, cache.config.ReadTimeout)
+	testutil.Equals(t, 3*time.Second, cache.config.WriteTimeout)
+	testutil.Equals(t, 4*time.Second, cache.config.GetMultiTimeout)
+	testutil.Equals(t, 1, cache.config.MaxAsyncConcurrency)
+	testutil.Equals(t, 1, cache.config.MaxAsyncBufferSize)
+	testutil.Equals(t, 1, cache.config.MaxGetMultiConcurrency)
+	testutil.Equals(t, 1, cache.config.MaxGetMultiBatchSize)
+	testutil.Equals(t, model.Bytes(1024*1024), cache.config.MaxItemSize)
+}
+
+func TestRedisClient_SetAsyncAndGetMulti(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	tests := map[string]struct {
+		maxBatchSize   int
+		maxConcurrency int
+	}{
+		"no batching": {},
+		"batching with unlimited concurrency": {
+			maxBatchSize: 2,
+		},
+		"batching with limited concurrency": {
+			maxBatchSize:   2,
+			maxConcurrency: 1,
+		},
+	}
+
+	for testName, testData := range tests {
+		t.Run(testName, func(t *testing.T) {
+			s, err := miniredis.Run()
+			testutil.Ok(t, err)
+			defer s.Close()
+
+			ctx := context.Background()
+			config := defaultRedisClientConfig
+			config.Addresses = []string{s.Addr()}
+			config.MaxGetMultiBatchSize = testData.maxBatchSize
+			config.MaxGetMultiConcurrency = testData.maxConcurrency
+
+			client, err := NewRedisClientWithConfig(log.NewNopLogger(), "test", config, nil)
+			testutil.Ok(t, err)
+			defer client.Stop()
+
+			for i := 0; i < 5; i++ {
+				testutil.Ok(t, client.SetAsync(ctx, fmt.Sprintf("key-%d", i), []byte(fmt.Sprintf("value-%d", i)), time.Hour))
+			}
+			testutil.Ok(t, runutil.Retry(10*time.Millisecond, ctx.Done(), func() error {
+				if n := int(prom_testutil.ToFloat64(client.operations.WithLabelValues(opSet))); n != 5 || len(s.Keys()) != 5 {
+					return fmt.Errorf("expected 5 set operations, got %d", n)
+				}
+				return nil
+			}))
+
+			hits := client.GetMulti(ctx, []string{"key-0", "key-1", "key-2", "key-3", "key-4", "missing"})
+			testutil.Equals(t, map[string][]byte{
+				"key-0": []byte("value-0"),
+				"key-1": []byte("value-1"),
+				"key-2": []byte("value-2"),
+				"key-3": []byte("value-3"),
+				"key-4": []byte("value-4"),
+			}, hits)
+
+			// The TTL should be honored.
+			s.FastForward(2 * time.Hour)
+			testutil.Equals(t, 0, len(client.GetMulti(ctx, []string{"key-0"})))
+		})
+	}
+}
+
+func TestRedisClient_SetAsyncWithCustomMaxItemSize(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	s, err := miniredis.Run()
+	testutil.Ok(t, err)
+	defer s.Close()
+
+	ctx := context.Background()
+	config := defaultRedisClientConfig
+	config.Addresses = []string{s.Addr()}
+	config.MaxItemSize = model.Bytes(10)
+
+	client, err := NewRedisClientWithConfig(log.NewNopLogger(), "test", config, nil)
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	testutil.Ok(t, client.SetAsync(ctx, "key-1", []byte("value-1"), time.Hour))
+	testutil.Ok(t, client.SetAsync(ctx, "key-2", []byte("value-2-too-long-to-be-stored"), time.Hour))
+	testutil.Ok(t, runutil.Retry(10*time.Millisecond, ctx.Done(), func() error {
+		if len(client.GetMulti(ctx, []string{"key-1"})) != 1 {
+			return fmt.Errorf("key-1 not stored yet")
+		}
+		return nil
+	}))
+
+	testutil.Equals(t, 1, int(prom_testutil.ToFloat64(client.operations.WithLabelValues(opSet))))
+	testutil.Equals(t, 1, int(prom_testutil.ToFloat64(client.skipped.WithLabelValues(opSet, reasonMaxItemSize))))
+	testutil.Equals(t, 0, len(client.GetMulti(ctx, []string{"key-2"})))
+}
+
+func TestRedisClient_GetMultiOnServerError(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	s, err := miniredis.Run()
+	testutil.Ok(t, err)
+
+	config := defaultRedisClientConfig
+	config.Addresses = []string{s.Addr()}
+	config.DialTimeout = 100 * time.Millisecond
+	config.MinIdleConnections = 0
+
+	client, err := NewRedisClientWithConfig(log.NewNopLogger(), "test", config, nil)
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	s.Close()
+
+	testutil.Equals(t, 0, len(client.GetMulti(context.Background(), []string{"key-1", "key-2"})))
+	testutil.Equals(t, 1, int(prom_testutil.ToFloat64(client.failures.WithLabelValues(opGetMulti))))
+}
+
+func TestRedisClient_GetMultiTimeout(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// A redis server accepting connections but never replying.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	testutil.Ok(t, err)
+	defer l.Close()
+
+	var (
+		mtx   sync.Mutex
+		conns []net.Conn
+	)
+	defer func() {
+		mtx.Lock()
+		defer mtx.Unlock()
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	}()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mtx.Lock()
+			conns = append(conns, c)
+			mtx.Unlock()
+		}
+	}()
+
+	config := defaultRedisClientConfig
+	config.Addresses = []string{l.Addr().String()}
+	config.ReadTimeout = time.Second
+	config.MinIdleConnections = 0
+	config.GetMultiTimeout = 100 * time.Millisecond
+
+	client, err := NewRedisClientWithConfig(log.NewNopLogger(), "test", config, nil)
+	testutil.Ok(t, err)
+	defer client.Stop()
+
+	start := time.Now()
+	testutil.Equals(t, 0, len(client.GetMulti(context.Background(), []string{"key-1", "key-2"})))
+	testutil.Assert(t, time.Since(start) < config.ReadTimeout, "GetMulti took %v", time.Since(start))
+	testutil.Equals(t, 1, int(prom_testutil.ToFloat64(client.failures.WithLabelValues(opGetMulti))))
+}
+
+func TestRedisClient_GetMultiAfterStopOrCancel(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	s, err := miniredis.Run()
+	testutil.Ok(t, err)
+	defer s.Close()
+	testutil.Ok(t, s.Set("key-1", "value-1"))
+
+	config := defaultRedisClientConfig
+	config.Addresses = []string{s.Addr()}
+	config.MaxGetMultiBatchSize = 1
+
+	client, err := NewRedisClientWithConfig(log.NewNopLogger(), "test", config, nil)
+	testutil.Ok(t, err)
+
+	// A cancelled request does not send the pipeline.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = client.pipelinedGetSync(ctx, []string{"key-1"})
+	testutil.NotOk(t, err)
+	testutil.Equals(t, 1, len(client.GetMulti(context.Background(), []string{"key-1", "key-2"})))
+
+	// Requests after stopping fail without starting workers.
+	client.Stop()
+	testutil.Equals(t, 0, len(client.GetMulti(context.Background(), []string{"key-1", "key-2"})))
+	_, err = client.pipelinedGet(context.Background(), []string{"key-1"})
+	testutil.Equals(t, errRedisClientStopped, err)
+}
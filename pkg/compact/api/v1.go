@@ -0,0 +1,162 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package v1
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/route"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/thanos-io/thanos/pkg/compact"
+	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	qapi "github.com/thanos-io/thanos/pkg/query/api"
+	"github.com/thanos-io/thanos/pkg/tracing"
+)
+
+// API serves deletion requests of the compactor.
+type API struct {
+	logger        log.Logger
+	bkt           objstore.Bucket
+	now           func() time.Time
+	pendingBlocks func(r *compact.DeletionRequest, now time.Time) int
+}
+
+// NewAPI returns a compactor API. The pendingBlocks function reports the number of blocks the given deletion
+// request was not yet applied to.
+func NewAPI(logger log.Logger, bkt objstore.Bucket, pendingBlocks func(r *compact.DeletionRequest, now time.Time) int) *API {
+	return &API{
+		logger:        logger,
+		bkt:           bkt,
+		now:           time.Now,
+		pendingBlocks: pendingBlocks,
+	}
+}
+
+func (api *API) Register(r *route.Router, tracer opentracing.Tracer, logger log.Logger, ins extpromhttp.InstrumentationMiddleware) {
+	instr := func(name string, f qapi.ApiFunc) http.HandlerFunc {
+		hf := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			qapi.SetCORS(w)
+			if data, warnings, err := f(r); err != nil {
+				qapi.RespondError(w, err, data)
+			} else if data != nil {
+				qapi.Respond(w, data, warnings)
+			} else {
+				w.WriteHeader(http.StatusNoContent)
+			}
+		})
+		return ins.NewHandler(name, tracing.HTTPMiddleware(tracer, name, logger, gziphandler.GzipHandler(hf)))
+	}
+
+	r.Get("/deletion-requests", instr("deletion_requests", api.deletionRequests))
+	r.Post("/deletion-requests", instr("create_deletion_requests", api.createDeletionRequests))
+	r.Del("/deletion-requests/:id", instr("cancel_deletion_request", api.cancelDeletionRequest))
+}
+
+// DeletionRequest is a deletion request together with the number of blocks it was not yet applied to.
+type DeletionRequest struct {
+	*compact.DeletionRequest
+	PendingBlocks int `json:"pending_blocks"`
+}
+
+func (api *API) deletionRequests(r *http.Request) (interface{}, []error, *qapi.ApiError) {
+	requests, err := compact.ReadDeletionRequests(r.Context(), api.logger, api.bkt)
+	if err != nil {
+		return nil, nil, &qapi.ApiError{Typ: qapi.ErrorInternal, Err: err}
+	}
+
+	now := api.now()
+	res := make([]DeletionRequest, 0, len(requests))
+	for _, req := range requests {
+		res = append(res, DeletionRequest{DeletionRequest: req, PendingBlocks: api.pendingBlocks(req, now)})
+	}
+	return res, nil, nil
+}
+
+func (api *API) createDeletionRequests(r *http.Request) (interface{}, []error, *qapi.ApiError) {
+	if err := r.ParseForm(); err != nil {
+		return nil, nil, &qapi.ApiError{Typ: qapi.ErrorInternal, Err: errors.Wrap(err, "parse form")}
+	}
+	if len(r.Form["match[]"]) == 0 {
+		return nil, nil, &qapi.ApiError{Typ: qapi.ErrorBadData, Err: errors.New("no match[] parameter provided")}
+	}
+
+	var (
+		minTime, maxTime int64
+		retention        time.Duration
+		err              error
+	)
+	if s := r.FormValue("retention"); s != "" {
+		d, err := model.ParseDuration(s)
+		if err != nil {
+			return nil, nil, &qapi.ApiError{Typ: qapi.ErrorBadData, Err: errors.Wrap(err, "parse retention")}
+		}
+		retention = time.Duration(d)
+	} else {
+		minTime, err = parseTimeParam(r, "start", math.MinInt64)
+		if err != nil {
+			return nil, nil, &qapi.ApiError{Typ: qapi.ErrorBadData, Err: err}
+		}
+		maxTime, err = parseTimeParam(r, "end", math.MaxInt64)
+		if err != nil {
+			return nil, nil, &qapi.ApiError{Typ: qapi.ErrorBadData, Err: err}
+		}
+	}
+
+	// Validate all requests before uploading any of them.
+	now := api.now()
+	requests := make([]*compact.DeletionRequest, 0, len(r.Form["match[]"]))
+	for i, s := range r.Form["match[]"] {
+		req, err := compact.NewDeletionRequest(s, minTime, maxTime, retention, now.Add(time.Duration(i)*time.Millisecond))
+		if err != nil {
+			return nil, nil, &qapi.ApiError{Typ: qapi.ErrorBadData, Err: errors.Wrapf(err, "deletion request for %q", s)}
+		}
+		requests = append(requests, req)
+	}
+	for _, req := range requests {
+		if err := compact.UploadDeletionRequest(r.Context(), api.bkt, req); err != nil {
+			return nil, nil, &qapi.ApiError{Typ: qapi.ErrorInternal, Err: err}
+		}
+	}
+	return requests, nil, nil
+}
+
+func (api *API) cancelDeletionRequest(r *http.Request) (interface{}, []error, *qapi.ApiError) {
+	id, err := ulid.Parse(route.Param(r.Context(), "id"))
+	if err != nil {
+		return nil, nil, &qapi.ApiError{Typ: qapi.ErrorBadData, Err: errors.Wrap(err, "parse deletion request id")}
+	}
+	if err := compact.CancelDeletionRequest(r.Context(), api.bkt, id); err != nil {
+		if err == compact.ErrDeletionRequestNotFound {
+			return nil, nil, &qapi.ApiError{Typ: qapi.ErrorBadData, Err: err}
+		}
+		return nil, nil, &qapi.ApiError{Typ: qapi.ErrorInternal, Err: err}
+	}
+	return nil, nil, nil
+}
+
+// parseTimeParam parses the time parameter given either as unix timestamp in seconds or in RFC3339 format
+// and returns it in milliseconds.
+func parseTimeParam(r *http.Request, paramName string, defaultValue int64) (int64, error) {
+	val := r.FormValue(paramName)
+	if val == "" {
+		return defaultValue, nil
+	}
+	if t, err := strconv.ParseFloat(val, 64); err == nil {
+		return int64(t * 1000), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, val); err == nil {
+		return timestamp.FromTime(t), nil
+	}
+	return 0, errors.Errorf("cannot parse %q to a valid timestamp for %s", val, paramName)
+}
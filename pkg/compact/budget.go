@@ -0,0 +1,96 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/thanos-io/thanos/pkg/objstore"
+)
+
+// diskBudget bounds the local disk space reserved by concurrent compactions. Nil budget or zero limit means no limit.
+type diskBudget struct {
+	logger log.Logger
+	limit  uint64
+
+	mtx      sync.Mutex
+	used     uint64
+	released chan struct{}
+}
+
+func newDiskBudget(logger log.Logger, limit uint64) *diskBudget {
+	return &diskBudget{logger: logger, limit: limit, released: make(chan struct{})}
+}
+
+func (b *diskBudget) limited() bool {
+	return b != nil && b.limit > 0
+}
+
+// acquire reserves n bytes, waiting until enough of the budget is released. A reservation exceeding the whole budget
+// is granted once nothing else is reserved, so a single large compaction still makes progress. The returned function
+// releases the reservation.
+func (b *diskBudget) acquire(ctx context.Context, n uint64) (func(), error) {
+	if !b.limited() {
+		return func() {}, nil
+	}
+	for {
+		b.mtx.Lock()
+		if b.used == 0 || b.used+n <= b.limit {
+			if n > b.limit {
+				level.Warn(b.logger).Log("msg", "compaction exceeds the disk budget", "bytes", n, "budget", b.limit)
+			}
+			b.used += n
+			b.mtx.Unlock()
+
+			var once sync.Once
+			return func() { once.Do(func() { b.release(n) }) }, nil
+		}
+		released := b.released
+		b.mtx.Unlock()
+
+		level.Debug(b.logger).Log("msg", "waiting for disk budget", "bytes", n, "budget", b.limit)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+func (b *diskBudget) release(n uint64) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	b.used -= n
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// blockSize returns the total size of the objects of the block in the bucket.
+func blockSize(ctx context.Context, bkt objstore.BucketReader, id ulid.ULID) (uint64, error) {
+	var size uint64
+	var iter func(dir string) error
+	iter = func(dir string) error {
+		return bkt.Iter(ctx, dir, func(name string) error {
+			if strings.HasSuffix(name, objstore.DirDelim) {
+				return iter(name)
+			}
+			s, err := bkt.ObjectSize(ctx, name)
+			if err != nil {
+				return err
+			}
+			size += s
+			return nil
+		})
+	}
+	if err := iter(id.String()); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
@@ -0,0 +1,82 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestDiskBudget(t *testing.T) {
+	ctx := context.Background()
+
+	// No budget means no limit.
+	var unlimited *diskBudget
+	release, err := unlimited.acquire(ctx, 1<<40)
+	testutil.Ok(t, err)
+	release()
+
+	b := newDiskBudget(log.NewNopLogger(), 100)
+	release60, err := b.acquire(ctx, 60)
+	testutil.Ok(t, err)
+	release40, err := b.acquire(ctx, 40)
+	testutil.Ok(t, err)
+
+	// Exhausted budget blocks until released.
+	acquired := make(chan func())
+	go func() {
+		release, err := b.acquire(ctx, 50)
+		testutil.Ok(t, err)
+		acquired <- release
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("expected acquire to wait for exhausted budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release60()
+	// Releasing twice is a noop.
+	release60()
+	release50 := <-acquired
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	_, err = b.acquire(timeoutCtx, 20)
+	testutil.Equals(t, context.DeadlineExceeded, err)
+
+	// Reservations larger than the budget are granted once nothing else is reserved.
+	go func() {
+		release, err := b.acquire(ctx, 200)
+		testutil.Ok(t, err)
+		acquired <- release
+	}()
+	release40()
+	release50()
+	release200 := <-acquired
+	testutil.Equals(t, uint64(200), b.used)
+	release200()
+	testutil.Equals(t, uint64(0), b.used)
+}
+
+func TestBlockSize(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+
+	id, other := ulid.MustNew(1, nil), ulid.MustNew(2, nil)
+	testutil.Ok(t, bkt.Upload(ctx, id.String()+"/meta.json", bytes.NewReader(make([]byte, 3))))
+	testutil.Ok(t, bkt.Upload(ctx, id.String()+"/index", bytes.NewReader(make([]byte, 10))))
+	testutil.Ok(t, bkt.Upload(ctx, id.String()+"/chunks/000001", bytes.NewReader(make([]byte, 100))))
+	testutil.Ok(t, bkt.Upload(ctx, other.String()+"/index", bytes.NewReader(make([]byte, 1000))))
+
+	size, err := blockSize(ctx, bkt, id)
+	testutil.Ok(t, err)
+	testutil.Equals(t, uint64(113), size)
+}
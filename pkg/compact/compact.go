@@ -53,6 +53,9 @@ type Syncer struct {
 	enableVerticalCompaction bool
 	duplicateBlocksFilter    *block.DeduplicateFilter
 	ignoreDeletionMarkFilter *block.IgnoreDeletionMarkFilter
+	noCompactMarkFilter      *block.NoCompactMarkFilter
+	deletionRequests         []*DeletionRequest
+	repairBkt                objstore.Bucket
 }
 
 type syncerMetrics struct {
@@ -66,6 +69,7 @@ type syncerMetrics struct {
 	compactionFailures        *prometheus.CounterVec
 	verticalCompactions       *prometheus.CounterVec
 	blocksMarkedForDeletion   prometheus.Counter
+	repairedBlocks            prometheus.Counter
 }
 
 func newSyncerMetrics(reg prometheus.Registerer, blocksMarkedForDeletion prometheus.Counter) *syncerMetrics {
@@ -109,6 +113,10 @@ func newSyncerMetrics(reg prometheus.Registerer, blocksMarkedForDeletion prometh
 		Name: "thanos_compact_group_vertical_compactions_total",
 		Help: "Total number of group compaction attempts that resulted in a new block based on overlapping blocks.",
 	}, []string{"group"})
+	m.repairedBlocks = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_compact_repaired_blocks_total",
+		Help: "Total number of input blocks with out of order or duplicated chunks repaired before compaction.",
+	})
 	m.blocksMarkedForDeletion = blocksMarkedForDeletion
 
 	return &m
@@ -116,7 +124,8 @@ func newSyncerMetrics(reg prometheus.Registerer, blocksMarkedForDeletion prometh
 
 // NewMetaSyncer returns a new Syncer for the given Bucket and directory.
 // Blocks must be at least as old as the sync delay for being considered.
-func NewSyncer(logger log.Logger, reg prometheus.Registerer, bkt objstore.Bucket, fetcher block.MetadataFetcher, duplicateBlocksFilter *block.DeduplicateFilter, ignoreDeletionMarkFilter *block.IgnoreDeletionMarkFilter, blocksMarkedForDeletion prometheus.Counter, blockSyncConcurrency int, acceptMalformedIndex bool, enableVerticalCompaction bool) (*Syncer, error) {
+// The noCompactMarkFilter is optional. If set, blocks it found marked for no compaction are not compacted.
+func NewSyncer(logger log.Logger, reg prometheus.Registerer, bkt objstore.Bucket, fetcher block.MetadataFetcher, duplicateBlocksFilter *block.DeduplicateFilter, ignoreDeletionMarkFilter *block.IgnoreDeletionMarkFilter, noCompactMarkFilter *block.NoCompactMarkFilter, blocksMarkedForDeletion prometheus.Counter, blockSyncConcurrency int, acceptMalformedIndex bool, enableVerticalCompaction bool) (*Syncer, error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
@@ -129,6 +138,7 @@ func NewSyncer(logger log.Logger, reg prometheus.Registerer, bkt objstore.Bucket
 		metrics:                  newSyncerMetrics(reg, blocksMarkedForDeletion),
 		duplicateBlocksFilter:    duplicateBlocksFilter,
 		ignoreDeletionMarkFilter: ignoreDeletionMarkFilter,
+		noCompactMarkFilter:      noCompactMarkFilter,
 		blockSyncConcurrency:     blockSyncConcurrency,
 		acceptMalformedIndex:     acceptMalformedIndex,
 		// The syncer offers an option to enable vertical compaction, even if it's
@@ -138,20 +148,24 @@ func NewSyncer(logger log.Logger, reg prometheus.Registerer, bkt objstore.Bucket
 	}, nil
 }
 
-// UntilNextDownsampling calculates how long it will take until the next downsampling operation.
-// Returns an error if there will be no downsampling.
-func UntilNextDownsampling(m *metadata.Meta) (time.Duration, error) {
+// EnableChunksRepair makes compaction groups repair input blocks with out of order or duplicated chunks, which would
+// otherwise halt the compaction. Original blocks are backed up to the given bucket before being repaired.
+func (s *Syncer) EnableChunksRepair(backupBkt objstore.Bucket) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.repairBkt = backupBkt
+}
+
+// UntilNextDownsampling calculates how long it will take until the next downsampling operation according to
+// the given downsampling levels. Returns an error if there will be no downsampling.
+func UntilNextDownsampling(m *metadata.Meta, levels downsample.Levels) (time.Duration, error) {
 	timeRange := time.Duration((m.MaxTime - m.MinTime) * int64(time.Millisecond))
-	switch m.Thanos.Downsample.Resolution {
-	case downsample.ResLevel2:
+	next, ok := levels.Next(m.Thanos.Downsample.Resolution)
+	if !ok {
 		return time.Duration(0), errors.New("no downsampling")
-	case downsample.ResLevel1:
-		return time.Duration(downsample.DownsampleRange1*time.Millisecond) - timeRange, nil
-	case downsample.ResLevel0:
-		return time.Duration(downsample.DownsampleRange0*time.Millisecond) - timeRange, nil
-	default:
-		panic(errors.Errorf("invalid resolution %v", m.Thanos.Downsample.Resolution))
 	}
+	return time.Duration(next.MinBlockRange*int64(time.Millisecond)) - timeRange, nil
 }
 
 // SyncMetas synchronises local state of block metas with what we have in the bucket.
@@ -163,8 +177,13 @@ func (s *Syncer) SyncMetas(ctx context.Context) error {
 	if err != nil {
 		return retry(err)
 	}
+	requests, err := ReadDeletionRequests(ctx, s.logger, s.bkt)
+	if err != nil {
+		return retry(err)
+	}
 	s.blocks = metas
 	s.partial = partial
+	s.deletionRequests = requests
 	return nil
 }
 
@@ -184,13 +203,40 @@ func (s *Syncer) Metas() map[ulid.ULID]*metadata.Meta {
 	return s.blocks
 }
 
+// DeletionRequests returns deletion requests loaded since last sync.
+func (s *Syncer) DeletionRequests() []*DeletionRequest {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.deletionRequests
+}
+
+// PendingDeletionBlocks returns the number of blocks loaded since last sync to which the deletion request applies,
+// but was not yet applied.
+func (s *Syncer) PendingDeletionBlocks(r *DeletionRequest, now time.Time) int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	n := 0
+	for _, m := range s.blocks {
+		if _, ok := r.Interval(m, now); ok && !r.AppliedTo(m) {
+			n++
+		}
+	}
+	return n
+}
+
 // GroupKey returns a unique identifier for the group the block belongs to. It considers
-// the downsampling resolution and the block's labels.
+// the downsampling resolution, the block's labels and the shard of series the block holds.
+// Blocks of different shards are compacted separately, as they complement rather than overlap each other.
 func GroupKey(meta metadata.Thanos) string {
-	return groupKey(meta.Downsample.Resolution, labels.FromMap(meta.Labels))
+	return groupKey(meta.Downsample.Resolution, labels.FromMap(meta.Labels), meta.Shard)
 }
 
-func groupKey(res int64, lbls labels.Labels) string {
+func groupKey(res int64, lbls labels.Labels, shard *metadata.ThanosShard) string {
+	if shard != nil {
+		return fmt.Sprintf("%d@%v@%s", res, lbls.Hash(), shard)
+	}
 	return fmt.Sprintf("%d@%v", res, lbls.Hash())
 }
 
@@ -200,6 +246,11 @@ func (s *Syncer) Groups() (res []*Group, err error) {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
+	var noCompactMarks map[ulid.ULID]*metadata.NoCompactMark
+	if s.noCompactMarkFilter != nil {
+		noCompactMarks = s.noCompactMarkFilter.NoCompactMarkedBlocks()
+	}
+
 	groups := map[string]*Group{}
 	for _, m := range s.blocks {
 		groupKey := GroupKey(m.Thanos)
@@ -211,6 +262,7 @@ func (s *Syncer) Groups() (res []*Group, err error) {
 				s.bkt,
 				lbls,
 				m.Thanos.Downsample.Resolution,
+				m.Thanos.Shard,
 				s.acceptMalformedIndex,
 				s.enableVerticalCompaction,
 				s.metrics.compactions.WithLabelValues(groupKey),
@@ -220,6 +272,9 @@ func (s *Syncer) Groups() (res []*Group, err error) {
 				s.metrics.verticalCompactions.WithLabelValues(groupKey),
 				s.metrics.garbageCollectedBlocks,
 				s.metrics.blocksMarkedForDeletion,
+				s.deletionRequests,
+				s.repairBkt,
+				s.metrics.repairedBlocks,
 			)
 			if err != nil {
 				return nil, errors.Wrap(err, "create compaction group")
@@ -227,6 +282,10 @@ func (s *Syncer) Groups() (res []*Group, err error) {
 			groups[groupKey] = g
 			res = append(res, g)
 		}
+		if _, ok := noCompactMarks[m.ULID]; ok {
+			g.addNoCompact(m)
+			continue
+		}
 		if err := g.Add(m); err != nil {
 			return nil, errors.Wrap(err, "add compaction group")
 		}
@@ -286,15 +345,17 @@ func (s *Syncer) GarbageCollect(ctx context.Context) error {
 	return nil
 }
 
-// Group captures a set of blocks that have the same origin labels and downsampling resolution.
+// Group captures a set of blocks that have the same origin labels, downsampling resolution and shard.
 // Those blocks generally contain the same series and can thus efficiently be compacted.
 type Group struct {
 	logger                      log.Logger
 	bkt                         objstore.Bucket
 	labels                      labels.Labels
 	resolution                  int64
+	shard                       *metadata.ThanosShard
 	mtx                         sync.Mutex
 	blocks                      map[ulid.ULID]*metadata.Meta
+	noCompactBlocks             map[ulid.ULID]*metadata.Meta
 	acceptMalformedIndex        bool
 	enableVerticalCompaction    bool
 	compactions                 prometheus.Counter
@@ -304,6 +365,9 @@ type Group struct {
 	verticalCompactions         prometheus.Counter
 	groupGarbageCollectedBlocks prometheus.Counter
 	blocksMarkedForDeletion     prometheus.Counter
+	deletionRequests            []*DeletionRequest
+	repairBkt                   objstore.Bucket
+	repairedBlocks              prometheus.Counter
 }
 
 // newGroup returns a new compaction group.
@@ -312,6 +376,7 @@ func newGroup(
 	bkt objstore.Bucket,
 	lset labels.Labels,
 	resolution int64,
+	shard *metadata.ThanosShard,
 	acceptMalformedIndex bool,
 	enableVerticalCompaction bool,
 	compactions prometheus.Counter,
@@ -321,6 +386,9 @@ func newGroup(
 	verticalCompactions prometheus.Counter,
 	groupGarbageCollectedBlocks prometheus.Counter,
 	blocksMarkedForDeletion prometheus.Counter,
+	deletionRequests []*DeletionRequest,
+	repairBkt objstore.Bucket,
+	repairedBlocks prometheus.Counter,
 ) (*Group, error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
@@ -330,7 +398,9 @@ func newGroup(
 		bkt:                         bkt,
 		labels:                      lset,
 		resolution:                  resolution,
+		shard:                       shard,
 		blocks:                      map[ulid.ULID]*metadata.Meta{},
+		noCompactBlocks:             map[ulid.ULID]*metadata.Meta{},
 		acceptMalformedIndex:        acceptMalformedIndex,
 		enableVerticalCompaction:    enableVerticalCompaction,
 		compactions:                 compactions,
@@ -340,13 +410,16 @@ func newGroup(
 		verticalCompactions:         verticalCompactions,
 		groupGarbageCollectedBlocks: groupGarbageCollectedBlocks,
 		blocksMarkedForDeletion:     blocksMarkedForDeletion,
+		deletionRequests:            deletionRequests,
+		repairBkt:                   repairBkt,
+		repairedBlocks:              repairedBlocks,
 	}
 	return g, nil
 }
 
 // Key returns an identifier for the group.
 func (cg *Group) Key() string {
-	return groupKey(cg.resolution, cg.labels)
+	return groupKey(cg.resolution, cg.labels, cg.shard)
 }
 
 // Add the block with the given meta to the group.
@@ -360,10 +433,22 @@ func (cg *Group) Add(meta *metadata.Meta) error {
 	if cg.resolution != meta.Thanos.Downsample.Resolution {
 		return errors.New("block and group resolution do not match")
 	}
+	if cg.shard.String() != meta.Thanos.Shard.String() {
+		return errors.New("block and group shard do not match")
+	}
 	cg.blocks[meta.ULID] = meta
 	return nil
 }
 
+// addNoCompact adds the block of the group which is marked for no compaction. It is never planned, but
+// compactions are not planned over its time range either, so compacted blocks never overlap it.
+func (cg *Group) addNoCompact(meta *metadata.Meta) {
+	cg.mtx.Lock()
+	defer cg.mtx.Unlock()
+
+	cg.noCompactBlocks[meta.ULID] = meta
+}
+
 // IDs returns all sorted IDs of blocks in the group.
 func (cg *Group) IDs() (ids []ulid.ULID) {
 	cg.mtx.Lock()
@@ -388,33 +473,23 @@ func (cg *Group) Resolution() int64 {
 	return cg.resolution
 }
 
-// Compact plans and runs a single compaction against the group. The compacted result
-// is uploaded into the bucket the blocks were retrieved from.
-func (cg *Group) Compact(ctx context.Context, dir string, comp tsdb.Compactor) (bool, ulid.ULID, error) {
-	cg.compactionRunsStarted.Inc()
-
-	subDir := filepath.Join(dir, cg.Key())
-
-	defer func() {
-		if err := os.RemoveAll(subDir); err != nil {
-			level.Error(cg.logger).Log("msg", "failed to remove compaction group work directory", "path", subDir, "err", err)
-		}
-	}()
+// Shard returns the common shard of series of blocks in the group. Nil shard means all series.
+func (cg *Group) Shard() *metadata.ThanosShard {
+	return cg.shard
+}
 
-	if err := os.RemoveAll(subDir); err != nil {
-		return false, ulid.ULID{}, errors.Wrap(err, "clean compaction group dir")
-	}
-	if err := os.MkdirAll(subDir, 0777); err != nil {
-		return false, ulid.ULID{}, errors.Wrap(err, "create compaction group dir")
+// Compact plans and runs a single compaction against the group. The compacted result
+// is uploaded into the bucket the blocks were retrieved from. It is split into blocks of shards
+// of series if it would exceed limits of the split config.
+func (cg *Group) Compact(ctx context.Context, dir string, comp tsdb.Compactor, split SplitConfig) (bool, []ulid.ULID, error) {
+	job, err := cg.prepare(ctx, dir, comp, nil)
+	if err != nil || job == nil {
+		return false, nil, err
 	}
-
-	shouldRerun, compID, err := cg.compact(ctx, subDir, comp)
-	if err != nil {
-		cg.compactionFailures.Inc()
-		return false, ulid.ULID{}, err
+	if err := job.compact(comp, split); err != nil {
+		return false, nil, err
 	}
-	cg.compactionRunsCompleted.Inc()
-	return shouldRerun, compID, nil
+	return job.upload(ctx)
 }
 
 // Issue347Error is a type wrapper for errors that should invoke repair process for broken block.
@@ -592,42 +667,193 @@ func RepairIssue347(ctx context.Context, logger log.Logger, bkt objstore.Bucket,
 	return nil
 }
 
-func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (shouldRerun bool, compID ulid.ULID, err error) {
+// compactionJob is a planned compaction of a group. Its input blocks are downloaded, compacted and the result
+// is uploaded in separate stages, so the BucketCompactor can run stages of different groups concurrently.
+type compactionJob struct {
+	group       *Group
+	dir         string
+	plan        []string
+	journal     *Journal
+	overlapping bool
+	now         time.Time
+	compIDs     []ulid.ULID
+	release     func()
+}
+
+// finish releases resources of the job succeeded in any stage.
+func (job *compactionJob) finish() {
+	job.release()
+	if err := os.RemoveAll(job.dir); err != nil {
+		level.Error(job.group.logger).Log("msg", "failed to remove compaction group work directory", "path", job.dir, "err", err)
+	}
+	job.group.compactionRunsCompleted.Inc()
+}
+
+// fail releases resources of the job failed in any stage. The work directory is kept, so the compaction
+// can be resumed from its journal.
+func (job *compactionJob) fail() {
+	job.release()
+	job.group.compactionFailures.Inc()
+}
+
+// compact compacts the downloaded input blocks of the job, unless it was resumed with the output blocks written already.
+func (job *compactionJob) compact(comp tsdb.Compactor, split SplitConfig) error {
+	if err := job.group.compactDownloaded(job, comp, split); err != nil {
+		job.fail()
+		return err
+	}
+	return nil
+}
+
+// upload uploads the compacted blocks of the job and deletes its input blocks. It returns true if the group
+// should be compacted again.
+func (job *compactionJob) upload(ctx context.Context) (bool, []ulid.ULID, error) {
+	if len(job.compIDs) == 0 {
+		// Compaction resulted in no block, but there may be more work to do.
+		job.finish()
+		return true, nil, nil
+	}
+	if err := job.group.uploadCompacted(ctx, job.dir, job.compIDs, job.plan); err != nil {
+		job.fail()
+		return false, nil, err
+	}
+	job.finish()
+	return true, job.compIDs, nil
+}
+
+// prepare plans a compaction of the group and downloads its input blocks into the group work directory in dir.
+// Before downloading, twice the size of the input blocks is reserved from the budget, accounting for the
+// input and output blocks. Nil job is returned if there is nothing to compact.
+func (cg *Group) prepare(ctx context.Context, dir string, comp tsdb.Compactor, budget *diskBudget) (*compactionJob, error) {
+	cg.compactionRunsStarted.Inc()
+
+	job := &compactionJob{group: cg, dir: filepath.Join(dir, cg.Key()), release: func() {}}
+	if err := os.MkdirAll(job.dir, 0777); err != nil {
+		job.fail()
+		return nil, errors.Wrap(err, "create compaction group dir")
+	}
+	planned, err := cg.download(ctx, job, comp, budget)
+	if err != nil {
+		job.fail()
+		return nil, err
+	}
+	if !planned {
+		job.finish()
+		return nil, nil
+	}
+	return job, nil
+}
+
+func (cg *Group) download(ctx context.Context, job *compactionJob, comp tsdb.Compactor, budget *diskBudget) (bool, error) {
 	cg.mtx.Lock()
 	defer cg.mtx.Unlock()
 
+	dir := job.dir
+
 	// Check for overlapped blocks.
-	overlappingBlocks := false
 	if err := cg.areBlocksOverlapping(nil); err != nil {
 		// TODO(bwplotka): It would really nice if we could still check for other overlaps than replica. In fact this should be checked
 		// in syncer itself. Otherwise with vertical compaction enabled we will sacrifice this important check.
 		if !cg.enableVerticalCompaction {
-			return false, ulid.ULID{}, halt(errors.Wrap(err, "pre compaction overlap check"))
+			return false, halt(errors.Wrap(err, "pre compaction overlap check"))
 		}
 
-		overlappingBlocks = true
+		job.overlapping = true
+	}
+
+	// A journal is left by a previous compaction of the group which was interrupted.
+	j, err := readJournal(dir)
+	if err != nil {
+		level.Warn(cg.logger).Log("msg", "discarding invalid compaction journal", "err", err)
+		j = nil
+	}
+	if j != nil && j.Stage == JournalStageUpload {
+		if plan, ok := cg.resumableUpload(dir, j); ok {
+			level.Info(cg.logger).Log("msg", "resuming upload of compacted blocks", "result_blocks", fmt.Sprintf("%v", j.Outputs), "blocks", fmt.Sprintf("%v", plan))
+			job.plan, job.journal, job.compIDs = plan, j, j.Outputs
+			return true, nil
+		}
+		j.Stage, j.Outputs = JournalStageDownload, nil
+	}
+	if err := cg.cleanWorkDir(dir, j); err != nil {
+		return false, errors.Wrap(err, "clean compaction group dir")
 	}
 
 	// Planning a compaction works purely based on the meta.json files in our future group's dir.
 	// So we first dump all our memory block metas into the directory.
 	for _, meta := range cg.blocks {
+		if j != nil && j.isVerified(meta.ULID) {
+			// Already downloaded blocks keep their meta.json, as it records applied deletion requests.
+			continue
+		}
 		bdir := filepath.Join(dir, meta.ULID.String())
 		if err := os.MkdirAll(bdir, 0777); err != nil {
-			return false, ulid.ULID{}, errors.Wrap(err, "create planning block dir")
+			return false, errors.Wrap(err, "create planning block dir")
 		}
 		if err := metadata.Write(cg.logger, bdir, meta); err != nil {
-			return false, ulid.ULID{}, errors.Wrap(err, "write planning meta file")
+			return false, errors.Wrap(err, "write planning meta file")
 		}
 	}
 
 	// Plan against the written meta.json files.
-	plan, err := comp.Plan(dir)
-	if err != nil {
-		return false, ulid.ULID{}, errors.Wrap(err, "plan compaction")
+	var plan []string
+	for {
+		plan, err = comp.Plan(dir)
+		if err != nil {
+			return false, errors.Wrap(err, "plan compaction")
+		}
+		if len(plan) == 0 {
+			// Nothing to do.
+			return false, nil
+		}
+		if plan, err = cg.trimNoCompact(plan); err != nil {
+			return false, errors.Wrap(err, "trim planned compaction")
+		}
+		if len(plan) > 0 {
+			break
+		}
+		// The planned blocks cannot be compacted without spanning over a block marked for no compaction,
+		// so they are left out of planning, which lets the planner pick other blocks.
+		level.Debug(cg.logger).Log("msg", "planned compaction spans over block marked for no compaction; skipping")
 	}
-	if len(plan) == 0 {
-		// Nothing to do.
-		return false, ulid.ULID{}, nil
+
+	inputs := make([]ulid.ULID, 0, len(plan))
+	for _, pdir := range plan {
+		id, err := ulid.Parse(filepath.Base(pdir))
+		if err != nil {
+			return false, errors.Wrapf(err, "plan dir %s", pdir)
+		}
+		inputs = append(inputs, id)
+	}
+	if j == nil || !j.hasInputs(inputs) {
+		// Blocks downloaded for a different plan are still reused, as blocks are immutable.
+		nj := newJournal(inputs)
+		for _, id := range inputs {
+			if j != nil && j.isVerified(id) {
+				nj.Verified = append(nj.Verified, id)
+			}
+		}
+		j = nj
+	}
+	if err := writeJournal(cg.logger, dir, j); err != nil {
+		return false, errors.Wrap(err, "write compaction journal")
+	}
+	job.plan, job.journal = plan, j
+
+	if budget.limited() {
+		var size uint64
+		for _, id := range inputs {
+			s, err := blockSize(ctx, cg.bkt, id)
+			if err != nil {
+				return false, retry(errors.Wrapf(err, "get size of block %s", id))
+			}
+			size += s
+		}
+		release, err := budget.acquire(ctx, 2*size)
+		if err != nil {
+			return false, errors.Wrap(err, "reserve disk budget")
+		}
+		job.release = release
 	}
 
 	level.Info(cg.logger).Log("msg", "compaction available and planned; downloading blocks", "plan", fmt.Sprintf("%v", plan))
@@ -638,66 +864,123 @@ func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (
 
 	// Once we have a plan we need to download the actual data.
 	begin := time.Now()
+	job.now = begin
 
-	for _, pdir := range plan {
+	for i, pdir := range plan {
+		id := inputs[i]
 		meta, err := metadata.Read(pdir)
 		if err != nil {
-			return false, ulid.ULID{}, errors.Wrapf(err, "read meta from %s", pdir)
+			return false, errors.Wrapf(err, "read meta from %s", pdir)
 		}
 
 		cgKey, groupKey := cg.Key(), GroupKey(meta.Thanos)
 		if cgKey != groupKey {
-			return false, ulid.ULID{}, halt(errors.Wrapf(err, "compact planned compaction for mixed groups. group: %s, planned block's group: %s", cgKey, groupKey))
+			return false, halt(errors.Wrapf(err, "compact planned compaction for mixed groups. group: %s, planned block's group: %s", cgKey, groupKey))
 		}
 
 		for _, s := range meta.Compaction.Sources {
 			if _, ok := uniqueSources[s]; ok {
-				return false, ulid.ULID{}, halt(errors.Errorf("overlapping sources detected for plan %v", plan))
+				return false, halt(errors.Errorf("overlapping sources detected for plan %v", plan))
 			}
 			uniqueSources[s] = struct{}{}
 		}
 
-		id, err := ulid.Parse(filepath.Base(pdir))
-		if err != nil {
-			return false, ulid.ULID{}, errors.Wrapf(err, "plan dir %s", pdir)
-		}
-
 		if meta.ULID.Compare(id) != 0 {
-			return false, ulid.ULID{}, errors.Errorf("mismatch between meta %s and dir %s", meta.ULID, id)
+			return false, errors.Errorf("mismatch between meta %s and dir %s", meta.ULID, id)
 		}
 
-		if err := block.Download(ctx, cg.logger, cg.bkt, id, pdir); err != nil {
-			return false, ulid.ULID{}, retry(errors.Wrapf(err, "download block %s", id))
-		}
+		if j.isVerified(id) {
+			level.Debug(cg.logger).Log("msg", "reusing downloaded and verified block", "block", id)
+		} else {
+			if err := block.Download(ctx, cg.logger, cg.bkt, id, pdir); err != nil {
+				return false, retry(errors.Wrapf(err, "download block %s", id))
+			}
 
-		// Ensure all input blocks are valid.
-		stats, err := block.GatherIndexIssueStats(cg.logger, filepath.Join(pdir, block.IndexFilename), meta.MinTime, meta.MaxTime)
-		if err != nil {
-			return false, ulid.ULID{}, errors.Wrapf(err, "gather index issues for block %s", pdir)
-		}
+			// Ensure all input blocks are valid.
+			stats, err := block.GatherIndexIssueStats(cg.logger, filepath.Join(pdir, block.IndexFilename), meta.MinTime, meta.MaxTime)
+			if err != nil {
+				return false, errors.Wrapf(err, "gather index issues for block %s", pdir)
+			}
+
+			if cg.repairable(meta, stats) {
+				if meta, err = cg.repairChunks(ctx, pdir, meta, stats); err != nil {
+					return false, errors.Wrapf(err, "repair block %s", pdir)
+				}
+				stats, err = block.GatherIndexIssueStats(cg.logger, filepath.Join(pdir, block.IndexFilename), meta.MinTime, meta.MaxTime)
+				if err != nil {
+					return false, errors.Wrapf(err, "gather index issues for repaired block %s", pdir)
+				}
+			}
+
+			if err := stats.CriticalErr(); err != nil {
+				return false, halt(errors.Wrapf(err, "block with not healthy index found %s; Compaction level %v; Labels: %v", pdir, meta.Compaction.Level, meta.Thanos.Labels))
+			}
+
+			if err := stats.Issue347OutsideChunksErr(); err != nil {
+				return false, issue347Error(errors.Wrapf(err, "invalid, but reparable block %s", pdir), meta.ULID)
+			}
 
-		if err := stats.CriticalErr(); err != nil {
-			return false, ulid.ULID{}, halt(errors.Wrapf(err, "block with not healthy index found %s; Compaction level %v; Labels: %v", pdir, meta.Compaction.Level, meta.Thanos.Labels))
+			if err := stats.PrometheusIssue5372Err(); !cg.acceptMalformedIndex && err != nil {
+				return false, errors.Wrapf(err,
+					"block id %s, try running with --debug.accept-malformed-index", id)
+			}
 		}
 
-		if err := stats.Issue347OutsideChunksErr(); err != nil {
-			return false, ulid.ULID{}, issue347Error(errors.Wrapf(err, "invalid, but reparable block %s", pdir), meta.ULID)
+		// Apply pending deletion requests before compaction, so the compacted block does not contain deleted series.
+		// The meta.json of reused blocks records deletion requests applied already.
+		if pending := PendingDeletionRequests(meta, cg.deletionRequests, job.now); len(pending) > 0 {
+			if _, err := ApplyDeletionRequests(cg.logger, pdir, *meta, pending, job.now); err != nil {
+				return false, errors.Wrapf(err, "apply deletion requests to block %s", id)
+			}
 		}
 
-		if err := stats.PrometheusIssue5372Err(); !cg.acceptMalformedIndex && err != nil {
-			return false, ulid.ULID{}, errors.Wrapf(err,
-				"block id %s, try running with --debug.accept-malformed-index", id)
+		if !j.isVerified(id) {
+			j.Verified = append(j.Verified, id)
+			if err := writeJournal(cg.logger, dir, j); err != nil {
+				return false, errors.Wrap(err, "write compaction journal")
+			}
 		}
 	}
-	level.Info(cg.logger).Log("msg", "downloaded and verified blocks; compacting blocks", "plan", fmt.Sprintf("%v", plan), "duration", time.Since(begin))
+	level.Info(cg.logger).Log("msg", "downloaded and verified blocks", "plan", fmt.Sprintf("%v", plan), "duration", time.Since(begin))
+	return true, nil
+}
 
-	begin = time.Now()
+func (cg *Group) compactDownloaded(job *compactionJob, comp tsdb.Compactor, split SplitConfig) error {
+	if job.journal.Stage == JournalStageUpload {
+		return nil
+	}
 
-	compID, err = comp.Compact(dir, plan, nil)
+	cg.mtx.Lock()
+	defer cg.mtx.Unlock()
+
+	dir, plan := job.dir, job.plan
+	n, err := split.shards(plan)
 	if err != nil {
-		return false, ulid.ULID{}, halt(errors.Wrapf(err, "compact blocks %v", plan))
+		return errors.Wrap(err, "estimate compacted block size")
+	}
+	level.Info(cg.logger).Log("msg", "compacting blocks", "plan", fmt.Sprintf("%v", plan), "shards", n)
+
+	begin := time.Now()
+
+	var (
+		compIDs []ulid.ULID
+		shards  []*metadata.ThanosShard
+	)
+	if n > 1 {
+		compIDs, shards, err = cg.compactSplit(dir, plan, comp, cg.shard.Split(n))
+		if err != nil {
+			return err
+		}
+	} else {
+		compID, err := comp.Compact(dir, plan, nil)
+		if err != nil {
+			return halt(errors.Wrapf(err, "compact blocks %v", plan))
+		}
+		if compID != (ulid.ULID{}) {
+			compIDs, shards = append(compIDs, compID), append(shards, cg.shard)
+		}
 	}
-	if compID == (ulid.ULID{}) {
+	if len(compIDs) == 0 {
 		// Prometheus compactor found that the compacted block would have no samples.
 		level.Info(cg.logger).Log("msg", "compacted block would have no samples, deleting source blocks", "blocks", fmt.Sprintf("%v", plan))
 		for _, block := range plan {
@@ -712,17 +995,76 @@ func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (
 				}
 			}
 		}
-		// Even though this block was empty, there may be more work to do.
-		return true, ulid.ULID{}, nil
+		return nil
 	}
 	cg.compactions.Inc()
-	if overlappingBlocks {
+	if job.overlapping {
 		cg.verticalCompactions.Inc()
 	}
-	level.Info(cg.logger).Log("msg", "compacted blocks", "new", compID,
-		"blocks", fmt.Sprintf("%v", plan), "duration", time.Since(begin), "overlapping_blocks", overlappingBlocks)
+	level.Info(cg.logger).Log("msg", "compacted blocks", "new", fmt.Sprintf("%v", compIDs),
+		"blocks", fmt.Sprintf("%v", plan), "duration", time.Since(begin), "overlapping_blocks", job.overlapping)
+
+	for i, compID := range compIDs {
+		if err := cg.finalizeCompacted(job, filepath.Join(dir, compID.String()), shards[i]); err != nil {
+			return err
+		}
+	}
+
+	// From now on a restarted compaction uploads the output blocks instead of compacting the blocks again.
+	job.journal.Stage, job.journal.Outputs = JournalStageUpload, compIDs
+	if err := writeJournal(cg.logger, dir, job.journal); err != nil {
+		return errors.Wrap(err, "write compaction journal")
+	}
+	job.compIDs = compIDs
+	return nil
+}
+
+// compactSplit splits each planned block into the given shards and compacts blocks of each shard separately.
+// It returns IDs of the non-empty compacted blocks together with their shards.
+func (cg *Group) compactSplit(dir string, plan []string, comp tsdb.Compactor, shards []*metadata.ThanosShard) ([]ulid.ULID, []*metadata.ThanosShard, error) {
+	splitDirs := make([]string, 0, len(shards))
+	for _, shard := range shards {
+		splitDirs = append(splitDirs, filepath.Join(dir, "split-"+shard.String()))
+	}
+	defer func() {
+		for _, sdir := range splitDirs {
+			if err := os.RemoveAll(sdir); err != nil {
+				level.Warn(cg.logger).Log("msg", "failed to remove split blocks", "path", sdir, "err", err)
+			}
+		}
+	}()
 
-	bdir := filepath.Join(dir, compID.String())
+	shardPlans := make([][]string, len(shards))
+	for _, pdir := range plan {
+		dirs := make([]string, 0, len(shards))
+		for i, sdir := range splitDirs {
+			dirs = append(dirs, filepath.Join(sdir, filepath.Base(pdir)))
+			shardPlans[i] = append(shardPlans[i], dirs[i])
+		}
+		if err := splitBlock(cg.logger, pdir, shards, dirs); err != nil {
+			return nil, nil, errors.Wrapf(err, "split block %s", pdir)
+		}
+	}
+
+	var (
+		compIDs     []ulid.ULID
+		shardsOfIDs []*metadata.ThanosShard
+	)
+	for i, shard := range shards {
+		compID, err := comp.Compact(dir, shardPlans[i], nil)
+		if err != nil {
+			return nil, nil, halt(errors.Wrapf(err, "compact shard %s of blocks %v", shard, plan))
+		}
+		if compID == (ulid.ULID{}) {
+			continue
+		}
+		compIDs, shardsOfIDs = append(compIDs, compID), append(shardsOfIDs, shard)
+	}
+	return compIDs, shardsOfIDs, nil
+}
+
+// finalizeCompacted sets the Thanos meta of the compacted block in bdir and verifies it before upload.
+func (cg *Group) finalizeCompacted(job *compactionJob, bdir string, shard *metadata.ThanosShard) error {
 	index := filepath.Join(bdir, block.IndexFilename)
 	indexCache := filepath.Join(bdir, block.IndexCacheFilename)
 
@@ -730,50 +1072,172 @@ func (cg *Group) compact(ctx context.Context, dir string, comp tsdb.Compactor) (
 		Labels:     cg.labels.Map(),
 		Downsample: metadata.ThanosDownsample{Resolution: cg.resolution},
 		Source:     metadata.CompactorSource,
+		Shard:      shard,
 	}, nil)
 	if err != nil {
-		return false, ulid.ULID{}, errors.Wrapf(err, "failed to finalize the block %s", bdir)
+		return errors.Wrapf(err, "failed to finalize the block %s", bdir)
+	}
+	if ids := applicableDeletionRequestIDs(newMeta, cg.deletionRequests, job.now); len(ids) > 0 {
+		newMeta.Thanos.DeletionRequests = ids
+		if err := metadata.Write(cg.logger, bdir, newMeta); err != nil {
+			return errors.Wrapf(err, "write deletion requests to meta of block %s", bdir)
+		}
+	}
+	repairs, err := planRepairs(job.plan)
+	if err != nil {
+		return err
+	}
+	if len(repairs) > 0 {
+		newMeta.Thanos.Repairs = repairs
+		if err := metadata.Write(cg.logger, bdir, newMeta); err != nil {
+			return errors.Wrapf(err, "write repairs to meta of block %s", bdir)
+		}
 	}
 
 	if err = os.Remove(filepath.Join(bdir, "tombstones")); err != nil {
-		return false, ulid.ULID{}, errors.Wrap(err, "remove tombstones")
+		return errors.Wrap(err, "remove tombstones")
 	}
 
 	// Ensure the output block is valid.
 	if err := block.VerifyIndex(cg.logger, index, newMeta.MinTime, newMeta.MaxTime); !cg.acceptMalformedIndex && err != nil {
-		return false, ulid.ULID{}, halt(errors.Wrapf(err, "invalid result block %s", bdir))
+		return halt(errors.Wrapf(err, "invalid result block %s", bdir))
 	}
 
 	// Ensure the output block is not overlapping with anything else,
 	// unless vertical compaction is enabled.
 	if !cg.enableVerticalCompaction {
-		if err := cg.areBlocksOverlapping(newMeta, plan...); err != nil {
-			return false, ulid.ULID{}, halt(errors.Wrapf(err, "resulted compacted block %s overlaps with something", bdir))
+		if err := cg.areBlocksOverlapping(newMeta, job.plan...); err != nil {
+			return halt(errors.Wrapf(err, "resulted compacted block %s overlaps with something", bdir))
 		}
 	}
 
 	if err := indexheader.WriteJSON(cg.logger, index, indexCache); err != nil {
-		return false, ulid.ULID{}, errors.Wrap(err, "write index cache")
+		return errors.Wrap(err, "write index cache")
 	}
+	return nil
+}
 
-	begin = time.Now()
+// trimNoCompact returns the first run of at least two planned blocks, whose time range does not overlap any block
+// marked for no compaction. If there is none, the planned block dirs are removed, so they are not planned again,
+// and nil is returned.
+func (cg *Group) trimNoCompact(plan []string) ([]string, error) {
+	if len(cg.noCompactBlocks) == 0 {
+		return plan, nil
+	}
+	var (
+		run  []string
+		mint int64
+	)
+	for _, pdir := range plan {
+		meta, err := metadata.Read(pdir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read meta from %s", pdir)
+		}
+		if len(run) > 0 && cg.overlapsNoCompact(mint, meta.MaxTime) {
+			if len(run) > 1 {
+				return run, nil
+			}
+			run = nil
+		}
+		if len(run) == 0 {
+			mint = meta.MinTime
+		}
+		run = append(run, pdir)
+	}
+	if len(run) > 1 {
+		return run, nil
+	}
+	for _, pdir := range plan {
+		if err := os.RemoveAll(pdir); err != nil {
+			return nil, errors.Wrap(err, "remove planning block dir")
+		}
+	}
+	return nil, nil
+}
 
-	if err := block.Upload(ctx, cg.logger, cg.bkt, bdir); err != nil {
-		return false, ulid.ULID{}, retry(errors.Wrapf(err, "upload of %s failed", compID))
+func (cg *Group) overlapsNoCompact(mint, maxt int64) bool {
+	for _, m := range cg.noCompactBlocks {
+		if m.MinTime < maxt && mint < m.MaxTime {
+			return true
+		}
+	}
+	return false
+}
+
+// resumableUpload returns the planned block dirs if the journal records complete output blocks, which compact
+// blocks still present in the group.
+func (cg *Group) resumableUpload(dir string, j *Journal) ([]string, bool) {
+	plan := make([]string, 0, len(j.Inputs))
+	for _, id := range j.Inputs {
+		if _, ok := cg.blocks[id]; !ok {
+			// The output block was uploaded already and its inputs were garbage collected.
+			return nil, false
+		}
+		plan = append(plan, filepath.Join(dir, id.String()))
+	}
+	if len(j.Outputs) == 0 {
+		return nil, false
+	}
+	for _, id := range j.Outputs {
+		bdir := filepath.Join(dir, id.String())
+		meta, err := metadata.Read(bdir)
+		if err != nil || meta.ULID.Compare(id) != 0 {
+			return nil, false
+		}
+		if _, err := os.Stat(filepath.Join(bdir, block.IndexCacheFilename)); err != nil {
+			return nil, false
+		}
+	}
+	return plan, true
+}
+
+// cleanWorkDir removes everything from the group work directory except the journal and the blocks it records as
+// downloaded and verified, which are still in the group. This removes partially downloaded blocks and partially
+// written output blocks of an interrupted compaction.
+func (cg *Group) cleanWorkDir(dir string, j *Journal) error {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		if fi.Name() == JournalFilename {
+			continue
+		}
+		if id, ok := block.IsBlockDir(fi.Name()); ok && j != nil && j.isVerified(id) {
+			if _, ok := cg.blocks[id]; ok {
+				continue
+			}
+		}
+		if err := os.RemoveAll(filepath.Join(dir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadCompacted uploads the compacted blocks from dir and deletes the planned blocks they were compacted from.
+// The planned blocks are deleted only after all compacted blocks were uploaded, so no series go missing.
+func (cg *Group) uploadCompacted(ctx context.Context, dir string, compIDs []ulid.ULID, plan []string) error {
+	for _, compID := range compIDs {
+		begin := time.Now()
+
+		if err := block.Upload(ctx, cg.logger, cg.bkt, filepath.Join(dir, compID.String())); err != nil {
+			return retry(errors.Wrapf(err, "upload of %s failed", compID))
+		}
+		level.Info(cg.logger).Log("msg", "uploaded block", "result_block", compID, "duration", time.Since(begin))
 	}
-	level.Info(cg.logger).Log("msg", "uploaded block", "result_block", compID, "duration", time.Since(begin))
 
 	// Delete the blocks we just compacted from the group and bucket so they do not get included
 	// into the next planning cycle.
 	// Eventually the block we just uploaded should get synced into the group again (including sync-delay).
 	for _, b := range plan {
 		if err := cg.deleteBlock(b); err != nil {
-			return false, ulid.ULID{}, retry(errors.Wrapf(err, "delete old block from bucket"))
+			return retry(errors.Wrapf(err, "delete old block from bucket"))
 		}
 		cg.groupGarbageCollectedBlocks.Inc()
 	}
 
-	return true, compID, nil
+	return nil
 }
 
 func (cg *Group) deleteBlock(b string) error {
@@ -804,9 +1268,13 @@ type BucketCompactor struct {
 	compactDir  string
 	bkt         objstore.Bucket
 	concurrency int
+	diskBudget  *diskBudget
+	split       SplitConfig
 }
 
-// NewBucketCompactor creates a new bucket compactor.
+// NewBucketCompactor creates a new bucket compactor. Compactions reserve twice the size of their input blocks
+// from the diskBudget bytes of local disk before downloading them. Zero diskBudget means no limit. Compacted blocks
+// exceeding limits of the split config are split into blocks of shards of series.
 func NewBucketCompactor(
 	logger log.Logger,
 	sy *Syncer,
@@ -814,6 +1282,8 @@ func NewBucketCompactor(
 	compactDir string,
 	bkt objstore.Bucket,
 	concurrency int,
+	diskBudget uint64,
+	split SplitConfig,
 ) (*BucketCompactor, error) {
 	if concurrency <= 0 {
 		return nil, errors.Errorf("invalid concurrency level (%d), concurrency level must be > 0", concurrency)
@@ -825,12 +1295,18 @@ func NewBucketCompactor(
 		compactDir:  compactDir,
 		bkt:         bkt,
 		concurrency: concurrency,
+		diskBudget:  newDiskBudget(logger, diskBudget),
+		split:       split,
 	}, nil
 }
 
 // Compact runs compaction over bucket.
-func (c *BucketCompactor) Compact(ctx context.Context) error {
+func (c *BucketCompactor) Compact(ctx context.Context) (rerr error) {
 	defer func() {
+		// The work directory is kept on error, so interrupted group compactions can be resumed.
+		if rerr != nil {
+			return
+		}
 		if err := os.RemoveAll(c.compactDir); err != nil {
 			level.Error(c.logger).Log("msg", "failed to remove compaction work directory", "path", c.compactDir, "err", err)
 		}
@@ -838,52 +1314,6 @@ func (c *BucketCompactor) Compact(ctx context.Context) error {
 
 	// Loop over bucket and compact until there's no work left.
 	for {
-		var (
-			wg                     sync.WaitGroup
-			workCtx, workCtxCancel = context.WithCancel(ctx)
-			groupChan              = make(chan *Group)
-			errChan                = make(chan error, c.concurrency)
-			finishedAllGroups      = true
-			mtx                    sync.Mutex
-		)
-		defer workCtxCancel()
-
-		// Set up workers who will compact the groups when the groups are ready.
-		// They will compact available groups until they encounter an error, after which they will stop.
-		for i := 0; i < c.concurrency; i++ {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				for g := range groupChan {
-					shouldRerunGroup, _, err := g.Compact(workCtx, c.compactDir, c.comp)
-					if err == nil {
-						if shouldRerunGroup {
-							mtx.Lock()
-							finishedAllGroups = false
-							mtx.Unlock()
-						}
-						continue
-					}
-
-					if IsIssue347Error(err) {
-						if err := RepairIssue347(workCtx, c.logger, c.bkt, c.sy.metrics.blocksMarkedForDeletion, err); err == nil {
-							mtx.Lock()
-							finishedAllGroups = false
-							mtx.Unlock()
-							continue
-						}
-					}
-					errChan <- errors.Wrapf(err, "group %s", g.Key())
-					return
-				}
-			}()
-		}
-
-		// Clean up the compaction temporary directory at the beginning of every compaction loop.
-		if err := os.RemoveAll(c.compactDir); err != nil {
-			return errors.Wrap(err, "clean up the compaction temporary directory")
-		}
-
 		level.Info(c.logger).Log("msg", "start sync of metas")
 		if err := c.sy.SyncMetas(ctx); err != nil {
 			return errors.Wrap(err, "sync")
@@ -901,34 +1331,18 @@ func (c *BucketCompactor) Compact(ctx context.Context) error {
 			return errors.Wrap(err, "build compaction groups")
 		}
 
-		level.Info(c.logger).Log("msg", "start of compactions")
-
-		// Send all groups found during this pass to the compaction workers.
-		var groupErrs terrors.MultiError
-	groupLoop:
-		for _, g := range groups {
-			select {
-			case groupErr := <-errChan:
-				groupErrs.Add(groupErr)
-				break groupLoop
-			case groupChan <- g:
-			}
+		// Clean up work directories of groups which are gone. Work directories of existing groups
+		// are left for their compactions to be resumed.
+		if err := c.cleanCompactDir(groups); err != nil {
+			return errors.Wrap(err, "clean up the compaction temporary directory")
 		}
-		close(groupChan)
-		wg.Wait()
 
-		// Collect any other error reported by the workers, or any error reported
-		// while we were waiting for the last batch of groups to run the compaction.
-		close(errChan)
-		for groupErr := range errChan {
-			groupErrs.Add(groupErr)
-		}
+		level.Info(c.logger).Log("msg", "start of compactions")
 
-		workCtxCancel()
-		if len(groupErrs) > 0 {
-			return groupErrs
+		finishedAllGroups, err := c.compactGroups(ctx, groups)
+		if err != nil {
+			return err
 		}
-
 		if finishedAllGroups {
 			break
 		}
@@ -936,3 +1350,132 @@ func (c *BucketCompactor) Compact(ctx context.Context) error {
 	level.Info(c.logger).Log("msg", "compaction iterations done")
 	return nil
 }
+
+// compactGroups runs a single compaction of each group. Each stage of compactions is run by its own workers: while blocks
+// of one group are compacted, input blocks of the next groups are downloaded and results of the previous ones uploaded.
+// It returns true if none of the groups needs to be compacted again.
+func (c *BucketCompactor) compactGroups(ctx context.Context, groups []*Group) (bool, error) {
+	var (
+		downloaders, compactors, uploaders sync.WaitGroup
+		workCtx, workCtxCancel             = context.WithCancel(ctx)
+		groupChan                          = make(chan *Group)
+		downloadedChan                     = make(chan *compactionJob)
+		compactedChan                      = make(chan *compactionJob)
+		failedChan                         = make(chan struct{})
+		failOnce                           sync.Once
+
+		mtx               sync.Mutex
+		finishedAllGroups = true
+		groupErrs         terrors.MultiError
+	)
+	defer workCtxCancel()
+
+	rerun := func() {
+		mtx.Lock()
+		finishedAllGroups = false
+		mtx.Unlock()
+	}
+	// Jobs already in progress are finished after a failure, but no other groups are started.
+	fail := func(g *Group, err error) {
+		mtx.Lock()
+		groupErrs.Add(errors.Wrapf(err, "group %s", g.Key()))
+		mtx.Unlock()
+		failOnce.Do(func() { close(failedChan) })
+	}
+
+	for i := 0; i < c.concurrency; i++ {
+		downloaders.Add(1)
+		go func() {
+			defer downloaders.Done()
+			for g := range groupChan {
+				job, err := g.prepare(workCtx, c.compactDir, c.comp, c.diskBudget)
+				if err == nil {
+					if job != nil {
+						downloadedChan <- job
+					}
+					continue
+				}
+
+				if IsIssue347Error(err) {
+					if err := RepairIssue347(workCtx, c.logger, c.bkt, c.sy.metrics.blocksMarkedForDeletion, err); err == nil {
+						rerun()
+						continue
+					}
+				}
+				fail(g, err)
+			}
+		}()
+
+		compactors.Add(1)
+		go func() {
+			defer compactors.Done()
+			for job := range downloadedChan {
+				if err := job.compact(c.comp, c.split); err != nil {
+					fail(job.group, err)
+					continue
+				}
+				compactedChan <- job
+			}
+		}()
+
+		uploaders.Add(1)
+		go func() {
+			defer uploaders.Done()
+			for job := range compactedChan {
+				shouldRerunGroup, _, err := job.upload(workCtx)
+				if err != nil {
+					fail(job.group, err)
+					continue
+				}
+				if shouldRerunGroup {
+					rerun()
+				}
+			}
+		}()
+	}
+
+	// Send all groups found during this pass to the compaction workers.
+groupLoop:
+	for _, g := range groups {
+		select {
+		case <-failedChan:
+			break groupLoop
+		case groupChan <- g:
+		}
+	}
+	close(groupChan)
+	downloaders.Wait()
+	close(downloadedChan)
+	compactors.Wait()
+	close(compactedChan)
+	uploaders.Wait()
+
+	if len(groupErrs) > 0 {
+		return false, groupErrs
+	}
+	return finishedAllGroups, nil
+}
+
+// cleanCompactDir removes work directories of groups other than the given ones.
+func (c *BucketCompactor) cleanCompactDir(groups []*Group) error {
+	fis, err := ioutil.ReadDir(c.compactDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	keys := make(map[string]struct{}, len(groups))
+	for _, g := range groups {
+		keys[g.Key()] = struct{}{}
+	}
+	for _, fi := range fis {
+		if _, ok := keys[fi.Name()]; ok {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(c.compactDir, fi.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
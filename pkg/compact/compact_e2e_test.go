@@ -97,7 +97,7 @@ func TestSyncer_GarbageCollect_e2e(t *testing.T) {
 
 		blocksMarkedForDeletion := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
 		ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(nil, nil, 48*time.Hour)
-		sy, err := NewSyncer(nil, nil, bkt, metaFetcher, duplicateBlocksFilter, ignoreDeletionMarkFilter, blocksMarkedForDeletion, 1, false, false)
+		sy, err := NewSyncer(nil, nil, bkt, metaFetcher, duplicateBlocksFilter, ignoreDeletionMarkFilter, nil, blocksMarkedForDeletion, 1, false, false)
 		testutil.Ok(t, err)
 
 		// Do one initial synchronization with the bucket.
@@ -106,7 +106,10 @@ func TestSyncer_GarbageCollect_e2e(t *testing.T) {
 
 		var rem []ulid.ULID
 		err = bkt.Iter(ctx, "", func(n string) error {
-			id := ulid.MustParse(n[:len(n)-1])
+			id, ok := block.IsBlockDir(n)
+			if !ok {
+				return nil
+			}
 			deletionMarkFile := path.Join(id.String(), metadata.DeletionMarkFilename)
 
 			exists, err := bkt.Exists(ctx, deletionMarkFile)
@@ -186,13 +189,13 @@ func TestGroup_Compact_e2e(t *testing.T) {
 		testutil.Ok(t, err)
 
 		blocksMarkedForDeletion := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
-		sy, err := NewSyncer(nil, nil, bkt, metaFetcher, duplicateBlocksFilter, ignoreDeletionMarkFilter, blocksMarkedForDeletion, 5, false, false)
+		sy, err := NewSyncer(nil, nil, bkt, metaFetcher, duplicateBlocksFilter, ignoreDeletionMarkFilter, nil, blocksMarkedForDeletion, 5, false, false)
 		testutil.Ok(t, err)
 
 		comp, err := tsdb.NewLeveledCompactor(ctx, reg, logger, []int64{1000, 3000}, nil)
 		testutil.Ok(t, err)
 
-		bComp, err := NewBucketCompactor(logger, sy, comp, dir, bkt, 2)
+		bComp, err := NewBucketCompactor(logger, sy, comp, dir, bkt, 2, 0, SplitConfig{})
 		testutil.Ok(t, err)
 
 		// Compaction on empty should not fail.
@@ -351,7 +354,7 @@ func TestGroup_Compact_e2e(t *testing.T) {
 		// We expect two compacted blocks only outside of what we expected in `nonCompactedExpected`.
 		testutil.Equals(t, 2, len(others))
 		{
-			meta, ok := others[groupKey(124, extLabels)]
+			meta, ok := others[groupKey(124, extLabels, nil)]
 			testutil.Assert(t, ok, "meta not found")
 
 			testutil.Equals(t, int64(0), meta.MinTime)
@@ -366,7 +369,7 @@ func TestGroup_Compact_e2e(t *testing.T) {
 			testutil.Equals(t, int64(124), meta.Thanos.Downsample.Resolution)
 		}
 		{
-			meta, ok := others[groupKey(124, extLabels2)]
+			meta, ok := others[groupKey(124, extLabels2, nil)]
 			testutil.Assert(t, ok, "meta not found")
 
 			testutil.Equals(t, int64(0), meta.MinTime)
@@ -467,7 +470,7 @@ func TestGarbageCollectDoesntCreateEmptyBlocksWithDeletionMarksOnly(t *testing.T
 		}, nil)
 		testutil.Ok(t, err)
 
-		sy, err := NewSyncer(nil, nil, bkt, metaFetcher, duplicateBlocksFilter, ignoreDeletionMarkFilter, blocksMarkedForDeletion, 1, false, false)
+		sy, err := NewSyncer(nil, nil, bkt, metaFetcher, duplicateBlocksFilter, ignoreDeletionMarkFilter, nil, blocksMarkedForDeletion, 1, false, false)
 		testutil.Ok(t, err)
 
 		// Do one initial synchronization with the bucket.
@@ -502,7 +505,10 @@ func TestGarbageCollectDoesntCreateEmptyBlocksWithDeletionMarksOnly(t *testing.T
 func listBlocksMarkedForDeletion(ctx context.Context, bkt objstore.Bucket) ([]ulid.ULID, error) {
 	var rem []ulid.ULID
 	err := bkt.Iter(ctx, "", func(n string) error {
-		id := ulid.MustParse(n[:len(n)-1])
+		id, ok := block.IsBlockDir(n)
+		if !ok {
+			return nil
+		}
 		deletionMarkFile := path.Join(id.String(), metadata.DeletionMarkFilename)
 
 		exists, err := bkt.Exists(ctx, deletionMarkFile)
@@ -516,3 +522,61 @@ func listBlocksMarkedForDeletion(ctx context.Context, bkt objstore.Bucket) ([]ul
 	})
 	return rem, err
 }
+
+func TestGroup_Compact_NoCompactMark(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	extLset := labels.Labels{{Name: "e1", Value: "1"}}
+	series := []labels.Labels{labels.FromStrings("a", "1")}
+
+	bkt := objstore.NewInMemBucket()
+	var specs []blockgenSpec
+	for i := int64(0); i < 7; i++ {
+		specs = append(specs, blockgenSpec{numSamples: 100, mint: i * 1000, maxt: (i + 1) * 1000, extLset: extLset, series: series})
+	}
+	metas := createAndUpload(t, bkt, specs)
+	testutil.Ok(t, block.MarkForNoCompact(ctx, logger, bkt, metas[1].ULID, metadata.ManualNoCompactReason, "", prometheus.NewCounter(prometheus.CounterOpts{})))
+
+	ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(logger, objstore.WithNoopInstr(bkt), 48*time.Hour)
+	duplicateBlocksFilter := block.NewDeduplicateFilter()
+	noCompactMarkFilter := block.NewNoCompactMarkFilter(logger, objstore.WithNoopInstr(bkt))
+	metaFetcher, err := block.NewMetaFetcher(nil, 32, objstore.WithNoopInstr(bkt), "", nil, []block.MetadataFilter{
+		ignoreDeletionMarkFilter,
+		duplicateBlocksFilter,
+		noCompactMarkFilter,
+	}, nil)
+	testutil.Ok(t, err)
+
+	sy, err := NewSyncer(nil, nil, bkt, metaFetcher, duplicateBlocksFilter, ignoreDeletionMarkFilter, noCompactMarkFilter, prometheus.NewCounter(prometheus.CounterOpts{}), 1, false, false)
+	testutil.Ok(t, err)
+	testutil.Ok(t, sy.SyncMetas(ctx))
+	// Marked block is still synced, e.g. to be downsampled, but it is not compacted.
+	testutil.Equals(t, 7, len(sy.Metas()))
+	groups, err := sy.Groups()
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(groups))
+	testutil.Equals(t, []ulid.ULID{metas[0].ULID, metas[2].ULID, metas[3].ULID, metas[4].ULID, metas[5].ULID, metas[6].ULID}, groups[0].IDs())
+
+	comp, err := tsdb.NewLeveledCompactor(ctx, nil, logger, []int64{1000, 3000}, nil)
+	testutil.Ok(t, err)
+
+	dir, err := ioutil.TempDir("", "test-compact-no-compact-mark")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	// The first range is not compacted, as it would span over the marked block.
+	ok, compIDs, err := groups[0].Compact(ctx, dir, comp, SplitConfig{})
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok, "expected rerun after compaction")
+	testutil.Equals(t, 1, len(compIDs))
+
+	meta, err := block.DownloadMeta(ctx, logger, bkt, compIDs[0])
+	testutil.Ok(t, err)
+	testutil.Equals(t, []ulid.ULID{metas[3].ULID, metas[4].ULID, metas[5].ULID}, meta.Compaction.Sources)
+
+	for _, m := range metas[:3] {
+		marked, err := bkt.Exists(ctx, path.Join(m.ULID.String(), metadata.DeletionMarkFilename))
+		testutil.Ok(t, err)
+		testutil.Assert(t, !marked, "expected block %s not to be compacted", m.ULID)
+	}
+}
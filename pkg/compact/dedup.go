@@ -0,0 +1,403 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	tsdberrors "github.com/prometheus/prometheus/tsdb/errors"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+	"github.com/thanos-io/thanos/pkg/dedup"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// DedupFuncPenalty is the name of the deduplication function using the penalty based algorithm of the Querier.
+const DedupFuncPenalty = "penalty"
+
+// maxSamplesPerChunk is the number of samples after which deduplicated chunks are cut, as done by Prometheus.
+const maxSamplesPerChunk = 120
+
+// DedupCompactor is a tsdb.Compactor which compacts overlapping raw blocks by deduplicating samples of series present
+// in multiple blocks with the same penalty based algorithm as the Querier, instead of chaining all samples together.
+// This way deduplicated blocks give the same results as querier-side deduplication of their replicas.
+// Blocks of downsampled data and blocks which don't overlap are compacted by the wrapped compactor.
+type DedupCompactor struct {
+	tsdb.Compactor
+
+	logger log.Logger
+	pool   chunkenc.Pool
+}
+
+// NewDedupCompactor returns a new DedupCompactor wrapping the given compactor.
+func NewDedupCompactor(logger log.Logger, comp tsdb.Compactor, pool chunkenc.Pool) *DedupCompactor {
+	return &DedupCompactor{Compactor: comp, logger: logger, pool: pool}
+}
+
+// Compact compacts the blocks in the given directories into a new block in dest and returns its ID.
+// Already open blocks are not supported for deduplication and are passed to the wrapped compactor.
+func (c *DedupCompactor) Compact(dest string, dirs []string, open []*tsdb.Block) (ulid.ULID, error) {
+	if len(open) > 0 {
+		return c.Compactor.Compact(dest, dirs, open)
+	}
+
+	metas := make([]*metadata.Meta, 0, len(dirs))
+	for _, dir := range dirs {
+		m, err := metadata.Read(dir)
+		if err != nil {
+			return ulid.ULID{}, errors.Wrapf(err, "read meta of %s", dir)
+		}
+		if m.Thanos.Downsample.Resolution != downsample.ResLevel0 {
+			return c.Compactor.Compact(dest, dirs, open)
+		}
+		metas = append(metas, m)
+	}
+	if !metasOverlapping(metas) {
+		return c.Compactor.Compact(dest, dirs, open)
+	}
+	return c.compactDeduplicated(dest, dirs, metas)
+}
+
+// metasOverlapping returns true if any of the blocks overlap in time.
+func metasOverlapping(metas []*metadata.Meta) bool {
+	sorted := make([]*metadata.Meta, len(metas))
+	copy(sorted, metas)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].MinTime < sorted[j].MinTime })
+
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].MinTime < sorted[i-1].MaxTime {
+			return true
+		}
+		if sorted[i].MaxTime < sorted[i-1].MaxTime {
+			sorted[i] = sorted[i-1]
+		}
+	}
+	return false
+}
+
+func (c *DedupCompactor) compactDeduplicated(dest string, dirs []string, metas []*metadata.Meta) (id ulid.ULID, err error) {
+	begin := time.Now()
+	uid := ulid.MustNew(ulid.Now(), rand.New(rand.NewSource(time.Now().UnixNano())))
+
+	var closers []io.Closer
+	defer func() {
+		var merr tsdberrors.MultiError
+		merr.Add(err)
+		// Readers must be closed before their blocks.
+		for i := len(closers) - 1; i >= 0; i-- {
+			merr.Add(closers[i].Close())
+		}
+		err = merr.Err()
+	}()
+
+	var (
+		series  = make([]*blockSeries, 0, len(dirs))
+		symbols = map[string]struct{}{}
+	)
+	for _, dir := range dirs {
+		b, err := tsdb.OpenBlock(c.logger, dir, c.pool)
+		if err != nil {
+			return id, errors.Wrapf(err, "open block %s", dir)
+		}
+		closers = append(closers, b)
+
+		indexr, err := b.Index()
+		if err != nil {
+			return id, errors.Wrapf(err, "open index reader of block %s", dir)
+		}
+		closers = append(closers, indexr)
+
+		chunkr, err := b.Chunks()
+		if err != nil {
+			return id, errors.Wrapf(err, "open chunk reader of block %s", dir)
+		}
+		closers = append(closers, chunkr)
+
+		syms := indexr.Symbols()
+		for syms.Next() {
+			symbols[syms.At()] = struct{}{}
+		}
+		if err := syms.Err(); err != nil {
+			return id, errors.Wrapf(err, "read symbols of block %s", dir)
+		}
+
+		postings, err := indexr.Postings(index.AllPostingsKey())
+		if err != nil {
+			return id, errors.Wrapf(err, "get all postings of block %s", dir)
+		}
+		s := &blockSeries{indexr: indexr, chunkr: chunkr, postings: indexr.SortedPostings(postings)}
+		if err := s.next(); err != nil {
+			return id, errors.Wrapf(err, "read series of block %s", dir)
+		}
+		series = append(series, s)
+	}
+
+	resdir := filepath.Join(dest, uid.String())
+	if err := os.MkdirAll(resdir, 0777); err != nil {
+		return id, errors.Wrap(err, "create block dir")
+	}
+	defer func() {
+		if err != nil {
+			if rerr := os.RemoveAll(resdir); rerr != nil {
+				level.Warn(c.logger).Log("msg", "failed to remove block dir", "dir", resdir, "err", rerr)
+			}
+		}
+	}()
+
+	indexr := symbolsIndexReader{IndexReader: series[0].indexr, symbols: make([]string, 0, len(symbols))}
+	for s := range symbols {
+		indexr.symbols = append(indexr.symbols, s)
+	}
+	sort.Strings(indexr.symbols)
+
+	w, err := downsample.NewStreamedBlockWriter(resdir, indexr, c.logger, compactedMeta(uid, metas))
+	if err != nil {
+		return id, errors.Wrap(err, "create block writer")
+	}
+	defer runutil.CloseWithErrCapture(&err, w, "close block writer")
+
+	var numSeries, numDeduplicated int
+	for {
+		// Series are sorted by labels in each block, so we merge them by always taking the lowest label set.
+		var (
+			lset     labels.Labels
+			replicas []*blockSeries
+		)
+		for _, s := range series {
+			if !s.ok {
+				continue
+			}
+			if len(replicas) == 0 || labels.Compare(s.lset, lset) < 0 {
+				lset, replicas = s.lset, append(replicas[:0], s)
+			} else if labels.Compare(s.lset, lset) == 0 {
+				replicas = append(replicas, s)
+			}
+		}
+		if len(replicas) == 0 {
+			break
+		}
+
+		chks := replicas[0].chks
+		if len(replicas) > 1 {
+			if chks, err = dedupChunks(replicas); err != nil {
+				return id, errors.Wrapf(err, "deduplicate series %s", lset)
+			}
+			numDeduplicated++
+		}
+		if len(chks) > 0 {
+			if err := w.WriteSeries(lset, chks); err != nil {
+				return id, errors.Wrapf(err, "write series %s", lset)
+			}
+			numSeries++
+		}
+
+		for _, s := range replicas {
+			if err := s.next(); err != nil {
+				return id, errors.Wrap(err, "read series")
+			}
+		}
+	}
+	if err := w.Close(); err != nil {
+		return id, errors.Wrap(err, "close block writer")
+	}
+	if numSeries == 0 {
+		// Same as the tsdb compactor, we signal that the compacted block would have no samples.
+		if err := os.RemoveAll(resdir); err != nil {
+			return id, errors.Wrap(err, "remove empty block dir")
+		}
+		return ulid.ULID{}, nil
+	}
+	// Blocks written by the tsdb compactor always have a tombstones file.
+	if _, err := tombstones.WriteFile(c.logger, resdir, tombstones.NewMemTombstones()); err != nil {
+		return id, errors.Wrap(err, "write tombstones")
+	}
+
+	level.Info(c.logger).Log("msg", "compacted overlapping blocks with penalty deduplication", "new", uid, "blocks", len(dirs),
+		"series", numSeries, "deduplicatedSeries", numDeduplicated, "duration", time.Since(begin))
+	return uid, nil
+}
+
+// compactedMeta returns the meta of the block compacted from the given blocks, as the tsdb compactor does.
+func compactedMeta(uid ulid.ULID, metas []*metadata.Meta) metadata.Meta {
+	res := metadata.Meta{
+		BlockMeta: tsdb.BlockMeta{ULID: uid, MinTime: metas[0].MinTime, MaxTime: metas[0].MaxTime},
+		Thanos: metadata.Thanos{
+			Labels:     metas[0].Thanos.Labels,
+			Downsample: metas[0].Thanos.Downsample,
+			Source:     metadata.CompactorSource,
+		},
+	}
+
+	sources := map[ulid.ULID]struct{}{}
+	for _, m := range metas {
+		if m.MinTime < res.MinTime {
+			res.MinTime = m.MinTime
+		}
+		if m.MaxTime > res.MaxTime {
+			res.MaxTime = m.MaxTime
+		}
+		if m.Compaction.Level > res.Compaction.Level {
+			res.Compaction.Level = m.Compaction.Level
+		}
+		for _, s := range m.Compaction.Sources {
+			sources[s] = struct{}{}
+		}
+		res.Compaction.Parents = append(res.Compaction.Parents, tsdb.BlockDesc{
+			ULID:    m.ULID,
+			MinTime: m.MinTime,
+			MaxTime: m.MaxTime,
+		})
+	}
+	res.Compaction.Level++
+
+	for s := range sources {
+		res.Compaction.Sources = append(res.Compaction.Sources, s)
+	}
+	sort.Slice(res.Compaction.Sources, func(i, j int) bool {
+		return res.Compaction.Sources[i].Compare(res.Compaction.Sources[j]) < 0
+	})
+	return res
+}
+
+// dedupChunks deduplicates samples of all replicas of a series and encodes them into new chunks. Replicas are
+// deduplicated pairwise in order, the same way the Querier does.
+func dedupChunks(replicas []*blockSeries) ([]chunks.Meta, error) {
+	var it storage.SeriesIterator = newChunksIterator(replicas[0].chks)
+	for _, r := range replicas[1:] {
+		it = dedup.NewSeriesIterator(it, newChunksIterator(r.chks))
+	}
+
+	var (
+		res []chunks.Meta
+		chk *chunkenc.XORChunk
+		app chunkenc.Appender
+		err error
+	)
+	for it.Next() {
+		t, v := it.At()
+		if chk == nil || chk.NumSamples() >= maxSamplesPerChunk {
+			chk = chunkenc.NewXORChunk()
+			if app, err = chk.Appender(); err != nil {
+				return nil, err
+			}
+			res = append(res, chunks.Meta{Chunk: chk, MinTime: t})
+		}
+		app.Append(t, v)
+		res[len(res)-1].MaxTime = t
+	}
+	return res, it.Err()
+}
+
+// blockSeries iterates over the series of a block together with their chunks.
+type blockSeries struct {
+	indexr   tsdb.IndexReader
+	chunkr   tsdb.ChunkReader
+	postings index.Postings
+
+	ok   bool
+	lset labels.Labels
+	chks []chunks.Meta
+}
+
+func (s *blockSeries) next() error {
+	if s.ok = s.postings.Next(); !s.ok {
+		return s.postings.Err()
+	}
+	// Labels and chunks are passed to the writer before advancing, so the slices can be reused.
+	s.lset, s.chks = s.lset[:0], s.chks[:0]
+	if err := s.indexr.Series(s.postings.At(), &s.lset, &s.chks); err != nil {
+		return errors.Wrapf(err, "get series %d", s.postings.At())
+	}
+	for i, c := range s.chks {
+		chk, err := s.chunkr.Chunk(c.Ref)
+		if err != nil {
+			return errors.Wrapf(err, "get chunk %d, series %d", c.Ref, s.postings.At())
+		}
+		s.chks[i].Chunk = chk
+	}
+	return nil
+}
+
+// symbolsIndexReader is an index reader returning the given symbols instead of the ones of the wrapped reader.
+type symbolsIndexReader struct {
+	tsdb.IndexReader
+	symbols []string
+}
+
+func (r symbolsIndexReader) Symbols() index.StringIter {
+	return index.NewStringListIter(r.symbols)
+}
+
+// chunksIterator iterates over the samples of sorted, non-overlapping chunks of a series.
+type chunksIterator struct {
+	chks []chunks.Meta
+	i    int
+	cur  chunkenc.Iterator
+	ok   bool
+}
+
+func newChunksIterator(chks []chunks.Meta) *chunksIterator {
+	it := &chunksIterator{chks: chks}
+	if len(chks) > 0 {
+		it.cur = chks[0].Chunk.Iterator(nil)
+	}
+	return it
+}
+
+func (it *chunksIterator) Next() bool {
+	for it.i < len(it.chks) {
+		if it.ok = it.cur.Next(); it.ok {
+			return true
+		}
+		if it.cur.Err() != nil {
+			return false
+		}
+		it.i++
+		if it.i < len(it.chks) {
+			it.cur = it.chks[it.i].Chunk.Iterator(it.cur)
+		}
+	}
+	return false
+}
+
+func (it *chunksIterator) Seek(t int64) bool {
+	if it.ok {
+		if ts, _ := it.cur.At(); ts >= t {
+			return true
+		}
+	}
+	for it.Next() {
+		if ts, _ := it.cur.At(); ts >= t {
+			return true
+		}
+	}
+	return false
+}
+
+func (it *chunksIterator) At() (int64, float64) {
+	return it.cur.At()
+}
+
+func (it *chunksIterator) Err() error {
+	if it.cur == nil {
+		return nil
+	}
+	return it.cur.Err()
+}
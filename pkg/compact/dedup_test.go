@@ -0,0 +1,78 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestDedupCompactor_Compact(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+
+	dir, err := ioutil.TempDir("", "test-dedup-compactor")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	tsdbComp, err := tsdb.NewLeveledCompactor(ctx, nil, logger, []int64{1000, 3000}, nil)
+	testutil.Ok(t, err)
+	comp := NewDedupCompactor(logger, tsdbComp, downsample.NewPool())
+
+	extLset := labels.Labels{{Name: "e1", Value: "1"}}
+	shared, onlyB := labels.FromStrings("a", "1"), labels.FromStrings("a", "2")
+
+	// Replicas scraping the same series 300ms apart every second.
+	idA, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{shared}, 100, 0, 101000, extLset, 0)
+	testutil.Ok(t, err)
+	idB, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{shared, onlyB}, 100, 300, 101300, extLset, 0)
+	testutil.Ok(t, err)
+	dirA, dirB := filepath.Join(dir, idA.String()), filepath.Join(dir, idB.String())
+
+	samplesA := readBlockSamples(t, dirA, chunkenc.NewPool())
+	samplesB := readBlockSamples(t, dirB, chunkenc.NewPool())
+
+	id, err := comp.Compact(dir, []string{dirA, dirB}, nil)
+	testutil.Ok(t, err)
+	testutil.Assert(t, id != ulid.ULID{}, "expected compacted block")
+
+	meta, err := metadata.Read(filepath.Join(dir, id.String()))
+	testutil.Ok(t, err)
+	testutil.Equals(t, int64(0), meta.MinTime)
+	testutil.Equals(t, int64(101300), meta.MaxTime)
+	testutil.Equals(t, 2, meta.Compaction.Level)
+	testutil.Equals(t, 2, len(meta.Compaction.Sources))
+	testutil.Equals(t, 2, len(meta.Compaction.Parents))
+	testutil.Equals(t, uint64(2), meta.Stats.NumSeries)
+	testutil.Equals(t, extLset.Map(), meta.Thanos.Labels)
+
+	// The first replica is followed, as it has the earliest sample and never has a gap.
+	samples := readBlockSamples(t, filepath.Join(dir, id.String()), chunkenc.NewPool())
+	testutil.Equals(t, samplesA[shared.String()], samples[shared.String()])
+	testutil.Equals(t, samplesB[onlyB.String()], samples[onlyB.String()])
+	testutil.Equals(t, uint64(len(samplesA[shared.String()])+len(samplesB[onlyB.String()])), meta.Stats.NumSamples)
+
+	// Not overlapping blocks are compacted by the wrapped compactor.
+	idC, err := e2eutil.CreateBlock(ctx, dir, []labels.Labels{shared}, 100, 101300, 202300, extLset, 0)
+	testutil.Ok(t, err)
+	dirC := filepath.Join(dir, idC.String())
+
+	id, err = comp.Compact(dir, []string{dirB, dirC}, nil)
+	testutil.Ok(t, err)
+	samples = readBlockSamples(t, filepath.Join(dir, id.String()), chunkenc.NewPool())
+	testutil.Equals(t, append(samplesB[shared.String()], readBlockSamples(t, dirC, chunkenc.NewPool())[shared.String()]...), samples[shared.String()])
+}
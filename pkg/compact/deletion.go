@@ -0,0 +1,620 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+const (
+	// DeletionRequestsDir is the directory in the bucket holding deletion requests.
+	DeletionRequestsDir = "deletion-requests"
+
+	// DeletionRequestVersion1 is the version of deletion requests supported by Thanos.
+	DeletionRequestVersion1 = 1
+)
+
+// ErrDeletionRequestNotFound is returned when the deletion request to cancel does not exist.
+var ErrDeletionRequestNotFound = errors.New("deletion request not found")
+
+// DeletionRequest requests deletion of series matching the given matchers within the time range.
+// Deletion requests are applied by the compactor when rewriting blocks. Blocks record IDs of applied
+// deletion requests in their meta.json, so each block is rewritten at most once per request.
+type DeletionRequest struct {
+	ID ulid.ULID `json:"id"`
+	// Matchers is a series selector, e.g {__name__=~"debug_.*", job="app"}.
+	Matchers string `json:"matchers"`
+	// MinTime and MaxTime specify the closed time range of samples to delete in milliseconds.
+	MinTime int64 `json:"min_time"`
+	MaxTime int64 `json:"max_time"`
+	// Retention, if set, makes the request delete all samples of matching series from blocks which are older
+	// than the retention, instead of the fixed time range.
+	Retention model.Duration `json:"retention,omitempty"`
+	// CreationTime is the unix timestamp of the request creation in milliseconds.
+	CreationTime int64 `json:"creation_time"`
+	// Version of the file.
+	Version int `json:"version"`
+
+	matchers []*labels.Matcher
+}
+
+// NewDeletionRequest returns a validated deletion request of series matching the given selector.
+// If retention is non-zero, minTime and maxTime must not be set.
+func NewDeletionRequest(selector string, minTime, maxTime int64, retention time.Duration, now time.Time) (*DeletionRequest, error) {
+	r := &DeletionRequest{
+		ID:           ulid.MustNew(ulid.Timestamp(now), rand.New(rand.NewSource(now.UnixNano()))),
+		Matchers:     selector,
+		MinTime:      minTime,
+		MaxTime:      maxTime,
+		Retention:    model.Duration(retention),
+		CreationTime: timestamp.FromTime(now),
+		Version:      DeletionRequestVersion1,
+	}
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *DeletionRequest) validate() error {
+	if r.Version != DeletionRequestVersion1 {
+		return errors.Errorf("unexpected deletion request version %d", r.Version)
+	}
+	ms, err := promql.ParseMetricSelector(r.Matchers)
+	if err != nil {
+		return errors.Wrap(err, "parse matchers")
+	}
+	r.matchers = ms
+
+	if r.Retention < 0 {
+		return errors.New("retention must not be negative")
+	}
+	if r.Retention > 0 {
+		if r.MinTime != 0 || r.MaxTime != 0 {
+			return errors.New("time range cannot be set together with retention")
+		}
+		return nil
+	}
+	if r.MinTime > r.MaxTime {
+		return errors.Errorf("min time %d is after max time %d", r.MinTime, r.MaxTime)
+	}
+	return nil
+}
+
+// Interval returns the closed interval of samples deleted by the request from the block with the given meta.
+// False is returned if the request does not apply to the block.
+func (r *DeletionRequest) Interval(m *metadata.Meta, now time.Time) (tombstones.Interval, bool) {
+	if r.Retention > 0 {
+		if m.MaxTime > timestamp.FromTime(now.Add(-time.Duration(r.Retention))) {
+			return tombstones.Interval{}, false
+		}
+		return tombstones.Interval{Mint: m.MinTime, Maxt: m.MaxTime}, true
+	}
+	if r.MinTime >= m.MaxTime || r.MaxTime < m.MinTime {
+		return tombstones.Interval{}, false
+	}
+	itv := tombstones.Interval{Mint: r.MinTime, Maxt: r.MaxTime}
+	if itv.Mint < m.MinTime {
+		itv.Mint = m.MinTime
+	}
+	if itv.Maxt > m.MaxTime {
+		itv.Maxt = m.MaxTime
+	}
+	return itv, true
+}
+
+func (r *DeletionRequest) matches(lset labels.Labels) bool {
+	for _, m := range r.matchers {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// AppliedTo returns true if the deletion request was already applied to the block with the given meta.
+func (r *DeletionRequest) AppliedTo(m *metadata.Meta) bool {
+	id := r.ID.String()
+	for _, applied := range m.Thanos.DeletionRequests {
+		if applied == id {
+			return true
+		}
+	}
+	return false
+}
+
+// PendingDeletionRequests returns deletion requests which apply to, but were not yet applied to the block with the given meta.
+func PendingDeletionRequests(m *metadata.Meta, requests []*DeletionRequest, now time.Time) []*DeletionRequest {
+	var pending []*DeletionRequest
+	for _, r := range requests {
+		if _, ok := r.Interval(m, now); !ok || r.AppliedTo(m) {
+			continue
+		}
+		pending = append(pending, r)
+	}
+	return pending
+}
+
+// applicableDeletionRequestIDs returns IDs of deletion requests which apply to the block with the given meta,
+// assuming all of them were applied to all source blocks of it.
+func applicableDeletionRequestIDs(m *metadata.Meta, requests []*DeletionRequest, now time.Time) []string {
+	var ids []string
+	for _, r := range requests {
+		if _, ok := r.Interval(m, now); ok {
+			ids = append(ids, r.ID.String())
+		}
+	}
+	return ids
+}
+
+func deletionRequestPath(id ulid.ULID) string {
+	return path.Join(DeletionRequestsDir, id.String()+".json")
+}
+
+// UploadDeletionRequest uploads the deletion request to the bucket.
+func UploadDeletionRequest(ctx context.Context, bkt objstore.Bucket, r *DeletionRequest) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "json encode deletion request")
+	}
+	return errors.Wrap(bkt.Upload(ctx, deletionRequestPath(r.ID), bytes.NewReader(b)), "upload deletion request")
+}
+
+// ReadDeletionRequests returns all deletion requests stored in the bucket, ordered by their creation.
+// Invalid requests are logged and skipped.
+func ReadDeletionRequests(ctx context.Context, logger log.Logger, bkt objstore.Bucket) ([]*DeletionRequest, error) {
+	var requests []*DeletionRequest
+	if err := bkt.Iter(ctx, DeletionRequestsDir+"/", func(name string) error {
+		if !strings.HasSuffix(name, ".json") {
+			return nil
+		}
+		rc, err := bkt.Get(ctx, name)
+		if err != nil {
+			if bkt.IsObjNotFoundErr(err) {
+				// Cancelled concurrently.
+				return nil
+			}
+			return errors.Wrapf(err, "get deletion request %s", name)
+		}
+		defer runutil.CloseWithLogOnErr(logger, rc, "close deletion request reader")
+
+		b, err := ioutil.ReadAll(rc)
+		if err != nil {
+			return errors.Wrapf(err, "read deletion request %s", name)
+		}
+		r := &DeletionRequest{}
+		if err := json.Unmarshal(b, r); err != nil {
+			level.Warn(logger).Log("msg", "skipping deletion request which cannot be decoded", "name", name, "err", err)
+			return nil
+		}
+		if err := r.validate(); err != nil {
+			level.Warn(logger).Log("msg", "skipping invalid deletion request", "name", name, "err", err)
+			return nil
+		}
+		requests = append(requests, r)
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "iterate deletion requests")
+	}
+
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].ID.Compare(requests[j].ID) < 0
+	})
+	return requests, nil
+}
+
+// CancelDeletionRequest removes the deletion request from the bucket. Blocks already rewritten are not restored.
+func CancelDeletionRequest(ctx context.Context, bkt objstore.Bucket, id ulid.ULID) error {
+	exists, err := bkt.Exists(ctx, deletionRequestPath(id))
+	if err != nil {
+		return errors.Wrapf(err, "check deletion request %s", id)
+	}
+	if !exists {
+		return ErrDeletionRequestNotFound
+	}
+	return errors.Wrapf(bkt.Delete(ctx, deletionRequestPath(id)), "delete deletion request %s", id)
+}
+
+// writeWithDeletionRequests writes the block from bdir to resdir, without samples deleted by the given requests.
+// The result block is described by the given meta, with the IDs of the applied requests added.
+// Both raw and downsampled blocks are supported.
+func writeWithDeletionRequests(logger log.Logger, bdir, resdir string, meta metadata.Meta, requests []*DeletionRequest, now time.Time) (_ *metadata.Meta, err error) {
+	var deletions []deletion
+	meta.Thanos.DeletionRequests = append([]string(nil), meta.Thanos.DeletionRequests...)
+	for _, r := range requests {
+		itv, ok := r.Interval(&meta, now)
+		if !ok {
+			continue
+		}
+		deletions = append(deletions, deletion{request: r, interval: itv})
+		if !r.AppliedTo(&meta) {
+			meta.Thanos.DeletionRequests = append(meta.Thanos.DeletionRequests, r.ID.String())
+		}
+	}
+	sort.Strings(meta.Thanos.DeletionRequests)
+
+	var pool chunkenc.Pool
+	if meta.Thanos.Downsample.Resolution == 0 {
+		pool = chunkenc.NewPool()
+	} else {
+		pool = downsample.NewPool()
+	}
+
+	b, err := tsdb.OpenBlock(logger, bdir, pool)
+	if err != nil {
+		return nil, errors.Wrapf(err, "open block %s", bdir)
+	}
+	defer runutil.CloseWithErrCapture(&err, b, "deletion block reader")
+
+	if err := os.MkdirAll(resdir, 0777); err != nil {
+		return nil, errors.Wrap(err, "create result block dir")
+	}
+	if err := rewriteWithDeletions(logger, b, resdir, meta, deletions); err != nil {
+		return nil, err
+	}
+	return metadata.Read(resdir)
+}
+
+// ApplyDeletionRequests replaces the block in bdir with a block without samples deleted by the given requests
+// and returns its meta. The block keeps its ULID, so it can be still used as a source of compaction or downsampling.
+func ApplyDeletionRequests(logger log.Logger, bdir string, meta metadata.Meta, requests []*DeletionRequest, now time.Time) (*metadata.Meta, error) {
+	tmpdir := bdir + ".tmp-deletions"
+	if err := os.RemoveAll(tmpdir); err != nil {
+		return nil, errors.Wrap(err, "clean temporary dir")
+	}
+	res, err := writeWithDeletionRequests(logger, bdir, tmpdir, meta, requests, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(bdir); err != nil {
+		return nil, errors.Wrap(err, "remove original block")
+	}
+	if err := os.Rename(tmpdir, bdir); err != nil {
+		return nil, errors.Wrap(err, "rename block with applied deletion requests")
+	}
+	return res, nil
+}
+
+type deletion struct {
+	request  *DeletionRequest
+	interval tombstones.Interval
+}
+
+func rewriteWithDeletions(logger log.Logger, b tsdb.BlockReader, resdir string, meta metadata.Meta, deletions []deletion) (err error) {
+	indexr, err := b.Index()
+	if err != nil {
+		return errors.Wrap(err, "open index reader")
+	}
+	defer runutil.CloseWithErrCapture(&err, indexr, "deletion index reader")
+
+	chunkr, err := b.Chunks()
+	if err != nil {
+		return errors.Wrap(err, "open chunk reader")
+	}
+	defer runutil.CloseWithErrCapture(&err, chunkr, "deletion chunk reader")
+
+	w, err := downsample.NewStreamedBlockWriter(resdir, indexr, logger, meta)
+	if err != nil {
+		return errors.Wrap(err, "create block writer")
+	}
+	defer runutil.CloseWithErrCapture(&err, w, "close block writer")
+
+	postings, err := indexr.Postings(index.AllPostingsKey())
+	if err != nil {
+		return errors.Wrap(err, "get all postings list")
+	}
+
+	var (
+		lset          labels.Labels
+		chks          []chunks.Meta
+		deletedSeries int
+	)
+	for postings.Next() {
+		if err := indexr.Series(postings.At(), &lset, &chks); err != nil {
+			return errors.Wrapf(err, "get series %d", postings.At())
+		}
+
+		var intervals tombstones.Intervals
+		for _, d := range deletions {
+			if d.request.matches(lset) {
+				intervals = intervals.Add(d.interval)
+			}
+		}
+
+		res := make([]chunks.Meta, 0, len(chks))
+		for _, c := range chks {
+			c.Chunk, err = chunkr.Chunk(c.Ref)
+			if err != nil {
+				return errors.Wrapf(err, "get chunk %d, series %d", c.Ref, postings.At())
+			}
+			if len(intervals) > 0 {
+				var ok bool
+				c, ok, err = deleteFromChunk(c, intervals)
+				if err != nil {
+					return errors.Wrapf(err, "delete samples from chunk %d, series %d", c.Ref, postings.At())
+				}
+				if !ok {
+					continue
+				}
+			}
+			res = append(res, c)
+		}
+		if len(res) == 0 {
+			deletedSeries++
+			continue
+		}
+		if err := w.WriteSeries(lset, res); err != nil {
+			return errors.Wrapf(err, "write series %d", postings.At())
+		}
+	}
+	if postings.Err() != nil {
+		return errors.Wrap(postings.Err(), "iterate series set")
+	}
+	level.Info(logger).Log("msg", "applied deletion requests", "block", meta.ULID, "requests", len(deletions), "deletedSeries", deletedSeries)
+	return nil
+}
+
+// deleteFromChunk returns the chunk without samples within the given intervals and false if no sample is left.
+func deleteFromChunk(c chunks.Meta, intervals tombstones.Intervals) (chunks.Meta, bool, error) {
+	if (tombstones.Interval{Mint: c.MinTime, Maxt: c.MaxTime}).IsSubrange(intervals) {
+		return c, false, nil
+	}
+	overlaps := false
+	for _, itv := range intervals {
+		if itv.Mint <= c.MaxTime && c.MinTime <= itv.Maxt {
+			overlaps = true
+			break
+		}
+	}
+	if !overlaps {
+		return c, true, nil
+	}
+
+	ac, ok := c.Chunk.(*downsample.AggrChunk)
+	if !ok {
+		chk, mint, maxt, err := deleteSamples(c.Chunk, intervals)
+		if err != nil || chk == nil {
+			return c, false, err
+		}
+		return chunks.Meta{Chunk: chk, MinTime: mint, MaxTime: maxt}, true, nil
+	}
+
+	// Aggregates of a downsampled chunk share the same timestamps, count is always present.
+	var (
+		res        [downsample.NumAggrTypes]chunkenc.Chunk
+		mint, maxt int64
+	)
+	for i := downsample.AggrCount; int(i) < downsample.NumAggrTypes; i++ {
+		sub, err := ac.Get(i)
+		if err == downsample.ErrAggrNotExist {
+			continue
+		}
+		if err != nil {
+			return c, false, errors.Wrapf(err, "get %s aggregate", i)
+		}
+		var (
+			chk          chunkenc.Chunk
+			smint, smaxt int64
+		)
+		if sc, ok := sub.(*downsample.SketchChunk); ok {
+			chk, smint, smaxt, err = deleteSketches(sc, intervals)
+		} else {
+			chk, smint, smaxt, err = deleteSamples(sub, intervals)
+		}
+		if err != nil {
+			return c, false, errors.Wrapf(err, "delete %s samples", i)
+		}
+		if chk == nil {
+			if i == downsample.AggrCount {
+				return c, false, nil
+			}
+			continue
+		}
+		if i == downsample.AggrCount {
+			mint, maxt = smint, smaxt
+		}
+		res[i] = chk
+	}
+	return chunks.Meta{Chunk: downsample.EncodeAggrChunk(res), MinTime: mint, MaxTime: maxt}, true, nil
+}
+
+// deleteSamples re-encodes the given chunk without samples within the intervals. Nil chunk is returned if no sample is left.
+func deleteSamples(chk chunkenc.Chunk, intervals tombstones.Intervals) (_ chunkenc.Chunk, mint, maxt int64, _ error) {
+	res := chunkenc.NewXORChunk()
+	app, err := res.Appender()
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	it := chk.Iterator(nil)
+Outer:
+	for it.Next() {
+		t, v := it.At()
+		for _, itv := range intervals {
+			if itv.InBounds(t) {
+				continue Outer
+			}
+		}
+		if res.NumSamples() == 0 {
+			mint = t
+		}
+		maxt = t
+		app.Append(t, v)
+	}
+	if err := it.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+	if res.NumSamples() == 0 {
+		return nil, 0, 0, nil
+	}
+	return res, mint, maxt, nil
+}
+
+// deleteSketches re-encodes the given sketch chunk without sketches of windows within the intervals. Nil chunk is
+// returned if no sketch is left.
+func deleteSketches(chk *downsample.SketchChunk, intervals tombstones.Intervals) (_ chunkenc.Chunk, mint, maxt int64, _ error) {
+	res := downsample.NewSketchChunk()
+
+	it := chk.Sketches()
+Outer:
+	for it.Next() {
+		t, s := it.At()
+		for _, itv := range intervals {
+			if itv.InBounds(t) {
+				continue Outer
+			}
+		}
+		if res.NumSamples() == 0 {
+			mint = t
+		}
+		maxt = t
+		res.AppendSketch(t, s)
+	}
+	if err := it.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+	if res.NumSamples() == 0 {
+		return nil, 0, 0, nil
+	}
+	return res, mint, maxt, nil
+}
+
+// DeletionRewriter rewrites blocks which are not going to be compacted anymore, but have pending deletion requests.
+type DeletionRewriter struct {
+	logger                  log.Logger
+	bkt                     objstore.Bucket
+	dir                     string
+	minDuration             time.Duration
+	blocksMarkedForDeletion prometheus.Counter
+	rewrites                prometheus.Counter
+	rewriteFailures         prometheus.Counter
+}
+
+// NewDeletionRewriter returns a new DeletionRewriter. Blocks are considered fully compacted if they span at least
+// minDuration, or their data is older than minDuration.
+func NewDeletionRewriter(
+	logger log.Logger,
+	reg prometheus.Registerer,
+	bkt objstore.Bucket,
+	dir string,
+	minDuration time.Duration,
+	blocksMarkedForDeletion prometheus.Counter,
+) *DeletionRewriter {
+	return &DeletionRewriter{
+		logger:                  logger,
+		bkt:                     bkt,
+		dir:                     dir,
+		minDuration:             minDuration,
+		blocksMarkedForDeletion: blocksMarkedForDeletion,
+		rewrites: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_compactor_deletion_rewrites_total",
+			Help: "Total number of blocks rewritten to apply deletion requests.",
+		}),
+		rewriteFailures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_compactor_deletion_rewrite_failures_total",
+			Help: "Total number of failed block rewrites to apply deletion requests.",
+		}),
+	}
+}
+
+// RewriteBlocks rewrites fully compacted blocks with pending deletion requests. Rewritten blocks keep sources of
+// the original blocks, which are marked for deletion.
+func (r *DeletionRewriter) RewriteBlocks(ctx context.Context, metas map[ulid.ULID]*metadata.Meta, requests []*DeletionRequest) error {
+	if len(requests) == 0 {
+		return nil
+	}
+	now := time.Now()
+	for _, m := range metas {
+		if m.MaxTime-m.MinTime < r.minDuration.Milliseconds() && now.Sub(timestamp.Time(m.MaxTime)) < r.minDuration {
+			continue
+		}
+		pending := PendingDeletionRequests(m, requests, now)
+		if len(pending) == 0 {
+			continue
+		}
+		if err := r.rewrite(ctx, m, pending, now); err != nil {
+			r.rewriteFailures.Inc()
+			return errors.Wrapf(err, "rewrite block %s", m.ULID)
+		}
+		r.rewrites.Inc()
+	}
+	return nil
+}
+
+func (r *DeletionRewriter) rewrite(ctx context.Context, m *metadata.Meta, requests []*DeletionRequest, now time.Time) error {
+	bdir := filepath.Join(r.dir, m.ULID.String())
+	if err := os.RemoveAll(r.dir); err != nil {
+		return errors.Wrap(err, "clean working directory")
+	}
+	defer func() {
+		if err := os.RemoveAll(r.dir); err != nil {
+			level.Warn(r.logger).Log("msg", "failed to remove deletion working directory", "path", r.dir, "err", err)
+		}
+	}()
+
+	if err := block.Download(ctx, r.logger, r.bkt, m.ULID, bdir); err != nil {
+		return retry(errors.Wrapf(err, "download block %s", m.ULID))
+	}
+
+	newMeta := *m
+	newMeta.ULID = ulid.MustNew(ulid.Now(), rand.New(rand.NewSource(time.Now().UnixNano())))
+	newMeta.Compaction.Parents = []tsdb.BlockDesc{{ULID: m.ULID, MinTime: m.MinTime, MaxTime: m.MaxTime}}
+	resdir := filepath.Join(r.dir, newMeta.ULID.String())
+
+	res, err := writeWithDeletionRequests(r.logger, bdir, resdir, newMeta, requests, now)
+	if err != nil {
+		return err
+	}
+
+	if res.Stats.NumSeries > 0 {
+		if err := block.VerifyIndex(r.logger, filepath.Join(resdir, block.IndexFilename), res.MinTime, res.MaxTime); err != nil {
+			return halt(errors.Wrapf(err, "invalid result block %s", resdir))
+		}
+		if err := block.Upload(ctx, r.logger, r.bkt, resdir); err != nil {
+			return retry(errors.Wrapf(err, "upload of %s failed", res.ULID))
+		}
+		level.Info(r.logger).Log("msg", "uploaded block with applied deletion requests", "old_block", m.ULID, "new_block", res.ULID)
+	} else {
+		level.Info(r.logger).Log("msg", "all series of block deleted by deletion requests", "old_block", m.ULID)
+	}
+
+	// Spawn a new context so we always mark a block for deletion in full on shutdown.
+	delCtx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	if err := block.MarkForDeletion(delCtx, r.logger, r.bkt, m.ULID, r.blocksMarkedForDeletion); err != nil {
+		return retry(errors.Wrapf(err, "mark block %s for deletion", m.ULID))
+	}
+	return nil
+}
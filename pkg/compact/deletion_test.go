@@ -0,0 +1,221 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestDeletionRequest_Interval(t *testing.T) {
+	now := time.Unix(1000, 0)
+	meta := &metadata.Meta{BlockMeta: tsdb.BlockMeta{MinTime: 100000, MaxTime: 200000}}
+
+	for _, tcase := range []struct {
+		name      string
+		minTime   int64
+		maxTime   int64
+		retention time.Duration
+
+		expected tombstones.Interval
+		applies  bool
+	}{
+		{name: "range before block", minTime: 0, maxTime: 99999},
+		{name: "range after block", minTime: 200000, maxTime: 300000},
+		{name: "range within block", minTime: 120000, maxTime: 130000, expected: tombstones.Interval{Mint: 120000, Maxt: 130000}, applies: true},
+		{name: "range overlapping block start", minTime: 0, maxTime: 130000, expected: tombstones.Interval{Mint: 100000, Maxt: 130000}, applies: true},
+		{name: "range covering block", minTime: 0, maxTime: 300000, expected: tombstones.Interval{Mint: 100000, Maxt: 200000}, applies: true},
+		{name: "block within retention", retention: 900 * time.Second},
+		{name: "block older than retention", retention: 800 * time.Second, expected: tombstones.Interval{Mint: 100000, Maxt: 200000}, applies: true},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			r, err := NewDeletionRequest(`{a="1"}`, tcase.minTime, tcase.maxTime, tcase.retention, now)
+			testutil.Ok(t, err)
+
+			itv, ok := r.Interval(meta, now)
+			testutil.Equals(t, tcase.applies, ok)
+			testutil.Equals(t, tcase.expected, itv)
+		})
+	}
+}
+
+func TestNewDeletionRequest_Validation(t *testing.T) {
+	now := time.Now()
+
+	_, err := NewDeletionRequest(`{a="1"`, 0, 10, 0, now)
+	testutil.NotOk(t, err)
+	_, err = NewDeletionRequest(`{a="1"}`, 10, 0, 0, now)
+	testutil.NotOk(t, err)
+	_, err = NewDeletionRequest(`{a="1"}`, 0, 10, time.Hour, now)
+	testutil.NotOk(t, err)
+	_, err = NewDeletionRequest(`{a="1"}`, 0, 0, -time.Hour, now)
+	testutil.NotOk(t, err)
+}
+
+func TestDeletionRequests_UploadReadCancel(t *testing.T) {
+	ctx := context.Background()
+	bkt := objstore.NewInMemBucket()
+	now := time.Now()
+
+	r1, err := NewDeletionRequest(`{a="1"}`, 0, 10, 0, now)
+	testutil.Ok(t, err)
+	r2, err := NewDeletionRequest(`{a=~"2|3"}`, 0, 0, time.Hour, now.Add(time.Second))
+	testutil.Ok(t, err)
+	testutil.Ok(t, UploadDeletionRequest(ctx, bkt, r2))
+	testutil.Ok(t, UploadDeletionRequest(ctx, bkt, r1))
+
+	// Invalid requests are skipped.
+	testutil.Ok(t, bkt.Upload(ctx, DeletionRequestsDir+"/invalid.json", bytes.NewReader([]byte("{"))))
+
+	requests, err := ReadDeletionRequests(ctx, log.NewNopLogger(), bkt)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(requests))
+	testutil.Equals(t, r1.ID, requests[0].ID)
+	testutil.Equals(t, r1.Matchers, requests[0].Matchers)
+	testutil.Equals(t, r2.ID, requests[1].ID)
+	testutil.Equals(t, r2.Retention, requests[1].Retention)
+	testutil.Assert(t, requests[1].matches(labels.FromStrings("a", "3")), "expected parsed matchers")
+
+	testutil.Ok(t, CancelDeletionRequest(ctx, bkt, r1.ID))
+	testutil.Equals(t, ErrDeletionRequestNotFound, CancelDeletionRequest(ctx, bkt, r1.ID))
+
+	requests, err = ReadDeletionRequests(ctx, log.NewNopLogger(), bkt)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(requests))
+	testutil.Equals(t, r2.ID, requests[0].ID)
+}
+
+func TestApplyDeletionRequests(t *testing.T) {
+	logger := log.NewNopLogger()
+	dir, err := ioutil.TempDir("", "test-deletion-requests")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	now := time.Now()
+	series := []labels.Labels{
+		labels.FromStrings("a", "1"),
+		labels.FromStrings("a", "2"),
+		labels.FromStrings("a", "3"),
+	}
+	id, err := e2eutil.CreateBlock(context.Background(), dir, series, 100, 0, downsample.DownsampleRange0, labels.Labels{{Name: "e1", Value: "1"}}, downsample.ResLevel0)
+	testutil.Ok(t, err)
+	bdir := filepath.Join(dir, id.String())
+
+	meta, err := metadata.Read(bdir)
+	testutil.Ok(t, err)
+
+	// Downsample the block before applying deletions, to verify the deletion from aggregated chunks.
+	b, err := tsdb.OpenBlock(logger, bdir, chunkenc.NewPool())
+	testutil.Ok(t, err)
+	downsampledID, err := downsample.Downsample(logger, meta, b, dir, downsample.ResLevel1)
+	testutil.Ok(t, err)
+	testutil.Ok(t, b.Close())
+	downsampledDir := filepath.Join(dir, downsampledID.String())
+
+	half := int64(downsample.DownsampleRange0 / 2)
+	deleteAll, err := NewDeletionRequest(`{a="1"}`, 0, downsample.DownsampleRange0, 0, now)
+	testutil.Ok(t, err)
+	deleteHalf, err := NewDeletionRequest(`{a="2"}`, 0, half, 0, now.Add(time.Millisecond))
+	testutil.Ok(t, err)
+	notMatching, err := NewDeletionRequest(`{a="4"}`, 0, downsample.DownsampleRange0, 0, now.Add(2*time.Millisecond))
+	testutil.Ok(t, err)
+	requests := []*DeletionRequest{deleteAll, deleteHalf, notMatching}
+
+	for _, tcase := range []struct {
+		name string
+		dir  string
+		pool chunkenc.Pool
+	}{
+		{name: "raw block", dir: bdir, pool: chunkenc.NewPool()},
+		{name: "downsampled block", dir: downsampledDir, pool: downsample.NewPool()},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			before, err := metadata.Read(tcase.dir)
+			testutil.Ok(t, err)
+			testutil.Equals(t, 3, len(PendingDeletionRequests(before, requests, now)))
+			untouched := readBlockSamples(t, tcase.dir, tcase.pool)[labels.FromStrings("a", "3").String()]
+
+			res, err := ApplyDeletionRequests(logger, tcase.dir, *before, requests, now)
+			testutil.Ok(t, err)
+			testutil.Equals(t, before.ULID, res.ULID)
+			testutil.Equals(t, []string{deleteAll.ID.String(), deleteHalf.ID.String(), notMatching.ID.String()}, res.Thanos.DeletionRequests)
+			testutil.Equals(t, 0, len(PendingDeletionRequests(res, requests, now)))
+			testutil.Equals(t, uint64(2), res.Stats.NumSeries)
+
+			after, err := metadata.Read(tcase.dir)
+			testutil.Ok(t, err)
+			testutil.Equals(t, res.Thanos.DeletionRequests, after.Thanos.DeletionRequests)
+
+			samples := readBlockSamples(t, tcase.dir, tcase.pool)
+			testutil.Equals(t, 2, len(samples))
+			for _, ts := range samples[labels.FromStrings("a", "2").String()] {
+				testutil.Assert(t, ts > half, "sample %d within deleted range", ts)
+			}
+			testutil.Assert(t, len(samples[labels.FromStrings("a", "2").String()]) > 0, "expected samples after deleted range")
+			testutil.Equals(t, untouched, samples[labels.FromStrings("a", "3").String()])
+		})
+	}
+}
+
+// readBlockSamples returns timestamps of samples for each series of the block. For downsampled blocks, timestamps
+// of the count aggregate are returned.
+func readBlockSamples(t *testing.T, dir string, pool chunkenc.Pool) map[string][]int64 {
+	b, err := tsdb.OpenBlock(log.NewNopLogger(), dir, pool)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, b.Close()) }()
+
+	ir, err := b.Index()
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, ir.Close()) }()
+	cr, err := b.Chunks()
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, cr.Close()) }()
+
+	k, v := index.AllPostingsKey()
+	p, err := ir.Postings(k, v)
+	testutil.Ok(t, err)
+
+	res := map[string][]int64{}
+	for p.Next() {
+		var (
+			lset labels.Labels
+			chks []chunks.Meta
+		)
+		testutil.Ok(t, ir.Series(p.At(), &lset, &chks))
+		for _, c := range chks {
+			chk, err := cr.Chunk(c.Ref)
+			testutil.Ok(t, err)
+			if ac, ok := chk.(*downsample.AggrChunk); ok {
+				chk, err = ac.Get(downsample.AggrCount)
+				testutil.Ok(t, err)
+			}
+			it := chk.Iterator(nil)
+			for it.Next() {
+				ts, _ := it.At()
+				res[lset.String()] = append(res[lset.String()], ts)
+			}
+			testutil.Ok(t, it.Err())
+		}
+	}
+	testutil.Ok(t, p.Err())
+	return res
+}
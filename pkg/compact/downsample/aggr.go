@@ -20,7 +20,7 @@ type AggrChunk []byte
 
 // EncodeAggrChunk encodes a new aggregate chunk from the array of chunks for each aggregate.
 // Each array entry corresponds to the respective AggrType number.
-func EncodeAggrChunk(chks [5]chunkenc.Chunk) *AggrChunk {
+func EncodeAggrChunk(chks [NumAggrTypes]chunkenc.Chunk) *AggrChunk {
 	var b []byte
 	buf := [8]byte{}
 
@@ -74,8 +74,12 @@ func (c AggrChunk) Get(t AggrType) (chunkenc.Chunk, error) {
 	var x []byte
 
 	for i := AggrType(0); i <= t; i++ {
+		// Chunks encoded before an aggregate type was introduced end before its entry.
+		if len(b) == 0 {
+			return nil, ErrAggrNotExist
+		}
 		l, n := binary.Uvarint(b)
-		if n < 1 || len(b[n:]) < int(l)+1 {
+		if n < 1 {
 			return nil, errors.New("invalid size")
 		}
 		b = b[n:]
@@ -86,9 +90,16 @@ func (c AggrChunk) Get(t AggrType) (chunkenc.Chunk, error) {
 			}
 			continue
 		}
+		if len(b) < int(l)+1 {
+			return nil, errors.New("invalid size")
+		}
 		x = b[:int(l)+1]
 		b = b[int(l)+1:]
 	}
+	if chunkenc.Encoding(x[0]) == ChunkEncSketch {
+		sc := SketchChunk(x[1:])
+		return &sc, nil
+	}
 	return chunkenc.FromData(chunkenc.Encoding(x[0]), x[1:])
 }
 
@@ -102,8 +113,13 @@ const (
 	AggrMin
 	AggrMax
 	AggrCounter
+	AggrLast
+	AggrSketch
 )
 
+// NumAggrTypes is the number of aggregation types an AggrChunk can hold.
+const NumAggrTypes = int(AggrSketch) + 1
+
 func (t AggrType) String() string {
 	switch t {
 	case AggrCount:
@@ -116,6 +132,10 @@ func (t AggrType) String() string {
 		return "max"
 	case AggrCounter:
 		return "counter"
+	case AggrLast:
+		return "last"
+	case AggrSketch:
+		return "sketch"
 	}
 	return "<unknown>"
 }
@@ -4,6 +4,7 @@
 package downsample
 
 import (
+	"encoding/binary"
 	"testing"
 	"time"
 
@@ -15,15 +16,17 @@ import (
 func TestAggrChunk(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
-	var input [5][]sample
+	var input [NumAggrTypes][]sample
 
 	input[AggrCount] = []sample{{100, 30}, {200, 50}, {300, 60}, {400, 67}}
 	input[AggrSum] = []sample{{100, 130}, {200, 1000}, {300, 2000}, {400, 5555}}
 	input[AggrMin] = []sample{{100, 0}, {200, -10}, {300, 1000}, {400, -9.5}}
 	// Maximum is absent.
 	input[AggrCounter] = []sample{{100, 5}, {200, 10}, {300, 10.1}, {400, 15}, {400, 3}}
+	input[AggrLast] = []sample{{100, 2}, {200, -1}, {300, 1000}, {400, 0}}
+	// Sketch is absent.
 
-	var chks [5]chunkenc.Chunk
+	var chks [NumAggrTypes]chunkenc.Chunk
 
 	for i, smpls := range input {
 		if len(smpls) == 0 {
@@ -38,10 +41,10 @@ func TestAggrChunk(t *testing.T) {
 		}
 	}
 
-	var res [5][]sample
+	var res [NumAggrTypes][]sample
 	ac := EncodeAggrChunk(chks)
 
-	for _, at := range []AggrType{AggrCount, AggrSum, AggrMin, AggrMax, AggrCounter} {
+	for _, at := range []AggrType{AggrCount, AggrSum, AggrMin, AggrMax, AggrCounter, AggrLast, AggrSketch} {
 		if c, err := ac.Get(at); err != ErrAggrNotExist {
 			testutil.Ok(t, err)
 			testutil.Ok(t, expandChunkIterator(c.Iterator(nil), &res[at]))
@@ -49,3 +52,34 @@ func TestAggrChunk(t *testing.T) {
 	}
 	testutil.Equals(t, input, res)
 }
+
+func TestAggrChunk_GetMissingAggregate(t *testing.T) {
+	chk := chunkenc.NewXORChunk()
+	app, err := chk.Appender()
+	testutil.Ok(t, err)
+	app.Append(100, 1)
+
+	// Chunks written before last and sketch aggregates were introduced have entries for the first 5 aggregates only.
+	ac := *EncodeAggrChunk([NumAggrTypes]chunkenc.Chunk{AggrCount: chk, AggrSum: chk})
+	var old AggrChunk
+	b := ac.Bytes()
+	for i := 0; i < int(AggrLast); i++ {
+		l, n := binary.Uvarint(b)
+		if l > 0 {
+			l++
+		}
+		old = append(old, b[:n+int(l)]...)
+		b = b[n+int(l):]
+	}
+
+	for _, c := range []AggrChunk{ac, old} {
+		_, err = c.Get(AggrSum)
+		testutil.Ok(t, err)
+		_, err = c.Get(AggrMax)
+		testutil.Equals(t, ErrAggrNotExist, err)
+		_, err = c.Get(AggrLast)
+		testutil.Equals(t, ErrAggrNotExist, err)
+		_, err = c.Get(AggrSketch)
+		testutil.Equals(t, ErrAggrNotExist, err)
+	}
+}
@@ -224,6 +224,7 @@ type aggregator struct {
 	counter float64 // Total counter state since beginning.
 	resets  int     // Number of counter resets since beginning.
 	last    float64 // Last added value.
+	sketch  Sketch  // Quantile sketch of current window.
 }
 
 // reset the stats to start a new aggregation window.
@@ -232,6 +233,7 @@ func (a *aggregator) reset() {
 	a.sum = 0
 	a.min = math.MaxFloat64
 	a.max = -math.MaxFloat64
+	a.sketch.Reset()
 }
 
 func (a *aggregator) add(v float64) {
@@ -260,6 +262,7 @@ func (a *aggregator) add(v float64) {
 	if v > a.max {
 		a.max = v
 	}
+	a.sketch.Add(v)
 }
 
 // aggrChunkBuilder builds chunks for multiple different aggregates.
@@ -267,8 +270,9 @@ type aggrChunkBuilder struct {
 	mint, maxt int64
 	added      int
 
-	chunks [5]chunkenc.Chunk
-	apps   [5]chunkenc.Appender
+	chunks [NumAggrTypes]chunkenc.Chunk
+	apps   [NumAggrTypes]chunkenc.Appender
+	sketch *SketchChunk
 }
 
 func newAggrChunkBuilder() *aggrChunkBuilder {
@@ -281,9 +285,12 @@ func newAggrChunkBuilder() *aggrChunkBuilder {
 	b.chunks[AggrMin] = chunkenc.NewXORChunk()
 	b.chunks[AggrMax] = chunkenc.NewXORChunk()
 	b.chunks[AggrCounter] = chunkenc.NewXORChunk()
+	b.chunks[AggrLast] = chunkenc.NewXORChunk()
+	b.sketch = NewSketchChunk()
+	b.chunks[AggrSketch] = b.sketch
 
 	for i, c := range b.chunks {
-		if c != nil {
+		if c != nil && c != b.sketch {
 			b.apps[i], _ = c.Appender()
 		}
 	}
@@ -302,6 +309,8 @@ func (b *aggrChunkBuilder) add(t int64, aggr *aggregator) {
 	b.apps[AggrMax].Append(t, aggr.max)
 	b.apps[AggrCount].Append(t, float64(aggr.count))
 	b.apps[AggrCounter].Append(t, aggr.counter)
+	b.apps[AggrLast].Append(t, aggr.last)
+	b.sketch.AppendSketch(t, &aggr.sketch)
 
 	b.added++
 }
@@ -512,6 +521,26 @@ func downsampleAggrBatch(chks []*AggrChunk, buf *[]sample, resolution int64) (ch
 	}); err != nil {
 		return chk, err
 	}
+	if err := do(AggrLast, func(a *aggregator) float64 {
+		return a.last
+	}); err != nil {
+		return chk, err
+	}
+
+	sketches := NewSketchChunk()
+	if err := downsampleSketches(chks, resolution, func(t int64, s *Sketch) {
+		if t < mint {
+			mint = t
+		} else if t > maxt {
+			maxt = t
+		}
+		sketches.AppendSketch(t, s)
+	}); err != nil {
+		return chk, err
+	}
+	if sketches.NumSamples() > 0 {
+		ab.chunks[AggrSketch] = sketches
+	}
 
 	// Handle counters by reading them properly.
 	acs := make([]chunkenc.Iterator, 0, len(chks))
@@ -558,6 +587,65 @@ func downsampleAggrBatch(chks []*AggrChunk, buf *[]sample, resolution int64) (ch
 	return ab.encode(), nil
 }
 
+// downsampleSketches merges the quantile sketches of the given chunks over the given resolution and calls add
+// each time the end of a resolution was reached. Windows match those of downsampleBatch for the other aggregates.
+// Chunks downsampled before sketches were introduced have none and are skipped.
+func downsampleSketches(chks []*AggrChunk, resolution int64, add func(int64, *Sketch)) error {
+	type window struct {
+		t int64
+		s *Sketch
+	}
+	var all []window
+	for _, chk := range chks {
+		c, err := chk.Get(AggrSketch)
+		if err == ErrAggrNotExist {
+			continue
+		} else if err != nil {
+			return err
+		}
+		sc, ok := c.(*SketchChunk)
+		if !ok {
+			return errors.Errorf("expected sketch chunk, got %T", c)
+		}
+		it := sc.Sketches()
+		for it.Next() {
+			t, s := it.At()
+			// Ensure the series does not go back in time, see expandChunkIterator.
+			if len(all) > 0 && t <= all[len(all)-1].t {
+				continue
+			}
+			all = append(all, window{t: t, s: s})
+		}
+		if err := it.Err(); err != nil {
+			return err
+		}
+	}
+	if len(all) == 0 {
+		return nil
+	}
+
+	var (
+		merged Sketch
+		nextT  = int64(-1)
+		lastT  = all[len(all)-1].t
+	)
+	for _, w := range all {
+		if w.t > nextT {
+			if nextT != -1 {
+				add(nextT, &merged)
+			}
+			merged.Reset()
+			nextT = currentWindow(w.t, resolution)
+			if nextT > lastT {
+				nextT = lastT
+			}
+		}
+		merged.Merge(w.s)
+	}
+	add(nextT, &merged)
+	return nil
+}
+
 type sample struct {
 	t int64
 	v float64
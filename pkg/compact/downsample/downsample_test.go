@@ -549,6 +549,72 @@ func encodeTestAggrSeries(v map[AggrType][]sample) chunks.Meta {
 	return b.encode()
 }
 
+func TestDownsampleLastAndSketch(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	// Samples every 10ms with values following the timestamps, except the last one in each 100ms window.
+	var raw []sample
+	for ts := int64(0); ts < 1000; ts += 10 {
+		v := float64(ts)
+		if ts%100 == 90 {
+			v = -1
+		}
+		raw = append(raw, sample{t: ts, v: v})
+	}
+
+	var last []sample
+	// The first of two chunks ends with the last sample of its batch.
+	for _, c := range downsampleRawLoop(raw, 100, 2) {
+		ac := c.Chunk.(*AggrChunk)
+		x, err := ac.Get(AggrLast)
+		testutil.Ok(t, err)
+		testutil.Ok(t, expandChunkIterator(x.Iterator(nil), &last))
+
+		x, err = ac.Get(AggrSketch)
+		testutil.Ok(t, err)
+		it := x.(*SketchChunk).Sketches()
+		for it.Next() {
+			ts, s := it.At()
+			testutil.Equals(t, uint64(10), s.Count())
+			testutil.Equals(t, -sketchValue(sketchIndex(1)), s.Quantile(0))
+			testutil.Equals(t, sketchValue(sketchIndex(float64(ts-ts%100+80))), s.Quantile(1))
+		}
+		testutil.Ok(t, it.Err())
+	}
+	testutil.Equals(t, []sample{{99, -1}, {199, -1}, {299, -1}, {399, -1}, {499, -1}, {590, -1}, {699, -1}, {799, -1}, {899, -1}, {990, -1}}, last)
+
+	// Re-aggregate over 500ms windows.
+	var aggrChunks []*AggrChunk
+	for _, c := range downsampleRawLoop(raw, 100, 1) {
+		aggrChunks = append(aggrChunks, c.Chunk.(*AggrChunk))
+	}
+	var buf []sample
+	res, err := downsampleAggrLoop(aggrChunks, &buf, 500, 1)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(res))
+
+	ac := res[0].Chunk.(*AggrChunk)
+	x, err := ac.Get(AggrLast)
+	testutil.Ok(t, err)
+	last = last[:0]
+	testutil.Ok(t, expandChunkIterator(x.Iterator(nil), &last))
+	testutil.Equals(t, []sample{{499, -1}, {990, -1}}, last)
+
+	x, err = ac.Get(AggrSketch)
+	testutil.Ok(t, err)
+	it := x.(*SketchChunk).Sketches()
+	var windows []int64
+	for it.Next() {
+		ts, s := it.At()
+		windows = append(windows, ts)
+		testutil.Equals(t, uint64(50), s.Count())
+		testutil.Equals(t, -sketchValue(sketchIndex(1)), s.Quantile(0.1))
+		testutil.Equals(t, sketchValue(sketchIndex(float64(ts-ts%500+480))), s.Quantile(1))
+	}
+	testutil.Ok(t, it.Err())
+	testutil.Equals(t, []int64{499, 990}, windows)
+}
+
 func TestAverageChunkIterator(t *testing.T) {
 	sum := []sample{{100, 30}, {200, 40}, {300, 5}, {400, -10}}
 	cnt := []sample{{100, 1}, {200, 5}, {300, 2}, {400, 10}}
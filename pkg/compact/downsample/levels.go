@@ -0,0 +1,117 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package downsample
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+// Level is a step of the downsampling ladder. Blocks of the previous resolution spanning at least
+// MinBlockRange are downsampled to Resolution.
+type Level struct {
+	Resolution    int64 // In milliseconds.
+	MinBlockRange int64 // In milliseconds.
+}
+
+// String returns the level in the <resolution>:<min-block-range> format.
+func (l Level) String() string {
+	return fmt.Sprintf("%s:%s", model.Duration(time.Duration(l.Resolution)*time.Millisecond), model.Duration(time.Duration(l.MinBlockRange)*time.Millisecond))
+}
+
+// Levels is a downsampling ladder ordered from the lowest to the highest resolution.
+type Levels []Level
+
+// DefaultLevels are the standard downsampling levels in Thanos: raw data is downsampled to 5m resolution
+// once blocks span 40 hours, 5m resolution data to 1h resolution once blocks span 10 days.
+var DefaultLevels = Levels{
+	{Resolution: ResLevel1, MinBlockRange: DownsampleRange0},
+	{Resolution: ResLevel2, MinBlockRange: DownsampleRange1},
+}
+
+// DefaultLevelsFlagValues returns the default levels in the format accepted by ParseLevels.
+func DefaultLevelsFlagValues() []string {
+	res := make([]string, 0, len(DefaultLevels))
+	for _, l := range DefaultLevels {
+		res = append(res, l.String())
+	}
+	return res
+}
+
+// ParseLevels parses and validates levels given in the <resolution>:<min-block-range> format, e.g 5m:40h.
+func ParseLevels(specs []string) (Levels, error) {
+	levels := make(Levels, 0, len(specs))
+	for _, s := range specs {
+		parts := strings.Split(s, ":")
+		if len(parts) != 2 {
+			return nil, errors.Errorf("downsampling level %q: expected <resolution>:<min-block-range> format", s)
+		}
+		res, err := model.ParseDuration(parts[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "downsampling level %q: parse resolution", s)
+		}
+		minRange, err := model.ParseDuration(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "downsampling level %q: parse minimum block range", s)
+		}
+		levels = append(levels, Level{
+			Resolution:    int64(time.Duration(res) / time.Millisecond),
+			MinBlockRange: int64(time.Duration(minRange) / time.Millisecond),
+		})
+	}
+	if err := levels.Validate(); err != nil {
+		return nil, err
+	}
+	return levels, nil
+}
+
+// Validate returns an error if resolutions of levels are not strictly increasing multiples of the previous
+// resolution, or if any minimum block range is not positive.
+func (ls Levels) Validate() error {
+	prev := ResLevel0
+	for _, l := range ls {
+		if l.Resolution <= prev {
+			return errors.Errorf("downsampling level %s: resolution must be higher than the resolution of the previous level", l)
+		}
+		// Aggregation windows are aligned to the resolution, so windows of the previous level must fit into them.
+		if prev != ResLevel0 && l.Resolution%prev != 0 {
+			return errors.Errorf("downsampling level %s: resolution must be a multiple of the resolution of the previous level", l)
+		}
+		if l.MinBlockRange <= 0 {
+			return errors.Errorf("downsampling level %s: minimum block range must be positive", l)
+		}
+		prev = l.Resolution
+	}
+	return nil
+}
+
+// Next returns the level blocks of the given resolution are downsampled to. False is returned
+// if blocks of the resolution are not downsampled further.
+func (ls Levels) Next(resolution int64) (Level, bool) {
+	if len(ls) == 0 {
+		return Level{}, false
+	}
+	if resolution == ResLevel0 {
+		return ls[0], true
+	}
+	for i, l := range ls[:len(ls)-1] {
+		if l.Resolution == resolution {
+			return ls[i+1], true
+		}
+	}
+	return Level{}, false
+}
+
+// Resolutions returns all resolutions of the ladder, including the raw one, from the lowest to the highest.
+func (ls Levels) Resolutions() []int64 {
+	res := []int64{ResLevel0}
+	for _, l := range ls {
+		res = append(res, l.Resolution)
+	}
+	return res
+}
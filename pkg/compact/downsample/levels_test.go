@@ -0,0 +1,66 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package downsample
+
+import (
+	"testing"
+
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestParseLevels(t *testing.T) {
+	levels, err := ParseLevels(DefaultLevelsFlagValues())
+	testutil.Ok(t, err)
+	testutil.Equals(t, DefaultLevels, levels)
+	testutil.Equals(t, []string{"5m:40h", "1h:10d"}, DefaultLevelsFlagValues())
+
+	levels, err = ParseLevels([]string{"1m:12h", "10m:7d", "2h:30d"})
+	testutil.Ok(t, err)
+	testutil.Equals(t, Levels{
+		{Resolution: 60 * 1000, MinBlockRange: 12 * 60 * 60 * 1000},
+		{Resolution: 10 * 60 * 1000, MinBlockRange: 7 * 24 * 60 * 60 * 1000},
+		{Resolution: 2 * 60 * 60 * 1000, MinBlockRange: 30 * 24 * 60 * 60 * 1000},
+	}, levels)
+
+	levels, err = ParseLevels(nil)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(levels))
+
+	for _, specs := range [][]string{
+		{"5m"},
+		{"5m:40h:1d"},
+		{"5x:40h"},
+		{"5m:40x"},
+		{"0s:40h"},
+		{"5m:0s"},
+		{"1h:10d", "5m:40h"},
+		{"5m:40h", "7m:10d"},
+	} {
+		_, err := ParseLevels(specs)
+		testutil.NotOk(t, err)
+	}
+}
+
+func TestLevels_Next(t *testing.T) {
+	levels := Levels{
+		{Resolution: 60 * 1000, MinBlockRange: 1000},
+		{Resolution: 10 * 60 * 1000, MinBlockRange: 2000},
+	}
+	testutil.Equals(t, []int64{ResLevel0, 60 * 1000, 10 * 60 * 1000}, levels.Resolutions())
+
+	next, ok := levels.Next(ResLevel0)
+	testutil.Assert(t, ok, "expected raw data to be downsampled")
+	testutil.Equals(t, levels[0], next)
+
+	next, ok = levels.Next(60 * 1000)
+	testutil.Assert(t, ok, "expected 1m data to be downsampled")
+	testutil.Equals(t, levels[1], next)
+
+	_, ok = levels.Next(10 * 60 * 1000)
+	testutil.Assert(t, !ok, "expected the highest resolution not to be downsampled")
+	_, ok = levels.Next(ResLevel1)
+	testutil.Assert(t, !ok, "expected unknown resolution not to be downsampled")
+	_, ok = Levels{}.Next(ResLevel0)
+	testutil.Assert(t, !ok, "expected no downsampling without levels")
+}
@@ -0,0 +1,387 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package downsample
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+// ChunkEncSketch is the encoding byte of the SketchChunk within the AggrChunk.
+const ChunkEncSketch = chunkenc.Encoding(0xfe)
+
+const (
+	// sketchRelativeAccuracy is the maximum relative error of quantiles estimated by a Sketch.
+	sketchRelativeAccuracy = 0.01
+	// sketchMinValue is the smallest absolute value a Sketch distinguishes from zero.
+	sketchMinValue = 1e-9
+	// maxSketchSamples is the maximum number of samples a sketch is expanded into when iterating a SketchChunk.
+	maxSketchSamples = 100
+)
+
+var (
+	sketchGamma    = (1 + sketchRelativeAccuracy) / (1 - sketchRelativeAccuracy)
+	sketchLogGamma = math.Log(sketchGamma)
+)
+
+// Sketch is a mergeable quantile sketch following DDSketch (https://arxiv.org/abs/1908.10693). Values are counted
+// in buckets of logarithmically growing size, so each quantile is estimated with a relative error of at most 1%.
+// Merging the sketches of adjacent windows yields the sketch of the joined window.
+// The zero value is an empty sketch.
+type Sketch struct {
+	pos, neg map[int32]uint64 // Bucket counts of positive and negative values by bucket index.
+	zero     uint64           // Count of values too close to zero to be bucketed.
+	count    uint64           // Total count of values.
+}
+
+type sketchBucket struct {
+	v     float64
+	count uint64
+}
+
+func sketchIndex(v float64) int32 {
+	return int32(math.Ceil(math.Log(v) / sketchLogGamma))
+}
+
+func sketchValue(k int32) float64 {
+	return 2 * math.Pow(sketchGamma, float64(k)) / (sketchGamma + 1)
+}
+
+// Add adds the value to the sketch. NaN and infinite values are ignored.
+func (s *Sketch) Add(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return
+	}
+	switch {
+	case v > sketchMinValue:
+		if s.pos == nil {
+			s.pos = map[int32]uint64{}
+		}
+		s.pos[sketchIndex(v)]++
+	case v < -sketchMinValue:
+		if s.neg == nil {
+			s.neg = map[int32]uint64{}
+		}
+		s.neg[sketchIndex(-v)]++
+	default:
+		s.zero++
+	}
+	s.count++
+}
+
+// Merge adds all values of the other sketch to the sketch.
+func (s *Sketch) Merge(o *Sketch) {
+	if len(o.pos) > 0 && s.pos == nil {
+		s.pos = make(map[int32]uint64, len(o.pos))
+	}
+	for k, c := range o.pos {
+		s.pos[k] += c
+	}
+	if len(o.neg) > 0 && s.neg == nil {
+		s.neg = make(map[int32]uint64, len(o.neg))
+	}
+	for k, c := range o.neg {
+		s.neg[k] += c
+	}
+	s.zero += o.zero
+	s.count += o.count
+}
+
+// Reset removes all values from the sketch.
+func (s *Sketch) Reset() {
+	for k := range s.pos {
+		delete(s.pos, k)
+	}
+	for k := range s.neg {
+		delete(s.neg, k)
+	}
+	s.zero = 0
+	s.count = 0
+}
+
+// Count returns the number of values added to the sketch.
+func (s *Sketch) Count() uint64 {
+	return s.count
+}
+
+// Quantile returns the estimated q-quantile of the added values, or NaN if the sketch is empty.
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.count == 0 {
+		return math.NaN()
+	}
+	return s.appendQuantiles(nil, []float64{q})[0]
+}
+
+// appendQuantiles appends the estimated quantiles for the given ascending qs to vs. The sketch must not be empty.
+func (s *Sketch) appendQuantiles(vs []float64, qs []float64) []float64 {
+	bs := s.buckets()
+	j, cum := 0, bs[0].count
+	for _, q := range qs {
+		if q < 0 {
+			q = 0
+		} else if q > 1 {
+			q = 1
+		}
+		rank := q * float64(s.count-1)
+		for float64(cum) <= rank && j < len(bs)-1 {
+			j++
+			cum += bs[j].count
+		}
+		vs = append(vs, bs[j].v)
+	}
+	return vs
+}
+
+// buckets returns the non-empty buckets of the sketch in ascending order of their values.
+func (s *Sketch) buckets() []sketchBucket {
+	bs := make([]sketchBucket, 0, len(s.neg)+len(s.pos)+1)
+	// Negative buckets with higher index hold lower values.
+	for _, k := range sortedSketchKeys(s.neg) {
+		bs = append(bs, sketchBucket{v: -sketchValue(k), count: s.neg[k]})
+	}
+	for i, j := 0, len(bs)-1; i < j; i, j = i+1, j-1 {
+		bs[i], bs[j] = bs[j], bs[i]
+	}
+	if s.zero > 0 {
+		bs = append(bs, sketchBucket{v: 0, count: s.zero})
+	}
+	for _, k := range sortedSketchKeys(s.pos) {
+		bs = append(bs, sketchBucket{v: sketchValue(k), count: s.pos[k]})
+	}
+	return bs
+}
+
+func sortedSketchKeys(m map[int32]uint64) []int32 {
+	keys := make([]int32, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	return keys
+}
+
+// appendEncoded appends the binary encoding of the sketch to b.
+func (s *Sketch) appendEncoded(b []byte) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	b = append(b, buf[:binary.PutUvarint(buf[:], s.zero)]...)
+
+	for _, m := range []map[int32]uint64{s.neg, s.pos} {
+		b = append(b, buf[:binary.PutUvarint(buf[:], uint64(len(m)))]...)
+		prev := int64(0)
+		for _, k := range sortedSketchKeys(m) {
+			b = append(b, buf[:binary.PutVarint(buf[:], int64(k)-prev)]...)
+			b = append(b, buf[:binary.PutUvarint(buf[:], m[k])]...)
+			prev = int64(k)
+		}
+	}
+	return b
+}
+
+// decodeSketch decodes a sketch encoded by appendEncoded.
+func decodeSketch(b []byte) (*Sketch, error) {
+	s := &Sketch{}
+
+	zero, n := binary.Uvarint(b)
+	if n < 1 {
+		return nil, errors.New("invalid zero count")
+	}
+	b = b[n:]
+	s.zero, s.count = zero, zero
+
+	for _, m := range []*map[int32]uint64{&s.neg, &s.pos} {
+		l, n := binary.Uvarint(b)
+		if n < 1 {
+			return nil, errors.New("invalid number of buckets")
+		}
+		b = b[n:]
+		if l == 0 {
+			continue
+		}
+		*m = make(map[int32]uint64, l)
+
+		k := int64(0)
+		for i := uint64(0); i < l; i++ {
+			d, n := binary.Varint(b)
+			if n < 1 {
+				return nil, errors.New("invalid bucket index")
+			}
+			b = b[n:]
+			c, n := binary.Uvarint(b)
+			if n < 1 {
+				return nil, errors.New("invalid bucket count")
+			}
+			b = b[n:]
+			k += d
+			(*m)[int32(k)] = c
+			s.count += c
+		}
+	}
+	if len(b) > 0 {
+		return nil, errors.New("unexpected trailing bytes")
+	}
+	return s, nil
+}
+
+// SketchChunk holds a quantile sketch for each downsampling window of a series, identified by the timestamp
+// of the window. The chunk starts with the number of sketches as 2 byte big endian integer followed by the
+// varint timestamp and the length prefixed encoding of each sketch.
+type SketchChunk []byte
+
+// NewSketchChunk returns an empty sketch chunk.
+func NewSketchChunk() *SketchChunk {
+	c := SketchChunk(make([]byte, 2, 128))
+	return &c
+}
+
+// AppendSketch appends the sketch of the window with the given timestamp. The timestamp must be
+// higher than timestamps of all previously appended sketches.
+func (c *SketchChunk) AppendSketch(t int64, s *Sketch) {
+	var buf [binary.MaxVarintLen64]byte
+	enc := s.appendEncoded(nil)
+
+	b := *c
+	b = append(b, buf[:binary.PutVarint(buf[:], t)]...)
+	b = append(b, buf[:binary.PutUvarint(buf[:], uint64(len(enc)))]...)
+	b = append(b, enc...)
+	binary.BigEndian.PutUint16(b, binary.BigEndian.Uint16(b)+1)
+	*c = b
+}
+
+func (c SketchChunk) Bytes() []byte {
+	return []byte(c)
+}
+
+func (c SketchChunk) Encoding() chunkenc.Encoding {
+	return ChunkEncSketch
+}
+
+func (c SketchChunk) Appender() (chunkenc.Appender, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c SketchChunk) NumSamples() int {
+	if len(c) < 2 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(c))
+}
+
+// Sketches returns an iterator over the sketches of the chunk.
+func (c SketchChunk) Sketches() *SketchIterator {
+	if len(c) < 2 {
+		return &SketchIterator{err: errors.New("invalid sketch chunk")}
+	}
+	return &SketchIterator{b: c[2:]}
+}
+
+// Iterator returns an iterator expanding the sketch of each window into samples with values evenly spaced over
+// the quantiles of the window, so that PromQL functions like quantile_over_time approximate the result for the
+// raw data. The samples of a window have consecutive millisecond timestamps ending at the window timestamp.
+func (c SketchChunk) Iterator(_ chunkenc.Iterator) chunkenc.Iterator {
+	return &sketchSamplesIterator{sketches: c.Sketches()}
+}
+
+// SketchIterator iterates over the sketches of a SketchChunk.
+type SketchIterator struct {
+	b   []byte
+	t   int64
+	s   *Sketch
+	err error
+}
+
+func (it *SketchIterator) Next() bool {
+	if it.err != nil || len(it.b) == 0 {
+		return false
+	}
+	t, n := binary.Varint(it.b)
+	if n < 1 {
+		it.err = errors.New("invalid sketch timestamp")
+		return false
+	}
+	it.b = it.b[n:]
+	l, n := binary.Uvarint(it.b)
+	if n < 1 || len(it.b[n:]) < int(l) {
+		it.err = errors.New("invalid sketch size")
+		return false
+	}
+	it.b = it.b[n:]
+	s, err := decodeSketch(it.b[:l])
+	if err != nil {
+		it.err = errors.Wrap(err, "decode sketch")
+		return false
+	}
+	it.b = it.b[l:]
+	it.t, it.s = t, s
+	return true
+}
+
+// At returns the timestamp of the current window and its sketch.
+func (it *SketchIterator) At() (int64, *Sketch) {
+	return it.t, it.s
+}
+
+func (it *SketchIterator) Err() error {
+	return it.err
+}
+
+type sketchSamplesIterator struct {
+	sketches *SketchIterator
+	started  bool
+	prevT    int64
+
+	qs   []float64
+	vals []float64
+	t0   int64
+	i    int
+}
+
+func (it *sketchSamplesIterator) Next() bool {
+	if it.i+1 < len(it.vals) {
+		it.i++
+		return true
+	}
+	for it.sketches.Next() {
+		t, s := it.sketches.At()
+
+		n := s.Count()
+		if n > maxSketchSamples {
+			n = maxSketchSamples
+		}
+		// Timestamps of samples must not go back to the previous window.
+		if it.started && uint64(t-it.prevT) < n {
+			n = uint64(t - it.prevT)
+		}
+		it.started, it.prevT = true, t
+		if n == 0 {
+			continue
+		}
+
+		it.qs = it.qs[:0]
+		for i := uint64(0); i < n; i++ {
+			q := 0.5
+			if n > 1 {
+				q = float64(i) / float64(n-1)
+			}
+			it.qs = append(it.qs, q)
+		}
+		it.vals = s.appendQuantiles(it.vals[:0], it.qs)
+		it.t0 = t - int64(n) + 1
+		it.i = 0
+		return true
+	}
+	it.vals = it.vals[:0]
+	return false
+}
+
+func (it *sketchSamplesIterator) At() (int64, float64) {
+	return it.t0 + int64(it.i), it.vals[it.i]
+}
+
+func (it *sketchSamplesIterator) Err() error {
+	return it.sketches.Err()
+}
@@ -0,0 +1,119 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package downsample
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestSketch_Quantile(t *testing.T) {
+	var s Sketch
+	testutil.Assert(t, math.IsNaN(s.Quantile(0.5)), "expected NaN for empty sketch")
+
+	r := rand.New(rand.NewSource(1))
+	var values []float64
+	for i := 0; i < 10000; i++ {
+		v := r.ExpFloat64() * 1000
+		if i%10 == 0 {
+			v = -v
+		}
+		if i%100 == 0 {
+			v = 0
+		}
+		values = append(values, v)
+		s.Add(v)
+	}
+	// NaN and infinite values are ignored.
+	s.Add(math.NaN())
+	s.Add(math.Inf(1))
+
+	sort.Float64s(values)
+	testutil.Equals(t, uint64(len(values)), s.Count())
+	for _, q := range []float64{0, 0.01, 0.05, 0.1, 0.5, 0.9, 0.99, 1} {
+		exp := values[int(q*float64(len(values)-1))]
+		got := s.Quantile(q)
+		testutil.Assert(t, math.Abs(got-exp) <= sketchRelativeAccuracy*math.Abs(exp), "quantile %v: expected %v, got %v", q, exp, got)
+	}
+}
+
+func TestSketch_MergeEncode(t *testing.T) {
+	var a, b, all Sketch
+	for i := 1; i <= 100; i++ {
+		v := float64(i)
+		if i%3 == 0 {
+			v = -v
+		}
+		all.Add(v)
+		if i <= 50 {
+			a.Add(v)
+		} else {
+			b.Add(v)
+		}
+	}
+	a.Add(0)
+	all.Add(0)
+
+	var merged Sketch
+	merged.Merge(&a)
+	merged.Merge(&b)
+	testutil.Equals(t, all, merged)
+
+	dec, err := decodeSketch(merged.appendEncoded(nil))
+	testutil.Ok(t, err)
+	testutil.Equals(t, all, *dec)
+
+	merged.Reset()
+	testutil.Equals(t, uint64(0), merged.Count())
+	dec, err = decodeSketch(merged.appendEncoded(nil))
+	testutil.Ok(t, err)
+	testutil.Equals(t, uint64(0), dec.Count())
+
+	_, err = decodeSketch(append(all.appendEncoded(nil), 1))
+	testutil.NotOk(t, err)
+}
+
+func TestSketchChunk(t *testing.T) {
+	c := NewSketchChunk()
+	testutil.Equals(t, 0, c.NumSamples())
+
+	var s1, s2 Sketch
+	for i := 1; i <= 3; i++ {
+		s1.Add(float64(i))
+	}
+	for i := 0; i < 1000; i++ {
+		s2.Add(10)
+	}
+	c.AppendSketch(299, &s1)
+	c.AppendSketch(599, &s2)
+	testutil.Equals(t, 2, c.NumSamples())
+
+	it := c.Sketches()
+	testutil.Assert(t, it.Next(), "expected first sketch")
+	ts, s := it.At()
+	testutil.Equals(t, int64(299), ts)
+	testutil.Equals(t, s1, *s)
+	testutil.Assert(t, it.Next(), "expected second sketch")
+	ts, s = it.At()
+	testutil.Equals(t, int64(599), ts)
+	testutil.Equals(t, s2, *s)
+	testutil.Assert(t, !it.Next(), "expected no more sketches")
+	testutil.Ok(t, it.Err())
+
+	// Sketches are expanded into up to maxSketchSamples samples ending at the window timestamp.
+	var got []sample
+	testutil.Ok(t, expandChunkIterator(c.Iterator(nil), &got))
+	testutil.Equals(t, 3+maxSketchSamples, len(got))
+	testutil.Equals(t, sample{t: 297, v: sketchValue(sketchIndex(1))}, got[0])
+	testutil.Equals(t, sample{t: 298, v: sketchValue(sketchIndex(2))}, got[1])
+	testutil.Equals(t, sample{t: 299, v: sketchValue(sketchIndex(3))}, got[2])
+	for i, smpl := range got[3:] {
+		testutil.Equals(t, int64(599-maxSketchSamples+1+i), smpl.t)
+		testutil.Equals(t, sketchValue(sketchIndex(10)), smpl.v)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// JournalFilename is the name of the file recording the progress of a group compaction in its work directory.
+const JournalFilename = "journal.json"
+
+const journalVersion1 = 1
+
+// JournalStage is the stage of a group compaction.
+type JournalStage string
+
+const (
+	// JournalStageDownload means the input blocks are being downloaded and verified.
+	JournalStageDownload JournalStage = "download"
+	// JournalStageUpload means the output blocks were written and verified, but may not be uploaded yet.
+	JournalStageUpload JournalStage = "upload"
+)
+
+// Journal records the progress of a group compaction, so it can be resumed after the compactor was restarted.
+type Journal struct {
+	Version int          `json:"version"`
+	Stage   JournalStage `json:"stage"`
+	// Inputs are the blocks planned to be compacted.
+	Inputs []ulid.ULID `json:"inputs"`
+	// Verified are the input blocks which were downloaded, verified and had pending deletion requests applied.
+	Verified []ulid.ULID `json:"verified"`
+	// Outputs are the compacted blocks, more than one if the compaction was split into shards of series.
+	// They are set in the upload stage only.
+	Outputs []ulid.ULID `json:"outputs"`
+}
+
+func newJournal(inputs []ulid.ULID) *Journal {
+	return &Journal{Version: journalVersion1, Stage: JournalStageDownload, Inputs: inputs}
+}
+
+func (j *Journal) isVerified(id ulid.ULID) bool {
+	for _, v := range j.Verified {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *Journal) hasInputs(inputs []ulid.ULID) bool {
+	if len(j.Inputs) != len(inputs) {
+		return false
+	}
+	for i := range inputs {
+		if j.Inputs[i] != inputs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// readJournal reads the journal from the given work directory. Nil is returned if there is none.
+func readJournal(dir string) (*Journal, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, JournalFilename))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read journal")
+	}
+	j := &Journal{}
+	if err := json.Unmarshal(b, j); err != nil {
+		return nil, errors.Wrap(err, "unmarshal journal")
+	}
+	if j.Version != journalVersion1 {
+		return nil, errors.Errorf("unexpected journal version %d", j.Version)
+	}
+	return j, nil
+}
+
+// writeJournal atomically writes the journal to the given work directory.
+func writeJournal(logger log.Logger, dir string, j *Journal) error {
+	path := filepath.Join(dir, JournalFilename)
+	tmp := path + ".tmp"
+
+	f, err := os.Create(tmp)
+	if err != nil {
+		return errors.Wrap(err, "create journal")
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(j); err != nil {
+		runutil.CloseWithLogOnErr(logger, f, "close journal")
+		return errors.Wrap(err, "encode journal")
+	}
+	if err := f.Sync(); err != nil {
+		runutil.CloseWithLogOnErr(logger, f, "close journal")
+		return errors.Wrap(err, "sync journal")
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrap(err, "close journal")
+	}
+	return errors.Wrap(os.Rename(tmp, path), "rename journal")
+}
@@ -0,0 +1,156 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/indexheader"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/testutil"
+	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
+)
+
+func TestGroup_Compact_Resume(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	extLset := labels.Labels{{Name: "e1", Value: "1"}}
+	series := []labels.Labels{labels.FromStrings("a", "1")}
+
+	comp, err := tsdb.NewLeveledCompactor(ctx, nil, logger, []int64{1000, 3000}, nil)
+	testutil.Ok(t, err)
+
+	// setup uploads four consecutive blocks, the first three of which are planned to be compacted.
+	setup := func(t *testing.T) (objstore.Bucket, *Group, []ulid.ULID, string) {
+		bkt := objstore.NewInMemBucket()
+		metas := createAndUpload(t, bkt, []blockgenSpec{
+			{numSamples: 100, mint: 0, maxt: 1000, extLset: extLset, series: series},
+			{numSamples: 100, mint: 1000, maxt: 2000, extLset: extLset, series: series},
+			{numSamples: 100, mint: 2000, maxt: 3000, extLset: extLset, series: series},
+			{numSamples: 100, mint: 3000, maxt: 4000, extLset: extLset, series: series},
+		})
+
+		ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(logger, objstore.WithNoopInstr(bkt), 48*time.Hour)
+		duplicateBlocksFilter := block.NewDeduplicateFilter()
+		metaFetcher, err := block.NewMetaFetcher(nil, 32, objstore.WithNoopInstr(bkt), "", nil, []block.MetadataFilter{
+			ignoreDeletionMarkFilter,
+			duplicateBlocksFilter,
+		}, nil)
+		testutil.Ok(t, err)
+
+		sy, err := NewSyncer(nil, nil, bkt, metaFetcher, duplicateBlocksFilter, ignoreDeletionMarkFilter, nil, prometheus.NewCounter(prometheus.CounterOpts{}), 1, false, false)
+		testutil.Ok(t, err)
+		testutil.Ok(t, sy.SyncMetas(ctx))
+		groups, err := sy.Groups()
+		testutil.Ok(t, err)
+		testutil.Equals(t, 1, len(groups))
+
+		dir, err := ioutil.TempDir("", "test-compact-resume")
+		testutil.Ok(t, err)
+		return bkt, groups[0], []ulid.ULID{metas[0].ULID, metas[1].ULID, metas[2].ULID}, dir
+	}
+
+	t.Run("downloaded blocks are reused", func(t *testing.T) {
+		bkt, g, inputs, dir := setup(t)
+		defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+		gdir := filepath.Join(dir, g.Key())
+		for _, id := range inputs {
+			testutil.Ok(t, block.Download(ctx, logger, bkt, id, filepath.Join(gdir, id.String())))
+			// Compaction fails if the block is downloaded again.
+			testutil.Ok(t, bkt.Delete(ctx, path.Join(id.String(), block.IndexFilename)))
+		}
+		// Leftovers of the interrupted compaction are removed.
+		testutil.Ok(t, os.MkdirAll(filepath.Join(gdir, ulid.MustNew(1, nil).String()), 0777))
+
+		j := newJournal(inputs)
+		j.Verified = inputs
+		testutil.Ok(t, writeJournal(logger, gdir, j))
+
+		ok, compIDs, err := g.Compact(ctx, dir, comp, SplitConfig{})
+		testutil.Ok(t, err)
+		testutil.Assert(t, ok, "expected rerun after compaction")
+		testutil.Equals(t, 1, len(compIDs))
+
+		meta, err := block.DownloadMeta(ctx, logger, bkt, compIDs[0])
+		testutil.Ok(t, err)
+		testutil.Equals(t, inputs, meta.Compaction.Sources)
+		testutil.Equals(t, int64(0), meta.MinTime)
+		testutil.Equals(t, int64(3000), meta.MaxTime)
+
+		_, err = os.Stat(gdir)
+		testutil.Assert(t, os.IsNotExist(err), "expected removed group work dir")
+	})
+
+	t.Run("written output block is uploaded", func(t *testing.T) {
+		bkt, g, inputs, dir := setup(t)
+		defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+		gdir := filepath.Join(dir, g.Key())
+		testutil.Ok(t, os.MkdirAll(gdir, 0777))
+		outID, err := e2eutil.CreateBlock(ctx, gdir, series, 300, 0, 3000, extLset, 0)
+		testutil.Ok(t, err)
+		bdir := filepath.Join(gdir, outID.String())
+		testutil.Ok(t, indexheader.WriteJSON(logger, filepath.Join(bdir, block.IndexFilename), filepath.Join(bdir, block.IndexCacheFilename)))
+
+		j := newJournal(inputs)
+		j.Verified = inputs
+		j.Stage, j.Outputs = JournalStageUpload, []ulid.ULID{outID}
+		testutil.Ok(t, writeJournal(logger, gdir, j))
+
+		ok, compIDs, err := g.Compact(ctx, dir, comp, SplitConfig{})
+		testutil.Ok(t, err)
+		testutil.Assert(t, ok, "expected rerun after compaction")
+		testutil.Equals(t, []ulid.ULID{outID}, compIDs)
+
+		exists, err := bkt.Exists(ctx, path.Join(outID.String(), block.MetaFilename))
+		testutil.Ok(t, err)
+		testutil.Assert(t, exists, "expected uploaded output block")
+
+		for _, id := range inputs {
+			marked, err := bkt.Exists(ctx, path.Join(id.String(), metadata.DeletionMarkFilename))
+			testutil.Ok(t, err)
+			testutil.Assert(t, marked, "expected input block %s marked for deletion", id)
+		}
+	})
+}
+
+func TestJournal_ReadWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "test-journal")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	j, err := readJournal(dir)
+	testutil.Ok(t, err)
+	testutil.Assert(t, j == nil, "expected no journal")
+
+	inputs := []ulid.ULID{ulid.MustNew(1, nil), ulid.MustNew(2, nil)}
+	exp := newJournal(inputs)
+	exp.Verified = inputs[:1]
+	testutil.Ok(t, writeJournal(log.NewNopLogger(), dir, exp))
+
+	j, err = readJournal(dir)
+	testutil.Ok(t, err)
+	testutil.Equals(t, exp, j)
+	testutil.Assert(t, j.hasInputs(inputs), "expected matching inputs")
+	testutil.Assert(t, !j.hasInputs(inputs[1:]), "expected not matching inputs")
+	testutil.Assert(t, j.isVerified(inputs[0]) && !j.isVerified(inputs[1]), "unexpected verified blocks")
+
+	testutil.Ok(t, ioutil.WriteFile(filepath.Join(dir, JournalFilename), []byte(`{"version": 2}`), 0666))
+	_, err = readJournal(dir)
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,89 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// repairable returns true if the downloaded block has out of order or duplicated chunks, which the group
+// is configured to repair.
+func (cg *Group) repairable(meta *metadata.Meta, stats block.Stats) bool {
+	if cg.repairBkt == nil || meta.Thanos.Downsample.Resolution != 0 {
+		return false
+	}
+	return stats.OutOfOrderChunks+stats.DuplicatedChunks > 0
+}
+
+// repairChunks backs up the block downloaded into pdir to the backup bucket and replaces it with a block of the same
+// ID, in which chunks of each series are sorted and overlapping chunks are merged. The repair is recorded in meta.json,
+// which is returned. The block in the bucket is left as is, it is deleted once the repaired block is compacted.
+func (cg *Group) repairChunks(ctx context.Context, pdir string, meta *metadata.Meta, stats block.Stats) (*metadata.Meta, error) {
+	id := meta.ULID
+	level.Warn(cg.logger).Log("msg", "repairing block with out of order or duplicated chunks", "block", id,
+		"outOfOrderChunks", stats.OutOfOrderChunks, "duplicatedChunks", stats.DuplicatedChunks)
+
+	// The block might have been backed up already by a repair, which was interrupted.
+	backedUp, err := cg.repairBkt.Exists(ctx, path.Join(id.String(), block.MetaFilename))
+	if err != nil {
+		return nil, retry(errors.Wrapf(err, "check backup of block %s", id))
+	}
+	if !backedUp {
+		if err := block.Upload(ctx, cg.logger, cg.repairBkt, pdir); err != nil {
+			return nil, retry(errors.Wrapf(err, "back up block %s", id))
+		}
+	}
+
+	resid, err := block.RepairOverlappingChunks(cg.logger, filepath.Dir(pdir), id, metadata.CompactorRepairSource)
+	if err != nil {
+		return nil, errors.Wrapf(err, "repair chunks of block %s", id)
+	}
+	resdir := filepath.Join(filepath.Dir(pdir), resid.String())
+
+	newMeta, err := metadata.Read(resdir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read meta of repaired block %s", resdir)
+	}
+	newMeta.ULID = id
+	newMeta.Thanos.Repairs = append(newMeta.Thanos.Repairs, metadata.ThanosRepair{
+		Block:            id,
+		Time:             time.Now().Unix(),
+		OutOfOrderChunks: stats.OutOfOrderChunks,
+		DuplicatedChunks: stats.DuplicatedChunks,
+	})
+	if err := metadata.Write(cg.logger, resdir, newMeta); err != nil {
+		return nil, errors.Wrapf(err, "write meta of repaired block %s", resdir)
+	}
+
+	if err := os.RemoveAll(pdir); err != nil {
+		return nil, errors.Wrapf(err, "remove original block %s", pdir)
+	}
+	if err := os.Rename(resdir, pdir); err != nil {
+		return nil, errors.Wrapf(err, "move repaired block %s", resdir)
+	}
+	cg.repairedBlocks.Inc()
+	return newMeta, nil
+}
+
+// planRepairs returns repairs recorded in meta.json of the given planned blocks.
+func planRepairs(plan []string) ([]metadata.ThanosRepair, error) {
+	var repairs []metadata.ThanosRepair
+	for _, pdir := range plan {
+		meta, err := metadata.Read(pdir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read meta from %s", pdir)
+		}
+		repairs = append(repairs, meta.Thanos.Repairs...)
+	}
+	return repairs, nil
+}
@@ -0,0 +1,160 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+// createBlockWithOverlappingChunks uploads a block with a single series, which has an out of order chunk overlapping
+// another one and a duplicated chunk.
+func createBlockWithOverlappingChunks(t *testing.T, bkt objstore.Bucket, mint, maxt int64, extLset labels.Labels) *metadata.Meta {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "test-overlapping-chunks")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	xorChunk := func(mint, maxt int64) chunks.Meta {
+		c := chunkenc.NewXORChunk()
+		app, err := c.Appender()
+		testutil.Ok(t, err)
+		for ts := mint; ts <= maxt; ts += 10 {
+			app.Append(ts, float64(ts))
+		}
+		return chunks.Meta{MinTime: mint, MaxTime: maxt, Chunk: c}
+	}
+	d := (maxt - mint) / 4
+	chks := []chunks.Meta{
+		xorChunk(mint, mint+2*d-10),
+		xorChunk(mint+3*d, maxt-10),
+		xorChunk(mint+3*d, maxt-10),
+		xorChunk(mint+d, mint+3*d-10),
+	}
+
+	id := ulid.MustNew(uint64(time.Now().UnixNano()/int64(time.Millisecond)), nil)
+	bdir := filepath.Join(dir, id.String())
+	cw, err := chunks.NewWriter(filepath.Join(bdir, block.ChunksDirname))
+	testutil.Ok(t, err)
+	testutil.Ok(t, cw.WriteChunks(chks...))
+	testutil.Ok(t, cw.Close())
+
+	lset := labels.FromStrings("a", "1")
+	iw, err := index.NewWriter(ctx, filepath.Join(bdir, block.IndexFilename))
+	testutil.Ok(t, err)
+	for _, s := range []string{"1", "a"} {
+		testutil.Ok(t, iw.AddSymbol(s))
+	}
+	testutil.Ok(t, iw.AddSeries(0, lset, chks...))
+	testutil.Ok(t, iw.Close())
+
+	meta := &metadata.Meta{
+		BlockMeta: tsdb.BlockMeta{
+			ULID:    id,
+			MinTime: mint,
+			MaxTime: maxt,
+			Version: 1,
+			Stats:   tsdb.BlockStats{NumSeries: 1, NumChunks: uint64(len(chks))},
+			Compaction: tsdb.BlockMetaCompaction{
+				Level:   1,
+				Sources: []ulid.ULID{id},
+			},
+		},
+		Thanos: metadata.Thanos{Labels: extLset.Map(), Source: metadata.TestSource},
+	}
+	testutil.Ok(t, metadata.Write(log.NewNopLogger(), bdir, meta))
+	testutil.Ok(t, block.Upload(ctx, log.NewNopLogger(), bkt, bdir))
+	return meta
+}
+
+func TestGroup_Compact_RepairChunks(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	extLset := labels.Labels{{Name: "e1", Value: "1"}}
+	series := []labels.Labels{labels.FromStrings("a", "1")}
+
+	bkt := objstore.NewInMemBucket()
+	backupBkt := objstore.NewInMemBucket()
+	broken := createBlockWithOverlappingChunks(t, bkt, 0, 1000, extLset)
+	metas := createAndUpload(t, bkt, []blockgenSpec{
+		{numSamples: 100, mint: 1000, maxt: 2000, extLset: extLset, series: series},
+		{numSamples: 100, mint: 2000, maxt: 3000, extLset: extLset, series: series},
+		{numSamples: 100, mint: 3000, maxt: 4000, extLset: extLset, series: series},
+	})
+
+	duplicateBlocksFilter := block.NewDeduplicateFilter()
+	metaFetcher, err := block.NewMetaFetcher(nil, 32, objstore.WithNoopInstr(bkt), "", nil, []block.MetadataFilter{
+		duplicateBlocksFilter,
+	}, nil)
+	testutil.Ok(t, err)
+
+	sy, err := NewSyncer(nil, nil, bkt, metaFetcher, duplicateBlocksFilter, nil, nil, prometheus.NewCounter(prometheus.CounterOpts{}), 1, false, false)
+	testutil.Ok(t, err)
+	testutil.Ok(t, sy.SyncMetas(ctx))
+
+	comp, err := tsdb.NewLeveledCompactor(ctx, nil, logger, []int64{1000, 3000}, nil)
+	testutil.Ok(t, err)
+
+	dir, err := ioutil.TempDir("", "test-compact-repair")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	// Without repair the broken block halts the compaction.
+	groups, err := sy.Groups()
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(groups))
+	_, _, err = groups[0].Compact(ctx, dir, comp, SplitConfig{})
+	testutil.NotOk(t, err)
+	testutil.Assert(t, IsHaltError(err), "expected halt error, got %v", err)
+
+	sy.EnableChunksRepair(backupBkt)
+	groups, err = sy.Groups()
+	testutil.Ok(t, err)
+	ok, compIDs, err := groups[0].Compact(ctx, dir, comp, SplitConfig{})
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok, "expected rerun after compaction")
+	testutil.Equals(t, 1, len(compIDs))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(groups[0].repairedBlocks))
+
+	// The original block is backed up.
+	exists, err := backupBkt.Exists(ctx, path.Join(broken.ULID.String(), block.IndexFilename))
+	testutil.Ok(t, err)
+	testutil.Assert(t, exists, "expected original block in backup bucket")
+
+	bdir := filepath.Join(dir, compIDs[0].String())
+	testutil.Ok(t, block.Download(ctx, logger, bkt, compIDs[0], bdir))
+	meta, err := metadata.Read(bdir)
+	testutil.Ok(t, err)
+	testutil.Equals(t, []ulid.ULID{broken.ULID, metas[0].ULID, metas[1].ULID}, meta.Compaction.Sources)
+	testutil.Equals(t, 1, len(meta.Thanos.Repairs))
+	testutil.Equals(t, broken.ULID, meta.Thanos.Repairs[0].Block)
+	testutil.Equals(t, 1, meta.Thanos.Repairs[0].OutOfOrderChunks)
+	testutil.Equals(t, 1, meta.Thanos.Repairs[0].DuplicatedChunks)
+
+	// The broken chunks are merged, so the compacted block is healthy and holds every sample of them once.
+	stats, err := block.GatherIndexIssueStats(logger, filepath.Join(bdir, block.IndexFilename), meta.MinTime, meta.MaxTime)
+	testutil.Ok(t, err)
+	testutil.Ok(t, stats.AnyErr())
+	testutil.Equals(t, 0, stats.DuplicatedChunks)
+	testutil.Equals(t, uint64(100+metas[0].Stats.NumSamples+metas[1].Stats.NumSamples), meta.Stats.NumSamples)
+}
@@ -5,6 +5,7 @@ package compact
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -12,9 +13,14 @@ import (
 	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	"github.com/thanos-io/thanos/pkg/objstore"
+	"gopkg.in/yaml.v2"
 )
 
 // ApplyRetentionPolicyByResolution removes blocks depending on the specified retentionByResolution based on blocks MaxTime.
@@ -45,3 +51,221 @@ func ApplyRetentionPolicyByResolution(
 	level.Info(logger).Log("msg", "optional retention apply done")
 	return nil
 }
+
+// DefaultRetentionRuleName is the name of the rule applied to blocks not matching any configured retention rule.
+const DefaultRetentionRuleName = "default"
+
+// RetentionConfig holds retention rules evaluated against external labels of blocks.
+type RetentionConfig struct {
+	Rules []RetentionRule `yaml:"rules"`
+}
+
+// RetentionRule sets the retention per resolution of blocks with external labels matching its matchers.
+// A retention of 0 keeps the blocks of the resolution forever.
+type RetentionRule struct {
+	Name string `yaml:"name"`
+	// Matchers is a series selector, e.g {tenant="team-a", env=~"prod|staging"}, matched against block external labels.
+	// Empty matchers match all blocks.
+	Matchers      string         `yaml:"matchers"`
+	ResolutionRaw model.Duration `yaml:"resolution_raw"`
+	Resolution5m  model.Duration `yaml:"resolution_5m"`
+	Resolution1h  model.Duration `yaml:"resolution_1h"`
+	// Resolutions sets the retention per resolution of any downsampling level, e.g. {1m: 90d, 10m: 1y}, overriding
+	// the fields above.
+	Resolutions map[model.Duration]model.Duration `yaml:"resolutions"`
+
+	matchers []*labels.Matcher
+}
+
+// ParseRetentionConfig parses and validates retention rules from YAML.
+func ParseRetentionConfig(confYaml []byte) (*RetentionConfig, error) {
+	conf := &RetentionConfig{}
+	if err := yaml.UnmarshalStrict(confYaml, conf); err != nil {
+		return nil, errors.Wrap(err, "parsing retention config YAML")
+	}
+
+	names := map[string]struct{}{}
+	for i := range conf.Rules {
+		r := &conf.Rules[i]
+		if r.Name == "" {
+			return nil, errors.Errorf("retention rule %d: name is required", i)
+		}
+		if r.Name == DefaultRetentionRuleName {
+			return nil, errors.Errorf("retention rule %d: name %q is reserved for blocks not matching any rule", i, DefaultRetentionRuleName)
+		}
+		if _, ok := names[r.Name]; ok {
+			return nil, errors.Errorf("retention rule %d: duplicated name %q", i, r.Name)
+		}
+		names[r.Name] = struct{}{}
+
+		if r.Matchers == "" {
+			continue
+		}
+		ms, err := promql.ParseMetricSelector(r.Matchers)
+		if err != nil {
+			return nil, errors.Wrapf(err, "retention rule %q: parse matchers", r.Name)
+		}
+		r.matchers = ms
+	}
+	return conf, nil
+}
+
+func (r RetentionRule) matches(lset labels.Labels) bool {
+	for _, m := range r.matchers {
+		if !m.Matches(lset.Get(m.Name)) {
+			return false
+		}
+	}
+	return true
+}
+
+// RetentionByResolution returns the retention of the rule per resolution.
+func (r RetentionRule) RetentionByResolution() map[ResolutionLevel]time.Duration {
+	res := map[ResolutionLevel]time.Duration{
+		ResolutionLevelRaw: time.Duration(r.ResolutionRaw),
+		ResolutionLevel5m:  time.Duration(r.Resolution5m),
+		ResolutionLevel1h:  time.Duration(r.Resolution1h),
+	}
+	for resolution, retention := range r.Resolutions {
+		res[ResolutionLevel(time.Duration(resolution)/time.Millisecond)] = time.Duration(retention)
+	}
+	return res
+}
+
+// ParseRetentionByResolution parses retentions given in the <resolution>:<retention> format, e.g 1m:90d.
+func ParseRetentionByResolution(specs []string) (map[ResolutionLevel]time.Duration, error) {
+	res := make(map[ResolutionLevel]time.Duration, len(specs))
+	for _, s := range specs {
+		parts := strings.Split(s, ":")
+		if len(parts) != 2 {
+			return nil, errors.Errorf("retention %q: expected <resolution>:<retention> format", s)
+		}
+		resolution, err := model.ParseDuration(parts[0])
+		if err != nil {
+			return nil, errors.Wrapf(err, "retention %q: parse resolution", s)
+		}
+		retention, err := model.ParseDuration(parts[1])
+		if err != nil {
+			return nil, errors.Wrapf(err, "retention %q: parse retention", s)
+		}
+		level := ResolutionLevel(time.Duration(resolution) / time.Millisecond)
+		if _, ok := res[level]; ok {
+			return nil, errors.Errorf("retention %q: duplicated resolution", s)
+		}
+		res[level] = time.Duration(retention)
+	}
+	return res, nil
+}
+
+// RetentionPolicy marks blocks for deletion according to the first retention rule matching their external labels.
+// Blocks not matching any rule are subject to the default retention.
+type RetentionPolicy struct {
+	logger                  log.Logger
+	bkt                     objstore.Bucket
+	rules                   []RetentionRule
+	dryRun                  bool
+	blocksMarkedForDeletion prometheus.Counter
+	blocksMarkedByRule      *prometheus.CounterVec
+}
+
+// NewRetentionPolicy returns a retention policy evaluating the rules of the given config (which might be nil) in order.
+// If dryRun is true, blocks which would be marked for deletion are only logged.
+func NewRetentionPolicy(
+	logger log.Logger,
+	reg prometheus.Registerer,
+	bkt objstore.Bucket,
+	conf *RetentionConfig,
+	defaultRetentionByResolution map[ResolutionLevel]time.Duration,
+	dryRun bool,
+	blocksMarkedForDeletion prometheus.Counter,
+) *RetentionPolicy {
+	p := &RetentionPolicy{
+		logger:                  logger,
+		bkt:                     bkt,
+		dryRun:                  dryRun,
+		blocksMarkedForDeletion: blocksMarkedForDeletion,
+		blocksMarkedByRule: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_compactor_retention_blocks_marked_for_deletion_total",
+			Help: "Total number of blocks marked for deletion by retention, per retention rule.",
+		}, []string{"rule"}),
+	}
+	if conf != nil {
+		p.rules = append(p.rules, conf.Rules...)
+	}
+	defaultRule := RetentionRule{
+		Name:          DefaultRetentionRuleName,
+		ResolutionRaw: model.Duration(defaultRetentionByResolution[ResolutionLevelRaw]),
+		Resolution5m:  model.Duration(defaultRetentionByResolution[ResolutionLevel5m]),
+		Resolution1h:  model.Duration(defaultRetentionByResolution[ResolutionLevel1h]),
+		Resolutions:   map[model.Duration]model.Duration{},
+	}
+	for resolution, retention := range defaultRetentionByResolution {
+		defaultRule.Resolutions[model.Duration(time.Duration(resolution)*time.Millisecond)] = model.Duration(retention)
+	}
+	p.rules = append(p.rules, defaultRule)
+	for _, r := range p.rules {
+		p.blocksMarkedByRule.WithLabelValues(r.Name)
+	}
+	return p
+}
+
+// Rules returns the retention rules in the order of evaluation, including the default one.
+func (p *RetentionPolicy) Rules() []RetentionRule {
+	return p.rules
+}
+
+// Validate returns an error if any rule sets a retention for a resolution which is not one of the given ones, e.g.
+// of the configured downsampling levels, as blocks of such resolution do not exist.
+func (p *RetentionPolicy) Validate(resolutions []int64) error {
+	known := make(map[ResolutionLevel]struct{}, len(resolutions))
+	for _, r := range resolutions {
+		known[ResolutionLevel(r)] = struct{}{}
+	}
+	for _, r := range p.rules {
+		for resolution, retention := range r.RetentionByResolution() {
+			if _, ok := known[resolution]; !ok && retention > 0 {
+				return errors.Errorf("retention rule %q: resolution %s is not a configured downsampling level", r.Name, model.Duration(time.Duration(resolution)*time.Millisecond))
+			}
+		}
+	}
+	return nil
+}
+
+func (p *RetentionPolicy) ruleFor(lset labels.Labels) RetentionRule {
+	for _, r := range p.rules {
+		if r.matches(lset) {
+			return r
+		}
+	}
+	// Unreachable, the default rule matches all blocks.
+	return p.rules[len(p.rules)-1]
+}
+
+// Apply marks blocks for deletion if their MaxTime is older than the retention of the first matching rule
+// for their resolution.
+func (p *RetentionPolicy) Apply(ctx context.Context, metas map[ulid.ULID]*metadata.Meta) error {
+	level.Info(p.logger).Log("msg", "start optional retention", "rules", len(p.rules), "dryRun", p.dryRun)
+	for id, m := range metas {
+		rule := p.ruleFor(labels.FromMap(m.Thanos.Labels))
+		retentionDuration := rule.RetentionByResolution()[ResolutionLevel(m.Thanos.Downsample.Resolution)]
+		if retentionDuration.Seconds() == 0 {
+			continue
+		}
+
+		maxTime := time.Unix(m.MaxTime/1000, 0)
+		if !time.Now().After(maxTime.Add(retentionDuration)) {
+			continue
+		}
+		if p.dryRun {
+			level.Info(p.logger).Log("msg", "dry run: block would be marked for deletion by retention", "id", id, "rule", rule.Name, "resolution", m.Thanos.Downsample.Resolution, "maxTime", maxTime.String())
+			continue
+		}
+		level.Info(p.logger).Log("msg", "applying retention: marking block for deletion", "id", id, "rule", rule.Name, "maxTime", maxTime.String())
+		if err := block.MarkForDeletion(ctx, p.logger, p.bkt, id, p.blocksMarkedForDeletion); err != nil {
+			return errors.Wrap(err, "delete block")
+		}
+		p.blocksMarkedByRule.WithLabelValues(rule.Name).Inc()
+	}
+	level.Info(p.logger).Log("msg", "optional retention apply done")
+	return nil
+}
@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"path/filepath"
 	"strings"
 	"testing"
@@ -17,6 +18,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/tsdb"
 	"github.com/thanos-io/thanos/pkg/block"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
@@ -241,7 +243,7 @@ func TestApplyRetentionPolicyByResolution(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
 			for _, b := range tt.blocks {
-				uploadMockBlock(t, bkt, b.id, b.minTime, b.maxTime, int64(b.resolution))
+				uploadMockBlock(t, bkt, b.id, b.minTime, b.maxTime, int64(b.resolution), nil)
 			}
 
 			metaFetcher, err := block.NewMetaFetcher(logger, 32, bkt, "", nil, nil, nil)
@@ -259,6 +261,9 @@ func TestApplyRetentionPolicyByResolution(t *testing.T) {
 			got := []string{}
 			gotMarkedBlocksCount := 0.0
 			testutil.Ok(t, bkt.Iter(context.TODO(), "", func(name string) error {
+				if _, ok := block.IsBlockDir(name); !ok {
+					return nil
+				}
 				exists, err := bkt.Exists(ctx, filepath.Join(name, metadata.DeletionMarkFilename))
 				if err != nil {
 					return err
@@ -277,7 +282,194 @@ func TestApplyRetentionPolicyByResolution(t *testing.T) {
 	}
 }
 
-func uploadMockBlock(t *testing.T, bkt objstore.Bucket, id string, minTime, maxTime time.Time, resolutionLevel int64) {
+func TestParseRetentionConfig(t *testing.T) {
+	conf, err := compact.ParseRetentionConfig([]byte(`
+rules:
+  - name: long-term
+    matchers: '{tenant=~"a|b"}'
+    resolution_raw: 30d
+    resolution_1h: 395d
+  - name: all
+    resolution_raw: 14d
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, len(conf.Rules))
+	testutil.Equals(t, "long-term", conf.Rules[0].Name)
+	testutil.Equals(t, model.Duration(30*24*time.Hour), conf.Rules[0].ResolutionRaw)
+	testutil.Equals(t, model.Duration(0), conf.Rules[0].Resolution5m)
+	testutil.Equals(t, model.Duration(395*24*time.Hour), conf.Rules[0].Resolution1h)
+
+	for _, c := range []string{
+		"rules:\n  - matchers: '{tenant=\"a\"}'\n",
+		"rules:\n  - name: default\n",
+		"rules:\n  - name: a\n  - name: a\n",
+		"rules:\n  - name: a\n    matchers: '{tenant='\n",
+		"rules:\n  - name: a\n    unknown: 1\n",
+	} {
+		_, err := compact.ParseRetentionConfig([]byte(c))
+		testutil.NotOk(t, err, c)
+	}
+}
+
+func TestRetentionPolicy_Apply(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+
+	conf, err := compact.ParseRetentionConfig([]byte(`
+rules:
+  - name: long-term
+    matchers: '{tenant="a"}'
+    resolution_raw: 10d
+  - name: debug
+    matchers: '{cluster=~"debug-.*"}'
+    resolution_raw: 1d
+    resolution_5m: 1d
+`))
+	testutil.Ok(t, err)
+
+	upload := func(bkt objstore.Bucket) {
+		for _, b := range []struct {
+			id         string
+			maxTime    time.Time
+			resolution compact.ResolutionLevel
+			lset       map[string]string
+		}{
+			// Matches long-term rule only.
+			{"01CPHBEX20729MJQZXE3W0BW40", time.Now().Add(-5 * 24 * time.Hour), compact.ResolutionLevelRaw, map[string]string{"tenant": "a"}},
+			{"01CPHBEX20729MJQZXE3W0BW41", time.Now().Add(-11 * 24 * time.Hour), compact.ResolutionLevelRaw, map[string]string{"tenant": "a"}},
+			// First match wins, long-term rule is applied.
+			{"01CPHBEX20729MJQZXE3W0BW42", time.Now().Add(-5 * 24 * time.Hour), compact.ResolutionLevelRaw, map[string]string{"tenant": "a", "cluster": "debug-1"}},
+			{"01CPHBEX20729MJQZXE3W0BW43", time.Now().Add(-5 * 24 * time.Hour), compact.ResolutionLevel5m, map[string]string{"tenant": "b", "cluster": "debug-1"}},
+			// Unset resolution of the matching rule is kept forever, even though default retention would delete it.
+			{"01CPHBEX20729MJQZXE3W0BW44", time.Now().Add(-5 * 24 * time.Hour), compact.ResolutionLevel1h, map[string]string{"tenant": "b", "cluster": "debug-1"}},
+			// Default retention.
+			{"01CPHBEX20729MJQZXE3W0BW45", time.Now().Add(-3 * 24 * time.Hour), compact.ResolutionLevelRaw, map[string]string{"tenant": "b"}},
+			{"01CPHBEX20729MJQZXE3W0BW46", time.Now().Add(-1 * 24 * time.Hour), compact.ResolutionLevelRaw, map[string]string{"tenant": "b"}},
+		} {
+			uploadMockBlock(t, bkt, b.id, b.maxTime.Add(-2*time.Hour), b.maxTime, int64(b.resolution), b.lset)
+		}
+	}
+	defaultRetention := map[compact.ResolutionLevel]time.Duration{
+		compact.ResolutionLevelRaw: 2 * 24 * time.Hour,
+		compact.ResolutionLevel1h:  2 * 24 * time.Hour,
+	}
+
+	for _, dryRun := range []bool{false, true} {
+		t.Run(fmt.Sprintf("dryRun=%v", dryRun), func(t *testing.T) {
+			bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+			upload(bkt)
+
+			metaFetcher, err := block.NewMetaFetcher(logger, 32, bkt, "", nil, nil, nil)
+			testutil.Ok(t, err)
+			metas, _, err := metaFetcher.Fetch(ctx)
+			testutil.Ok(t, err)
+
+			reg := prometheus.NewRegistry()
+			blocksMarkedForDeletion := promauto.With(nil).NewCounter(prometheus.CounterOpts{})
+			p := compact.NewRetentionPolicy(logger, reg, bkt, conf, defaultRetention, dryRun, blocksMarkedForDeletion)
+			testutil.Equals(t, 3, len(p.Rules()))
+			testutil.Ok(t, p.Apply(ctx, metas))
+
+			var marked []string
+			testutil.Ok(t, bkt.Iter(ctx, "", func(name string) error {
+				exists, err := bkt.Exists(ctx, filepath.Join(name, metadata.DeletionMarkFilename))
+				if err != nil {
+					return err
+				}
+				if exists {
+					marked = append(marked, name)
+				}
+				return nil
+			}))
+
+			if dryRun {
+				testutil.Equals(t, 0, len(marked))
+				testutil.Equals(t, 0.0, promtest.ToFloat64(blocksMarkedForDeletion))
+				return
+			}
+			testutil.Equals(t, []string{
+				"01CPHBEX20729MJQZXE3W0BW41/",
+				"01CPHBEX20729MJQZXE3W0BW43/",
+				"01CPHBEX20729MJQZXE3W0BW45/",
+			}, marked)
+			testutil.Equals(t, 3.0, promtest.ToFloat64(blocksMarkedForDeletion))
+
+			mfs, err := reg.Gather()
+			testutil.Ok(t, err)
+			byRule := map[string]float64{}
+			for _, mf := range mfs {
+				for _, m := range mf.GetMetric() {
+					byRule[m.GetLabel()[0].GetValue()] = m.GetCounter().GetValue()
+				}
+			}
+			testutil.Equals(t, map[string]float64{"long-term": 1, "debug": 1, "default": 1}, byRule)
+		})
+	}
+}
+
+func TestRetentionPolicy_CustomResolutions(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+
+	conf, err := compact.ParseRetentionConfig([]byte(`
+rules:
+  - name: edge
+    matchers: '{cluster="edge"}'
+    resolution_raw: 1d
+    resolutions:
+      1m: 3d
+`))
+	testutil.Ok(t, err)
+	defaultRetention, err := compact.ParseRetentionByResolution([]string{"0s:10d", "1m:10d", "10m:2d"})
+	testutil.Ok(t, err)
+
+	bkt := objstore.WithNoopInstr(objstore.NewInMemBucket())
+	for _, b := range []struct {
+		id         string
+		maxTime    time.Time
+		resolution time.Duration
+		lset       map[string]string
+	}{
+		{"01CPHBEX20729MJQZXE3W0BW40", time.Now().Add(-2 * 24 * time.Hour), time.Minute, map[string]string{"cluster": "edge"}},
+		{"01CPHBEX20729MJQZXE3W0BW41", time.Now().Add(-4 * 24 * time.Hour), time.Minute, map[string]string{"cluster": "edge"}},
+		{"01CPHBEX20729MJQZXE3W0BW42", time.Now().Add(-4 * 24 * time.Hour), time.Minute, map[string]string{"cluster": "core"}},
+		{"01CPHBEX20729MJQZXE3W0BW43", time.Now().Add(-4 * 24 * time.Hour), 10 * time.Minute, map[string]string{"cluster": "core"}},
+		// Unset resolution of the matching rule is kept forever.
+		{"01CPHBEX20729MJQZXE3W0BW44", time.Now().Add(-4 * 24 * time.Hour), 10 * time.Minute, map[string]string{"cluster": "edge"}},
+	} {
+		uploadMockBlock(t, bkt, b.id, b.maxTime.Add(-2*time.Hour), b.maxTime, int64(b.resolution/time.Millisecond), b.lset)
+	}
+
+	metaFetcher, err := block.NewMetaFetcher(logger, 32, bkt, "", nil, nil, nil)
+	testutil.Ok(t, err)
+	metas, _, err := metaFetcher.Fetch(ctx)
+	testutil.Ok(t, err)
+
+	p := compact.NewRetentionPolicy(logger, prometheus.NewRegistry(), bkt, conf, defaultRetention, false, promauto.With(nil).NewCounter(prometheus.CounterOpts{}))
+	testutil.Ok(t, p.Validate([]int64{0, 60000, 600000}))
+	testutil.NotOk(t, p.Validate([]int64{0, 60000}))
+	testutil.Ok(t, p.Apply(ctx, metas))
+
+	var marked []string
+	testutil.Ok(t, bkt.Iter(ctx, "", func(name string) error {
+		exists, err := bkt.Exists(ctx, filepath.Join(name, metadata.DeletionMarkFilename))
+		if err != nil {
+			return err
+		}
+		if exists {
+			marked = append(marked, name)
+		}
+		return nil
+	}))
+	testutil.Equals(t, []string{"01CPHBEX20729MJQZXE3W0BW41/", "01CPHBEX20729MJQZXE3W0BW43/"}, marked)
+
+	for _, specs := range [][]string{{"1m"}, {"1x:1d"}, {"1m:1x"}, {"1m:1d", "1m:2d"}} {
+		_, err := compact.ParseRetentionByResolution(specs)
+		testutil.NotOk(t, err, specs)
+	}
+}
+
+func uploadMockBlock(t *testing.T, bkt objstore.Bucket, id string, minTime, maxTime time.Time, resolutionLevel int64, lset map[string]string) {
 	t.Helper()
 	meta1 := metadata.Meta{
 		BlockMeta: tsdb.BlockMeta{
@@ -287,6 +479,7 @@ func uploadMockBlock(t *testing.T, bkt objstore.Bucket, id string, minTime, maxT
 			Version: 1,
 		},
 		Thanos: metadata.Thanos{
+			Labels: lset,
 			Downsample: metadata.ThanosDownsample{
 				Resolution: resolutionLevel,
 			},
@@ -0,0 +1,168 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+	"github.com/thanos-io/thanos/pkg/runutil"
+)
+
+// SplitConfig configures splitting of compacted blocks, which would be too big, into blocks of the same time range
+// holding shards of the series. Zero limits disable splitting.
+type SplitConfig struct {
+	// MaxSeries is the maximum number of series of a compacted block, estimated as the highest number of series
+	// of its input blocks.
+	MaxSeries uint64
+	// MaxBytes is the maximum size of a compacted block, estimated as the total size of its input blocks.
+	MaxBytes uint64
+}
+
+// shards returns the number of shards to split the compaction of the downloaded blocks in plan into.
+func (c SplitConfig) shards(plan []string) (uint64, error) {
+	n := uint64(1)
+	if c.MaxSeries > 0 {
+		var series uint64
+		for _, pdir := range plan {
+			meta, err := metadata.Read(pdir)
+			if err != nil {
+				return 0, errors.Wrapf(err, "read meta from %s", pdir)
+			}
+			if meta.Stats.NumSeries > series {
+				series = meta.Stats.NumSeries
+			}
+		}
+		if s := (series + c.MaxSeries - 1) / c.MaxSeries; s > n {
+			n = s
+		}
+	}
+	if c.MaxBytes > 0 {
+		var size uint64
+		for _, pdir := range plan {
+			s, err := dirSize(pdir)
+			if err != nil {
+				return 0, errors.Wrapf(err, "get size of %s", pdir)
+			}
+			size += s
+		}
+		if s := (size + c.MaxBytes - 1) / c.MaxBytes; s > n {
+			n = s
+		}
+	}
+	return n, nil
+}
+
+func dirSize(dir string) (uint64, error) {
+	var size uint64
+	err := filepath.Walk(dir, func(_ string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			size += uint64(fi.Size())
+		}
+		return nil
+	})
+	return size, err
+}
+
+type seriesWriter interface {
+	WriteSeries(lset labels.Labels, chks []chunks.Meta) error
+	Close() error
+}
+
+// splitBlock writes series of the block in bdir into a block in dirs[i] for each of the given shards. The written
+// blocks keep the meta of the original block apart from the shard, so they are compacted in place of it.
+func splitBlock(logger log.Logger, bdir string, shards []*metadata.ThanosShard, dirs []string) (err error) {
+	meta, err := metadata.Read(bdir)
+	if err != nil {
+		return errors.Wrapf(err, "read meta from %s", bdir)
+	}
+
+	var pool chunkenc.Pool
+	if meta.Thanos.Downsample.Resolution == 0 {
+		pool = chunkenc.NewPool()
+	} else {
+		pool = downsample.NewPool()
+	}
+
+	b, err := tsdb.OpenBlock(logger, bdir, pool)
+	if err != nil {
+		return errors.Wrapf(err, "open block %s", bdir)
+	}
+	defer runutil.CloseWithErrCapture(&err, b, "split block reader")
+
+	indexr, err := b.Index()
+	if err != nil {
+		return errors.Wrap(err, "open index reader")
+	}
+	defer runutil.CloseWithErrCapture(&err, indexr, "split index reader")
+
+	chunkr, err := b.Chunks()
+	if err != nil {
+		return errors.Wrap(err, "open chunk reader")
+	}
+	defer runutil.CloseWithErrCapture(&err, chunkr, "split chunk reader")
+
+	writers := make([]seriesWriter, 0, len(shards))
+	for i, shard := range shards {
+		if err := os.MkdirAll(dirs[i], 0777); err != nil {
+			return errors.Wrap(err, "create split block dir")
+		}
+		m := *meta
+		m.Thanos.Shard = shard
+		w, err := downsample.NewStreamedBlockWriter(dirs[i], indexr, logger, m)
+		if err != nil {
+			return errors.Wrap(err, "create block writer")
+		}
+		defer runutil.CloseWithErrCapture(&err, w, "close block writer")
+		writers = append(writers, w)
+	}
+
+	postings, err := indexr.Postings(index.AllPostingsKey())
+	if err != nil {
+		return errors.Wrap(err, "get all postings list")
+	}
+
+	var (
+		lset labels.Labels
+		chks []chunks.Meta
+	)
+	for postings.Next() {
+		if err := indexr.Series(postings.At(), &lset, &chks); err != nil {
+			return errors.Wrapf(err, "get series %d", postings.At())
+		}
+		i := 0
+		for ; i < len(shards) && !shards[i].Contains(lset); i++ {
+		}
+		if i == len(shards) {
+			return errors.Errorf("series %s does not belong to any shard", lset)
+		}
+		for j := range chks {
+			chks[j].Chunk, err = chunkr.Chunk(chks[j].Ref)
+			if err != nil {
+				return errors.Wrapf(err, "get chunk %d, series %d", chks[j].Ref, postings.At())
+			}
+		}
+		if err := writers[i].WriteSeries(lset, chks); err != nil {
+			return errors.Wrapf(err, "write series %d", postings.At())
+		}
+	}
+	if postings.Err() != nil {
+		return errors.Wrap(postings.Err(), "iterate series set")
+	}
+	level.Debug(logger).Log("msg", "split block", "block", meta.ULID, "shards", len(shards))
+	return nil
+}
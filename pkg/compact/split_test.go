@@ -0,0 +1,120 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package compact
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestGroup_Compact_Split(t *testing.T) {
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	extLset := labels.Labels{{Name: "e1", Value: "1"}}
+
+	var series []labels.Labels
+	for i := 0; i < 10; i++ {
+		series = append(series, labels.FromStrings("a", fmt.Sprintf("%d", i)))
+	}
+
+	bkt := objstore.NewInMemBucket()
+	metas := createAndUpload(t, bkt, []blockgenSpec{
+		{numSamples: 100, mint: 0, maxt: 1000, extLset: extLset, series: series},
+		{numSamples: 100, mint: 1000, maxt: 2000, extLset: extLset, series: series},
+		{numSamples: 100, mint: 2000, maxt: 3000, extLset: extLset, series: series},
+		{numSamples: 100, mint: 3000, maxt: 4000, extLset: extLset, series: series},
+	})
+
+	ignoreDeletionMarkFilter := block.NewIgnoreDeletionMarkFilter(logger, objstore.WithNoopInstr(bkt), 48*time.Hour)
+	duplicateBlocksFilter := block.NewDeduplicateFilter()
+	metaFetcher, err := block.NewMetaFetcher(nil, 32, objstore.WithNoopInstr(bkt), "", nil, []block.MetadataFilter{
+		ignoreDeletionMarkFilter,
+		duplicateBlocksFilter,
+	}, nil)
+	testutil.Ok(t, err)
+
+	sy, err := NewSyncer(nil, nil, bkt, metaFetcher, duplicateBlocksFilter, ignoreDeletionMarkFilter, nil, prometheus.NewCounter(prometheus.CounterOpts{}), 1, false, false)
+	testutil.Ok(t, err)
+	testutil.Ok(t, sy.SyncMetas(ctx))
+	groups, err := sy.Groups()
+	testutil.Ok(t, err)
+	testutil.Equals(t, 1, len(groups))
+
+	comp, err := tsdb.NewLeveledCompactor(ctx, nil, logger, []int64{1000, 3000}, nil)
+	testutil.Ok(t, err)
+
+	dir, err := ioutil.TempDir("", "test-compact-split")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	ok, compIDs, err := groups[0].Compact(ctx, dir, comp, SplitConfig{MaxSeries: 5})
+	testutil.Ok(t, err)
+	testutil.Assert(t, ok, "expected rerun after compaction")
+	testutil.Equals(t, 2, len(compIDs))
+
+	var numSeries uint64
+	for i, id := range compIDs {
+		bdir := filepath.Join(dir, id.String())
+		testutil.Ok(t, block.Download(ctx, logger, bkt, id, bdir))
+
+		meta, err := metadata.Read(bdir)
+		testutil.Ok(t, err)
+		testutil.Equals(t, &metadata.ThanosShard{Index: uint64(i), Count: 2}, meta.Thanos.Shard)
+		testutil.Equals(t, int64(0), meta.MinTime)
+		testutil.Equals(t, int64(3000), meta.MaxTime)
+		testutil.Equals(t, []ulid.ULID{metas[0].ULID, metas[1].ULID, metas[2].ULID}, meta.Compaction.Sources)
+		numSeries += meta.Stats.NumSeries
+
+		// Each block holds only series of its shard.
+		b, err := tsdb.OpenBlock(logger, bdir, nil)
+		testutil.Ok(t, err)
+		indexr, err := b.Index()
+		testutil.Ok(t, err)
+		p, err := indexr.Postings(index.AllPostingsKey())
+		testutil.Ok(t, err)
+		var (
+			lset labels.Labels
+			chks []chunks.Meta
+		)
+		for p.Next() {
+			testutil.Ok(t, indexr.Series(p.At(), &lset, &chks))
+			testutil.Assert(t, meta.Thanos.Shard.Contains(lset), "series %s outside of shard %s", lset, meta.Thanos.Shard)
+		}
+		testutil.Ok(t, p.Err())
+		testutil.Ok(t, indexr.Close())
+		testutil.Ok(t, b.Close())
+	}
+	testutil.Equals(t, uint64(len(series)), numSeries)
+
+	// Input blocks covered by the shards are filtered out and each shard is compacted in its own group.
+	testutil.Ok(t, sy.SyncMetas(ctx))
+	groups, err = sy.Groups()
+	testutil.Ok(t, err)
+	var keys []string
+	for _, g := range groups {
+		keys = append(keys, g.Key())
+	}
+	testutil.Equals(t, []string{
+		groupKey(0, extLset, nil),
+		groupKey(0, extLset, &metadata.ThanosShard{Index: 0, Count: 2}),
+		groupKey(0, extLset, &metadata.ThanosShard{Index: 1, Count: 2}),
+	}, keys)
+}
@@ -0,0 +1,120 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+// Package dedup implements the penalty based deduplication of samples of replicated series, shared by the
+// Querier and the Compactor.
+package dedup
+
+import (
+	"math"
+
+	"github.com/prometheus/prometheus/storage"
+)
+
+type seriesIterator struct {
+	a, b storage.SeriesIterator
+
+	aok, bok   bool
+	lastT      int64
+	penA, penB int64
+	useA       bool
+}
+
+// NewSeriesIterator returns an iterator deduplicating samples of two replicas of the same series. It follows one
+// replica and only switches to the other one if a gap of more than twice the last sample interval is found.
+func NewSeriesIterator(a, b storage.SeriesIterator) storage.SeriesIterator {
+	return &seriesIterator{
+		a:     a,
+		b:     b,
+		lastT: math.MinInt64,
+		aok:   a.Next(),
+		bok:   b.Next(),
+	}
+}
+
+func (it *seriesIterator) Next() bool {
+	// Advance both iterators to at least the next highest timestamp plus the potential penalty.
+	if it.aok {
+		it.aok = it.a.Seek(it.lastT + 1 + it.penA)
+	}
+	if it.bok {
+		it.bok = it.b.Seek(it.lastT + 1 + it.penB)
+	}
+	// Handle basic cases where one iterator is exhausted before the other.
+	if !it.aok {
+		it.useA = false
+		if it.bok {
+			it.lastT, _ = it.b.At()
+			it.penB = 0
+		}
+		return it.bok
+	}
+	if !it.bok {
+		it.useA = true
+		it.lastT, _ = it.a.At()
+		it.penA = 0
+		return true
+	}
+	// General case where both iterators still have data. We pick the one
+	// with the smaller timestamp.
+	// The applied penalty potentially already skipped potential samples already
+	// that would have resulted in exaggerated sampling frequency.
+	ta, _ := it.a.At()
+	tb, _ := it.b.At()
+
+	it.useA = ta <= tb
+
+	// For the series we didn't pick, add a penalty twice as high as the delta of the last two
+	// samples to the next seek against it.
+	// This ensures that we don't pick a sample too close, which would increase the overall
+	// sample frequency. It also guards against clock drift and inaccuracies during
+	// timestamp assignment.
+	// If we don't know a delta yet, we pick 5000 as a constant, which is based on the knowledge
+	// that timestamps are in milliseconds and sampling frequencies typically multiple seconds long.
+	const initialPenality = 5000
+
+	if it.useA {
+		if it.lastT != math.MinInt64 {
+			it.penB = 2 * (ta - it.lastT)
+		} else {
+			it.penB = initialPenality
+		}
+		it.penA = 0
+		it.lastT = ta
+		return true
+	}
+	if it.lastT != math.MinInt64 {
+		it.penA = 2 * (tb - it.lastT)
+	} else {
+		it.penA = initialPenality
+	}
+	it.penB = 0
+	it.lastT = tb
+	return true
+}
+
+func (it *seriesIterator) Seek(t int64) bool {
+	for {
+		ts, _ := it.At()
+		if ts > 0 && ts >= t {
+			return true
+		}
+		if !it.Next() {
+			return false
+		}
+	}
+}
+
+func (it *seriesIterator) At() (int64, float64) {
+	if it.useA {
+		return it.a.At()
+	}
+	return it.b.At()
+}
+
+func (it *seriesIterator) Err() error {
+	if it.a.Err() != nil {
+		return it.a.Err()
+	}
+	return it.b.Err()
+}
@@ -42,6 +42,7 @@ import (
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
 	"github.com/thanos-io/thanos/pkg/query"
 	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/thanos-io/thanos/pkg/tracing"
 )
 
@@ -63,6 +64,9 @@ const (
 	ErrorInternal ErrorType = "internal"
 )
 
+// ErrorBadData is the error type of requests with invalid parameters, used by APIs of other components.
+const ErrorBadData = errorBadData
+
 var corsHeaders = map[string]string{
 	"Access-Control-Allow-Headers":  "Accept, Accept-Encoding, Authorization, Content-Type, Origin",
 	"Access-Control-Allow-Methods":  "GET, OPTIONS",
@@ -102,6 +106,7 @@ type API struct {
 	logger          log.Logger
 	queryableCreate query.QueryableCreator
 	queryEngine     *promql.Engine
+	proxy           storepb.StoreServer
 
 	enableAutodownsampling                 bool
 	enablePartialResponse                  bool
@@ -118,6 +123,7 @@ func NewAPI(
 	reg *prometheus.Registry,
 	qe *promql.Engine,
 	c query.QueryableCreator,
+	proxy storepb.StoreServer,
 	enableAutodownsampling bool,
 	enablePartialResponse bool,
 	replicaLabels []string,
@@ -127,6 +133,7 @@ func NewAPI(
 		logger:                                 logger,
 		queryEngine:                            qe,
 		queryableCreate:                        c,
+		proxy:                                  proxy,
 		enableAutodownsampling:                 enableAutodownsampling,
 		enablePartialResponse:                  enablePartialResponse,
 		replicaLabels:                          replicaLabels,
@@ -168,6 +175,8 @@ func (api *API) Register(r *route.Router, tracer opentracing.Tracer, logger log.
 
 	r.Get("/labels", instr("label_names", api.labelNames))
 	r.Post("/labels", instr("label_names", api.labelNames))
+
+	r.Get("/status/cardinality", instr("cardinality_stats", api.cardinalityStats))
 }
 
 type queryData struct {
@@ -626,3 +635,158 @@ func (api *API) labelNames(r *http.Request) (interface{}, []error, *ApiError) {
 
 	return names, warnings, nil
 }
+
+// cardinalityStat is a single entry of cardinality statistics, following the format of Prometheus TSDB status API.
+type cardinalityStat struct {
+	Name  string `json:"name"`
+	Value uint64 `json:"value"`
+}
+
+// cardinalityStatsResult holds estimated cardinality statistics of all sources.
+type cardinalityStatsResult struct {
+	SeriesCountByMetricName     []cardinalityStat `json:"seriesCountByMetricName"`
+	LabelValueCountByLabelName  []cardinalityStat `json:"labelValueCountByLabelName"`
+	SeriesCountByLabelValuePair []cardinalityStat `json:"seriesCountByLabelValuePair"`
+}
+
+// cardinalityStats returns the top entries of estimated cardinality statistics for the given selector and time range.
+// Statistics of sources with equal external labels (after removing replica labels if deduplication is enabled) are
+// merged by taking the maximum, while statistics of different sources are summed up.
+func (api *API) cardinalityStats(r *http.Request) (interface{}, []error, *ApiError) {
+	if err := r.ParseForm(); err != nil {
+		return nil, nil, &ApiError{ErrorInternal, errors.Wrap(err, "parse form")}
+	}
+
+	start := minTime
+	if t := r.FormValue("start"); t != "" {
+		var err error
+		start, err = parseTime(t)
+		if err != nil {
+			return nil, nil, &ApiError{errorBadData, err}
+		}
+	}
+
+	end := maxTime
+	if t := r.FormValue("end"); t != "" {
+		var err error
+		end, err = parseTime(t)
+		if err != nil {
+			return nil, nil, &ApiError{errorBadData, err}
+		}
+	}
+
+	var matchers []storepb.LabelMatcher
+	switch len(r.Form["match[]"]) {
+	case 0:
+	case 1:
+		ms, err := promql.ParseMetricSelector(r.Form["match[]"][0])
+		if err != nil {
+			return nil, nil, &ApiError{errorBadData, err}
+		}
+		for _, m := range ms {
+			sm := storepb.LabelMatcher{Name: m.Name, Value: m.Value}
+			switch m.Type {
+			case labels.MatchEqual:
+				sm.Type = storepb.LabelMatcher_EQ
+			case labels.MatchNotEqual:
+				sm.Type = storepb.LabelMatcher_NEQ
+			case labels.MatchRegexp:
+				sm.Type = storepb.LabelMatcher_RE
+			case labels.MatchNotRegexp:
+				sm.Type = storepb.LabelMatcher_NRE
+			}
+			matchers = append(matchers, sm)
+		}
+	default:
+		return nil, nil, &ApiError{errorBadData, errors.New("only one match[] parameter is supported")}
+	}
+
+	limit := 10
+	if l := r.FormValue("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			return nil, nil, &ApiError{errorBadData, errors.Errorf("cannot parse %q to a valid limit", l)}
+		}
+	}
+
+	enableDedup, apiErr := api.parseEnableDedupParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	replicaLabels, apiErr := api.parseReplicaLabelsParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	enablePartialResponse, apiErr := api.parsePartialResponseParam(r)
+	if apiErr != nil {
+		return nil, nil, apiErr
+	}
+
+	resp, err := api.proxy.CardinalityStats(r.Context(), &storepb.CardinalityStatsRequest{
+		MinTime:                 timestamp.FromTime(start),
+		MaxTime:                 timestamp.FromTime(end),
+		Matchers:                matchers,
+		Limit:                   int64(limit),
+		PartialResponseDisabled: !enablePartialResponse,
+	})
+	if err != nil {
+		return nil, nil, &ApiError{errorExec, err}
+	}
+
+	var warnings []error
+	for _, w := range resp.Warnings {
+		warnings = append(warnings, errors.New(w))
+	}
+
+	stats := resp.Stats
+	if enableDedup && len(replicaLabels) > 0 {
+		deduped := make([]storepb.CardinalityStats, 0, len(stats))
+		for _, s := range stats {
+			s.Labels = storepb.PromLabelsToLabels(
+				labels.NewBuilder(storepb.LabelsToPromLabels(s.Labels)).Del(replicaLabels...).Labels(),
+			)
+			deduped = append(deduped, s)
+		}
+		stats = storepb.MergeCardinalityStats(0, deduped...)
+	}
+
+	var (
+		metricNames = map[string]uint64{}
+		labelNames  = map[string]uint64{}
+		labelPairs  = map[string]uint64{}
+	)
+	for _, s := range stats {
+		for _, e := range s.SeriesCountByMetricName {
+			metricNames[e.Name] += e.Count
+		}
+		for _, e := range s.LabelValueCountByLabelName {
+			labelNames[e.Name] += e.Count
+		}
+		for _, e := range s.SeriesCountByLabelValuePair {
+			labelPairs[e.Name+"="+e.Value] += e.Count
+		}
+	}
+
+	return &cardinalityStatsResult{
+		SeriesCountByMetricName:     topCardinalityStats(metricNames, limit),
+		LabelValueCountByLabelName:  topCardinalityStats(labelNames, limit),
+		SeriesCountByLabelValuePair: topCardinalityStats(labelPairs, limit),
+	}, warnings, nil
+}
+
+func topCardinalityStats(m map[string]uint64, limit int) []cardinalityStat {
+	entries := make([]storepb.CardinalityStatsEntry, 0, len(m))
+	for name, n := range m {
+		entries = append(entries, storepb.CardinalityStatsEntry{Name: name, Count: n})
+	}
+	entries = storepb.TopCardinalityStatsEntries(entries, limit)
+
+	res := make([]cardinalityStat, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, cardinalityStat{Name: e.Name, Value: e.Count})
+	}
+	return res
+}
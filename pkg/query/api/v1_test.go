@@ -44,6 +44,7 @@ import (
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
 	"github.com/thanos-io/thanos/pkg/query"
 	"github.com/thanos-io/thanos/pkg/store"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/thanos-io/thanos/pkg/testutil"
 	"github.com/thanos-io/thanos/pkg/testutil/e2eutil"
 )
@@ -1152,3 +1153,104 @@ func TestParseDownsamplingParamMillis(t *testing.T) {
 
 	}
 }
+
+type cardinalityStoreServer struct {
+	storepb.StoreServer
+
+	resp    *storepb.CardinalityStatsResponse
+	lastReq *storepb.CardinalityStatsRequest
+}
+
+func (s *cardinalityStoreServer) CardinalityStats(_ context.Context, r *storepb.CardinalityStatsRequest) (*storepb.CardinalityStatsResponse, error) {
+	s.lastReq = r
+	return s.resp, nil
+}
+
+func TestCardinalityStats(t *testing.T) {
+	stats := func(replica string, up, foo uint64) storepb.CardinalityStats {
+		return storepb.CardinalityStats{
+			Labels: []storepb.Label{{Name: "region", Value: "eu"}, {Name: "replica", Value: replica}},
+			SeriesCountByMetricName: []storepb.CardinalityStatsEntry{
+				{Name: "up", Count: up},
+				{Name: "foo", Count: foo},
+			},
+			LabelValueCountByLabelName: []storepb.CardinalityStatsEntry{
+				{Name: "__name__", Count: 2},
+			},
+			SeriesCountByLabelValuePair: []storepb.CardinalityStatsEntry{
+				{Name: "__name__", Value: "up", Count: up},
+				{Name: "__name__", Value: "foo", Count: foo},
+			},
+		}
+	}
+	srv := &cardinalityStoreServer{resp: &storepb.CardinalityStatsResponse{
+		Stats: []storepb.CardinalityStats{
+			stats("a", 10, 3),
+			stats("b", 12, 1),
+			{
+				Labels:                      []storepb.Label{{Name: "region", Value: "us"}},
+				SeriesCountByMetricName:     []storepb.CardinalityStatsEntry{{Name: "foo", Count: 5}},
+				LabelValueCountByLabelName:  []storepb.CardinalityStatsEntry{{Name: "__name__", Count: 1}},
+				SeriesCountByLabelValuePair: []storepb.CardinalityStatsEntry{{Name: "__name__", Value: "foo", Count: 5}},
+			},
+		},
+		Warnings: []string{"store x does not support cardinality statistics"},
+	}}
+	api := &API{proxy: srv, replicaLabels: []string{"replica"}}
+
+	for _, tcase := range []struct {
+		name     string
+		params   url.Values
+		expected *cardinalityStatsResult
+		reqLimit int64
+		errType  ErrorType
+	}{
+		{
+			name:     "deduplicated",
+			params:   url.Values{"match[]": []string{`{__name__=~"up|foo"}`}},
+			reqLimit: 10,
+			expected: &cardinalityStatsResult{
+				SeriesCountByMetricName:     []cardinalityStat{{Name: "up", Value: 12}, {Name: "foo", Value: 8}},
+				LabelValueCountByLabelName:  []cardinalityStat{{Name: "__name__", Value: 3}},
+				SeriesCountByLabelValuePair: []cardinalityStat{{Name: "__name__=up", Value: 12}, {Name: "__name__=foo", Value: 8}},
+			},
+		},
+		{
+			name:     "without dedup and limit",
+			params:   url.Values{"dedup": []string{"false"}, "limit": []string{"1"}},
+			reqLimit: 1,
+			expected: &cardinalityStatsResult{
+				SeriesCountByMetricName:     []cardinalityStat{{Name: "up", Value: 22}},
+				LabelValueCountByLabelName:  []cardinalityStat{{Name: "__name__", Value: 5}},
+				SeriesCountByLabelValuePair: []cardinalityStat{{Name: "__name__=up", Value: 22}},
+			},
+		},
+		{
+			name:    "invalid limit",
+			params:  url.Values{"limit": []string{"-1"}},
+			errType: errorBadData,
+		},
+		{
+			name:    "multiple matchers",
+			params:  url.Values{"match[]": []string{"up", "foo"}},
+			errType: errorBadData,
+		},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			srv.lastReq = nil
+			r, err := http.NewRequest(http.MethodGet, "http://example.com?"+tcase.params.Encode(), nil)
+			testutil.Ok(t, err)
+
+			res, warnings, apiErr := api.cardinalityStats(r)
+			if tcase.errType != errorNone {
+				testutil.Assert(t, apiErr != nil, "expected error")
+				testutil.Equals(t, tcase.errType, apiErr.Typ)
+				return
+			}
+			testutil.Assert(t, apiErr == nil, "unexpected error %v", apiErr)
+			testutil.Equals(t, tcase.expected, res)
+			testutil.Equals(t, 1, len(warnings))
+			testutil.Equals(t, tcase.reqLimit, srv.lastReq.Limit)
+		})
+	}
+}
@@ -56,6 +56,12 @@ func (s *testStore) LabelValues(ctx context.Context, r *storepb.LabelValuesReque
 	return nil, status.Error(codes.Unimplemented, "not implemented")
 }
 
+func (s *testStore) CardinalityStats(ctx context.Context, r *storepb.CardinalityStatsRequest) (
+	*storepb.CardinalityStatsResponse, error,
+) {
+	return nil, status.Error(codes.Unimplemented, "not implemented")
+}
+
 type testStoreMeta struct {
 	extlsetFn func(addr string) []storepb.LabelSet
 	storeType component.StoreAPI
@@ -4,7 +4,6 @@
 package query
 
 import (
-	"math"
 	"sort"
 
 	"github.com/pkg/errors"
@@ -12,6 +11,7 @@ import (
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/thanos-io/thanos/pkg/compact/downsample"
+	"github.com/thanos-io/thanos/pkg/dedup"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 )
 
@@ -211,6 +211,16 @@ func (s *chunkSeries) Iterator() storage.SeriesIterator {
 				its = append(its, getFirstIterator(c.Counter, c.Raw))
 			}
 			sit = downsample.NewCounterSeriesIterator(its...)
+		case storepb.Aggr_LAST:
+			for _, c := range s.chunks {
+				its = append(its, getFirstIteratorOrAverage(c, c.Last, c.Raw))
+			}
+			sit = newChunkSeriesIterator(its)
+		case storepb.Aggr_SKETCH:
+			for _, c := range s.chunks {
+				its = append(its, getFirstIteratorOrAverage(c, c.Sketch, c.Raw))
+			}
+			sit = newChunkSeriesIterator(its)
 		default:
 			return errSeriesIterator{err: errors.Errorf("unexpected result aggregate type %v", s.aggrs)}
 		}
@@ -245,6 +255,9 @@ func getFirstIterator(cs ...*storepb.Chunk) chunkenc.Iterator {
 		if c == nil {
 			continue
 		}
+		if c.Type == storepb.Chunk_SKETCH {
+			return downsample.SketchChunk(c.Data).Iterator(nil)
+		}
 		chk, err := chunkenc.FromData(chunkEncoding(c.Type), c.Data)
 		if err != nil {
 			return errSeriesIterator{err}
@@ -254,6 +267,20 @@ func getFirstIterator(cs ...*storepb.Chunk) chunkenc.Iterator {
 	return errSeriesIterator{errors.New("no valid chunk found")}
 }
 
+// getFirstIteratorOrAverage is like getFirstIterator, but falls back to the average of the chunk's sum and count
+// aggregates, which stores respond with for chunks downsampled before the requested aggregate was introduced.
+func getFirstIteratorOrAverage(c storepb.AggrChunk, cs ...*storepb.Chunk) chunkenc.Iterator {
+	for _, x := range cs {
+		if x != nil {
+			return getFirstIterator(x)
+		}
+	}
+	if c.Sum != nil && c.Count != nil {
+		return downsample.NewAverageChunkIterator(getFirstIterator(c.Count), getFirstIterator(c.Sum))
+	}
+	return errSeriesIterator{errors.New("no valid chunk found")}
+}
+
 func chunkEncoding(e storepb.Chunk_Encoding) chunkenc.Encoding {
 	switch e {
 	case storepb.Chunk_XOR:
@@ -477,113 +504,7 @@ func (s *dedupSeries) Labels() labels.Labels {
 func (s *dedupSeries) Iterator() (it storage.SeriesIterator) {
 	it = s.replicas[0].Iterator()
 	for _, o := range s.replicas[1:] {
-		it = newDedupSeriesIterator(it, o.Iterator())
+		it = dedup.NewSeriesIterator(it, o.Iterator())
 	}
 	return it
 }
-
-type dedupSeriesIterator struct {
-	a, b storage.SeriesIterator
-
-	aok, bok   bool
-	lastT      int64
-	penA, penB int64
-	useA       bool
-}
-
-func newDedupSeriesIterator(a, b storage.SeriesIterator) *dedupSeriesIterator {
-	return &dedupSeriesIterator{
-		a:     a,
-		b:     b,
-		lastT: math.MinInt64,
-		aok:   a.Next(),
-		bok:   b.Next(),
-	}
-}
-
-func (it *dedupSeriesIterator) Next() bool {
-	// Advance both iterators to at least the next highest timestamp plus the potential penalty.
-	if it.aok {
-		it.aok = it.a.Seek(it.lastT + 1 + it.penA)
-	}
-	if it.bok {
-		it.bok = it.b.Seek(it.lastT + 1 + it.penB)
-	}
-	// Handle basic cases where one iterator is exhausted before the other.
-	if !it.aok {
-		it.useA = false
-		if it.bok {
-			it.lastT, _ = it.b.At()
-			it.penB = 0
-		}
-		return it.bok
-	}
-	if !it.bok {
-		it.useA = true
-		it.lastT, _ = it.a.At()
-		it.penA = 0
-		return true
-	}
-	// General case where both iterators still have data. We pick the one
-	// with the smaller timestamp.
-	// The applied penalty potentially already skipped potential samples already
-	// that would have resulted in exaggerated sampling frequency.
-	ta, _ := it.a.At()
-	tb, _ := it.b.At()
-
-	it.useA = ta <= tb
-
-	// For the series we didn't pick, add a penalty twice as high as the delta of the last two
-	// samples to the next seek against it.
-	// This ensures that we don't pick a sample too close, which would increase the overall
-	// sample frequency. It also guards against clock drift and inaccuracies during
-	// timestamp assignment.
-	// If we don't know a delta yet, we pick 5000 as a constant, which is based on the knowledge
-	// that timestamps are in milliseconds and sampling frequencies typically multiple seconds long.
-	const initialPenality = 5000
-
-	if it.useA {
-		if it.lastT != math.MinInt64 {
-			it.penB = 2 * (ta - it.lastT)
-		} else {
-			it.penB = initialPenality
-		}
-		it.penA = 0
-		it.lastT = ta
-		return true
-	}
-	if it.lastT != math.MinInt64 {
-		it.penA = 2 * (tb - it.lastT)
-	} else {
-		it.penA = initialPenality
-	}
-	it.penB = 0
-	it.lastT = tb
-	return true
-}
-
-func (it *dedupSeriesIterator) Seek(t int64) bool {
-	for {
-		ts, _ := it.At()
-		if ts > 0 && ts >= t {
-			return true
-		}
-		if !it.Next() {
-			return false
-		}
-	}
-}
-
-func (it *dedupSeriesIterator) At() (int64, float64) {
-	if it.useA {
-		return it.a.At()
-	}
-	return it.b.At()
-}
-
-func (it *dedupSeriesIterator) Err() error {
-	if it.a.Err() != nil {
-		return it.a.Err()
-	}
-	return it.b.Err()
-}
@@ -156,6 +156,12 @@ func aggrsFromFunc(f string) []storepb.Aggr {
 	if f == "increase" || f == "rate" {
 		return []storepb.Aggr{storepb.Aggr_COUNTER}
 	}
+	if f == "last_over_time" {
+		return []storepb.Aggr{storepb.Aggr_LAST}
+	}
+	if f == "quantile_over_time" {
+		return []storepb.Aggr{storepb.Aggr_SKETCH}
+	}
 	// In the default case, we retrieve count and sum to compute an average.
 	return []storepb.Aggr{storepb.Aggr_COUNT, storepb.Aggr_SUM}
 }
@@ -26,6 +26,7 @@ import (
 	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/tsdb/chunkenc"
 	"github.com/thanos-io/thanos/pkg/component"
+	"github.com/thanos-io/thanos/pkg/dedup"
 	"github.com/thanos-io/thanos/pkg/store"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/thanos-io/thanos/pkg/testutil"
@@ -1253,7 +1254,7 @@ func TestDedupSeriesIterator(t *testing.T) {
 	}
 	for i, c := range cases {
 		t.Logf("case %d:", i)
-		it := newDedupSeriesIterator(
+		it := dedup.NewSeriesIterator(
 			newMockedSeriesIterator(c.a),
 			newMockedSeriesIterator(c.b),
 		)
@@ -1264,7 +1265,7 @@ func TestDedupSeriesIterator(t *testing.T) {
 
 func BenchmarkDedupSeriesIterator(b *testing.B) {
 	run := func(b *testing.B, s1, s2 []sample) {
-		it := newDedupSeriesIterator(
+		it := dedup.NewSeriesIterator(
 			newMockedSeriesIterator(s1),
 			newMockedSeriesIterator(s2),
 		)
@@ -18,7 +18,10 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/thanos-io/thanos/pkg/discovery/dns"
 	"gopkg.in/fsnotify.v1"
+	"gopkg.in/yaml.v2"
 )
 
 var (
@@ -26,14 +29,116 @@ var (
 	errParseConfigurationFile = errors.New("configuration file is not parsable")
 	// An errEmptyConfigurationFile is returned by the ConfigWatcher when attempting to load an empty configuration file.
 	errEmptyConfigurationFile = errors.New("configuration file is empty")
+	// An errRouterEndpointInHashring is returned by the ConfigWatcher when a hashring lists the endpoint of the router
+	// watching the configuration.
+	errRouterEndpointInHashring = errors.New("hashring lists the endpoint of this router")
 )
 
+// HashringAlgorithm is the algorithm distributing time series across the endpoints of a hashring.
+type HashringAlgorithm string
+
+const (
+	// AlgorithmHashmod assigns time series to the endpoint at the index of their hash modulo the number of endpoints.
+	// Changing the endpoints remaps almost every time series.
+	AlgorithmHashmod HashringAlgorithm = "hashmod"
+	// AlgorithmKetama assigns time series to endpoints by consistent hashing over virtual nodes of the endpoints.
+	// Changing the endpoints remaps only time series of the added or removed endpoints.
+	AlgorithmKetama HashringAlgorithm = "ketama"
+)
+
+// DefaultVirtualNodes is the default number of virtual nodes per endpoint of ketama hashrings.
+const DefaultVirtualNodes = 200
+
 // HashringConfig represents the configuration for a hashring
 // a receive node knows about.
 type HashringConfig struct {
 	Hashring  string   `json:"hashring,omitempty"`
 	Tenants   []string `json:"tenants,omitempty"`
 	Endpoints []string `json:"endpoints"`
+	// SDFiles are paths to Prometheus file service discovery files holding further endpoints of the hashring.
+	// The paths can be glob patterns.
+	SDFiles []string `json:"sd_files,omitempty"`
+	// Algorithm of the hashring. Defaults to hashmod.
+	Algorithm HashringAlgorithm `json:"algorithm,omitempty"`
+	// VirtualNodes is the number of virtual nodes of an endpoint of weight 1 in a ketama hashring.
+	// Defaults to DefaultVirtualNodes.
+	VirtualNodes uint32 `json:"virtual_nodes,omitempty"`
+	// EndpointOptions holds optional weights and zones of endpoints of a ketama hashring, keyed by endpoint.
+	// Options of discovered endpoints are keyed by their resolved address.
+	EndpointOptions map[string]EndpointOptions `json:"endpoint_options,omitempty"`
+	// RelabelConfigs are applied to time series written for the tenants of the hashring, before they are hashed.
+	// Series relabeled to a non-empty TenantLabel are written for the tenant of its value instead.
+	RelabelConfigs RelabelConfigs `json:"relabel_configs,omitempty"`
+}
+
+// TenantLabel is the label relabel configs set to write a time series for the tenant of its value.
+// The label is removed from the time series. Values other than ASCII letters, digits, '_' and '-' are not valid tenants,
+// and their time series are dropped.
+const TenantLabel = "__tenant__"
+
+// RelabelConfigs are Prometheus relabel configs, given in the form of Prometheus configuration files.
+type RelabelConfigs []*relabel.Config
+
+// UnmarshalJSON implements json.Unmarshaler. JSON is valid YAML, so relabel configs are unmarshalled
+// by their YAML unmarshaller, which applies defaults and validates them.
+func (c *RelabelConfigs) UnmarshalJSON(b []byte) error {
+	var cfgs []*relabel.Config
+	if err := yaml.UnmarshalStrict(b, &cfgs); err != nil {
+		return errors.Wrap(err, "parse relabel configs")
+	}
+	*c = cfgs
+	return nil
+}
+
+// EndpointOptions configures an endpoint of a ketama hashring.
+type EndpointOptions struct {
+	// Weight multiplies the number of virtual nodes of the endpoint, so it gets proportionally more time series.
+	// Defaults to 1.
+	Weight uint32 `json:"weight,omitempty"`
+	// Zone is the availability zone of the endpoint. Replicas of a time series land in distinct zones.
+	// Endpoints without a zone are treated as being in a zone of their own.
+	Zone string `json:"zone,omitempty"`
+}
+
+// validate returns an error if the hashring configuration is inconsistent.
+func (c HashringConfig) validate() error {
+	switch c.Algorithm {
+	case "", AlgorithmHashmod:
+		if c.VirtualNodes > 0 || len(c.EndpointOptions) > 0 {
+			return errors.Errorf("hashring %q: virtual nodes and endpoint options require the %s algorithm", c.Hashring, AlgorithmKetama)
+		}
+	case AlgorithmKetama:
+	default:
+		return errors.Errorf("hashring %q: unknown algorithm %q", c.Hashring, c.Algorithm)
+	}
+
+	// Discovered endpoints are not known until the hashring is resolved.
+	if c.discovered() {
+		return nil
+	}
+	endpoints := make(map[string]struct{}, len(c.Endpoints))
+	for _, e := range c.Endpoints {
+		endpoints[e] = struct{}{}
+	}
+	for e := range c.EndpointOptions {
+		if _, ok := endpoints[e]; !ok {
+			return errors.Errorf("hashring %q: options of unknown endpoint %q", c.Hashring, e)
+		}
+	}
+	return nil
+}
+
+// discovered returns true if endpoints of the hashring have to be resolved by DNS or read from file SD.
+func (c HashringConfig) discovered() bool {
+	if len(c.SDFiles) > 0 {
+		return true
+	}
+	for _, e := range c.Endpoints {
+		if dns.IsDynamicNode(e) {
+			return true
+		}
+	}
+	return false
 }
 
 // ConfigWatcher is able to watch a file containing a hashring configuration
@@ -45,6 +150,10 @@ type ConfigWatcher struct {
 	logger   log.Logger
 	watcher  *fsnotify.Watcher
 
+	// routerEndpoint is the endpoint of the receiver if it runs in router mode. Routers do not ingest series,
+	// so configurations listing it are rejected.
+	routerEndpoint string
+
 	hashGauge            prometheus.Gauge
 	successGauge         prometheus.Gauge
 	lastSuccessTimeGauge prometheus.Gauge
@@ -58,8 +167,8 @@ type ConfigWatcher struct {
 	lastLoadedConfigHash float64
 }
 
-// NewConfigWatcher creates a new ConfigWatcher.
-func NewConfigWatcher(logger log.Logger, reg prometheus.Registerer, path string, interval model.Duration) (*ConfigWatcher, error) {
+// NewConfigWatcher creates a new ConfigWatcher. If routerEndpoint is set, configurations listing it in a hashring are not valid.
+func NewConfigWatcher(logger log.Logger, reg prometheus.Registerer, path string, interval model.Duration, routerEndpoint string) (*ConfigWatcher, error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
 	}
@@ -78,6 +187,8 @@ func NewConfigWatcher(logger log.Logger, reg prometheus.Registerer, path string,
 		interval: time.Duration(interval),
 		logger:   logger,
 		watcher:  watcher,
+
+		routerEndpoint: routerEndpoint,
 		hashGauge: promauto.With(reg).NewGauge(
 			prometheus.GaugeOpts{
 				Name: "thanos_receive_config_hash",
@@ -197,6 +308,16 @@ func (cw *ConfigWatcher) loadConfig() ([]HashringConfig, float64, error) {
 		return nil, 0, errors.Wrapf(errEmptyConfigurationFile, "failed to load configuration file, path: %s", cw.path)
 	}
 
+	if cw.routerEndpoint != "" {
+		for _, c := range config {
+			for _, e := range c.Endpoints {
+				if e == cw.routerEndpoint {
+					return nil, 0, errors.Wrapf(errRouterEndpointInHashring, "hashring %q, endpoint %s", c.Hashring, e)
+				}
+			}
+		}
+	}
+
 	return config, hashAsMetricValue(cfgContent), nil
 }
 
@@ -283,8 +404,15 @@ func (cw *ConfigWatcher) readFile() ([]byte, error) {
 // parseConfig parses the raw configuration content and returns a HashringConfig.
 func (cw *ConfigWatcher) parseConfig(content []byte) ([]HashringConfig, error) {
 	var config []HashringConfig
-	err := json.Unmarshal(content, &config)
-	return config, err
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, err
+	}
+	for _, c := range config {
+		if err := c.validate(); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
 }
 
 // hashAsMetricValue generates metric value from hash of data.
@@ -14,9 +14,10 @@ import (
 
 func TestValidateConfig(t *testing.T) {
 	for _, tc := range []struct {
-		name string
-		cfg  interface{}
-		err  error
+		name           string
+		cfg            interface{}
+		routerEndpoint string
+		err            error
 	}{
 		{
 			name: "<nil> config",
@@ -33,6 +34,79 @@ func TestValidateConfig(t *testing.T) {
 			cfg:  struct{}{},
 			err:  errParseConfigurationFile,
 		},
+		{
+			name: "unknown algorithm",
+			cfg: []HashringConfig{
+				{
+					Endpoints: []string{"node1"},
+					Algorithm: "unknown",
+				},
+			},
+			err: errParseConfigurationFile,
+		},
+		{
+			name: "endpoint options without ketama",
+			cfg: []HashringConfig{
+				{
+					Endpoints:       []string{"node1"},
+					EndpointOptions: map[string]EndpointOptions{"node1": {Weight: 2}},
+				},
+			},
+			err: errParseConfigurationFile,
+		},
+		{
+			name: "options of unknown endpoint",
+			cfg: []HashringConfig{
+				{
+					Endpoints:       []string{"node1"},
+					Algorithm:       AlgorithmKetama,
+					EndpointOptions: map[string]EndpointOptions{"node2": {Zone: "a"}},
+				},
+			},
+			err: errParseConfigurationFile,
+		},
+		{
+			name: "valid ketama config",
+			cfg: []HashringConfig{
+				{
+					Endpoints:       []string{"node1", "node2"},
+					Algorithm:       AlgorithmKetama,
+					VirtualNodes:    100,
+					EndpointOptions: map[string]EndpointOptions{"node1": {Weight: 2, Zone: "a"}},
+				},
+			},
+			err: nil, // means it's valid.
+		},
+		{
+			name: "options of discovered endpoints",
+			cfg: []HashringConfig{
+				{
+					Endpoints:       []string{"dns+receive.example.com:10901"},
+					SDFiles:         []string{"hashring.yaml"},
+					Algorithm:       AlgorithmKetama,
+					EndpointOptions: map[string]EndpointOptions{"10.0.0.1:10901": {Zone: "a"}},
+				},
+			},
+			err: nil, // means it's valid.
+		},
+		{
+			name: "invalid relabel config",
+			cfg:  json.RawMessage(`[{"endpoints": ["node1"], "relabel_configs": [{"action": "unknown"}]}]`),
+			err:  errParseConfigurationFile,
+		},
+		{
+			name: "unknown field of relabel config",
+			cfg:  json.RawMessage(`[{"endpoints": ["node1"], "relabel_configs": [{"action": "drop", "regexp": "foo"}]}]`),
+			err:  errParseConfigurationFile,
+		},
+		{
+			name: "valid relabel config",
+			cfg: json.RawMessage(`[{"endpoints": ["node1"], "relabel_configs": [
+				{"source_labels": ["team"], "target_label": "__tenant__"},
+				{"action": "labeldrop", "regex": "team"}
+			]}]`),
+			err: nil, // means it's valid.
+		},
 		{
 			name: "valid config",
 			cfg: []HashringConfig{
@@ -42,6 +116,26 @@ func TestValidateConfig(t *testing.T) {
 			},
 			err: nil, // means it's valid.
 		},
+		{
+			name: "router endpoint in hashring",
+			cfg: []HashringConfig{
+				{
+					Endpoints: []string{"node1", "router"},
+				},
+			},
+			routerEndpoint: "router",
+			err:            errRouterEndpointInHashring,
+		},
+		{
+			name: "valid config of router",
+			cfg: []HashringConfig{
+				{
+					Endpoints: []string{"node1"},
+				},
+			},
+			routerEndpoint: "router",
+			err:            nil, // means it's valid.
+		},
 	} {
 		var content []byte
 		var err error
@@ -63,14 +157,18 @@ func TestValidateConfig(t *testing.T) {
 			t.Fatalf("case %q: unexpectedly failed closing the temp file: %v", tc.name, err)
 		}
 
-		cw, err := NewConfigWatcher(nil, nil, tmpfile.Name(), 1)
+		cw, err := NewConfigWatcher(nil, nil, tmpfile.Name(), 1, tc.routerEndpoint)
 		if err != nil {
 			t.Fatalf("case %q: unexpectedly failed creating config watcher: %v", tc.name, err)
 		}
 
-		if err := cw.ValidateConfig(); err != nil && !errors.Is(err, tc.err) {
+		err = cw.ValidateConfig()
+		if err != nil && !errors.Is(err, tc.err) {
 			t.Errorf("case %q: got unexpected error: %v", tc.name, err)
 			continue
 		}
+		if err == nil && tc.err != nil {
+			t.Errorf("case %q: expected error %v, got none", tc.name, tc.err)
+		}
 	}
 }
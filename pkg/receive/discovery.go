@@ -0,0 +1,237 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/file"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/thanos-io/thanos/pkg/discovery/cache"
+	"github.com/thanos-io/thanos/pkg/discovery/dns"
+	"github.com/thanos-io/thanos/pkg/extprom"
+)
+
+// HashringDiscovery builds hashrings from hashring configurations, resolving endpoints given as DNS names
+// with the dns+ or dnssrv+ prefix and reading endpoints from Prometheus file service discovery.
+type HashringDiscovery struct {
+	logger         log.Logger
+	dnsProvider    *dns.Provider
+	dnsInterval    time.Duration
+	fileSDInterval model.Duration
+	debounce       time.Duration
+
+	membersGauge *prometheus.GaugeVec
+}
+
+// NewHashringDiscovery creates a new HashringDiscovery. DNS names are resolved every dnsInterval, file SD files are
+// re-read every fileSDInterval on top of watching them, and hashrings with changed members are rebuilt
+// at most once per debounce duration.
+func NewHashringDiscovery(
+	logger log.Logger,
+	reg prometheus.Registerer,
+	resolverType dns.ResolverType,
+	dnsInterval time.Duration,
+	fileSDInterval model.Duration,
+	debounce time.Duration,
+) *HashringDiscovery {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &HashringDiscovery{
+		logger:         logger,
+		dnsProvider:    dns.NewProvider(logger, extprom.WrapRegistererWithPrefix("thanos_receive_hashring_", reg), resolverType),
+		dnsInterval:    dnsInterval,
+		fileSDInterval: fileSDInterval,
+		debounce:       debounce,
+		membersGauge: promauto.With(reg).NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "thanos_receive_hashring_members",
+				Help: "The number of resolved members per hashring.",
+			},
+			[]string{"name"}),
+	}
+}
+
+// discoveredHashring tracks the members of a configured hashring.
+type discoveredHashring struct {
+	cfg HashringConfig
+	// dns and fileSD are nil if the hashring has only static endpoints.
+	dns    *dns.Provider
+	fileSD *cache.Cache
+}
+
+// members returns the current endpoints of the hashring.
+// Discovered endpoints are sorted, as hashmod hashrings depend on the order of their endpoints.
+func (r *discoveredHashring) members(ctx context.Context) []string {
+	if r.dns == nil {
+		return r.cfg.Endpoints
+	}
+
+	addrs := append([]string{}, r.cfg.Endpoints...)
+	if r.fileSD != nil {
+		addrs = append(addrs, r.fileSD.Addresses()...)
+	}
+	r.dns.Resolve(ctx, addrs)
+
+	resolved := r.dns.Addresses()
+	sort.Strings(resolved)
+	members := resolved[:0]
+	for i, m := range resolved {
+		if i > 0 && m == resolved[i-1] {
+			continue
+		}
+		members = append(members, m)
+	}
+	return members
+}
+
+// Run sends hashrings built from configurations received on the configs channel to the updates channel,
+// until the given context is cancelled or the configs channel is closed.
+// Members of hashrings are resolved again on file SD updates and every DNS interval.
+// The first hashring is sent right away, unless it waits for the first read of its file SD files.
+// Later changes are sent once the debounce duration passes.
+func (d *HashringDiscovery) Run(ctx context.Context, configs <-chan []HashringConfig, updates chan<- Hashring) error {
+	var (
+		rings     []*discoveredHashring
+		members   [][]string
+		sent      [][]string
+		ready     bool
+		sdUpdates = make(chan struct{}, 1)
+		debounceC <-chan time.Time
+	)
+	stopSD := context.CancelFunc(func() {})
+	defer func() { stopSD() }()
+
+	ticker := time.NewTicker(d.dnsInterval)
+	defer ticker.Stop()
+
+	for {
+		configChanged := false
+		select {
+		case cfg, ok := <-configs:
+			if !ok {
+				return errors.New("hashring config watcher stopped unexpectedly")
+			}
+			stopSD()
+			rings, stopSD = d.hashrings(ctx, cfg, sdUpdates)
+			configChanged = true
+		case <-sdUpdates:
+		case <-ticker.C:
+		case <-debounceC:
+			debounceC = nil
+			if err := d.send(ctx, rings, members, updates); err != nil {
+				return err
+			}
+			sent, ready = members, true
+			continue
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if rings == nil {
+			continue
+		}
+
+		members = make([][]string, 0, len(rings))
+		waitFileSD := false
+		for _, r := range rings {
+			members = append(members, r.members(ctx))
+			waitFileSD = waitFileSD || r.fileSD != nil
+		}
+		if debounceC != nil || (ready && !configChanged && equalMembers(members, sent)) {
+			continue
+		}
+		if d.debounce > 0 && (ready || waitFileSD) {
+			debounceC = time.After(d.debounce)
+			continue
+		}
+		if err := d.send(ctx, rings, members, updates); err != nil {
+			return err
+		}
+		sent, ready = members, true
+	}
+}
+
+// hashrings returns hashrings tracking the members of the given configuration. File SD of the hashrings runs
+// until the returned function is called, notifying the sdUpdates channel about updates.
+func (d *HashringDiscovery) hashrings(ctx context.Context, cfg []HashringConfig, sdUpdates chan<- struct{}) ([]*discoveredHashring, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	rings := make([]*discoveredHashring, 0, len(cfg))
+	for _, c := range cfg {
+		r := &discoveredHashring{cfg: c}
+		if c.discovered() {
+			r.dns = d.dnsProvider.Clone()
+		}
+		if len(c.SDFiles) > 0 {
+			r.fileSD = cache.New()
+			go d.runFileSD(ctx, c.SDFiles, r.fileSD, sdUpdates)
+		}
+		rings = append(rings, r)
+	}
+	return rings, cancel
+}
+
+// runFileSD keeps the cache updated with targets of the given file SD files until the context is cancelled.
+func (d *HashringDiscovery) runFileSD(ctx context.Context, files []string, c *cache.Cache, sdUpdates chan<- struct{}) {
+	tgs := make(chan []*targetgroup.Group)
+	go file.NewDiscovery(&file.SDConfig{Files: files, RefreshInterval: d.fileSDInterval}, d.logger).Run(ctx, tgs)
+
+	for {
+		select {
+		case update := <-tgs:
+			c.Update(update)
+			// A pending notification covers this update too.
+			select {
+			case sdUpdates <- struct{}{}:
+			default:
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// send builds a hashring of the given members and sends it to the updates channel.
+func (d *HashringDiscovery) send(ctx context.Context, rings []*discoveredHashring, members [][]string, updates chan<- Hashring) error {
+	cfg := make([]HashringConfig, 0, len(rings))
+	for i, r := range rings {
+		c := r.cfg
+		c.Endpoints = members[i]
+		cfg = append(cfg, c)
+		d.membersGauge.WithLabelValues(c.Hashring).Set(float64(len(c.Endpoints)))
+	}
+	level.Debug(d.logger).Log("msg", "rebuilding hashrings", "hashrings", len(cfg))
+
+	select {
+	case updates <- newMultiHashring(cfg):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func equalMembers(a, b [][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if a[i][j] != b[i][j] {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,85 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/thanos-io/thanos/pkg/discovery/dns"
+)
+
+func TestHashringDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hashring-discovery")
+	if err != nil {
+		t.Fatalf("unexpectedly failed creating the temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	sdFile := filepath.Join(dir, "targets.yaml")
+	writeTargets := func(targets string) {
+		if err := ioutil.WriteFile(sdFile, []byte("- targets: ["+targets+"]\n"), os.ModePerm); err != nil {
+			t.Fatalf("unexpectedly failed writing the file SD file: %v", err)
+		}
+	}
+	writeTargets(`"node3:10901", "node2:10901"`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const debounce = 100 * time.Millisecond
+	d := NewHashringDiscovery(nil, prometheus.NewRegistry(), dns.GolangResolverType, time.Minute, model.Duration(time.Minute), debounce)
+	configs := make(chan []HashringConfig)
+	updates := make(chan Hashring)
+	errc := make(chan error, 1)
+	go func() { errc <- d.Run(ctx, configs, updates) }()
+
+	members := func() []string {
+		select {
+		case h := <-updates:
+			return []string(h.(*multiHashring).hashrings[0].(simpleHashring))
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for a hashring")
+			return nil
+		}
+	}
+
+	// A static hashring is sent right away and keeps the order of its endpoints.
+	configs <- []HashringConfig{{Endpoints: []string{"node2:10901", "node1:10901"}}}
+	if got, exp := members(), []string{"node2:10901", "node1:10901"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected static members %v, got %v", exp, got)
+	}
+
+	// Discovered endpoints are sorted and deduplicated.
+	start := time.Now()
+	configs <- []HashringConfig{{Endpoints: []string{"node1:10901", "node2:10901"}, SDFiles: []string{sdFile}}}
+	if got, exp := members(), []string{"node1:10901", "node2:10901", "node3:10901"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected discovered members %v, got %v", exp, got)
+	}
+	if since := time.Since(start); since < debounce {
+		t.Errorf("expected rebuild to be debounced by %v, got rebuilt after %v", debounce, since)
+	}
+	if got := promtestutil.ToFloat64(d.membersGauge.WithLabelValues("")); got != 3 {
+		t.Errorf("expected 3 members in the metric, got %v", got)
+	}
+
+	// Changes of file SD targets rebuild the hashring.
+	writeTargets(`"node4:10901"`)
+	if got, exp := members(), []string{"node1:10901", "node2:10901", "node4:10901"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected updated members %v, got %v", exp, got)
+	}
+
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("expected context cancelled error, got %v", err)
+	}
+}
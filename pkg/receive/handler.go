@@ -11,8 +11,11 @@ import (
 	stdlog "log"
 	"net"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -32,7 +35,9 @@ import (
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
+	"github.com/thanos-io/thanos/pkg/extprom"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
+	"github.com/thanos-io/thanos/pkg/gate"
 	"github.com/thanos-io/thanos/pkg/runutil"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/thanos-io/thanos/pkg/tracing"
@@ -54,6 +59,35 @@ var conflictErr = errors.New("conflict")
 
 var errBadReplica = errors.New("replica count exceeds replication factor")
 
+var errNotRouted = errors.New("write request was not routed; ingestors accept only write requests forwarded by routers")
+
+// errHandedOff is the error of a write request queued for a replica behind its pending hints, rather than written to it.
+var errHandedOff = errors.New("replica has pending hints; write request queued")
+
+// ReceiverMode determines whether a receiver routes write requests over the hashring, ingests them into
+// the local TSDB, or both.
+type ReceiverMode string
+
+const (
+	// RouterIngestor receivers route write requests over the hashring and ingest series belonging to their
+	// local endpoint.
+	RouterIngestor ReceiverMode = "router-ingestor"
+	// RouterOnly receivers route write requests over the hashring without holding any TSDB.
+	RouterOnly ReceiverMode = "router"
+	// IngestorOnly receivers ingest write requests already routed by routers, without consulting any hashring.
+	IngestorOnly ReceiverMode = "ingestor"
+)
+
+// Routes returns true if receivers of the mode route write requests over the hashring.
+func (m ReceiverMode) Routes() bool {
+	return m != IngestorOnly
+}
+
+// Ingests returns true if receivers of the mode write into the local TSDB.
+func (m ReceiverMode) Ingests() bool {
+	return m != RouterOnly
+}
+
 // Options for the web Handler.
 type Options struct {
 	Writer            *Writer
@@ -67,6 +101,23 @@ type Options struct {
 	Tracer            opentracing.Tracer
 	TLSConfig         *tls.Config
 	DialOpts          []grpc.DialOption
+	// ReceiverMode of the handler. Empty mode is RouterIngestor.
+	ReceiverMode ReceiverMode
+	// Handoff queues replicated write requests for unreachable replicas when the replication quorum is met.
+	// Nil disables hinted handoff.
+	Handoff *HandoffQueue
+	// ForwardCompression is the compression of write requests forwarded to peers over write streams.
+	ForwardCompression storepb.Compression
+	// HashringTransition is the period after a hashring change during which series moved to other endpoints are
+	// also written to their previous endpoints. 0 disables dual-writing.
+	HashringTransition time.Duration
+	// ForwardTimeout bounds write requests forwarded to other receivers, including dual-writes, which outlive the
+	// write requests they belong to. 0 disables the timeout.
+	ForwardTimeout time.Duration
+	// MaxConcurrentWrites limits the number of write requests of clients handled concurrently. Further requests wait
+	// for their turn. Write requests forwarded between receivers are not limited, so it does not apply to the flow
+	// control of write streams, which is bound by maxConcurrentStreamWrites. 0 disables the limit.
+	MaxConcurrentWrites int
 }
 
 // Handler serves a Prometheus remote write receiving HTTP endpoint.
@@ -79,10 +130,18 @@ type Handler struct {
 
 	mtx      sync.RWMutex
 	hashring Hashring
+	// previous holds the hashrings replaced within the transition period, newest first. Moved series are dual-written
+	// to their endpoints in each of them until its transition ends.
+	previous []previousHashring
 	peers    *peerGroup
+	// writeGate limits concurrent write requests of clients; nil if they are not limited.
+	writeGate *gate.Gate
 
 	// Metrics.
-	forwardRequestsTotal *prometheus.CounterVec
+	forwardRequestsTotal      *prometheus.CounterVec
+	relabelDroppedSeriesTotal prometheus.Counter
+	dualWrittenSeriesTotal    prometheus.Counter
+	dualWriteFailuresTotal    prometheus.Counter
 }
 
 func NewHandler(logger log.Logger, o *Options) *Handler {
@@ -95,13 +154,35 @@ func NewHandler(logger log.Logger, o *Options) *Handler {
 		writer:  o.Writer,
 		router:  route.New(),
 		options: o,
-		peers:   newPeerGroup(o.DialOpts...),
+		peers:   newPeerGroup(o.ForwardCompression, o.DialOpts...),
 		forwardRequestsTotal: promauto.With(o.Registry).NewCounterVec(
 			prometheus.CounterOpts{
 				Name: "thanos_receive_forward_requests_total",
 				Help: "The number of forward requests.",
 			}, []string{"result"},
 		),
+		relabelDroppedSeriesTotal: promauto.With(o.Registry).NewCounter(
+			prometheus.CounterOpts{
+				Name: "thanos_receive_relabel_dropped_series_total",
+				Help: "The number of time series dropped by relabel configs of hashrings, including time series relabeled to invalid tenants.",
+			},
+		),
+		dualWrittenSeriesTotal: promauto.With(o.Registry).NewCounter(
+			prometheus.CounterOpts{
+				Name: "thanos_receive_hashring_transition_dual_written_series_total",
+				Help: "The number of time series written to their previous endpoint as well during hashring transitions.",
+			},
+		),
+		dualWriteFailuresTotal: promauto.With(o.Registry).NewCounter(
+			prometheus.CounterOpts{
+				Name: "thanos_receive_hashring_transition_dual_write_failures_total",
+				Help: "The number of failed write requests to previous endpoints of time series during hashring transitions.",
+			},
+		),
+	}
+
+	if o.MaxConcurrentWrites > 0 {
+		h.writeGate = gate.NewGate(o.MaxConcurrentWrites, extprom.WrapRegistererWithPrefix("thanos_receive_write_", o.Registry))
 	}
 
 	ins := extpromhttp.NewNopInstrumentationMiddleware()
@@ -118,6 +199,9 @@ func NewHandler(logger log.Logger, o *Options) *Handler {
 	}
 
 	h.router.Post("/api/v1/receive", instrf("receive", readyf(h.receiveHTTP)))
+	// Pushgateway clients push with both PUT and POST; both append to the TSDB.
+	h.router.Post("/api/v1/push/metrics/*labels", instrf("push", readyf(h.pushHTTP)))
+	h.router.Put("/api/v1/push/metrics/*labels", instrf("push", readyf(h.pushHTTP)))
 
 	return h
 }
@@ -126,19 +210,50 @@ func NewHandler(logger log.Logger, o *Options) *Handler {
 // The hashring must be set to a non-nil value in order for the
 // handler to be ready and usable.
 // If the hashring is nil, then the handler is marked as not ready.
+// When a hashring replaces another, series moved to other endpoints are dual-written to their previous endpoints
+// for the hashring transition period. Hashrings changing again within the period keep all the replaced hashrings,
+// each until its own transition period ends.
 func (h *Handler) Hashring(hashring Hashring) {
 	h.mtx.Lock()
 	defer h.mtx.Unlock()
+	if hashring == nil {
+		h.previous = nil
+	}
+	if h.options.HashringTransition > 0 && h.hashring != nil && hashring != nil {
+		h.previous = append([]previousHashring{{
+			hashring: h.hashring,
+			end:      time.Now().Add(h.options.HashringTransition),
+		}}, h.previousHashrings()...)
+	}
 	h.hashring = hashring
 }
 
+// previousHashring is a hashring replaced by another, to which moved series are dual-written until end.
+type previousHashring struct {
+	hashring Hashring
+	end      time.Time
+}
+
+// previousHashrings returns the replaced hashrings within their transition period, newest first.
+// It must be called with the lock held.
+func (h *Handler) previousHashrings() []previousHashring {
+	now := time.Now()
+	for i, p := range h.previous {
+		if now.After(p.end) {
+			// Older hashrings were replaced earlier, so their transition ended too.
+			return h.previous[:i]
+		}
+	}
+	return h.previous
+}
+
 // Verifies whether the server is ready or not.
 func (h *Handler) isReady() bool {
 	h.mtx.RLock()
 	hr := h.hashring != nil
 	sr := h.writer != nil
 	h.mtx.RUnlock()
-	return sr && hr
+	return (sr || !h.options.ReceiverMode.Ingests()) && (hr || !h.options.ReceiverMode.Routes())
 }
 
 // Checks if server is ready, calls f if it is, returns 503 if it is not.
@@ -157,6 +272,18 @@ func (h *Handler) testReady(f http.HandlerFunc) http.HandlerFunc {
 	}
 }
 
+// limit waits until it is the turn of a write request of a client within the limit of concurrent writes.
+// The returned function must be called once the request is handled.
+func (h *Handler) limit(ctx context.Context) (func(), error) {
+	if h.writeGate == nil {
+		return func() {}, nil
+	}
+	if err := h.writeGate.IsMyTurn(ctx); err != nil {
+		return nil, errors.Wrap(err, "wait for turn to write")
+	}
+	return h.writeGate.Done, nil
+}
+
 // Close stops the Handler.
 func (h *Handler) Close() {
 	if h.listener != nil {
@@ -220,16 +347,75 @@ func (h *Handler) handleRequest(ctx context.Context, rep uint64, tenant string,
 		r.n--
 	}
 
+	// Time series are relabeled once, before they are hashed, and not again when replicated.
+	wreqs := map[string]*prompb.WriteRequest{tenant: wreq}
+	if !r.replicated {
+		wreqs = h.relabel(tenant, wreq)
+	}
+	tenants := make([]string, 0, len(wreqs))
+	for t := range wreqs {
+		tenants = append(tenants, t)
+	}
+	sort.Strings(tenants)
+
 	// Forward any time series as necessary. All time series
 	// destined for the local node will be written to the receiver.
 	// Time series will be replicated as necessary.
-	if err := h.forward(ctx, tenant, r, wreq); err != nil {
-		if countCause(err, isConflict) > 0 {
-			return conflictErr
+	var ferr error
+	for _, t := range tenants {
+		err := h.forward(ctx, t, r, wreqs[t])
+		switch {
+		case err == nil:
+			continue
+		case countCause(err, isConflict) > 0:
+			err = conflictErr
+		case countCause(err, isNotReady) > 0:
+			err = tsdb.ErrNotReady
 		}
-		return err
+		ferr = precedingError(ferr, err)
 	}
-	return nil
+	return ferr
+}
+
+// precedingError returns the error of a write request out of the errors of two of its parts, either of which can be nil.
+// Other errors take precedence over not ready errors, and these over conflicts, as clients retry them.
+func precedingError(a, b error) error {
+	if errorPrecedence(b) > errorPrecedence(a) {
+		return b
+	}
+	return a
+}
+
+func errorPrecedence(err error) int {
+	switch cause := errors.Cause(err); {
+	case err == nil:
+		return 0
+	case isConflict(cause):
+		return 1
+	case isNotReady(cause):
+		return 2
+	default:
+		return 3
+	}
+}
+
+// relabel applies relabel configs of the hashring of the tenant to the write request.
+// It returns write requests by tenant.
+func (h *Handler) relabel(tenant string, wreq *prompb.WriteRequest) map[string]*prompb.WriteRequest {
+	h.mtx.RLock()
+	rl, ok := h.hashring.(relabeler)
+	h.mtx.RUnlock()
+	if !ok {
+		return map[string]*prompb.WriteRequest{tenant: wreq}
+	}
+	cfgs := rl.RelabelConfigs(tenant)
+	if len(cfgs) == 0 {
+		return map[string]*prompb.WriteRequest{tenant: wreq}
+	}
+
+	wreqs, dropped := relabelRequest(tenant, wreq, cfgs)
+	h.relabelDroppedSeriesTotal.Add(float64(dropped))
+	return wreqs
 }
 
 func (h *Handler) receiveHTTP(w http.ResponseWriter, r *http.Request) {
@@ -266,7 +452,21 @@ func (h *Handler) receiveHTTP(w http.ResponseWriter, r *http.Request) {
 		tenant = h.options.DefaultTenantID
 	}
 
-	err = h.handleRequest(r.Context(), rep, tenant, &wreq)
+	// Write requests forwarded by other receivers are not limited, as they hold a turn on the forwarding receiver.
+	if rep == 0 {
+		done, err := h.limit(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer done()
+	}
+
+	h.writeResponse(w, h.handleRequest(r.Context(), rep, tenant, &wreq))
+}
+
+// writeResponse writes the HTTP response of a handled write request.
+func (h *Handler) writeResponse(w http.ResponseWriter, err error) {
 	switch err {
 	case nil:
 		return
@@ -293,6 +493,10 @@ func (h *Handler) receiveHTTP(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) forward(ctx context.Context, tenant string, r replica, wreq *prompb.WriteRequest) error {
 	wreqs := make(map[string]*prompb.WriteRequest)
 	replicas := make(map[string]replica)
+	// Series moved by hashring changes within the transition period, by previous endpoint. Replicated series are
+	// dual-written by replicate.
+	dual := make(map[string]*prompb.WriteRequest)
+	dualReplicas := make(map[string]replica)
 
 	// It is possible that hashring is ready in testReady() but unready now,
 	// so need to lock here.
@@ -301,6 +505,7 @@ func (h *Handler) forward(ctx context.Context, tenant string, r replica, wreq *p
 		h.mtx.RUnlock()
 		return errors.New("hashring is not ready")
 	}
+	prevs := h.previousHashrings()
 
 	// Batch all of the time series in the write request
 	// into several smaller write requests that are
@@ -315,6 +520,27 @@ func (h *Handler) forward(ctx context.Context, tenant string, r replica, wreq *p
 			h.mtx.RUnlock()
 			return err
 		}
+		switch {
+		case len(prevs) == 0:
+		case r.replicated:
+			// Series dual-written to their previous endpoint are written there, rather than routed again.
+			if endpoint != h.options.Endpoint && h.isPreviousEndpoint(prevs, tenant, &wreq.Timeseries[i]) {
+				endpoint = h.options.Endpoint
+			}
+		case h.options.ReplicationFactor <= 1:
+			// A series is written once to every previous endpoint, even if several previous hashrings share it.
+			for j, p := range prevs {
+				prevEndpoint, err := p.hashring.GetN(tenant, &wreq.Timeseries[i], r.n)
+				if err != nil || prevEndpoint == endpoint || previousEndpointOf(prevs[:j], tenant, &wreq.Timeseries[i], r.n, prevEndpoint) {
+					continue
+				}
+				if _, ok := dual[prevEndpoint]; !ok {
+					dual[prevEndpoint] = &prompb.WriteRequest{}
+					dualReplicas[prevEndpoint] = replica{n: r.n, replicated: true}
+				}
+				dual[prevEndpoint].Timeseries = append(dual[prevEndpoint].Timeseries, wreq.Timeseries[i])
+			}
+		}
 		if _, ok := wreqs[endpoint]; !ok {
 			wreqs[endpoint] = &prompb.WriteRequest{}
 			replicas[endpoint] = r
@@ -322,11 +548,81 @@ func (h *Handler) forward(ctx context.Context, tenant string, r replica, wreq *p
 		wr := wreqs[endpoint]
 		wr.Timeseries = append(wr.Timeseries, wreq.Timeseries[i])
 	}
+	// Metadata is batched along with the time series of its metric family.
+	for i := range wreq.Metadata {
+		endpoint, err := h.hashring.GetN(tenant, metadataSeries(&wreq.Metadata[i]), r.n)
+		if err != nil {
+			h.mtx.RUnlock()
+			return err
+		}
+		if _, ok := wreqs[endpoint]; !ok {
+			wreqs[endpoint] = &prompb.WriteRequest{}
+			replicas[endpoint] = r
+		}
+		wr := wreqs[endpoint]
+		wr.Metadata = append(wr.Metadata, wreq.Metadata[i])
+	}
 	h.mtx.RUnlock()
 
+	return h.parallelizeWithDualWrites(ctx, tenant, replicas, wreqs, dualReplicas, dual)
+}
+
+// isPreviousEndpoint returns whether the local endpoint held any replica of the time series in any previous hashring.
+func (h *Handler) isPreviousEndpoint(prevs []previousHashring, tenant string, ts *prompb.TimeSeries) bool {
+	for i := uint64(0); i == 0 || i < h.options.ReplicationFactor; i++ {
+		if previousEndpointOf(prevs, tenant, ts, i, h.options.Endpoint) {
+			return true
+		}
+	}
+	return false
+}
+
+// previousEndpointOf returns whether the endpoint held the nth replica of the time series in any of the previous hashrings.
+func previousEndpointOf(prevs []previousHashring, tenant string, ts *prompb.TimeSeries, n uint64, endpoint string) bool {
+	for _, p := range prevs {
+		if e, err := p.hashring.GetN(tenant, ts, n); err == nil && e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// parallelizeWithDualWrites parallelizes the write requests and starts dual writes of moved series to their
+// previous endpoints. The function returns when the write requests have finished or the context is canceled;
+// dual writes do not delay the response and go on in the background, bound by the forward timeout.
+func (h *Handler) parallelizeWithDualWrites(ctx context.Context, tenant string, replicas map[string]replica, wreqs map[string]*prompb.WriteRequest, dualReplicas map[string]replica, dual map[string]*prompb.WriteRequest) error {
+	if len(dual) > 0 {
+		go h.dualWrite(tenant, dualReplicas, dual)
+	}
 	return h.parallelizeRequests(ctx, tenant, replicas, wreqs)
 }
 
+// dualWrite writes series moved by hashring changes within the transition period to their previous endpoints, so
+// these keep complete series until the transition period ends. Failures do not fail the write request, as the new
+// endpoints hold the series. Dual writes are detached from the write request, which does not wait for them.
+func (h *Handler) dualWrite(tenant string, replicas map[string]replica, wreqs map[string]*prompb.WriteRequest) {
+	ctx, cancel := context.Background(), context.CancelFunc(func() {})
+	if h.options.ForwardTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, h.options.ForwardTimeout)
+	}
+	defer cancel()
+
+	for _, wreq := range wreqs {
+		h.dualWrittenSeriesTotal.Add(float64(len(wreq.Timeseries)))
+	}
+
+	err := h.parallelizeRequests(ctx, tenant, replicas, wreqs)
+	if err == nil {
+		return
+	}
+	errs, ok := err.(terrors.MultiError)
+	if !ok {
+		errs = terrors.MultiError{err}
+	}
+	h.dualWriteFailuresTotal.Add(float64(len(errs)))
+	level.Warn(h.logger).Log("msg", "failed to dual-write series to previous endpoints during hashring transition", "err", err)
+}
+
 // parallelizeRequests parallelizes a given set of write requests.
 // The function only returns when all requests have finished
 // or the context is canceled.
@@ -356,29 +652,16 @@ func (h *Handler) parallelizeRequests(ctx context.Context, tenant string, replic
 		// function as replication to other nodes, we can treat
 		// a failure to write locally as just another error that
 		// can be ignored if the replication factor is met.
-		if endpoint == h.options.Endpoint {
+		// Routers never store locally, even if their endpoint is in the hashring.
+		if endpoint == h.options.Endpoint && h.options.ReceiverMode.Ingests() {
 			go func(endpoint string) {
-				var err error
-
-				tracing.DoInSpan(ctx, "receive_tsdb_write", func(ctx context.Context) {
-					err = h.writer.Write(tenant, wreqs[endpoint])
-				})
-
-				// When a MultiError is added to another MultiError, the error slices are concatenated, not nested.
-				// To avoid breaking the counting logic, we need to flatten the error.
-				if errs, ok := err.(terrors.MultiError); ok {
-					if countCause(errs, isConflict) > 0 {
-						err = errors.Wrap(conflictErr, errs.Error())
-					} else if countCause(errs, isNotReady) > 0 {
-						err = tsdb.ErrNotReady
-					} else {
-						err = errors.New(errs.Error())
-					}
-				}
+				err := h.writeLocal(ctx, tenant, wreqs[endpoint])
 				if err != nil {
 					level.Error(h.logger).Log("msg", "storing locally", "err", err, "endpoint", endpoint)
+					ec <- &endpointError{endpoint: endpoint, err: err}
+					return
 				}
-				ec <- err
+				ec <- nil
 			}(endpoint)
 			continue
 		}
@@ -386,6 +669,22 @@ func (h *Handler) parallelizeRequests(ctx context.Context, tenant string, replic
 		go func(endpoint string) {
 			var err error
 
+			r := &storepb.WriteRequest{
+				Timeseries: wreqs[endpoint].Timeseries,
+				Metadata:   wreqs[endpoint].Metadata,
+				Tenant:     tenant,
+				Replica:    int64(replicas[endpoint].n + 1), // increment replica since on-the-wire format is 1-indexed and 0 indicates unreplicated.
+			}
+			// Write requests for replicas which did not get their hints replayed yet are queued behind them,
+			// as replicas reject samples older than the ones they already have.
+			if h.options.Handoff != nil && h.options.Handoff.Pending(endpoint) {
+				if err = h.options.Handoff.Add(endpoint, r); err == nil {
+					err = errHandedOff
+				}
+				ec <- &endpointError{endpoint: endpoint, err: err}
+				return
+			}
+
 			// Increment the counters as necessary now that
 			// the requests will go out.
 			defer func() {
@@ -396,28 +695,19 @@ func (h *Handler) parallelizeRequests(ctx context.Context, tenant string, replic
 				h.forwardRequestsTotal.WithLabelValues("success").Inc()
 			}()
 
-			cl, err := h.peers.get(ctx, endpoint)
+			// Actually make the request against the endpoint
+			// we determined should handle these time series.
+			fctx, cancel := ctx, context.CancelFunc(func() {})
+			if h.options.ForwardTimeout > 0 {
+				fctx, cancel = context.WithTimeout(ctx, h.options.ForwardTimeout)
+			}
+			defer cancel()
+			err = h.writeRemote(fctx, endpoint, r)
 			if err != nil {
-				level.Error(h.logger).Log("msg", "failed to get peer connection to forward request", "err", err, "endpoint", endpoint)
-				ec <- err
+				ec <- &endpointError{endpoint: endpoint, err: err}
 				return
 			}
-			// Create a span to track the request made to another receive node.
-			tracing.DoInSpan(ctx, "receive_forward", func(ctx context.Context) {
-				// Actually make the request against the endpoint
-				// we determined should handle these time series.
-				_, err = cl.RemoteWrite(ctx, &storepb.WriteRequest{
-					Timeseries: wreqs[endpoint].Timeseries,
-					Tenant:     tenant,
-					Replica:    int64(replicas[endpoint].n + 1), // increment replica since on-the-wire format is 1-indexed and 0 indicates unreplicated.
-				})
-				if err != nil {
-					level.Error(h.logger).Log("msg", "forwarding request", "err", err, "endpoint", endpoint)
-					ec <- err
-					return
-				}
-				ec <- nil
-			})
+			ec <- nil
 		}(endpoint)
 	}
 
@@ -436,15 +726,70 @@ func (h *Handler) parallelizeRequests(ctx context.Context, tenant string, replic
 	return errs.Err()
 }
 
+// writeRemote writes the write request to another receive node.
+func (h *Handler) writeRemote(ctx context.Context, endpoint string, r *storepb.WriteRequest) error {
+	cl, err := h.peers.get(ctx, endpoint)
+	if err != nil {
+		level.Error(h.logger).Log("msg", "failed to get peer connection to forward request", "err", err, "endpoint", endpoint)
+		return err
+	}
+	// Create a span to track the request made to another receive node.
+	tracing.DoInSpan(ctx, "receive_forward", func(ctx context.Context) {
+		err = h.peers.write(ctx, endpoint, cl, r)
+	})
+	if err != nil {
+		level.Error(h.logger).Log("msg", "forwarding request", "err", err, "endpoint", endpoint)
+	}
+	return err
+}
+
+// Replay sends a write request handed off for the given endpoint to it.
+// It implements HandoffSender.
+func (h *Handler) Replay(ctx context.Context, endpoint string, r *storepb.WriteRequest) error {
+	return h.writeRemote(ctx, endpoint, r)
+}
+
+// writeLocal writes the write request into the local storage.
+func (h *Handler) writeLocal(ctx context.Context, tenant string, wreq *prompb.WriteRequest) error {
+	var err error
+	tracing.DoInSpan(ctx, "receive_tsdb_write", func(ctx context.Context) {
+		err = h.writer.Write(tenant, wreq)
+	})
+
+	// When a MultiError is added to another MultiError, the error slices are concatenated, not nested.
+	// To avoid breaking the counting logic, we need to flatten the error.
+	if errs, ok := err.(terrors.MultiError); ok {
+		if countCause(errs, isConflict) > 0 {
+			err = errors.Wrap(conflictErr, errs.Error())
+		} else if countCause(errs, isNotReady) > 0 {
+			err = tsdb.ErrNotReady
+		} else {
+			err = errors.New(errs.Error())
+		}
+	}
+	return err
+}
+
+// ingest writes a write request already routed by a router into the local storage.
+// Replication is done by the routers, so every routed write request carries a replica number.
+func (h *Handler) ingest(ctx context.Context, r *storepb.WriteRequest) error {
+	if r.Replica == 0 {
+		return errNotRouted
+	}
+	if err := h.writeLocal(ctx, r.Tenant, &prompb.WriteRequest{Timeseries: r.Timeseries, Metadata: r.Metadata}); err != nil {
+		if countCause(err, isConflict) > 0 {
+			return conflictErr
+		}
+		return err
+	}
+	return nil
+}
+
 // replicate replicates a write request to (replication-factor) nodes
 // selected by the tenant and time series.
 // The function only returns when all replication requests have finished
 // or the context is canceled.
 func (h *Handler) replicate(ctx context.Context, tenant string, wreq *prompb.WriteRequest) error {
-	wreqs := make(map[string]*prompb.WriteRequest)
-	replicas := make(map[string]replica)
-	var i uint64
-
 	// It is possible that hashring is ready in testReady() but unready now,
 	// so need to lock here.
 	h.mtx.RLock()
@@ -453,18 +798,133 @@ func (h *Handler) replicate(ctx context.Context, tenant string, wreq *prompb.Wri
 		return errors.New("hashring is not ready")
 	}
 
-	for i = 0; i < h.options.ReplicationFactor; i++ {
-		endpoint, err := h.hashring.GetN(tenant, &wreq.Timeseries[0], i)
+	// Series sharing their first replica can still have their other replicas on different endpoints,
+	// e.g. with the ketama algorithm, so series are batched by all the endpoints of their replicas.
+	var (
+		groups []*replicaGroup
+		byKey  = make(map[string]*replicaGroup)
+	)
+	groupOf := func(ts *prompb.TimeSeries) (*replicaGroup, error) {
+		endpoints := make([]string, 0, h.options.ReplicationFactor)
+		for i := uint64(0); i < h.options.ReplicationFactor; i++ {
+			endpoint, err := h.hashring.GetN(tenant, ts, i)
+			if err != nil {
+				return nil, err
+			}
+			endpoints = append(endpoints, endpoint)
+		}
+		key := strings.Join(endpoints, ",")
+		g, ok := byKey[key]
+		if !ok {
+			g = &replicaGroup{
+				endpoints:    endpoints,
+				wreq:         &prompb.WriteRequest{},
+				dual:         make(map[string]*prompb.WriteRequest),
+				dualReplicas: make(map[string]replica),
+			}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		return g, nil
+	}
+
+	prevs := h.previousHashrings()
+	for j := range wreq.Timeseries {
+		g, err := groupOf(&wreq.Timeseries[j])
+		if err != nil {
+			h.mtx.RUnlock()
+			return err
+		}
+		g.wreq.Timeseries = append(g.wreq.Timeseries, wreq.Timeseries[j])
+		if len(prevs) > 0 {
+			h.addDualWrites(prevs, tenant, g, &wreq.Timeseries[j])
+		}
+	}
+	// Metadata is replicated along with the time series of its metric family.
+	for j := range wreq.Metadata {
+		g, err := groupOf(metadataSeries(&wreq.Metadata[j]))
 		if err != nil {
 			h.mtx.RUnlock()
 			return err
 		}
-		wreqs[endpoint] = wreq
-		replicas[endpoint] = replica{i, true}
+		g.wreq.Metadata = append(g.wreq.Metadata, wreq.Metadata[j])
 	}
 	h.mtx.RUnlock()
 
-	err := h.parallelizeRequests(ctx, tenant, replicas, wreqs)
+	if len(groups) == 1 {
+		return h.replicateGroup(ctx, tenant, groups[0])
+	}
+	errc := make(chan error, len(groups))
+	for _, g := range groups {
+		go func(g *replicaGroup) {
+			errc <- h.replicateGroup(ctx, tenant, g)
+		}(g)
+	}
+	// Every group meets its replication threshold on its own, so the classified error of a group is kept.
+	var ferr error
+	for range groups {
+		ferr = precedingError(ferr, <-errc)
+	}
+	return ferr
+}
+
+// replicaGroup is a batch of time series and metadata replicated to the same endpoints.
+type replicaGroup struct {
+	// endpoints holds the endpoint of every replica, by replica number.
+	endpoints []string
+	wreq      *prompb.WriteRequest
+	// Series moved by hashring changes within the transition period, by previous endpoint.
+	dual         map[string]*prompb.WriteRequest
+	dualReplicas map[string]replica
+}
+
+// addDualWrites adds the time series to the dual writes of the group if hashring changes within the transition period
+// moved any of its replicas. A series is written once to every previous endpoint, even if it held several of its
+// replicas or in several previous hashrings.
+func (h *Handler) addDualWrites(prevs []previousHashring, tenant string, g *replicaGroup, ts *prompb.TimeSeries) {
+	written := make(map[string]struct{}, h.options.ReplicationFactor)
+	for _, p := range prevs {
+		for i := uint64(0); i < h.options.ReplicationFactor; i++ {
+			endpoint, err := p.hashring.GetN(tenant, ts, i)
+			if err != nil {
+				continue
+			}
+			if _, ok := written[endpoint]; ok {
+				continue
+			}
+			written[endpoint] = struct{}{}
+			if containsEndpoint(g.endpoints, endpoint) {
+				continue
+			}
+			if _, ok := g.dual[endpoint]; !ok {
+				g.dual[endpoint] = &prompb.WriteRequest{}
+				g.dualReplicas[endpoint] = replica{i, true}
+			}
+			g.dual[endpoint].Timeseries = append(g.dual[endpoint].Timeseries, *ts)
+		}
+	}
+}
+
+func containsEndpoint(endpoints []string, endpoint string) bool {
+	for _, e := range endpoints {
+		if e == endpoint {
+			return true
+		}
+	}
+	return false
+}
+
+// replicateGroup writes the batch of the group to the endpoints of its replicas and checks that
+// the replication threshold is met.
+func (h *Handler) replicateGroup(ctx context.Context, tenant string, g *replicaGroup) error {
+	wreqs := make(map[string]*prompb.WriteRequest, len(g.endpoints))
+	replicas := make(map[string]replica, len(g.endpoints))
+	for i, endpoint := range g.endpoints {
+		wreqs[endpoint] = g.wreq
+		replicas[endpoint] = replica{uint64(i), true}
+	}
+
+	err := h.parallelizeWithDualWrites(ctx, tenant, replicas, wreqs, g.dualReplicas, g.dual)
 	if errs, ok := err.(terrors.MultiError); ok {
 		if uint64(countCause(errs, isNotReady)) >= (h.options.ReplicationFactor+1)/2 {
 			return tsdb.ErrNotReady
@@ -475,28 +935,89 @@ func (h *Handler) replicate(ctx context.Context, tenant string, wreq *prompb.Wri
 		if uint64(len(errs)) >= (h.options.ReplicationFactor+1)/2 {
 			return errors.Wrap(err, "did not meet replication threshold")
 		}
+		h.handoff(tenant, replicas, g.wreq, errs)
 		return nil
 	}
 	return errors.Wrap(err, "could not replicate write request")
 }
 
+// handoff queues the replicated write request for replicas which were unreachable,
+// so they catch up once they are back.
+func (h *Handler) handoff(tenant string, replicas map[string]replica, wreq *prompb.WriteRequest, errs terrors.MultiError) {
+	if h.options.Handoff == nil {
+		return
+	}
+	for _, err := range errs {
+		eerr, ok := err.(*endpointError)
+		if !ok || eerr.endpoint == h.options.Endpoint || !isUnreachable(eerr.err) {
+			continue
+		}
+		if err := h.options.Handoff.Add(eerr.endpoint, &storepb.WriteRequest{
+			Timeseries: wreq.Timeseries,
+			Metadata:   wreq.Metadata,
+			Tenant:     tenant,
+			Replica:    int64(replicas[eerr.endpoint].n + 1),
+		}); err != nil {
+			level.Warn(h.logger).Log("msg", "failed to hand off write request", "err", err, "endpoint", eerr.endpoint)
+		}
+	}
+}
+
 // RemoteWrite implements the gRPC remote write handler for storepb.WriteableStore.
+// Ingestors write the request into the local storage, other receivers route it over the hashring.
 func (h *Handler) RemoteWrite(ctx context.Context, r *storepb.WriteRequest) (*storepb.WriteResponse, error) {
-	err := h.handleRequest(ctx, uint64(r.Replica), r.Tenant, &prompb.WriteRequest{Timeseries: r.Timeseries})
+	if err := h.remoteWrite(ctx, r); err != nil {
+		return nil, err
+	}
+	return &storepb.WriteResponse{}, nil
+}
+
+// remoteWrite writes a write request received over gRPC. It returns a gRPC status error.
+func (h *Handler) remoteWrite(ctx context.Context, r *storepb.WriteRequest) error {
+	// Write requests forwarded by other receivers are not limited, as they hold a turn on the forwarding receiver.
+	if r.Replica == 0 {
+		done, err := h.limit(ctx)
+		if err != nil {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+		defer done()
+	}
+
+	var err error
+	if h.options.ReceiverMode.Routes() {
+		err = h.handleRequest(ctx, uint64(r.Replica), r.Tenant, &prompb.WriteRequest{Timeseries: r.Timeseries, Metadata: r.Metadata})
+	} else {
+		err = h.ingest(ctx, r)
+	}
 	switch err {
 	case nil:
-		return &storepb.WriteResponse{}, nil
+		return nil
 	case tsdb.ErrNotReady:
-		return nil, status.Error(codes.Unavailable, err.Error())
+		return status.Error(codes.Unavailable, err.Error())
 	case conflictErr:
-		return nil, status.Error(codes.AlreadyExists, err.Error())
-	case errBadReplica:
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errBadReplica, errNotRouted:
+		return status.Error(codes.InvalidArgument, err.Error())
 	default:
-		return nil, status.Error(codes.Internal, err.Error())
+		return status.Error(codes.Internal, err.Error())
 	}
 }
 
+// endpointError is an error of a write request to the endpoint.
+type endpointError struct {
+	endpoint string
+	err      error
+}
+
+func (e *endpointError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the error of the write request, so countCause sees through endpointError.
+func (e *endpointError) Cause() error {
+	return e.err
+}
+
 // countCause counts the number of errors within the given error
 // whose causes satisfy the given function.
 // countCause will inspect the error's cause or, if the error is a MultiError,
@@ -534,19 +1055,27 @@ func isNotReady(err error) bool {
 		status.Code(err) == codes.Unavailable
 }
 
-func newPeerGroup(dialOpts ...grpc.DialOption) *peerGroup {
+func newPeerGroup(compression storepb.Compression, dialOpts ...grpc.DialOption) *peerGroup {
 	return &peerGroup{
-		dialOpts: dialOpts,
-		cache:    map[string]storepb.WriteableStoreClient{},
-		m:        sync.RWMutex{},
-		dialer:   grpc.DialContext,
+		dialOpts:    dialOpts,
+		compression: compression,
+		cache:       map[string]storepb.WriteableStoreClient{},
+		streams:     map[string]*writeStream{},
+		unary:       map[string]struct{}{},
+		m:           sync.RWMutex{},
+		dialer:      grpc.DialContext,
 	}
 }
 
 type peerGroup struct {
-	dialOpts []grpc.DialOption
-	cache    map[string]storepb.WriteableStoreClient
-	m        sync.RWMutex
+	dialOpts    []grpc.DialOption
+	compression storepb.Compression
+	cache       map[string]storepb.WriteableStoreClient
+	// streams holds the write streams to peers.
+	streams map[string]*writeStream
+	// unary holds the peers which do not support write streams, which are written to with unary requests.
+	unary map[string]struct{}
+	m     sync.RWMutex
 
 	// dialer is used for testing.
 	dialer func(ctx context.Context, target string, opts ...grpc.DialOption) (conn *grpc.ClientConn, err error)
@@ -577,3 +1106,52 @@ func (p *peerGroup) get(ctx context.Context, addr string) (storepb.WriteableStor
 	p.cache[addr] = client
 	return client, nil
 }
+
+// write writes the write request to the peer over its write stream, opening the stream if there is none or it broke.
+// Peers which do not support write streams are written to with unary requests.
+func (p *peerGroup) write(ctx context.Context, addr string, c storepb.WriteableStoreClient, r *storepb.WriteRequest) error {
+	p.m.RLock()
+	_, unary := p.unary[addr]
+	s, ok := p.streams[addr]
+	p.m.RUnlock()
+	if unary {
+		_, err := c.RemoteWrite(ctx, r)
+		return err
+	}
+
+	var err error
+	if !ok || s.broken() {
+		s, err = p.openStream(addr, c)
+	}
+	if err == nil {
+		err = s.write(ctx, r, p.compression)
+	}
+	// Nothing was written if write streams are unimplemented, so the request is sent again.
+	if status.Code(err) == codes.Unimplemented {
+		p.m.Lock()
+		p.unary[addr] = struct{}{}
+		delete(p.streams, addr)
+		p.m.Unlock()
+		_, err = c.RemoteWrite(ctx, r)
+	}
+	return err
+}
+
+// openStream opens a write stream to the peer, replacing its broken stream.
+func (p *peerGroup) openStream(addr string, c storepb.WriteableStoreClient) (*writeStream, error) {
+	// Opening the stream waits for the connection, so it is done without holding the lock.
+	s, err := newWriteStream(c)
+	if err != nil {
+		return nil, err
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+	// Another request may have opened a stream in the meantime.
+	if cur, ok := p.streams[addr]; ok && !cur.broken() {
+		s.close()
+		return cur, nil
+	}
+	p.streams[addr] = s
+	return s, nil
+}
@@ -6,24 +6,36 @@ package receive
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/gogo/protobuf/proto"
 	"github.com/golang/snappy"
 	"github.com/pkg/errors"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/tsdb"
 	terrors "github.com/prometheus/prometheus/tsdb/errors"
+	"github.com/thanos-io/thanos/pkg/runutil"
 	"github.com/thanos-io/thanos/pkg/store/storepb"
 	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestCountCause(t *testing.T) {
@@ -150,6 +162,8 @@ func newHandlerHashring(appendables []*fakeAppendable, replicationFactor uint64)
 		dialOpts: nil,
 		m:        sync.RWMutex{},
 		cache:    map[string]storepb.WriteableStoreClient{},
+		streams:  map[string]*writeStream{},
+		unary:    map[string]struct{}{},
 		dialer: func(context.Context, string, ...grpc.DialOption) (*grpc.ClientConn, error) {
 			// dialer should never be called since we are creating fake clients with fake addresses
 			// this protects against some leaking test that may attempt to dial random IP addresses
@@ -515,6 +529,565 @@ func TestReceive(t *testing.T) {
 	}
 }
 
+func TestReceiveModes(t *testing.T) {
+	wreq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "foo", Value: "bar"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1}, {Value: 2, Timestamp: 2}},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "foo", Value: "baz"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+			},
+		},
+	}
+	appendables := []*fakeAppendable{
+		{appender: newFakeAppender(nil, nil, nil, nil)},
+		{appender: newFakeAppender(nil, nil, nil, nil)},
+	}
+	ingestors, hashring := newHandlerHashring(appendables, 1)
+	for _, h := range ingestors {
+		h.options.ReceiverMode = IngestorOnly
+		h.Hashring(nil)
+	}
+
+	// The endpoint of the router is in the hashring, but routers never store locally.
+	router := NewHandler(nil, &Options{
+		TenantHeader:      DefaultTenantHeader,
+		ReplicaHeader:     DefaultReplicaHeader,
+		ReplicationFactor: 1,
+		ReceiverMode:      RouterOnly,
+		Endpoint:          ingestors[0].options.Endpoint,
+	})
+	router.peers = ingestors[0].peers
+	if router.isReady() {
+		t.Fatal("router without hashring unexpectedly ready")
+	}
+	router.Hashring(hashring)
+
+	rec, err := makeRequest(router, "test", wreq)
+	if err != nil {
+		t.Fatalf("unexpectedly failed making HTTP request: %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got unexpected HTTP status code: expected %d, got %d; body: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	for _, ts := range wreq.Timeseries {
+		lset := labels.Labels{{Name: ts.Labels[0].Name, Value: ts.Labels[0].Value}}
+		for j, a := range appendables {
+			var expected int
+			if endpointHit(t, hashring, 1, ingestors[j].options.Endpoint, "test", &ts) {
+				expected = len(ts.Samples)
+			}
+			if got := len(a.appender.(*fakeAppender).samples[lset.String()]); expected != got {
+				t.Errorf("ingestor: %d, labels %q: expected %d samples, got %d", j, lset.String(), expected, got)
+			}
+		}
+	}
+
+	// Ingestors write routed requests without a hashring and reject write requests which were not routed.
+	for i, h := range ingestors {
+		if !h.isReady() {
+			t.Fatalf("ingestor %d without hashring unexpectedly not ready", i)
+		}
+		if _, err := h.RemoteWrite(context.Background(), &storepb.WriteRequest{Timeseries: wreq.Timeseries, Tenant: "test", Replica: 1}); err != nil {
+			t.Errorf("ingestor %d: unexpected error writing routed request: %v", i, err)
+		}
+		if _, err := h.RemoteWrite(context.Background(), &storepb.WriteRequest{Timeseries: wreq.Timeseries, Tenant: "test"}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("ingestor %d: expected invalid argument error writing request which was not routed, got %v", i, err)
+		}
+	}
+	for j, a := range appendables {
+		lset := labels.Labels{{Name: "foo", Value: "baz"}}
+		var expected int
+		if endpointHit(t, hashring, 1, ingestors[j].options.Endpoint, "test", &wreq.Timeseries[1]) {
+			expected = 1
+		}
+		if got := len(a.appender.(*fakeAppender).samples[lset.String()]); expected+1 != got {
+			t.Errorf("ingestor: %d, labels %q: expected %d samples, got %d", j, lset.String(), expected+1, got)
+		}
+	}
+}
+
+func TestReceiveHintedHandoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "receive-handoff")
+	if err != nil {
+		t.Fatalf("unexpectedly failed creating the temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	wreq := func(samples ...prompb.Sample) *prompb.WriteRequest {
+		return &prompb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{
+				{
+					Labels:  []prompb.Label{{Name: "foo", Value: "bar"}},
+					Samples: samples,
+				},
+			},
+		}
+	}
+	appendables := []*fakeAppendable{
+		{appender: newFakeAppender(nil, nil, nil, nil)},
+		{appender: newFakeAppender(nil, nil, nil, nil)},
+		{appender: newFakeAppender(nil, nil, nil, nil)},
+	}
+	handlers, _ := newHandlerHashring(appendables, 3)
+	q, err := NewHandoffQueue(nil, nil, filepath.Join(dir, "handoff"), 1<<20, time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpectedly failed creating the queue: %v", err)
+	}
+	handlers[0].options.Handoff = q
+
+	// The third replica writes into a TSDB, which rejects samples older than the ones it has.
+	m := newTestMultiTSDB(filepath.Join(dir, "tsdb"), nil, 0, false, nil)
+	defer func() {
+		if err := m.Flush(); err != nil {
+			t.Errorf("unexpectedly failed flushing the TSDB: %v", err)
+		}
+	}()
+	tenant, err := m.getOrLoadTenant("test", true)
+	if err != nil {
+		t.Fatalf("unexpectedly failed starting the tenant TSDB: %v", err)
+	}
+	handlers[2].writer = NewWriter(log.NewNopLogger(), m)
+
+	// The third replica is unreachable, but the quorum of two replicas is met.
+	down := handlers[2].options.Endpoint
+	peer := handlers[0].peers.cache[down]
+	handlers[0].peers.cache[down] = &fakeUnavailableWriteClient{}
+
+	rec, err := makeRequest(handlers[0], "test", wreq(prompb.Sample{Value: 1, Timestamp: 1}, prompb.Sample{Value: 2, Timestamp: 2}))
+	if err != nil {
+		t.Fatalf("unexpectedly failed making HTTP request: %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got unexpected HTTP status code: expected %d, got %d; body: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	if !q.Pending(down) {
+		t.Fatal("expected hints for the unreachable replica")
+	}
+
+	// Once the replica is back, newer samples are queued behind the hints, so the missed samples are not rejected
+	// as out of order.
+	handlers[0].peers.cache[down] = peer
+	rec, err = makeRequest(handlers[0], "test", wreq(prompb.Sample{Value: 3, Timestamp: 3}))
+	if err != nil {
+		t.Fatalf("unexpectedly failed making HTTP request: %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got unexpected HTTP status code: expected %d, got %d; body: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	q.replay(context.Background(), handlers[0].Replay)
+	if q.Pending(down) {
+		t.Error("expected all hints to be replayed")
+	}
+	if got := promtestutil.ToFloat64(q.droppedSamples.WithLabelValues("rejected")); got != 0 {
+		t.Errorf("expected no rejected samples, got %v", got)
+	}
+
+	lset := labels.Labels{{Name: "foo", Value: "bar"}}
+	for i, a := range appendables[:2] {
+		if got := len(a.appender.(*fakeAppender).samples[lset.String()]); got != 3 {
+			t.Errorf("replica %d: expected 3 samples, got %d", i, got)
+		}
+	}
+	querier, err := tenant.readyStorage().Querier(context.Background(), 0, 10)
+	if err != nil {
+		t.Fatalf("unexpectedly failed querying the TSDB: %v", err)
+	}
+	defer querier.Close()
+	ss, _, err := querier.Select(nil, labels.MustNewMatcher(labels.MatchEqual, "foo", "bar"))
+	if err != nil {
+		t.Fatalf("unexpectedly failed selecting series: %v", err)
+	}
+	var timestamps []int64
+	for ss.Next() {
+		it := ss.At().Iterator()
+		for it.Next() {
+			ts, _ := it.At()
+			timestamps = append(timestamps, ts)
+		}
+	}
+	if exp := []int64{1, 2, 3}; !reflect.DeepEqual(timestamps, exp) {
+		t.Errorf("replica 2: expected samples at %v, got %v", exp, timestamps)
+	}
+}
+
+func TestReceiveHashringTransition(t *testing.T) {
+	for _, tc := range []struct {
+		name              string
+		replicationFactor uint64
+		// Endpoints of the hashrings, oldest first. The last one is the current hashring.
+		endpoints []int
+	}{
+		{name: "scale up", replicationFactor: 1, endpoints: []int{3, 4}},
+		{name: "scale down", replicationFactor: 1, endpoints: []int{3, 2}},
+		{name: "scale down with replication", replicationFactor: 3, endpoints: []int{4, 3}},
+		{name: "successive changes", replicationFactor: 1, endpoints: []int{4, 2, 3}},
+		{name: "successive changes with replication", replicationFactor: 3, endpoints: []int{3, 4, 3}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			appendables := make([]*fakeAppendable, 4)
+			for i := range appendables {
+				appendables[i] = &fakeAppendable{appender: newFakeAppender(nil, nil, nil, nil)}
+			}
+			handlers, _ := newHandlerHashring(appendables, tc.replicationFactor)
+			hashrings := make([]Hashring, len(tc.endpoints))
+			for i, n := range tc.endpoints {
+				cfg := []HashringConfig{{Hashring: "test"}}
+				for _, h := range handlers[:n] {
+					cfg[0].Endpoints = append(cfg[0].Endpoints, h.options.Endpoint)
+				}
+				hashrings[i] = newMultiHashring(cfg)
+			}
+			for _, h := range handlers {
+				// Drop the hashring of newHandlerHashring, so it is not kept as a previous one.
+				h.Hashring(nil)
+				h.options.HashringTransition = time.Hour
+				for _, hashring := range hashrings {
+					h.Hashring(hashring)
+				}
+			}
+			hit := func(hashring int, handler int, ts *prompb.TimeSeries) bool {
+				return handler < tc.endpoints[hashring] && endpointHit(t, hashrings[hashring], tc.replicationFactor, handlers[handler].options.Endpoint, "tenant", ts)
+			}
+
+			// Every phase expires one more previous hashring, oldest first. Series are written to their current
+			// endpoints and to the endpoints they had in the previous hashrings still within their transition period.
+			var moved int
+			for expired := 0; expired < len(hashrings); expired++ {
+				wreq := &prompb.WriteRequest{}
+				timestamp := int64(expired + 1)
+				for i := 0; i < 20; i++ {
+					wreq.Timeseries = append(wreq.Timeseries, prompb.TimeSeries{
+						Labels:  []prompb.Label{{Name: "foo", Value: strconv.Itoa(i)}},
+						Samples: []prompb.Sample{{Value: 1, Timestamp: timestamp}},
+					})
+				}
+				rec, err := makeRequest(handlers[0], "tenant", wreq)
+				if err != nil {
+					t.Fatalf("unexpectedly failed making HTTP request: %v", err)
+				}
+				if rec.Code != http.StatusOK {
+					t.Fatalf("got unexpected HTTP status code: expected %d, got %d; body: %s", http.StatusOK, rec.Code, rec.Body.String())
+				}
+
+				expected := make([][]int, len(wreq.Timeseries))
+				for i := range wreq.Timeseries {
+					expected[i] = make([]int, len(appendables))
+					for j := range appendables {
+						hitNext := hit(len(hashrings)-1, j, &wreq.Timeseries[i])
+						var hitPrev bool
+						for k := expired; k < len(hashrings)-1; k++ {
+							hitPrev = hitPrev || hit(k, j, &wreq.Timeseries[i])
+						}
+						if hitPrev && !hitNext {
+							moved++
+						}
+						if hitNext || hitPrev {
+							expected[i][j] = 1
+						}
+					}
+				}
+				if expired == 0 && moved == 0 {
+					t.Fatal("expected series to be moved by the hashring changes")
+				}
+
+				// Dual-writes go on in the background, so wait for them.
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				err = runutil.Retry(10*time.Millisecond, ctx.Done(), func() error {
+					for i := range wreq.Timeseries {
+						lset := labels.Labels{{Name: "foo", Value: wreq.Timeseries[i].Labels[0].Value}}
+						for j, a := range appendables {
+							app := a.appender.(*fakeAppender)
+							app.Lock()
+							var got int
+							for _, s := range app.samples[lset.String()] {
+								if s.Timestamp == timestamp {
+									got++
+								}
+							}
+							app.Unlock()
+							if got != expected[i][j] {
+								return errors.Errorf("handler %d, labels %q: expected %d samples at %d, got %d", j, lset.String(), expected[i][j], timestamp, got)
+							}
+						}
+					}
+					if got := int(promtestutil.ToFloat64(handlers[0].dualWrittenSeriesTotal)); got != moved {
+						return errors.Errorf("expected %d dual-written series, got %d", moved, got)
+					}
+					return nil
+				})
+				cancel()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if expired < len(hashrings)-1 {
+					for _, h := range handlers {
+						h.mtx.Lock()
+						h.previous[len(h.previous)-1-expired].end = time.Now().Add(-time.Second)
+						h.mtx.Unlock()
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestReceiveKetamaReplication(t *testing.T) {
+	appendables := make([]*fakeAppendable, 5)
+	for i := range appendables {
+		appendables[i] = &fakeAppendable{appender: newFakeAppender(nil, nil, nil, nil)}
+	}
+	handlers, _ := newHandlerHashring(appendables, 3)
+	cfg := []HashringConfig{{Hashring: "test", Algorithm: AlgorithmKetama}}
+	for _, h := range handlers {
+		cfg[0].Endpoints = append(cfg[0].Endpoints, h.options.Endpoint)
+	}
+	hashring := newMultiHashring(cfg)
+	for _, h := range handlers {
+		h.Hashring(hashring)
+	}
+
+	wreq := &prompb.WriteRequest{}
+	for i := 0; i < 50; i++ {
+		wreq.Timeseries = append(wreq.Timeseries, prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "foo", Value: strconv.Itoa(i)}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+		})
+	}
+	rec, err := makeRequest(handlers[0], "tenant", wreq)
+	if err != nil {
+		t.Fatalf("unexpectedly failed making HTTP request: %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got unexpected HTTP status code: expected %d, got %d; body: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	// Every series is written to the endpoints of all its replicas only, even if these differ
+	// from the ones of other series sharing its first replica.
+	for i := range wreq.Timeseries {
+		ts := &wreq.Timeseries[i]
+		lset := labels.Labels{{Name: "foo", Value: ts.Labels[0].Value}}
+		for j, a := range appendables {
+			expected := 0
+			if endpointHit(t, hashring, 3, handlers[j].options.Endpoint, "tenant", ts) {
+				expected = 1
+			}
+			if got := len(a.appender.(*fakeAppender).samples[lset.String()]); got != expected {
+				t.Errorf("handler %d, labels %q: expected %d samples, got %d", j, lset.String(), expected, got)
+			}
+		}
+	}
+}
+
+func TestReceiveKetamaReplicationErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		appendable func() *fakeAppendable
+		status     int
+	}{
+		{
+			name: "not ready",
+			appendable: func() *fakeAppendable {
+				return &fakeAppendable{
+					appender:    newFakeAppender(nil, nil, nil, nil),
+					appenderErr: func() error { return tsdb.ErrNotReady },
+				}
+			},
+			status: http.StatusServiceUnavailable,
+		},
+		{
+			name: "conflict",
+			appendable: func() *fakeAppendable {
+				return &fakeAppendable{appender: newFakeAppender(func() error { return storage.ErrOutOfBounds }, nil, nil, nil)}
+			},
+			status: http.StatusConflict,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			appendables := make([]*fakeAppendable, 5)
+			for i := range appendables {
+				appendables[i] = tc.appendable()
+			}
+			handlers, _ := newHandlerHashring(appendables, 3)
+			cfg := []HashringConfig{{Hashring: "test", Algorithm: AlgorithmKetama}}
+			for _, h := range handlers {
+				cfg[0].Endpoints = append(cfg[0].Endpoints, h.options.Endpoint)
+			}
+			hashring := newMultiHashring(cfg)
+			for _, h := range handlers {
+				h.Hashring(hashring)
+			}
+
+			// Series are split into several replica groups, whose errors keep their status.
+			wreq := &prompb.WriteRequest{}
+			for i := 0; i < 50; i++ {
+				wreq.Timeseries = append(wreq.Timeseries, prompb.TimeSeries{
+					Labels:  []prompb.Label{{Name: "foo", Value: strconv.Itoa(i)}},
+					Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+				})
+			}
+			rec, err := makeRequest(handlers[0], "tenant", wreq)
+			if err != nil {
+				t.Fatalf("unexpectedly failed making HTTP request: %v", err)
+			}
+			if rec.Code != tc.status {
+				t.Errorf("got unexpected HTTP status code: expected %d, got %d; body: %s", tc.status, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestReceiveMetadata(t *testing.T) {
+	appendables := []*fakeAppendable{
+		{appender: newFakeAppender(nil, nil, nil, nil)},
+		{appender: newFakeAppender(nil, nil, nil, nil)},
+		{appender: newFakeAppender(nil, nil, nil, nil)},
+	}
+	handlers, hashring := newHandlerHashring(appendables, 1)
+
+	metadata := []prompb.MetricMetadata{
+		{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "requests_total", Help: "The number of requests."},
+		{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "temperature", Unit: "celsius"},
+	}
+	// Requests holding only metadata are accepted too.
+	rec, err := makeRequest(handlers[0], "test", &prompb.WriteRequest{Metadata: metadata})
+	if err != nil {
+		t.Fatalf("unexpectedly failed making HTTP request: %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got unexpected HTTP status code: expected %d, got %d; body: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	for _, m := range metadata {
+		for j, a := range appendables {
+			var expected int
+			if endpointHit(t, hashring, 1, handlers[j].options.Endpoint, "test", metadataSeries(&m)) {
+				expected = 1
+			}
+			var got int
+			for _, am := range a.metadata {
+				if am == m {
+					got++
+				}
+			}
+			if expected != got {
+				t.Errorf("handler: %d, metric family %q: expected %d metadata, got %d", j, m.MetricFamilyName, expected, got)
+			}
+		}
+	}
+
+	// Pushed metrics are stored with the labels of the push path, along with their metadata.
+	body := "# TYPE temperature gauge\ntemperature{instance=\"other\"} 21.5 2000\n"
+	req, err := http.NewRequest("PUT", "/api/v1/push/metrics/job/test/instance/host", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpectedly failed creating HTTP request: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	handlers[0].router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got unexpected HTTP status code: expected %d, got %d; body: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+	ts := prompb.TimeSeries{Labels: []prompb.Label{{Name: "__name__", Value: "temperature"}, {Name: "instance", Value: "host"}, {Name: "job", Value: "test"}}}
+	lset := labels.FromStrings("__name__", "temperature", "instance", "host", "job", "test")
+	for j, a := range appendables {
+		var expected int
+		if endpointHit(t, hashring, 1, handlers[j].options.Endpoint, "test", &ts) {
+			expected = 1
+		}
+		if got := len(a.appender.(*fakeAppender).samples[lset.String()]); expected != got {
+			t.Errorf("handler: %d, labels %q: expected %d samples, got %d", j, lset.String(), expected, got)
+		}
+	}
+
+	req, err = http.NewRequest("POST", "/api/v1/push/metrics/instance/host", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpectedly failed creating HTTP request: %v", err)
+	}
+	rec = httptest.NewRecorder()
+	handlers[0].router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("got unexpected HTTP status code pushing without job: expected %d, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestReceiveRelabel(t *testing.T) {
+	appendables := []*fakeAppendable{
+		{appender: newFakeAppender(nil, nil, nil, nil)},
+		{appender: newFakeAppender(nil, nil, nil, nil)},
+	}
+	handlers, _ := newHandlerHashring(appendables, 1)
+
+	var cfgs RelabelConfigs
+	if err := json.Unmarshal([]byte(`[
+		{"source_labels": ["team"], "target_label": "__tenant__"},
+		{"action": "labeldrop", "regex": "team"},
+		{"source_labels": ["__name__"], "regex": "debug_.*", "action": "drop"}
+	]`), &cfgs); err != nil {
+		t.Fatalf("unexpectedly failed parsing relabel configs: %v", err)
+	}
+	// Time series of team a move to the hashring of tenant a, the others stay in the default hashring.
+	hashring := newMultiHashring([]HashringConfig{
+		{Hashring: "a", Tenants: []string{"a"}, Endpoints: []string{handlers[1].options.Endpoint}},
+		{Hashring: "default", Endpoints: []string{handlers[0].options.Endpoint}, RelabelConfigs: cfgs},
+	})
+	for _, h := range handlers {
+		h.Hashring(hashring)
+	}
+
+	wreq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "team", Value: "a"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "instance", Value: "b"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1}, {Value: 1, Timestamp: 2}},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "debug_info"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+			},
+		},
+	}
+	rec, err := makeRequest(handlers[0], "default", wreq)
+	if err != nil {
+		t.Fatalf("unexpectedly failed making HTTP request: %v", err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got unexpected HTTP status code: expected %d, got %d; body: %s", http.StatusOK, rec.Code, rec.Body.String())
+	}
+
+	for _, tc := range []struct {
+		lset     labels.Labels
+		expected []int
+	}{
+		{lset: labels.FromStrings("__name__", "up"), expected: []int{0, 1}},
+		{lset: labels.FromStrings("__name__", "up", "team", "a"), expected: []int{0, 0}},
+		{lset: labels.FromStrings("__name__", "up", "instance", "b"), expected: []int{2, 0}},
+		{lset: labels.FromStrings("__name__", "debug_info"), expected: []int{0, 0}},
+	} {
+		for j, a := range appendables {
+			if got := len(a.appender.(*fakeAppender).samples[tc.lset.String()]); tc.expected[j] != got {
+				t.Errorf("handler: %d, labels %q: expected %d samples, got %d", j, tc.lset.String(), tc.expected[j], got)
+			}
+		}
+	}
+}
+
+type fakeUnavailableWriteClient struct{}
+
+func (f *fakeUnavailableWriteClient) RemoteWrite(context.Context, *storepb.WriteRequest, ...grpc.CallOption) (*storepb.WriteResponse, error) {
+	return nil, status.Error(codes.Unavailable, "connection refused")
+}
+
+func (f *fakeUnavailableWriteClient) RemoteWriteStream(context.Context, ...grpc.CallOption) (storepb.WriteableStore_RemoteWriteStreamClient, error) {
+	return nil, status.Error(codes.Unavailable, "connection refused")
+}
+
 // endpointHit is a helper to determine if a given endpoint in a hashring would be selected
 // for a given time series, tenant, and replication factor.
 func endpointHit(t *testing.T, h Hashring, rf uint64, endpoint, tenant string, timeSeries *prompb.TimeSeries) bool {
@@ -576,3 +1149,8 @@ type fakeRemoteWriteGRPCServer struct {
 func (f *fakeRemoteWriteGRPCServer) RemoteWrite(ctx context.Context, in *storepb.WriteRequest, opts ...grpc.CallOption) (*storepb.WriteResponse, error) {
 	return f.h.RemoteWrite(ctx, in)
 }
+
+// RemoteWriteStream is unimplemented, so write requests fall back to RemoteWrite.
+func (f *fakeRemoteWriteGRPCServer) RemoteWriteStream(context.Context, ...grpc.CallOption) (storepb.WriteableStore_RemoteWriteStreamClient, error) {
+	return nil, status.Error(codes.Unimplemented, "unimplemented")
+}
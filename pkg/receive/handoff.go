@@ -0,0 +1,298 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	hintExt    = ".hint"
+	hintTmpExt = ".tmp"
+)
+
+// HandoffSender sends a write request handed off for the given endpoint to it.
+type HandoffSender func(ctx context.Context, endpoint string, r *storepb.WriteRequest) error
+
+// HandoffQueue is a bounded on-disk queue of hints: write requests which replica endpoints missed while they were
+// unreachable, even though the replication quorum was met. Hints are replayed to their endpoints once they are back.
+// Every hint is stored in its own file, named by a ULID of the time it was queued, in a directory of its endpoint.
+// Write requests for endpoints with pending hints are queued behind them, so endpoints receive samples in order.
+type HandoffQueue struct {
+	logger        log.Logger
+	dir           string
+	maxSize       int64
+	maxAge        time.Duration
+	retryInterval time.Duration
+
+	mtx     sync.Mutex
+	size    int64
+	entropy io.Reader
+	// pending holds the number of queued hints by endpoint.
+	pending map[string]int
+
+	queuedSamples   prometheus.Counter
+	replayedSamples prometheus.Counter
+	droppedSamples  *prometheus.CounterVec
+	queueSize       prometheus.Gauge
+}
+
+// NewHandoffQueue creates a new HandoffQueue storing hints in the given directory. Hints beyond maxSize bytes are
+// dropped, as are hints older than maxAge. Replay is retried every retryInterval.
+func NewHandoffQueue(logger log.Logger, reg prometheus.Registerer, dir string, maxSize int64, maxAge, retryInterval time.Duration) (*HandoffQueue, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		return nil, errors.Wrap(err, "create hinted handoff dir")
+	}
+
+	q := &HandoffQueue{
+		logger:        logger,
+		dir:           dir,
+		maxSize:       maxSize,
+		maxAge:        maxAge,
+		retryInterval: retryInterval,
+		entropy:       ulid.Monotonic(rand.New(rand.NewSource(time.Now().UnixNano())), 0),
+		pending:       map[string]int{},
+		queuedSamples: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_receive_hinted_handoff_queued_samples_total",
+			Help: "The number of samples queued for replicas which missed them.",
+		}),
+		replayedSamples: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_receive_hinted_handoff_replayed_samples_total",
+			Help: "The number of queued samples replayed to their replicas.",
+		}),
+		droppedSamples: promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+			Name: "thanos_receive_hinted_handoff_dropped_samples_total",
+			Help: "The number of samples dropped instead of being queued or replayed.",
+		}, []string{"reason"}),
+		queueSize: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_receive_hinted_handoff_queue_bytes",
+			Help: "The size of the hinted handoff queue on disk.",
+		}),
+	}
+	for _, reason := range []string{"full", "expired", "rejected", "error"} {
+		q.droppedSamples.WithLabelValues(reason)
+	}
+
+	// Account hints queued before a restart and remove leftovers of interrupted writes.
+	if err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch filepath.Ext(path) {
+		case hintTmpExt:
+			return os.Remove(path)
+		case hintExt:
+			q.size += info.Size()
+			if endpoint, err := hex.DecodeString(filepath.Base(filepath.Dir(path))); err == nil {
+				q.pending[string(endpoint)]++
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, errors.Wrap(err, "load hinted handoff queue")
+	}
+	q.queueSize.Set(float64(q.size))
+	return q, nil
+}
+
+// Add queues the write request for the endpoint, unless the queue is full.
+func (q *HandoffQueue) Add(endpoint string, r *storepb.WriteRequest) error {
+	samples := countSamples(r)
+	b, err := r.Marshal()
+	if err != nil {
+		q.droppedSamples.WithLabelValues("error").Add(float64(samples))
+		return errors.Wrap(err, "marshal hint")
+	}
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	if q.size+int64(len(b)) > q.maxSize {
+		q.droppedSamples.WithLabelValues("full").Add(float64(samples))
+		return errors.Errorf("hinted handoff queue is full, dropping %d samples for %s", samples, endpoint)
+	}
+
+	if err := q.write(endpoint, b); err != nil {
+		q.droppedSamples.WithLabelValues("error").Add(float64(samples))
+		return err
+	}
+	q.size += int64(len(b))
+	q.pending[endpoint]++
+	q.queueSize.Set(float64(q.size))
+	q.queuedSamples.Add(float64(samples))
+	return nil
+}
+
+// Pending returns true if hints for the endpoint are queued. Write requests for the endpoint have to be queued
+// behind them, as the endpoint rejects samples older than the ones it already has.
+func (q *HandoffQueue) Pending(endpoint string) bool {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	return q.pending[endpoint] > 0
+}
+
+// write stores the hint atomically, so replay never sees partial hints.
+func (q *HandoffQueue) write(endpoint string, b []byte) error {
+	edir := filepath.Join(q.dir, hex.EncodeToString([]byte(endpoint)))
+	if err := os.MkdirAll(edir, 0777); err != nil {
+		return errors.Wrap(err, "create hint dir")
+	}
+
+	id, err := ulid.New(ulid.Now(), q.entropy)
+	if err != nil {
+		return errors.Wrap(err, "create hint ID")
+	}
+	path := filepath.Join(edir, id.String()+hintExt)
+	if err := ioutil.WriteFile(path+hintTmpExt, b, 0666); err != nil {
+		return errors.Wrap(err, "write hint")
+	}
+	return errors.Wrap(os.Rename(path+hintTmpExt, path), "rename hint")
+}
+
+// Run replays queued hints to their endpoints every retry interval until the context is cancelled.
+func (q *HandoffQueue) Run(ctx context.Context, send HandoffSender) error {
+	return runutil.Repeat(q.retryInterval, ctx.Done(), func() error {
+		q.replay(ctx, send)
+		return nil
+	})
+}
+
+// replay replays hints of every endpoint in parallel.
+func (q *HandoffQueue) replay(ctx context.Context, send HandoffSender) {
+	dirs, err := ioutil.ReadDir(q.dir)
+	if err != nil {
+		level.Error(q.logger).Log("msg", "failed to list hinted handoff queue", "err", err)
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, d := range dirs {
+		endpoint, err := hex.DecodeString(d.Name())
+		if !d.IsDir() || err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func(endpoint string, edir string) {
+			defer wg.Done()
+			q.replayEndpoint(ctx, send, endpoint, edir)
+		}(string(endpoint), filepath.Join(q.dir, d.Name()))
+	}
+	wg.Wait()
+}
+
+// replayEndpoint replays hints of the endpoint oldest first. It stops at the first hint that fails to be sent,
+// so it is retried together with the later ones in the next round.
+func (q *HandoffQueue) replayEndpoint(ctx context.Context, send HandoffSender, endpoint, edir string) {
+	files, err := ioutil.ReadDir(edir)
+	if err != nil {
+		level.Error(q.logger).Log("msg", "failed to list hints", "endpoint", endpoint, "err", err)
+		return
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+
+	for _, f := range files {
+		if filepath.Ext(f.Name()) != hintExt {
+			continue
+		}
+		path := filepath.Join(edir, f.Name())
+		id, err := ulid.Parse(strings.TrimSuffix(f.Name(), hintExt))
+		if err != nil {
+			level.Warn(q.logger).Log("msg", "removing hint with invalid name", "path", path)
+			q.remove(endpoint, path, f.Size())
+			continue
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			level.Error(q.logger).Log("msg", "failed to read hint", "path", path, "err", err)
+			return
+		}
+		var r storepb.WriteRequest
+		if err := r.Unmarshal(b); err != nil {
+			level.Warn(q.logger).Log("msg", "removing corrupted hint", "path", path, "err", err)
+			q.remove(endpoint, path, f.Size())
+			continue
+		}
+		samples := float64(countSamples(&r))
+
+		if time.Since(ulid.Time(id.Time())) > q.maxAge {
+			q.droppedSamples.WithLabelValues("expired").Add(samples)
+			q.remove(endpoint, path, f.Size())
+			continue
+		}
+
+		sctx, cancel := context.WithTimeout(ctx, q.retryInterval)
+		err = send(sctx, endpoint, &r)
+		cancel()
+		if err != nil && retryableHint(err) {
+			level.Debug(q.logger).Log("msg", "failed to replay hint, retrying later", "endpoint", endpoint, "err", err)
+			return
+		}
+		if err != nil {
+			level.Warn(q.logger).Log("msg", "endpoint rejected hint, dropping it", "endpoint", endpoint, "err", err)
+			q.droppedSamples.WithLabelValues("rejected").Add(samples)
+		} else {
+			q.replayedSamples.Add(samples)
+		}
+		q.remove(endpoint, path, f.Size())
+	}
+}
+
+func (q *HandoffQueue) remove(endpoint, path string, size int64) {
+	if err := os.Remove(path); err != nil {
+		level.Error(q.logger).Log("msg", "failed to remove hint", "path", path, "err", err)
+		return
+	}
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	q.size -= size
+	q.pending[endpoint]--
+	if q.pending[endpoint] <= 0 {
+		delete(q.pending, endpoint)
+	}
+	q.queueSize.Set(float64(q.size))
+}
+
+// retryableHint returns true if sending a hint failed for a reason other than the endpoint refusing its samples,
+// e.g. as they conflict with samples written since.
+func retryableHint(err error) bool {
+	return !isConflict(err) && status.Code(err) != codes.InvalidArgument
+}
+
+// isUnreachable returns true if the error means that the endpoint could not be reached to write a request.
+func isUnreachable(err error) bool {
+	return isNotReady(err) || status.Code(err) == codes.DeadlineExceeded
+}
+
+func countSamples(r *storepb.WriteRequest) int {
+	var n int
+	for _, ts := range r.Timeseries {
+		n += len(ts.Samples)
+	}
+	return n
+}
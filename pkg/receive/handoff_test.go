@@ -0,0 +1,127 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHandoffQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "handoff-queue")
+	if err != nil {
+		t.Fatalf("unexpectedly failed creating the temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	hint := func(ts int64) *storepb.WriteRequest {
+		return &storepb.WriteRequest{
+			Tenant:  "test",
+			Replica: 2,
+			Timeseries: []prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: "foo", Value: "bar"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: ts}, {Value: 2, Timestamp: ts + 1}},
+			}},
+		}
+	}
+	b, err := hint(1000).Marshal()
+	if err != nil {
+		t.Fatalf("unexpectedly failed marshalling the hint: %v", err)
+	}
+
+	// The queue fits 3 hints.
+	q, err := NewHandoffQueue(nil, prometheus.NewRegistry(), dir, int64(3*len(b)), time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpectedly failed creating the queue: %v", err)
+	}
+	for i := int64(0); i < 3; i++ {
+		if err := q.Add("node1:10901", hint(1000+10*i)); err != nil {
+			t.Fatalf("unexpectedly failed adding hint %d: %v", i, err)
+		}
+	}
+	if err := q.Add("node2:10901", hint(1100)); err == nil {
+		t.Error("expected error adding hint to a full queue")
+	}
+	if got := promtestutil.ToFloat64(q.queuedSamples); got != 6 {
+		t.Errorf("expected 6 queued samples, got %v", got)
+	}
+	if got := promtestutil.ToFloat64(q.droppedSamples.WithLabelValues("full")); got != 2 {
+		t.Errorf("expected 2 samples dropped from the full queue, got %v", got)
+	}
+
+	// Hints survive restarts.
+	q, err = NewHandoffQueue(nil, prometheus.NewRegistry(), dir, int64(3*len(b)), time.Hour, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpectedly failed reopening the queue: %v", err)
+	}
+	if got := promtestutil.ToFloat64(q.queueSize); got != float64(3*len(b)) {
+		t.Errorf("expected queue of %d bytes, got %v", 3*len(b), got)
+	}
+	if !q.Pending("node1:10901") || q.Pending("node2:10901") {
+		t.Error("expected pending hints for node1 only")
+	}
+
+	// Unreachable endpoints keep their hints, replay stops at the first failure.
+	var sent []int64
+	sendErrs := []error{nil, status.Error(codes.Unavailable, "unavailable")}
+	send := func(_ context.Context, endpoint string, r *storepb.WriteRequest) error {
+		if endpoint != "node1:10901" || r.Tenant != "test" || r.Replica != 2 {
+			t.Errorf("unexpected hint for %s: %v", endpoint, r)
+		}
+		err := sendErrs[0]
+		if len(sendErrs) > 1 {
+			sendErrs = sendErrs[1:]
+		}
+		if err == nil {
+			sent = append(sent, r.Timeseries[0].Samples[0].Timestamp)
+		}
+		return err
+	}
+	q.replay(context.Background(), send)
+	if len(sent) != 1 || sent[0] != 1000 {
+		t.Errorf("expected the oldest hint to be replayed, got %v", sent)
+	}
+	if got := promtestutil.ToFloat64(q.replayedSamples); got != 2 {
+		t.Errorf("expected 2 replayed samples, got %v", got)
+	}
+
+	// Rejected hints are dropped.
+	sendErrs = []error{status.Error(codes.InvalidArgument, "invalid"), nil}
+	q.replay(context.Background(), send)
+	if len(sent) != 2 || sent[1] != 1020 {
+		t.Errorf("expected the last hint to be replayed after the rejected one, got %v", sent)
+	}
+	if got := promtestutil.ToFloat64(q.droppedSamples.WithLabelValues("rejected")); got != 2 {
+		t.Errorf("expected 2 rejected samples, got %v", got)
+	}
+	if got := promtestutil.ToFloat64(q.queueSize); got != 0 {
+		t.Errorf("expected empty queue, got %v bytes", got)
+	}
+	if q.Pending("node1:10901") {
+		t.Error("expected no pending hints for node1")
+	}
+
+	// Hints older than the max age are dropped.
+	q.maxAge = 0
+	if err := q.Add("node1:10901", hint(1030)); err != nil {
+		t.Fatalf("unexpectedly failed adding hint: %v", err)
+	}
+	q.replay(context.Background(), send)
+	if len(sent) != 2 {
+		t.Errorf("expected expired hint not to be replayed, got %v", sent)
+	}
+	if got := promtestutil.ToFloat64(q.droppedSamples.WithLabelValues("expired")); got != 2 {
+		t.Errorf("expected 2 expired samples, got %v", got)
+	}
+}
@@ -87,13 +87,109 @@ func (s simpleHashring) GetN(tenant string, ts *prompb.TimeSeries, n uint64) (st
 	return s[(hash(tenant, ts)+n)%uint64(len(s))], nil
 }
 
+// ketamaHashring is a consistent hashring. Every endpoint owns virtual nodes, placed on the ring by the hash
+// of the endpoint and the index of the virtual node. A time series belongs to the endpoint of the first virtual node
+// following its hash on the ring, and its replicas belong to the endpoints of the next virtual nodes
+// which are in other zones.
+type ketamaHashring struct {
+	endpoints []string
+	// zones holds the index of the zone of each endpoint. Endpoints with an empty zone are in a zone of their own.
+	zones    []int
+	numZones uint64
+	sections []ketamaSection
+}
+
+// ketamaSection is a virtual node of the endpoint at the given index.
+type ketamaSection struct {
+	hash     uint64
+	endpoint int
+}
+
+func newKetamaHashring(endpoints []string, virtualNodes uint32, options map[string]EndpointOptions) *ketamaHashring {
+	if virtualNodes == 0 {
+		virtualNodes = DefaultVirtualNodes
+	}
+	k := &ketamaHashring{
+		endpoints: endpoints,
+		zones:     make([]int, len(endpoints)),
+	}
+	zones := map[string]int{}
+	for i, e := range endpoints {
+		o := options[e]
+		z, ok := zones[o.Zone]
+		if o.Zone == "" || !ok {
+			z = int(k.numZones)
+			k.numZones++
+			if o.Zone != "" {
+				zones[o.Zone] = z
+			}
+		}
+		k.zones[i] = z
+
+		weight := o.Weight
+		if weight == 0 {
+			weight = 1
+		}
+		for v := uint32(0); v < virtualNodes*weight; v++ {
+			k.sections = append(k.sections, ketamaSection{
+				hash:     xxhash.Sum64String(fmt.Sprintf("%s%c%d", e, sep, v)),
+				endpoint: i,
+			})
+		}
+	}
+	sort.Slice(k.sections, func(i, j int) bool { return k.sections[i].hash < k.sections[j].hash })
+	return k
+}
+
+// Get returns a target to handle the given tenant and time series.
+func (k *ketamaHashring) Get(tenant string, ts *prompb.TimeSeries) (string, error) {
+	return k.GetN(tenant, ts, 0)
+}
+
+// GetN returns the nth target to handle the given tenant and time series.
+// The first n+1 targets are in distinct zones.
+func (k *ketamaHashring) GetN(tenant string, ts *prompb.TimeSeries, n uint64) (string, error) {
+	if n >= k.numZones {
+		return "", &insufficientNodesError{have: k.numZones, want: n + 1}
+	}
+
+	h := hash(tenant, ts)
+	i := sort.Search(len(k.sections), func(i int) bool { return k.sections[i].hash >= h })
+
+	// Every endpoint is in a single zone, so the zones picked so far identify the picked endpoints too.
+	// These are few, as n is lower than the replication factor, so they are kept in a slice, which stays on the stack.
+	var buf [8]int
+	picked := buf[:0]
+	for j := 0; j < len(k.sections); j++ {
+		e := k.sections[(i+j)%len(k.sections)].endpoint
+		if containsZone(picked, k.zones[e]) {
+			continue
+		}
+		if uint64(len(picked)) == n {
+			return k.endpoints[e], nil
+		}
+		picked = append(picked, k.zones[e])
+	}
+	return "", &insufficientNodesError{have: uint64(len(picked)), want: n + 1}
+}
+
+func containsZone(zones []int, zone int) bool {
+	for _, z := range zones {
+		if z == zone {
+			return true
+		}
+	}
+	return false
+}
+
 // multiHashring represents a set of hashrings.
 // Which hashring to use for a tenant is determined
 // by the tenants field of the hashring configuration.
 type multiHashring struct {
-	cache      map[string]Hashring
-	hashrings  []Hashring
-	tenantSets []map[string]struct{}
+	cache          map[string]int
+	hashrings      []Hashring
+	tenantSets     []map[string]struct{}
+	relabelConfigs []RelabelConfigs
 
 	// We need a mutex to guard concurrent access
 	// to the cache map, as this is both written to
@@ -108,11 +204,29 @@ func (m *multiHashring) Get(tenant string, ts *prompb.TimeSeries) (string, error
 
 // GetN returns the nth target to handle the given tenant and time series.
 func (m *multiHashring) GetN(tenant string, ts *prompb.TimeSeries, n uint64) (string, error) {
+	i, ok := m.match(tenant)
+	if !ok {
+		return "", errors.New("no matching hashring to handle tenant")
+	}
+	return m.hashrings[i].GetN(tenant, ts, n)
+}
+
+// RelabelConfigs returns the relabel configs of the hashring of the given tenant.
+func (m *multiHashring) RelabelConfigs(tenant string) RelabelConfigs {
+	i, ok := m.match(tenant)
+	if !ok {
+		return nil
+	}
+	return m.relabelConfigs[i]
+}
+
+// match returns the index of the hashring of the given tenant.
+func (m *multiHashring) match(tenant string) (int, bool) {
 	m.mu.RLock()
-	h, ok := m.cache[tenant]
+	i, ok := m.cache[tenant]
 	m.mu.RUnlock()
 	if ok {
-		return h.GetN(tenant, ts, n)
+		return i, true
 	}
 	var found bool
 	// If the tenant is not in the cache, then we need to check
@@ -127,12 +241,12 @@ func (m *multiHashring) GetN(tenant string, ts *prompb.TimeSeries, n uint64) (st
 		}
 		if found {
 			m.mu.Lock()
-			m.cache[tenant] = m.hashrings[i]
+			m.cache[tenant] = i
 			m.mu.Unlock()
-			return m.hashrings[i].GetN(tenant, ts, n)
+			return i, true
 		}
 	}
-	return "", errors.New("no matching hashring to handle tenant")
+	return 0, false
 }
 
 // newMultiHashring creates a multi-tenant hashring for a given slice of
@@ -141,11 +255,16 @@ func (m *multiHashring) GetN(tenant string, ts *prompb.TimeSeries, n uint64) (st
 // by the tenants field of the hashring configuration.
 func newMultiHashring(cfg []HashringConfig) Hashring {
 	m := &multiHashring{
-		cache: make(map[string]Hashring),
+		cache: make(map[string]int),
 	}
 
 	for _, h := range cfg {
-		m.hashrings = append(m.hashrings, simpleHashring(h.Endpoints))
+		switch h.Algorithm {
+		case AlgorithmKetama:
+			m.hashrings = append(m.hashrings, newKetamaHashring(h.Endpoints, h.VirtualNodes, h.EndpointOptions))
+		default:
+			m.hashrings = append(m.hashrings, simpleHashring(h.Endpoints))
+		}
 		var t map[string]struct{}
 		if len(h.Tenants) != 0 {
 			t = make(map[string]struct{})
@@ -154,30 +273,22 @@ func newMultiHashring(cfg []HashringConfig) Hashring {
 			t[tenant] = struct{}{}
 		}
 		m.tenantSets = append(m.tenantSets, t)
+		m.relabelConfigs = append(m.relabelConfigs, h.RelabelConfigs)
 	}
 	return m
 }
 
 // HashringFromConfig creates multi-tenant hashrings from a
 // hashring configuration file watcher.
-// The configuration file is watched for updates.
+// The configuration file is watched for updates and endpoints
+// of the hashrings are discovered by the given HashringDiscovery.
 // Hashrings are returned on the updates channel.
 // Which hashring to use for a tenant is determined
 // by the tenants field of the hashring configuration.
 // The updates chan is closed before exiting.
-func HashringFromConfig(ctx context.Context, updates chan<- Hashring, cw *ConfigWatcher) error {
+func HashringFromConfig(ctx context.Context, updates chan<- Hashring, cw *ConfigWatcher, d *HashringDiscovery) error {
 	defer close(updates)
 	go cw.Run(ctx)
 
-	for {
-		select {
-		case cfg, ok := <-cw.C():
-			if !ok {
-				return errors.New("hashring config watcher stopped unexpectedly")
-			}
-			updates <- newMultiHashring(cfg)
-		case <-ctx.Done():
-			return ctx.Err()
-		}
-	}
+	return d.Run(ctx, cw.C(), updates)
 }
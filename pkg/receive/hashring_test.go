@@ -4,6 +4,7 @@
 package receive
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
@@ -135,6 +136,20 @@ func TestHashringGet(t *testing.T) {
 			},
 			tenant: "tenant1",
 		},
+		{
+			name: "ketama",
+			cfg: []HashringConfig{
+				{
+					Endpoints: []string{"node1", "node2", "node3"},
+					Algorithm: AlgorithmKetama,
+				},
+			},
+			nodes: map[string]struct{}{
+				"node1": {},
+				"node2": {},
+				"node3": {},
+			},
+		},
 		{
 			name: "many nodes default",
 			cfg: []HashringConfig{
@@ -170,3 +185,113 @@ func TestHashringGet(t *testing.T) {
 		}
 	}
 }
+
+func seriesForTest(n int) []*prompb.TimeSeries {
+	series := make([]*prompb.TimeSeries, 0, n)
+	for i := 0; i < n; i++ {
+		series = append(series, &prompb.TimeSeries{
+			Labels: []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "instance", Value: fmt.Sprintf("instance-%d", i)}},
+		})
+	}
+	return series
+}
+
+func endpointsForTest(n int) []string {
+	endpoints := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		endpoints = append(endpoints, fmt.Sprintf("node-%d:10901", i))
+	}
+	return endpoints
+}
+
+// movedFraction returns the fraction of series, which are assigned to a different endpoint by the two hashrings.
+func movedFraction(t *testing.T, before, after Hashring, series []*prompb.TimeSeries) float64 {
+	var moved int
+	for _, ts := range series {
+		b, err := before.Get("tenant", ts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		a, err := after.Get("tenant", ts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if a != b {
+			moved++
+		}
+	}
+	return float64(moved) / float64(len(series))
+}
+
+func TestKetamaHashring_ScaleOut(t *testing.T) {
+	series := seriesForTest(10000)
+
+	for _, n := range []int{3, 10, 20} {
+		t.Run(fmt.Sprintf("%d endpoints", n), func(t *testing.T) {
+			before, after := endpointsForTest(n), endpointsForTest(n+1)
+
+			// Only series of the added endpoint move, which is 1/(n+1) of them for evenly balanced endpoints.
+			ideal := 1 / float64(n+1)
+			if moved := movedFraction(t, newKetamaHashring(before, 0, nil), newKetamaHashring(after, 0, nil), series); moved > 1.5*ideal {
+				t.Errorf("ketama: expected at most %.3f of series to move, moved %.3f", 1.5*ideal, moved)
+			}
+			// The hashmod algorithm remaps almost all series.
+			if moved := movedFraction(t, simpleHashring(before), simpleHashring(after), series); moved < 1-2*ideal {
+				t.Errorf("hashmod: expected at least %.3f of series to move, moved %.3f", 1-2*ideal, moved)
+			}
+		})
+	}
+}
+
+func TestKetamaHashring_Weights(t *testing.T) {
+	series := seriesForTest(10000)
+	endpoints := endpointsForTest(3)
+	h := newKetamaHashring(endpoints, 0, map[string]EndpointOptions{endpoints[0]: {Weight: 2}})
+
+	counts := map[string]int{}
+	for _, ts := range series {
+		e, err := h.Get("tenant", ts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		counts[e]++
+	}
+	// The endpoint of weight 2 gets half of the series.
+	if c := float64(counts[endpoints[0]]) / float64(len(series)); c < 0.4 || c > 0.6 {
+		t.Errorf("expected endpoint of weight 2 to get about 0.5 of series, got %.3f", c)
+	}
+	for _, e := range endpoints[1:] {
+		if c := float64(counts[e]) / float64(len(series)); c < 0.15 || c > 0.35 {
+			t.Errorf("expected endpoint %s to get about 0.25 of series, got %.3f", e, c)
+		}
+	}
+}
+
+func TestKetamaHashring_Zones(t *testing.T) {
+	series := seriesForTest(1000)
+	endpoints := endpointsForTest(6)
+	options := map[string]EndpointOptions{}
+	for i, e := range endpoints {
+		options[e] = EndpointOptions{Zone: fmt.Sprintf("zone-%d", i%3)}
+	}
+	h := newKetamaHashring(endpoints, 0, options)
+
+	for _, ts := range series {
+		zones := map[string]struct{}{}
+		for n := uint64(0); n < 3; n++ {
+			e, err := h.GetN("tenant", ts, n)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			zones[options[e].Zone] = struct{}{}
+		}
+		if len(zones) != 3 {
+			t.Fatalf("expected replicas of series %v in 3 distinct zones, got %v", ts.Labels, zones)
+		}
+	}
+
+	// Replicas cannot land in distinct zones if there are fewer zones than replicas.
+	if _, err := h.GetN("tenant", series[0], 3); err == nil {
+		t.Errorf("expected error getting replica of more zones than available")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+// MetadataFilename is the name of the file holding metric metadata of a tenant, next to its TSDB.
+const MetadataFilename = "metadata.json"
+
+// metadataStore keeps the latest metadata of every metric family of a tenant, persisted in a file.
+type metadataStore struct {
+	path string
+
+	mtx      sync.RWMutex
+	metadata map[string]prompb.MetricMetadata
+}
+
+func newMetadataStore(path string) *metadataStore {
+	return &metadataStore{
+		path:     path,
+		metadata: map[string]prompb.MetricMetadata{},
+	}
+}
+
+// openMetadataStore opens the metadata store persisted in the given file, if it exists.
+func openMetadataStore(path string) (*metadataStore, error) {
+	s := newMetadataStore(path)
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "read metadata")
+	}
+	var md []prompb.MetricMetadata
+	if err := json.Unmarshal(b, &md); err != nil {
+		return nil, errors.Wrapf(err, "unmarshal metadata from %s", path)
+	}
+	for _, m := range md {
+		s.metadata[m.MetricFamilyName] = m
+	}
+	return s, nil
+}
+
+// write updates metadata of the given metric families. The file is rewritten only if any metadata changed,
+// as remote write clients resend unchanged metadata periodically.
+func (s *metadataStore) write(md []prompb.MetricMetadata) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	changed := false
+	for _, m := range md {
+		if old, ok := s.metadata[m.MetricFamilyName]; ok && old == m {
+			continue
+		}
+		s.metadata[m.MetricFamilyName] = m
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+
+	b, err := json.Marshal(s.sorted())
+	if err != nil {
+		return errors.Wrap(err, "marshal metadata")
+	}
+	tmp := s.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0666); err != nil {
+		return errors.Wrap(err, "write metadata")
+	}
+	return errors.Wrap(os.Rename(tmp, s.path), "rename metadata")
+}
+
+// get returns metadata of all metric families, sorted by name.
+func (s *metadataStore) get() []prompb.MetricMetadata {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.sorted()
+}
+
+func (s *metadataStore) sorted() []prompb.MetricMetadata {
+	md := make([]prompb.MetricMetadata, 0, len(s.metadata))
+	for _, m := range s.metadata {
+		md = append(md, m)
+	}
+	sort.Slice(md, func(i, j int) bool { return md[i].MetricFamilyName < md[j].MetricFamilyName })
+	return md
+}
+
+// metadataSeries returns the series the metadata of a metric family is routed by, so it lands on the endpoints
+// of the series of the family without further labels.
+func metadataSeries(m *prompb.MetricMetadata) *prompb.TimeSeries {
+	return &prompb.TimeSeries{Labels: []prompb.Label{{Name: labels.MetricName, Value: m.MetricFamilyName}}}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+func TestMetadataStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadata-store")
+	if err != nil {
+		t.Fatalf("unexpectedly failed creating the temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, MetadataFilename)
+
+	s, err := openMetadataStore(path)
+	if err != nil {
+		t.Fatalf("unexpectedly failed opening missing metadata store: %v", err)
+	}
+	if md := s.get(); len(md) != 0 {
+		t.Fatalf("expected no metadata, got %v", md)
+	}
+
+	requests := prompb.MetricMetadata{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "requests_total", Help: "The number of requests."}
+	temperature := prompb.MetricMetadata{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "temperature", Unit: "celsius"}
+	if err := s.write([]prompb.MetricMetadata{temperature, requests}); err != nil {
+		t.Fatalf("unexpectedly failed writing metadata: %v", err)
+	}
+	expected := []prompb.MetricMetadata{requests, temperature}
+	if md := s.get(); !reflect.DeepEqual(expected, md) {
+		t.Errorf("expected metadata %v, got %v", expected, md)
+	}
+
+	// Unchanged metadata does not rewrite the file.
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(path, past, past); err != nil {
+		t.Fatalf("unexpectedly failed changing file times: %v", err)
+	}
+	if err := s.write([]prompb.MetricMetadata{requests}); err != nil {
+		t.Fatalf("unexpectedly failed writing metadata: %v", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpectedly failed reading file info: %v", err)
+	}
+	if !fi.ModTime().Equal(past) {
+		t.Error("expected unchanged metadata not to rewrite the file")
+	}
+
+	temperature.Help = "The temperature."
+	if err := s.write([]prompb.MetricMetadata{temperature}); err != nil {
+		t.Fatalf("unexpectedly failed writing metadata: %v", err)
+	}
+
+	// Metadata is persisted across restarts.
+	s, err = openMetadataStore(path)
+	if err != nil {
+		t.Fatalf("unexpectedly failed reopening metadata store: %v", err)
+	}
+	expected = []prompb.MetricMetadata{requests, temperature}
+	if md := s.get(); !reflect.DeepEqual(expected, md) {
+		t.Errorf("expected metadata %v, got %v", expected, md)
+	}
+}
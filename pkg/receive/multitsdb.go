@@ -9,12 +9,14 @@ import (
 	"os"
 	"path"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/storage/tsdb"
 	terrors "github.com/prometheus/prometheus/tsdb/errors"
@@ -24,41 +26,70 @@ import (
 	"github.com/thanos-io/thanos/pkg/runutil"
 	"github.com/thanos-io/thanos/pkg/shipper"
 	"github.com/thanos-io/thanos/pkg/store"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
 	"golang.org/x/sync/errgroup"
 )
 
+// errTenantEvicting is returned for tenants which are being evicted.
+var errTenantEvicting = errors.Wrap(tsdb.ErrNotReady, "tenant is being evicted")
+
 type MultiTSDB struct {
 	dataDir         string
 	logger          log.Logger
 	reg             prometheus.Registerer
 	tsdbCfg         *tsdb.Options
+	tenantsCfg      *TenantsConfig
+	idleTimeout     time.Duration
+	walOnly         bool
 	tenantLabelName string
 	labels          labels.Labels
 	bucket          objstore.Bucket
 
-	mtx     *sync.RWMutex
-	tenants map[string]*tenant
+	mtx      *sync.RWMutex
+	tenants  map[string]*tenant
+	evicting map[string]struct{}
+	// unshipped holds the shippers of evicted tenants whose blocks failed to be shipped, so shipping is retried by Sync.
+	unshipped map[string]*shipper.Shipper
+
+	tenantsGauge      prometheus.Gauge
+	evictedTenants    prometheus.Counter
+	evictionsFailures prometheus.Counter
 }
 
 type tenant struct {
 	tsdbCfg *tsdb.Options
+	reg     *tenantRegisterer
+	// lastWrite is the Unix time in nanoseconds of the last write to the tenant.
+	lastWrite int64
 
 	readyS *tsdb.ReadyStorage
 	fs     *FlushableStorage
 	s      *store.TSDBStore
 	ship   *shipper.Shipper
+	md     *metadataStore
 
 	mtx *sync.RWMutex
 }
 
-func newTenant(tsdbCfg *tsdb.Options) *tenant {
+func newTenant(tsdbCfg *tsdb.Options, reg *tenantRegisterer) *tenant {
 	return &tenant{
-		tsdbCfg: tsdbCfg,
-		readyS:  &tsdb.ReadyStorage{},
-		mtx:     &sync.RWMutex{},
+		tsdbCfg:   tsdbCfg,
+		reg:       reg,
+		lastWrite: time.Now().UnixNano(),
+		readyS:    &tsdb.ReadyStorage{},
+		mtx:       &sync.RWMutex{},
 	}
 }
 
+// touch records a write to the tenant.
+func (t *tenant) touch() {
+	atomic.StoreInt64(&t.lastWrite, time.Now().UnixNano())
+}
+
+func (t *tenant) idleSince() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&t.lastWrite))
+}
+
 func (t *tenant) readyStorage() *tsdb.ReadyStorage {
 	return t.readyS
 }
@@ -81,20 +112,33 @@ func (t *tenant) flushableStorage() *FlushableStorage {
 	return t.fs
 }
 
-func (t *tenant) set(tstore *store.TSDBStore, fs *FlushableStorage, ship *shipper.Shipper) {
+func (t *tenant) metadataStore() *metadataStore {
+	t.mtx.RLock()
+	defer t.mtx.RUnlock()
+	return t.md
+}
+
+func (t *tenant) set(tstore *store.TSDBStore, fs *FlushableStorage, ship *shipper.Shipper, md *metadataStore) {
 	t.readyS.Set(fs.Get(), int64(2*time.Duration(t.tsdbCfg.MinBlockDuration).Seconds()*1000))
 	t.mtx.Lock()
 	t.fs = fs
 	t.s = tstore
 	t.ship = ship
+	t.md = md
 	t.mtx.Unlock()
 }
 
+// NewMultiTSDB creates a new MultiTSDB holding a TSDB per tenant. TSDB options of tenants default to tsdbCfg,
+// overridden by the given tenants configuration, which can be nil. Tenants without writes for longer than their
+// idle timeout are evicted by EvictIdle; the idle timeout defaults to idleTimeout, 0 disables eviction.
 func NewMultiTSDB(
 	dataDir string,
 	l log.Logger,
 	reg prometheus.Registerer,
 	tsdbCfg *tsdb.Options,
+	tenantsCfg *TenantsConfig,
+	idleTimeout time.Duration,
+	walOnly bool,
 	labels labels.Labels,
 	tenantLabelName string,
 	bucket objstore.Bucket,
@@ -108,11 +152,28 @@ func NewMultiTSDB(
 		logger:          l,
 		reg:             reg,
 		tsdbCfg:         tsdbCfg,
+		tenantsCfg:      tenantsCfg,
+		idleTimeout:     idleTimeout,
+		walOnly:         walOnly,
 		mtx:             &sync.RWMutex{},
 		tenants:         map[string]*tenant{},
+		evicting:        map[string]struct{}{},
+		unshipped:       map[string]*shipper.Shipper{},
 		labels:          labels,
 		tenantLabelName: tenantLabelName,
 		bucket:          bucket,
+		tenantsGauge: promauto.With(reg).NewGauge(prometheus.GaugeOpts{
+			Name: "thanos_receive_tenants",
+			Help: "The number of tenants with a TSDB.",
+		}),
+		evictedTenants: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_receive_evicted_tenants_total",
+			Help: "The number of tenants evicted for being idle.",
+		}),
+		evictionsFailures: promauto.With(reg).NewCounter(prometheus.CounterOpts{
+			Name: "thanos_receive_tenant_eviction_failures_total",
+			Help: "The number of failed evictions of idle tenants.",
+		}),
 	}
 }
 
@@ -135,6 +196,9 @@ func (t *MultiTSDB) Open() error {
 
 		g.Go(func() error {
 			_, err := t.getOrLoadTenant(f.Name(), true)
+			if err == errTenantEvicting {
+				return nil
+			}
 			return err
 		})
 	}
@@ -170,7 +234,16 @@ func (t *MultiTSDB) Flush() error {
 	return merr.Err()
 }
 
+// Sync uploads the blocks of all tenants. In WAL-only mode, complete blocks are cut from the heads first and
+// removed locally once uploaded. Shipping of evicted tenants whose blocks failed to be shipped is retried.
 func (t *MultiTSDB) Sync(ctx context.Context) error {
+	merr := terrors.MultiError{}
+	merr.Add(t.syncTenants(ctx))
+	merr.Add(t.syncEvicted(ctx))
+	return merr.Err()
+}
+
+func (t *MultiTSDB) syncTenants(ctx context.Context) error {
 	t.mtx.RLock()
 	defer t.mtx.RUnlock()
 
@@ -183,22 +256,88 @@ func (t *MultiTSDB) Sync(ctx context.Context) error {
 		if s == nil {
 			continue
 		}
+		fs := tenant.flushableStorage()
 
 		wg.Add(1)
-		go func() {
-			if uploaded, err := s.Sync(ctx); err != nil {
+		go func(tenantID string) {
+			defer wg.Done()
+			if err := t.sync(ctx, tenantID, fs, s); err != nil {
 				errmtx.Lock()
-				merr.Add(errors.Wrapf(err, "upload %d", uploaded))
+				merr.Add(err)
 				errmtx.Unlock()
 			}
-			wg.Done()
-		}()
+		}(tenantID)
 	}
 
 	wg.Wait()
 	return merr.Err()
 }
 
+// syncEvicted retries shipping the blocks of evicted tenants which failed to be shipped on eviction.
+// Writes to these tenants fail with tsdb.ErrNotReady while their blocks are shipped.
+func (t *MultiTSDB) syncEvicted(ctx context.Context) error {
+	t.mtx.Lock()
+	unshipped := t.unshipped
+	t.unshipped = map[string]*shipper.Shipper{}
+	for tenantID := range unshipped {
+		t.evicting[tenantID] = struct{}{}
+	}
+	t.mtx.Unlock()
+
+	merr := terrors.MultiError{}
+	for tenantID, ship := range unshipped {
+		err := t.shipEvicted(ctx, tenantID, ship)
+
+		t.mtx.Lock()
+		if err != nil {
+			merr.Add(errors.Wrapf(err, "evicted tenant %s", tenantID))
+			t.unshipped[tenantID] = ship
+		}
+		delete(t.evicting, tenantID)
+		t.mtx.Unlock()
+	}
+	return merr.Err()
+}
+
+func (t *MultiTSDB) sync(ctx context.Context, tenantID string, fs *FlushableStorage, s *shipper.Shipper) error {
+	if !t.walOnly {
+		if uploaded, err := s.Sync(ctx); err != nil {
+			return errors.Wrapf(err, "upload %d", uploaded)
+		}
+		return nil
+	}
+
+	if err := fs.CutHead(); err != nil {
+		return errors.Wrapf(err, "cut head of tenant %s", tenantID)
+	}
+	if uploaded, err := s.Sync(ctx); err != nil {
+		return errors.Wrapf(err, "upload %d", uploaded)
+	}
+	return errors.Wrapf(removeShipped(t.shipDir(tenantID)), "remove shipped blocks of tenant %s", tenantID)
+}
+
+// shipDir returns the directory blocks of a WAL-only tenant are cut into and shipped from.
+func (t *MultiTSDB) shipDir(tenantID string) string {
+	return path.Join(t.dataDir, tenantID, "ship")
+}
+
+// removeShipped removes the blocks in dir which the shipper uploaded.
+func removeShipped(dir string) error {
+	meta, err := shipper.ReadMetaFile(dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "read shipper meta file")
+	}
+	for _, id := range meta.Uploaded {
+		if err := os.RemoveAll(path.Join(dir, id.String())); err != nil {
+			return errors.Wrapf(err, "remove block %s", id)
+		}
+	}
+	return nil
+}
+
 func (t *MultiTSDB) TSDBStores() map[string]*store.TSDBStore {
 	t.mtx.RLock()
 	defer t.mtx.RUnlock()
@@ -213,10 +352,15 @@ func (t *MultiTSDB) TSDBStores() map[string]*store.TSDBStore {
 	return res
 }
 
+// getOrLoadTenant returns the tenant, loading it if needed, and records a write to it. The write is recorded under
+// the lock, so EvictIdle does not evict a tenant between it being returned and written to.
 func (t *MultiTSDB) getOrLoadTenant(tenantID string, blockingStart bool) (*tenant, error) {
 	// Fast path, as creating tenants is a very rare operation.
 	t.mtx.RLock()
 	tenant, exist := t.tenants[tenantID]
+	if exist {
+		tenant.touch()
+	}
 	t.mtx.RUnlock()
 	if exist {
 		return tenant, nil
@@ -228,52 +372,77 @@ func (t *MultiTSDB) getOrLoadTenant(tenantID string, blockingStart bool) (*tenan
 	t.mtx.Lock()
 	tenant, exist = t.tenants[tenantID]
 	if exist {
+		tenant.touch()
 		t.mtx.Unlock()
 		return tenant, nil
 	}
-
-	tenant = newTenant(t.tsdbCfg)
+	// Data of evicted tenants is still being flushed and shipped.
+	if _, ok := t.evicting[tenantID]; ok {
+		t.mtx.Unlock()
+		return nil, errTenantEvicting
+	}
+	// The shipper of the tenant ships the blocks left by its eviction.
+	delete(t.unshipped, tenantID)
+
+	// Metrics of the tenant are unregistered on eviction, so they can be registered again if it comes back.
+	reg := &tenantRegisterer{Registerer: prometheus.WrapRegistererWith(prometheus.Labels{
+		"tenant": tenantID,
+	}, t.reg)}
+	tenant = newTenant(t.tenantsCfg.TSDBOptions(tenantID, t.tsdbCfg), reg)
 	t.tenants[tenantID] = tenant
+	t.tenantsGauge.Set(float64(len(t.tenants)))
 	t.mtx.Unlock()
 
 	var err error
 	startTSDB := func() {
-		reg := prometheus.WrapRegistererWith(prometheus.Labels{
-			"tenant": tenantID,
-		}, t.reg)
 		logger := log.With(t.logger, "tenant", tenantID)
 		lbls := append(t.labels, labels.Label{Name: t.tenantLabelName, Value: tenantID})
 		dataDir := path.Join(t.dataDir, tenantID)
 
+		// WAL-only tenants ship the blocks cut from their head rather than blocks of their TSDB.
+		shipDir := dataDir
+		if t.walOnly {
+			shipDir = t.shipDir(tenantID)
+		}
+
 		var ship *shipper.Shipper
 		if t.bucket != nil {
 			ship = shipper.New(
 				logger,
 				reg,
-				dataDir,
+				shipDir,
 				t.bucket,
 				func() labels.Labels { return lbls },
 				metadata.ReceiveSource,
 			)
 		}
 
-		s := NewFlushableStorage(
-			dataDir,
-			logger,
-			reg,
-			t.tsdbCfg,
-		)
+		var s *FlushableStorage
+		if t.walOnly {
+			s = NewWALOnlyStorage(dataDir, logger, reg, tenant.tsdbCfg, shipDir)
+		} else {
+			s = NewFlushableStorage(dataDir, logger, reg, tenant.tsdbCfg)
+		}
 
 		// Assign to outer error to report in blocking case.
 		if err = s.Open(); err != nil {
 			level.Error(logger).Log("msg", "failed to open tsdb", "err", err)
 			t.mtx.Lock()
 			delete(t.tenants, tenantID)
+			t.tenantsGauge.Set(float64(len(t.tenants)))
 			t.mtx.Unlock()
 			runutil.CloseWithLogOnErr(logger, s, "failed to close tsdb")
+			reg.unregisterAll()
 			return
 		}
 
+		md, mdErr := openMetadataStore(path.Join(dataDir, MetadataFilename))
+		if mdErr != nil {
+			// Metadata is resent by remote write clients, so start over rather than failing the tenant.
+			level.Warn(logger).Log("msg", "failed to open metadata store, dropping persisted metadata", "err", mdErr)
+			md = newMetadataStore(path.Join(dataDir, MetadataFilename))
+		}
+
 		tenant.set(
 			store.NewTSDBStore(
 				logger,
@@ -284,6 +453,7 @@ func (t *MultiTSDB) getOrLoadTenant(tenantID string, blockingStart bool) (*tenan
 			),
 			s,
 			ship,
+			md,
 		)
 	}
 	if !blockingStart {
@@ -295,6 +465,19 @@ func (t *MultiTSDB) getOrLoadTenant(tenantID string, blockingStart bool) (*tenan
 	return tenant, err
 }
 
+// WriteMetadata stores metadata of metric families of the tenant.
+func (t *MultiTSDB) WriteMetadata(tenantID string, md []prompb.MetricMetadata) error {
+	tenant, err := t.getOrLoadTenant(tenantID, false)
+	if err != nil {
+		return err
+	}
+	s := tenant.metadataStore()
+	if s == nil {
+		return tsdb.ErrNotReady
+	}
+	return s.write(md)
+}
+
 func (t *MultiTSDB) TenantAppendable(tenantID string) (Appendable, error) {
 	tenant, err := t.getOrLoadTenant(tenantID, false)
 	if err != nil {
@@ -302,3 +485,108 @@ func (t *MultiTSDB) TenantAppendable(tenantID string) (Appendable, error) {
 	}
 	return tenant.readyStorage(), nil
 }
+
+// EvictIdle evicts tenants without writes for longer than their idle timeout, releasing their WAL, head and shipper.
+// The head of an evicted tenant is flushed to a block, its blocks are shipped and its TSDB is closed.
+// Local data is removed once all blocks are shipped; without a bucket it is kept and loaded again on the next write.
+// Blocks failing to be shipped are shipped by the next Sync, or by the tenant itself if it is written to again first.
+// Writes to tenants being evicted fail with tsdb.ErrNotReady.
+func (t *MultiTSDB) EvictIdle(ctx context.Context) error {
+	idle := map[string]*tenant{}
+	t.mtx.Lock()
+	for tenantID, tenant := range t.tenants {
+		timeout := t.tenantsCfg.IdleTimeout(tenantID, t.idleTimeout)
+		// Tenants with a TSDB still starting are not idle.
+		if timeout <= 0 || tenant.flushableStorage() == nil || time.Since(tenant.idleSince()) < timeout {
+			continue
+		}
+		idle[tenantID] = tenant
+		delete(t.tenants, tenantID)
+		t.evicting[tenantID] = struct{}{}
+	}
+	t.tenantsGauge.Set(float64(len(t.tenants)))
+	t.mtx.Unlock()
+
+	merr := terrors.MultiError{}
+	for tenantID, tenant := range idle {
+		level.Info(t.logger).Log("msg", "evicting idle tenant", "tenant", tenantID, "idle_since", tenant.idleSince())
+		var unshipped *shipper.Shipper
+		err := t.evict(tenantID, tenant)
+		if ship := tenant.shipper(); err == nil && ship != nil {
+			if err = t.shipEvicted(ctx, tenantID, ship); err != nil {
+				unshipped = ship
+			}
+		}
+		if err != nil {
+			t.evictionsFailures.Inc()
+			merr.Add(errors.Wrapf(err, "evict tenant %s", tenantID))
+		} else {
+			t.evictedTenants.Inc()
+		}
+
+		t.mtx.Lock()
+		if unshipped != nil {
+			t.unshipped[tenantID] = unshipped
+		}
+		delete(t.evicting, tenantID)
+		t.mtx.Unlock()
+	}
+	return merr.Err()
+}
+
+// evict flushes and closes the TSDB of the tenant.
+func (t *MultiTSDB) evict(tenantID string, tenant *tenant) error {
+	defer tenant.reg.unregisterAll()
+
+	fs := tenant.flushableStorage()
+	// Flush leaves the storage closed, unless it fails.
+	if err := fs.Flush(); err != nil {
+		runutil.CloseWithLogOnErr(t.logger, fs, "close tsdb of tenant %s", tenantID)
+		return errors.Wrap(err, "flush head")
+	}
+	return errors.Wrap(fs.Close(), "close tsdb")
+}
+
+// shipEvicted ships the blocks of an evicted tenant and removes its local data once all blocks are shipped.
+func (t *MultiTSDB) shipEvicted(ctx context.Context, tenantID string, ship *shipper.Shipper) error {
+	if _, err := ship.Sync(ctx); err != nil {
+		return errors.Wrap(err, "ship blocks")
+	}
+	return errors.Wrap(os.RemoveAll(path.Join(t.dataDir, tenantID)), "remove local data")
+}
+
+// tenantRegisterer records collectors registered for a tenant, so they can be unregistered when it is evicted.
+type tenantRegisterer struct {
+	prometheus.Registerer
+
+	mtx sync.Mutex
+	cs  []prometheus.Collector
+}
+
+func (r *tenantRegisterer) Register(c prometheus.Collector) error {
+	if err := r.Registerer.Register(c); err != nil {
+		return err
+	}
+	r.mtx.Lock()
+	r.cs = append(r.cs, c)
+	r.mtx.Unlock()
+	return nil
+}
+
+func (r *tenantRegisterer) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// unregisterAll unregisters all collectors registered so far.
+func (r *tenantRegisterer) unregisterAll() {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	for _, c := range r.cs {
+		r.Registerer.Unregister(c)
+	}
+	r.cs = nil
+}
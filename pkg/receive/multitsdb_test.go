@@ -0,0 +1,236 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+// newTestMultiTSDB creates a MultiTSDB of replica 1 in the given directory, cutting 2h blocks.
+func newTestMultiTSDB(dir string, tenantsCfg *TenantsConfig, idleTimeout time.Duration, walOnly bool, bkt objstore.Bucket) *MultiTSDB {
+	return NewMultiTSDB(
+		dir,
+		nil,
+		prometheus.NewRegistry(),
+		&tsdb.Options{
+			RetentionDuration: model.Duration(15 * 24 * time.Hour),
+			MinBlockDuration:  model.Duration(2 * time.Hour),
+			MaxBlockDuration:  model.Duration(2 * time.Hour),
+			NoLockfile:        true,
+		},
+		tenantsCfg,
+		idleTimeout,
+		walOnly,
+		labels.FromStrings("replica", "1"),
+		DefaultTenantLabel,
+		bkt,
+	)
+}
+
+func TestMultiTSDBEvictIdle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multitsdb-evict")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	bkt := objstore.NewInMemBucket()
+	never := model.Duration(0)
+	m := newTestMultiTSDB(dir, &TenantsConfig{Tenants: []TenantConfig{{Tenant: "busy", IdleTimeout: &never}}}, time.Hour, false, bkt)
+	defer func() { testutil.Ok(t, m.Flush()) }()
+
+	write := func(tenantID string) {
+		tenant, err := m.getOrLoadTenant(tenantID, true)
+		testutil.Ok(t, err)
+		app, err := tenant.readyStorage().Appender()
+		testutil.Ok(t, err)
+		_, err = app.Add(labels.FromStrings("foo", "bar"), 1000, 1)
+		testutil.Ok(t, err)
+		testutil.Ok(t, app.Commit())
+	}
+	for _, tenantID := range []string{"idle", "busy", "active"} {
+		write(tenantID)
+	}
+	// Both idle and busy have not been written to for longer than the idle timeout, but eviction of busy is disabled.
+	for _, tenantID := range []string{"idle", "busy"} {
+		atomic.StoreInt64(&m.tenants[tenantID].lastWrite, time.Now().Add(-2*time.Hour).UnixNano())
+	}
+
+	testutil.Ok(t, m.EvictIdle(context.Background()))
+	stores := m.TSDBStores()
+	testutil.Equals(t, 2, len(stores))
+	for _, tenantID := range []string{"busy", "active"} {
+		_, ok := stores[tenantID]
+		testutil.Assert(t, ok, "expected TSDB of tenant %s", tenantID)
+	}
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(m.evictedTenants))
+	testutil.Equals(t, 2.0, promtestutil.ToFloat64(m.tenantsGauge))
+
+	// The head of the evicted tenant is shipped and its local data is removed.
+	_, err = os.Stat(path.Join(dir, "idle"))
+	testutil.Assert(t, os.IsNotExist(err), "expected local data of evicted tenant to be removed, got %v", err)
+	var blocks int
+	testutil.Ok(t, bkt.Iter(context.Background(), "", func(name string) error {
+		if _, err := ulid.Parse(strings.TrimSuffix(name, "/")); err == nil {
+			blocks++
+		}
+		return nil
+	}))
+	testutil.Equals(t, 1, blocks)
+
+	// Evicted tenants are loaded again on write.
+	write("idle")
+	testutil.Equals(t, 3, len(m.TSDBStores()))
+}
+
+// failingUploadBucket fails uploads while fail is set.
+type failingUploadBucket struct {
+	objstore.Bucket
+	fail int32
+}
+
+func (b *failingUploadBucket) Upload(ctx context.Context, name string, r io.Reader) error {
+	if atomic.LoadInt32(&b.fail) == 1 {
+		return errors.New("upload failed")
+	}
+	return b.Bucket.Upload(ctx, name, r)
+}
+
+func TestMultiTSDBEvictIdleRetriesShipping(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multitsdb-evict-retry")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	bkt := &failingUploadBucket{Bucket: objstore.NewInMemBucket(), fail: 1}
+	m := newTestMultiTSDB(dir, nil, time.Hour, false, bkt)
+	defer func() { testutil.Ok(t, m.Flush()) }()
+
+	tenant, err := m.getOrLoadTenant("idle", true)
+	testutil.Ok(t, err)
+	app, err := tenant.readyStorage().Appender()
+	testutil.Ok(t, err)
+	_, err = app.Add(labels.FromStrings("foo", "bar"), 1000, 1)
+	testutil.Ok(t, err)
+	testutil.Ok(t, app.Commit())
+	atomic.StoreInt64(&tenant.lastWrite, time.Now().Add(-2*time.Hour).UnixNano())
+
+	// The tenant is evicted, but its local data is kept as its blocks failed to be shipped.
+	testutil.NotOk(t, m.EvictIdle(context.Background()))
+	testutil.Equals(t, 0, len(m.TSDBStores()))
+	testutil.Equals(t, 1.0, promtestutil.ToFloat64(m.evictionsFailures))
+	_, err = os.Stat(path.Join(dir, "idle"))
+	testutil.Ok(t, err)
+
+	// Shipping is retried by the next sync, which removes the local data once shipped.
+	atomic.StoreInt32(&bkt.fail, 0)
+	testutil.Ok(t, m.Sync(context.Background()))
+	_, err = os.Stat(path.Join(dir, "idle"))
+	testutil.Assert(t, os.IsNotExist(err), "expected local data of evicted tenant to be removed, got %v", err)
+	var blocks int
+	testutil.Ok(t, bkt.Iter(context.Background(), "", func(name string) error {
+		if _, err := ulid.Parse(strings.TrimSuffix(name, "/")); err == nil {
+			blocks++
+		}
+		return nil
+	}))
+	testutil.Equals(t, 1, blocks)
+	testutil.Equals(t, 0, len(m.unshipped))
+}
+
+func TestMultiTSDBWALOnly(t *testing.T) {
+	dir, err := ioutil.TempDir("", "multitsdb-wal-only")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	bkt := objstore.NewInMemBucket()
+	shipped := func() []metadata.Meta {
+		var metas []metadata.Meta
+		testutil.Ok(t, bkt.Iter(context.Background(), "", func(name string) error {
+			id, err := ulid.Parse(strings.TrimSuffix(name, "/"))
+			if err != nil {
+				return nil
+			}
+			meta, err := block.DownloadMeta(context.Background(), log.NewNopLogger(), bkt, id)
+			testutil.Ok(t, err)
+			metas = append(metas, meta)
+			return nil
+		}))
+		sort.Slice(metas, func(i, j int) bool { return metas[i].MinTime < metas[j].MinTime })
+		return metas
+	}
+	localBlocks := func() int {
+		files, err := ioutil.ReadDir(path.Join(dir, "foo", "ship"))
+		testutil.Ok(t, err)
+		var n int
+		for _, f := range files {
+			if _, err := ulid.Parse(f.Name()); err == nil {
+				n++
+			}
+		}
+		return n
+	}
+
+	m := newTestMultiTSDB(dir, nil, 0, true, bkt)
+	tenant, err := m.getOrLoadTenant("foo", true)
+	testutil.Ok(t, err)
+	app, err := tenant.readyStorage().Appender()
+	testutil.Ok(t, err)
+	// Samples over 4h, so the first 2h block range is complete.
+	for ts := int64(0); ts <= 4*time.Hour.Milliseconds(); ts += time.Minute.Milliseconds() {
+		_, err = app.Add(labels.FromStrings("foo", "bar"), ts, float64(ts))
+		testutil.Ok(t, err)
+	}
+	testutil.Ok(t, app.Commit())
+
+	testutil.Ok(t, m.Sync(context.Background()))
+	metas := shipped()
+	testutil.Equals(t, 1, len(metas))
+	testutil.Equals(t, int64(0), metas[0].MinTime)
+	testutil.Equals(t, 2*time.Hour.Milliseconds(), metas[0].MaxTime)
+	testutil.Equals(t, uint64(120), metas[0].Stats.NumSamples)
+	testutil.Equals(t, labels.FromStrings("replica", "1", DefaultTenantLabel, "foo").Map(), metas[0].Thanos.Labels)
+	testutil.Equals(t, 0, localBlocks())
+	// The head keeps serving the samples which were not cut yet.
+	testutil.Equals(t, 2*time.Hour.Milliseconds(), tenant.flushableStorage().Get().Head().MinTime())
+	testutil.Equals(t, 0, len(tenant.flushableStorage().Get().Blocks()))
+
+	// Samples replayed from the WAL on restart which were already shipped are not cut again.
+	testutil.Ok(t, tenant.flushableStorage().Close())
+	m = newTestMultiTSDB(dir, nil, 0, true, bkt)
+	testutil.Ok(t, m.Open())
+	testutil.Equals(t, 2*time.Hour.Milliseconds(), m.tenants["foo"].flushableStorage().Get().Head().MinTime())
+
+	// Flushing cuts the rest of the head.
+	testutil.Ok(t, m.Flush())
+	testutil.Ok(t, m.Sync(context.Background()))
+	metas = shipped()
+	testutil.Equals(t, 2, len(metas))
+	testutil.Equals(t, 2*time.Hour.Milliseconds(), metas[1].MinTime)
+	testutil.Equals(t, 4*time.Hour.Milliseconds()+1, metas[1].MaxTime)
+	testutil.Equals(t, uint64(121), metas[1].Stats.NumSamples)
+	testutil.Equals(t, 0, localBlocks())
+	_, err = os.Stat(path.Join(dir, "foo", "wal"))
+	testutil.Assert(t, os.IsNotExist(err), "expected WAL to be removed, got %v", err)
+}
@@ -0,0 +1,179 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/common/route"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/textparse"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+// base64Suffix marks label names in push paths, whose values are base64 encoded, as done by the Pushgateway.
+const base64Suffix = "@base64"
+
+var metricTypes = map[textparse.MetricType]prompb.MetricMetadata_MetricType{
+	textparse.MetricTypeCounter:        prompb.MetricMetadata_COUNTER,
+	textparse.MetricTypeGauge:          prompb.MetricMetadata_GAUGE,
+	textparse.MetricTypeHistogram:      prompb.MetricMetadata_HISTOGRAM,
+	textparse.MetricTypeGaugeHistogram: prompb.MetricMetadata_GAUGEHISTOGRAM,
+	textparse.MetricTypeSummary:        prompb.MetricMetadata_SUMMARY,
+	textparse.MetricTypeInfo:           prompb.MetricMetadata_INFO,
+	textparse.MetricTypeStateset:       prompb.MetricMetadata_STATESET,
+}
+
+// pushHTTP accepts metrics pushed in the Prometheus text or OpenMetrics exposition format, Pushgateway-style.
+// The job label and further labels, e.g. instance, come from the path: /job/<job>{/<label name>/<label value>}.
+// Labels of the path take precedence over labels of the pushed metrics.
+func (h *Handler) pushHTTP(w http.ResponseWriter, r *http.Request) {
+	lset, err := parsePushLabels(route.Param(r.Context(), "labels"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	wreq, err := parseExposition(b, r.Header.Get("Content-Type"), lset, timestamp.FromTime(time.Now()))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	tenant := r.Header.Get(h.options.TenantHeader)
+	if len(tenant) == 0 {
+		tenant = h.options.DefaultTenantID
+	}
+
+	done, err := h.limit(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer done()
+
+	h.writeResponse(w, h.handleRequest(r.Context(), 0, tenant, wreq))
+}
+
+// parsePushLabels parses labels of a push path: /job/<job>{/<label name>/<label value>}.
+// Label names with the @base64 suffix have base64 URL encoded values.
+func parsePushLabels(path string) (labels.Labels, error) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts)%2 != 0 {
+		return nil, errors.Errorf("odd number of path segments in %q", path)
+	}
+
+	lset := make(labels.Labels, 0, len(parts)/2)
+	for i := 0; i < len(parts); i += 2 {
+		name, value := parts[i], parts[i+1]
+		if strings.HasSuffix(name, base64Suffix) {
+			name = strings.TrimSuffix(name, base64Suffix)
+			// Pushgateway accepts values with and without padding.
+			v, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+			if err != nil {
+				return nil, errors.Wrapf(err, "decode base64 value of label %q", name)
+			}
+			value = string(v)
+		}
+		if i == 0 && name != "job" {
+			return nil, errors.Errorf("push path must start with the job label, got %q", name)
+		}
+		if !model.LabelName(name).IsValid() || strings.HasPrefix(name, model.ReservedLabelPrefix) {
+			return nil, errors.Errorf("invalid label name %q", name)
+		}
+		if value == "" && name == "job" {
+			return nil, errors.New("empty job label")
+		}
+		lset = append(lset, labels.Label{Name: name, Value: value})
+	}
+	if len(lset) == 0 {
+		return nil, errors.New("push path must start with the job label")
+	}
+	sort.Sort(lset)
+	return lset, nil
+}
+
+// parseExposition translates metrics in the Prometheus text or OpenMetrics exposition format, depending on
+// the content type, into a write request. Samples without timestamp get the given one. The given labels are added
+// to every series, replacing labels of the same name.
+func parseExposition(b []byte, contentType string, extLset labels.Labels, defaultTimestamp int64) (*prompb.WriteRequest, error) {
+	var (
+		p        = textparse.New(b, contentType)
+		wreq     = &prompb.WriteRequest{}
+		metadata = map[string]*prompb.MetricMetadata{}
+		lset     labels.Labels
+	)
+	familyMetadata := func(name []byte) *prompb.MetricMetadata {
+		m, ok := metadata[string(name)]
+		if !ok {
+			m = &prompb.MetricMetadata{MetricFamilyName: string(name)}
+			metadata[string(name)] = m
+		}
+		return m
+	}
+
+	for {
+		e, err := p.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "parse exposition")
+		}
+
+		switch e {
+		case textparse.EntryType:
+			name, t := p.Type()
+			familyMetadata(name).Type = metricTypes[t]
+		case textparse.EntryHelp:
+			name, help := p.Help()
+			familyMetadata(name).Help = string(help)
+		case textparse.EntryUnit:
+			name, unit := p.Unit()
+			familyMetadata(name).Unit = string(unit)
+		case textparse.EntrySeries:
+			_, ts, v := p.Series()
+			t := defaultTimestamp
+			if ts != nil {
+				t = *ts
+			}
+			// Metric appends to the given labels.
+			lset = lset[:0]
+			p.Metric(&lset)
+
+			lb := labels.NewBuilder(lset)
+			for _, l := range extLset {
+				lb.Set(l.Name, l.Value)
+			}
+			series := prompb.TimeSeries{Samples: []prompb.Sample{{Value: v, Timestamp: t}}}
+			for _, l := range lb.Labels() {
+				series.Labels = append(series.Labels, prompb.Label{Name: l.Name, Value: l.Value})
+			}
+			wreq.Timeseries = append(wreq.Timeseries, series)
+		}
+	}
+
+	for _, m := range metadata {
+		wreq.Metadata = append(wreq.Metadata, *m)
+	}
+	sort.Slice(wreq.Metadata, func(i, j int) bool {
+		return wreq.Metadata[i].MetricFamilyName < wreq.Metadata[j].MetricFamilyName
+	})
+	return wreq, nil
+}
@@ -0,0 +1,154 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+func TestParsePushLabels(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		path string
+		lset labels.Labels
+		err  bool
+	}{
+		{
+			name: "job only",
+			path: "/job/test",
+			lset: labels.FromStrings("job", "test"),
+		},
+		{
+			name: "job and instance",
+			path: "/job/test/instance/host:9100/",
+			lset: labels.FromStrings("instance", "host:9100", "job", "test"),
+		},
+		{
+			name: "base64 values",
+			path: "/job@base64/L3Zhci90bXA=/path@base64/YS9i",
+			lset: labels.FromStrings("job", "/var/tmp", "path", "a/b"),
+		},
+		{
+			name: "no job",
+			path: "/instance/host",
+			err:  true,
+		},
+		{
+			name: "empty job",
+			path: "/job@base64/=",
+			err:  true,
+		},
+		{
+			name: "missing value",
+			path: "/job/test/instance",
+			err:  true,
+		},
+		{
+			name: "invalid label name",
+			path: "/job/test/in-stance/host",
+			err:  true,
+		},
+		{
+			name: "reserved label name",
+			path: "/job/test/__name__/up",
+			err:  true,
+		},
+		{
+			name: "invalid base64 value",
+			path: "/job/test/instance@base64/!!",
+			err:  true,
+		},
+	} {
+		lset, err := parsePushLabels(tc.path)
+		if tc.err {
+			if err == nil {
+				t.Errorf("test case %s: expected error, got labels %s", tc.name, lset)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test case %s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if !labels.Equal(tc.lset, lset) {
+			t.Errorf("test case %s: expected labels %s, got %s", tc.name, tc.lset, lset)
+		}
+	}
+}
+
+func TestParseExposition(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		contentType string
+		input       string
+		wreq        *prompb.WriteRequest
+	}{
+		{
+			name: "text format",
+			input: `# HELP requests_total The number of requests.
+# TYPE requests_total counter
+requests_total{code="200",job="other"} 3
+requests_total{code="500"} 1 500
+temperature 21.5
+`,
+			wreq: &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{
+					{
+						Labels:  []prompb.Label{{Name: "__name__", Value: "requests_total"}, {Name: "code", Value: "200"}, {Name: "job", Value: "test"}},
+						Samples: []prompb.Sample{{Value: 3, Timestamp: 1000}},
+					},
+					{
+						Labels:  []prompb.Label{{Name: "__name__", Value: "requests_total"}, {Name: "code", Value: "500"}, {Name: "job", Value: "test"}},
+						Samples: []prompb.Sample{{Value: 1, Timestamp: 500}},
+					},
+					{
+						Labels:  []prompb.Label{{Name: "__name__", Value: "temperature"}, {Name: "job", Value: "test"}},
+						Samples: []prompb.Sample{{Value: 21.5, Timestamp: 1000}},
+					},
+				},
+				Metadata: []prompb.MetricMetadata{
+					{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "requests_total", Help: "The number of requests."},
+				},
+			},
+		},
+		{
+			name:        "OpenMetrics format",
+			contentType: "application/openmetrics-text; version=0.0.1; charset=utf-8",
+			input: `# TYPE temperature_celsius gauge
+# UNIT temperature_celsius celsius
+# HELP temperature_celsius The temperature.
+temperature_celsius 21.5 2
+# EOF
+`,
+			wreq: &prompb.WriteRequest{
+				Timeseries: []prompb.TimeSeries{
+					{
+						Labels:  []prompb.Label{{Name: "__name__", Value: "temperature_celsius"}, {Name: "job", Value: "test"}},
+						Samples: []prompb.Sample{{Value: 21.5, Timestamp: 2000}},
+					},
+				},
+				Metadata: []prompb.MetricMetadata{
+					{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "temperature_celsius", Help: "The temperature.", Unit: "celsius"},
+				},
+			},
+		},
+	} {
+		wreq, err := parseExposition([]byte(tc.input), tc.contentType, labels.FromStrings("job", "test"), 1000)
+		if err != nil {
+			t.Errorf("test case %s: unexpected error: %v", tc.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(tc.wreq, wreq) {
+			t.Errorf("test case %s: expected write request %v, got %v", tc.name, tc.wreq, wreq)
+		}
+	}
+
+	if _, err := parseExposition([]byte("invalid metric{\n"), "", nil, 1000); err == nil {
+		t.Error("expected error parsing invalid exposition")
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/relabel"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+// relabeler is implemented by hashrings holding relabel configs of their tenants.
+type relabeler interface {
+	RelabelConfigs(tenant string) RelabelConfigs
+}
+
+// relabelRequest applies the relabel configs to the time series of the write request of the tenant.
+// It returns write requests by tenant, as time series relabeled to a non-empty TenantLabel move to the tenant of
+// its value, and the number of dropped time series. Time series relabeled to a tenant which is not valid are dropped. Metadata goes to every tenant with time series of its
+// metric family, to the given tenant if the request has no time series of the family, and is dropped along with
+// all time series of its family otherwise.
+func relabelRequest(tenant string, wreq *prompb.WriteRequest, cfgs RelabelConfigs) (map[string]*prompb.WriteRequest, int) {
+	var (
+		wreqs    = map[string]*prompb.WriteRequest{}
+		families = map[string]map[string]struct{}{}
+		dropped  int
+		lset     labels.Labels
+	)
+	request := func(t string) *prompb.WriteRequest {
+		wr, ok := wreqs[t]
+		if !ok {
+			wr = &prompb.WriteRequest{}
+			wreqs[t] = wr
+		}
+		return wr
+	}
+
+	for _, ts := range wreq.Timeseries {
+		lset = lset[:0]
+		var family string
+		for _, l := range ts.Labels {
+			lset = append(lset, labels.Label{Name: l.Name, Value: l.Value})
+			if l.Name == labels.MetricName {
+				family = l.Value
+			}
+		}
+		if _, ok := families[family]; !ok {
+			families[family] = map[string]struct{}{}
+		}
+
+		rlset := relabel.Process(lset, cfgs...)
+		if rlset == nil {
+			dropped++
+			continue
+		}
+		t := tenant
+		if v := rlset.Get(TenantLabel); v != "" {
+			// The tenant names the directory of its TSDB, so it must not be able to point elsewhere.
+			if !validTenant(v) {
+				dropped++
+				continue
+			}
+			t = v
+		}
+		families[family][t] = struct{}{}
+
+		series := prompb.TimeSeries{Labels: make([]prompb.Label, 0, len(rlset)), Samples: ts.Samples}
+		for _, l := range rlset {
+			if l.Name == TenantLabel {
+				continue
+			}
+			series.Labels = append(series.Labels, prompb.Label{Name: l.Name, Value: l.Value})
+		}
+		wr := request(t)
+		wr.Timeseries = append(wr.Timeseries, series)
+	}
+
+	for _, m := range wreq.Metadata {
+		tenants, ok := families[m.MetricFamilyName]
+		if !ok {
+			wr := request(tenant)
+			wr.Metadata = append(wr.Metadata, m)
+			continue
+		}
+		for t := range tenants {
+			wr := request(t)
+			wr.Metadata = append(wr.Metadata, m)
+		}
+	}
+	return wreqs, dropped
+}
+
+// validTenant returns true if the tenant consists of ASCII letters, digits, '_' and '-' only.
+func validTenant(tenant string) bool {
+	if tenant == "" {
+		return false
+	}
+	for _, c := range tenant {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
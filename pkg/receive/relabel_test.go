@@ -0,0 +1,119 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+func TestRelabelRequest(t *testing.T) {
+	var cfgs RelabelConfigs
+	if err := json.Unmarshal([]byte(`[
+		{"source_labels": ["team"], "target_label": "__tenant__"},
+		{"action": "labeldrop", "regex": "team"},
+		{"source_labels": ["__name__"], "regex": "debug_.*", "action": "drop"},
+		{"target_label": "receive", "replacement": "true"}
+	]`), &cfgs); err != nil {
+		t.Fatalf("unexpectedly failed parsing relabel configs: %v", err)
+	}
+
+	wreq := &prompb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "team", Value: "a"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "team", Value: "b"}},
+				Samples: []prompb.Sample{{Value: 0, Timestamp: 1}},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "instance", Value: "c"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 2}},
+			},
+			{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "debug_info"}, {Name: "team", Value: "a"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+			},
+		},
+		Metadata: []prompb.MetricMetadata{
+			{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up"},
+			{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "debug_info"},
+			{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "requests_total"},
+		},
+	}
+
+	wreqs, dropped := relabelRequest("default", wreq, cfgs)
+	if dropped != 1 {
+		t.Errorf("expected 1 dropped series, got %d", dropped)
+	}
+	expected := map[string]*prompb.WriteRequest{
+		"a": {
+			Timeseries: []prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "receive", Value: "true"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+			}},
+			Metadata: []prompb.MetricMetadata{{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up"}},
+		},
+		"b": {
+			Timeseries: []prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "receive", Value: "true"}},
+				Samples: []prompb.Sample{{Value: 0, Timestamp: 1}},
+			}},
+			Metadata: []prompb.MetricMetadata{{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up"}},
+		},
+		"default": {
+			Timeseries: []prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "instance", Value: "c"}, {Name: "receive", Value: "true"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 2}},
+			}},
+			Metadata: []prompb.MetricMetadata{
+				{Type: prompb.MetricMetadata_GAUGE, MetricFamilyName: "up"},
+				{Type: prompb.MetricMetadata_COUNTER, MetricFamilyName: "requests_total"},
+			},
+		},
+	}
+	if !reflect.DeepEqual(expected, wreqs) {
+		t.Errorf("expected write requests %v, got %v", expected, wreqs)
+	}
+}
+
+func TestRelabelRequestInvalidTenant(t *testing.T) {
+	var cfgs RelabelConfigs
+	if err := json.Unmarshal([]byte(`[
+		{"source_labels": ["team"], "target_label": "__tenant__"},
+		{"action": "labeldrop", "regex": "team"}
+	]`), &cfgs); err != nil {
+		t.Fatalf("unexpectedly failed parsing relabel configs: %v", err)
+	}
+
+	wreq := &prompb.WriteRequest{}
+	for _, team := range []string{"..", ".", "../../etc", "a/b", `a\b`, "a.b", "a b", "ä", "team-a_1"} {
+		wreq.Timeseries = append(wreq.Timeseries, prompb.TimeSeries{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "team", Value: team}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+		})
+	}
+
+	// Series relabeled to invalid tenants are dropped rather than written for another tenant.
+	wreqs, dropped := relabelRequest("default", wreq, cfgs)
+	if dropped != 8 {
+		t.Errorf("expected 8 dropped series, got %d", dropped)
+	}
+	expected := map[string]*prompb.WriteRequest{
+		"team-a_1": {
+			Timeseries: []prompb.TimeSeries{{
+				Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+			}},
+		},
+	}
+	if !reflect.DeepEqual(expected, wreqs) {
+		t.Errorf("expected write requests %v, got %v", expected, wreqs)
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// maxDecodedWriteRequestSize is the maximum size of decompressed write requests of write streams. It is the default
+// maximum size of messages received by gRPC servers, which bounds write requests received with RemoteWrite, so small
+// requests cannot decompress to exhaust the memory of the receiver.
+const maxDecodedWriteRequestSize = 4 << 20
+
+var (
+	// Encoders and decoders with valid options never fail to be created, and are safe for concurrent use.
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxDecodedWriteRequestSize))
+)
+
+// ParseCompression parses the name of a write stream compression.
+func ParseCompression(name string) (storepb.Compression, error) {
+	c, ok := storepb.Compression_value[strings.ToUpper(name)]
+	if !ok {
+		return 0, errors.Errorf("unknown compression %q", name)
+	}
+	return storepb.Compression(c), nil
+}
+
+// encodeWriteRequest marshals the write request and compresses it with the given compression.
+func encodeWriteRequest(r *storepb.WriteRequest, c storepb.Compression) ([]byte, error) {
+	b, err := proto.Marshal(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshal write request")
+	}
+	switch c {
+	case storepb.Compression_NONE:
+		return b, nil
+	case storepb.Compression_SNAPPY:
+		return snappy.Encode(nil, b), nil
+	case storepb.Compression_ZSTD:
+		return zstdEncoder.EncodeAll(b, nil), nil
+	default:
+		return nil, errors.Errorf("unknown compression %s", c)
+	}
+}
+
+// decodeWriteRequest decompresses and unmarshals the write request of a write stream request.
+func decodeWriteRequest(r *storepb.WriteStreamRequest) (*storepb.WriteRequest, error) {
+	var (
+		b   []byte
+		err error
+	)
+	switch r.Compression {
+	case storepb.Compression_NONE:
+		b = r.Data
+	case storepb.Compression_SNAPPY:
+		var n int
+		if n, err = snappy.DecodedLen(r.Data); err == nil && n > maxDecodedWriteRequestSize {
+			err = errors.Errorf("decoded size %d exceeds limit of %d bytes", n, maxDecodedWriteRequestSize)
+		}
+		if err == nil {
+			b, err = snappy.Decode(nil, r.Data)
+		}
+	case storepb.Compression_ZSTD:
+		b, err = zstdDecoder.DecodeAll(r.Data, nil)
+	default:
+		return nil, errors.Errorf("unknown compression %s", r.Compression)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s decode", r.Compression)
+	}
+
+	var wreq storepb.WriteRequest
+	if err := proto.Unmarshal(b, &wreq); err != nil {
+		return nil, errors.Wrap(err, "unmarshal write request")
+	}
+	return &wreq, nil
+}
+
+// maxConcurrentStreamWrites is the maximum number of requests of a single write stream written concurrently.
+const maxConcurrentStreamWrites = 64
+
+// RemoteWriteStream implements the gRPC streaming remote write handler for storepb.WriteableStore.
+// Requests of a stream are written concurrently, up to maxConcurrentStreamWrites at a time, and acknowledged with a
+// response of their sequence number in the order they complete. Requests are not read while the stream is at its
+// limit, so gRPC flow control pushes back on senders writing faster than the receiver. Each request is abandoned
+// after the timeout given by its sender.
+func (h *Handler) RemoteWriteStream(srv storepb.WriteableStore_RemoteWriteStreamServer) error {
+	var (
+		wg      sync.WaitGroup
+		sendMtx sync.Mutex
+		sendErr error
+		turns   = make(chan struct{}, maxConcurrentStreamWrites)
+	)
+	for {
+		req, err := srv.Recv()
+		if err != nil {
+			// The stream must not be used once the handler returns.
+			wg.Wait()
+			if err == io.EOF {
+				err = sendErr
+			}
+			return err
+		}
+
+		turns <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-turns }()
+
+			ctx := srv.Context()
+			if req.TimeoutMs > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, time.Duration(req.TimeoutMs)*time.Millisecond)
+				defer cancel()
+			}
+			resp := &storepb.WriteStreamResponse{Seq: req.Seq}
+			if err := h.writeStreamRequest(ctx, req); err != nil {
+				st := status.Convert(err)
+				resp.Code = int32(st.Code())
+				resp.Message = st.Message()
+			}
+
+			// gRPC streams do not support concurrent sends. A failed send breaks the stream, so later responses
+			// are dropped and the error is returned once the handler stops receiving.
+			sendMtx.Lock()
+			defer sendMtx.Unlock()
+			if sendErr == nil {
+				sendErr = srv.Send(resp)
+			}
+		}()
+	}
+}
+
+func (h *Handler) writeStreamRequest(ctx context.Context, req *storepb.WriteStreamRequest) error {
+	wreq, err := decodeWriteRequest(req)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return h.remoteWrite(ctx, wreq)
+}
+
+// writeStream multiplexes write requests to a peer over a single write stream.
+// Responses are matched to the requests by their sequence number.
+type writeStream struct {
+	stream storepb.WriteableStore_RemoteWriteStreamClient
+	cancel context.CancelFunc
+
+	// sendMtx serializes sending, as gRPC streams do not support concurrent sends.
+	sendMtx sync.Mutex
+	seq     uint64
+
+	mtx     sync.Mutex
+	pending map[uint64]chan error
+	// err is the error the stream broke with, after which it does not accept requests anymore.
+	err error
+}
+
+// newWriteStream opens a write stream to the peer. The stream outlives the requests written over it, until it breaks.
+func newWriteStream(c storepb.WriteableStoreClient) (*writeStream, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := c.RemoteWriteStream(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	s := &writeStream{
+		stream:  stream,
+		cancel:  cancel,
+		pending: map[uint64]chan error{},
+	}
+	go s.receive()
+	return s, nil
+}
+
+// receive hands the responses of the stream to the pending requests, until the stream breaks.
+func (s *writeStream) receive() {
+	for {
+		resp, err := s.stream.Recv()
+		if err != nil {
+			s.fail(err)
+			return
+		}
+
+		s.mtx.Lock()
+		ch, ok := s.pending[resp.Seq]
+		delete(s.pending, resp.Seq)
+		s.mtx.Unlock()
+		if !ok {
+			continue
+		}
+		if code := codes.Code(resp.Code); code != codes.OK {
+			ch <- status.Error(code, resp.Message)
+			continue
+		}
+		ch <- nil
+	}
+}
+
+// fail breaks the stream, failing all pending requests with the given error.
+func (s *writeStream) fail(err error) {
+	if err == io.EOF {
+		err = status.Error(codes.Unavailable, "write stream closed by peer")
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.err == nil {
+		s.err = err
+	}
+	for seq, ch := range s.pending {
+		ch <- s.err
+		delete(s.pending, seq)
+	}
+	s.cancel()
+}
+
+// broken returns whether the stream broke.
+func (s *writeStream) broken() bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	return s.err != nil
+}
+
+// write sends the write request compressed with the given compression and waits for its response.
+func (s *writeStream) write(ctx context.Context, r *storepb.WriteRequest, c storepb.Compression) error {
+	data, err := encodeWriteRequest(r, c)
+	if err != nil {
+		return err
+	}
+
+	// Responses are buffered, so the stream never waits for requests which gave up.
+	ch := make(chan error, 1)
+	s.sendMtx.Lock()
+	s.mtx.Lock()
+	if err := s.err; err != nil {
+		s.mtx.Unlock()
+		s.sendMtx.Unlock()
+		return err
+	}
+	s.seq++
+	seq := s.seq
+	s.pending[seq] = ch
+	s.mtx.Unlock()
+	req := &storepb.WriteStreamRequest{Seq: seq, Compression: c, Data: data}
+	if deadline, ok := ctx.Deadline(); ok {
+		// The peer abandons the request when the sender stops waiting for it. Requests with less than a millisecond
+		// left are given one, as 0 means no timeout.
+		req.TimeoutMs = int64(time.Until(deadline) / time.Millisecond)
+		if req.TimeoutMs <= 0 {
+			req.TimeoutMs = 1
+		}
+	}
+	err = s.stream.Send(req)
+	s.sendMtx.Unlock()
+	// A failed send aborts the stream. If the peer aborted it, the send fails with io.EOF and the status of the stream
+	// is handed to the request by receive.
+	if err != nil && err != io.EOF {
+		s.fail(err)
+		return err
+	}
+
+	select {
+	case err := <-ch:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close breaks the stream.
+func (s *writeStream) close() {
+	s.fail(status.Error(codes.Canceled, "write stream closed"))
+}
@@ -0,0 +1,263 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/golang/snappy"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+	"github.com/thanos-io/thanos/pkg/store/storepb/prompb"
+)
+
+func TestEncodeWriteRequest(t *testing.T) {
+	wreq := &storepb.WriteRequest{
+		Timeseries: []prompb.TimeSeries{
+			{
+				Labels:  []prompb.Label{{Name: "foo", Value: "bar"}},
+				Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+			},
+		},
+		Tenant:  "tenant",
+		Replica: 1,
+	}
+	for _, name := range []string{"none", "snappy", "zstd"} {
+		c, err := ParseCompression(name)
+		if err != nil {
+			t.Fatalf("%s: unexpected error parsing compression: %v", name, err)
+		}
+		data, err := encodeWriteRequest(wreq, c)
+		if err != nil {
+			t.Fatalf("%s: unexpected error encoding: %v", name, err)
+		}
+		got, err := decodeWriteRequest(&storepb.WriteStreamRequest{Compression: c, Data: data})
+		if err != nil {
+			t.Fatalf("%s: unexpected error decoding: %v", name, err)
+		}
+		if got.String() != wreq.String() {
+			t.Errorf("%s: expected %v, got %v", name, wreq, got)
+		}
+	}
+
+	if _, err := ParseCompression("gzip"); err == nil {
+		t.Error("expected error parsing unknown compression")
+	}
+	if _, err := decodeWriteRequest(&storepb.WriteStreamRequest{Compression: storepb.Compression_SNAPPY, Data: []byte("not snappy")}); err == nil {
+		t.Error("expected error decoding corrupted request")
+	}
+
+	// Requests decompressing beyond the limit are not decoded.
+	large := make([]byte, 2*maxDecodedWriteRequestSize)
+	for c, data := range map[storepb.Compression][]byte{
+		storepb.Compression_SNAPPY: snappy.Encode(nil, large),
+		storepb.Compression_ZSTD:   zstdEncoder.EncodeAll(large, nil),
+	} {
+		if _, err := decodeWriteRequest(&storepb.WriteStreamRequest{Compression: c, Data: data}); err == nil {
+			t.Errorf("%s: expected error decoding request beyond the size limit", c)
+		}
+	}
+}
+
+func TestReceiveWriteStream(t *testing.T) {
+	appendable := &fakeAppendable{appender: newFakeAppender(nil, nil, nil, nil)}
+	ingestor := NewHandler(nil, &Options{
+		ReceiverMode: IngestorOnly,
+		Writer:       NewWriter(log.NewNopLogger(), newFakeTenantAppendable(appendable)),
+	})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %v", err)
+	}
+	srv := grpc.NewServer()
+	storepb.RegisterWriteableStoreServer(srv, ingestor)
+	go func() { _ = srv.Serve(l) }()
+	defer srv.Stop()
+
+	wreq := func(i int, replica int64) *storepb.WriteRequest {
+		return &storepb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{
+				{
+					Labels:  []prompb.Label{{Name: "foo", Value: fmt.Sprintf("%d", i)}},
+					Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+				},
+			},
+			Tenant:  "tenant",
+			Replica: replica,
+		}
+	}
+
+	for _, c := range []storepb.Compression{storepb.Compression_NONE, storepb.Compression_SNAPPY, storepb.Compression_ZSTD} {
+		peers := newPeerGroup(c, grpc.WithInsecure())
+		cl, err := peers.get(context.Background(), l.Addr().String())
+		if err != nil {
+			t.Fatalf("%s: unexpected error dialing: %v", c, err)
+		}
+
+		// Requests are multiplexed over one stream.
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs <- peers.write(context.Background(), l.Addr().String(), cl, wreq(int(c)*10+i, 1))
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			if err != nil {
+				t.Fatalf("%s: unexpected error writing: %v", c, err)
+			}
+		}
+		if len(peers.streams) != 1 {
+			t.Fatalf("%s: expected 1 write stream, got %d", c, len(peers.streams))
+		}
+		s := peers.streams[l.Addr().String()]
+
+		// Failed requests are acknowledged with their status, without breaking the stream.
+		err = peers.write(context.Background(), l.Addr().String(), cl, wreq(0, 0))
+		if status.Code(err) != codes.InvalidArgument {
+			t.Fatalf("%s: expected invalid argument writing request not routed, got %v", c, err)
+		}
+		if err := peers.write(context.Background(), l.Addr().String(), cl, wreq(int(c)*10, 1)); err != nil {
+			t.Fatalf("%s: unexpected error writing after failed request: %v", c, err)
+		}
+		if s.broken() || peers.streams[l.Addr().String()] != s {
+			t.Fatalf("%s: expected write stream to be reused", c)
+		}
+		s.close()
+	}
+
+	for c := 0; c < 3; c++ {
+		for i := 0; i < 10; i++ {
+			lset := fmt.Sprintf("{foo=\"%d\"}", c*10+i)
+			expected := 1
+			if i == 0 {
+				expected = 2
+			}
+			if got := len(appendable.appender.(*fakeAppender).samples[lset]); got != expected {
+				t.Errorf("labels %s: expected %d samples, got %d", lset, expected, got)
+			}
+		}
+	}
+}
+
+func TestReceiveLimit(t *testing.T) {
+	h := NewHandler(nil, &Options{MaxConcurrentWrites: 1})
+
+	done, err := h.limit(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error waiting for turn: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := h.limit(ctx); err == nil {
+		t.Fatal("expected error waiting for turn beyond the limit")
+	}
+
+	// Write requests forwarded between receivers are not limited.
+	err = h.remoteWrite(context.Background(), &storepb.WriteRequest{Replica: 1})
+	if status.Code(err) == codes.Unavailable {
+		t.Fatalf("unexpected limit of forwarded write request: %v", err)
+	}
+
+	done()
+	done, err = h.limit(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error waiting for turn after the previous write: %v", err)
+	}
+	done()
+}
+
+// fakeWriteStreamServer is a write stream receiving the requests of its channel and sending responses to its other one.
+type fakeWriteStreamServer struct {
+	grpc.ServerStream
+
+	ctx   context.Context
+	reqs  chan *storepb.WriteStreamRequest
+	resps chan *storepb.WriteStreamResponse
+}
+
+func (s *fakeWriteStreamServer) Context() context.Context { return s.ctx }
+
+func (s *fakeWriteStreamServer) Recv() (*storepb.WriteStreamRequest, error) {
+	req, ok := <-s.reqs
+	if !ok {
+		return nil, io.EOF
+	}
+	return req, nil
+}
+
+func (s *fakeWriteStreamServer) Send(resp *storepb.WriteStreamResponse) error {
+	s.resps <- resp
+	return nil
+}
+
+func TestReceiveWriteStreamConcurrentRequests(t *testing.T) {
+	appendable := &fakeAppendable{appender: newFakeAppender(nil, nil, nil, nil)}
+	h := NewHandler(nil, &Options{
+		ReceiverMode:        IngestorOnly,
+		Writer:              NewWriter(log.NewNopLogger(), newFakeTenantAppendable(appendable)),
+		MaxConcurrentWrites: 1,
+	})
+	// Holding the only turn blocks requests which are not forwarded by other receivers.
+	done, err := h.limit(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error waiting for turn: %v", err)
+	}
+	defer done()
+
+	srv := &fakeWriteStreamServer{
+		ctx:   context.Background(),
+		reqs:  make(chan *storepb.WriteStreamRequest),
+		resps: make(chan *storepb.WriteStreamResponse, 2),
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- h.RemoteWriteStream(srv) }()
+
+	wreq := func(replica int64) []byte {
+		data, err := encodeWriteRequest(&storepb.WriteRequest{
+			Timeseries: []prompb.TimeSeries{
+				{
+					Labels:  []prompb.Label{{Name: "foo", Value: "bar"}},
+					Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+				},
+			},
+			Tenant:  "tenant",
+			Replica: replica,
+		}, storepb.Compression_NONE)
+		if err != nil {
+			t.Fatalf("unexpected error encoding: %v", err)
+		}
+		return data
+	}
+	srv.reqs <- &storepb.WriteStreamRequest{Seq: 1, Data: wreq(0), TimeoutMs: 100}
+	srv.reqs <- &storepb.WriteStreamRequest{Seq: 2, Data: wreq(1)}
+
+	// The blocked request does not hold up the later one, and is abandoned after its timeout.
+	if resp := <-srv.resps; resp.Seq != 2 || codes.Code(resp.Code) != codes.OK {
+		t.Fatalf("expected successful response to request 2 first, got %v", resp)
+	}
+	if resp := <-srv.resps; resp.Seq != 1 || codes.Code(resp.Code) != codes.Unavailable {
+		t.Fatalf("expected unavailable response to request 1 after its timeout, got %v", resp)
+	}
+
+	close(srv.reqs)
+	if err := <-errc; err != nil {
+		t.Fatalf("unexpected error closing write stream: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/storage/tsdb"
+	"gopkg.in/yaml.v2"
+)
+
+// TenantsConfig holds options of tenants, overriding the flags.
+type TenantsConfig struct {
+	Tenants []TenantConfig `yaml:"tenants"`
+}
+
+// TenantConfig overrides options of the TSDB of a tenant. Options which are not set default to the flags.
+type TenantConfig struct {
+	Tenant           string          `yaml:"tenant"`
+	Retention        *model.Duration `yaml:"retention"`
+	MinBlockDuration *model.Duration `yaml:"min_block_duration"`
+	MaxBlockDuration *model.Duration `yaml:"max_block_duration"`
+	// IdleTimeout is the duration without writes after which the tenant is evicted. 0 disables eviction of the tenant.
+	IdleTimeout *model.Duration `yaml:"idle_timeout"`
+}
+
+// ParseTenantsConfig parses and validates options of tenants from YAML.
+func ParseTenantsConfig(confYaml []byte) (*TenantsConfig, error) {
+	conf := &TenantsConfig{}
+	if err := yaml.UnmarshalStrict(confYaml, conf); err != nil {
+		return nil, errors.Wrap(err, "parsing tenants config YAML")
+	}
+
+	tenants := map[string]struct{}{}
+	for i, c := range conf.Tenants {
+		if c.Tenant == "" {
+			return nil, errors.Errorf("tenant config %d: tenant is required", i)
+		}
+		if _, ok := tenants[c.Tenant]; ok {
+			return nil, errors.Errorf("tenant config %d: duplicated tenant %q", i, c.Tenant)
+		}
+		tenants[c.Tenant] = struct{}{}
+
+		if c.MinBlockDuration != nil && *c.MinBlockDuration <= 0 {
+			return nil, errors.Errorf("tenant %q: min block duration must be positive", c.Tenant)
+		}
+		if c.MinBlockDuration != nil && c.MaxBlockDuration != nil && *c.MinBlockDuration > *c.MaxBlockDuration {
+			return nil, errors.Errorf("tenant %q: min block duration %s exceeds max block duration %s", c.Tenant, c.MinBlockDuration, c.MaxBlockDuration)
+		}
+	}
+	return conf, nil
+}
+
+func (c *TenantsConfig) tenant(tenantID string) (TenantConfig, bool) {
+	if c == nil {
+		return TenantConfig{}, false
+	}
+	for _, tc := range c.Tenants {
+		if tc.Tenant == tenantID {
+			return tc, true
+		}
+	}
+	return TenantConfig{}, false
+}
+
+// TSDBOptions returns the TSDB options of the tenant: the given defaults with the overrides of the tenant.
+func (c *TenantsConfig) TSDBOptions(tenantID string, defaults *tsdb.Options) *tsdb.Options {
+	opts := *defaults
+	tc, ok := c.tenant(tenantID)
+	if !ok {
+		return &opts
+	}
+	if tc.Retention != nil {
+		opts.RetentionDuration = *tc.Retention
+	}
+	if tc.MinBlockDuration != nil {
+		opts.MinBlockDuration = *tc.MinBlockDuration
+	}
+	if tc.MaxBlockDuration != nil {
+		opts.MaxBlockDuration = *tc.MaxBlockDuration
+	}
+	return &opts
+}
+
+// IdleTimeout returns the idle timeout of the tenant, or the given default.
+func (c *TenantsConfig) IdleTimeout(tenantID string, def time.Duration) time.Duration {
+	tc, ok := c.tenant(tenantID)
+	if !ok || tc.IdleTimeout == nil {
+		return def
+	}
+	return time.Duration(*tc.IdleTimeout)
+}
@@ -0,0 +1,95 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package receive
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/storage/tsdb"
+
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestParseTenantsConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		conf string
+		err  bool
+	}{
+		{
+			name: "valid",
+			conf: `
+tenants:
+- tenant: team-a
+  retention: 30d
+  min_block_duration: 2h
+  max_block_duration: 2h
+  idle_timeout: 0s
+- tenant: team-b
+  idle_timeout: 1h
+`,
+		},
+		{
+			name: "missing tenant",
+			conf: "tenants: [{retention: 30d}]",
+			err:  true,
+		},
+		{
+			name: "duplicated tenant",
+			conf: "tenants: [{tenant: team-a}, {tenant: team-a}]",
+			err:  true,
+		},
+		{
+			name: "min block duration exceeding max block duration",
+			conf: "tenants: [{tenant: team-a, min_block_duration: 4h, max_block_duration: 2h}]",
+			err:  true,
+		},
+		{
+			name: "unknown field",
+			conf: "tenants: [{tenant: team-a, retention_time: 30d}]",
+			err:  true,
+		},
+	} {
+		_, err := ParseTenantsConfig([]byte(tc.conf))
+		if tc.err {
+			testutil.NotOk(t, err, tc.name)
+			continue
+		}
+		testutil.Ok(t, err, tc.name)
+	}
+}
+
+func TestTenantsConfigOptions(t *testing.T) {
+	conf, err := ParseTenantsConfig([]byte(`
+tenants:
+- tenant: team-a
+  retention: 30d
+  max_block_duration: 6h
+  idle_timeout: 0s
+`))
+	testutil.Ok(t, err)
+
+	defaults := &tsdb.Options{
+		RetentionDuration: model.Duration(15 * 24 * time.Hour),
+		MinBlockDuration:  model.Duration(2 * time.Hour),
+		MaxBlockDuration:  model.Duration(2 * time.Hour),
+		WALCompression:    true,
+	}
+	testutil.Equals(t, &tsdb.Options{
+		RetentionDuration: model.Duration(30 * 24 * time.Hour),
+		MinBlockDuration:  model.Duration(2 * time.Hour),
+		MaxBlockDuration:  model.Duration(6 * time.Hour),
+		WALCompression:    true,
+	}, conf.TSDBOptions("team-a", defaults))
+	testutil.Equals(t, defaults, conf.TSDBOptions("team-b", defaults))
+	testutil.Equals(t, time.Duration(0), conf.IdleTimeout("team-a", time.Hour))
+	testutil.Equals(t, time.Hour, conf.IdleTimeout("team-b", time.Hour))
+
+	// Without tenants config, all tenants get the defaults.
+	var none *TenantsConfig
+	testutil.Equals(t, defaults, none.TSDBOptions("team-a", defaults))
+	testutil.Equals(t, time.Hour, none.IdleTimeout("team-a", time.Hour))
+}
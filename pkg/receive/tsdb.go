@@ -4,18 +4,31 @@
 package receive
 
 import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/storage/tsdb"
 	promtsdb "github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/tombstones"
+	"github.com/thanos-io/thanos/pkg/runutil"
 )
 
+// cutFilename is the file in the TSDB directory of a WAL-only storage recording up to where the head was cut.
+const cutFilename = "thanos.cut.json"
+
 type FlushableStorage struct {
 	*promtsdb.DB
 
@@ -24,6 +37,10 @@ type FlushableStorage struct {
 	r    prometheus.Registerer
 	opts *tsdb.Options
 
+	// shipDir is the directory blocks are cut into from the head in WAL-only mode; empty otherwise.
+	shipDir   string
+	compactor *promtsdb.LeveledCompactor
+
 	stopped bool
 	mu      sync.Mutex
 }
@@ -39,6 +56,14 @@ func NewFlushableStorage(path string, l log.Logger, r prometheus.Registerer, opt
 	}
 }
 
+// NewWALOnlyStorage returns a new storage which keeps only the WAL and head of a TSDB database. Instead of being
+// compacted and retained locally, blocks are cut from the head into shipDir by CutHead.
+func NewWALOnlyStorage(path string, l log.Logger, r prometheus.Registerer, opts *tsdb.Options, shipDir string) *FlushableStorage {
+	f := NewFlushableStorage(path, l, r, opts)
+	f.shipDir = shipDir
+	return f
+}
+
 // Get returns a reference to the underlying storage.
 func (f *FlushableStorage) Get() *promtsdb.DB {
 	return f.DB
@@ -67,14 +92,109 @@ func (f *FlushableStorage) open() error {
 	if err != nil {
 		return err
 	}
+	if f.shipDir != "" {
+		if err := f.openWALOnly(db); err != nil {
+			runutil.CloseWithLogOnErr(f.l, db, "close TSDB")
+			return err
+		}
+	}
 	f.DB = db
 	f.stopped = false
 	return nil
 }
 
+func (f *FlushableStorage) openWALOnly(db *promtsdb.DB) error {
+	db.DisableCompactions()
+
+	if err := os.MkdirAll(f.shipDir, 0777); err != nil {
+		return errors.Wrap(err, "create ship dir")
+	}
+	if f.compactor == nil {
+		c, err := promtsdb.NewLeveledCompactor(context.Background(), nil, log.With(f.l, "component", "compactor"), []int64{f.blockRange()}, chunkenc.NewPool())
+		if err != nil {
+			return errors.Wrap(err, "create compactor")
+		}
+		f.compactor = c
+	}
+
+	// Samples replayed from the WAL which were already cut into blocks must not be cut again.
+	cut, err := readCutTime(f.path)
+	if err != nil {
+		return err
+	}
+	if cut == math.MinInt64 {
+		return nil
+	}
+	return errors.Wrap(db.Head().Truncate(cut), "truncate head to last cut")
+}
+
+func (f *FlushableStorage) blockRange() int64 {
+	return int64(time.Duration(f.opts.MinBlockDuration) / time.Millisecond)
+}
+
+// CutHead cuts the complete block ranges of the head into blocks in the ship directory and truncates the head after
+// them, leaving between one and one and a half block ranges of the most recent samples in the head.
+// It is a no-op for storages which are not WAL-only.
+func (f *FlushableStorage) CutHead() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.shipDir == "" || f.stopped {
+		return nil
+	}
+
+	head := f.DB.Head()
+	brange := f.blockRange()
+	for head.MaxTime()-head.MinTime() > brange/2*3 {
+		mint := head.MinTime()
+		if err := f.cut(mint, mint-mint%brange+brange); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cut writes the samples of the head in [mint, maxt) to a block in the ship directory and truncates the head to maxt.
+func (f *FlushableStorage) cut(mint, maxt int64) error {
+	head := f.DB.Head()
+	id, err := f.compactor.Write(f.shipDir, &headRange{head: head, mint: mint, maxt: maxt - 1}, mint, maxt, nil)
+	if err != nil {
+		return errors.Wrap(err, "cut block from head")
+	}
+	level.Debug(f.l).Log("msg", "cut block from head", "ulid", id, "mint", mint, "maxt", maxt)
+
+	// Record the cut before truncating, so a crash in between does not cut the same samples twice.
+	if err := writeCutTime(f.path, maxt); err != nil {
+		return err
+	}
+	return errors.Wrap(head.Truncate(maxt), "truncate head")
+}
+
+// flushWALOnly cuts the whole head into a block in the ship directory and removes the WAL.
+func (f *FlushableStorage) flushWALOnly() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.open(); err != nil {
+		return errors.Wrap(err, "opening storage")
+	}
+	if head := f.DB.Head(); head.NumSeries() > 0 && head.MinTime() <= head.MaxTime() {
+		if err := f.cut(head.MinTime(), head.MaxTime()+1); err != nil {
+			return err
+		}
+	}
+	if err := f.DB.Close(); err != nil {
+		return errors.Wrap(err, "stopping storage")
+	}
+	f.stopped = true
+	return errors.Wrap(os.RemoveAll(filepath.Join(f.path, "wal")), "removing stale WAL")
+}
+
 // Flush temporarily stops the storage and flushes the WAL to blocks.
+// In WAL-only mode, the head is cut into a block in the ship directory instead.
 // Note: this operation leaves the storage closed.
 func (f *FlushableStorage) Flush() error {
+	if f.shipDir != "" {
+		return f.flushWALOnly()
+	}
 	_, err := os.Stat(filepath.Join(f.path, "wal"))
 	if os.IsNotExist(err) {
 		level.Info(f.l).Log("msg", "No WAL was found for flushing; ignoring.")
@@ -116,6 +236,92 @@ func (f *FlushableStorage) Close() error {
 	return nil
 }
 
+type cutMeta struct {
+	MaxTime int64 `json:"max_time"`
+}
+
+// readCutTime returns up to where the head in dir was cut into blocks, or math.MinInt64 if it never was.
+func readCutTime(dir string) (int64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, cutFilename))
+	if os.IsNotExist(err) {
+		return math.MinInt64, nil
+	}
+	if err != nil {
+		return 0, errors.Wrap(err, "read cut file")
+	}
+	var m cutMeta
+	if err := json.Unmarshal(b, &m); err != nil {
+		return 0, errors.Wrap(err, "unmarshal cut file")
+	}
+	return m.MaxTime, nil
+}
+
+func writeCutTime(dir string, maxt int64) error {
+	b, err := json.Marshal(cutMeta{MaxTime: maxt})
+	if err != nil {
+		return errors.Wrap(err, "marshal cut file")
+	}
+	path := filepath.Join(dir, cutFilename)
+	// Write to a temporary file and rename it, so the cut file is never partially written.
+	if err := ioutil.WriteFile(path+".tmp", b, 0666); err != nil {
+		return errors.Wrap(err, "write cut file")
+	}
+	return errors.Wrap(os.Rename(path+".tmp", path), "rename cut file")
+}
+
+// headRange is a block reader of the chunks of a head overlapping [mint, maxt].
+type headRange struct {
+	head       *promtsdb.Head
+	mint, maxt int64
+}
+
+func (h *headRange) Index() (promtsdb.IndexReader, error) {
+	ir, err := h.head.Index()
+	if err != nil {
+		return nil, err
+	}
+	return &headRangeIndexReader{IndexReader: ir, mint: h.mint, maxt: h.maxt}, nil
+}
+
+func (h *headRange) Chunks() (promtsdb.ChunkReader, error) {
+	return h.head.Chunks()
+}
+
+func (h *headRange) Tombstones() (tombstones.Reader, error) {
+	return h.head.Tombstones()
+}
+
+func (h *headRange) Meta() promtsdb.BlockMeta {
+	return promtsdb.BlockMeta{
+		MinTime: h.mint,
+		MaxTime: h.maxt,
+		ULID:    h.head.Meta().ULID,
+		Stats: promtsdb.BlockStats{
+			NumSeries: h.head.NumSeries(),
+		},
+	}
+}
+
+type headRangeIndexReader struct {
+	promtsdb.IndexReader
+	mint, maxt int64
+}
+
+// Series returns the series of ref with only its chunks overlapping the range.
+func (r *headRangeIndexReader) Series(ref uint64, lset *labels.Labels, chks *[]chunks.Meta) error {
+	if err := r.IndexReader.Series(ref, lset, chks); err != nil {
+		return err
+	}
+	filtered := (*chks)[:0]
+	for _, c := range *chks {
+		if c.OverlapsClosedInterval(r.mint, r.maxt) {
+			filtered = append(filtered, c)
+		}
+	}
+	*chks = filtered
+	return nil
+}
+
 // UnRegisterer is a Prometheus registerer that
 // ensures that collectors can be registered
 // by unregistering already-registered collectors.
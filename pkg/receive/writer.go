@@ -24,6 +24,8 @@ type Appendable interface {
 
 type TenantStorage interface {
 	TenantAppendable(string) (Appendable, error)
+	// WriteMetadata stores metadata of metric families of the tenant.
+	WriteMetadata(string, []prompb.MetricMetadata) error
 }
 
 type Writer struct {
@@ -104,6 +106,12 @@ func (r *Writer) Write(tenantID string, wreq *prompb.WriteRequest) error {
 		errs.Add(errors.Wrap(err, "commit samples"))
 	}
 
+	if len(wreq.Metadata) > 0 {
+		if err := r.multiTSDB.WriteMetadata(tenantID, wreq.Metadata); err != nil {
+			errs.Add(errors.Wrap(err, "write metadata"))
+		}
+	}
+
 	return errs.Err()
 }
 
@@ -119,9 +127,18 @@ func (t *fakeTenantAppendable) TenantAppendable(tenantID string) (Appendable, er
 	return t.f, nil
 }
 
+func (t *fakeTenantAppendable) WriteMetadata(tenantID string, md []prompb.MetricMetadata) error {
+	t.f.Lock()
+	defer t.f.Unlock()
+	t.f.metadata = append(t.f.metadata, md...)
+	return nil
+}
+
 type fakeAppendable struct {
+	sync.Mutex
 	appender    storage.Appender
 	appenderErr func() error
+	metadata    []prompb.MetricMetadata
 }
 
 var _ Appendable = &fakeAppendable{}
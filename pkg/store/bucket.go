@@ -8,6 +8,7 @@ import (
 	"context"
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"math"
@@ -17,6 +18,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -84,6 +86,18 @@ const (
 	partitionerMaxGapSize = 512 * 1024
 )
 
+var (
+	castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+	// errCorruptedChunk is returned when a chunk read from the bucket fails its checksum, or is cut off by the end
+	// of its object. Other short reads are not reported as corruption, since they can be caused by transient object
+	// storage errors.
+	errCorruptedChunk = errors.New("corrupted chunk")
+	// errCorruptedSeries is returned when a series entry read from the block index fails its checksum, or is cut off
+	// by the end of the index.
+	errCorruptedSeries = errors.New("corrupted series index entry")
+)
+
 type bucketStoreMetrics struct {
 	blocksLoaded          prometheus.Gauge
 	blockLoads            prometheus.Counter
@@ -102,6 +116,9 @@ type bucketStoreMetrics struct {
 	queriesDropped        prometheus.Counter
 	queriesLimit          prometheus.Gauge
 	seriesRefetches       prometheus.Counter
+	blockCorruptions      *prometheus.CounterVec
+	blockQuarantines      prometheus.Counter
+	blocksMarkedNoServe   prometheus.Counter
 
 	cachedPostingsCompressions           *prometheus.CounterVec
 	cachedPostingsCompressionErrors      *prometheus.CounterVec
@@ -192,6 +209,19 @@ func newBucketStoreMetrics(reg prometheus.Registerer) *bucketStoreMetrics {
 		Help: fmt.Sprintf("Total number of cases where %v bytes was not enough was to fetch series from index, resulting in refetch.", maxSeriesSize),
 	})
 
+	m.blockCorruptions = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_block_corruptions_total",
+		Help: "Total number of requests that failed because of corrupted block data.",
+	}, []string{"reason"})
+	m.blockQuarantines = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_block_quarantines_total",
+		Help: "Total number of blocks that are no longer served because of corrupted data.",
+	})
+	m.blocksMarkedNoServe = promauto.With(reg).NewCounter(prometheus.CounterOpts{
+		Name: "thanos_bucket_store_blocks_marked_for_no_serve_total",
+		Help: "Total number of blocks marked for no serve.",
+	})
+
 	m.cachedPostingsCompressions = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
 		Name: "thanos_bucket_store_cached_postings_compressions_total",
 		Help: "Number of postings compressions before storing to index cache.",
@@ -221,6 +251,15 @@ type FilterConfig struct {
 	MinTime, MaxTime model.TimeOrDurationValue
 }
 
+// QuarantineConfig configures how Store handles blocks with corrupted data.
+type QuarantineConfig struct {
+	// CorruptionThreshold is the number of requests failing because of corrupted data of a block after
+	// which the block is no longer served. Zero disables quarantine.
+	CorruptionThreshold int64
+	// Bucket is used to upload the no-serve mark of quarantined blocks. If nil, blocks are only unloaded.
+	Bucket objstore.Bucket
+}
+
 // BucketStore implements the store API backed by a bucket. It loads all index
 // files to local disk.
 type BucketStore struct {
@@ -236,6 +275,8 @@ type BucketStore struct {
 	mtx       sync.RWMutex
 	blocks    map[ulid.ULID]*bucketBlock
 	blockSets map[uint64]*bucketBlockSet
+	// Blocks no longer served because of corrupted data.
+	quarantined map[ulid.ULID]*quarantinedBlock
 
 	// Verbose enabled additional logging.
 	debugLogging bool
@@ -262,6 +303,18 @@ type BucketStore struct {
 
 	// Enables hints in the Series() response.
 	enableSeriesHints bool
+
+	quarantineConfig *QuarantineConfig
+}
+
+type quarantinedBlock struct {
+	block   *bucketBlock
+	reason  metadata.NoServeReason
+	details string
+	// unloaded is true once the block resources have been released.
+	unloaded bool
+	// marked is true once the no-serve mark has been uploaded.
+	marked bool
 }
 
 // NewBucketStore creates a new bucket backed store that implements the store API against
@@ -284,6 +337,7 @@ func NewBucketStore(
 	enablePostingsCompression bool,
 	postingOffsetsInMemSampling int,
 	enableSeriesHints bool, // TODO(pracucci) Thanos 0.12 and below doesn't gracefully handle new fields in SeriesResponse. Drop this flag and always enable hints once we can drop backward compatibility.
+	quarantineConfig *QuarantineConfig,
 ) (*BucketStore, error) {
 	if logger == nil {
 		logger = log.NewNopLogger()
@@ -308,6 +362,7 @@ func NewBucketStore(
 		chunkPool:            chunkPool,
 		blocks:               map[ulid.ULID]*bucketBlock{},
 		blockSets:            map[uint64]*bucketBlockSet{},
+		quarantined:          map[ulid.ULID]*quarantinedBlock{},
 		debugLogging:         debugLogging,
 		blockSyncConcurrency: blockSyncConcurrency,
 		filterConfig:         filterConfig,
@@ -322,6 +377,7 @@ func NewBucketStore(
 		enablePostingsCompression:   enablePostingsCompression,
 		postingOffsetsInMemSampling: postingOffsetsInMemSampling,
 		enableSeriesHints:           enableSeriesHints,
+		quarantineConfig:            quarantineConfig,
 	}
 	s.metrics = metrics
 
@@ -342,6 +398,11 @@ func (s *BucketStore) Close() (err error) {
 	for _, b := range s.blocks {
 		runutil.CloseWithErrCapture(&err, b, "closing Bucket Block")
 	}
+	for _, q := range s.quarantined {
+		if !q.unloaded {
+			runutil.CloseWithErrCapture(&err, q.block, "closing quarantined Bucket Block")
+		}
+	}
 	return err
 }
 
@@ -354,6 +415,8 @@ func (s *BucketStore) SyncBlocks(ctx context.Context) error {
 		return metaFetchErr
 	}
 
+	s.syncQuarantined(ctx, metas)
+
 	var wg sync.WaitGroup
 	blockc := make(chan *metadata.Meta)
 
@@ -373,6 +436,9 @@ func (s *BucketStore) SyncBlocks(ctx context.Context) error {
 		if b := s.getBlock(id); b != nil {
 			continue
 		}
+		if s.isQuarantined(id) {
+			continue
+		}
 		select {
 		case <-ctx.Done():
 		case blockc <- meta:
@@ -447,6 +513,90 @@ func (s *BucketStore) InitialSync(ctx context.Context) error {
 	return nil
 }
 
+func (s *BucketStore) isQuarantined(id ulid.ULID) bool {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	_, ok := s.quarantined[id]
+	return ok
+}
+
+// recordCorruption counts requests failing because of corrupted data of the given block, and quarantines
+// the block once the configured threshold is reached.
+func (s *BucketStore) recordCorruption(b *bucketBlock, err error) {
+	var reason metadata.NoServeReason
+	switch errors.Cause(err) {
+	case errCorruptedChunk:
+		reason = metadata.CorruptedChunksNoServeReason
+	case errCorruptedSeries:
+		reason = metadata.CorruptedIndexNoServeReason
+	default:
+		return
+	}
+	s.metrics.blockCorruptions.WithLabelValues(string(reason)).Inc()
+	level.Warn(s.logger).Log("msg", "detected corrupted block data", "block", b.meta.ULID, "reason", reason, "err", err)
+
+	if s.quarantineConfig == nil || s.quarantineConfig.CorruptionThreshold <= 0 {
+		return
+	}
+	if atomic.AddInt64(&b.corruptions, 1) < s.quarantineConfig.CorruptionThreshold {
+		return
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.blocks[b.meta.ULID]; !ok {
+		return
+	}
+	// The block cannot be closed here, given readers of the ongoing requests are still open. It is
+	// unloaded and marked on the next sync.
+	lset := labels.FromMap(b.meta.Thanos.Labels)
+	s.blockSets[lset.Hash()].remove(b.meta.ULID)
+	delete(s.blocks, b.meta.ULID)
+	s.quarantined[b.meta.ULID] = &quarantinedBlock{block: b, reason: reason, details: err.Error()}
+
+	s.metrics.blocksLoaded.Dec()
+	s.metrics.blockQuarantines.Inc()
+	level.Warn(s.logger).Log("msg", "block quarantined because of corrupted data; it is no longer served", "block", b.meta.ULID, "reason", reason)
+}
+
+// syncQuarantined releases the resources of quarantined blocks and uploads their no-serve marks.
+func (s *BucketStore) syncQuarantined(ctx context.Context, metas map[ulid.ULID]*metadata.Meta) {
+	s.mtx.RLock()
+	quarantined := make(map[ulid.ULID]*quarantinedBlock, len(s.quarantined))
+	for id, q := range s.quarantined {
+		quarantined[id] = q
+	}
+	s.mtx.RUnlock()
+
+	for id, q := range quarantined {
+		if !q.unloaded {
+			if err := q.block.Close(); err != nil {
+				level.Warn(s.logger).Log("msg", "close of quarantined block failed", "block", id, "err", err)
+			}
+			if err := os.RemoveAll(q.block.dir); err != nil {
+				level.Warn(s.logger).Log("msg", "failed to remove quarantined block", "block", id, "err", err)
+			}
+			q.unloaded = true
+		}
+
+		if !q.marked && s.quarantineConfig.Bucket != nil {
+			if err := block.MarkForNoServe(ctx, s.logger, s.quarantineConfig.Bucket, id, q.reason, q.details, s.metrics.blocksMarkedNoServe); err != nil {
+				level.Warn(s.logger).Log("msg", "failed to mark quarantined block for no serve; retrying on next sync", "block", id, "err", err)
+				continue
+			}
+			q.marked = true
+		}
+
+		// Once marked, the block is expected to be filtered out by the fetcher, so it can be forgotten.
+		if _, ok := metas[id]; !ok && q.marked {
+			s.mtx.Lock()
+			delete(s.quarantined, id)
+			s.mtx.Unlock()
+		}
+	}
+}
+
 func (s *BucketStore) getBlock(id ulid.ULID) *bucketBlock {
 	s.mtx.RLock()
 	defer s.mtx.RUnlock()
@@ -811,11 +961,52 @@ func populateChunk(out *storepb.AggrChunk, in chunkenc.Chunk, aggrs []storepb.Ag
 				return errors.Errorf("aggregate %s does not exist", downsample.AggrCounter)
 			}
 			out.Counter = &storepb.Chunk{Type: storepb.Chunk_XOR, Data: x.Bytes()}
+		case storepb.Aggr_LAST:
+			x, err := ac.Get(downsample.AggrLast)
+			if err == downsample.ErrAggrNotExist {
+				// Blocks downsampled before the aggregate was introduced don't have it, respond with average instead.
+				if err := populateAverage(out, ac); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return errors.Errorf("aggregate %s does not exist", downsample.AggrLast)
+			}
+			out.Last = &storepb.Chunk{Type: storepb.Chunk_XOR, Data: x.Bytes()}
+		case storepb.Aggr_SKETCH:
+			x, err := ac.Get(downsample.AggrSketch)
+			if err == downsample.ErrAggrNotExist {
+				// Blocks downsampled before the aggregate was introduced don't have it, respond with average instead.
+				if err := populateAverage(out, ac); err != nil {
+					return err
+				}
+				continue
+			}
+			if err != nil {
+				return errors.Errorf("aggregate %s does not exist", downsample.AggrSketch)
+			}
+			out.Sketch = &storepb.Chunk{Type: storepb.Chunk_SKETCH, Data: x.Bytes()}
 		}
 	}
 	return nil
 }
 
+// populateAverage sets the count and sum aggregates the average is computed from.
+func populateAverage(out *storepb.AggrChunk, ac downsample.AggrChunk) error {
+	x, err := ac.Get(downsample.AggrCount)
+	if err != nil {
+		return errors.Errorf("aggregate %s does not exist", downsample.AggrCount)
+	}
+	out.Count = &storepb.Chunk{Type: storepb.Chunk_XOR, Data: x.Bytes()}
+	x, err = ac.Get(downsample.AggrSum)
+	if err != nil {
+		return errors.Errorf("aggregate %s does not exist", downsample.AggrSum)
+	}
+	out.Sum = &storepb.Chunk{Type: storepb.Chunk_XOR, Data: x.Bytes()}
+	return nil
+}
+
 // debugFoundBlockSetOverview logs on debug level what exactly blocks we used for query in terms of
 // labels and resolution. This is important because we allow mixed resolution results, so it is quite crucial
 // to be aware what exactly resolution we see on query.
@@ -922,6 +1113,7 @@ func (s *BucketStore) Series(req *storepb.SeriesRequest, srv storepb.Store_Serie
 					s.samplesLimiter,
 				)
 				if err != nil {
+					s.recordCorruption(b, err)
 					return errors.Wrapf(err, "fetch series for block %s", b.meta.ULID)
 				}
 
@@ -1112,6 +1304,99 @@ func (s *BucketStore) LabelValues(ctx context.Context, req *storepb.LabelValuesR
 	}, nil
 }
 
+// CardinalityStats implements the storepb.StoreServer interface.
+// Without matchers, statistics are estimated from postings offsets kept in the index header of each block, so neither
+// postings nor chunks are fetched from the object storage. With matchers, postings and series matching them are fetched
+// to count their labels, but chunks are not.
+func (s *BucketStore) CardinalityStats(ctx context.Context, req *storepb.CardinalityStatsRequest) (*storepb.CardinalityStatsResponse, error) {
+	matchers, err := translateMatchers(req.Matchers)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	mint := s.limitMinTime(req.MinTime)
+	maxt := s.limitMaxTime(req.MaxTime)
+
+	var (
+		g, gctx  = errgroup.WithContext(ctx)
+		mtx      sync.Mutex
+		lsets    []labels.Labels
+		builders []*cardinalityStatsBuilder
+		sharded  []map[blockRange]*cardinalityStatsBuilder
+	)
+
+	s.mtx.RLock()
+
+	for _, bs := range s.blockSets {
+		blockMatchers, ok := bs.labelMatchers(matchers...)
+		if !ok {
+			continue
+		}
+		builder := newCardinalityStatsBuilder()
+		shards := map[blockRange]*cardinalityStatsBuilder{}
+		lsets = append(lsets, bs.labels)
+		builders = append(builders, builder)
+		sharded = append(sharded, shards)
+
+		// Downsampled blocks contain the same series as raw ones, so prefer them as there are fewer to look at.
+		for _, b := range bs.getFor(mint, maxt, math.MaxInt64) {
+			meta := b.meta
+			indexr := b.indexReader(gctx)
+			g.Go(func() error {
+				defer runutil.CloseWithLogOnErr(s.logger, indexr, "cardinality stats")
+
+				// Do it via index reader to have pending reader registered correctly.
+				r, err := newBucketBlockCardinalityReader(gctx, indexr)
+				if err != nil {
+					return errors.Wrapf(err, "cardinality stats for block %s", indexr.block.meta.ULID)
+				}
+				block := newCardinalityStatsBuilder()
+				if err := block.addIndex(r, blockMatchers); err != nil {
+					return errors.Wrapf(err, "cardinality stats for block %s", indexr.block.meta.ULID)
+				}
+
+				mtx.Lock()
+				defer mtx.Unlock()
+
+				if meta.Thanos.Shard == nil {
+					builder.merge(block)
+					return nil
+				}
+				// Shards of a block hold disjoint series, so their statistics are summed before merging with other blocks.
+				key := blockRange{mint: meta.MinTime, maxt: meta.MaxTime, res: meta.Thanos.Downsample.Resolution}
+				if _, ok := shards[key]; !ok {
+					shards[key] = newCardinalityStatsBuilder()
+				}
+				shards[key].sum(block)
+				return nil
+			})
+		}
+	}
+
+	s.mtx.RUnlock()
+
+	if err := g.Wait(); err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+
+	resp := &storepb.CardinalityStatsResponse{}
+	for i, builder := range builders {
+		for _, shards := range sharded[i] {
+			builder.merge(shards)
+		}
+		if len(builder.seriesCount) == 0 {
+			continue
+		}
+		resp.Stats = append(resp.Stats, builder.build(lsets[i], int(req.Limit)))
+	}
+	resp.Stats = storepb.MergeCardinalityStats(int(req.Limit), resp.Stats...)
+	return resp, nil
+}
+
+// blockRange identifies blocks of the same time range and resolution, like shards of a split block.
+type blockRange struct {
+	mint, maxt, res int64
+}
+
 // bucketBlockSet holds all blocks of an equal label set. It internally splits
 // them up by downsampling resolution and allows querying.
 type bucketBlockSet struct {
@@ -1121,13 +1406,13 @@ type bucketBlockSet struct {
 	blocks      [][]*bucketBlock // Ordered buckets for the existing resolutions.
 }
 
-// newBucketBlockSet initializes a new set with the known downsampling windows hard-configured.
-// The set currently does not support arbitrary ranges.
+// newBucketBlockSet initializes a new set with the raw resolution. Downsampling resolutions are added
+// as blocks of them are added, so arbitrary downsampling levels are supported.
 func newBucketBlockSet(lset labels.Labels) *bucketBlockSet {
 	return &bucketBlockSet{
 		labels:      lset,
-		resolutions: []int64{downsample.ResLevel2, downsample.ResLevel1, downsample.ResLevel0},
-		blocks:      make([][]*bucketBlock, 3),
+		resolutions: []int64{downsample.ResLevel0},
+		blocks:      make([][]*bucketBlock, 1),
 	}
 }
 
@@ -1138,9 +1423,16 @@ func (s *bucketBlockSet) add(b *bucketBlock) error {
 	s.mtx.Lock()
 	defer s.mtx.Unlock()
 
-	i := int64index(s.resolutions, b.meta.Thanos.Downsample.Resolution)
+	res := b.meta.Thanos.Downsample.Resolution
+	if res < 0 {
+		return errors.Errorf("unsupported downsampling resolution %d", res)
+	}
+	i := int64index(s.resolutions, res)
 	if i < 0 {
-		return errors.Errorf("unsupported downsampling resolution %d", b.meta.Thanos.Downsample.Resolution)
+		// Keep resolutions ordered from high to low.
+		i = sort.Search(len(s.resolutions), func(j int) bool { return s.resolutions[j] < res })
+		s.resolutions = append(s.resolutions[:i], append([]int64{res}, s.resolutions[i:]...)...)
+		s.blocks = append(s.blocks[:i], append([][]*bucketBlock{nil}, s.blocks[i:]...)...)
 	}
 	bs := append(s.blocks[i], b)
 	s.blocks[i] = bs
@@ -1263,6 +1555,9 @@ type bucketBlock struct {
 	seriesRefetches prometheus.Counter
 
 	enablePostingsCompression bool
+
+	// Number of requests that failed because of corrupted data. Accessed atomically.
+	corruptions int64
 }
 
 func newBucketBlock(
@@ -1341,6 +1636,16 @@ func (b *bucketBlock) readChunkRange(ctx context.Context, seq int, off, length i
 	return &internalBuf, nil
 }
 
+// truncated returns whether a read of the object ending at the given offset reached the end of the object, in which
+// case an entry cut off by the end of the read is cut off in the object itself.
+func (b *bucketBlock) truncated(ctx context.Context, name string, end uint64) (bool, error) {
+	size, err := b.bkt.ObjectSize(ctx, name)
+	if err != nil {
+		return false, errors.Wrapf(err, "get size of %s", name)
+	}
+	return end >= size, nil
+}
+
 func (b *bucketBlock) indexReader(ctx context.Context) *bucketIndexReader {
 	b.pendingReaders.Add(1)
 	return newBucketIndexReader(ctx, b)
@@ -1825,11 +2130,11 @@ func (r *bucketIndexReader) loadSeries(ctx context.Context, ids []uint64, refetc
 
 		l, n := binary.Uvarint(c)
 		if n < 1 {
-			return errors.New("reading series length failed")
+			return r.shortRead(ctx, start+uint64(len(b)), errors.Errorf("series %d: reading series length failed", id))
 		}
-		if len(c) < n+int(l) {
+		if len(c) < n+int(l)+crc32.Size {
 			if i == 0 && refetch {
-				return errors.Errorf("invalid remaining size, even after refetch, remaining: %d, expected %d", len(c), n+int(l))
+				return r.shortRead(ctx, start+uint64(len(b)), errors.Errorf("series %d: invalid remaining size, even after refetch, remaining: %d, expected %d", id, len(c), n+int(l)+crc32.Size))
 			}
 
 			// Inefficient, but should be rare.
@@ -1837,7 +2142,10 @@ func (r *bucketIndexReader) loadSeries(ctx context.Context, ids []uint64, refetc
 			level.Warn(r.block.logger).Log("msg", "series size exceeded expected size; refetching", "id", id, "series length", n+int(l), "maxSeriesSize", maxSeriesSize)
 
 			// Fetch plus to get the size of next one if exists.
-			return r.loadSeries(ctx, ids[i:], true, id, id+uint64(n+int(l)+1))
+			return r.loadSeries(ctx, ids[i:], true, id, id+uint64(n+int(l)+crc32.Size+1))
+		}
+		if exp, got := binary.BigEndian.Uint32(c[n+int(l):]), crc32.Checksum(c[n:n+int(l)], castagnoliTable); exp != got {
+			return errors.Wrapf(errCorruptedSeries, "series %d: checksum mismatch expected:%x, actual:%x", id, exp, got)
 		}
 		c = c[n : n+int(l)]
 		r.mtx.Lock()
@@ -1848,6 +2156,19 @@ func (r *bucketIndexReader) loadSeries(ctx context.Context, ids []uint64, refetc
 	return nil
 }
 
+// shortRead returns the error of a series entry cut off by the end of a read ending at the given offset. The entry is
+// corrupted if the read reached the end of the index, and the read was short otherwise.
+func (r *bucketIndexReader) shortRead(ctx context.Context, end uint64, err error) error {
+	truncated, terr := r.block.truncated(ctx, r.block.indexFilename(), end)
+	if terr != nil {
+		return errors.Wrapf(err, "check for truncated object: %v", terr)
+	}
+	if truncated {
+		return errors.Wrap(errCorruptedSeries, err.Error())
+	}
+	return err
+}
+
 type part struct {
 	start uint64
 	end   uint64
@@ -2012,19 +2333,36 @@ func (r *bucketChunkReader) loadChunks(ctx context.Context, offs []uint32, seq i
 	for _, o := range offs {
 		cb := (*b)[o-start:]
 
+		cid := uint64(seq<<32) | uint64(o)
 		l, n := binary.Uvarint(cb)
 		if n < 1 {
-			return errors.New("reading chunk length failed")
+			return r.shortRead(ctx, seq, uint64(start)+uint64(len(*b)), errors.Errorf("chunk %d: reading chunk length failed", cid))
 		}
-		if len(cb) < n+int(l)+1 {
-			return errors.Errorf("preloaded chunk too small, expecting %d", n+int(l)+1)
+		if len(cb) < n+int(l)+1+crc32.Size {
+			return r.shortRead(ctx, seq, uint64(start)+uint64(len(*b)), errors.Errorf("chunk %d: preloaded chunk too small, expecting %d", cid, n+int(l)+1+crc32.Size))
+		}
+		// The checksum covers the encoding byte and the chunk data.
+		if exp, got := binary.BigEndian.Uint32(cb[n+int(l)+1:]), crc32.Checksum(cb[n:n+int(l)+1], castagnoliTable); exp != got {
+			return errors.Wrapf(errCorruptedChunk, "chunk %d: checksum mismatch expected:%x, actual:%x", cid, exp, got)
 		}
-		cid := uint64(seq<<32) | uint64(o)
 		r.chunks[cid] = rawChunk(cb[n : n+int(l)+1])
 	}
 	return nil
 }
 
+// shortRead returns the error of a chunk cut off by the end of a read of the chunk file ending at the given offset.
+// The chunk is corrupted if the read reached the end of the file, and the read was short otherwise.
+func (r *bucketChunkReader) shortRead(ctx context.Context, seq int, end uint64, err error) error {
+	truncated, terr := r.block.truncated(ctx, r.block.chunkObjs[seq], end)
+	if terr != nil {
+		return errors.Wrapf(err, "check for truncated object: %v", terr)
+	}
+	if truncated {
+		return errors.Wrap(errCorruptedChunk, err.Error())
+	}
+	return err
+}
+
 func (r *bucketChunkReader) Chunk(id uint64) (chunkenc.Chunk, error) {
 	c, ok := r.chunks[id]
 	if !ok {
@@ -170,6 +170,7 @@ func prepareStoreWithTestBlocks(t testing.TB, dir string, bkt objstore.Bucket, m
 		true,
 		DefaultPostingOffsetInMemorySampling,
 		true,
+		nil,
 	)
 	testutil.Ok(t, err)
 	s.store = store
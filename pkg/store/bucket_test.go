@@ -7,6 +7,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"math"
@@ -16,6 +17,7 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -28,6 +30,7 @@ import (
 	"github.com/leanovate/gopter/gen"
 	"github.com/leanovate/gopter/prop"
 	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	promtest "github.com/prometheus/client_golang/prometheus/testutil"
@@ -296,6 +299,75 @@ func TestBucketBlockSet_addGet(t *testing.T) {
 	}
 }
 
+func TestBucketBlockSet_addGetArbitraryResolutions(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	set := newBucketBlockSet(labels.Labels{})
+
+	const (
+		res1m  = int64(60 * 1000)
+		res10m = int64(10 * 60 * 1000)
+		res2h  = int64(2 * 60 * 60 * 1000)
+	)
+	type resBlock struct {
+		mint, maxt int64
+		window     int64
+	}
+	input := []resBlock{
+		{window: res10m, mint: 0, maxt: 200},
+		{window: downsample.ResLevel0, mint: 0, maxt: 300},
+		{window: res2h, mint: 0, maxt: 100},
+		{window: res1m, mint: 0, maxt: 300},
+	}
+	for _, in := range input {
+		var m metadata.Meta
+		m.Thanos.Downsample.Resolution = in.window
+		m.MinTime = in.mint
+		m.MaxTime = in.maxt
+
+		testutil.Ok(t, set.add(&bucketBlock{meta: &m}))
+	}
+	testutil.Equals(t, []int64{res2h, res10m, res1m, downsample.ResLevel0}, set.resolutions)
+
+	for _, c := range []struct {
+		maxResolution int64
+		res           []resBlock
+	}{
+		{
+			maxResolution: 0,
+			res:           []resBlock{{window: downsample.ResLevel0, mint: 0, maxt: 300}},
+		}, {
+			maxResolution: 5 * 60 * 1000,
+			res:           []resBlock{{window: res1m, mint: 0, maxt: 300}},
+		}, {
+			maxResolution: 30 * 60 * 1000,
+			res: []resBlock{
+				{window: res10m, mint: 0, maxt: 200},
+				{window: res1m, mint: 0, maxt: 300},
+			},
+		}, {
+			maxResolution: res2h,
+			res: []resBlock{
+				{window: res2h, mint: 0, maxt: 100},
+				{window: res10m, mint: 0, maxt: 200},
+				{window: res1m, mint: 0, maxt: 300},
+			},
+		},
+	} {
+		t.Run("", func(t *testing.T) {
+			var exp []*bucketBlock
+			for _, b := range c.res {
+				var m metadata.Meta
+				m.Thanos.Downsample.Resolution = b.window
+				m.MinTime = b.mint
+				m.MaxTime = b.maxt
+				exp = append(exp, &bucketBlock{meta: &m})
+			}
+			testutil.Equals(t, exp, set.getFor(0, 300, c.maxResolution))
+		})
+	}
+}
+
 func TestBucketBlockSet_remove(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
@@ -484,6 +556,7 @@ func TestBucketStore_Info(t *testing.T) {
 		true,
 		DefaultPostingOffsetInMemorySampling,
 		false,
+		nil,
 	)
 	testutil.Ok(t, err)
 
@@ -521,6 +594,24 @@ func (r *recorder) GetRange(ctx context.Context, name string, off, length int64)
 	return r.Bucket.GetRange(ctx, name, off, length)
 }
 
+// shortReadBucket returns at most limit bytes of each range read.
+type shortReadBucket struct {
+	objstore.Bucket
+
+	limit int64
+}
+
+func (b *shortReadBucket) GetRange(ctx context.Context, name string, off, length int64) (io.ReadCloser, error) {
+	rc, err := b.Bucket.GetRange(ctx, name, off, length)
+	if err != nil {
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{Reader: io.LimitReader(rc, b.limit), Closer: rc}, nil
+}
+
 func TestBucketStore_Sharding(t *testing.T) {
 	ctx := context.Background()
 	logger := log.NewNopLogger()
@@ -738,6 +829,7 @@ func testSharding(t *testing.T, reuseDisk string, bkt objstore.Bucket, all ...ul
 				true,
 				DefaultPostingOffsetInMemorySampling,
 				false,
+				nil,
 			)
 			testutil.Ok(t, err)
 
@@ -834,14 +926,16 @@ func TestReadIndexCache_LoadSeries(t *testing.T) {
 	}
 
 	buf := encoding.Encbuf{}
+	putSeries := func(s string) {
+		buf.PutUvarint(len(s))
+		buf.PutString(s)
+		buf.PutBE32(crc32.Checksum([]byte(s), castagnoliTable))
+	}
 	buf.PutByte(0)
 	buf.PutByte(0)
-	buf.PutUvarint(10)
-	buf.PutString("aaaaaaaaaa")
-	buf.PutUvarint(10)
-	buf.PutString("bbbbbbbbbb")
-	buf.PutUvarint(10)
-	buf.PutString("cccccccccc")
+	putSeries("aaaaaaaaaa")
+	putSeries("bbbbbbbbbb")
+	putSeries("cccccccccc")
 	testutil.Ok(t, bkt.Upload(context.Background(), filepath.Join(b.meta.ULID.String(), block.IndexFilename), bytes.NewReader(buf.Get())))
 
 	r := bucketIndexReader{
@@ -851,21 +945,21 @@ func TestReadIndexCache_LoadSeries(t *testing.T) {
 	}
 
 	// Success with no refetches.
-	testutil.Ok(t, r.loadSeries(context.TODO(), []uint64{2, 13, 24}, false, 2, 100))
+	testutil.Ok(t, r.loadSeries(context.TODO(), []uint64{2, 17, 32}, false, 2, 100))
 	testutil.Equals(t, map[uint64][]byte{
 		2:  []byte("aaaaaaaaaa"),
-		13: []byte("bbbbbbbbbb"),
-		24: []byte("cccccccccc"),
+		17: []byte("bbbbbbbbbb"),
+		32: []byte("cccccccccc"),
 	}, r.loadedSeries)
 	testutil.Equals(t, float64(0), promtest.ToFloat64(s.seriesRefetches))
 
 	// Success with 2 refetches.
 	r.loadedSeries = map[uint64][]byte{}
-	testutil.Ok(t, r.loadSeries(context.TODO(), []uint64{2, 13, 24}, false, 2, 15))
+	testutil.Ok(t, r.loadSeries(context.TODO(), []uint64{2, 17, 32}, false, 2, 18))
 	testutil.Equals(t, map[uint64][]byte{
 		2:  []byte("aaaaaaaaaa"),
-		13: []byte("bbbbbbbbbb"),
-		24: []byte("cccccccccc"),
+		17: []byte("bbbbbbbbbb"),
+		32: []byte("cccccccccc"),
 	}, r.loadedSeries)
 	testutil.Equals(t, float64(2), promtest.ToFloat64(s.seriesRefetches))
 
@@ -884,8 +978,36 @@ func TestReadIndexCache_LoadSeries(t *testing.T) {
 	buf.PutString("aaaaaaa")
 	testutil.Ok(t, bkt.Upload(context.Background(), filepath.Join(b.meta.ULID.String(), block.IndexFilename), bytes.NewReader(buf.Get())))
 
-	// Fail, but no recursion at least.
-	testutil.NotOk(t, r.loadSeries(context.TODO(), []uint64{2, 13, 24}, false, 1, 15))
+	// Fail, but no recursion at least. The series is cut off by the end of the index, so it is corrupted.
+	err := r.loadSeries(context.TODO(), []uint64{2, 13, 24}, false, 1, 15)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, errCorruptedSeries, errors.Cause(err))
+
+	buf.Reset()
+	buf.PutByte(0)
+	buf.PutByte(0)
+	putSeries("aaaaaaaaaa")
+	putSeries("bbbbbbbbbb")
+	testutil.Ok(t, bkt.Upload(context.Background(), filepath.Join(b.meta.ULID.String(), block.IndexFilename), bytes.NewReader(buf.Get())))
+
+	// Short reads before the end of the index are not reported as corruption.
+	b.bkt = &shortReadBucket{Bucket: bkt, limit: 10}
+	err = r.loadSeries(context.TODO(), []uint64{2, 17}, false, 2, 100)
+	testutil.NotOk(t, err)
+	testutil.Assert(t, errors.Cause(err) != errCorruptedSeries, "short read reported as corruption: %v", err)
+	b.bkt = bkt
+
+	buf.Reset()
+	buf.PutByte(0)
+	buf.PutByte(0)
+	putSeries("aaaaaaaaaa")
+	buf.B[5] = 'x'
+	testutil.Ok(t, bkt.Upload(context.Background(), filepath.Join(b.meta.ULID.String(), block.IndexFilename), bytes.NewReader(buf.Get())))
+
+	// Fail on checksum mismatch.
+	err = r.loadSeries(context.TODO(), []uint64{2}, false, 2, 100)
+	testutil.NotOk(t, err)
+	testutil.Equals(t, errCorruptedSeries, errors.Cause(err))
 }
 
 func TestBucketIndexReader_ExpandedPostings(t *testing.T) {
@@ -1690,6 +1812,7 @@ func TestSeries_HintsEnabled(t *testing.T) {
 		true,
 		DefaultPostingOffsetInMemorySampling,
 		true,
+		nil,
 	)
 	testutil.Ok(tb, err)
 	testutil.Ok(tb, store.SyncBlocks(context.Background()))
@@ -1735,3 +1858,385 @@ func TestSeries_HintsEnabled(t *testing.T) {
 
 	benchmarkSeries(tb, store, testCases)
 }
+
+func TestBucketStore_QuarantineCorruptedBlock(t *testing.T) {
+	t.Run("checksum mismatch", func(t *testing.T) {
+		// Flip the last byte of the chunks file, which is part of the checksum of the last chunk.
+		testBucketStoreQuarantineCorruptedBlock(t, func(b []byte) []byte {
+			b[len(b)-1] ^= 0xff
+			return b
+		})
+	})
+	t.Run("truncated chunks file", func(t *testing.T) {
+		// Cut off the checksum of the last chunk.
+		testBucketStoreQuarantineCorruptedBlock(t, func(b []byte) []byte {
+			return b[:len(b)-2]
+		})
+	})
+}
+
+func testBucketStoreQuarantineCorruptedBlock(t *testing.T, corrupt func([]byte) []byte) {
+	tb := testutil.NewTB(t)
+
+	tmpDir, err := ioutil.TempDir("", "test-quarantine-corrupted-block")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(tmpDir)) }()
+
+	bktDir := filepath.Join(tmpDir, "bkt")
+	bkt, err := filesystem.NewBucket(bktDir)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, bkt.Close()) }()
+
+	var (
+		ctx      = context.Background()
+		logger   = log.NewNopLogger()
+		instrBkt = objstore.WithNoopInstr(bkt)
+	)
+
+	id, _ := createBlockWithOneSample(tb, bktDir, 0, 2)
+	_, err = metadata.InjectThanos(logger, filepath.Join(bktDir, id.String()), metadata.Thanos{
+		Labels:     labels.Labels{{Name: "ext1", Value: "1"}}.Map(),
+		Downsample: metadata.ThanosDownsample{Resolution: 0},
+		Source:     metadata.TestSource,
+	}, nil)
+	testutil.Ok(t, err)
+
+	chunksFile := filepath.Join(bktDir, id.String(), block.ChunksDirname, "000001")
+	b, err := ioutil.ReadFile(chunksFile)
+	testutil.Ok(t, err)
+	testutil.Ok(t, ioutil.WriteFile(chunksFile, corrupt(b), os.ModePerm))
+
+	fetcher, err := block.NewMetaFetcher(logger, 10, instrBkt, tmpDir, nil, []block.MetadataFilter{
+		block.NewNoServeMarkFilter(logger, instrBkt, true),
+	}, nil)
+	testutil.Ok(t, err)
+
+	reg := prometheus.NewRegistry()
+	store, err := NewBucketStore(
+		logger,
+		reg,
+		instrBkt,
+		fetcher,
+		filepath.Join(tmpDir, "store"),
+		noopCache{},
+		1000000,
+		10000,
+		10,
+		false,
+		10,
+		allowAllFilterConf,
+		false,
+		true,
+		false,
+		DefaultPostingOffsetInMemorySampling,
+		false,
+		&QuarantineConfig{CorruptionThreshold: 2, Bucket: bkt},
+	)
+	testutil.Ok(t, err)
+	testutil.Ok(t, store.SyncBlocks(ctx))
+	testutil.Equals(t, 1, len(store.blocks))
+
+	req := &storepb.SeriesRequest{
+		MinTime:  0,
+		MaxTime:  2,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "foo", Value: "bar"}},
+	}
+
+	// The block is served until the threshold is reached.
+	for i := 0; i < 2; i++ {
+		testutil.Equals(t, 1, len(store.blocks))
+		err := store.Series(req, newStoreSeriesServer(ctx))
+		testutil.NotOk(t, err)
+		testutil.Assert(t, strings.Contains(err.Error(), errCorruptedChunk.Error()), "unexpected error: %v", err)
+	}
+	testutil.Equals(t, 0, len(store.blocks))
+	testutil.Equals(t, 2.0, promtest.ToFloat64(store.metrics.blockCorruptions.WithLabelValues(string(metadata.CorruptedChunksNoServeReason))))
+	testutil.Equals(t, 1.0, promtest.ToFloat64(store.metrics.blockQuarantines))
+
+	// Quarantined block is no longer queried.
+	srv := newStoreSeriesServer(ctx)
+	testutil.Ok(t, store.Series(req, srv))
+	testutil.Equals(t, 0, len(srv.SeriesSet))
+
+	// The next sync uploads the no-serve mark, and the block is filtered out from then on.
+	testutil.Ok(t, store.SyncBlocks(ctx))
+	mark, err := metadata.ReadNoServeMark(ctx, instrBkt, logger, id.String())
+	testutil.Ok(t, err)
+	testutil.Equals(t, metadata.CorruptedChunksNoServeReason, mark.Reason)
+	testutil.Equals(t, 1.0, promtest.ToFloat64(store.metrics.blocksMarkedNoServe))
+
+	testutil.Ok(t, store.SyncBlocks(ctx))
+	testutil.Equals(t, 0, len(store.blocks))
+	testutil.Equals(t, 0, len(store.quarantined))
+	testutil.Ok(t, store.Close())
+}
+
+func TestBucketStore_CardinalityStats(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-bucket-store-cardinality-stats")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(tmpDir)) }()
+
+	bktDir := filepath.Join(tmpDir, "bkt")
+	bkt, err := filesystem.NewBucket(bktDir)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, bkt.Close()) }()
+
+	var (
+		ctx    = context.Background()
+		logger = log.NewNopLogger()
+		extA   = labels.Labels{{Name: "ext", Value: "a"}}
+		extB   = labels.Labels{{Name: "ext", Value: "b"}}
+	)
+
+	_, err = e2eutil.CreateBlock(ctx, bktDir, []labels.Labels{
+		labels.FromStrings("__name__", "up", "job", "a", "instance", "1"),
+		labels.FromStrings("__name__", "up", "job", "a", "instance", "2"),
+		labels.FromStrings("__name__", "up", "job", "a", "instance", "3"),
+		labels.FromStrings("__name__", "up", "job", "b", "instance", "4"),
+		labels.FromStrings("__name__", "go_goroutines", "job", "a", "instance", "1"),
+	}, 10, 0, 1000, extA, 0)
+	testutil.Ok(t, err)
+	_, err = e2eutil.CreateBlock(ctx, bktDir, []labels.Labels{
+		labels.FromStrings("__name__", "up", "job", "a", "instance", "1"),
+		labels.FromStrings("__name__", "build_info", "job", "a", "instance", "5"),
+	}, 10, 1000, 2000, extA, 0)
+	testutil.Ok(t, err)
+	_, err = e2eutil.CreateBlock(ctx, bktDir, []labels.Labels{
+		labels.FromStrings("__name__", "up", "job", "c", "instance", "1"),
+	}, 10, 0, 1000, extB, 0)
+	testutil.Ok(t, err)
+
+	instrBkt := objstore.WithNoopInstr(bkt)
+	fetcher, err := block.NewMetaFetcher(logger, 10, instrBkt, tmpDir, nil, nil, nil)
+	testutil.Ok(t, err)
+
+	store, err := NewBucketStore(
+		logger,
+		nil,
+		instrBkt,
+		fetcher,
+		filepath.Join(tmpDir, "store"),
+		noopCache{},
+		1000000,
+		10000,
+		10,
+		false,
+		10,
+		allowAllFilterConf,
+		false,
+		true,
+		false,
+		DefaultPostingOffsetInMemorySampling,
+		false,
+		nil,
+	)
+	testutil.Ok(t, err)
+	testutil.Ok(t, store.SyncBlocks(ctx))
+
+	t.Run("all blocks", func(t *testing.T) {
+		resp, err := store.CardinalityStats(ctx, &storepb.CardinalityStatsRequest{MinTime: 0, MaxTime: 2000})
+		testutil.Ok(t, err)
+		testutil.Equals(t, []storepb.CardinalityStats{
+			{
+				Labels: []storepb.Label{{Name: "ext", Value: "a"}},
+				// Counts of subsequent blocks are not summed up, as those mostly contain the same series.
+				SeriesCountByMetricName: []storepb.CardinalityStatsEntry{
+					{Name: "up", Count: 4},
+					{Name: "build_info", Count: 1},
+					{Name: "go_goroutines", Count: 1},
+				},
+				LabelValueCountByLabelName: []storepb.CardinalityStatsEntry{
+					{Name: "instance", Count: 5},
+					{Name: "__name__", Count: 3},
+					{Name: "job", Count: 2},
+				},
+				SeriesCountByLabelValuePair: []storepb.CardinalityStatsEntry{
+					{Name: "__name__", Value: "up", Count: 4},
+					{Name: "job", Value: "a", Count: 4},
+					{Name: "instance", Value: "1", Count: 2},
+					{Name: "__name__", Value: "build_info", Count: 1},
+					{Name: "__name__", Value: "go_goroutines", Count: 1},
+					{Name: "instance", Value: "2", Count: 1},
+					{Name: "instance", Value: "3", Count: 1},
+					{Name: "instance", Value: "4", Count: 1},
+					{Name: "instance", Value: "5", Count: 1},
+					{Name: "job", Value: "b", Count: 1},
+				},
+			},
+			{
+				Labels:                      []storepb.Label{{Name: "ext", Value: "b"}},
+				SeriesCountByMetricName:     []storepb.CardinalityStatsEntry{{Name: "up", Count: 1}},
+				LabelValueCountByLabelName:  []storepb.CardinalityStatsEntry{{Name: "__name__", Count: 1}, {Name: "instance", Count: 1}, {Name: "job", Count: 1}},
+				SeriesCountByLabelValuePair: []storepb.CardinalityStatsEntry{{Name: "__name__", Value: "up", Count: 1}, {Name: "instance", Value: "1", Count: 1}, {Name: "job", Value: "c", Count: 1}},
+			},
+		}, resp.Stats)
+	})
+	t.Run("matchers and limit", func(t *testing.T) {
+		resp, err := store.CardinalityStats(ctx, &storepb.CardinalityStatsRequest{
+			MinTime: 0,
+			MaxTime: 999,
+			Matchers: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_EQ, Name: "ext", Value: "a"},
+				{Type: storepb.LabelMatcher_RE, Name: "job", Value: "b|c"},
+			},
+			Limit: 1,
+		})
+		testutil.Ok(t, err)
+		testutil.Equals(t, []storepb.CardinalityStats{
+			{
+				Labels:                      []storepb.Label{{Name: "ext", Value: "a"}},
+				SeriesCountByMetricName:     []storepb.CardinalityStatsEntry{{Name: "up", Count: 1}},
+				LabelValueCountByLabelName:  []storepb.CardinalityStatsEntry{{Name: "__name__", Count: 1}},
+				SeriesCountByLabelValuePair: []storepb.CardinalityStatsEntry{{Name: "__name__", Value: "up", Count: 1}},
+			},
+		}, resp.Stats)
+	})
+	t.Run("matchers of different labels", func(t *testing.T) {
+		resp, err := store.CardinalityStats(ctx, &storepb.CardinalityStatsRequest{
+			MinTime: 0,
+			MaxTime: 2000,
+			Matchers: []storepb.LabelMatcher{
+				{Type: storepb.LabelMatcher_EQ, Name: "job", Value: "a"},
+				{Type: storepb.LabelMatcher_EQ, Name: "instance", Value: "1"},
+			},
+		})
+		testutil.Ok(t, err)
+		testutil.Equals(t, []storepb.CardinalityStats{
+			{
+				Labels: []storepb.Label{{Name: "ext", Value: "a"}},
+				SeriesCountByMetricName: []storepb.CardinalityStatsEntry{
+					{Name: "go_goroutines", Count: 1},
+					{Name: "up", Count: 1},
+				},
+				LabelValueCountByLabelName: []storepb.CardinalityStatsEntry{
+					{Name: "__name__", Count: 2},
+					{Name: "instance", Count: 1},
+					{Name: "job", Count: 1},
+				},
+				SeriesCountByLabelValuePair: []storepb.CardinalityStatsEntry{
+					{Name: "instance", Value: "1", Count: 2},
+					{Name: "job", Value: "a", Count: 2},
+					{Name: "__name__", Value: "go_goroutines", Count: 1},
+					{Name: "__name__", Value: "up", Count: 1},
+				},
+			},
+		}, resp.Stats)
+	})
+	t.Run("matcher without matching label value", func(t *testing.T) {
+		resp, err := store.CardinalityStats(ctx, &storepb.CardinalityStatsRequest{
+			MinTime:  0,
+			MaxTime:  2000,
+			Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "job", Value: "c"}},
+		})
+		testutil.Ok(t, err)
+		testutil.Equals(t, 1, len(resp.Stats))
+		testutil.Equals(t, []storepb.Label{{Name: "ext", Value: "b"}}, resp.Stats[0].Labels)
+	})
+}
+
+func TestBucketStore_CardinalityStats_Shards(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "test-bucket-store-cardinality-stats-shards")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(tmpDir)) }()
+
+	bktDir := filepath.Join(tmpDir, "bkt")
+	bkt, err := filesystem.NewBucket(bktDir)
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, bkt.Close()) }()
+
+	var (
+		ctx    = context.Background()
+		logger = log.NewNopLogger()
+		extA   = labels.Labels{{Name: "ext", Value: "a"}}
+	)
+
+	// Two shards of a split block, and the subsequent block holding some of its series, which is not split.
+	for i, series := range [][]labels.Labels{
+		{labels.FromStrings("__name__", "up", "instance", "1")},
+		{labels.FromStrings("__name__", "up", "instance", "2"), labels.FromStrings("__name__", "up", "instance", "3")},
+	} {
+		id, err := e2eutil.CreateBlock(ctx, bktDir, series, 10, 0, 1000, extA, 0)
+		testutil.Ok(t, err)
+		meta, err := metadata.Read(filepath.Join(bktDir, id.String()))
+		testutil.Ok(t, err)
+		meta.Thanos.Shard = &metadata.ThanosShard{Index: uint64(i), Count: 2}
+		testutil.Ok(t, metadata.Write(logger, filepath.Join(bktDir, id.String()), meta))
+	}
+	_, err = e2eutil.CreateBlock(ctx, bktDir, []labels.Labels{
+		labels.FromStrings("__name__", "up", "instance", "1"),
+		labels.FromStrings("__name__", "up", "instance", "2"),
+	}, 10, 1000, 2000, extA, 0)
+	testutil.Ok(t, err)
+
+	instrBkt := objstore.WithNoopInstr(bkt)
+	fetcher, err := block.NewMetaFetcher(logger, 10, instrBkt, tmpDir, nil, nil, nil)
+	testutil.Ok(t, err)
+
+	store, err := NewBucketStore(
+		logger,
+		nil,
+		instrBkt,
+		fetcher,
+		filepath.Join(tmpDir, "store"),
+		noopCache{},
+		1000000,
+		10000,
+		10,
+		false,
+		10,
+		allowAllFilterConf,
+		false,
+		true,
+		false,
+		DefaultPostingOffsetInMemorySampling,
+		false,
+		nil,
+	)
+	testutil.Ok(t, err)
+	testutil.Ok(t, store.SyncBlocks(ctx))
+
+	resp, err := store.CardinalityStats(ctx, &storepb.CardinalityStatsRequest{MinTime: 0, MaxTime: 2000, Limit: 1})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []storepb.CardinalityStats{
+		{
+			Labels: []storepb.Label{{Name: "ext", Value: "a"}},
+			// Counts of shards are summed up, as those hold disjoint series.
+			SeriesCountByMetricName:     []storepb.CardinalityStatsEntry{{Name: "up", Count: 3}},
+			LabelValueCountByLabelName:  []storepb.CardinalityStatsEntry{{Name: "instance", Count: 3}},
+			SeriesCountByLabelValuePair: []storepb.CardinalityStatsEntry{{Name: "__name__", Value: "up", Count: 3}},
+		},
+	}, resp.Stats)
+}
+
+func TestPopulateChunk_LastAndSketch(t *testing.T) {
+	xor := chunkenc.NewXORChunk()
+	app, err := xor.Appender()
+	testutil.Ok(t, err)
+	app.Append(299, 1)
+
+	var s downsample.Sketch
+	s.Add(1)
+	sketch := downsample.NewSketchChunk()
+	sketch.AppendSketch(299, &s)
+
+	withAggrs := downsample.EncodeAggrChunk([downsample.NumAggrTypes]chunkenc.Chunk{
+		downsample.AggrCount: xor, downsample.AggrSum: xor, downsample.AggrLast: xor, downsample.AggrSketch: sketch,
+	})
+	var out storepb.AggrChunk
+	testutil.Ok(t, populateChunk(&out, withAggrs, []storepb.Aggr{storepb.Aggr_LAST, storepb.Aggr_SKETCH}))
+	testutil.Equals(t, storepb.AggrChunk{
+		Last:   &storepb.Chunk{Type: storepb.Chunk_XOR, Data: xor.Bytes()},
+		Sketch: &storepb.Chunk{Type: storepb.Chunk_SKETCH, Data: sketch.Bytes()},
+	}, out)
+
+	// Chunks without the aggregates are served as average.
+	withoutAggrs := downsample.EncodeAggrChunk([downsample.NumAggrTypes]chunkenc.Chunk{
+		downsample.AggrCount: xor, downsample.AggrSum: xor,
+	})
+	out = storepb.AggrChunk{}
+	testutil.Ok(t, populateChunk(&out, withoutAggrs, []storepb.Aggr{storepb.Aggr_SKETCH}))
+	testutil.Equals(t, storepb.AggrChunk{
+		Count: &storepb.Chunk{Type: storepb.Chunk_XOR, Data: xor.Bytes()},
+		Sum:   &storepb.Chunk{Type: storepb.Chunk_XOR, Data: xor.Bytes()},
+	}, out)
+}
@@ -0,0 +1,542 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	lru "github.com/hashicorp/golang-lru/simplelru"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/model"
+	"github.com/thanos-io/thanos/pkg/runutil"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// diskEntryHeaderSize is the size of the header prepended to each entry stored on disk.
+	// It holds the CRC32 (Castagnoli) checksum of the entry value.
+	diskEntryHeaderSize = 4
+
+	diskTmpSuffix = ".tmp"
+)
+
+var (
+	DefaultDiskIndexCacheConfig = DiskIndexCacheConfig{
+		MaxSize:     10 * 1024 * 1024 * 1024,
+		MaxItemSize: 125 * 1024 * 1024,
+	}
+
+	castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+// DiskIndexCacheConfig holds the disk index cache config.
+type DiskIndexCacheConfig struct {
+	// Directory is the local directory where cache entries are persisted.
+	Directory string `yaml:"directory"`
+	// MaxSize represents overall maximum number of bytes cache can contain on disk.
+	MaxSize model.Bytes `yaml:"max_size"`
+	// MaxItemSize represents maximum size of single item.
+	MaxItemSize model.Bytes `yaml:"max_item_size"`
+}
+
+// parseDiskIndexCacheConfig unmarshals a buffer into a DiskIndexCacheConfig with default values.
+func parseDiskIndexCacheConfig(conf []byte) (DiskIndexCacheConfig, error) {
+	config := DefaultDiskIndexCacheConfig
+	if err := yaml.Unmarshal(conf, &config); err != nil {
+		return DiskIndexCacheConfig{}, err
+	}
+
+	return config, nil
+}
+
+// DiskIndexCache is a size-bounded LRU index cache persisted on local disk, which survives restarts.
+// Each entry is stored in its own file, prefixed by a checksum verified on every read.
+type DiskIndexCache struct {
+	mtx sync.Mutex
+
+	logger           log.Logger
+	dir              string
+	lru              *lru.LRU
+	maxSizeBytes     uint64
+	maxItemSizeBytes uint64
+
+	curSize uint64
+
+	evicted   *prometheus.CounterVec
+	requests  *prometheus.CounterVec
+	hits      *prometheus.CounterVec
+	added     *prometheus.CounterVec
+	corrupted *prometheus.CounterVec
+	current   *prometheus.GaugeVec
+	curBytes  *prometheus.GaugeVec
+	overflow  *prometheus.CounterVec
+}
+
+// NewDiskIndexCache creates a new disk-backed LRU cache for index entries from a YAML configuration.
+func NewDiskIndexCache(logger log.Logger, reg prometheus.Registerer, conf []byte) (*DiskIndexCache, error) {
+	config, err := parseDiskIndexCacheConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDiskIndexCacheWithConfig(logger, reg, config)
+}
+
+// NewDiskIndexCacheWithConfig creates a new disk-backed LRU cache for index entries and ensures the total
+// size of the entries on disk approximately does not exceed maxBytes. Entries already present in the
+// directory are loaded on startup, so that the cache content survives restarts.
+func NewDiskIndexCacheWithConfig(logger log.Logger, reg prometheus.Registerer, config DiskIndexCacheConfig) (*DiskIndexCache, error) {
+	if config.Directory == "" {
+		return nil, errors.New("no directory specified for disk index cache")
+	}
+	if config.MaxItemSize > config.MaxSize {
+		return nil, errors.Errorf("max item size (%v) cannot be bigger than overall cache size (%v)", config.MaxItemSize, config.MaxSize)
+	}
+	if err := os.MkdirAll(config.Directory, os.ModePerm); err != nil {
+		return nil, errors.Wrap(err, "create disk index cache directory")
+	}
+
+	c := &DiskIndexCache{
+		logger:           logger,
+		dir:              config.Directory,
+		maxSizeBytes:     uint64(config.MaxSize),
+		maxItemSizeBytes: uint64(config.MaxItemSize),
+	}
+
+	c.evicted = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_items_evicted_total",
+		Help: "Total number of items that were evicted from the index cache.",
+	}, []string{"item_type"})
+	c.evicted.WithLabelValues(cacheTypePostings)
+	c.evicted.WithLabelValues(cacheTypeSeries)
+
+	c.added = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_items_added_total",
+		Help: "Total number of items that were added to the index cache.",
+	}, []string{"item_type"})
+	c.added.WithLabelValues(cacheTypePostings)
+	c.added.WithLabelValues(cacheTypeSeries)
+
+	c.requests = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_requests_total",
+		Help: "Total number of requests to the cache.",
+	}, []string{"item_type"})
+	c.requests.WithLabelValues(cacheTypePostings)
+	c.requests.WithLabelValues(cacheTypeSeries)
+
+	c.overflow = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_items_overflowed_total",
+		Help: "Total number of items that could not be added to the cache due to being too big.",
+	}, []string{"item_type"})
+	c.overflow.WithLabelValues(cacheTypePostings)
+	c.overflow.WithLabelValues(cacheTypeSeries)
+
+	c.hits = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_hits_total",
+		Help: "Total number of requests to the cache that were a hit.",
+	}, []string{"item_type"})
+	c.hits.WithLabelValues(cacheTypePostings)
+	c.hits.WithLabelValues(cacheTypeSeries)
+
+	c.corrupted = promauto.With(reg).NewCounterVec(prometheus.CounterOpts{
+		Name: "thanos_store_index_cache_items_corrupted_total",
+		Help: "Total number of items read from the index cache that failed the checksum verification and were dropped.",
+	}, []string{"item_type"})
+	c.corrupted.WithLabelValues(cacheTypePostings)
+	c.corrupted.WithLabelValues(cacheTypeSeries)
+
+	c.current = promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thanos_store_index_cache_items",
+		Help: "Current number of items in the index cache.",
+	}, []string{"item_type"})
+	c.current.WithLabelValues(cacheTypePostings)
+	c.current.WithLabelValues(cacheTypeSeries)
+
+	c.curBytes = promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+		Name: "thanos_store_index_cache_items_size_bytes",
+		Help: "Current byte size of items in the index cache.",
+	}, []string{"item_type"})
+	c.curBytes.WithLabelValues(cacheTypePostings)
+	c.curBytes.WithLabelValues(cacheTypeSeries)
+
+	_ = promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "thanos_store_index_cache_max_size_bytes",
+		Help: "Maximum number of bytes to be held in the index cache.",
+	}, func() float64 {
+		return float64(c.maxSizeBytes)
+	})
+	_ = promauto.With(reg).NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "thanos_store_index_cache_max_item_size_bytes",
+		Help: "Maximum number of bytes for single entry to be held in the index cache.",
+	}, func() float64 {
+		return float64(c.maxItemSizeBytes)
+	})
+
+	// Initialize LRU cache with a high size limit since we will manage evictions ourselves
+	// based on stored size using `RemoveOldest` method.
+	l, err := lru.NewLRU(maxInt, c.onEvict)
+	if err != nil {
+		return nil, err
+	}
+	c.lru = l
+
+	if err := c.load(); err != nil {
+		return nil, errors.Wrap(err, "load disk index cache entries")
+	}
+
+	level.Info(logger).Log(
+		"msg", "created disk index cache",
+		"dir", c.dir,
+		"maxItemSizeBytes", c.maxItemSizeBytes,
+		"maxSizeBytes", c.maxSizeBytes,
+		"loadedItems", c.lru.Len(),
+		"loadedSizeBytes", c.curSize,
+	)
+	return c, nil
+}
+
+// diskEntry is the in-memory bookkeeping of a single entry stored on disk.
+type diskEntry struct {
+	typ  string
+	size uint64
+}
+
+type loadedDiskEntry struct {
+	key     string
+	entry   diskEntry
+	modTime time.Time
+}
+
+// load scans the cache directory and registers all the entries found in the LRU, oldest first,
+// so that entries persisted before a restart are reused. Leftover temporary files are removed.
+func (c *DiskIndexCache) load() error {
+	var entries []loadedDiskEntry
+
+	if err := filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		if strings.HasSuffix(path, diskTmpSuffix) {
+			return os.Remove(path)
+		}
+
+		key, err := filepath.Rel(c.dir, path)
+		if err != nil {
+			return err
+		}
+		typ, ok := diskKeyType(key)
+		if !ok {
+			level.Warn(c.logger).Log("msg", "removing unknown file from disk index cache directory", "path", path)
+			return os.Remove(path)
+		}
+
+		entries = append(entries, loadedDiskEntry{
+			key:     key,
+			entry:   diskEntry{typ: typ, size: uint64(info.Size())},
+			modTime: info.ModTime(),
+		})
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].modTime.Before(entries[j].modTime)
+	})
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	for _, e := range entries {
+		c.lru.Add(e.key, e.entry)
+		c.current.WithLabelValues(e.entry.typ).Inc()
+		c.curBytes.WithLabelValues(e.entry.typ).Add(float64(e.entry.size))
+		c.curSize += e.entry.size
+	}
+
+	// The max size may have been lowered since the last run.
+	for c.curSize > c.maxSizeBytes {
+		if _, _, ok := c.lru.RemoveOldest(); !ok {
+			break
+		}
+	}
+	return nil
+}
+
+func (c *DiskIndexCache) onEvict(key, val interface{}) {
+	e := val.(diskEntry)
+
+	c.evicted.WithLabelValues(e.typ).Inc()
+	c.current.WithLabelValues(e.typ).Dec()
+	c.curBytes.WithLabelValues(e.typ).Sub(float64(e.size))
+	c.curSize -= e.size
+
+	if err := os.Remove(filepath.Join(c.dir, key.(string))); err != nil && !os.IsNotExist(err) {
+		level.Warn(c.logger).Log("msg", "failed to remove evicted disk index cache entry", "key", key, "err", err)
+	}
+}
+
+// diskKey returns the path of the entry relative to the cache directory. Entries are grouped
+// by block, so that all the entries of a block are stored in the same directory.
+func diskKey(key cacheKey) string {
+	switch k := key.key.(type) {
+	case cacheKeyPostings:
+		// cacheKey.string() hashes the label, so the resulting name is safe to be used as file name.
+		s := key.string()
+		return filepath.Join(key.block.String(), "P-"+s[strings.LastIndex(s, ":")+1:])
+	case cacheKeySeries:
+		return filepath.Join(key.block.String(), "S-"+strconv.FormatUint(uint64(k), 10))
+	}
+	return ""
+}
+
+// diskKeyType returns the item type of an entry given its path relative to the cache directory.
+func diskKeyType(key string) (string, bool) {
+	dir, file := filepath.Split(key)
+	if _, err := ulid.Parse(filepath.Clean(dir)); err != nil {
+		return "", false
+	}
+	switch {
+	case strings.HasPrefix(file, "P-"):
+		return cacheTypePostings, true
+	case strings.HasPrefix(file, "S-"):
+		return cacheTypeSeries, true
+	}
+	return "", false
+}
+
+func (c *DiskIndexCache) get(typ string, key cacheKey) ([]byte, bool) {
+	c.requests.WithLabelValues(typ).Inc()
+
+	k := diskKey(key)
+
+	c.mtx.Lock()
+	_, ok := c.lru.Get(k)
+	c.mtx.Unlock()
+	if !ok {
+		return nil, false
+	}
+
+	// The file is read outside of the lock. If the entry gets evicted in the meantime,
+	// the read fails and it's accounted as a miss.
+	b, err := ioutil.ReadFile(filepath.Join(c.dir, k))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			level.Warn(c.logger).Log("msg", "failed to read disk index cache entry", "key", k, "err", err)
+		}
+		return nil, false
+	}
+
+	v, err := decodeDiskEntry(b)
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "dropping corrupted disk index cache entry", "key", k, "err", err)
+		c.corrupted.WithLabelValues(typ).Inc()
+
+		c.mtx.Lock()
+		c.lru.Remove(k)
+		c.mtx.Unlock()
+		return nil, false
+	}
+
+	c.hits.WithLabelValues(typ).Inc()
+	return v, true
+}
+
+func (c *DiskIndexCache) set(typ string, key cacheKey, val []byte) {
+	var size = diskEntryHeaderSize + uint64(len(val))
+
+	k := diskKey(key)
+
+	c.mtx.Lock()
+	_, ok := c.lru.Get(k)
+	c.mtx.Unlock()
+	if ok {
+		return
+	}
+	if size > c.maxItemSizeBytes {
+		c.overflow.WithLabelValues(typ).Inc()
+		return
+	}
+
+	// The entry is written to a temporary file outside of the lock, so that slow disk writes
+	// don't block concurrent lookups. Only the LRU update and the rename happen under the lock.
+	tmp, err := c.writeTmpEntry(k, val)
+	if err != nil {
+		level.Warn(c.logger).Log("msg", "failed to write disk index cache entry", "key", k, "err", err)
+		return
+	}
+
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+
+	if _, ok := c.lru.Get(k); ok {
+		c.removeTmpEntry(tmp)
+		return
+	}
+
+	if !c.ensureFits(size, typ) {
+		c.overflow.WithLabelValues(typ).Inc()
+		c.removeTmpEntry(tmp)
+		return
+	}
+
+	if err := os.Rename(tmp, filepath.Join(c.dir, k)); err != nil {
+		level.Warn(c.logger).Log("msg", "failed to write disk index cache entry", "key", k, "err", err)
+		c.removeTmpEntry(tmp)
+		return
+	}
+	c.lru.Add(k, diskEntry{typ: typ, size: size})
+
+	c.added.WithLabelValues(typ).Inc()
+	c.curBytes.WithLabelValues(typ).Add(float64(size))
+	c.current.WithLabelValues(typ).Inc()
+	c.curSize += size
+}
+
+// writeTmpEntry writes the checksummed value to a new temporary file next to the file of the
+// given key and returns its path. Concurrent writers of the same key use distinct files.
+func (c *DiskIndexCache) writeTmpEntry(key string, val []byte) (_ string, err error) {
+	path := filepath.Join(c.dir, key)
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return "", err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*"+diskTmpSuffix)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			runutil.CloseWithLogOnErr(c.logger, f, "close disk index cache entry")
+			_ = os.Remove(f.Name())
+		}
+	}()
+
+	var header [diskEntryHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:], crc32.Checksum(val, castagnoliTable))
+	if _, err = f.Write(header[:]); err != nil {
+		return "", err
+	}
+	if _, err = f.Write(val); err != nil {
+		return "", err
+	}
+	if err = f.Close(); err != nil {
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+func (c *DiskIndexCache) removeTmpEntry(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		level.Warn(c.logger).Log("msg", "failed to remove temporary disk index cache entry", "path", path, "err", err)
+	}
+}
+
+// decodeDiskEntry verifies the checksum of an entry read from disk and returns its value.
+func decodeDiskEntry(b []byte) ([]byte, error) {
+	if len(b) < diskEntryHeaderSize {
+		return nil, errors.Errorf("entry too short: %d bytes", len(b))
+	}
+	v := b[diskEntryHeaderSize:]
+	if exp, got := binary.BigEndian.Uint32(b[:diskEntryHeaderSize]), crc32.Checksum(v, castagnoliTable); exp != got {
+		return nil, errors.Errorf("checksum mismatch: expected %x, got %x", exp, got)
+	}
+	return v, nil
+}
+
+// ensureFits tries to make sure that the passed item will fit into the disk cache.
+// Returns true if it will fit.
+func (c *DiskIndexCache) ensureFits(size uint64, typ string) bool {
+	if size > c.maxItemSizeBytes {
+		level.Debug(c.logger).Log(
+			"msg", "item bigger than maxItemSizeBytes. Ignoring..",
+			"maxItemSizeBytes", c.maxItemSizeBytes,
+			"maxSizeBytes", c.maxSizeBytes,
+			"curSize", c.curSize,
+			"itemSize", size,
+			"cacheType", typ,
+		)
+		return false
+	}
+
+	for c.curSize+size > c.maxSizeBytes {
+		if _, _, ok := c.lru.RemoveOldest(); !ok {
+			level.Error(c.logger).Log(
+				"msg", "LRU has nothing more to evict, but we still cannot allocate the item.",
+				"maxItemSizeBytes", c.maxItemSizeBytes,
+				"maxSizeBytes", c.maxSizeBytes,
+				"curSize", c.curSize,
+				"itemSize", size,
+				"cacheType", typ,
+			)
+			return false
+		}
+	}
+	return true
+}
+
+// StorePostings sets the postings identified by the ulid and label to the value v,
+// if the postings already exists in the cache it is not mutated.
+func (c *DiskIndexCache) StorePostings(_ context.Context, blockID ulid.ULID, l labels.Label, v []byte) {
+	c.set(cacheTypePostings, cacheKey{blockID, cacheKeyPostings(l)}, v)
+}
+
+// FetchMultiPostings fetches multiple postings - each identified by a label -
+// and returns a map containing cache hits, along with a list of missing keys.
+func (c *DiskIndexCache) FetchMultiPostings(_ context.Context, blockID ulid.ULID, keys []labels.Label) (hits map[labels.Label][]byte, misses []labels.Label) {
+	hits = map[labels.Label][]byte{}
+
+	for _, key := range keys {
+		if b, ok := c.get(cacheTypePostings, cacheKey{blockID, cacheKeyPostings(key)}); ok {
+			hits[key] = b
+			continue
+		}
+
+		misses = append(misses, key)
+	}
+
+	return hits, misses
+}
+
+// StoreSeries sets the series identified by the ulid and id to the value v,
+// if the series already exists in the cache it is not mutated.
+func (c *DiskIndexCache) StoreSeries(_ context.Context, blockID ulid.ULID, id uint64, v []byte) {
+	c.set(cacheTypeSeries, cacheKey{blockID, cacheKeySeries(id)}, v)
+}
+
+// FetchMultiSeries fetches multiple series - each identified by ID - from the cache
+// and returns a map containing cache hits, along with a list of missing IDs.
+func (c *DiskIndexCache) FetchMultiSeries(_ context.Context, blockID ulid.ULID, ids []uint64) (hits map[uint64][]byte, misses []uint64) {
+	hits = map[uint64][]byte{}
+
+	for _, id := range ids {
+		if b, ok := c.get(cacheTypeSeries, cacheKey{blockID, cacheKeySeries(id)}); ok {
+			hits[id] = b
+			continue
+		}
+
+		misses = append(misses, id)
+	}
+
+	return hits, misses
+}
@@ -0,0 +1,151 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestNewDiskIndexCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk-index-cache")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	// Should return error on invalid YAML config.
+	_, err = NewDiskIndexCache(log.NewNopLogger(), nil, []byte("invalid"))
+	testutil.NotOk(t, err)
+
+	// Should return error if the directory is missing.
+	_, err = NewDiskIndexCache(log.NewNopLogger(), nil, []byte{})
+	testutil.NotOk(t, err)
+
+	// Should instance a disk index cache with specified YAML config with units.
+	cache, err := NewDiskIndexCache(log.NewNopLogger(), nil, []byte(`
+directory: `+dir+`
+max_size: 1MB
+max_item_size: 2KB
+`))
+	testutil.Ok(t, err)
+	testutil.Equals(t, dir, cache.dir)
+	testutil.Equals(t, uint64(1024*1024), cache.maxSizeBytes)
+	testutil.Equals(t, uint64(2*1024), cache.maxItemSizeBytes)
+}
+
+func TestDiskIndexCache_StoreFetchAndReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk-index-cache")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	ctx := context.Background()
+	uid := ulid.MustNew(0, nil)
+	lbl := labels.Label{Name: "foo", Value: "bar"}
+	conf := DiskIndexCacheConfig{Directory: dir, MaxSize: 1024, MaxItemSize: 1024}
+
+	cache, err := NewDiskIndexCacheWithConfig(log.NewNopLogger(), nil, conf)
+	testutil.Ok(t, err)
+
+	cache.StorePostings(ctx, uid, lbl, []byte{1, 2, 3})
+	cache.StoreSeries(ctx, uid, 42, []byte{4, 5, 6})
+
+	postings, misses := cache.FetchMultiPostings(ctx, uid, []labels.Label{lbl, {Name: "foo", Value: "baz"}})
+	testutil.Equals(t, map[labels.Label][]byte{lbl: {1, 2, 3}}, postings)
+	testutil.Equals(t, []labels.Label{{Name: "foo", Value: "baz"}}, misses)
+
+	series, seriesMisses := cache.FetchMultiSeries(ctx, uid, []uint64{42, 43})
+	testutil.Equals(t, map[uint64][]byte{42: {4, 5, 6}}, series)
+	testutil.Equals(t, []uint64{43}, seriesMisses)
+
+	// Entries should survive a restart.
+	reg := prometheus.NewRegistry()
+	cache, err = NewDiskIndexCacheWithConfig(log.NewNopLogger(), reg, conf)
+	testutil.Ok(t, err)
+	testutil.Equals(t, 2, cache.lru.Len())
+	testutil.Equals(t, uint64(2*(diskEntryHeaderSize+3)), cache.curSize)
+	testutil.Equals(t, float64(1), promtest.ToFloat64(cache.current.WithLabelValues(cacheTypePostings)))
+	testutil.Equals(t, float64(1), promtest.ToFloat64(cache.current.WithLabelValues(cacheTypeSeries)))
+
+	postings, misses = cache.FetchMultiPostings(ctx, uid, []labels.Label{lbl})
+	testutil.Equals(t, map[labels.Label][]byte{lbl: {1, 2, 3}}, postings)
+	testutil.Equals(t, 0, len(misses))
+	testutil.Equals(t, float64(1), promtest.ToFloat64(cache.hits.WithLabelValues(cacheTypePostings)))
+}
+
+func TestDiskIndexCache_Corruption(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk-index-cache")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	ctx := context.Background()
+	uid := ulid.MustNew(0, nil)
+
+	cache, err := NewDiskIndexCacheWithConfig(log.NewNopLogger(), nil, DiskIndexCacheConfig{Directory: dir, MaxSize: 1024, MaxItemSize: 1024})
+	testutil.Ok(t, err)
+
+	cache.StoreSeries(ctx, uid, 1, []byte{1, 2, 3})
+
+	// Flip the value on disk, keeping the stored checksum.
+	path := filepath.Join(dir, diskKey(cacheKey{uid, cacheKeySeries(1)}))
+	b, err := ioutil.ReadFile(path)
+	testutil.Ok(t, err)
+	b[len(b)-1] = 42
+	testutil.Ok(t, ioutil.WriteFile(path, b, os.ModePerm))
+
+	hits, misses := cache.FetchMultiSeries(ctx, uid, []uint64{1})
+	testutil.Equals(t, 0, len(hits))
+	testutil.Equals(t, []uint64{1}, misses)
+	testutil.Equals(t, float64(1), promtest.ToFloat64(cache.corrupted.WithLabelValues(cacheTypeSeries)))
+	testutil.Equals(t, 0, cache.lru.Len())
+	testutil.Equals(t, uint64(0), cache.curSize)
+
+	_, err = os.Stat(path)
+	testutil.Assert(t, os.IsNotExist(err), "corrupted entry should be removed from disk")
+}
+
+func TestDiskIndexCache_Eviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk-index-cache")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	ctx := context.Background()
+	uid := ulid.MustNew(0, nil)
+
+	cache, err := NewDiskIndexCacheWithConfig(log.NewNopLogger(), nil, DiskIndexCacheConfig{
+		Directory:   dir,
+		MaxSize:     2 * (diskEntryHeaderSize + 4),
+		MaxItemSize: 2 * (diskEntryHeaderSize + 4),
+	})
+	testutil.Ok(t, err)
+
+	cache.StoreSeries(ctx, uid, 1, []byte{1, 1, 1, 1})
+	cache.StoreSeries(ctx, uid, 2, []byte{2, 2, 2, 2})
+
+	// Make series 1 the most recently used.
+	_, _ = cache.FetchMultiSeries(ctx, uid, []uint64{1})
+
+	cache.StoreSeries(ctx, uid, 3, []byte{3, 3, 3, 3})
+
+	hits, misses := cache.FetchMultiSeries(ctx, uid, []uint64{1, 2, 3})
+	testutil.Equals(t, map[uint64][]byte{1: {1, 1, 1, 1}, 3: {3, 3, 3, 3}}, hits)
+	testutil.Equals(t, []uint64{2}, misses)
+	testutil.Equals(t, float64(1), promtest.ToFloat64(cache.evicted.WithLabelValues(cacheTypeSeries)))
+
+	_, err = os.Stat(filepath.Join(dir, diskKey(cacheKey{uid, cacheKeySeries(2)})))
+	testutil.Assert(t, os.IsNotExist(err), "evicted entry should be removed from disk")
+
+	// Items bigger than the max item size are not stored.
+	cache.StoreSeries(ctx, uid, 4, make([]byte, 2*(diskEntryHeaderSize+4)))
+	testutil.Equals(t, float64(1), promtest.ToFloat64(cache.overflow.WithLabelValues(cacheTypeSeries)))
+	testutil.Equals(t, 2, cache.lru.Len())
+}
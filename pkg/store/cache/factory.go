@@ -5,6 +5,7 @@ package storecache
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 
 	"github.com/go-kit/kit/log"
@@ -12,6 +13,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/thanos-io/thanos/pkg/cacheutil"
+	"github.com/thanos-io/thanos/pkg/extprom"
 	"gopkg.in/yaml.v2"
 )
 
@@ -20,6 +22,9 @@ type IndexCacheProvider string
 const (
 	INMEMORY  IndexCacheProvider = "IN-MEMORY"
 	MEMCACHED IndexCacheProvider = "MEMCACHED"
+	REDIS     IndexCacheProvider = "REDIS"
+	DISK      IndexCacheProvider = "DISK"
+	TIERED    IndexCacheProvider = "TIERED"
 )
 
 // IndexCacheConfig specifies the index cache config.
@@ -36,6 +41,10 @@ func NewIndexCache(logger log.Logger, confContentYaml []byte, reg prometheus.Reg
 		return nil, errors.Wrap(err, "parsing config YAML file")
 	}
 
+	return newIndexCache(logger, cacheConfig, reg)
+}
+
+func newIndexCache(logger log.Logger, cacheConfig *IndexCacheConfig, reg prometheus.Registerer) (IndexCache, error) {
 	backendConfig, err := yaml.Marshal(cacheConfig.Config)
 	if err != nil {
 		return nil, errors.Wrap(err, "marshal content of cache backend configuration")
@@ -51,6 +60,16 @@ func NewIndexCache(logger log.Logger, confContentYaml []byte, reg prometheus.Reg
 		if err == nil {
 			cache, err = NewMemcachedIndexCache(logger, memcached, reg)
 		}
+	case string(REDIS):
+		var redis cacheutil.RedisClient
+		redis, err = cacheutil.NewRedisClient(logger, "index-cache", backendConfig, reg)
+		if err == nil {
+			cache, err = NewRedisIndexCache(logger, redis, reg)
+		}
+	case string(DISK):
+		cache, err = NewDiskIndexCache(logger, reg, backendConfig)
+	case string(TIERED):
+		cache, err = newTieredIndexCache(logger, backendConfig, reg)
 	default:
 		return nil, errors.Errorf("index cache with type %s is not supported", cacheConfig.Type)
 	}
@@ -59,3 +78,31 @@ func NewIndexCache(logger log.Logger, confContentYaml []byte, reg prometheus.Reg
 	}
 	return cache, nil
 }
+
+// newTieredIndexCache builds each configured tier, exposing its metrics with a "tier" label
+// holding the position of the tier, so that hits and misses can be tracked per tier.
+func newTieredIndexCache(logger log.Logger, conf []byte, reg prometheus.Registerer) (*TieredIndexCache, error) {
+	config := TieredIndexCacheConfig{}
+	if err := yaml.UnmarshalStrict(conf, &config); err != nil {
+		return nil, errors.Wrap(err, "parsing tiered index cache config")
+	}
+
+	tiers := make([]IndexCache, 0, len(config.Tiers))
+	for i, tierConfig := range config.Tiers {
+		if strings.ToUpper(string(tierConfig.Type)) == string(TIERED) {
+			return nil, errors.Errorf("tier %d: nested tiered index cache is not supported", i)
+		}
+
+		tierConfig := tierConfig
+		tier, err := newIndexCache(
+			log.With(logger, "tier", i),
+			&tierConfig,
+			extprom.WrapRegistererWith(prometheus.Labels{"tier": strconv.Itoa(i)}, reg),
+		)
+		if err != nil {
+			return nil, errors.Wrapf(err, "tier %d", i)
+		}
+		tiers = append(tiers, tier)
+	}
+	return NewTieredIndexCache(tiers...)
+}
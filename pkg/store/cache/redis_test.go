@@ -0,0 +1,55 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	prom_testutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestRedisIndexCache_FetchMultiPostingsAndSeries(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	block1 := ulid.MustNew(1, nil)
+	block2 := ulid.MustNew(2, nil)
+	label1 := labels.Label{Name: "instance", Value: "a"}
+	label2 := labels.Label{Name: "instance", Value: "b"}
+
+	// The mocked memcached client implements cacheutil.RedisClient too.
+	c, err := NewRedisIndexCache(log.NewNopLogger(), newMockedMemcachedClient(nil), nil)
+	testutil.Ok(t, err)
+
+	c.StorePostings(ctx, block1, label1, []byte{1})
+	c.StorePostings(ctx, block2, label2, []byte{2})
+	c.StoreSeries(ctx, block1, 1, []byte{3})
+
+	hits, misses := c.FetchMultiPostings(ctx, block1, []labels.Label{label1, label2})
+	testutil.Equals(t, map[labels.Label][]byte{label1: {1}}, hits)
+	testutil.Equals(t, []labels.Label{label2}, misses)
+
+	seriesHits, seriesMisses := c.FetchMultiSeries(ctx, block1, []uint64{1, 2})
+	testutil.Equals(t, map[uint64][]byte{1: {3}}, seriesHits)
+	testutil.Equals(t, []uint64{2}, seriesMisses)
+
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(c.requests.WithLabelValues(cacheTypePostings)))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.hits.WithLabelValues(cacheTypePostings)))
+	testutil.Equals(t, 2.0, prom_testutil.ToFloat64(c.requests.WithLabelValues(cacheTypeSeries)))
+	testutil.Equals(t, 1.0, prom_testutil.ToFloat64(c.hits.WithLabelValues(cacheTypeSeries)))
+
+	// Errors are reported as misses.
+	c, err = NewRedisIndexCache(log.NewNopLogger(), newMockedMemcachedClient(errors.New("mocked error")), nil)
+	testutil.Ok(t, err)
+
+	hits, misses = c.FetchMultiPostings(ctx, block1, []labels.Label{label1})
+	testutil.Equals(t, 0, len(hits))
+	testutil.Equals(t, []labels.Label{label1}, misses)
+}
@@ -0,0 +1,100 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// TieredIndexCacheConfig holds the config of a multi-tier index cache.
+type TieredIndexCacheConfig struct {
+	// Tiers is the list of index caches, ordered from the fastest to the slowest one.
+	Tiers []IndexCacheConfig `yaml:"tiers"`
+}
+
+// TieredIndexCache is an index cache made of multiple stacked caches (ie. in-memory in front of disk).
+// Lookups go through the tiers in order and only the keys missed by a tier are looked up in the next
+// one. Hits from a slower tier are backfilled into all the faster tiers, while stored items are written
+// to every tier.
+type TieredIndexCache struct {
+	tiers []IndexCache
+}
+
+// NewTieredIndexCache makes a new TieredIndexCache from the given tiers, ordered from the fastest to the slowest one.
+func NewTieredIndexCache(tiers ...IndexCache) (*TieredIndexCache, error) {
+	if len(tiers) == 0 {
+		return nil, errors.New("at least one tier is required")
+	}
+	return &TieredIndexCache{tiers: tiers}, nil
+}
+
+// StorePostings stores postings for a single series in all the tiers.
+func (c *TieredIndexCache) StorePostings(ctx context.Context, blockID ulid.ULID, l labels.Label, v []byte) {
+	for _, t := range c.tiers {
+		t.StorePostings(ctx, blockID, l, v)
+	}
+}
+
+// FetchMultiPostings fetches multiple postings - each identified by a label -
+// and returns a map containing cache hits, along with a list of missing keys.
+func (c *TieredIndexCache) FetchMultiPostings(ctx context.Context, blockID ulid.ULID, keys []labels.Label) (hits map[labels.Label][]byte, misses []labels.Label) {
+	hits = map[labels.Label][]byte{}
+	misses = keys
+
+	for i, t := range c.tiers {
+		if len(misses) == 0 {
+			break
+		}
+
+		var tierHits map[labels.Label][]byte
+		tierHits, misses = t.FetchMultiPostings(ctx, blockID, misses)
+		for l, v := range tierHits {
+			hits[l] = v
+
+			// Backfill the faster tiers.
+			for _, upper := range c.tiers[:i] {
+				upper.StorePostings(ctx, blockID, l, v)
+			}
+		}
+	}
+
+	return hits, misses
+}
+
+// StoreSeries stores a single series in all the tiers.
+func (c *TieredIndexCache) StoreSeries(ctx context.Context, blockID ulid.ULID, id uint64, v []byte) {
+	for _, t := range c.tiers {
+		t.StoreSeries(ctx, blockID, id, v)
+	}
+}
+
+// FetchMultiSeries fetches multiple series - each identified by ID - from the cache
+// and returns a map containing cache hits, along with a list of missing IDs.
+func (c *TieredIndexCache) FetchMultiSeries(ctx context.Context, blockID ulid.ULID, ids []uint64) (hits map[uint64][]byte, misses []uint64) {
+	hits = map[uint64][]byte{}
+	misses = ids
+
+	for i, t := range c.tiers {
+		if len(misses) == 0 {
+			break
+		}
+
+		var tierHits map[uint64][]byte
+		tierHits, misses = t.FetchMultiSeries(ctx, blockID, misses)
+		for id, v := range tierHits {
+			hits[id] = v
+
+			// Backfill the faster tiers.
+			for _, upper := range c.tiers[:i] {
+				upper.StoreSeries(ctx, blockID, id, v)
+			}
+		}
+	}
+
+	return hits, misses
+}
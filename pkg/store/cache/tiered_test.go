@@ -0,0 +1,98 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package storecache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
+	"github.com/prometheus/client_golang/prometheus"
+	promtest "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/thanos-io/thanos/pkg/testutil"
+)
+
+func TestNewIndexCache_Tiered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tiered-index-cache")
+	testutil.Ok(t, err)
+	defer func() { testutil.Ok(t, os.RemoveAll(dir)) }()
+
+	reg := prometheus.NewRegistry()
+	c, err := NewIndexCache(log.NewNopLogger(), []byte(`
+type: TIERED
+config:
+  tiers:
+  - type: IN-MEMORY
+    config:
+      max_size: 1MB
+      max_item_size: 1KB
+  - type: DISK
+    config:
+      directory: `+dir+`
+      max_size: 1MB
+      max_item_size: 1KB
+`), reg)
+	testutil.Ok(t, err)
+
+	cache := c.(*TieredIndexCache)
+	testutil.Equals(t, 2, len(cache.tiers))
+	mem := cache.tiers[0].(*InMemoryIndexCache)
+	disk := cache.tiers[1].(*DiskIndexCache)
+
+	ctx := context.Background()
+	uid := ulid.MustNew(0, nil)
+	lbl := labels.Label{Name: "foo", Value: "bar"}
+
+	// Stored items end up in all the tiers.
+	cache.StorePostings(ctx, uid, lbl, []byte{1})
+	testutil.Equals(t, 1, mem.lru.Len())
+	testutil.Equals(t, 1, disk.lru.Len())
+
+	// Items only in the slower tier are backfilled.
+	disk.StoreSeries(ctx, uid, 1, []byte{2})
+	hits, misses := cache.FetchMultiSeries(ctx, uid, []uint64{1, 2})
+	testutil.Equals(t, map[uint64][]byte{1: {2}}, hits)
+	testutil.Equals(t, []uint64{2}, misses)
+	testutil.Equals(t, float64(0), promtest.ToFloat64(mem.hits.WithLabelValues(cacheTypeSeries)))
+	testutil.Equals(t, float64(1), promtest.ToFloat64(disk.hits.WithLabelValues(cacheTypeSeries)))
+
+	hits, misses = cache.FetchMultiSeries(ctx, uid, []uint64{1})
+	testutil.Equals(t, map[uint64][]byte{1: {2}}, hits)
+	testutil.Equals(t, 0, len(misses))
+	testutil.Equals(t, float64(1), promtest.ToFloat64(mem.hits.WithLabelValues(cacheTypeSeries)))
+	testutil.Equals(t, float64(1), promtest.ToFloat64(disk.hits.WithLabelValues(cacheTypeSeries)))
+
+	// Metrics are exposed per tier.
+	testutil.Ok(t, promtest.GatherAndCompare(reg, strings.NewReader(`
+# HELP thanos_store_index_cache_hits_total Total number of requests to the cache that were a hit.
+# TYPE thanos_store_index_cache_hits_total counter
+thanos_store_index_cache_hits_total{item_type="Postings",tier="0"} 0
+thanos_store_index_cache_hits_total{item_type="Postings",tier="1"} 0
+thanos_store_index_cache_hits_total{item_type="Series",tier="0"} 1
+thanos_store_index_cache_hits_total{item_type="Series",tier="1"} 1
+# HELP thanos_store_index_cache_requests_total Total number of requests to the cache.
+# TYPE thanos_store_index_cache_requests_total counter
+thanos_store_index_cache_requests_total{item_type="Postings",tier="0"} 0
+thanos_store_index_cache_requests_total{item_type="Postings",tier="1"} 0
+thanos_store_index_cache_requests_total{item_type="Series",tier="0"} 3
+thanos_store_index_cache_requests_total{item_type="Series",tier="1"} 2
+`), "thanos_store_index_cache_hits_total", "thanos_store_index_cache_requests_total"))
+}
+
+func TestNewIndexCache_TieredNotNested(t *testing.T) {
+	_, err := NewIndexCache(log.NewNopLogger(), []byte(`
+type: TIERED
+config:
+  tiers:
+  - type: TIERED
+    config:
+      tiers: []
+`), nil)
+	testutil.NotOk(t, err)
+}
@@ -0,0 +1,289 @@
+// Copyright (c) The Thanos Authors.
+// Licensed under the Apache License 2.0.
+
+package store
+
+import (
+	"context"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunks"
+	"github.com/prometheus/prometheus/tsdb/index"
+	"github.com/thanos-io/thanos/pkg/block/indexheader"
+	"github.com/thanos-io/thanos/pkg/store/storepb"
+)
+
+// cardinalityIndexReader gives access to the index metadata required to estimate cardinality statistics.
+type cardinalityIndexReader interface {
+	LabelNames() ([]string, error)
+	LabelValues(name string) ([]string, error)
+	// SeriesCount returns the (estimated) number of series with the given label name and value.
+	SeriesCount(name, value string) (uint64, error)
+	// MatchingSeries calls f with the labels of each series matching all matchers.
+	MatchingSeries(matchers []*labels.Matcher, f func(labels.Labels)) error
+}
+
+// bucketBlockCardinalityReader estimates series counts from the postings offsets kept in the index header,
+// so postings are not fetched from the object storage. Only series matching selectors are fetched.
+type bucketBlockCardinalityReader struct {
+	ctx    context.Context
+	block  *bucketBlock
+	indexr *bucketIndexReader
+
+	// lastPosting is the range of the postings list written last to the index. The index header only guesses its
+	// end offset, so the length of this single list is read from the object storage instead.
+	lastPosting index.Range
+}
+
+func newBucketBlockCardinalityReader(ctx context.Context, indexr *bucketIndexReader) (*bucketBlockCardinalityReader, error) {
+	b := indexr.block
+	r := &bucketBlockCardinalityReader{ctx: ctx, block: b, indexr: indexr}
+
+	// Postings lists are written in the order of sorted label names and values.
+	names := b.indexHeaderReader.LabelNames()
+	if len(names) == 0 {
+		return r, nil
+	}
+	name := names[len(names)-1]
+	values, err := b.indexHeaderReader.LabelValues(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "index header label values")
+	}
+	if len(values) == 0 {
+		return r, nil
+	}
+	r.lastPosting, err = b.indexHeaderReader.PostingsOffset(name, values[len(values)-1])
+	if err != nil {
+		return nil, errors.Wrap(err, "index header PostingsOffset")
+	}
+	return r, nil
+}
+
+func (r *bucketBlockCardinalityReader) LabelNames() ([]string, error) {
+	return r.block.indexHeaderReader.LabelNames(), nil
+}
+
+func (r *bucketBlockCardinalityReader) LabelValues(name string) ([]string, error) {
+	return r.block.indexHeaderReader.LabelValues(name)
+}
+
+func (r *bucketBlockCardinalityReader) SeriesCount(name, value string) (uint64, error) {
+	rng, err := r.block.indexHeaderReader.PostingsOffset(name, value)
+	if err == indexheader.NotFoundRangeErr {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if rng == r.lastPosting {
+		b, err := r.block.readIndexRange(r.ctx, rng.Start, 4)
+		if err != nil {
+			return 0, errors.Wrap(err, "read postings length")
+		}
+		if len(b) != 4 {
+			return 0, errors.Errorf("unexpected postings length size %d", len(b))
+		}
+		return uint64(binary.BigEndian.Uint32(b)), nil
+	}
+	// Postings list starts with 4 bytes for the number of entries, followed by 4 bytes per series reference.
+	// The range end might include padding, which is always shorter than a single reference.
+	n := (rng.End - rng.Start - 4) / 4
+	if n < 0 {
+		return 0, nil
+	}
+	return uint64(n), nil
+}
+
+func (r *bucketBlockCardinalityReader) MatchingSeries(matchers []*labels.Matcher, f func(labels.Labels)) error {
+	ps, err := r.indexr.ExpandedPostings(matchers)
+	if err != nil {
+		return errors.Wrap(err, "expanded matching postings")
+	}
+	if len(ps) == 0 {
+		return nil
+	}
+	if err := r.indexr.PreloadSeries(ps); err != nil {
+		return errors.Wrap(err, "preload series")
+	}
+
+	var (
+		lset labels.Labels
+		chks []chunks.Meta
+	)
+	for _, id := range ps {
+		if err := r.indexr.LoadedSeries(id, &lset, &chks); err != nil {
+			return errors.Wrap(err, "read series")
+		}
+		f(lset)
+	}
+	return nil
+}
+
+// tsdbCardinalityReader counts series from postings of the TSDB index reader, e.g the one of the head block.
+type tsdbCardinalityReader struct {
+	r tsdb.IndexReader
+}
+
+func (r tsdbCardinalityReader) LabelNames() ([]string, error) {
+	return r.r.LabelNames()
+}
+
+func (r tsdbCardinalityReader) LabelValues(name string) ([]string, error) {
+	return r.r.LabelValues(name)
+}
+
+func (r tsdbCardinalityReader) SeriesCount(name, value string) (uint64, error) {
+	p, err := r.r.Postings(name, value)
+	if err != nil {
+		return 0, err
+	}
+	var n uint64
+	for p.Next() {
+		n++
+	}
+	return n, p.Err()
+}
+
+func (r tsdbCardinalityReader) MatchingSeries(matchers []*labels.Matcher, f func(labels.Labels)) error {
+	p, err := tsdb.PostingsForMatchers(r.r, matchers...)
+	if err != nil {
+		return errors.Wrap(err, "postings for matchers")
+	}
+
+	var (
+		lset labels.Labels
+		chks []chunks.Meta
+	)
+	for p.Next() {
+		err := r.r.Series(p.At(), &lset, &chks)
+		// Series might have been garbage collected since the postings were read.
+		if err == storage.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "read series")
+		}
+		f(lset)
+	}
+	return p.Err()
+}
+
+// cardinalityStatsBuilder accumulates cardinality statistics of indexes sharing the same external labels.
+// Series counts of different indexes are merged by taking the maximum, as subsequent blocks of a single
+// source mostly contain the same series, unless they hold disjoint shards of series and are summed instead.
+// Label values are merged as a union.
+type cardinalityStatsBuilder struct {
+	labelValues map[string]map[string]struct{}
+	seriesCount map[labels.Label]uint64
+}
+
+func newCardinalityStatsBuilder() *cardinalityStatsBuilder {
+	return &cardinalityStatsBuilder{
+		labelValues: map[string]map[string]struct{}{},
+		seriesCount: map[labels.Label]uint64{},
+	}
+}
+
+// addIndex adds statistics of the given index. Without matchers, statistics are estimated from the index metadata.
+// Otherwise, they are counted from the labels of the series matching all matchers, which are read from the index.
+// Indexes without any matching series are skipped.
+func (b *cardinalityStatsBuilder) addIndex(r cardinalityIndexReader, matchers []*labels.Matcher) error {
+	if len(matchers) > 0 {
+		return b.addMatchingSeries(r, matchers)
+	}
+
+	names, err := r.LabelNames()
+	if err != nil {
+		return errors.Wrap(err, "label names")
+	}
+
+	idx := newCardinalityStatsBuilder()
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		values, err := r.LabelValues(name)
+		if err != nil {
+			return errors.Wrapf(err, "label values for %s", name)
+		}
+		for _, v := range values {
+			n, err := r.SeriesCount(name, v)
+			if err != nil {
+				return errors.Wrapf(err, "series count for %s=%s", name, v)
+			}
+			if n == 0 {
+				continue
+			}
+			idx.add(name, v, n)
+		}
+	}
+	b.merge(idx)
+	return nil
+}
+
+func (b *cardinalityStatsBuilder) addMatchingSeries(r cardinalityIndexReader, matchers []*labels.Matcher) error {
+	counts := map[labels.Label]uint64{}
+	if err := r.MatchingSeries(matchers, func(lset labels.Labels) {
+		for _, l := range lset {
+			counts[l]++
+		}
+	}); err != nil {
+		return errors.Wrap(err, "matching series")
+	}
+
+	idx := newCardinalityStatsBuilder()
+	for l, n := range counts {
+		idx.add(l.Name, l.Value, n)
+	}
+	b.merge(idx)
+	return nil
+}
+
+func (b *cardinalityStatsBuilder) add(name, value string, n uint64) {
+	values, ok := b.labelValues[name]
+	if !ok {
+		values = map[string]struct{}{}
+		b.labelValues[name] = values
+	}
+	values[value] = struct{}{}
+
+	l := labels.Label{Name: name, Value: value}
+	if n > b.seriesCount[l] {
+		b.seriesCount[l] = n
+	}
+}
+
+func (b *cardinalityStatsBuilder) merge(o *cardinalityStatsBuilder) {
+	for l, n := range o.seriesCount {
+		b.add(l.Name, l.Value, n)
+	}
+}
+
+// sum adds statistics of an index holding series disjoint with the accumulated ones, like another shard of a block.
+func (b *cardinalityStatsBuilder) sum(o *cardinalityStatsBuilder) {
+	for l, n := range o.seriesCount {
+		b.add(l.Name, l.Value, b.seriesCount[l]+n)
+	}
+}
+
+// build returns statistics with at most limit entries per list. Zero limit means no limit.
+func (b *cardinalityStatsBuilder) build(lset labels.Labels, limit int) storepb.CardinalityStats {
+	stats := storepb.CardinalityStats{Labels: storepb.PromLabelsToLabelsUnsafe(lset)}
+	for l, n := range b.seriesCount {
+		if l.Name == labels.MetricName {
+			stats.SeriesCountByMetricName = append(stats.SeriesCountByMetricName, storepb.CardinalityStatsEntry{Name: l.Value, Count: n})
+		}
+		stats.SeriesCountByLabelValuePair = append(stats.SeriesCountByLabelValuePair, storepb.CardinalityStatsEntry{Name: l.Name, Value: l.Value, Count: n})
+	}
+	for name, values := range b.labelValues {
+		stats.LabelValueCountByLabelName = append(stats.LabelValueCountByLabelName, storepb.CardinalityStatsEntry{Name: name, Count: uint64(len(values))})
+	}
+	stats.SeriesCountByMetricName = storepb.TopCardinalityStatsEntries(stats.SeriesCountByMetricName, limit)
+	stats.LabelValueCountByLabelName = storepb.TopCardinalityStatsEntries(stats.LabelValueCountByLabelName, limit)
+	stats.SeriesCountByLabelValuePair = storepb.TopCardinalityStatsEntries(stats.SeriesCountByLabelValuePair, limit)
+	return stats
+}
@@ -248,6 +248,13 @@ func (s *LocalStore) LabelValues(_ context.Context, r *storepb.LabelValuesReques
 	return resp, nil
 }
 
+// CardinalityStats is not supported by the local store.
+func (s *LocalStore) CardinalityStats(_ context.Context, _ *storepb.CardinalityStatsRequest) (
+	*storepb.CardinalityStatsResponse, error,
+) {
+	return nil, status.Error(codes.Unimplemented, "cardinality stats are not supported by the local store")
+}
+
 func (s *LocalStore) Close() (err error) {
 	return s.c.Close()
 }
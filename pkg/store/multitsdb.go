@@ -283,3 +283,29 @@ func (s *MultiTSDBStore) LabelValues(ctx context.Context, req *storepb.LabelValu
 		Warnings: keys(warnings),
 	}, nil
 }
+
+// CardinalityStats returns cardinality statistics of each tenant TSDB.
+func (s *MultiTSDBStore) CardinalityStats(ctx context.Context, req *storepb.CardinalityStatsRequest) (*storepb.CardinalityStatsResponse, error) {
+	var (
+		stats    []storepb.CardinalityStats
+		warnings = map[string]struct{}{}
+	)
+
+	stores := s.tsdbStores()
+	for tenant, store := range stores {
+		r, err := store.CardinalityStats(ctx, req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "get cardinality stats for tenant %s", tenant)
+		}
+		stats = append(stats, r.Stats...)
+
+		for _, l := range r.Warnings {
+			warnings[prefixTenantWarning(tenant, l)] = struct{}{}
+		}
+	}
+
+	return &storepb.CardinalityStatsResponse{
+		Stats:    storepb.MergeCardinalityStats(int(req.Limit), stats...),
+		Warnings: keys(warnings),
+	}, nil
+}
@@ -662,6 +662,103 @@ func (p *PrometheusStore) LabelValues(ctx context.Context, r *storepb.LabelValue
 	return &storepb.LabelValuesResponse{Values: m.Data}, nil
 }
 
+// CardinalityStats returns cardinality statistics of the Prometheus head block as exposed by the TSDB status API.
+// NOTE: Prometheus returns only the top 10 entries of each list without taking matchers into account, so statistics
+// are only returned for selectors matching nothing but external labels. Other selectors are answered with a warning.
+func (p *PrometheusStore) CardinalityStats(ctx context.Context, r *storepb.CardinalityStatsRequest) (*storepb.CardinalityStatsResponse, error) {
+	externalLset := p.externalLabels()
+
+	match, newMatchers, err := matchesExternalLabels(r.Matchers, externalLset)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !match {
+		return &storepb.CardinalityStatsResponse{}, nil
+	}
+	if mint, maxt := p.timestamps(); r.MinTime > maxt || r.MaxTime < mint {
+		return &storepb.CardinalityStatsResponse{}, nil
+	}
+
+	if len(newMatchers) > 0 {
+		return &storepb.CardinalityStatsResponse{
+			Warnings: []string{"Prometheus does not support cardinality statistics of selectors beyond external labels"},
+		}, nil
+	}
+
+	u := *p.base
+	u.Path = path.Join(u.Path, "/api/v1/status/tsdb")
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	span, ctx := tracing.StartSpan(ctx, "/prom_tsdb_status HTTP[client]")
+	defer span.Finish()
+
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer runutil.ExhaustCloseWithLogOnErr(p.logger, resp.Body, "tsdb status request body")
+
+	type stat struct {
+		Name  string `json:"name"`
+		Value uint64 `json:"value"`
+	}
+	var m struct {
+		Data struct {
+			SeriesCountByMetricName     []stat `json:"seriesCountByMetricName"`
+			LabelValueCountByLabelName  []stat `json:"labelValueCountByLabelName"`
+			SeriesCountByLabelValuePair []stat `json:"seriesCountByLabelValuePair"`
+		} `json:"data"`
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if resp.StatusCode/100 != 2 {
+		code, exists := statusToCode[resp.StatusCode]
+		if !exists {
+			code = codes.Internal
+		}
+		return nil, status.Errorf(code, "request Prometheus server failed, code %s", resp.Status)
+	}
+
+	if err = json.Unmarshal(body, &m); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	if m.Status != SUCCESS {
+		return nil, status.Error(codes.Internal, m.Error)
+	}
+
+	stats := storepb.CardinalityStats{Labels: storepb.PromLabelsToLabels(externalLset)}
+	for _, e := range m.Data.SeriesCountByMetricName {
+		stats.SeriesCountByMetricName = append(stats.SeriesCountByMetricName, storepb.CardinalityStatsEntry{Name: e.Name, Count: e.Value})
+	}
+	for _, e := range m.Data.LabelValueCountByLabelName {
+		stats.LabelValueCountByLabelName = append(stats.LabelValueCountByLabelName, storepb.CardinalityStatsEntry{Name: e.Name, Count: e.Value})
+	}
+	for _, e := range m.Data.SeriesCountByLabelValuePair {
+		// Pairs are returned in the name=value form.
+		i := strings.Index(e.Name, "=")
+		if i < 0 {
+			continue
+		}
+		stats.SeriesCountByLabelValuePair = append(stats.SeriesCountByLabelValuePair, storepb.CardinalityStatsEntry{Name: e.Name[:i], Value: e.Name[i+1:], Count: e.Value})
+	}
+	stats.SeriesCountByMetricName = storepb.TopCardinalityStatsEntries(stats.SeriesCountByMetricName, int(r.Limit))
+	stats.LabelValueCountByLabelName = storepb.TopCardinalityStatsEntries(stats.LabelValueCountByLabelName, int(r.Limit))
+	stats.SeriesCountByLabelValuePair = storepb.TopCardinalityStatsEntries(stats.SeriesCountByLabelValuePair, int(r.Limit))
+
+	return &storepb.CardinalityStatsResponse{Stats: []storepb.CardinalityStats{stats}}, nil
+}
+
 // seriesLabels returns the labels from Prometheus series API.
 func (p *PrometheusStore) seriesLabels(ctx context.Context, matchers []storepb.LabelMatcher, startTime, endTime int64) ([]map[string]string, error) {
 	u := *p.base
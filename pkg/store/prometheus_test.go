@@ -7,6 +7,8 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 	"time"
@@ -450,6 +452,62 @@ func TestPrometheusStore_Info(t *testing.T) {
 	testutil.Equals(t, int64(456), resp.MaxTime)
 }
 
+func TestPrometheusStore_CardinalityStats(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		testutil.Equals(t, "/api/v1/status/tsdb", r.URL.Path)
+		_, _ = w.Write([]byte(`{"status":"success","data":{
+			"seriesCountByMetricName":[{"name":"up","value":10},{"name":"build_info","value":2}],
+			"labelValueCountByLabelName":[{"name":"instance","value":10},{"name":"job","value":2}],
+			"memoryInBytesByLabelName":[{"name":"instance","value":100}],
+			"seriesCountByLabelValuePair":[{"name":"job=a","value":8},{"name":"job=b","value":4},{"name":"__name__=up","value":10}]
+		}}`))
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	testutil.Ok(t, err)
+
+	proxy, err := NewPrometheusStore(nil, nil, u, component.Sidecar,
+		func() labels.Labels { return labels.FromStrings("region", "eu-west") },
+		func() (int64, int64) { return 123, 456 })
+	testutil.Ok(t, err)
+
+	resp, err := proxy.CardinalityStats(context.Background(), &storepb.CardinalityStatsRequest{
+		MinTime:  0,
+		MaxTime:  1000,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "region", Value: "eu-west"}},
+		Limit:    2,
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []storepb.CardinalityStats{{
+		Labels:                      []storepb.Label{{Name: "region", Value: "eu-west"}},
+		SeriesCountByMetricName:     []storepb.CardinalityStatsEntry{{Name: "up", Count: 10}, {Name: "build_info", Count: 2}},
+		LabelValueCountByLabelName:  []storepb.CardinalityStatsEntry{{Name: "instance", Count: 10}, {Name: "job", Count: 2}},
+		SeriesCountByLabelValuePair: []storepb.CardinalityStatsEntry{{Name: "__name__", Value: "up", Count: 10}, {Name: "job", Value: "a", Count: 8}},
+	}}, resp.Stats)
+
+	// Statistics of selectors beyond external labels are not supported.
+	resp, err = proxy.CardinalityStats(context.Background(), &storepb.CardinalityStatsRequest{
+		MinTime:  0,
+		MaxTime:  1000,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_NEQ, Name: "job", Value: "b"}},
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(resp.Stats))
+	testutil.Equals(t, 1, len(resp.Warnings))
+
+	// Not matching external labels.
+	resp, err = proxy.CardinalityStats(context.Background(), &storepb.CardinalityStatsRequest{
+		MinTime:  0,
+		MaxTime:  1000,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "region", Value: "us-east"}},
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(resp.Stats))
+}
+
 func testSeries_SplitSamplesIntoChunksWithMaxSizeOfUint16_e2e(t *testing.T, appender tsdb.Appender, newStore func() storepb.StoreServer) {
 	baseT := timestamp.FromTime(time.Now().AddDate(0, 0, -2)) / 1000 * 1000
 
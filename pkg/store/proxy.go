@@ -624,3 +624,72 @@ func (s *ProxyStore) LabelValues(ctx context.Context, r *storepb.LabelValuesRequ
 		Warnings: warnings,
 	}, nil
 }
+
+// CardinalityStats returns cardinality statistics merged from all stores matching the request.
+// Statistics of stores exposing equal external labels are merged, so replicas and overlapping stores
+// are not counted twice.
+func (s *ProxyStore) CardinalityStats(ctx context.Context, r *storepb.CardinalityStatsRequest) (
+	*storepb.CardinalityStatsResponse, error,
+) {
+	match, _, err := matchesExternalLabels(r.Matchers, s.selectorLabels)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !match {
+		return &storepb.CardinalityStatsResponse{}, nil
+	}
+
+	var (
+		warnings []string
+		all      []storepb.CardinalityStats
+		mtx      sync.Mutex
+		g, gctx  = errgroup.WithContext(ctx)
+	)
+
+	for _, st := range s.stores() {
+		// NOTE: all matchers are validated in matchesExternalLabels method so we explicitly ignore error.
+		if ok, _ := storeMatches(st, r.MinTime, r.MaxTime, r.Matchers...); !ok {
+			continue
+		}
+
+		store := st
+		g.Go(func() error {
+			resp, err := store.CardinalityStats(gctx, r)
+			if err != nil {
+				if status.Code(err) == codes.Unimplemented {
+					// Older stores do not support statistics, which should not fail the whole request.
+					mtx.Lock()
+					warnings = append(warnings, fmt.Sprintf("store %s does not support cardinality statistics", store))
+					mtx.Unlock()
+					return nil
+				}
+
+				err = errors.Wrapf(err, "fetch cardinality stats from store %s", store)
+				if r.PartialResponseDisabled {
+					return err
+				}
+
+				mtx.Lock()
+				warnings = append(warnings, err.Error())
+				mtx.Unlock()
+				return nil
+			}
+
+			mtx.Lock()
+			warnings = append(warnings, resp.Warnings...)
+			all = append(all, resp.Stats...)
+			mtx.Unlock()
+
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	return &storepb.CardinalityStatsResponse{
+		Stats:    storepb.MergeCardinalityStats(int(r.Limit), all...),
+		Warnings: warnings,
+	}, nil
+}
@@ -1245,6 +1245,85 @@ func seriesEquals(t *testing.T, expected []rawSeries, got []storepb.Series) {
 	}
 }
 
+func TestProxyStore_CardinalityStats(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	ext1 := []storepb.Label{{Name: "ext", Value: "1"}}
+	ext2 := []storepb.Label{{Name: "ext", Value: "2"}}
+	m1 := &mockedStoreAPI{
+		RespCardinality: &storepb.CardinalityStatsResponse{
+			Stats: []storepb.CardinalityStats{{
+				Labels:                      ext1,
+				SeriesCountByMetricName:     []storepb.CardinalityStatsEntry{{Name: "up", Count: 10}, {Name: "go_goroutines", Count: 5}},
+				LabelValueCountByLabelName:  []storepb.CardinalityStatsEntry{{Name: "instance", Count: 10}},
+				SeriesCountByLabelValuePair: []storepb.CardinalityStatsEntry{{Name: "job", Value: "a", Count: 15}},
+			}},
+			Warnings: []string{"warning"},
+		},
+	}
+	cls := []Client{
+		&testClient{StoreClient: m1, labelSets: []storepb.LabelSet{{Labels: ext1}}, minTime: 0, maxTime: 100},
+		// Replica of the same source with slightly different counts.
+		&testClient{StoreClient: &mockedStoreAPI{
+			RespCardinality: &storepb.CardinalityStatsResponse{
+				Stats: []storepb.CardinalityStats{{
+					Labels:                      ext1,
+					SeriesCountByMetricName:     []storepb.CardinalityStatsEntry{{Name: "up", Count: 12}},
+					LabelValueCountByLabelName:  []storepb.CardinalityStatsEntry{{Name: "instance", Count: 12}},
+					SeriesCountByLabelValuePair: []storepb.CardinalityStatsEntry{{Name: "job", Value: "a", Count: 12}},
+				}},
+			},
+		}, labelSets: []storepb.LabelSet{{Labels: ext1}}, minTime: 0, maxTime: 100},
+		&testClient{StoreClient: &mockedStoreAPI{
+			RespCardinality: &storepb.CardinalityStatsResponse{
+				Stats: []storepb.CardinalityStats{{
+					Labels:                  ext2,
+					SeriesCountByMetricName: []storepb.CardinalityStatsEntry{{Name: "up", Count: 3}},
+				}},
+			},
+		}, labelSets: []storepb.LabelSet{{Labels: ext2}}, minTime: 0, maxTime: 100},
+		// Older store without statistics support.
+		&testClient{StoreClient: &mockedStoreAPI{
+			RespError: status.Error(codes.Unimplemented, "unknown method"),
+		}, minTime: 0, maxTime: 100},
+		// Store outside of the requested time range.
+		&testClient{StoreClient: &mockedStoreAPI{
+			RespError: errors.New("should not be called"),
+		}, minTime: 200, maxTime: 300},
+	}
+	q := NewProxyStore(nil,
+		nil,
+		func() []Client { return cls },
+		component.Query,
+		nil,
+		0*time.Second,
+	)
+
+	req := &storepb.CardinalityStatsRequest{
+		MinTime:                 0,
+		MaxTime:                 100,
+		Limit:                   1,
+		PartialResponseDisabled: true,
+	}
+	resp, err := q.CardinalityStats(context.Background(), req)
+	testutil.Ok(t, err)
+	testutil.Assert(t, proto.Equal(req, m1.LastCardinalityReq), "request was not proxied properly to underlying storeAPI: %s vs %s", req, m1.LastCardinalityReq)
+
+	testutil.Equals(t, []storepb.CardinalityStats{
+		{
+			Labels:                      ext1,
+			SeriesCountByMetricName:     []storepb.CardinalityStatsEntry{{Name: "up", Count: 12}},
+			LabelValueCountByLabelName:  []storepb.CardinalityStatsEntry{{Name: "instance", Count: 12}},
+			SeriesCountByLabelValuePair: []storepb.CardinalityStatsEntry{{Name: "job", Value: "a", Count: 15}},
+		},
+		{
+			Labels:                  ext2,
+			SeriesCountByMetricName: []storepb.CardinalityStatsEntry{{Name: "up", Count: 3}},
+		},
+	}, resp.Stats)
+	testutil.Equals(t, 2, len(resp.Warnings))
+}
+
 func TestStoreMatches(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
 
@@ -1404,6 +1483,7 @@ type mockedStoreAPI struct {
 	RespSeries      []*storepb.SeriesResponse
 	RespLabelValues *storepb.LabelValuesResponse
 	RespLabelNames  *storepb.LabelNamesResponse
+	RespCardinality *storepb.CardinalityStatsResponse
 	RespError       error
 	RespDuration    time.Duration
 	// Index of series in store to slow response.
@@ -1412,6 +1492,7 @@ type mockedStoreAPI struct {
 	LastSeriesReq      *storepb.SeriesRequest
 	LastLabelValuesReq *storepb.LabelValuesRequest
 	LastLabelNamesReq  *storepb.LabelNamesRequest
+	LastCardinalityReq *storepb.CardinalityStatsRequest
 
 	// injectedError will be injected into Recv() if not nil.
 	injectedError      error
@@ -1440,6 +1521,12 @@ func (s *mockedStoreAPI) LabelValues(ctx context.Context, req *storepb.LabelValu
 	return s.RespLabelValues, s.RespError
 }
 
+func (s *mockedStoreAPI) CardinalityStats(ctx context.Context, req *storepb.CardinalityStatsRequest, _ ...grpc.CallOption) (*storepb.CardinalityStatsResponse, error) {
+	s.LastCardinalityReq = req
+
+	return s.RespCardinality, s.RespError
+}
+
 // StoreSeriesClient is test gRPC storeAPI series client.
 type StoreSeriesClient struct {
 	// This field just exist to pseudo-implement the unused methods of the interface.
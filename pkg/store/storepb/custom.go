@@ -4,6 +4,7 @@
 package storepb
 
 import (
+	"sort"
 	"strings"
 	"unsafe"
 
@@ -243,3 +244,90 @@ func LabelSetsToString(lsets []LabelSet) string {
 	}
 	return strings.Join(s, "")
 }
+
+// TopCardinalityStatsEntries sorts entries by count in descending order and returns at most limit of them.
+// Zero limit means no limit.
+func TopCardinalityStatsEntries(entries []CardinalityStatsEntry, limit int) []CardinalityStatsEntry {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		return entries[i].Value < entries[j].Value
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// MergeCardinalityStats merges statistics with equal external labels by taking the maximum of each entry,
+// as those are assumed to come from replicated or overlapping sources. Result is sorted by labels.
+func MergeCardinalityStats(limit int, stats ...CardinalityStats) []CardinalityStats {
+	type mergedStats struct {
+		lset        labels.Labels
+		metricNames map[string]uint64
+		labelNames  map[string]uint64
+		labelPairs  map[labels.Label]uint64
+	}
+	var (
+		byLset = map[uint64]*mergedStats{}
+		keys   []uint64
+	)
+	for _, s := range stats {
+		lset := LabelsToPromLabels(s.Labels)
+		h := lset.Hash()
+		m, ok := byLset[h]
+		if !ok {
+			m = &mergedStats{
+				lset:        lset,
+				metricNames: map[string]uint64{},
+				labelNames:  map[string]uint64{},
+				labelPairs:  map[labels.Label]uint64{},
+			}
+			byLset[h] = m
+			keys = append(keys, h)
+		}
+		for _, e := range s.SeriesCountByMetricName {
+			if e.Count > m.metricNames[e.Name] {
+				m.metricNames[e.Name] = e.Count
+			}
+		}
+		for _, e := range s.LabelValueCountByLabelName {
+			if e.Count > m.labelNames[e.Name] {
+				m.labelNames[e.Name] = e.Count
+			}
+		}
+		for _, e := range s.SeriesCountByLabelValuePair {
+			l := labels.Label{Name: e.Name, Value: e.Value}
+			if e.Count > m.labelPairs[l] {
+				m.labelPairs[l] = e.Count
+			}
+		}
+	}
+
+	res := make([]CardinalityStats, 0, len(keys))
+	for _, h := range keys {
+		m := byLset[h]
+		s := CardinalityStats{Labels: PromLabelsToLabels(m.lset)}
+		for name, n := range m.metricNames {
+			s.SeriesCountByMetricName = append(s.SeriesCountByMetricName, CardinalityStatsEntry{Name: name, Count: n})
+		}
+		for name, n := range m.labelNames {
+			s.LabelValueCountByLabelName = append(s.LabelValueCountByLabelName, CardinalityStatsEntry{Name: name, Count: n})
+		}
+		for l, n := range m.labelPairs {
+			s.SeriesCountByLabelValuePair = append(s.SeriesCountByLabelValuePair, CardinalityStatsEntry{Name: l.Name, Value: l.Value, Count: n})
+		}
+		s.SeriesCountByMetricName = TopCardinalityStatsEntries(s.SeriesCountByMetricName, limit)
+		s.LabelValueCountByLabelName = TopCardinalityStatsEntries(s.LabelValueCountByLabelName, limit)
+		s.SeriesCountByLabelValuePair = TopCardinalityStatsEntries(s.SeriesCountByLabelValuePair, limit)
+		res = append(res, s)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return labels.Compare(LabelsToPromLabels(res[i].Labels), LabelsToPromLabels(res[j].Labels)) < 0
+	})
+	return res
+}
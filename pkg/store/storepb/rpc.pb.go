@@ -30,6 +30,35 @@ var _ = math.Inf
 // proto package needs to be updated.
 const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
 
+/// Compression of write requests sent over write streams.
+type Compression int32
+
+const (
+	Compression_NONE   Compression = 0
+	Compression_SNAPPY Compression = 1
+	Compression_ZSTD   Compression = 2
+)
+
+var Compression_name = map[int32]string{
+	0: "NONE",
+	1: "SNAPPY",
+	2: "ZSTD",
+}
+
+var Compression_value = map[string]int32{
+	"NONE":   0,
+	"SNAPPY": 1,
+	"ZSTD":   2,
+}
+
+func (x Compression) String() string {
+	return proto.EnumName(Compression_name, int32(x))
+}
+
+func (Compression) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_77a6da22d6a3feb1, []int{0}
+}
+
 type StoreType int32
 
 const (
@@ -68,7 +97,7 @@ func (x StoreType) String() string {
 }
 
 func (StoreType) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_77a6da22d6a3feb1, []int{0}
+	return fileDescriptor_77a6da22d6a3feb1, []int{1}
 }
 
 /// PartialResponseStrategy controls partial response handling.
@@ -102,7 +131,7 @@ func (x PartialResponseStrategy) String() string {
 }
 
 func (PartialResponseStrategy) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_77a6da22d6a3feb1, []int{1}
+	return fileDescriptor_77a6da22d6a3feb1, []int{2}
 }
 
 type Aggr int32
@@ -114,6 +143,8 @@ const (
 	Aggr_MIN     Aggr = 3
 	Aggr_MAX     Aggr = 4
 	Aggr_COUNTER Aggr = 5
+	Aggr_LAST    Aggr = 6
+	Aggr_SKETCH  Aggr = 7
 )
 
 var Aggr_name = map[int32]string{
@@ -123,6 +154,8 @@ var Aggr_name = map[int32]string{
 	3: "MIN",
 	4: "MAX",
 	5: "COUNTER",
+	6: "LAST",
+	7: "SKETCH",
 }
 
 var Aggr_value = map[string]int32{
@@ -132,6 +165,8 @@ var Aggr_value = map[string]int32{
 	"MIN":     3,
 	"MAX":     4,
 	"COUNTER": 5,
+	"LAST":    6,
+	"SKETCH":  7,
 }
 
 func (x Aggr) String() string {
@@ -139,7 +174,7 @@ func (x Aggr) String() string {
 }
 
 func (Aggr) EnumDescriptor() ([]byte, []int) {
-	return fileDescriptor_77a6da22d6a3feb1, []int{2}
+	return fileDescriptor_77a6da22d6a3feb1, []int{3}
 }
 
 type WriteResponse struct {
@@ -179,9 +214,10 @@ func (m *WriteResponse) XXX_DiscardUnknown() {
 var xxx_messageInfo_WriteResponse proto.InternalMessageInfo
 
 type WriteRequest struct {
-	Timeseries []prompb.TimeSeries `protobuf:"bytes,1,rep,name=timeseries,proto3" json:"timeseries"`
-	Tenant     string              `protobuf:"bytes,2,opt,name=tenant,proto3" json:"tenant,omitempty"`
-	Replica    int64               `protobuf:"varint,3,opt,name=replica,proto3" json:"replica,omitempty"`
+	Timeseries []prompb.TimeSeries     `protobuf:"bytes,1,rep,name=timeseries,proto3" json:"timeseries"`
+	Tenant     string                  `protobuf:"bytes,2,opt,name=tenant,proto3" json:"tenant,omitempty"`
+	Replica    int64                   `protobuf:"varint,3,opt,name=replica,proto3" json:"replica,omitempty"`
+	Metadata   []prompb.MetricMetadata `protobuf:"bytes,4,rep,name=metadata,proto3" json:"metadata"`
 }
 
 func (m *WriteRequest) Reset()         { *m = WriteRequest{} }
@@ -217,6 +253,90 @@ func (m *WriteRequest) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_WriteRequest proto.InternalMessageInfo
 
+type WriteStreamRequest struct {
+	// seq is the sequence number of the request within the stream, sent back by its response.
+	Seq         uint64      `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`
+	Compression Compression `protobuf:"varint,2,opt,name=compression,proto3,enum=thanos.Compression" json:"compression,omitempty"`
+	// data is the marshaled WriteRequest, compressed with the given compression.
+	Data []byte `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	// timeout_ms is the time in milliseconds the sender waits for the response, after which the request is abandoned.
+	// 0 means no timeout.
+	TimeoutMs int64 `protobuf:"varint,4,opt,name=timeout_ms,json=timeoutMs,proto3" json:"timeout_ms,omitempty"`
+}
+
+func (m *WriteStreamRequest) Reset()         { *m = WriteStreamRequest{} }
+func (m *WriteStreamRequest) String() string { return proto.CompactTextString(m) }
+func (*WriteStreamRequest) ProtoMessage()    {}
+func (*WriteStreamRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_77a6da22d6a3feb1, []int{2}
+}
+func (m *WriteStreamRequest) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *WriteStreamRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_WriteStreamRequest.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *WriteStreamRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WriteStreamRequest.Merge(m, src)
+}
+func (m *WriteStreamRequest) XXX_Size() int {
+	return m.Size()
+}
+func (m *WriteStreamRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_WriteStreamRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WriteStreamRequest proto.InternalMessageInfo
+
+type WriteStreamResponse struct {
+	Seq uint64 `protobuf:"varint,1,opt,name=seq,proto3" json:"seq,omitempty"`
+	// code is the gRPC status code of writing the request, OK if it was written.
+	Code    int32  `protobuf:"varint,2,opt,name=code,proto3" json:"code,omitempty"`
+	Message string `protobuf:"bytes,3,opt,name=message,proto3" json:"message,omitempty"`
+}
+
+func (m *WriteStreamResponse) Reset()         { *m = WriteStreamResponse{} }
+func (m *WriteStreamResponse) String() string { return proto.CompactTextString(m) }
+func (*WriteStreamResponse) ProtoMessage()    {}
+func (*WriteStreamResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_77a6da22d6a3feb1, []int{3}
+}
+func (m *WriteStreamResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *WriteStreamResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_WriteStreamResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *WriteStreamResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_WriteStreamResponse.Merge(m, src)
+}
+func (m *WriteStreamResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *WriteStreamResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_WriteStreamResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_WriteStreamResponse proto.InternalMessageInfo
+
 type InfoRequest struct {
 }
 
@@ -224,7 +344,7 @@ func (m *InfoRequest) Reset()         { *m = InfoRequest{} }
 func (m *InfoRequest) String() string { return proto.CompactTextString(m) }
 func (*InfoRequest) ProtoMessage()    {}
 func (*InfoRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_77a6da22d6a3feb1, []int{2}
+	return fileDescriptor_77a6da22d6a3feb1, []int{4}
 }
 func (m *InfoRequest) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -267,7 +387,7 @@ func (m *InfoResponse) Reset()         { *m = InfoResponse{} }
 func (m *InfoResponse) String() string { return proto.CompactTextString(m) }
 func (*InfoResponse) ProtoMessage()    {}
 func (*InfoResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_77a6da22d6a3feb1, []int{3}
+	return fileDescriptor_77a6da22d6a3feb1, []int{5}
 }
 func (m *InfoResponse) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -304,7 +424,7 @@ func (m *LabelSet) Reset()         { *m = LabelSet{} }
 func (m *LabelSet) String() string { return proto.CompactTextString(m) }
 func (*LabelSet) ProtoMessage()    {}
 func (*LabelSet) Descriptor() ([]byte, []int) {
-	return fileDescriptor_77a6da22d6a3feb1, []int{4}
+	return fileDescriptor_77a6da22d6a3feb1, []int{6}
 }
 func (m *LabelSet) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -351,7 +471,7 @@ func (m *SeriesRequest) Reset()         { *m = SeriesRequest{} }
 func (m *SeriesRequest) String() string { return proto.CompactTextString(m) }
 func (*SeriesRequest) ProtoMessage()    {}
 func (*SeriesRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_77a6da22d6a3feb1, []int{5}
+	return fileDescriptor_77a6da22d6a3feb1, []int{7}
 }
 func (m *SeriesRequest) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -392,7 +512,7 @@ func (m *SeriesResponse) Reset()         { *m = SeriesResponse{} }
 func (m *SeriesResponse) String() string { return proto.CompactTextString(m) }
 func (*SeriesResponse) ProtoMessage()    {}
 func (*SeriesResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_77a6da22d6a3feb1, []int{6}
+	return fileDescriptor_77a6da22d6a3feb1, []int{8}
 }
 func (m *SeriesResponse) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -488,7 +608,7 @@ func (m *LabelNamesRequest) Reset()         { *m = LabelNamesRequest{} }
 func (m *LabelNamesRequest) String() string { return proto.CompactTextString(m) }
 func (*LabelNamesRequest) ProtoMessage()    {}
 func (*LabelNamesRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_77a6da22d6a3feb1, []int{7}
+	return fileDescriptor_77a6da22d6a3feb1, []int{9}
 }
 func (m *LabelNamesRequest) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -526,7 +646,7 @@ func (m *LabelNamesResponse) Reset()         { *m = LabelNamesResponse{} }
 func (m *LabelNamesResponse) String() string { return proto.CompactTextString(m) }
 func (*LabelNamesResponse) ProtoMessage()    {}
 func (*LabelNamesResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_77a6da22d6a3feb1, []int{8}
+	return fileDescriptor_77a6da22d6a3feb1, []int{10}
 }
 func (m *LabelNamesResponse) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -566,7 +686,7 @@ func (m *LabelValuesRequest) Reset()         { *m = LabelValuesRequest{} }
 func (m *LabelValuesRequest) String() string { return proto.CompactTextString(m) }
 func (*LabelValuesRequest) ProtoMessage()    {}
 func (*LabelValuesRequest) Descriptor() ([]byte, []int) {
-	return fileDescriptor_77a6da22d6a3feb1, []int{9}
+	return fileDescriptor_77a6da22d6a3feb1, []int{11}
 }
 func (m *LabelValuesRequest) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -604,7 +724,7 @@ func (m *LabelValuesResponse) Reset()         { *m = LabelValuesResponse{} }
 func (m *LabelValuesResponse) String() string { return proto.CompactTextString(m) }
 func (*LabelValuesResponse) ProtoMessage()    {}
 func (*LabelValuesResponse) Descriptor() ([]byte, []int) {
-	return fileDescriptor_77a6da22d6a3feb1, []int{10}
+	return fileDescriptor_77a6da22d6a3feb1, []int{12}
 }
 func (m *LabelValuesResponse) XXX_Unmarshal(b []byte) error {
 	return m.Unmarshal(b)
@@ -633,12 +753,181 @@ func (m *LabelValuesResponse) XXX_DiscardUnknown() {
 
 var xxx_messageInfo_LabelValuesResponse proto.InternalMessageInfo
 
+type CardinalityStatsRequest struct {
+	MinTime  int64          `protobuf:"varint,1,opt,name=min_time,json=minTime,proto3" json:"min_time,omitempty"`
+	MaxTime  int64          `protobuf:"varint,2,opt,name=max_time,json=maxTime,proto3" json:"max_time,omitempty"`
+	Matchers []LabelMatcher `protobuf:"bytes,3,rep,name=matchers,proto3" json:"matchers"`
+	/// limit is the maximum number of entries returned in each statistics list. Zero means no limit.
+	Limit                   int64 `protobuf:"varint,4,opt,name=limit,proto3" json:"limit,omitempty"`
+	PartialResponseDisabled bool  `protobuf:"varint,5,opt,name=partial_response_disabled,json=partialResponseDisabled,proto3" json:"partial_response_disabled,omitempty"`
+}
+
+func (m *CardinalityStatsRequest) Reset()         { *m = CardinalityStatsRequest{} }
+func (m *CardinalityStatsRequest) String() string { return proto.CompactTextString(m) }
+func (*CardinalityStatsRequest) ProtoMessage()    {}
+func (*CardinalityStatsRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_77a6da22d6a3feb1, []int{13}
+}
+func (m *CardinalityStatsRequest) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *CardinalityStatsRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_CardinalityStatsRequest.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *CardinalityStatsRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CardinalityStatsRequest.Merge(m, src)
+}
+func (m *CardinalityStatsRequest) XXX_Size() int {
+	return m.Size()
+}
+func (m *CardinalityStatsRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_CardinalityStatsRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CardinalityStatsRequest proto.InternalMessageInfo
+
+type CardinalityStatsResponse struct {
+	/// stats holds statistics for each unique set of external labels the store exposes.
+	Stats    []CardinalityStats `protobuf:"bytes,1,rep,name=stats,proto3" json:"stats"`
+	Warnings []string           `protobuf:"bytes,2,rep,name=warnings,proto3" json:"warnings,omitempty"`
+}
+
+func (m *CardinalityStatsResponse) Reset()         { *m = CardinalityStatsResponse{} }
+func (m *CardinalityStatsResponse) String() string { return proto.CompactTextString(m) }
+func (*CardinalityStatsResponse) ProtoMessage()    {}
+func (*CardinalityStatsResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_77a6da22d6a3feb1, []int{14}
+}
+func (m *CardinalityStatsResponse) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *CardinalityStatsResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_CardinalityStatsResponse.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *CardinalityStatsResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CardinalityStatsResponse.Merge(m, src)
+}
+func (m *CardinalityStatsResponse) XXX_Size() int {
+	return m.Size()
+}
+func (m *CardinalityStatsResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_CardinalityStatsResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CardinalityStatsResponse proto.InternalMessageInfo
+
+/// CardinalityStats represents estimated cardinality statistics for a single set of external labels.
+/// All lists are sorted by count in descending order.
+type CardinalityStats struct {
+	Labels []Label `protobuf:"bytes,1,rep,name=labels,proto3" json:"labels"`
+	/// series_count_by_metric_name is the estimated number of series for each metric name.
+	SeriesCountByMetricName []CardinalityStatsEntry `protobuf:"bytes,2,rep,name=series_count_by_metric_name,json=seriesCountByMetricName,proto3" json:"series_count_by_metric_name"`
+	/// label_value_count_by_label_name is the number of distinct values for each label name.
+	LabelValueCountByLabelName []CardinalityStatsEntry `protobuf:"bytes,3,rep,name=label_value_count_by_label_name,json=labelValueCountByLabelName,proto3" json:"label_value_count_by_label_name"`
+	/// series_count_by_label_value_pair is the estimated number of series for each label name and value pair.
+	SeriesCountByLabelValuePair []CardinalityStatsEntry `protobuf:"bytes,4,rep,name=series_count_by_label_value_pair,json=seriesCountByLabelValuePair,proto3" json:"series_count_by_label_value_pair"`
+}
+
+func (m *CardinalityStats) Reset()         { *m = CardinalityStats{} }
+func (m *CardinalityStats) String() string { return proto.CompactTextString(m) }
+func (*CardinalityStats) ProtoMessage()    {}
+func (*CardinalityStats) Descriptor() ([]byte, []int) {
+	return fileDescriptor_77a6da22d6a3feb1, []int{15}
+}
+func (m *CardinalityStats) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *CardinalityStats) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_CardinalityStats.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *CardinalityStats) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CardinalityStats.Merge(m, src)
+}
+func (m *CardinalityStats) XXX_Size() int {
+	return m.Size()
+}
+func (m *CardinalityStats) XXX_DiscardUnknown() {
+	xxx_messageInfo_CardinalityStats.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CardinalityStats proto.InternalMessageInfo
+
+type CardinalityStatsEntry struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	/// value is set only for label name and value pair entries.
+	Value string `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Count uint64 `protobuf:"varint,3,opt,name=count,proto3" json:"count,omitempty"`
+}
+
+func (m *CardinalityStatsEntry) Reset()         { *m = CardinalityStatsEntry{} }
+func (m *CardinalityStatsEntry) String() string { return proto.CompactTextString(m) }
+func (*CardinalityStatsEntry) ProtoMessage()    {}
+func (*CardinalityStatsEntry) Descriptor() ([]byte, []int) {
+	return fileDescriptor_77a6da22d6a3feb1, []int{16}
+}
+func (m *CardinalityStatsEntry) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *CardinalityStatsEntry) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_CardinalityStatsEntry.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *CardinalityStatsEntry) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_CardinalityStatsEntry.Merge(m, src)
+}
+func (m *CardinalityStatsEntry) XXX_Size() int {
+	return m.Size()
+}
+func (m *CardinalityStatsEntry) XXX_DiscardUnknown() {
+	xxx_messageInfo_CardinalityStatsEntry.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_CardinalityStatsEntry proto.InternalMessageInfo
+
 func init() {
+	proto.RegisterEnum("thanos.Compression", Compression_name, Compression_value)
 	proto.RegisterEnum("thanos.StoreType", StoreType_name, StoreType_value)
 	proto.RegisterEnum("thanos.PartialResponseStrategy", PartialResponseStrategy_name, PartialResponseStrategy_value)
 	proto.RegisterEnum("thanos.Aggr", Aggr_name, Aggr_value)
 	proto.RegisterType((*WriteResponse)(nil), "thanos.WriteResponse")
 	proto.RegisterType((*WriteRequest)(nil), "thanos.WriteRequest")
+	proto.RegisterType((*WriteStreamRequest)(nil), "thanos.WriteStreamRequest")
+	proto.RegisterType((*WriteStreamResponse)(nil), "thanos.WriteStreamResponse")
 	proto.RegisterType((*InfoRequest)(nil), "thanos.InfoRequest")
 	proto.RegisterType((*InfoResponse)(nil), "thanos.InfoResponse")
 	proto.RegisterType((*LabelSet)(nil), "thanos.LabelSet")
@@ -648,73 +937,103 @@ func init() {
 	proto.RegisterType((*LabelNamesResponse)(nil), "thanos.LabelNamesResponse")
 	proto.RegisterType((*LabelValuesRequest)(nil), "thanos.LabelValuesRequest")
 	proto.RegisterType((*LabelValuesResponse)(nil), "thanos.LabelValuesResponse")
+	proto.RegisterType((*CardinalityStatsRequest)(nil), "thanos.CardinalityStatsRequest")
+	proto.RegisterType((*CardinalityStatsResponse)(nil), "thanos.CardinalityStatsResponse")
+	proto.RegisterType((*CardinalityStats)(nil), "thanos.CardinalityStats")
+	proto.RegisterType((*CardinalityStatsEntry)(nil), "thanos.CardinalityStatsEntry")
 }
 
 func init() { proto.RegisterFile("rpc.proto", fileDescriptor_77a6da22d6a3feb1) }
 
 var fileDescriptor_77a6da22d6a3feb1 = []byte{
-	// 975 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xa4, 0x56, 0x4b, 0x6f, 0x23, 0x45,
-	0x10, 0x76, 0x7b, 0xfc, 0x2c, 0x6f, 0xc2, 0x6c, 0xe7, 0xb1, 0x13, 0xaf, 0xe4, 0x44, 0x23, 0x21,
-	0x59, 0x61, 0xe5, 0x80, 0x11, 0x20, 0x10, 0x17, 0x3b, 0xf1, 0x92, 0x88, 0x8d, 0x03, 0xed, 0x78,
-	0xc3, 0x43, 0xc8, 0x1a, 0x27, 0xbd, 0xe3, 0xd1, 0xce, 0x8b, 0xe9, 0x36, 0x89, 0xaf, 0x70, 0x47,
-	0x5c, 0xf9, 0x0f, 0xfc, 0x0b, 0x2e, 0x39, 0xee, 0x11, 0x2e, 0x08, 0x92, 0x3f, 0x82, 0xfa, 0x31,
-	0x8e, 0x67, 0xc9, 0x46, 0x42, 0xb9, 0x75, 0x7d, 0x5f, 0x75, 0x57, 0xd5, 0xd7, 0x55, 0xd3, 0x03,
-	0xd5, 0x24, 0x3e, 0x6d, 0xc5, 0x49, 0xc4, 0x23, 0x5c, 0xe2, 0x13, 0x27, 0x8c, 0x58, 0xbd, 0xc6,
-	0x67, 0x31, 0x65, 0x0a, 0xac, 0xaf, 0xba, 0x91, 0x1b, 0xc9, 0xe5, 0x8e, 0x58, 0x69, 0x14, 0xc7,
-	0x49, 0x14, 0xc4, 0xe3, 0x9d, 0x45, 0xcf, 0x0d, 0x37, 0x8a, 0x5c, 0x9f, 0xee, 0x48, 0x6b, 0x3c,
-	0x7d, 0xb1, 0xe3, 0x84, 0x33, 0x45, 0xd9, 0x6f, 0xc1, 0xd2, 0x49, 0xe2, 0x71, 0x4a, 0x28, 0x8b,
-	0xa3, 0x90, 0x51, 0xfb, 0x27, 0x04, 0x0f, 0x34, 0xf2, 0xfd, 0x94, 0x32, 0x8e, 0x3b, 0x00, 0xdc,
-	0x0b, 0x28, 0xa3, 0x89, 0x47, 0x99, 0x85, 0xb6, 0x8c, 0x66, 0xad, 0xfd, 0x58, 0xec, 0x0e, 0x28,
-	0x9f, 0xd0, 0x29, 0x1b, 0x9d, 0x46, 0xf1, 0xac, 0x75, 0xec, 0x05, 0x74, 0x20, 0x5d, 0xba, 0x85,
-	0xcb, 0xbf, 0x36, 0x73, 0x64, 0x61, 0x13, 0x5e, 0x87, 0x12, 0xa7, 0xa1, 0x13, 0x72, 0x2b, 0xbf,
-	0x85, 0x9a, 0x55, 0xa2, 0x2d, 0x6c, 0x41, 0x39, 0xa1, 0xb1, 0xef, 0x9d, 0x3a, 0x96, 0xb1, 0x85,
-	0x9a, 0x06, 0x49, 0x4d, 0x7b, 0x09, 0x6a, 0x07, 0xe1, 0x8b, 0x48, 0xe7, 0x60, 0xff, 0x89, 0xe0,
-	0x81, 0xb2, 0x55, 0x96, 0xf8, 0x1d, 0x28, 0xf9, 0xce, 0x98, 0xfa, 0x69, 0x42, 0x4b, 0x2d, 0xa5,
-	0x50, 0xeb, 0x99, 0x40, 0x75, 0x0a, 0xda, 0x05, 0x6f, 0x40, 0x25, 0xf0, 0xc2, 0x91, 0x48, 0x48,
-	0x26, 0x60, 0x90, 0x72, 0xe0, 0x85, 0x22, 0x63, 0x49, 0x39, 0x17, 0x8a, 0xd2, 0x29, 0x04, 0xce,
-	0x85, 0xa4, 0x76, 0xa0, 0xca, 0x78, 0x94, 0xd0, 0xe3, 0x59, 0x4c, 0xad, 0xc2, 0x16, 0x6a, 0x2e,
-	0xb7, 0x1f, 0xa6, 0x51, 0x06, 0x29, 0x41, 0x6e, 0x7c, 0xf0, 0x07, 0x00, 0x32, 0xe0, 0x88, 0x51,
-	0xce, 0xac, 0xa2, 0xcc, 0xcb, 0xcc, 0xe4, 0x35, 0xa0, 0x5c, 0xa7, 0x56, 0xf5, 0xb5, 0xcd, 0xec,
-	0x8f, 0xa0, 0x92, 0x92, 0xff, 0xab, 0x2c, 0xfb, 0x57, 0x03, 0x96, 0x94, 0xe4, 0xe9, 0x55, 0x2d,
-	0x16, 0x8a, 0xde, 0x5c, 0x68, 0x3e, 0x5b, 0xe8, 0x87, 0x82, 0xe2, 0xa7, 0x13, 0x9a, 0x30, 0xcb,
-	0x90, 0x61, 0x57, 0x33, 0x61, 0x0f, 0x15, 0xa9, 0xa3, 0xcf, 0x7d, 0x71, 0x1b, 0xd6, 0xc4, 0x91,
-	0x09, 0x65, 0x91, 0x3f, 0xe5, 0x5e, 0x14, 0x8e, 0xce, 0xbd, 0xf0, 0x2c, 0x3a, 0x97, 0x62, 0x19,
-	0x64, 0x25, 0x70, 0x2e, 0xc8, 0x9c, 0x3b, 0x91, 0x14, 0x7e, 0x02, 0xe0, 0xb8, 0x6e, 0x42, 0x5d,
-	0x87, 0x53, 0xa5, 0xd1, 0x72, 0xfb, 0x41, 0x1a, 0xad, 0xe3, 0xba, 0x09, 0x59, 0xe0, 0xf1, 0x27,
-	0xb0, 0x11, 0x3b, 0x09, 0xf7, 0x1c, 0x5f, 0x44, 0x91, 0x37, 0x3f, 0x3a, 0xf3, 0x98, 0x33, 0xf6,
-	0xe9, 0x99, 0x55, 0xda, 0x42, 0xcd, 0x0a, 0x79, 0xa4, 0x1d, 0xd2, 0xce, 0xd8, 0xd3, 0x34, 0xfe,
-	0xf6, 0x96, 0xbd, 0x8c, 0x27, 0x0e, 0xa7, 0xee, 0xcc, 0x2a, 0xcb, 0xeb, 0xdc, 0x4c, 0x03, 0x7f,
-	0x91, 0x3d, 0x63, 0xa0, 0xdd, 0xfe, 0x73, 0x78, 0x4a, 0xe0, 0x4d, 0xa8, 0xb1, 0x97, 0x5e, 0x3c,
-	0x3a, 0x9d, 0x4c, 0xc3, 0x97, 0xcc, 0xaa, 0xc8, 0x54, 0x40, 0x40, 0xbb, 0x12, 0xb1, 0x7f, 0x46,
-	0xb0, 0x9c, 0xde, 0x8d, 0x6e, 0xd9, 0x26, 0x94, 0xe6, 0x33, 0x84, 0x9a, 0xb5, 0xf6, 0xf2, 0xbc,
-	0x99, 0x24, 0xba, 0x9f, 0x23, 0x9a, 0xc7, 0x75, 0x28, 0x9f, 0x3b, 0x49, 0xe8, 0x85, 0xae, 0x9a,
-	0x97, 0xfd, 0x1c, 0x49, 0x01, 0xfc, 0x04, 0x8a, 0x13, 0x2f, 0xe4, 0x4c, 0x76, 0xab, 0xb8, 0x29,
-	0x35, 0xda, 0xad, 0x74, 0xb4, 0x5b, 0x9d, 0x70, 0xb6, 0x9f, 0x23, 0xca, 0xa9, 0x5b, 0x81, 0x52,
-	0x42, 0xd9, 0xd4, 0xe7, 0xf6, 0x6f, 0x08, 0x1e, 0xca, 0xdb, 0xec, 0x3b, 0xc1, 0x4d, 0xc3, 0xdc,
-	0x29, 0x30, 0xba, 0x87, 0xc0, 0xf9, 0xfb, 0x09, 0x6c, 0x3f, 0x05, 0xbc, 0x98, 0xad, 0x96, 0x70,
-	0x15, 0x8a, 0xa1, 0x00, 0xe4, 0x74, 0x54, 0x89, 0x32, 0x70, 0x1d, 0x2a, 0x5a, 0x1d, 0x66, 0xe5,
-	0x25, 0x31, 0xb7, 0xed, 0xdf, 0x91, 0x3e, 0xe8, 0xb9, 0xe3, 0x4f, 0x6f, 0xea, 0x5e, 0x85, 0xa2,
-	0x1c, 0x22, 0x59, 0x63, 0x95, 0x28, 0xe3, 0x6e, 0x35, 0xf2, 0xf7, 0x50, 0xc3, 0xb8, 0xa7, 0x1a,
-	0x07, 0xb0, 0x92, 0x29, 0x42, 0xcb, 0xb1, 0x0e, 0xa5, 0x1f, 0x24, 0xa2, 0xf5, 0xd0, 0xd6, 0x5d,
-	0x82, 0x6c, 0x7f, 0x07, 0xd5, 0xf9, 0xc7, 0x0b, 0xd7, 0xa0, 0x3c, 0xec, 0x7f, 0xde, 0x3f, 0x3a,
-	0xe9, 0x9b, 0x39, 0x5c, 0x85, 0xe2, 0x97, 0xc3, 0x1e, 0xf9, 0xda, 0x44, 0xb8, 0x02, 0x05, 0x32,
-	0x7c, 0xd6, 0x33, 0xf3, 0xc2, 0x63, 0x70, 0xb0, 0xd7, 0xdb, 0xed, 0x10, 0xd3, 0x10, 0x1e, 0x83,
-	0xe3, 0x23, 0xd2, 0x33, 0x0b, 0x02, 0x27, 0xbd, 0xdd, 0xde, 0xc1, 0xf3, 0x9e, 0x59, 0x14, 0xf8,
-	0x5e, 0xaf, 0x3b, 0xfc, 0xcc, 0x2c, 0x6d, 0xb7, 0xe0, 0xd1, 0x1b, 0xaa, 0x13, 0x87, 0x9e, 0x74,
-	0x88, 0x8e, 0xd4, 0xe9, 0x1e, 0x91, 0x63, 0x13, 0x6d, 0x77, 0xa1, 0x20, 0xa6, 0x1e, 0x97, 0xc1,
-	0x20, 0x9d, 0x13, 0xc5, 0xed, 0x1e, 0x0d, 0xfb, 0xc7, 0x26, 0x12, 0xd8, 0x60, 0x78, 0x68, 0xe6,
-	0xc5, 0xe2, 0xf0, 0xa0, 0x6f, 0x1a, 0x72, 0xd1, 0xf9, 0x4a, 0x85, 0x97, 0x5e, 0x3d, 0x62, 0x16,
-	0xdb, 0x3f, 0xe6, 0xa1, 0x28, 0x6b, 0xc2, 0xef, 0x41, 0x41, 0xbc, 0x12, 0x78, 0x25, 0x55, 0x7a,
-	0xe1, 0x0d, 0xa9, 0xaf, 0x66, 0x41, 0xad, 0xe1, 0xc7, 0x50, 0x52, 0xf3, 0x87, 0xd7, 0xb2, 0xf3,
-	0x98, 0x6e, 0x5b, 0x7f, 0x1d, 0x56, 0x1b, 0xdf, 0x45, 0x78, 0x17, 0xe0, 0xa6, 0x47, 0xf1, 0x46,
-	0xe6, 0x9b, 0xb9, 0x38, 0x65, 0xf5, 0xfa, 0x6d, 0x94, 0x8e, 0xff, 0x14, 0x6a, 0x0b, 0x57, 0x8b,
-	0xb3, 0xae, 0x99, 0xa6, 0xad, 0x3f, 0xbe, 0x95, 0x53, 0xe7, 0xb4, 0xfb, 0xb0, 0x2c, 0x5f, 0x6d,
-	0xd1, 0x8d, 0x4a, 0x8c, 0x4f, 0xa1, 0x46, 0x68, 0x10, 0x71, 0x2a, 0x71, 0x3c, 0x2f, 0x7f, 0xf1,
-	0x71, 0xaf, 0xaf, 0xbd, 0x86, 0xea, 0x9f, 0x80, 0x5c, 0xf7, 0xed, 0xcb, 0x7f, 0x1a, 0xb9, 0xcb,
-	0xab, 0x06, 0x7a, 0x75, 0xd5, 0x40, 0x7f, 0x5f, 0x35, 0xd0, 0x2f, 0xd7, 0x8d, 0xdc, 0xab, 0xeb,
-	0x46, 0xee, 0x8f, 0xeb, 0x46, 0xee, 0x9b, 0xb2, 0x7c, 0xf5, 0xe2, 0xf1, 0xb8, 0x24, 0xbf, 0x3b,
-	0xef, 0xff, 0x1b, 0x00, 0x00, 0xff, 0xff, 0x73, 0xae, 0x8b, 0x4e, 0xac, 0x08, 0x00, 0x00,
+	// 1381 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xc4, 0x57, 0x4b, 0x6f, 0x1b, 0xb7,
+	0x16, 0xd6, 0xe8, 0xad, 0x23, 0xdb, 0x77, 0x42, 0xdb, 0xf1, 0x58, 0x46, 0x6c, 0x61, 0x80, 0x0b,
+	0x08, 0xbe, 0xb9, 0x72, 0xaa, 0x36, 0x2d, 0x5a, 0x74, 0x23, 0xcb, 0x4a, 0x6d, 0x24, 0x96, 0x5d,
+	0x4a, 0x8a, 0xd3, 0x14, 0x85, 0x40, 0xc9, 0x8c, 0x3c, 0x8d, 0xe6, 0x91, 0x21, 0xd5, 0x44, 0x7f,
+	0xa2, 0xc8, 0xb6, 0xdb, 0xae, 0xfb, 0x2f, 0x0a, 0x14, 0x59, 0x66, 0xd9, 0x6c, 0x8a, 0x36, 0xf9,
+	0x13, 0x5d, 0x16, 0x7c, 0x8c, 0x1e, 0xb6, 0x6c, 0x24, 0xf0, 0xa2, 0x3b, 0x9e, 0xef, 0x1c, 0x9e,
+	0x37, 0xc9, 0x43, 0xc8, 0x85, 0x41, 0xaf, 0x1c, 0x84, 0x3e, 0xf7, 0x51, 0x9a, 0x9f, 0x11, 0xcf,
+	0x67, 0x85, 0x3c, 0x1f, 0x05, 0x94, 0x29, 0xb0, 0xb0, 0xd2, 0xf7, 0xfb, 0xbe, 0x5c, 0xee, 0x88,
+	0x95, 0x46, 0x51, 0x10, 0xfa, 0x6e, 0xd0, 0xdd, 0x99, 0x96, 0x5c, 0xef, 0xfb, 0x7e, 0x7f, 0x40,
+	0x77, 0x24, 0xd5, 0x1d, 0x3e, 0xd9, 0x21, 0xde, 0x48, 0xb1, 0xec, 0xff, 0xc0, 0xe2, 0x49, 0xe8,
+	0x70, 0x8a, 0x29, 0x0b, 0x7c, 0x8f, 0x51, 0xfb, 0x37, 0x03, 0x16, 0x34, 0xf2, 0x6c, 0x48, 0x19,
+	0x47, 0x55, 0x00, 0xee, 0xb8, 0x94, 0xd1, 0xd0, 0xa1, 0xcc, 0x32, 0x8a, 0x89, 0x52, 0xbe, 0xb2,
+	0x21, 0x76, 0xbb, 0x94, 0x9f, 0xd1, 0x21, 0xeb, 0xf4, 0xfc, 0x60, 0x54, 0x6e, 0x39, 0x2e, 0x6d,
+	0x4a, 0x91, 0xdd, 0xe4, 0xab, 0x3f, 0xb6, 0x62, 0x78, 0x6a, 0x13, 0xba, 0x09, 0x69, 0x4e, 0x3d,
+	0xe2, 0x71, 0x2b, 0x5e, 0x34, 0x4a, 0x39, 0xac, 0x29, 0x64, 0x41, 0x26, 0xa4, 0xc1, 0xc0, 0xe9,
+	0x11, 0x2b, 0x51, 0x34, 0x4a, 0x09, 0x1c, 0x91, 0xa8, 0x0a, 0x59, 0x97, 0x72, 0x72, 0x4a, 0x38,
+	0xb1, 0x92, 0xd2, 0xe4, 0xd6, 0x05, 0x93, 0x87, 0x94, 0x87, 0x4e, 0xef, 0x50, 0x8b, 0x69, 0xb3,
+	0xe3, 0x6d, 0xf6, 0x4b, 0x03, 0x90, 0x0c, 0xa4, 0xc9, 0x43, 0x4a, 0xdc, 0x28, 0x1c, 0x13, 0x12,
+	0x8c, 0x3e, 0xb3, 0x8c, 0xa2, 0x51, 0x4a, 0x62, 0xb1, 0x44, 0x77, 0x21, 0xdf, 0xf3, 0xdd, 0x20,
+	0xa4, 0x8c, 0x39, 0xbe, 0x27, 0x5d, 0x5c, 0xaa, 0x2c, 0x97, 0x55, 0xca, 0xcb, 0xb5, 0x09, 0x0b,
+	0x4f, 0xcb, 0x21, 0x04, 0x49, 0xe9, 0x9e, 0xf0, 0x7c, 0x01, 0xcb, 0x35, 0xba, 0xa5, 0x72, 0xe5,
+	0x0f, 0x79, 0xc7, 0x65, 0x56, 0x52, 0xc6, 0x94, 0xd3, 0xc8, 0x21, 0xb3, 0xdb, 0xb0, 0x3c, 0xe3,
+	0x91, 0x4a, 0xf9, 0x1c, 0x97, 0x10, 0x24, 0x7b, 0xfe, 0x29, 0x95, 0xbe, 0xa4, 0xb0, 0x5c, 0x8b,
+	0x64, 0xb9, 0x94, 0x31, 0xd2, 0xa7, 0xd2, 0x64, 0x0e, 0x47, 0xa4, 0xbd, 0x08, 0xf9, 0x03, 0xef,
+	0x89, 0xaf, 0x23, 0xb4, 0xdf, 0x18, 0xb0, 0xa0, 0x68, 0xad, 0xff, 0x7f, 0x90, 0x1e, 0x90, 0x2e,
+	0x1d, 0x44, 0xd5, 0x5b, 0x8c, 0x62, 0x7b, 0x20, 0x50, 0x9d, 0x38, 0x2d, 0x82, 0xd6, 0x21, 0xeb,
+	0x3a, 0x5e, 0x47, 0x38, 0x2d, 0xcd, 0x27, 0x70, 0xc6, 0x75, 0x3c, 0x51, 0x5e, 0xc9, 0x22, 0x2f,
+	0x14, 0x4b, 0xd7, 0xcb, 0x25, 0x2f, 0x24, 0x6b, 0x07, 0x72, 0x8c, 0xfb, 0x21, 0x6d, 0x8d, 0x02,
+	0x2a, 0xe3, 0x5e, 0xaa, 0xdc, 0x88, 0xac, 0x34, 0x23, 0x06, 0x9e, 0xc8, 0xa0, 0xbb, 0x00, 0xd2,
+	0x60, 0x87, 0x51, 0xce, 0xac, 0x94, 0xf4, 0xcb, 0x9c, 0xf1, 0xab, 0x49, 0xb9, 0x76, 0x2d, 0x37,
+	0xd0, 0x34, 0xb3, 0x3f, 0x83, 0x6c, 0xc4, 0xfc, 0xa0, 0xb0, 0xec, 0x9f, 0x12, 0xb0, 0xa8, 0xfa,
+	0x33, 0x6a, 0x84, 0xe9, 0x40, 0x8d, 0xcb, 0x03, 0x8d, 0xcf, 0x06, 0xfa, 0xa9, 0x60, 0xf1, 0xde,
+	0x19, 0x0d, 0x99, 0x95, 0x90, 0x66, 0x57, 0x66, 0xcc, 0x1e, 0x2a, 0xe6, 0xb8, 0x1b, 0xb5, 0x2c,
+	0xaa, 0xc0, 0xaa, 0x50, 0x19, 0x52, 0xe6, 0x0f, 0x86, 0xdc, 0xf1, 0xbd, 0xce, 0x73, 0xc7, 0x3b,
+	0xf5, 0x9f, 0xeb, 0x26, 0x59, 0x76, 0xc9, 0x0b, 0x3c, 0xe6, 0x9d, 0x48, 0x16, 0xba, 0x0d, 0x40,
+	0xfa, 0xfd, 0x90, 0xf6, 0x09, 0xa7, 0x2a, 0x47, 0x4b, 0x95, 0x85, 0xc8, 0x5a, 0xb5, 0xdf, 0x0f,
+	0xf1, 0x14, 0x1f, 0x7d, 0x01, 0xeb, 0x01, 0x09, 0xb9, 0x43, 0x06, 0x9d, 0x50, 0x57, 0xbe, 0x73,
+	0xea, 0x30, 0xd2, 0x1d, 0xd0, 0x53, 0x2b, 0x5d, 0x34, 0x4a, 0x59, 0xbc, 0xa6, 0x05, 0xa2, 0xce,
+	0xd8, 0xd3, 0x6c, 0xf4, 0xed, 0x9c, 0xbd, 0x8c, 0x87, 0x84, 0xd3, 0xfe, 0xc8, 0xca, 0xc8, 0x72,
+	0x6e, 0x45, 0x86, 0x8f, 0x67, 0x75, 0x34, 0xb5, 0xd8, 0x05, 0xe5, 0x11, 0x03, 0x6d, 0x41, 0x9e,
+	0x3d, 0x75, 0x82, 0x4e, 0xef, 0x6c, 0xe8, 0x3d, 0x65, 0x56, 0x56, 0xba, 0x02, 0x02, 0xaa, 0x49,
+	0xc4, 0xfe, 0xd1, 0x80, 0xa5, 0xa8, 0x36, 0xba, 0x65, 0x4b, 0x90, 0x1e, 0x5f, 0x38, 0x46, 0x29,
+	0x5f, 0x59, 0x1a, 0x37, 0x93, 0x44, 0xf7, 0x63, 0x58, 0xf3, 0x51, 0x01, 0x32, 0xcf, 0x49, 0xe8,
+	0x39, 0x5e, 0x5f, 0x5d, 0x2e, 0xfb, 0x31, 0x1c, 0x01, 0xe8, 0x36, 0xa4, 0xce, 0x1c, 0x8f, 0x33,
+	0xd9, 0xad, 0xa2, 0x52, 0xea, 0x1e, 0x2c, 0x47, 0xf7, 0x60, 0xb9, 0xea, 0x8d, 0xf6, 0x63, 0x58,
+	0x09, 0xed, 0x66, 0x21, 0x1d, 0x52, 0x36, 0x1c, 0x70, 0xfb, 0x17, 0x03, 0x6e, 0xc8, 0x6a, 0x36,
+	0x88, 0x3b, 0x69, 0x98, 0x2b, 0x13, 0x6c, 0x5c, 0x23, 0xc1, 0xf1, 0xeb, 0x25, 0xd8, 0xbe, 0x07,
+	0x68, 0xda, 0x5b, 0x9d, 0xc2, 0x15, 0x48, 0x79, 0x02, 0x90, 0xa7, 0x23, 0x87, 0x15, 0x81, 0x0a,
+	0x90, 0xd5, 0xd9, 0x61, 0x56, 0x5c, 0x32, 0xc6, 0xb4, 0xfd, 0xab, 0xa1, 0x15, 0x3d, 0x24, 0x83,
+	0xe1, 0x24, 0xee, 0x15, 0x48, 0xc9, 0x43, 0x24, 0x63, 0xcc, 0x61, 0x45, 0x5c, 0x9d, 0x8d, 0xf8,
+	0x35, 0xb2, 0x91, 0xb8, 0x66, 0x36, 0x0e, 0x60, 0x79, 0x26, 0x08, 0x9d, 0x8e, 0x9b, 0x90, 0xfe,
+	0x41, 0x22, 0x3a, 0x1f, 0x9a, 0xba, 0x32, 0x21, 0x6f, 0x0c, 0x58, 0xab, 0x91, 0xf0, 0xd4, 0xf1,
+	0xc8, 0xc0, 0xe1, 0xa3, 0x26, 0x27, 0xfc, 0x5f, 0xba, 0x3e, 0x44, 0x0d, 0x1c, 0xd7, 0xe1, 0xfa,
+	0xba, 0x50, 0xc4, 0xd5, 0x35, 0x48, 0x5d, 0x59, 0x03, 0x7b, 0x00, 0xd6, 0xc5, 0xd0, 0x74, 0xae,
+	0x3e, 0x81, 0x14, 0x13, 0x80, 0xbe, 0x58, 0xad, 0xf1, 0x5b, 0x78, 0x6e, 0x83, 0x76, 0x53, 0x09,
+	0x5f, 0x99, 0xc9, 0xbf, 0xe3, 0x60, 0x9e, 0xdf, 0xfd, 0x61, 0xef, 0x12, 0x81, 0x0d, 0x75, 0xe2,
+	0x3b, 0x3d, 0x7f, 0xe8, 0xf1, 0x4e, 0x77, 0xd4, 0x71, 0xe5, 0x00, 0xd0, 0x11, 0x8d, 0x2d, 0x0d,
+	0xe6, 0x2b, 0xb7, 0x2e, 0xf3, 0xb4, 0xee, 0xf1, 0x70, 0xa4, 0x35, 0xae, 0x29, 0x3d, 0x35, 0xa1,
+	0x66, 0x77, 0xa4, 0xa6, 0x08, 0x71, 0x74, 0xd0, 0x19, 0x6c, 0xa9, 0x37, 0x49, 0xb6, 0xc6, 0xc4,
+	0x8e, 0x02, 0xa5, 0x99, 0xc4, 0xfb, 0x9b, 0x29, 0x0c, 0xc6, 0x5d, 0xa8, 0x4d, 0x8d, 0x0f, 0x29,
+	0xfa, 0x1e, 0x8a, 0xe7, 0x83, 0x99, 0xb6, 0x1c, 0x10, 0x27, 0xb4, 0x92, 0xef, 0x6f, 0x6a, 0x63,
+	0x26, 0xa2, 0x49, 0xf7, 0x1f, 0x13, 0x27, 0xb4, 0x4f, 0x60, 0x75, 0xee, 0x5e, 0x31, 0x64, 0x78,
+	0x44, 0x77, 0x6f, 0x0e, 0xcb, 0xb5, 0xe8, 0x33, 0xe9, 0x82, 0x1e, 0xd4, 0x14, 0x21, 0x50, 0xe9,
+	0xa7, 0x3c, 0x9b, 0x49, 0xac, 0x88, 0xed, 0xff, 0x43, 0x7e, 0x6a, 0x38, 0x42, 0x59, 0x48, 0x36,
+	0x8e, 0x1a, 0x75, 0x33, 0x86, 0x00, 0xd2, 0xcd, 0x46, 0xf5, 0xf8, 0xf8, 0x1b, 0xd3, 0x10, 0xe8,
+	0xe3, 0x66, 0x6b, 0xcf, 0x8c, 0x6f, 0x7f, 0x07, 0xb9, 0xf1, 0x24, 0x80, 0xf2, 0x90, 0x69, 0x37,
+	0xee, 0x37, 0x8e, 0x4e, 0x1a, 0x66, 0x0c, 0xe5, 0x20, 0xf5, 0x75, 0xbb, 0x8e, 0xb5, 0x38, 0x6e,
+	0x3f, 0xa8, 0x9b, 0x71, 0x21, 0xd1, 0x3c, 0xd8, 0xab, 0xd7, 0xaa, 0xd8, 0x4c, 0x08, 0x89, 0x66,
+	0xeb, 0x08, 0xd7, 0xcd, 0xa4, 0xc0, 0x71, 0xbd, 0x56, 0x3f, 0x78, 0x58, 0x37, 0x53, 0x02, 0xdf,
+	0xab, 0xef, 0xb6, 0xbf, 0x32, 0xd3, 0xdb, 0x65, 0x58, 0xbb, 0xe4, 0xaa, 0x10, 0x4a, 0x4f, 0xaa,
+	0x58, 0x5b, 0xaa, 0xee, 0x1e, 0xe1, 0x96, 0x69, 0x6c, 0x3f, 0x82, 0xa4, 0x78, 0x42, 0x51, 0x06,
+	0x12, 0xb8, 0x7a, 0xa2, 0x78, 0xb5, 0xa3, 0x76, 0xa3, 0x65, 0x1a, 0x02, 0x6b, 0xb6, 0x0f, 0xcd,
+	0xb8, 0x58, 0x1c, 0x1e, 0x34, 0xcc, 0x84, 0x5c, 0x54, 0x1f, 0x29, 0xf3, 0x52, 0xaa, 0x8e, 0xcd,
+	0x94, 0x50, 0xfc, 0xa0, 0xda, 0x6c, 0x99, 0x69, 0x19, 0xf2, 0xfd, 0x7a, 0xab, 0xb6, 0x6f, 0x66,
+	0x2a, 0x6f, 0xe2, 0x90, 0x92, 0x91, 0xa2, 0x8f, 0x20, 0x29, 0x06, 0x31, 0x34, 0x1e, 0x26, 0xa7,
+	0xc6, 0xb4, 0xc2, 0xca, 0x2c, 0xa8, 0x8f, 0xde, 0xe7, 0x90, 0x56, 0x4f, 0x1c, 0x5a, 0x9d, 0x7d,
+	0xf2, 0xa2, 0x6d, 0x37, 0xcf, 0xc3, 0x6a, 0xe3, 0x1d, 0x03, 0xd5, 0x00, 0x26, 0xcf, 0x00, 0x5a,
+	0x9f, 0x39, 0x4c, 0xd3, 0x0f, 0x59, 0xa1, 0x30, 0x8f, 0xa5, 0xed, 0xdf, 0x83, 0xfc, 0xd4, 0xed,
+	0x89, 0x66, 0x45, 0x67, 0xde, 0x85, 0xc2, 0xc6, 0x5c, 0x9e, 0xd6, 0xd3, 0x9e, 0x73, 0xde, 0xb7,
+	0x2e, 0xeb, 0xe5, 0x48, 0x63, 0xf1, 0x72, 0x01, 0xa5, 0xb6, 0xf2, 0xb3, 0x01, 0x4b, 0x72, 0x84,
+	0x16, 0x97, 0x98, 0x4a, 0xf2, 0x97, 0x90, 0xc7, 0xd4, 0xf5, 0x39, 0x95, 0x38, 0x1a, 0xa7, 0x75,
+	0xfa, 0x13, 0x53, 0x58, 0x3d, 0x87, 0xea, 0xcf, 0x4e, 0x0c, 0x61, 0xb8, 0x31, 0xb5, 0x5b, 0x0d,
+	0xe6, 0x93, 0xa8, 0x2f, 0xfe, 0x1f, 0x0a, 0x1b, 0x73, 0x79, 0x91, 0xbe, 0x92, 0x71, 0xc7, 0xd8,
+	0xfd, 0xef, 0xab, 0xbf, 0x36, 0x63, 0xaf, 0xde, 0x6e, 0x1a, 0xaf, 0xdf, 0x6e, 0x1a, 0x7f, 0xbe,
+	0xdd, 0x34, 0x5e, 0xbe, 0xdb, 0x8c, 0xbd, 0x7e, 0xb7, 0x19, 0xfb, 0xfd, 0xdd, 0x66, 0xec, 0x71,
+	0x46, 0x0e, 0xc1, 0x41, 0xb7, 0x9b, 0x96, 0x63, 0xc8, 0xc7, 0xff, 0x0c, 0x00, 0x20, 0x0c, 0xc4,
+	0x8d, 0xe8, 0x0d, 0x00, 0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -744,6 +1063,9 @@ type StoreClient interface {
 	LabelNames(ctx context.Context, in *LabelNamesRequest, opts ...grpc.CallOption) (*LabelNamesResponse, error)
 	/// LabelValues returns all label values for given label name.
 	LabelValues(ctx context.Context, in *LabelValuesRequest, opts ...grpc.CallOption) (*LabelValuesResponse, error)
+	/// CardinalityStats returns estimated cardinality statistics for given label matchers and time range.
+	/// Statistics are computed from index metadata only (e.g postings lengths), so no series nor chunks are fetched.
+	CardinalityStats(ctx context.Context, in *CardinalityStatsRequest, opts ...grpc.CallOption) (*CardinalityStatsResponse, error)
 }
 
 type storeClient struct {
@@ -813,6 +1135,15 @@ func (c *storeClient) LabelValues(ctx context.Context, in *LabelValuesRequest, o
 	return out, nil
 }
 
+func (c *storeClient) CardinalityStats(ctx context.Context, in *CardinalityStatsRequest, opts ...grpc.CallOption) (*CardinalityStatsResponse, error) {
+	out := new(CardinalityStatsResponse)
+	err := c.cc.Invoke(ctx, "/thanos.Store/CardinalityStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // StoreServer is the server API for Store service.
 type StoreServer interface {
 	/// Info returns meta information about a store e.g labels that makes that store unique as well as time range that is
@@ -830,6 +1161,9 @@ type StoreServer interface {
 	LabelNames(context.Context, *LabelNamesRequest) (*LabelNamesResponse, error)
 	/// LabelValues returns all label values for given label name.
 	LabelValues(context.Context, *LabelValuesRequest) (*LabelValuesResponse, error)
+	/// CardinalityStats returns estimated cardinality statistics for given label matchers and time range.
+	/// Statistics are computed from index metadata only (e.g postings lengths), so no series nor chunks are fetched.
+	CardinalityStats(context.Context, *CardinalityStatsRequest) (*CardinalityStatsResponse, error)
 }
 
 // UnimplementedStoreServer can be embedded to have forward compatible implementations.
@@ -848,6 +1182,9 @@ func (*UnimplementedStoreServer) LabelNames(ctx context.Context, req *LabelNames
 func (*UnimplementedStoreServer) LabelValues(ctx context.Context, req *LabelValuesRequest) (*LabelValuesResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method LabelValues not implemented")
 }
+func (*UnimplementedStoreServer) CardinalityStats(ctx context.Context, req *CardinalityStatsRequest) (*CardinalityStatsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CardinalityStats not implemented")
+}
 
 func RegisterStoreServer(s *grpc.Server, srv StoreServer) {
 	s.RegisterService(&_Store_serviceDesc, srv)
@@ -928,6 +1265,24 @@ func _Store_LabelValues_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Store_CardinalityStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CardinalityStatsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StoreServer).CardinalityStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/thanos.Store/CardinalityStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StoreServer).CardinalityStats(ctx, req.(*CardinalityStatsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 var _Store_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "thanos.Store",
 	HandlerType: (*StoreServer)(nil),
@@ -944,6 +1299,10 @@ var _Store_serviceDesc = grpc.ServiceDesc{
 			MethodName: "LabelValues",
 			Handler:    _Store_LabelValues_Handler,
 		},
+		{
+			MethodName: "CardinalityStats",
+			Handler:    _Store_CardinalityStats_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -961,6 +1320,9 @@ var _Store_serviceDesc = grpc.ServiceDesc{
 type WriteableStoreClient interface {
 	// WriteRequest allows you to write metrics to this store via remote write
 	RemoteWrite(ctx context.Context, in *WriteRequest, opts ...grpc.CallOption) (*WriteResponse, error)
+	// RemoteWriteStream allows you to write metrics to this store over a stream of compressed write requests.
+	// Every request is acknowledged by a response with its sequence number once it is written.
+	RemoteWriteStream(ctx context.Context, opts ...grpc.CallOption) (WriteableStore_RemoteWriteStreamClient, error)
 }
 
 type writeableStoreClient struct {
@@ -980,10 +1342,44 @@ func (c *writeableStoreClient) RemoteWrite(ctx context.Context, in *WriteRequest
 	return out, nil
 }
 
+func (c *writeableStoreClient) RemoteWriteStream(ctx context.Context, opts ...grpc.CallOption) (WriteableStore_RemoteWriteStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_WriteableStore_serviceDesc.Streams[0], "/thanos.WriteableStore/RemoteWriteStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &writeableStoreRemoteWriteStreamClient{stream}
+	return x, nil
+}
+
+type WriteableStore_RemoteWriteStreamClient interface {
+	Send(*WriteStreamRequest) error
+	Recv() (*WriteStreamResponse, error)
+	grpc.ClientStream
+}
+
+type writeableStoreRemoteWriteStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *writeableStoreRemoteWriteStreamClient) Send(m *WriteStreamRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *writeableStoreRemoteWriteStreamClient) Recv() (*WriteStreamResponse, error) {
+	m := new(WriteStreamResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // WriteableStoreServer is the server API for WriteableStore service.
 type WriteableStoreServer interface {
 	// WriteRequest allows you to write metrics to this store via remote write
 	RemoteWrite(context.Context, *WriteRequest) (*WriteResponse, error)
+	// RemoteWriteStream allows you to write metrics to this store over a stream of compressed write requests.
+	// Every request is acknowledged by a response with its sequence number once it is written.
+	RemoteWriteStream(WriteableStore_RemoteWriteStreamServer) error
 }
 
 // UnimplementedWriteableStoreServer can be embedded to have forward compatible implementations.
@@ -993,6 +1389,9 @@ type UnimplementedWriteableStoreServer struct {
 func (*UnimplementedWriteableStoreServer) RemoteWrite(ctx context.Context, req *WriteRequest) (*WriteResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method RemoteWrite not implemented")
 }
+func (*UnimplementedWriteableStoreServer) RemoteWriteStream(srv WriteableStore_RemoteWriteStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method RemoteWriteStream not implemented")
+}
 
 func RegisterWriteableStoreServer(s *grpc.Server, srv WriteableStoreServer) {
 	s.RegisterService(&_WriteableStore_serviceDesc, srv)
@@ -1016,6 +1415,32 @@ func _WriteableStore_RemoteWrite_Handler(srv interface{}, ctx context.Context, d
 	return interceptor(ctx, in, info, handler)
 }
 
+func _WriteableStore_RemoteWriteStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(WriteableStoreServer).RemoteWriteStream(&writeableStoreRemoteWriteStreamServer{stream})
+}
+
+type WriteableStore_RemoteWriteStreamServer interface {
+	Send(*WriteStreamResponse) error
+	Recv() (*WriteStreamRequest, error)
+	grpc.ServerStream
+}
+
+type writeableStoreRemoteWriteStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *writeableStoreRemoteWriteStreamServer) Send(m *WriteStreamResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *writeableStoreRemoteWriteStreamServer) Recv() (*WriteStreamRequest, error) {
+	m := new(WriteStreamRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _WriteableStore_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "thanos.WriteableStore",
 	HandlerType: (*WriteableStoreServer)(nil),
@@ -1025,7 +1450,14 @@ var _WriteableStore_serviceDesc = grpc.ServiceDesc{
 			Handler:    _WriteableStore_RemoteWrite_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "RemoteWriteStream",
+			Handler:       _WriteableStore_RemoteWriteStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
 	Metadata: "rpc.proto",
 }
 
@@ -1072,6 +1504,20 @@ func (m *WriteRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	_ = i
 	var l int
 	_ = l
+	if len(m.Metadata) > 0 {
+		for iNdEx := len(m.Metadata) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Metadata[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintRpc(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x22
+		}
+	}
 	if m.Replica != 0 {
 		i = encodeVarintRpc(dAtA, i, uint64(m.Replica))
 		i--
@@ -1101,7 +1547,7 @@ func (m *WriteRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
-func (m *InfoRequest) Marshal() (dAtA []byte, err error) {
+func (m *WriteStreamRequest) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
 	n, err := m.MarshalToSizedBuffer(dAtA[:size])
@@ -1111,20 +1557,42 @@ func (m *InfoRequest) Marshal() (dAtA []byte, err error) {
 	return dAtA[:n], nil
 }
 
-func (m *InfoRequest) MarshalTo(dAtA []byte) (int, error) {
+func (m *WriteStreamRequest) MarshalTo(dAtA []byte) (int, error) {
 	size := m.Size()
 	return m.MarshalToSizedBuffer(dAtA[:size])
 }
 
-func (m *InfoRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+func (m *WriteStreamRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	i := len(dAtA)
 	_ = i
 	var l int
 	_ = l
+	if m.TimeoutMs != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.TimeoutMs))
+		i--
+		dAtA[i] = 0x20
+	}
+	if len(m.Data) > 0 {
+		i -= len(m.Data)
+		copy(dAtA[i:], m.Data)
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Data)))
+		i--
+		dAtA[i] = 0x1a
+	}
+	if m.Compression != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.Compression))
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.Seq != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.Seq))
+		i--
+		dAtA[i] = 0x8
+	}
 	return len(dAtA) - i, nil
 }
 
-func (m *InfoResponse) Marshal() (dAtA []byte, err error) {
+func (m *WriteStreamResponse) Marshal() (dAtA []byte, err error) {
 	size := m.Size()
 	dAtA = make([]byte, size)
 	n, err := m.MarshalToSizedBuffer(dAtA[:size])
@@ -1134,18 +1602,81 @@ func (m *InfoResponse) Marshal() (dAtA []byte, err error) {
 	return dAtA[:n], nil
 }
 
-func (m *InfoResponse) MarshalTo(dAtA []byte) (int, error) {
+func (m *WriteStreamResponse) MarshalTo(dAtA []byte) (int, error) {
 	size := m.Size()
 	return m.MarshalToSizedBuffer(dAtA[:size])
 }
 
-func (m *InfoResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+func (m *WriteStreamResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	i := len(dAtA)
 	_ = i
 	var l int
 	_ = l
-	if len(m.LabelSets) > 0 {
-		for iNdEx := len(m.LabelSets) - 1; iNdEx >= 0; iNdEx-- {
+	if len(m.Message) > 0 {
+		i -= len(m.Message)
+		copy(dAtA[i:], m.Message)
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Message)))
+		i--
+		dAtA[i] = 0x1a
+	}
+	if m.Code != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.Code))
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.Seq != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.Seq))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *InfoRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *InfoRequest) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *InfoRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	return len(dAtA) - i, nil
+}
+
+func (m *InfoResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *InfoResponse) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *InfoResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if len(m.LabelSets) > 0 {
+		for iNdEx := len(m.LabelSets) - 1; iNdEx >= 0; iNdEx-- {
 			{
 				size, err := m.LabelSets[iNdEx].MarshalToSizedBuffer(dAtA[:i])
 				if err != nil {
@@ -1575,6 +2106,235 @@ func (m *LabelValuesResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
 	return len(dAtA) - i, nil
 }
 
+func (m *CardinalityStatsRequest) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *CardinalityStatsRequest) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *CardinalityStatsRequest) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.PartialResponseDisabled {
+		i--
+		if m.PartialResponseDisabled {
+			dAtA[i] = 1
+		} else {
+			dAtA[i] = 0
+		}
+		i--
+		dAtA[i] = 0x28
+	}
+	if m.Limit != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.Limit))
+		i--
+		dAtA[i] = 0x20
+	}
+	if len(m.Matchers) > 0 {
+		for iNdEx := len(m.Matchers) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Matchers[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintRpc(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x1a
+		}
+	}
+	if m.MaxTime != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.MaxTime))
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.MinTime != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.MinTime))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *CardinalityStatsResponse) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *CardinalityStatsResponse) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *CardinalityStatsResponse) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if len(m.Warnings) > 0 {
+		for iNdEx := len(m.Warnings) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.Warnings[iNdEx])
+			copy(dAtA[i:], m.Warnings[iNdEx])
+			i = encodeVarintRpc(dAtA, i, uint64(len(m.Warnings[iNdEx])))
+			i--
+			dAtA[i] = 0x12
+		}
+	}
+	if len(m.Stats) > 0 {
+		for iNdEx := len(m.Stats) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Stats[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintRpc(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0xa
+		}
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *CardinalityStats) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *CardinalityStats) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *CardinalityStats) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if len(m.SeriesCountByLabelValuePair) > 0 {
+		for iNdEx := len(m.SeriesCountByLabelValuePair) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.SeriesCountByLabelValuePair[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintRpc(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x22
+		}
+	}
+	if len(m.LabelValueCountByLabelName) > 0 {
+		for iNdEx := len(m.LabelValueCountByLabelName) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.LabelValueCountByLabelName[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintRpc(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x1a
+		}
+	}
+	if len(m.SeriesCountByMetricName) > 0 {
+		for iNdEx := len(m.SeriesCountByMetricName) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.SeriesCountByMetricName[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintRpc(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x12
+		}
+	}
+	if len(m.Labels) > 0 {
+		for iNdEx := len(m.Labels) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.Labels[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintRpc(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0xa
+		}
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *CardinalityStatsEntry) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *CardinalityStatsEntry) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *CardinalityStatsEntry) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.Count != 0 {
+		i = encodeVarintRpc(dAtA, i, uint64(m.Count))
+		i--
+		dAtA[i] = 0x18
+	}
+	if len(m.Value) > 0 {
+		i -= len(m.Value)
+		copy(dAtA[i:], m.Value)
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Value)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if len(m.Name) > 0 {
+		i -= len(m.Name)
+		copy(dAtA[i:], m.Name)
+		i = encodeVarintRpc(dAtA, i, uint64(len(m.Name)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
 func encodeVarintRpc(dAtA []byte, offset int, v uint64) int {
 	offset -= sovRpc(v)
 	base := offset
@@ -1614,18 +2374,65 @@ func (m *WriteRequest) Size() (n int) {
 	if m.Replica != 0 {
 		n += 1 + sovRpc(uint64(m.Replica))
 	}
+	if len(m.Metadata) > 0 {
+		for _, e := range m.Metadata {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
 	return n
 }
 
-func (m *InfoRequest) Size() (n int) {
+func (m *WriteStreamRequest) Size() (n int) {
 	if m == nil {
 		return 0
 	}
 	var l int
 	_ = l
-	return n
-}
-
+	if m.Seq != 0 {
+		n += 1 + sovRpc(uint64(m.Seq))
+	}
+	if m.Compression != 0 {
+		n += 1 + sovRpc(uint64(m.Compression))
+	}
+	l = len(m.Data)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	if m.TimeoutMs != 0 {
+		n += 1 + sovRpc(uint64(m.TimeoutMs))
+	}
+	return n
+}
+
+func (m *WriteStreamResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.Seq != 0 {
+		n += 1 + sovRpc(uint64(m.Seq))
+	}
+	if m.Code != 0 {
+		n += 1 + sovRpc(uint64(m.Code))
+	}
+	l = len(m.Message)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	return n
+}
+
+func (m *InfoRequest) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	return n
+}
+
 func (m *InfoResponse) Size() (n int) {
 	if m == nil {
 		return 0
@@ -1833,6 +2640,107 @@ func (m *LabelValuesResponse) Size() (n int) {
 	return n
 }
 
+func (m *CardinalityStatsRequest) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.MinTime != 0 {
+		n += 1 + sovRpc(uint64(m.MinTime))
+	}
+	if m.MaxTime != 0 {
+		n += 1 + sovRpc(uint64(m.MaxTime))
+	}
+	if len(m.Matchers) > 0 {
+		for _, e := range m.Matchers {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if m.Limit != 0 {
+		n += 1 + sovRpc(uint64(m.Limit))
+	}
+	if m.PartialResponseDisabled {
+		n += 2
+	}
+	return n
+}
+
+func (m *CardinalityStatsResponse) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if len(m.Stats) > 0 {
+		for _, e := range m.Stats {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if len(m.Warnings) > 0 {
+		for _, s := range m.Warnings {
+			l = len(s)
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *CardinalityStats) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if len(m.Labels) > 0 {
+		for _, e := range m.Labels {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if len(m.SeriesCountByMetricName) > 0 {
+		for _, e := range m.SeriesCountByMetricName {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if len(m.LabelValueCountByLabelName) > 0 {
+		for _, e := range m.LabelValueCountByLabelName {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	if len(m.SeriesCountByLabelValuePair) > 0 {
+		for _, e := range m.SeriesCountByLabelValuePair {
+			l = e.Size()
+			n += 1 + l + sovRpc(uint64(l))
+		}
+	}
+	return n
+}
+
+func (m *CardinalityStatsEntry) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Name)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	l = len(m.Value)
+	if l > 0 {
+		n += 1 + l + sovRpc(uint64(l))
+	}
+	if m.Count != 0 {
+		n += 1 + sovRpc(uint64(m.Count))
+	}
+	return n
+}
+
 func sovRpc(x uint64) (n int) {
 	return (math_bits.Len64(x|1) + 6) / 7
 }
@@ -2006,6 +2914,40 @@ func (m *WriteRequest) Unmarshal(dAtA []byte) error {
 					break
 				}
 			}
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Metadata", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Metadata = append(m.Metadata, prompb.MetricMetadata{})
+			if err := m.Metadata[len(m.Metadata)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -2030,7 +2972,7 @@ func (m *WriteRequest) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *InfoRequest) Unmarshal(dAtA []byte) error {
+func (m *WriteStreamRequest) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -2053,12 +2995,103 @@ func (m *InfoRequest) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: InfoRequest: wiretype end group for non-group")
+			return fmt.Errorf("proto: WriteStreamRequest: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: InfoRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: WriteStreamRequest: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Seq", wireType)
+			}
+			m.Seq = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Seq |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Compression", wireType)
+			}
+			m.Compression = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Compression |= Compression(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Data", wireType)
+			}
+			var byteLen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				byteLen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if byteLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + byteLen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Data = append(m.Data[:0], dAtA[iNdEx:postIndex]...)
+			if m.Data == nil {
+				m.Data = []byte{}
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TimeoutMs", wireType)
+			}
+			m.TimeoutMs = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.TimeoutMs |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
 		default:
 			iNdEx = preIndex
 			skippy, err := skipRpc(dAtA[iNdEx:])
@@ -2083,7 +3116,7 @@ func (m *InfoRequest) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
-func (m *InfoResponse) Unmarshal(dAtA []byte) error {
+func (m *WriteStreamResponse) Unmarshal(dAtA []byte) error {
 	l := len(dAtA)
 	iNdEx := 0
 	for iNdEx < l {
@@ -2106,17 +3139,17 @@ func (m *InfoResponse) Unmarshal(dAtA []byte) error {
 		fieldNum := int32(wire >> 3)
 		wireType := int(wire & 0x7)
 		if wireType == 4 {
-			return fmt.Errorf("proto: InfoResponse: wiretype end group for non-group")
+			return fmt.Errorf("proto: WriteStreamResponse: wiretype end group for non-group")
 		}
 		if fieldNum <= 0 {
-			return fmt.Errorf("proto: InfoResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+			return fmt.Errorf("proto: WriteStreamResponse: illegal tag %d (wire type %d)", fieldNum, wire)
 		}
 		switch fieldNum {
 		case 1:
-			if wireType != 2 {
-				return fmt.Errorf("proto: wrong wireType = %d for field Labels", wireType)
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Seq", wireType)
 			}
-			var msglen int
+			m.Seq = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -2126,31 +3159,16 @@ func (m *InfoResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				msglen |= int(b&0x7F) << shift
+				m.Seq |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-			if msglen < 0 {
-				return ErrInvalidLengthRpc
-			}
-			postIndex := iNdEx + msglen
-			if postIndex < 0 {
-				return ErrInvalidLengthRpc
-			}
-			if postIndex > l {
-				return io.ErrUnexpectedEOF
-			}
-			m.Labels = append(m.Labels, Label{})
-			if err := m.Labels[len(m.Labels)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
-				return err
-			}
-			iNdEx = postIndex
 		case 2:
 			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field MinTime", wireType)
+				return fmt.Errorf("proto: wrong wireType = %d for field Code", wireType)
 			}
-			m.MinTime = 0
+			m.Code = 0
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -2160,16 +3178,16 @@ func (m *InfoResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.MinTime |= int64(b&0x7F) << shift
+				m.Code |= int32(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
 		case 3:
-			if wireType != 0 {
-				return fmt.Errorf("proto: wrong wireType = %d for field MaxTime", wireType)
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Message", wireType)
 			}
-			m.MaxTime = 0
+			var stringLen uint64
 			for shift := uint(0); ; shift += 7 {
 				if shift >= 64 {
 					return ErrIntOverflowRpc
@@ -2179,12 +3197,203 @@ func (m *InfoResponse) Unmarshal(dAtA []byte) error {
 				}
 				b := dAtA[iNdEx]
 				iNdEx++
-				m.MaxTime |= int64(b&0x7F) << shift
+				stringLen |= uint64(b&0x7F) << shift
 				if b < 0x80 {
 					break
 				}
 			}
-		case 4:
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Message = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *InfoRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: InfoRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: InfoRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *InfoResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: InfoResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: InfoResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Labels", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Labels = append(m.Labels, Label{})
+			if err := m.Labels[len(m.Labels)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MinTime", wireType)
+			}
+			m.MinTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MinTime |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxTime", wireType)
+			}
+			m.MaxTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxTime |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
 			if wireType != 0 {
 				return fmt.Errorf("proto: wrong wireType = %d for field StoreType", wireType)
 			}
@@ -3225,6 +4434,614 @@ func (m *LabelValuesResponse) Unmarshal(dAtA []byte) error {
 	}
 	return nil
 }
+func (m *CardinalityStatsRequest) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: CardinalityStatsRequest: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: CardinalityStatsRequest: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MinTime", wireType)
+			}
+			m.MinTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MinTime |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxTime", wireType)
+			}
+			m.MaxTime = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxTime |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Matchers", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Matchers = append(m.Matchers, LabelMatcher{})
+			if err := m.Matchers[len(m.Matchers)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Limit", wireType)
+			}
+			m.Limit = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Limit |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field PartialResponseDisabled", wireType)
+			}
+			var v int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				v |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			m.PartialResponseDisabled = bool(v != 0)
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *CardinalityStatsResponse) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: CardinalityStatsResponse: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: CardinalityStatsResponse: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Stats", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Stats = append(m.Stats, CardinalityStats{})
+			if err := m.Stats[len(m.Stats)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Warnings", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Warnings = append(m.Warnings, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *CardinalityStats) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: CardinalityStats: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: CardinalityStats: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Labels", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Labels = append(m.Labels, Label{})
+			if err := m.Labels[len(m.Labels)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SeriesCountByMetricName", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.SeriesCountByMetricName = append(m.SeriesCountByMetricName, CardinalityStatsEntry{})
+			if err := m.SeriesCountByMetricName[len(m.SeriesCountByMetricName)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field LabelValueCountByLabelName", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.LabelValueCountByLabelName = append(m.LabelValueCountByLabelName, CardinalityStatsEntry{})
+			if err := m.LabelValueCountByLabelName[len(m.LabelValueCountByLabelName)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field SeriesCountByLabelValuePair", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.SeriesCountByLabelValuePair = append(m.SeriesCountByLabelValuePair, CardinalityStatsEntry{})
+			if err := m.SeriesCountByLabelValuePair[len(m.SeriesCountByLabelValuePair)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *CardinalityStatsEntry) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowRpc
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: CardinalityStatsEntry: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: CardinalityStatsEntry: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Name = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Value", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthRpc
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Value = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Count", wireType)
+			}
+			m.Count = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowRpc
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Count |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipRpc(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if skippy < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) < 0 {
+				return ErrInvalidLengthRpc
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
 func skipRpc(dAtA []byte) (n int, err error) {
 	l := len(dAtA)
 	iNdEx := 0
@@ -242,3 +242,39 @@ func (s *TSDBStore) LabelValues(ctx context.Context, r *storepb.LabelValuesReque
 	}
 	return &storepb.LabelValuesResponse{Values: res}, nil
 }
+
+// CardinalityStats returns cardinality statistics of the head block. Persisted blocks are not considered,
+// as they are expected to be shipped and served from the object storage.
+func (s *TSDBStore) CardinalityStats(_ context.Context, r *storepb.CardinalityStatsRequest) (*storepb.CardinalityStatsResponse, error) {
+	match, newMatchers, err := matchesExternalLabels(r.Matchers, s.externalLabels)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	head := s.db.Head()
+	if !match || r.MinTime > head.MaxTime() || r.MaxTime < head.MinTime() {
+		return &storepb.CardinalityStatsResponse{}, nil
+	}
+
+	matchers, err := translateMatchers(newMatchers)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	indexr, err := head.Index()
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	defer runutil.CloseWithLogOnErr(s.logger, indexr, "close tsdb head index reader")
+
+	builder := newCardinalityStatsBuilder()
+	if err := builder.addIndex(tsdbCardinalityReader{r: indexr}, matchers); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+
+	resp := &storepb.CardinalityStatsResponse{}
+	if len(builder.seriesCount) > 0 {
+		resp.Stats = append(resp.Stats, builder.build(s.externalLabels, int(r.Limit)))
+	}
+	return resp, nil
+}
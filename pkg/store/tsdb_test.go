@@ -276,6 +276,85 @@ func TestTSDBStore_LabelValues(t *testing.T) {
 	}
 }
 
+func TestTSDBStore_CardinalityStats(t *testing.T) {
+	defer leaktest.CheckTimeout(t, 10*time.Second)()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	db, err := e2eutil.NewTSDB()
+	defer func() { testutil.Ok(t, db.Close()) }()
+	testutil.Ok(t, err)
+
+	tsdbStore := NewTSDBStore(nil, nil, db, component.Receive, labels.FromStrings("region", "eu-west"))
+
+	// Empty head.
+	resp, err := tsdbStore.CardinalityStats(ctx, &storepb.CardinalityStatsRequest{MinTime: math.MinInt64, MaxTime: math.MaxInt64})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(resp.Stats))
+
+	appender := db.Appender()
+	for _, lset := range []labels.Labels{
+		labels.FromStrings("__name__", "up", "job", "a"),
+		labels.FromStrings("__name__", "up", "job", "b"),
+		labels.FromStrings("__name__", "build_info", "job", "a"),
+	} {
+		_, err := appender.Add(lset, 10, 1)
+		testutil.Ok(t, err)
+	}
+	testutil.Ok(t, appender.Commit())
+
+	resp, err = tsdbStore.CardinalityStats(ctx, &storepb.CardinalityStatsRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "region", Value: "eu-west"}},
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []storepb.CardinalityStats{{
+		Labels:                     []storepb.Label{{Name: "region", Value: "eu-west"}},
+		SeriesCountByMetricName:    []storepb.CardinalityStatsEntry{{Name: "up", Count: 2}, {Name: "build_info", Count: 1}},
+		LabelValueCountByLabelName: []storepb.CardinalityStatsEntry{{Name: "__name__", Count: 2}, {Name: "job", Count: 2}},
+		SeriesCountByLabelValuePair: []storepb.CardinalityStatsEntry{
+			{Name: "__name__", Value: "up", Count: 2},
+			{Name: "job", Value: "a", Count: 2},
+			{Name: "__name__", Value: "build_info", Count: 1},
+			{Name: "job", Value: "b", Count: 1},
+		},
+	}}, resp.Stats)
+
+	// Statistics are counted over the series matching the selector only.
+	resp, err = tsdbStore.CardinalityStats(ctx, &storepb.CardinalityStatsRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "job", Value: "a"}},
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, []storepb.CardinalityStats{{
+		Labels:                     []storepb.Label{{Name: "region", Value: "eu-west"}},
+		SeriesCountByMetricName:    []storepb.CardinalityStatsEntry{{Name: "build_info", Count: 1}, {Name: "up", Count: 1}},
+		LabelValueCountByLabelName: []storepb.CardinalityStatsEntry{{Name: "__name__", Count: 2}, {Name: "job", Count: 1}},
+		SeriesCountByLabelValuePair: []storepb.CardinalityStatsEntry{
+			{Name: "job", Value: "a", Count: 2},
+			{Name: "__name__", Value: "build_info", Count: 1},
+			{Name: "__name__", Value: "up", Count: 1},
+		},
+	}}, resp.Stats)
+
+	// Not matching external labels.
+	resp, err = tsdbStore.CardinalityStats(ctx, &storepb.CardinalityStatsRequest{
+		MinTime:  0,
+		MaxTime:  100,
+		Matchers: []storepb.LabelMatcher{{Type: storepb.LabelMatcher_EQ, Name: "region", Value: "us-east"}},
+	})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(resp.Stats))
+
+	// Time range not covered by the head.
+	resp, err = tsdbStore.CardinalityStats(ctx, &storepb.CardinalityStatsRequest{MinTime: 200, MaxTime: 300})
+	testutil.Ok(t, err)
+	testutil.Equals(t, 0, len(resp.Stats))
+}
+
 // Regression test for https://github.com/thanos-io/thanos/issues/1038.
 func TestTSDBStore_Series_SplitSamplesIntoChunksWithMaxSizeOfUint16_e2e(t *testing.T) {
 	defer leaktest.CheckTimeout(t, 10*time.Second)()
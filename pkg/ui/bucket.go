@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/oklog/ulid"
 	"github.com/prometheus/common/route"
 	"github.com/thanos-io/thanos/pkg/block/metadata"
 	extpromhttp "github.com/thanos-io/thanos/pkg/extprom/http"
@@ -22,16 +23,19 @@ type Bucket struct {
 	externalPrefix, prefixHeader string
 	// Unique Prometheus label that identifies each shard, used as the title. If
 	// not present, all labels are displayed externally as a legend.
-	Label       string
-	Blocks      template.JS
-	RefreshedAt time.Time
-	Err         error
+	Label  string
+	Blocks template.JS
+	// No-serve marks of quarantined blocks, by block ULID.
+	NoServeMarks template.JS
+	RefreshedAt  time.Time
+	Err          error
 }
 
 func NewBucketUI(logger log.Logger, label, externalPrefix, prefixHeader string) *Bucket {
 	return &Bucket{
 		BaseUI:         NewBaseUI(log.With(logger, "component", "bucketUI"), "bucket_menu.html", queryTmplFuncs()),
 		Blocks:         "[]",
+		NoServeMarks:   "{}",
 		Label:          label,
 		externalPrefix: externalPrefix,
 		prefixHeader:   prefixHeader,
@@ -70,3 +74,13 @@ func (b *Bucket) Set(blocks []metadata.Meta, err error) {
 	b.Blocks = template.JS(data)
 	b.Err = err
 }
+
+// SetNoServeMarks sets the no-serve marks of the quarantined blocks, so they are highlighted in the UI.
+func (b *Bucket) SetNoServeMarks(marks map[ulid.ULID]*metadata.NoServeMark) {
+	data := "{}"
+	dataB, err := json.Marshal(marks)
+	if err == nil {
+		data = string(dataB)
+	}
+	b.NoServeMarks = template.JS(data)
+}
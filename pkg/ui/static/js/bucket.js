@@ -55,6 +55,9 @@ function draw() {
                 }();
 
                 label = `level: ${d.compaction.level}, resolution: ${d.thanos.downsample.resolution}`;
+                if (thanos.noServeMarks[d.ulid]) {
+                    label += ", no-serve";
+                }
                 return [title, label, generateTooltip(d), new Date(d.minTime), new Date(d.maxTime)];
             }));
 
@@ -122,6 +125,12 @@ function generateTooltip(block) {
     compactInfo.innerHTML += generateLine("Level: ", block.compaction.level);
     compactInfo.innerHTML += generateLine("Source: ", block.thanos.source);
 
+    var noServeMark = thanos.noServeMarks[block.ulid];
+    if (noServeMark) {
+        var noServeTime = new Date(noServeMark.no_serve_time * 1000);
+        compactInfo.innerHTML += generateLine("No-serve: ", noServeMark.reason + " since " + noServeTime.toLocaleDateString() + " " + noServeTime.toLocaleTimeString());
+    }
+
     info.appendChild(metaInfo);
     info.appendChild(dateInfo);
     info.appendChild(statsInfo);
@@ -56,6 +56,14 @@ var (
 	indexCacheConfigs = map[storecache.IndexCacheProvider]interface{}{
 		storecache.INMEMORY:  storecache.InMemoryIndexCacheConfig{},
 		storecache.MEMCACHED: cacheutil.MemcachedClientConfig{},
+		storecache.REDIS:     cacheutil.RedisClientConfig{},
+		storecache.DISK:      storecache.DiskIndexCacheConfig{},
+		storecache.TIERED: storecache.TieredIndexCacheConfig{
+			Tiers: []storecache.IndexCacheConfig{
+				{Type: storecache.INMEMORY, Config: storecache.InMemoryIndexCacheConfig{}},
+				{Type: storecache.DISK, Config: storecache.DiskIndexCacheConfig{}},
+			},
+		},
 	}
 )
 
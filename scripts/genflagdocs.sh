@@ -46,7 +46,7 @@ for x in "${toolsCommands[@]}"; do
     ./thanos tools "${x}" --help &> "docs/components/flags/tools_${x}.txt"
 done
 
-toolsBucketCommands=("verify" "ls" "inspect" "web" "replicate" "downsample")
+toolsBucketCommands=("verify" "ls" "inspect" "web" "replicate" "downsample" "mark")
 for x in "${toolsBucketCommands[@]}"; do
     ./thanos tools bucket "${x}" --help &> "docs/components/flags/tools_bucket_${x}.txt"
 done